@@ -0,0 +1,75 @@
+// Command check-aof validates an append-only file and can repair it by
+// truncating it at the first bad record, like redis-check-aof. Given a
+// manifest or the directory holding one, it checks every segment in replay
+// order. Encrypted segments are read with the key in REDIS_ENCRYPTION_KEY.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"redis-from-scratch/internal/persistence"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file at the first bad record")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-fix] <file.aof|manifest|dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	enc, err := persistence.EncryptionFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot check %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+	files, err := persistence.AOFFiles(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot check %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+	for i, path := range files {
+		check, err := persistence.CheckAOF(path, enc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot check %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, commands=%d, diff=%d\n",
+			path, check.Size, check.ValidTo, check.Commands, check.Size-check.ValidTo)
+		if !check.OK() {
+			repair(path, check, files[i+1:], enc, *fix)
+			return
+		}
+	}
+	fmt.Println("AOF is valid")
+}
+
+// repair reports the problem found in path and, if fix is set, truncates the
+// file. Only the last segment with data can be repaired that way; later
+// segments would otherwise replay on top of a gap.
+func repair(path string, check persistence.AOFCheck, later []string, enc *persistence.Encryption, fix bool) {
+	fmt.Printf("First problem at offset %d: %v\n", check.ValidTo, check.Err)
+	for _, next := range later {
+		if c, err := persistence.CheckAOF(next, enc); err != nil || c.Commands > 0 || !c.OK() {
+			fmt.Printf("%s is followed by segments with data and cannot be fixed by truncation.\n", path)
+			os.Exit(1)
+		}
+	}
+	if !fix {
+		fmt.Println("AOF is not valid. Use the -fix option to try fixing it.")
+		os.Exit(1)
+	}
+	fmt.Printf("This will shrink the AOF from %d bytes to %d bytes, discarding %d bytes.\n",
+		check.Size, check.ValidTo, check.Size-check.ValidTo)
+	if err := persistence.RepairAOF(path, check.ValidTo); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to repair %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	fmt.Println("Successfully truncated AOF")
+}
@@ -0,0 +1,276 @@
+// Command migrate copies the keys of a running Redis into this server. It
+// walks the source with SCAN and, for each key, reads its type, TTL and
+// value with standard commands (GET, HGETALL, LRANGE, SMEMBERS, ZRANGE
+// WITHSCORES) and writes it to the target with SET, HSET, RPUSH, SADD and
+// ZADD, keeping the expiry as an absolute deadline. Keys are copied by a
+// pool of workers, each with its own connections, and progress is reported
+// as it goes.
+//
+// The source is not paused: a key written while it is being copied may be
+// copied in either state. Stop writes to the source for an exact copy.
+// Streams and module types are skipped.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redis-from-scratch/internal/protocol"
+)
+
+// batchSize bounds the elements sent in one write command, so that huge
+// collections do not produce huge commands.
+const batchSize = 512
+
+type options struct {
+	from, to    string
+	password    string
+	db          int
+	match       string
+	count       int
+	workers     int
+	replace     bool
+	progress    time.Duration
+	dialTimeout time.Duration
+}
+
+// stats counts what happened to the keys SCAN returned.
+type stats struct {
+	scanned  atomic.Int64
+	migrated atomic.Int64
+	skipped  atomic.Int64 // unsupported types, or keys gone before being read
+	failed   atomic.Int64
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.from, "from", "localhost:6379", "source Redis address")
+	flag.StringVar(&opts.to, "to", "localhost:6378", "target server address")
+	flag.StringVar(&opts.password, "from-password", "", "password to AUTH with at the source")
+	flag.IntVar(&opts.db, "from-db", 0, "source database to SELECT")
+	flag.StringVar(&opts.match, "match", "*", "only copy keys matching this pattern")
+	flag.IntVar(&opts.count, "count", 1000, "SCAN COUNT hint")
+	flag.IntVar(&opts.workers, "workers", 8, "keys copied concurrently")
+	flag.BoolVar(&opts.replace, "replace", true, "delete keys that already exist on the target before copying")
+	flag.DurationVar(&opts.progress, "progress", 2*time.Second, "how often to report progress")
+	flag.Parse()
+	opts.dialTimeout = 5 * time.Second
+	if opts.workers < 1 {
+		opts.workers = 1
+	}
+
+	start := time.Now()
+	st, err := migrate(opts)
+	log.Printf("Scanned %d keys in %v: %d migrated, %d skipped, %d failed",
+		st.scanned.Load(), time.Since(start).Round(time.Millisecond), st.migrated.Load(), st.skipped.Load(), st.failed.Load())
+	if err != nil {
+		log.Printf("Migration stopped: %v", err)
+		os.Exit(1)
+	}
+	if st.failed.Load() > 0 {
+		os.Exit(1)
+	}
+}
+
+// migrate scans the source and feeds the keys to the workers.
+func migrate(opts options) (*stats, error) {
+	st := &stats{}
+	scanner, err := dialSource(opts)
+	if err != nil {
+		return st, err
+	}
+	defer scanner.Close()
+
+	// Open every connection up front, so a wrong address fails fast.
+	type conns struct{ src, dst *protocol.Client }
+	pool := make([]conns, opts.workers)
+	for i := range pool {
+		if pool[i].src, err = dialSource(opts); err == nil {
+			pool[i].dst, err = protocol.Dial(opts.to, opts.dialTimeout)
+		}
+		if err != nil {
+			return st, err
+		}
+		defer pool[i].src.Close()
+		defer pool[i].dst.Close()
+	}
+
+	keys := make(chan string, opts.count)
+	var wg sync.WaitGroup
+	for _, c := range pool {
+		wg.Add(1)
+		go func(src, dst *protocol.Client) {
+			defer wg.Done()
+			for key := range keys {
+				copied, err := copyKey(src, dst, key, opts.replace)
+				switch {
+				case err != nil:
+					st.failed.Add(1)
+					log.Printf("Failed to migrate %q: %v", key, err)
+				case copied:
+					st.migrated.Add(1)
+				default:
+					st.skipped.Add(1)
+				}
+			}
+		}(c.src, c.dst)
+	}
+
+	done := make(chan struct{})
+	go reportProgress(st, opts.progress, done)
+	defer close(done)
+
+	cursor := "0"
+	for {
+		r, err := scanner.Do("SCAN", cursor, "MATCH", opts.match, "COUNT", strconv.Itoa(opts.count))
+		if err == nil && len(r.Array) != 2 {
+			err = fmt.Errorf("unexpected SCAN reply")
+		}
+		if err != nil {
+			close(keys)
+			wg.Wait()
+			return st, fmt.Errorf("SCAN failed: %w", err)
+		}
+		for _, key := range r.Array[1].Strings() {
+			st.scanned.Add(1)
+			keys <- key
+		}
+		if cursor = r.Array[0].Str; cursor == "0" {
+			break
+		}
+	}
+	close(keys)
+	wg.Wait()
+	return st, nil
+}
+
+func dialSource(opts options) (*protocol.Client, error) {
+	c, err := protocol.Dial(opts.from, opts.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if opts.password != "" {
+		if _, err := c.Do("AUTH", opts.password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("AUTH failed: %w", err)
+		}
+	}
+	if opts.db != 0 {
+		if _, err := c.Do("SELECT", strconv.Itoa(opts.db)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("SELECT failed: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func reportProgress(st *stats, every time.Duration, done <-chan struct{}) {
+	if every <= 0 {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	var last int64
+	for {
+		select {
+		case <-ticker.C:
+			n := st.migrated.Load()
+			log.Printf("Migrated %d of %d keys scanned so far (%.0f keys/s), %d skipped, %d failed",
+				n, st.scanned.Load(), float64(n-last)/every.Seconds(), st.skipped.Load(), st.failed.Load())
+			last = n
+		case <-done:
+			return
+		}
+	}
+}
+
+// copyKey copies one key from src to dst. It reports false, without an
+// error, for a key that is of an unsupported type or no longer exists.
+func copyKey(src, dst *protocol.Client, key string, replace bool) (bool, error) {
+	r, err := src.Do("TYPE", key)
+	if err != nil {
+		return false, err
+	}
+	typ := r.Str
+	if r, err = src.Do("PTTL", key); err != nil {
+		return false, err
+	}
+	pttl := r.Int
+	if pttl == -2 {
+		return false, nil
+	}
+
+	var writes [][]string
+	switch typ {
+	case "string":
+		r, err = src.Do("GET", key)
+		if err != nil || r.Null {
+			return false, err
+		}
+		writes = [][]string{{"SET", key, r.Str}}
+	case "hash":
+		r, err = src.Do("HGETALL", key)
+		writes = batches("HSET", key, r.Strings(), 2)
+	case "list":
+		r, err = src.Do("LRANGE", key, "0", "-1")
+		writes = batches("RPUSH", key, r.Strings(), 1)
+	case "set":
+		r, err = src.Do("SMEMBERS", key)
+		writes = batches("SADD", key, r.Strings(), 1)
+	case "zset":
+		r, err = src.Do("ZRANGE", key, "0", "-1", "WITHSCORES")
+		pairs := r.Strings()
+		// ZRANGE returns member, score; ZADD wants score, member.
+		for i := 0; i+1 < len(pairs); i += 2 {
+			pairs[i], pairs[i+1] = pairs[i+1], pairs[i]
+		}
+		writes = batches("ZADD", key, pairs, 2)
+	case "none":
+		return false, nil
+	default:
+		log.Printf("Skipping %q: type %s is not supported", key, typ)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(writes) == 0 {
+		return false, nil // emptied meanwhile
+	}
+
+	if replace {
+		if _, err := dst.Do("DEL", key); err != nil {
+			return false, err
+		}
+	}
+	for _, w := range writes {
+		if _, err := dst.Do(w...); err != nil {
+			return false, err
+		}
+	}
+	if pttl > 0 {
+		deadline := time.Now().Add(time.Duration(pttl) * time.Millisecond).UnixMilli()
+		if _, err := dst.Do("PEXPIREAT", key, strconv.FormatInt(deadline, 10)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// batches splits items, in groups of size elements, into write commands of
+// at most batchSize groups each.
+func batches(cmd, key string, items []string, size int) [][]string {
+	var out [][]string
+	for len(items) > 0 {
+		n := min(len(items), batchSize*size)
+		out = append(out, append([]string{cmd, key}, items[:n]...))
+		items = items[n:]
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+// Command sentinel monitors a master and its replicas and fails over to a
+// replica when a quorum of sentinels agrees the master is down; see package
+// sentinel. Run three or more, each given at least one other's address:
+//
+//	sentinel -port 26379 -master 127.0.0.1:6378 -quorum 2 -sentinels 127.0.0.1:26380
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"redis-from-scratch/internal/sentinel"
+)
+
+func main() {
+	cfg := sentinel.DefaultConfig()
+	var peers string
+	flag.IntVar(&cfg.Port, "port", cfg.Port, "port to listen on")
+	flag.StringVar(&cfg.Name, "name", cfg.Name, "name clients ask for the master by")
+	flag.StringVar(&cfg.MasterAddr, "master", "127.0.0.1:6378", "address of the master to monitor")
+	flag.IntVar(&cfg.Quorum, "quorum", cfg.Quorum, "sentinels that must agree the master is down")
+	flag.StringVar(&peers, "sentinels", "", "comma separated addresses of other sentinels")
+	flag.DurationVar(&cfg.DownAfter, "down-after", cfg.DownAfter, "how long the master may not reply before it is considered down")
+	flag.DurationVar(&cfg.FailoverTimeout, "failover-timeout", cfg.FailoverTimeout, "bound on each step of a failover")
+	flag.Parse()
+	if peers != "" {
+		cfg.Sentinels = strings.Split(peers, ",")
+	}
+	if cfg.Quorum < 1 {
+		log.Fatalf("Invalid -quorum %d: must be at least 1", cfg.Quorum)
+	}
+
+	s := sentinel.New(cfg)
+	if err := s.Start(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Sentinel listening on port %d", cfg.Port)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Shutting down sentinel...")
+	s.Stop()
+}
@@ -2,30 +2,77 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"redis-from-scratch/internal/daemon"
+	"redis-from-scratch/internal/logging"
 	"redis-from-scratch/internal/server"
+	"redis-from-scratch/internal/version"
 	"redis-from-scratch/pkg/config"
 )
 
 func main() {
 	configPath := flag.String("config", "", "path to config file")
 	port := flag.Int("port", 6378, "port to listen on")
+	replayUntil := flag.String("replay-until", "", "recover the AOF up to this RFC3339 time, setting later commands aside")
+	readOnly := flag.Bool("read-only", false, "refuse write commands, serving reads only")
+	showVersion := flag.Bool("version", false, "print the version and build, and exit")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(version.Get())
+		return
+	}
+
 	cfg := config.DefaultConfig()
 	if *configPath != "" {
 		loadedCfg, err := config.LoadFromFile(*configPath)
 		if err != nil {
-			log.Printf("Failed to load config: %v, using defaults", err)
-		} else {
-			cfg = loadedCfg
+			logging.Fatalf("Failed to load config %s: %s", *configPath, strings.ReplaceAll(err.Error(), "\n", "; "))
 		}
+		cfg = loadedCfg
 	}
 	cfg.Port = *port
+	if err := cfg.Validate(); err != nil {
+		logging.Fatalf("Invalid configuration: %s", strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	if err := logging.Setup(logging.Options{
+		Level:      cfg.LogLevel,
+		Format:     cfg.LogFormat,
+		File:       cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxAge:     cfg.LogMaxAge,
+		MaxBackups: cfg.LogMaxBackups,
+	}); err != nil {
+		logging.Fatalf("Failed to set up logging: %v", err)
+	}
+	if *replayUntil != "" {
+		t, err := time.Parse(time.RFC3339, *replayUntil)
+		if err != nil {
+			logging.Fatalf("Invalid --replay-until: %v", err)
+		}
+		cfg.ReplayUntil = t
+	}
+	if *readOnly {
+		cfg.ReadOnly = true
+	}
+
+	supervisor, err := daemon.NewSupervisor(cfg.Supervised)
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
+	if cfg.PidFile != "" {
+		// Like Redis, start anyway.
+		if err := daemon.WritePidFile(cfg.PidFile); err != nil {
+			logging.Warnf("Failed to write pidfile: %v", err)
+		}
+		defer daemon.RemovePidFile(cfg.PidFile)
+	}
 
 	srv := server.New(cfg)
 
@@ -33,13 +80,33 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	log.Printf("Starting Redis server on port %d", cfg.Port)
+	logging.Infof("Starting Redis server on port %d, %s", cfg.Port, version.Get())
+	if cfg.TLSPort > 0 {
+		logging.Infof("Accepting TLS connections on port %d", cfg.TLSPort)
+	}
+	if cfg.ReadOnly {
+		logging.Infof("Read-only: write commands will be refused")
+	}
 	if err := srv.Start(); err != nil {
-		log.Fatal(err)
+		if cfg.PidFile != "" {
+			daemon.RemovePidFile(cfg.PidFile)
+		}
+		logging.Fatalf("%v", err)
+	}
+	if err := supervisor.Ready("Ready to accept connections"); err != nil {
+		logging.Warnf("%v", err)
 	}
 
-	// Block here until we receive a shutdown signal, then stop the server.
+	// Block here until we receive a shutdown signal, then drain and stop
+	// the server. A second signal exits at once.
 	<-sigChan
-	log.Println("Shutting down server...")
+	logging.Infof("Shutting down server, waiting up to %v for commands in flight...", cfg.ShutdownTimeout)
+	if err := supervisor.Stopping("Shutting down"); err != nil {
+		logging.Warnf("%v", err)
+	}
+	go func() {
+		<-sigChan
+		logging.Fatalf("Forced shutdown")
+	}()
 	srv.Stop()
 }
@@ -1,3 +1,8 @@
 module redis-from-scratch
 
 go 1.22.2
+
+require (
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/sys v0.4.0
+)
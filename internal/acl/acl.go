@@ -0,0 +1,417 @@
+// Package acl implements Redis' access control lists: users with
+// passwords, the commands they may run and the keys they may access.
+package acl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"redis-from-scratch/internal/glob"
+)
+
+// ErrRemoved is returned by Check for a user that was deleted, whose
+// connections are closed, as in Redis.
+var ErrRemoved = errors.New("user was removed")
+
+// errWrongPass is returned by Authenticate.
+var errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+
+// User is one user's rules. Connections hold the *User they authenticated
+// as, which later rules update in place, as in Redis. Its fields are
+// guarded by the ACL's lock.
+type User struct {
+	name    string
+	enabled bool
+	noPass  bool
+	// passwords are SHA-256 hashes, in hex.
+	passwords map[string]bool
+	// commands are the commands in the category table the user may run.
+	// allCommands is set by +@all and cleared by any rule taking a
+	// command away; it also allows the commands the table lacks.
+	commands    map[string]bool
+	allCommands bool
+	// keys are the glob-style patterns of the keys the user may access.
+	keys    []string
+	removed bool
+}
+
+func newUser(name string) *User {
+	return &User{name: name, passwords: make(map[string]bool), commands: make(map[string]bool)}
+}
+
+// Name returns the user's name.
+func (u *User) Name() string {
+	return u.name
+}
+
+// clone returns a copy of u to apply rules to.
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = make(map[string]bool, len(u.passwords))
+	for h := range u.passwords {
+		c.passwords[h] = true
+	}
+	c.commands = make(map[string]bool, len(u.commands))
+	for cmd := range u.commands {
+		c.commands[cmd] = true
+	}
+	c.keys = slices.Clone(u.keys)
+	return &c
+}
+
+// ACL holds the users. The zero value is not ready to use; call New.
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// New creates an ACL with only the default user, who needs no password
+// and may run any command on any key.
+func New() *ACL {
+	return &ACL{users: map[string]*User{"default": defaultUser()}}
+}
+
+func defaultUser() *User {
+	u := newUser("default")
+	for _, rule := range []string{"on", "nopass", "~*", "+@all"} {
+		u.apply(rule)
+	}
+	return u
+}
+
+// Default returns the default user, and whether connections are
+// authenticated as it from the start: it is enabled and needs no
+// password.
+func (a *ACL) Default() (*User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u := a.users["default"]
+	return u, u.enabled && u.noPass
+}
+
+// NoPass reports whether the default user has nopass.
+func (a *ACL) NoPass() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users["default"].noPass
+}
+
+// Authenticate returns the user called name if it is enabled and pass is
+// one of its passwords, or it has nopass.
+func (a *ACL) Authenticate(name, pass string) (*User, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u := a.users[name]
+	if u == nil || !u.enabled || !u.noPass && !u.passwords[hash(pass)] {
+		return nil, errWrongPass
+	}
+	return u, nil
+}
+
+// Check returns the NOPERM error for u running cmd, upper-cased, on keys,
+// if it may not, or ErrRemoved if u no longer exists.
+func (a *ACL) Check(u *User, cmd string, keys []string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if u.removed {
+		return ErrRemoved
+	}
+	if _, known := commandCategories[cmd]; !u.commands[cmd] && (known || !u.allCommands) {
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", u.name, strings.ToLower(cmd))
+	}
+	for _, key := range keys {
+		if !slices.ContainsFunc(u.keys, func(pattern string) bool { return glob.Match(pattern, key) }) {
+			return errors.New("NOPERM No permissions to access a key")
+		}
+	}
+	return nil
+}
+
+// SetUser applies rules to the user called name, creating it, disabled and
+// without permissions, if it does not exist. Either every rule applies or,
+// on an error, none does.
+func (a *ACL) SetUser(name string, rules ...string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.users[name]
+	next := newUser(name)
+	if u != nil {
+		next = u.clone()
+	}
+	for _, rule := range rules {
+		if err := next.apply(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	if u == nil {
+		a.users[name] = next
+	} else {
+		*u = *next
+	}
+	return nil
+}
+
+// DelUser deletes the named users and returns how many existed. The
+// default user cannot be deleted.
+func (a *ACL) DelUser(names ...string) (int, error) {
+	if slices.Contains(names, "default") {
+		return 0, errors.New("The 'default' user cannot be removed")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for _, name := range names {
+		if u := a.users[name]; u != nil {
+			u.removed = true
+			delete(a.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// List returns every user as it appears in an ACL file, sorted by name:
+// "user <name> <rule> ...".
+func (a *ACL) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = "user " + name + " " + strings.Join(a.users[name].rules(), " ")
+	}
+	return lines
+}
+
+// GetUser describes the user called name as ACL GETUSER does: its flags,
+// password hashes, commands and key patterns.
+func (a *ACL) GetUser(name string) ([]any, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u := a.users[name]
+	if u == nil {
+		return nil, false
+	}
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.noPass {
+		flags = append(flags, "nopass")
+	}
+	keys := make([]string, len(u.keys))
+	for i, pattern := range u.keys {
+		keys[i] = "~" + pattern
+	}
+	return []any{
+		"flags", flags,
+		"passwords", u.passwordList(),
+		"commands", strings.Join(u.commandRules(), " "),
+		"keys", strings.Join(keys, " "),
+	}, true
+}
+
+// Load replaces the users with those in the ACL file at path, which holds
+// lines like ACL LIST's. Blank lines and lines starting with # are
+// skipped. The default user keeps its defaults unless the file has it.
+// Users missing from the file are deleted. On an error nothing changes.
+func (a *ACL) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users := make(map[string]*User)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: line should start with user keyword", path, line)
+		}
+		if users[fields[1]] != nil {
+			return fmt.Errorf("%s:%d: duplicate user '%s'", path, line, fields[1])
+		}
+		u := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.apply(rule); err != nil {
+				return fmt.Errorf("%s:%d: %v. Use ACL SETUSER rules", path, line, err)
+			}
+		}
+		users[u.name] = u
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if users["default"] == nil {
+		users["default"] = defaultUser()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, u := range a.users {
+		if next := users[name]; next != nil {
+			*u = *next
+			users[name] = u
+		} else {
+			u.removed = true
+		}
+	}
+	a.users = users
+	return nil
+}
+
+// apply applies one ACL SETUSER rule to u.
+func (u *User) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.noPass, u.passwords = true, make(map[string]bool)
+		return nil
+	case "resetpass":
+		u.noPass, u.passwords = false, make(map[string]bool)
+		return nil
+	case "allkeys":
+		return u.apply("~*")
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allcommands":
+		return u.apply("+@all")
+	case "nocommands":
+		return u.apply("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "off", "-@all"} {
+			u.apply(r)
+		}
+		return nil
+	}
+	if rule == "" {
+		return errors.New("Syntax error")
+	}
+	switch arg := rule[1:]; rule[0] {
+	case '>':
+		u.noPass = false
+		u.passwords[hash(arg)] = true
+	case '<':
+		if !u.passwords[hash(arg)] {
+			return errors.New("no such password")
+		}
+		delete(u.passwords, hash(arg))
+	case '#':
+		if len(arg) != sha256.Size*2 || strings.Trim(arg, "0123456789abcdef") != "" {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.noPass = false
+		u.passwords[arg] = true
+	case '!':
+		if !u.passwords[arg] {
+			return errors.New("no such password")
+		}
+		delete(u.passwords, arg)
+	case '~':
+		if !slices.Contains(u.keys, arg) {
+			u.keys = append(u.keys, arg)
+		}
+	case '+', '-':
+		allow := rule[0] == '+'
+		if category, ok := strings.CutPrefix(arg, "@"); ok {
+			category = strings.ToLower(category)
+			if !categories[category] {
+				return errors.New("Unknown command or category name in ACL")
+			}
+			for cmd := range commandCategories {
+				if InCategory(cmd, category) {
+					u.commands[cmd] = allow
+				}
+			}
+			if category == "all" {
+				u.allCommands = allow
+			}
+		} else {
+			cmd := strings.ToUpper(arg)
+			if _, ok := commandCategories[cmd]; !ok {
+				return errors.New("Unknown command or category name in ACL")
+			}
+			u.commands[cmd] = allow
+		}
+		if !allow {
+			u.allCommands = false
+		}
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+// rules returns rules that recreate u.
+func (u *User) rules() []string {
+	rules := []string{"off"}
+	if u.enabled {
+		rules[0] = "on"
+	}
+	if u.noPass {
+		rules = append(rules, "nopass")
+	}
+	for _, h := range u.passwordList() {
+		rules = append(rules, "#"+h)
+	}
+	for _, pattern := range u.keys {
+		rules = append(rules, "~"+pattern)
+	}
+	return append(rules, u.commandRules()...)
+}
+
+// commandRules describes u's commands: +@all and the commands taken away,
+// or -@all and the commands allowed.
+func (u *User) commandRules() []string {
+	var rules []string
+	for cmd := range commandCategories {
+		if u.commands[cmd] != u.allCommands {
+			rules = append(rules, strings.ToLower(cmd))
+		}
+	}
+	sort.Strings(rules)
+	sign, all := "+", "-@all"
+	if u.allCommands {
+		sign, all = "-", "+@all"
+	}
+	for i, cmd := range rules {
+		rules[i] = sign + cmd
+	}
+	return append([]string{all}, rules...)
+}
+
+func (u *User) passwordList() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for h := range u.passwords {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// hash returns the hex SHA-256 of a password, as ACL rules store it.
+func hash(pass string) string {
+	sum := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,148 @@
+package acl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultUser(t *testing.T) {
+	a := New()
+	u, auto := a.Default()
+	if !auto {
+		t.Fatalf("expected connections to start authenticated")
+	}
+	if err := a.Check(u, "FLUSHALL", nil); err != nil {
+		t.Fatalf("expected the default user to run anything, got %v", err)
+	}
+	if err := a.Check(u, "SOMEFUTURECOMMAND", []string{"k"}); err != nil {
+		t.Fatalf("expected +@all to allow commands missing from the table, got %v", err)
+	}
+	if got := a.List(); !reflect.DeepEqual(got, []string{"user default on nopass ~* +@all"}) {
+		t.Fatalf("unexpected ACL LIST: %q", got)
+	}
+}
+
+func TestSetUser(t *testing.T) {
+	a := New()
+	if err := a.SetUser("app", "on", ">secret", "~cache:*", "+@read", "+set", "-keys"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := a.Authenticate("app", "secret")
+	if err != nil {
+		t.Fatalf("expected the password to work, got %v", err)
+	}
+	if _, err := a.Authenticate("app", "wrong"); err == nil {
+		t.Fatalf("expected a wrong password to fail")
+	}
+	for _, ok := range []struct {
+		cmd  string
+		keys []string
+	}{{"GET", []string{"cache:1"}}, {"SET", []string{"cache:2"}}, {"SMEMBERS", []string{"cache:3"}}} {
+		if err := a.Check(u, ok.cmd, ok.keys); err != nil {
+			t.Fatalf("expected %s %v to be allowed, got %v", ok.cmd, ok.keys, err)
+		}
+	}
+	if err := a.Check(u, "GET", []string{"other"}); err == nil || err.Error() != "NOPERM No permissions to access a key" {
+		t.Fatalf("expected the key to be refused, got %v", err)
+	}
+	if err := a.Check(u, "KEYS", nil); err == nil || err.Error() != "NOPERM User app has no permissions to run the 'keys' command" {
+		t.Fatalf("expected KEYS to be refused, got %v", err)
+	}
+	if err := a.Check(u, "DEL", []string{"cache:1"}); err == nil {
+		t.Fatalf("expected a write outside +set to be refused")
+	}
+
+	// Rules apply in place, to the connections that authenticated.
+	if err := a.SetUser("app", "+@all", "-@dangerous"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Check(u, "DEL", []string{"cache:1"}); err != nil {
+		t.Fatalf("expected +@all to allow DEL, got %v", err)
+	}
+	if err := a.Check(u, "FLUSHALL", nil); err == nil {
+		t.Fatalf("expected -@dangerous to refuse FLUSHALL")
+	}
+	if err := a.Check(u, "SOMEFUTURECOMMAND", nil); err == nil {
+		t.Fatalf("expected a removal to disallow commands missing from the table")
+	}
+
+	// A bad rule leaves the user alone.
+	if err := a.SetUser("app", "off", "+nosuchcommand"); err == nil || !strings.Contains(err.Error(), "Unknown command or category name in ACL") {
+		t.Fatalf("expected an unknown command to be refused, got %v", err)
+	}
+	if _, err := a.Authenticate("app", "secret"); err != nil {
+		t.Fatalf("expected the user to stay enabled, got %v", err)
+	}
+
+	// A new user starts disabled without permissions.
+	a.SetUser("new", ">pw")
+	if _, err := a.Authenticate("new", "pw"); err == nil {
+		t.Fatalf("expected a new user to be disabled")
+	}
+	if n, err := a.DelUser("new", "missing"); n != 1 || err != nil {
+		t.Fatalf("expected one deletion, got %d, %v", n, err)
+	}
+	if _, err := a.DelUser("default"); err == nil {
+		t.Fatalf("expected the default user to stay")
+	}
+	a.DelUser("app")
+	if err := a.Check(u, "GET", nil); !errors.Is(err, ErrRemoved) {
+		t.Fatalf("expected the deleted user's connections to be told, got %v", err)
+	}
+}
+
+func TestListRoundTrip(t *testing.T) {
+	a := New()
+	a.SetUser("app", "on", ">secret", "~a:*", "~b:*", "-@all", "+get", "+set")
+	a.SetUser("admin", "on", "nopass", "allkeys", "+@all", "-flushall")
+	path := filepath.Join(t.TempDir(), "users.acl")
+	if err := os.WriteFile(path, []byte("# users\n\n"+strings.Join(a.List(), "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New()
+	if err := b.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.List(), a.List(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	info, _ := b.GetUser("app")
+	if info[5] != "-@all +get +set" || info[7] != "~a:* ~b:*" {
+		t.Fatalf("unexpected ACL GETUSER: %v", info)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	a := New()
+	a.SetUser("gone", "on", "nopass", "+@all")
+	gone, _ := a.Authenticate("gone", "")
+	def, _ := a.Default()
+	path := filepath.Join(t.TempDir(), "users.acl")
+
+	os.WriteFile(path, []byte("user default on >pw ~* +@all\nuser app on\nbogus\n"), 0600)
+	if err := a.Load(path); err == nil || !strings.Contains(err.Error(), ":3: line should start with user keyword") {
+		t.Fatalf("expected the bad line to be reported, got %v", err)
+	}
+	if _, err := a.Authenticate("gone", ""); err != nil {
+		t.Fatalf("expected a failed load to change nothing, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("user default on >pw ~* +@all\nuser app on\n"), 0600)
+	if err := a.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, auto := a.Default(); auto {
+		t.Fatalf("expected the default user to need its new password")
+	}
+	if d, _ := a.Default(); d != def {
+		t.Fatalf("expected the default user to be updated in place")
+	}
+	if err := a.Check(gone, "GET", nil); !errors.Is(err, ErrRemoved) {
+		t.Fatalf("expected a user missing from the file to be deleted, got %v", err)
+	}
+}
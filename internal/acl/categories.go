@@ -0,0 +1,118 @@
+package acl
+
+// commandCategories gives the ACL categories of each command, as in Redis'
+// command table. Every command is also in @all.
+var commandCategories = map[string][]string{
+	"PING":   {"fast", "connection"},
+	"ECHO":   {"fast", "connection"},
+	"AUTH":   {"fast", "connection"},
+	"HELLO":  {"fast", "connection"},
+	"QUIT":   {"fast", "connection"},
+	"RESET":  {"fast", "connection"},
+	"SELECT": {"fast", "connection"},
+	"ASKING": {"fast", "connection"},
+	"WAIT":   {"slow", "connection"},
+	"CLIENT": {"slow", "connection"},
+
+	"SET": {"write", "string", "slow"},
+	"GET": {"read", "string", "fast"},
+
+	"HSET":    {"write", "hash", "fast"},
+	"HGET":    {"read", "hash", "fast"},
+	"HDEL":    {"write", "hash", "fast"},
+	"HGETALL": {"read", "hash", "slow"},
+	"HSCAN":   {"read", "hash", "slow"},
+
+	"LPUSH":  {"write", "list", "fast"},
+	"RPUSH":  {"write", "list", "fast"},
+	"LPOP":   {"write", "list", "fast"},
+	"RPOP":   {"write", "list", "fast"},
+	"LRANGE": {"read", "list", "slow"},
+	"LMOVE":  {"write", "list", "slow"},
+
+	"SADD":        {"write", "set", "fast"},
+	"SREM":        {"write", "set", "fast"},
+	"SMEMBERS":    {"read", "set", "slow"},
+	"SISMEMBER":   {"read", "set", "fast"},
+	"SMOVE":       {"write", "set", "fast"},
+	"SINTERSTORE": {"write", "set", "slow"},
+
+	"ZADD":   {"write", "sortedset", "fast"},
+	"ZRANGE": {"read", "sortedset", "slow"},
+
+	"DEL":       {"keyspace", "write", "slow"},
+	"EXISTS":    {"keyspace", "read", "fast"},
+	"KEYS":      {"keyspace", "read", "slow", "dangerous"},
+	"SCAN":      {"keyspace", "read", "slow"},
+	"OBJECT":    {"keyspace", "read", "slow"},
+	"RENAME":    {"keyspace", "write", "slow"},
+	"RENAMENX":  {"keyspace", "write", "fast"},
+	"PEXPIREAT": {"keyspace", "write", "fast"},
+	"DUMP":      {"keyspace", "read", "slow"},
+	"RESTORE":   {"keyspace", "write", "slow", "dangerous"},
+	"MIGRATE":   {"keyspace", "write", "slow", "dangerous"},
+	"FLUSHDB":   {"keyspace", "write", "slow", "dangerous"},
+	"FLUSHALL":  {"keyspace", "write", "slow", "dangerous"},
+
+	"PUBLISH":      {"pubsub", "fast"},
+	"SUBSCRIBE":    {"pubsub", "slow"},
+	"UNSUBSCRIBE":  {"pubsub", "slow"},
+	"PSUBSCRIBE":   {"pubsub", "slow"},
+	"PUNSUBSCRIBE": {"pubsub", "slow"},
+
+	"INFO":         {"slow", "dangerous"},
+	"LATENCY":      {"admin", "slow", "dangerous"},
+	"SLOWLOG":      {"admin", "slow", "dangerous"},
+	"MEMORY":       {"slow", "dangerous"},
+	"CLUSTER":      {"slow"},
+	"COMMAND":      {"slow", "connection"},
+	"SAVE":         {"admin", "slow", "dangerous"},
+	"BGSAVE":       {"admin", "slow", "dangerous"},
+	"BGREWRITEAOF": {"admin", "slow", "dangerous"},
+	"LASTSAVE":     {"admin", "fast", "dangerous"},
+	"BACKUP":       {"admin", "slow", "dangerous"},
+	"CONFIG":       {"admin", "slow", "dangerous"},
+	"ACL":          {"admin", "slow", "dangerous"},
+	"REPLICAOF":    {"admin", "slow", "dangerous"},
+	"SLAVEOF":      {"admin", "slow", "dangerous"},
+	"ROLE":         {"admin", "fast", "dangerous"},
+	"FAILOVER":     {"admin", "slow", "dangerous"},
+	"SYNC":         {"admin", "slow", "dangerous"},
+	"PSYNC":        {"admin", "slow", "dangerous"},
+	"REPLCONF":     {"admin", "slow", "dangerous"},
+}
+
+// categories are the known categories, found in the table.
+var categories = func() map[string]bool {
+	cats := map[string]bool{"all": true}
+	for _, cs := range commandCategories {
+		for _, c := range cs {
+			cats[c] = true
+		}
+	}
+	return cats
+}()
+
+// Categories returns the ACL categories of the upper-cased command, for
+// COMMAND INFO.
+func Categories(cmd string) []string {
+	return append([]string(nil), commandCategories[cmd]...)
+}
+
+// IsCategory reports whether name, without its @, is a known category.
+func IsCategory(name string) bool {
+	return categories[name]
+}
+
+// InCategory reports whether the upper-cased cmd is in category.
+func InCategory(cmd, category string) bool {
+	if category == "all" {
+		return true
+	}
+	for _, c := range commandCategories[cmd] {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
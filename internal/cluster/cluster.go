@@ -0,0 +1,601 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// BusPortOffset is added to a node's client port to get the port of its
+// cluster bus, as in Redis.
+const BusPortOffset = 10000
+
+// Node is a cluster node as this node knows it.
+type Node struct {
+	// ID is the node's 40 character hex name, fixed for its lifetime.
+	ID string
+	// Host is the address clients reach the node at, empty if unknown.
+	Host    string
+	Port    int
+	BusPort int
+	Myself  bool
+	// ConfigEpoch versions the node's claim on its slots.
+	ConfigEpoch uint64
+	// Slots are the slot ranges the node serves, ascending.
+	Slots []Range
+	// PFail is set when the node has not been heard from for the node
+	// timeout, and Fail when a majority of the masters agree it is down.
+	PFail bool
+	Fail  bool
+	// PingSent is when the oldest unanswered ping was sent to the node,
+	// zero if none, and PongReceived when it last answered one.
+	PingSent     time.Time
+	PongReceived time.Time
+	// LinkDown is set while the last ping could not be delivered.
+	LinkDown bool
+
+	// seen is when the node was last heard from, failTime when it was
+	// flagged Fail, and failReports when each other node last reported it
+	// failing.
+	seen        time.Time
+	failTime    time.Time
+	failReports map[string]time.Time
+}
+
+// Addr is the node's client address, host:port.
+func (n Node) Addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// Info summarizes the cluster for CLUSTER INFO.
+type Info struct {
+	// State is "ok" when every slot is served by a node that is not
+	// failing, "fail" otherwise.
+	State         string
+	SlotsAssigned int
+	// SlotsPFail and SlotsFail count the slots served by nodes flagged
+	// PFail and Fail.
+	SlotsPFail int
+	SlotsFail  int
+	KnownNodes int
+	// Size is the number of nodes serving at least one slot.
+	Size         int
+	CurrentEpoch uint64
+	MyEpoch      uint64
+}
+
+// Cluster is this node's view of the cluster. It is safe for concurrent use.
+type Cluster struct {
+	mu           sync.Mutex
+	path         string
+	myself       *Node
+	nodes        map[string]*Node
+	owner        [Slots]*Node
+	currentEpoch uint64
+	nodeTimeout  time.Duration
+
+	// importing and migrating record the slots being moved to this node
+	// from another, and from this node to another, during resharding.
+	importing [Slots]*Node
+	migrating [Slots]*Node
+}
+
+// Open loads the cluster state from the nodes.conf file at path, or starts a
+// cluster of one node with a new ID, serving no slots, and writes the file.
+// host and port are where clients reach this node; host may be empty.
+func Open(path, host string, port int) (*Cluster, error) {
+	c := &Cluster{path: path, nodes: make(map[string]*Node), nodeTimeout: DefaultNodeTimeout}
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		defer f.Close()
+		if err := c.load(f); err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", path, err)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		id, err := newNodeID()
+		if err != nil {
+			return nil, err
+		}
+		c.myself = &Node{ID: id, Myself: true}
+		c.nodes[id] = c.myself
+		logging.Infof("No cluster configuration found, I'm %s", id)
+	default:
+		return nil, err
+	}
+	if host != "" {
+		c.myself.Host = host
+	}
+	c.myself.Port, c.myself.BusPort = port, port+BusPortOffset
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newNodeID returns a random node ID.
+func newNodeID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate node ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MyID returns this node's ID.
+func (c *Cluster) MyID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.myself.ID
+}
+
+// Myself returns this node.
+func (c *Cluster) Myself() Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.node(c.myself)
+}
+
+// Nodes returns the known nodes, this one first and the rest by ID.
+func (c *Cluster) Nodes() []Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodeList()
+}
+
+func (c *Cluster) nodeList() []Node {
+	out := make([]Node, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		out = append(out, c.node(n))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Myself != out[j].Myself {
+			return out[i].Myself
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// node returns a copy of n with its slot ranges filled in. It is called with
+// mu held.
+func (c *Cluster) node(n *Node) Node {
+	var slots []int
+	for slot, owner := range c.owner {
+		if owner == n {
+			slots = append(slots, slot)
+		}
+	}
+	out := *n
+	out.Slots = ranges(slots)
+	return out
+}
+
+// Owner returns the node serving slot, if any, without its Slots.
+func (c *Cluster) Owner(slot int) (Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.owner[slot]
+	if n == nil {
+		return Node{}, false
+	}
+	out := *n
+	return out, true
+}
+
+// Importing returns the node slot is being imported from, if any.
+func (c *Cluster) Importing(slot int) (Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := c.importing[slot]; n != nil {
+		return *n, true
+	}
+	return Node{}, false
+}
+
+// Migrating returns the node slot is being migrated to, if any.
+func (c *Cluster) Migrating(slot int) (Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := c.migrating[slot]; n != nil {
+		return *n, true
+	}
+	return Node{}, false
+}
+
+// Info returns the cluster summary.
+func (c *Cluster) Info() Info {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := Info{KnownNodes: len(c.nodes), CurrentEpoch: c.currentEpoch, MyEpoch: c.myself.ConfigEpoch}
+	serving := make(map[*Node]bool)
+	for _, n := range c.owner {
+		if n == nil {
+			continue
+		}
+		info.SlotsAssigned++
+		serving[n] = true
+		if n.PFail {
+			info.SlotsPFail++
+		}
+		if n.Fail {
+			info.SlotsFail++
+		}
+	}
+	info.Size = len(serving)
+	info.State = "fail"
+	if info.SlotsAssigned == Slots && info.SlotsFail == 0 {
+		info.State = "ok"
+	}
+	return info
+}
+
+// AddSlots makes this node serve slots. Nothing changes unless every slot
+// is currently unassigned.
+func (c *Cluster) AddSlots(slots []int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, slot := range slots {
+		if c.owner[slot] != nil {
+			return fmt.Errorf("Slot %d is already busy", slot)
+		}
+	}
+	for _, slot := range slots {
+		c.owner[slot] = c.myself
+	}
+	return c.save()
+}
+
+// DelSlots leaves slots unassigned, whichever node served them. Nothing
+// changes unless every slot is currently assigned.
+func (c *Cluster) DelSlots(slots []int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, slot := range slots {
+		if c.owner[slot] == nil {
+			return fmt.Errorf("Slot %d is already unassigned", slot)
+		}
+	}
+	for _, slot := range slots {
+		c.owner[slot] = nil
+	}
+	return c.save()
+}
+
+// lookup returns the known node with id. It is called with mu held.
+func (c *Cluster) lookup(id string) (*Node, error) {
+	n := c.nodes[id]
+	if n == nil {
+		return nil, fmt.Errorf("I don't know about node %s", id)
+	}
+	return n, nil
+}
+
+// SetSlotImporting marks slot as being imported from the node with id,
+// which serves it, so this node accepts commands for it from clients that
+// were redirected with ASK.
+func (c *Cluster) SetSlotImporting(slot int, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owner[slot] == c.myself {
+		return fmt.Errorf("I'm already the owner of hash slot %d", slot)
+	}
+	n, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if n == c.myself {
+		return fmt.Errorf("I'm already the owner of hash slot %d", slot)
+	}
+	c.importing[slot] = n
+	return c.save()
+}
+
+// SetSlotMigrating marks slot, which this node serves, as being migrated to
+// the node with id, so commands for keys no longer here are redirected there
+// with ASK.
+func (c *Cluster) SetSlotMigrating(slot int, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owner[slot] != c.myself {
+		return fmt.Errorf("I'm not the owner of hash slot %d", slot)
+	}
+	n, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if n == c.myself {
+		return errors.New("Can't migrate a hash slot to myself")
+	}
+	c.migrating[slot] = n
+	return c.save()
+}
+
+// SetSlotStable clears the importing and migrating state of slot.
+func (c *Cluster) SetSlotStable(slot int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.importing[slot], c.migrating[slot] = nil, nil
+	return c.save()
+}
+
+// SetSlotNode assigns slot to the node with id, ending a migration: the
+// source stops migrating it, and the target, taking it over, stops
+// importing it and claims it with a new config epoch, as Redis does. The
+// caller checks that a source no longer holds keys in the slot.
+func (c *Cluster) SetSlotNode(slot int, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if n != c.myself {
+		c.migrating[slot] = nil
+	}
+	if n == c.myself && c.importing[slot] != nil {
+		c.importing[slot] = nil
+		c.currentEpoch++
+		c.myself.ConfigEpoch = c.currentEpoch
+	}
+	c.owner[slot] = n
+	return c.save()
+}
+
+// WriteNodes writes the CLUSTER NODES description of the cluster to w: one
+// line per node with its ID, address, flags, master, last ping sent and pong
+// received, config epoch, link state and slots.
+func (c *Cluster) WriteNodes(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeNodes(w)
+}
+
+func (c *Cluster) writeNodes(w io.Writer) error {
+	for _, n := range c.nodeList() {
+		if _, err := fmt.Fprintln(w, c.nodeLine(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeLine is n's CLUSTER NODES line. It is called with mu held.
+func (c *Cluster) nodeLine(n Node) string {
+	flags := "master"
+	if n.Myself {
+		flags = "myself,master"
+	}
+	if n.PFail {
+		flags += ",fail?"
+	}
+	if n.Fail {
+		flags += ",fail"
+	}
+	link := "connected"
+	if n.LinkDown {
+		link = "disconnected"
+	}
+	line := fmt.Sprintf("%s %s:%d@%d %s - %d %d %d %s", n.ID, n.Host, n.Port, n.BusPort, flags,
+		unixMilli(n.PingSent), unixMilli(n.PongReceived), n.ConfigEpoch, link)
+	for _, r := range n.Slots {
+		line += " " + r.String()
+	}
+	if n.Myself {
+		// Slots being moved are listed as [slot->-target] and
+		// [slot-<-source], as in Redis.
+		for slot := range Slots {
+			if to := c.migrating[slot]; to != nil {
+				line += fmt.Sprintf(" [%d->-%s]", slot, to.ID)
+			}
+			if from := c.importing[slot]; from != nil {
+				line += fmt.Sprintf(" [%d-<-%s]", slot, from.ID)
+			}
+		}
+	}
+	return line
+}
+
+// unixMilli is t in Unix milliseconds, 0 for the zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// save writes the state to the nodes.conf file: the CLUSTER NODES lines and
+// a vars line with the epochs. It is written to a temporary file and renamed
+// into place, so a crash leaves either the old or the new file. It is called
+// with mu held.
+func (c *Cluster) save() error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "temp-nodes-*.conf")
+	if err != nil {
+		return fmt.Errorf("failed to write cluster config: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	err = c.writeNodes(w)
+	if err == nil {
+		_, err = fmt.Fprintf(w, "vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write cluster config: %w", err)
+	}
+	return nil
+}
+
+// load reads a nodes.conf file written by save, or by Redis.
+func (c *Cluster) load(r io.Reader) error {
+	var moving []move
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					c.currentEpoch, _ = strconv.ParseUint(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		n, moves, err := parseNode(fields)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		c.add(n)
+		moving = append(moving, moves...)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if c.myself == nil {
+		return errors.New("no node is flagged myself")
+	}
+	for _, m := range moving {
+		n, err := c.lookup(m.id)
+		if err != nil {
+			return err
+		}
+		if m.importing {
+			c.importing[m.slot] = n
+		} else {
+			c.migrating[m.slot] = n
+		}
+	}
+	return nil
+}
+
+// move is a [slot->-id] or [slot-<-id] entry of nodes.conf.
+type move struct {
+	slot      int
+	id        string
+	importing bool
+}
+
+// parseMove reads a move entry without its brackets.
+func parseMove(s string) (move, error) {
+	var m move
+	num, id, ok := strings.Cut(s, "->-")
+	if !ok {
+		num, id, ok = strings.Cut(s, "-<-")
+		m.importing = true
+	}
+	slot, err := ParseSlot(num)
+	if !ok || err != nil || id == "" {
+		return m, fmt.Errorf("bad migrating slot %q", s)
+	}
+	m.slot, m.id = slot, id
+	return m, nil
+}
+
+// add records n, loaded from nodes.conf, as the owner of its slots. Its
+// health is judged afresh, as if it had just been heard from. It is called
+// with mu held.
+func (c *Cluster) add(n *Node) {
+	if n.Myself {
+		c.myself = n
+	}
+	n.PFail, n.Fail, n.seen = false, false, time.Now()
+	c.nodes[n.ID] = n
+	for _, r := range n.Slots {
+		for slot := r.Start; slot <= r.End; slot++ {
+			c.owner[slot] = n
+		}
+	}
+	n.Slots = nil
+}
+
+// parseNode reads the fields of a CLUSTER NODES or nodes.conf line: the
+// node, with the slots it serves, and the slots it is moving.
+func parseNode(fields []string) (*Node, []move, error) {
+	if len(fields) < 8 {
+		return nil, nil, errors.New("too few fields")
+	}
+	n := &Node{ID: fields[0]}
+	// ip:port@cport, followed in Redis 7 by ,hostname.
+	addr, _, _ := strings.Cut(fields[1], ",")
+	addr, bus, _ := strings.Cut(addr, "@")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	n.Host = host
+	if n.Port, err = strconv.Atoi(port); err != nil {
+		return nil, nil, fmt.Errorf("bad port in %q", fields[1])
+	}
+	if bus != "" {
+		if n.BusPort, err = strconv.Atoi(bus); err != nil {
+			return nil, nil, fmt.Errorf("bad bus port in %q", fields[1])
+		}
+	}
+	for _, flag := range strings.Split(fields[2], ",") {
+		switch flag {
+		case "myself":
+			n.Myself = true
+		case "fail?":
+			n.PFail = true
+		case "fail":
+			n.Fail = true
+		}
+	}
+	if n.ConfigEpoch, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
+		return nil, nil, fmt.Errorf("bad config epoch %q", fields[6])
+	}
+	var slots []int
+	var moves []move
+	for _, s := range fields[8:] {
+		if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+			m, err := parseMove(s[1 : len(s)-1])
+			if err != nil {
+				return nil, nil, err
+			}
+			moves = append(moves, m)
+			continue
+		}
+		first, last, isRange := strings.Cut(s, "-")
+		if !isRange {
+			last = first
+		}
+		start, err1 := ParseSlot(first)
+		end, err2 := ParseSlot(last)
+		if err1 != nil || err2 != nil || start > end {
+			return nil, nil, fmt.Errorf("bad slot range %q", s)
+		}
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	n.Slots = ranges(slots)
+	return n, moves, nil
+}
@@ -0,0 +1,245 @@
+package cluster
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"", 0},
+		{"123456789", 12739}, // CRC16/XMODEM check value 0x31C3
+		{"foo", 12182},
+		{"bar", 5061},
+		{"{user1000}.following", 3443},
+		{"{user1000}.followers", 3443},
+		{"user1000", 3443},
+		{"a{b}c{d}", KeySlot("b")},
+	}
+	for _, tt := range tests {
+		if got := KeySlot(tt.key); got != tt.slot {
+			t.Errorf("KeySlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+	// An empty tag or no closing brace hashes the whole key.
+	for _, key := range []string{"{}bar", "foo{}{bar}", "{bar"} {
+		if KeySlot(key) == KeySlot("bar") {
+			t.Errorf("expected %q to hash as a whole", key)
+		}
+	}
+}
+
+func TestRanges(t *testing.T) {
+	got := ranges([]int{0, 1, 2, 5, 7, 8})
+	want := []Range{{0, 2}, {5, 5}, {7, 8}}
+	if len(got) != len(want) {
+		t.Fatalf("ranges = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("ranges = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSlotsAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+	c, err := Open(path, "127.0.0.1", 7000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := c.MyID()
+	if len(id) != 40 {
+		t.Fatalf("expected a 40 character node ID, got %q", id)
+	}
+	if info := c.Info(); info.State != "fail" || info.SlotsAssigned != 0 || info.KnownNodes != 1 || info.Size != 0 {
+		t.Fatalf("unexpected info for a new node: %+v", info)
+	}
+
+	all := make([]int, Slots)
+	for i := range all {
+		all[i] = i
+	}
+	if err := c.AddSlots(all); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddSlots([]int{9, 10}); err == nil || err.Error() != "Slot 9 is already busy" {
+		t.Fatalf("expected a busy slot error, got %v", err)
+	}
+	if info := c.Info(); info.State != "ok" || info.SlotsAssigned != Slots || info.Size != 1 {
+		t.Fatalf("unexpected info once every slot is served: %+v", info)
+	}
+	if err := c.DelSlots([]int{100, 101, 102, 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DelSlots([]int{5, 100}); err == nil || err.Error() != "Slot 100 is already unassigned" {
+		t.Fatalf("expected an unassigned slot error, got %v", err)
+	}
+	if _, ok := c.Owner(5); !ok {
+		t.Fatalf("expected a failed DelSlots to leave slot 5 assigned")
+	}
+
+	var b strings.Builder
+	if err := c.WriteNodes(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := id + " 127.0.0.1:7000@17000 myself,master - 0 0 0 connected 0-99 103-199 201-16383\n"
+	if b.String() != want {
+		t.Fatalf("CLUSTER NODES = %q, want %q", b.String(), want)
+	}
+
+	// The ID and slots survive a restart, and the port follows the config.
+	c, err = Open(path, "", 7001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	me := c.Myself()
+	if me.ID != id || me.Host != "127.0.0.1" || me.Port != 7001 || me.BusPort != 17001 {
+		t.Fatalf("unexpected node after reload: %+v", me)
+	}
+	if len(me.Slots) != 3 || me.Slots[1] != (Range{103, 199}) {
+		t.Fatalf("unexpected slots after reload: %v", me.Slots)
+	}
+}
+
+func TestSlotMigrationState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+	c, err := Open(path, "127.0.0.1", 7000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The other node's ID is the smallest, so this node keeps its epoch.
+	other := Node{ID: strings.Repeat("0", 40)}
+	if _, err := c.Receive([]string{"0", other.ID + " 127.0.0.1:7001@17001 myself,master - 0 0 0 connected 10-20"}, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddSlots([]int{1, 15}); err == nil {
+		t.Fatalf("expected adding a slot the other node serves to fail")
+	}
+	if err := c.AddSlots([]int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := c.Owner(15); owner.ID != other.ID {
+		t.Fatalf("expected a meet to teach the other node's slots, got %+v", owner)
+	}
+	if err := c.SetSlotMigrating(10, other.ID); err == nil || err.Error() != "I'm not the owner of hash slot 10" {
+		t.Fatalf("expected migrating a slot owned elsewhere to fail, got %v", err)
+	}
+	if err := c.SetSlotImporting(1, other.ID); err == nil {
+		t.Fatalf("expected importing an owned slot to fail")
+	}
+	if err := c.SetSlotMigrating(1, strings.Repeat("c", 40)); err == nil || !strings.HasPrefix(err.Error(), "I don't know about node") {
+		t.Fatalf("expected an unknown node to be refused, got %v", err)
+	}
+	if err := c.SetSlotMigrating(1, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetSlotImporting(10, other.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The migrations survive a restart.
+	c, err = Open(path, "", 7000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to, ok := c.Migrating(1); !ok || to.ID != other.ID {
+		t.Fatalf("expected slot 1 to be migrating after reload, got %+v", to)
+	}
+	if from, ok := c.Importing(10); !ok || from.ID != other.ID {
+		t.Fatalf("expected slot 10 to be importing after reload, got %+v", from)
+	}
+
+	if err := c.SetSlotNode(10, c.MyID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Importing(10); ok {
+		t.Fatalf("expected taking over slot 10 to end its import")
+	}
+	if info := c.Info(); info.MyEpoch != 1 || info.CurrentEpoch != 1 {
+		t.Fatalf("expected taking over a slot to bump the epoch, got %+v", info)
+	}
+	if err := c.SetSlotNode(1, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Migrating(1); ok {
+		t.Fatalf("expected handing over slot 1 to end its migration")
+	}
+}
+
+func TestGossip(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "nodes.conf"), "127.0.0.1", 7000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetNodeTimeout(time.Second)
+	var slots []int
+	for slot := range 100 {
+		slots = append(slots, slot)
+	}
+	c.AddSlots(slots)
+
+	// b's ID is the greatest, so on the epoch collision this node moves on.
+	b, d := strings.Repeat("f", 40), strings.Repeat("d", 40)
+	bLine := b + " 127.0.0.1:7001@17001 myself,master - 0 0 0 connected 100-199"
+	dLine := d + " 127.0.0.1:7003@17003 master - 0 0 0 connected"
+	if _, err := c.Receive([]string{"0", bLine, dLine}, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if info := c.Info(); info.KnownNodes != 1 {
+		t.Fatalf("expected a ping from an unknown node to be ignored, got %+v", info)
+	}
+	if id, err := c.Receive([]string{"3", bLine, dLine}, "", true); err != nil || id != b {
+		t.Fatalf("Receive = %q, %v", id, err)
+	}
+	if owner, _ := c.Owner(150); owner.ID != b {
+		t.Fatalf("expected b's slots to be learned, got %+v", owner)
+	}
+	if info := c.Info(); info.KnownNodes != 3 || info.CurrentEpoch != 4 || info.MyEpoch != 4 {
+		t.Fatalf("expected d to be introduced and the epoch collision resolved, got %+v", info)
+	}
+
+	// A claim with a newer config epoch wins the slot.
+	bLine = b + " 127.0.0.1:7001@17001 myself,master - 0 0 5 connected 50 100-199"
+	c.Receive([]string{"5", bLine}, "", false)
+	if owner, _ := c.Owner(50); owner.ID != b {
+		t.Fatalf("expected the newer claim to win slot 50, got %+v", owner)
+	}
+
+	now := time.Now()
+	if failed := c.CheckFailures(now.Add(2 * time.Second)); len(failed) != 0 {
+		t.Fatalf("expected no quorum from this node alone, got %v", failed)
+	}
+	if n := c.node(c.nodes[b]); !n.PFail {
+		t.Fatalf("expected b to be suspected, got %+v", n)
+	}
+	// d agrees b is failing, which with this node is a majority of the
+	// two masters serving slots.
+	pfail := b + " 127.0.0.1:7001@17001 master,fail? - 0 0 5 connected"
+	c.Receive([]string{"5", d + " 127.0.0.1:7003@17003 myself,master - 0 0 0 connected", pfail}, "", false)
+	if failed := c.CheckFailures(now.Add(2 * time.Second)); len(failed) != 1 || failed[0] != b {
+		t.Fatalf("expected b to fail, got %v", failed)
+	}
+	if info := c.Info(); info.State != "fail" || info.SlotsFail != 101 {
+		t.Fatalf("expected b's slots to be failing, got %+v", info)
+	}
+	// b serves slots, so it is not trusted again at once.
+	c.Receive([]string{"5", bLine}, "", false)
+	if n := c.node(c.nodes[b]); !n.Fail {
+		t.Fatalf("expected b to stay failed, got %+v", n)
+	}
+
+	c.MarkFailed(b, d)
+	if n := c.node(c.nodes[d]); !n.Fail {
+		t.Fatalf("expected a FAIL message to fail d, got %+v", n)
+	}
+	c.Receive([]string{"5", d + " 127.0.0.1:7003@17003 myself,master - 0 0 0 connected"}, "", false)
+	if n := c.node(c.nodes[d]); n.Fail {
+		t.Fatalf("expected d, which serves no slots, to recover once heard from, got %+v", n)
+	}
+}
@@ -0,0 +1,290 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// DefaultNodeTimeout is how long a node may go unheard from before it is
+// suspected of failing, Redis' cluster-node-timeout.
+const DefaultNodeTimeout = 15 * time.Second
+
+// failReportValidity is how many node timeouts a report that a node is
+// failing counts for, and failUndoTime how many must pass after a node
+// serving slots was flagged Fail before it is trusted again, as in Redis.
+const (
+	failReportValidity = 2
+	failUndoTime       = 2
+)
+
+// SetNodeTimeout sets the node timeout.
+func (c *Cluster) SetNodeTimeout(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nodeTimeout = d
+}
+
+// NodeTimeout returns the node timeout.
+func (c *Cluster) NodeTimeout() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodeTimeout
+}
+
+// SetMyHost records host as this node's address unless it has one, as a
+// node learns its IP from the connections other nodes reach it on.
+func (c *Cluster) SetMyHost(host string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.myself.Host != "" || host == "" {
+		return nil
+	}
+	c.myself.Host = host
+	return c.save()
+}
+
+// Message is what this node sends in a ping, a meet or a pong: the current
+// epoch, its own CLUSTER NODES line, which claims its slots, and the lines
+// of the other nodes it knows the address of, which gossip about their
+// health and introduce them to the receiver.
+func (c *Cluster) Message() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := []string{strconv.FormatUint(c.currentEpoch, 10)}
+	for _, n := range c.nodeList() {
+		if n.Myself || n.Host != "" {
+			msg = append(msg, c.nodeLine(n))
+		}
+	}
+	return msg
+}
+
+// Receive processes a Message from another node, returning its ID. host is
+// the address the node was reached at or connected from, used unless it
+// announces its own. A node only joins the cluster by a meet or by
+// answering one, so unless meet is set a message from an unknown node is
+// ignored.
+//
+// The sender's claims win the slots that are unassigned here or served by
+// a node with an older config epoch, except those this node is importing.
+// If the sender has this node's config epoch and a greater ID this node
+// takes a new epoch, so that conflicting claims resolve the same way
+// everywhere. The gossip is counted as reports that nodes are failing, or
+// no longer are, and introduces the nodes this one does not know.
+func (c *Cluster) Receive(msg []string, host string, meet bool) (string, error) {
+	if len(msg) < 2 {
+		return "", errors.New("message too short")
+	}
+	epoch, err := strconv.ParseUint(msg[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("bad current epoch %q", msg[0])
+	}
+	sender, _, err := parseNode(strings.Fields(msg[1]))
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sender.ID == c.myself.ID {
+		return "", errors.New("Can't meet myself")
+	}
+	now := time.Now()
+	n := c.nodes[sender.ID]
+	changed := false
+	if n == nil {
+		if !meet {
+			return sender.ID, nil
+		}
+		n = &Node{ID: sender.ID}
+		c.nodes[n.ID] = n
+		changed = true
+	}
+	if sender.Host == "" {
+		sender.Host = host
+	}
+	if n.Host != sender.Host || n.Port != sender.Port || n.BusPort != sender.BusPort || n.ConfigEpoch != sender.ConfigEpoch {
+		n.Host, n.Port, n.BusPort, n.ConfigEpoch = sender.Host, sender.Port, sender.BusPort, sender.ConfigEpoch
+		changed = true
+	}
+	c.heard(n, now)
+	if e := max(epoch, n.ConfigEpoch); e > c.currentEpoch {
+		c.currentEpoch = e
+		changed = true
+	}
+	if c.claim(n, sender.Slots) {
+		changed = true
+	}
+	if n.ConfigEpoch == c.myself.ConfigEpoch && n.ID > c.myself.ID {
+		c.currentEpoch++
+		c.myself.ConfigEpoch = c.currentEpoch
+		logging.Warnf("configEpoch collision with node %s. configEpoch set to %d", n.ID, c.currentEpoch)
+		changed = true
+	}
+	for _, line := range msg[2:] {
+		if c.gossip(n, line, now) {
+			changed = true
+		}
+	}
+	if changed {
+		return n.ID, c.save()
+	}
+	return n.ID, nil
+}
+
+// claim gives n the slots it claims that are unassigned here or served by
+// a node with an older config epoch, except those being imported, and
+// reports whether any changed hands. A migration from this node ends when
+// its target claims the slot. It is called with mu held.
+func (c *Cluster) claim(n *Node, slots []Range) bool {
+	changed := false
+	for _, r := range slots {
+		for slot := r.Start; slot <= r.End; slot++ {
+			owner := c.owner[slot]
+			if owner == n || c.importing[slot] != nil || owner != nil && owner.ConfigEpoch >= n.ConfigEpoch {
+				continue
+			}
+			if c.migrating[slot] == n {
+				c.migrating[slot] = nil
+			}
+			c.owner[slot] = n
+			changed = true
+		}
+	}
+	return changed
+}
+
+// gossip processes a line sender sent about another node, reporting
+// whether a node was added. It is called with mu held.
+func (c *Cluster) gossip(sender *Node, line string, now time.Time) bool {
+	g, _, err := parseNode(strings.Fields(line))
+	if err != nil || g.ID == c.myself.ID {
+		return false
+	}
+	n := c.nodes[g.ID]
+	if n == nil {
+		if g.PFail || g.Fail || g.Host == "" {
+			return false
+		}
+		c.nodes[g.ID] = &Node{ID: g.ID, Host: g.Host, Port: g.Port, BusPort: g.BusPort, seen: now}
+		return true
+	}
+	if g.PFail || g.Fail {
+		if n.failReports == nil {
+			n.failReports = make(map[string]time.Time)
+		}
+		n.failReports[sender.ID] = now
+	} else {
+		delete(n.failReports, sender.ID)
+	}
+	return false
+}
+
+// heard records that n is up. A node serving slots stays flagged Fail for
+// a while after it comes back, as in Redis. It is called with mu held.
+func (c *Cluster) heard(n *Node, now time.Time) {
+	n.seen = now
+	n.PFail = false
+	if n.Fail && (!c.serves(n) || now.Sub(n.failTime) > failUndoTime*c.nodeTimeout) {
+		logging.Infof("Clear FAIL state for node %s: is reachable again.", n.ID)
+		n.Fail = false
+	}
+}
+
+// serves reports whether n serves any slot. It is called with mu held.
+func (c *Cluster) serves(n *Node) bool {
+	for _, owner := range c.owner {
+		if owner == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Pinged records that a ping was sent to the node with id at t, unless an
+// earlier one is unanswered.
+func (c *Cluster) Pinged(id string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := c.nodes[id]; n != nil && n.PingSent.IsZero() {
+		n.PingSent = t
+	}
+}
+
+// Ponged records that the node with id answered a ping at t.
+func (c *Cluster) Ponged(id string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := c.nodes[id]; n != nil {
+		n.PingSent, n.PongReceived, n.LinkDown = time.Time{}, t, false
+	}
+}
+
+// Unreachable records that the node with id could not be pinged.
+func (c *Cluster) Unreachable(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := c.nodes[id]; n != nil {
+		n.LinkDown = true
+	}
+}
+
+// CheckFailures flags PFail the nodes not heard from for the node timeout,
+// and Fail those a majority of the masters serving slots, counting this
+// node, report failing. It returns the nodes newly flagged Fail, which the
+// caller tells the others about.
+func (c *Cluster) CheckFailures(now time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	serving := make(map[*Node]bool)
+	for _, n := range c.owner {
+		if n != nil {
+			serving[n] = true
+		}
+	}
+	quorum := len(serving)/2 + 1
+	var failed []string
+	for _, n := range c.nodes {
+		if n == c.myself || n.Fail {
+			continue
+		}
+		if now.Sub(n.seen) > c.nodeTimeout {
+			n.PFail = true
+		}
+		if !n.PFail {
+			continue
+		}
+		reports := 0
+		for id, at := range n.failReports {
+			if now.Sub(at) > failReportValidity*c.nodeTimeout {
+				delete(n.failReports, id)
+				continue
+			}
+			reports++
+		}
+		if reports+1 >= quorum {
+			logging.Infof("Marking node %s as failing (quorum reached).", n.ID)
+			n.PFail, n.Fail, n.failTime = false, true, now
+			failed = append(failed, n.ID)
+		}
+	}
+	return failed
+}
+
+// MarkFailed flags the node with id Fail, as the node with sender ID found
+// a majority agrees it is down.
+func (c *Cluster) MarkFailed(sender, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.nodes[id]
+	if c.nodes[sender] == nil || n == nil || n == c.myself || n.Fail {
+		return
+	}
+	logging.Infof("FAIL message received from %s about %s", sender, id)
+	n.PFail, n.Fail, n.failTime = false, true, time.Now()
+}
@@ -0,0 +1,86 @@
+// Package cluster holds the state of a Redis Cluster node: the hash slot a
+// key belongs to, the known nodes and which of them serves each slot. The
+// server exposes it through the CLUSTER command; the state survives restarts
+// in a nodes.conf file in Redis' format.
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Slots is the number of hash slots the keyspace is divided into.
+const Slots = 16384
+
+// KeySlot returns the hash slot of key: the CRC16 of the key modulo Slots.
+// If the key contains a hash tag, a non-empty substring between the first
+// '{' and the next '}', only the tag is hashed, so keys sharing a tag land
+// in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) & (Slots - 1))
+}
+
+// ParseSlot parses a slot number, rejecting ones out of range.
+func ParseSlot(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= Slots {
+		return 0, fmt.Errorf("invalid or out of range slot")
+	}
+	return n, nil
+}
+
+// Range is an inclusive range of slots.
+type Range struct {
+	Start, End int
+}
+
+func (r Range) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// ranges collapses ascending slots into ranges.
+func ranges(slots []int) []Range {
+	var out []Range
+	for _, slot := range slots {
+		if n := len(out); n > 0 && out[n-1].End == slot-1 {
+			out[n-1].End = slot
+			continue
+		}
+		out = append(out, Range{slot, slot})
+	}
+	return out
+}
+
+// crc16 is CRC-16/XMODEM (polynomial 0x1021, initial value 0), the variant
+// Redis Cluster uses.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+var crc16Table = func() (t [256]uint16) {
+	for i := range t {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
@@ -9,7 +9,7 @@ import (
 )
 
 type Handler interface {
-	Execute(store *store.Store, args []string) Response
+	Execute(store store.KV, args []string) Response
 }
 
 type Response struct {
@@ -28,9 +28,50 @@ const (
 	TypeNull
 	TypeError
 	TypeNestedArray
+	// TypeValue replies mix types; see protocol.Writer.WriteValue.
+	TypeValue
 )
 
+// Constructors of responses of each type, which cannot be given a Value of
+// another.
+
+func NewSimpleStringResponse(s string) Response {
+	return Response{Type: TypeSimpleString, Value: s}
+}
+
+func NewBulkStringResponse(s string) Response {
+	return Response{Type: TypeBulkString, Value: s}
+}
+
+func NewIntResponse(n int) Response {
+	return Response{Type: TypeInteger, Value: n}
+}
+
+func NewArrayResponse(elems []string) Response {
+	return Response{Type: TypeArray, Value: elems}
+}
+
+func NewNullResponse() Response {
+	return Response{Type: TypeNull}
+}
+
+func NewErrorResponse(err error) Response {
+	return Response{Type: TypeError, Error: err}
+}
+
+// NewScanResponse is the reply of SCAN and HSCAN: the next cursor and the
+// keys or fields found.
+func NewScanResponse(cursor string, keys []string) Response {
+	return Response{Type: TypeNestedArray, Value: map[string]interface{}{"cursor": cursor, "keys": keys}}
+}
+
+// WriteTo writes r as a RESP reply. A response whose Value does not fit its
+// Type, a handler's bug, is written as an error reply instead of panicking,
+// so that the client gets a reply and its connection stays in step.
 func (r Response) WriteTo(w *protocol.Writer) error {
+	if err := r.check(); err != nil {
+		return w.WriteError("ERR internal error: " + err.Error())
+	}
 	switch r.Type {
 	case TypeSimpleString:
 		return w.WriteSimpleString(r.Value.(string))
@@ -50,49 +91,210 @@ func (r Response) WriteTo(w *protocol.Writer) error {
 		cursor := data["cursor"].(string)
 		keys := data["keys"].([]string)
 		return w.WriteNestedArray(cursor, keys)
+	case TypeValue:
+		return w.WriteValue(r.Value)
 	default:
 		return fmt.Errorf("unknown response type")
 	}
 }
 
+// check returns why r cannot be written, if its Value is not what its Type
+// is written from.
+func (r Response) check() error {
+	ok := true
+	switch r.Type {
+	case TypeSimpleString, TypeBulkString:
+		_, ok = r.Value.(string)
+	case TypeInteger:
+		_, ok = r.Value.(int)
+	case TypeArray:
+		_, ok = r.Value.([]string)
+	case TypeNull:
+	case TypeError:
+		if r.Error == nil {
+			return fmt.Errorf("error response without an error")
+		}
+	case TypeNestedArray:
+		data, _ := r.Value.(map[string]interface{})
+		_, cursor := data["cursor"].(string)
+		_, keys := data["keys"].([]string)
+		ok = cursor && keys
+	case TypeValue:
+		return protocol.CheckValue(r.Value)
+	default:
+		return fmt.Errorf("unknown response type %d", r.Type)
+	}
+	if !ok {
+		return fmt.Errorf("response of type %d holding %T", r.Type, r.Value)
+	}
+	return nil
+}
+
 var handlers = map[string]Handler{
-	"PING":      &PingHandler{},
-	"ECHO":      &EchoHandler{},
-	"SET":       &SetHandler{},
-	"GET":       &GetHandler{},
-	"HSET":      &HSetHandler{},
-	"HGET":      &HGetHandler{},
-	"HDEL":      &HDelHandler{},
-	"HGETALL":   &HGetAllHandler{},
-	"LPUSH":     &LPushHandler{},
-	"RPUSH":     &RPushHandler{},
-	"LPOP":      &LPopHandler{},
-	"RPOP":      &RPopHandler{},
-	"LRANGE":    &LRangeHandler{},
-	"SADD":      &SAddHandler{},
-	"SMEMBERS":  &SMembersHandler{},
-	"SREM":      &SRemHandler{},
-	"SISMEMBER": &SISMemberHandler{},
-	"DEL":       &DelHandler{},
-	"EXISTS":    &ExistsHandler{},
-	"KEYS":      &KeysHandler{},
-	"SCAN":      &ScanHandler{},
-	"HSCAN":     &HScanHandler{},
-	"ZADD":      &ZAddHandler{},
-	"ZRANGE":    &ZRangeHandler{},
+	"PING":        &PingHandler{},
+	"ECHO":        &EchoHandler{},
+	"SET":         &SetHandler{},
+	"GET":         &GetHandler{},
+	"HSET":        &HSetHandler{},
+	"HGET":        &HGetHandler{},
+	"HDEL":        &HDelHandler{},
+	"HGETALL":     &HGetAllHandler{},
+	"LPUSH":       &LPushHandler{},
+	"RPUSH":       &RPushHandler{},
+	"LPOP":        &LPopHandler{},
+	"RPOP":        &RPopHandler{},
+	"LRANGE":      &LRangeHandler{},
+	"SADD":        &SAddHandler{},
+	"SMEMBERS":    &SMembersHandler{},
+	"SREM":        &SRemHandler{},
+	"SISMEMBER":   &SISMemberHandler{},
+	"DEL":         &DelHandler{},
+	"EXISTS":      &ExistsHandler{},
+	"KEYS":        &KeysHandler{},
+	"SCAN":        &ScanHandler{},
+	"HSCAN":       &HScanHandler{},
+	"ZADD":        &ZAddHandler{},
+	"ZRANGE":      &ZRangeHandler{},
+	"OBJECT":      &ObjectHandler{},
+	"FLUSHDB":     &FlushDBHandler{},
+	"FLUSHALL":    &FlushDBHandler{},
+	"INFO":        &InfoHandler{},
+	"PEXPIREAT":   &PExpireAtHandler{},
+	"DUMP":        &DumpHandler{},
+	"RESTORE":     &RestoreHandler{},
+	"RENAME":      &RenameHandler{},
+	"RENAMENX":    &RenameHandler{NX: true},
+	"SMOVE":       &SMoveHandler{},
+	"LMOVE":       &LMoveHandler{},
+	"SINTERSTORE": &SInterStoreHandler{},
 }
 
 // TODO: Add handlers for other data types (HSET/HGET for hashes, LPUSH/LRANGE for lists,
 // SADD/SMEMBERS for sets, ZADD/ZRANGE for sorted sets). Ensure handlers perform
 // type checks and return appropriate errors when the key exists with a different type.
 
-func Execute(s *store.Store, cmd string, args []string) Response {
-	handler, ok := handlers[strings.ToUpper(cmd)]
+// denyOOM lists commands that can grow the dataset. When maxmemory is set they
+// trigger eviction first and are refused if memory cannot be reclaimed.
+var denyOOM = map[string]bool{
+	"SET":         true,
+	"HSET":        true,
+	"LPUSH":       true,
+	"RPUSH":       true,
+	"LMOVE":       true,
+	"SADD":        true,
+	"SMOVE":       true,
+	"SINTERSTORE": true,
+	"ZADD":        true,
+	"RESTORE":     true,
+}
+
+// lockAll lists the commands that change every key, which lock them all
+// rather than those they name, so that no write to a key can be applied
+// before them and logged after them.
+var lockAll = map[string]bool{
+	"FLUSHDB":  true,
+	"FLUSHALL": true,
+}
+
+// writeCommands lists the commands that may modify the dataset. They are
+// logged to the AOF and propagated to replicas, and read-only replicas
+// refuse them from clients.
+var writeCommands = map[string]bool{
+	"SET":         true,
+	"DEL":         true,
+	"HSET":        true,
+	"HDEL":        true,
+	"LPUSH":       true,
+	"RPUSH":       true,
+	"LPOP":        true,
+	"RPOP":        true,
+	"SADD":        true,
+	"SREM":        true,
+	"ZADD":        true,
+	"FLUSHDB":     true,
+	"FLUSHALL":    true,
+	"PEXPIREAT":   true,
+	"RESTORE":     true,
+	"RENAME":      true,
+	"RENAMENX":    true,
+	"SMOVE":       true,
+	"LMOVE":       true,
+	"SINTERSTORE": true,
+}
+
+// Keys returns the keys among the arguments of the upper-cased command, as
+// cluster mode routes commands by them and Execute locks them, from the
+// positions in the command table. Server commands have none.
+func Keys(cmd string, args []string) []string {
+	if handlers[cmd] == nil {
+		return nil
+	}
+	return specs[cmd].keys.of(args)
+}
+
+// IsWrite reports whether the upper-cased command may modify the dataset.
+func IsWrite(cmd string) bool {
+	return writeCommands[cmd]
+}
+
+// IsKeyspace reports whether the upper-cased command is a keyspace command,
+// one Execute runs on the store.
+func IsKeyspace(cmd string) bool {
+	_, ok := handlers[cmd]
+	return ok
+}
+
+// IsReadOnly reports whether the upper-cased command is a keyspace command
+// that never modifies the dataset.
+func IsReadOnly(cmd string) bool {
+	_, ok := handlers[cmd]
+	return ok && !writeCommands[cmd]
+}
+
+func Execute(s store.KV, cmd string, args []string) Response {
+	return ExecuteThen(s, cmd, args, nil)
+}
+
+// ExecuteThen is Execute, calling then, if not nil, with the response while
+// the command's keys are still locked: what it does with a write, like
+// logging and propagating it, is then ordered as the writes were applied.
+func ExecuteThen(s store.KV, cmd string, args []string, then func(Response)) Response {
+	name := strings.ToUpper(cmd)
+	handler, ok := handlers[name]
 	if !ok {
 		return Response{
 			Type:  TypeError,
 			Error: fmt.Errorf("ERR unknown command '%s'", cmd),
 		}
 	}
-	return handler.Execute(s, args)
+	if l, ok := s.(store.Limiter); ok && denyOOM[name] && len(args) > 0 {
+		values := args[1:]
+		if name == "RESTORE" {
+			// Its payload is not what it stores: the handler checks the
+			// decoded entry instead.
+			values = nil
+		}
+		if err := l.CheckLimits(args[0], values...); err != nil {
+			return Response{Type: TypeError, Error: err}
+		}
+	}
+	if ev, ok := s.(store.Evictor); ok && denyOOM[name] {
+		if err := ev.EvictIfNeeded(); err != nil {
+			return Response{Type: TypeError, Error: err}
+		}
+	}
+	// Locking the command's keys for its whole run makes the ones built
+	// from several engine calls atomic, like RENAME or SMOVE.
+	if l, ok := s.(store.KeyLocker); ok {
+		if lockAll[name] {
+			defer l.LockAll()()
+		} else {
+			defer l.LockKeys(Keys(name, args), writeCommands[name])()
+		}
+	}
+	response := handler.Execute(s, args)
+	if then != nil {
+		then(response)
+	}
+	return response
 }
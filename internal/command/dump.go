@@ -0,0 +1,96 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/store"
+)
+
+// DUMP handler: serializes the value at key, without its TTL, in the dump
+// format, for RESTORE on this or another server.
+// Usage: DUMP key
+type DumpHandler struct{}
+
+func (h *DumpHandler) Execute(kv store.KV, args []string) Response {
+	if len(args) != 1 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'dump' command")}
+	}
+	s, ok := kv.(store.EntryStore)
+	if !ok {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR DUMP is not supported by this storage backend")}
+	}
+	e, ok := s.Lookup(args[0])
+	if !ok {
+		return Response{Type: TypeNull}
+	}
+	payload, err := store.DumpValue(e)
+	if err != nil {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR %v", err)}
+	}
+	return Response{Type: TypeBulkString, Value: string(payload)}
+}
+
+// RESTORE handler: creates key from a DUMP payload. ttl is in milliseconds,
+// 0 for none, or a Unix time in milliseconds with ABSTTL. An existing key
+// is only replaced with REPLACE.
+// Usage: RESTORE key ttl payload [REPLACE] [ABSTTL]
+type RestoreHandler struct{}
+
+func (h *RestoreHandler) Execute(kv store.KV, args []string) Response {
+	if len(args) < 3 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'restore' command")}
+	}
+	var replace, absTTL bool
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(opt) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return Response{Type: TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+	}
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+	}
+	if ttl < 0 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR Invalid TTL value, must be >= 0")}
+	}
+	s, ok := kv.(store.EntryStore)
+	if !ok {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR RESTORE is not supported by this storage backend")}
+	}
+	if !replace && kv.Exists(args[0]) > 0 {
+		return Response{Type: TypeError, Error: fmt.Errorf("BUSYKEY Target key name already exists.")}
+	}
+	e, err := store.LoadValue([]byte(args[2]))
+	if err != nil {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR DUMP payload version or checksum are wrong")}
+	}
+	e.Key = args[0]
+	if l, ok := kv.(store.Limiter); ok {
+		if err := l.CheckEntry(e); err != nil {
+			return Response{Type: TypeError, Error: err}
+		}
+	}
+	if ttl > 0 {
+		at := time.UnixMilli(ttl)
+		if !absTTL {
+			at = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		if !at.After(time.Now()) {
+			// Already expired: the key is not created, and one it would
+			// have replaced is gone.
+			kv.Delete(args[0])
+			return Response{Type: TypeSimpleString, Value: "OK"}
+		}
+		e.Expiry = &at
+	}
+	s.Put(e)
+	return Response{Type: TypeSimpleString, Value: "OK"}
+}
@@ -0,0 +1,31 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"redis-from-scratch/internal/store"
+)
+
+// PEXPIREAT handler: sets an absolute deadline in Unix milliseconds.
+// Usage: PEXPIREAT key ms-timestamp
+type PExpireAtHandler struct{}
+
+func (h *PExpireAtHandler) Execute(kv store.KV, args []string) Response {
+	if len(args) != 2 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'pexpireat' command")}
+	}
+	ms, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+	}
+	s, ok := kv.(store.Expirer)
+	if !ok {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR PEXPIREAT is not supported by this storage backend")}
+	}
+	if !s.ExpireAt(args[0], time.UnixMilli(ms)) {
+		return Response{Type: TypeInteger, Value: 0}
+	}
+	return Response{Type: TypeInteger, Value: 1}
+}
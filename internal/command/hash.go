@@ -8,24 +8,26 @@ import (
 
 type HSetHandler struct{}
 
-func (h *HSetHandler) Execute(s *store.Store, args []string) Response {
-	if len(args) < 3 {
+func (h *HSetHandler) Execute(s store.KV, args []string) Response {
+	if len(args) < 3 || len(args)%2 != 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'hset' command")}
 	}
 	key := args[0]
-	field := args[1]
-	value := args[2]
 
-	n, err := s.HashSet(key, field, value)
-	if err != nil {
-		return Response{Type: TypeError, Error: err}
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		n, err := s.HashSet(key, args[i], args[i+1])
+		if err != nil {
+			return Response{Type: TypeError, Error: err}
+		}
+		added += n
 	}
-	return Response{Type: TypeInteger, Value: n}
+	return Response{Type: TypeInteger, Value: added}
 }
 
 type HGetHandler struct{}
 
-func (h *HGetHandler) Execute(s *store.Store, args []string) Response {
+func (h *HGetHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'hget' command")}
 	}
@@ -44,7 +46,7 @@ func (h *HGetHandler) Execute(s *store.Store, args []string) Response {
 
 type HDelHandler struct{}
 
-func (h *HDelHandler) Execute(s *store.Store, args []string) Response {
+func (h *HDelHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'hdel' command")}
 	}
@@ -59,7 +61,7 @@ func (h *HDelHandler) Execute(s *store.Store, args []string) Response {
 
 type HGetAllHandler struct{}
 
-func (h *HGetAllHandler) Execute(s *store.Store, args []string) Response {
+func (h *HGetAllHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'hgetall' command")}
 	}
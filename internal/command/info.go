@@ -0,0 +1,75 @@
+package command
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"redis-from-scratch/internal/store"
+)
+
+// InfoSection renders one INFO section body, one "field:value" per line.
+// Sections other than the keyspace are rendered by the server, from state
+// this package does not see.
+type InfoSection struct {
+	Name   string
+	Title  string // the header, Name capitalized if empty
+	Render func() string
+	Extra  bool // only returned when named, or with "all" or "everything"
+}
+
+// INFO handler. Usage: INFO [section ...]
+// With no argument or "default", every section but the extra ones is
+// returned; with "all" or "everything", every section.
+type InfoHandler struct{}
+
+func (h *InfoHandler) Execute(s store.KV, args []string) Response {
+	return Info(s, args)
+}
+
+// Info renders INFO for args: the extra sections in the order given, then
+// the keyspace, which Redis always prints last.
+func Info(s store.KV, args []string, extra ...InfoSection) Response {
+	want := make(map[string]bool)
+	for _, a := range args {
+		want[strings.ToLower(a)] = true
+	}
+	all := want["all"] || want["everything"]
+	defaults := len(want) == 0 || want["default"]
+
+	sections := append(slices.Clip(extra), InfoSection{Name: "keyspace", Render: func() string { return infoKeyspace(s) }})
+	var b strings.Builder
+	for _, sec := range sections {
+		if !all && !want[sec.Name] && (!defaults || sec.Extra) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		title := sec.Title
+		if title == "" {
+			title = strings.ToUpper(sec.Name[:1]) + sec.Name[1:]
+		}
+		fmt.Fprintf(&b, "# %s\r\n", title)
+		b.WriteString(sec.Render())
+	}
+	return Response{Type: TypeBulkString, Value: b.String()}
+}
+
+// infoKeyspace reports the single database in Redis' dbN format, followed by
+// per-type key counts when the engine tracks them.
+func infoKeyspace(s store.KV) string {
+	r, ok := s.(store.StatsReporter)
+	if !ok {
+		if n := s.Size(); n > 0 {
+			return fmt.Sprintf("db0:keys=%d,expires=0,avg_ttl=0\r\n", n)
+		}
+		return ""
+	}
+	st := r.Stats()
+	if st.Keys == 0 {
+		return ""
+	}
+	return fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=0,strings=%d,hashes=%d,lists=%d,sets=%d,zsets=%d\r\n",
+		st.Keys, st.Expires, st.Strings, st.Hashes, st.Lists, st.Sets, st.ZSets)
+}
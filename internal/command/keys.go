@@ -2,9 +2,10 @@ package command
 
 import (
 	"fmt"
-	"path/filepath"
 	"strconv"
+	"strings"
 
+	"redis-from-scratch/internal/glob"
 	"redis-from-scratch/internal/store"
 )
 
@@ -17,21 +18,16 @@ func NewPatternMatcher(pattern string) *PatternMatcher {
 	return &PatternMatcher{pattern: pattern}
 }
 
-// Match checks if a key matches the pattern using glob-style matching
+// Match checks if a key matches the pattern using Redis glob-style matching
 // Supports: * (any chars), ? (single char), [abc] (char class), [^abc] (negated class)
 func (pm *PatternMatcher) Match(key string) bool {
-	ok, err := filepath.Match(pm.pattern, key)
-	if err != nil {
-		// Invalid pattern, no matches
-		return false
-	}
-	return ok
+	return glob.Match(pm.pattern, key)
 }
 
 // Updated KeysHandler with pattern support
 type KeysHandler struct{}
 
-func (h *KeysHandler) Execute(s *store.Store, args []string) Response {
+func (h *KeysHandler) Execute(s store.KV, args []string) Response {
 	pattern := "*"
 	if len(args) > 0 {
 		pattern = args[0]
@@ -43,7 +39,7 @@ func (h *KeysHandler) Execute(s *store.Store, args []string) Response {
 // DEL handler
 type DelHandler struct{}
 
-func (h *DelHandler) Execute(s *store.Store, args []string) Response {
+func (h *DelHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'del' command")}
 	}
@@ -51,10 +47,48 @@ func (h *DelHandler) Execute(s *store.Store, args []string) Response {
 	return Response{Type: TypeInteger, Value: n}
 }
 
+// RENAME handler: moves the value at key, with its TTL, to newkey,
+// replacing any value there. RENAMENX only renames if newkey does not
+// exist, replying 1 if it did.
+// Usage: RENAME key newkey, RENAMENX key newkey
+type RenameHandler struct {
+	NX bool
+}
+
+func (h *RenameHandler) Execute(kv store.KV, args []string) Response {
+	name := "rename"
+	if h.NX {
+		name = "renamenx"
+	}
+	if len(args) != 2 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for '%s' command", name)}
+	}
+	s, ok := kv.(store.EntryStore)
+	if !ok {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR %s is not supported by this storage backend", strings.ToUpper(name))}
+	}
+	e, ok := s.Lookup(args[0])
+	if !ok {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR no such key")}
+	}
+	if h.NX && kv.Exists(args[1]) > 0 {
+		return Response{Type: TypeInteger, Value: 0}
+	}
+	if args[1] != args[0] {
+		e.Key = args[1]
+		s.Put(e)
+		kv.Delete(args[0])
+	}
+	if h.NX {
+		return Response{Type: TypeInteger, Value: 1}
+	}
+	return Response{Type: TypeSimpleString, Value: "OK"}
+}
+
 // EXISTS handler
 type ExistsHandler struct{}
 
-func (h *ExistsHandler) Execute(s *store.Store, args []string) Response {
+func (h *ExistsHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'exists' command")}
 	}
@@ -62,10 +96,33 @@ func (h *ExistsHandler) Execute(s *store.Store, args []string) Response {
 	return Response{Type: TypeInteger, Value: n}
 }
 
+// FLUSHDB handler: removes every key. Usage: FLUSHDB [ASYNC|SYNC]
+// Both modes only unlink the keyspace; the garbage collector reclaims it.
+type FlushDBHandler struct{}
+
+func (h *FlushDBHandler) Execute(s store.KV, args []string) Response {
+	if len(args) > 1 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'flushdb' command")}
+	}
+	if len(args) == 1 {
+		switch strings.ToUpper(args[0]) {
+		case "ASYNC", "SYNC":
+		default:
+			return Response{Type: TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+	}
+	f, ok := s.(store.Flusher)
+	if !ok {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR FLUSHDB is not supported by this storage backend")}
+	}
+	f.Flush()
+	return Response{Type: TypeSimpleString, Value: "OK"}
+}
+
 // SCAN handler - implements cursor-based iteration
 type ScanHandler struct{}
 
-func (h *ScanHandler) Execute(s *store.Store, args []string) Response {
+func (h *ScanHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'scan' command")}
 	}
@@ -111,19 +168,13 @@ func (h *ScanHandler) Execute(s *store.Store, args []string) Response {
 	}
 
 	// Response format: [nextCursor, [keys...]] - nested array
-	return Response{
-		Type: TypeNestedArray,
-		Value: map[string]interface{}{
-			"cursor": fmt.Sprintf("%d", nextCursor),
-			"keys":   keys,
-		},
-	}
+	return NewScanResponse(fmt.Sprintf("%d", nextCursor), keys)
 }
 
 // HSCAN handler for scanning hash fields
 type HScanHandler struct{}
 
-func (h *HScanHandler) Execute(s *store.Store, args []string) Response {
+func (h *HScanHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'hscan' command")}
 	}
@@ -169,13 +220,7 @@ func (h *HScanHandler) Execute(s *store.Store, args []string) Response {
 	}
 
 	// Response format: [nextCursor, [fields...]] - nested array
-	return Response{
-		Type: TypeNestedArray,
-		Value: map[string]interface{}{
-			"cursor": fmt.Sprintf("%d", nextCursor),
-			"keys":   fields,
-		},
-	}
+	return NewScanResponse(fmt.Sprintf("%d", nextCursor), fields)
 }
 
 // Register SCAN handlers
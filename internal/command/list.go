@@ -2,13 +2,14 @@ package command
 
 import (
 	"fmt"
+	"strings"
 
 	"redis-from-scratch/internal/store"
 )
 
 type LPushHandler struct{}
 
-func (h *LPushHandler) Execute(s *store.Store, args []string) Response {
+func (h *LPushHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'lpush' command")}
 	}
@@ -23,7 +24,7 @@ func (h *LPushHandler) Execute(s *store.Store, args []string) Response {
 
 type RPushHandler struct{}
 
-func (h *RPushHandler) Execute(s *store.Store, args []string) Response {
+func (h *RPushHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'rpush' command")}
 	}
@@ -38,7 +39,7 @@ func (h *RPushHandler) Execute(s *store.Store, args []string) Response {
 
 type LPopHandler struct{}
 
-func (h *LPopHandler) Execute(s *store.Store, args []string) Response {
+func (h *LPopHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'lpop' command")}
 	}
@@ -55,7 +56,7 @@ func (h *LPopHandler) Execute(s *store.Store, args []string) Response {
 
 type RPopHandler struct{}
 
-func (h *RPopHandler) Execute(s *store.Store, args []string) Response {
+func (h *RPopHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'rpop' command")}
 	}
@@ -72,7 +73,7 @@ func (h *RPopHandler) Execute(s *store.Store, args []string) Response {
 
 type LRangeHandler struct{}
 
-func (h *LRangeHandler) Execute(s *store.Store, args []string) Response {
+func (h *LRangeHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 3 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'lrange' command")}
 	}
@@ -93,3 +94,43 @@ func (h *LRangeHandler) Execute(s *store.Store, args []string) Response {
 	}
 	return Response{Type: TypeArray, Value: arr}
 }
+
+// LMOVE handler: pops an element from the wherefrom end of the list at
+// source and pushes it onto the whereto end of the one at destination,
+// replying with the element.
+// Usage: LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+type LMoveHandler struct{}
+
+func (h *LMoveHandler) Execute(s store.KV, args []string) Response {
+	if len(args) != 4 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'lmove' command")}
+	}
+	src, dst := args[0], args[1]
+	from, to := strings.ToUpper(args[2]), strings.ToUpper(args[3])
+	if from != "LEFT" && from != "RIGHT" || to != "LEFT" && to != "RIGHT" {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR syntax error")}
+	}
+	// Checked before anything changes, so a WRONGTYPE destination keeps
+	// the element in the source.
+	if _, err := s.ListRange(dst, 0, 0); err != nil {
+		return Response{Type: TypeError, Error: err}
+	}
+	pop, push := s.ListLPop, s.ListLPush
+	if from == "RIGHT" {
+		pop = s.ListRPop
+	}
+	if to == "RIGHT" {
+		push = s.ListRPush
+	}
+	val, ok, err := pop(src)
+	if err != nil {
+		return Response{Type: TypeError, Error: err}
+	}
+	if !ok {
+		return Response{Type: TypeNull}
+	}
+	if _, err := push(dst, val); err != nil {
+		return Response{Type: TypeError, Error: err}
+	}
+	return Response{Type: TypeBulkString, Value: val}
+}
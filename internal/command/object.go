@@ -0,0 +1,57 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"redis-from-scratch/internal/store"
+)
+
+// OBJECT handler: inspects the internal representation of a key.
+// Usage: OBJECT ENCODING|FREQ|IDLETIME key
+type ObjectHandler struct{}
+
+func (h *ObjectHandler) Execute(kv store.KV, args []string) Response {
+	if len(args) < 1 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'object' command")}
+	}
+	s, ok := kv.(store.ObjectInspector)
+	if !ok {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR OBJECT is not supported by this storage backend")}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ENCODING":
+		if len(args) != 2 {
+			return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'object|encoding' command")}
+		}
+		enc, ok := s.ObjectEncoding(args[1])
+		if !ok {
+			return Response{Type: TypeNull}
+		}
+		return Response{Type: TypeBulkString, Value: enc}
+	case "FREQ":
+		if len(args) != 2 {
+			return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'object|freq' command")}
+		}
+		freq, ok, err := s.ObjectFreq(args[1])
+		if err != nil {
+			return Response{Type: TypeError, Error: err}
+		}
+		if !ok {
+			return Response{Type: TypeNull}
+		}
+		return Response{Type: TypeInteger, Value: freq}
+	case "IDLETIME":
+		if len(args) != 2 {
+			return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'object|idletime' command")}
+		}
+		idle, ok := s.ObjectIdleTime(args[1])
+		if !ok {
+			return Response{Type: TypeNull}
+		}
+		return Response{Type: TypeInteger, Value: int(idle)}
+	default:
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'", args[0])}
+	}
+}
@@ -0,0 +1,112 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// noopIfZero lists write commands whose integer reply counts the changes
+// they made, so a reply of 0 means the dataset was not modified.
+var noopIfZero = map[string]bool{
+	"DEL":       true,
+	"HDEL":      true,
+	"SADD":      true,
+	"SREM":      true,
+	"PEXPIREAT": true,
+}
+
+// Changed reports whether a write command that produced resp modified the
+// dataset, and so should be logged to the AOF or propagated to replicas.
+// Errors never change anything, nor does popping from a missing list.
+func Changed(cmd string, resp Response) bool {
+	switch resp.Type {
+	case TypeError:
+		return false
+	case TypeNull:
+		return cmd != "LPOP" && cmd != "RPOP"
+	case TypeInteger:
+		return !noopIfZero[cmd] || resp.Value.(int) != 0
+	}
+	return true
+}
+
+// NormalizeExpiry returns the form of a write command to log to the AOF or
+// propagate to replicas. Relative expirations are replaced by absolute
+// deadlines computed from now, so replaying the command later reproduces the
+// original expiry instead of restarting the TTL. args excludes the command
+// name and is not modified.
+func NormalizeExpiry(cmd string, args []string, now time.Time) []string {
+	if cmd == "RESTORE" {
+		return normalizeRestore(args, now)
+	}
+	if cmd != "SET" {
+		return args
+	}
+	var out []string
+	for i := 2; i+1 < len(args); i += 2 {
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			continue
+		}
+		var ms int64
+		switch strings.ToUpper(args[i]) {
+		case "PX":
+			ms = n
+		case "EX":
+			ms = n * 1000
+		default:
+			continue
+		}
+		if out == nil {
+			out = append([]string(nil), args...)
+		}
+		out[i] = "PXAT"
+		out[i+1] = strconv.FormatInt(now.UnixMilli()+ms, 10)
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
+
+// DefaultExpiry returns the arguments of a client's SET with ttl, the
+// cache-mode default, added as PX when it sets no expiry of its own. The
+// server resolves it before running the command, so that NormalizeExpiry
+// logs and propagates the deadline it drew rather than each replica or AOF
+// replay drawing its own. args excludes the command name and is not
+// modified.
+func DefaultExpiry(cmd string, args []string, ttl time.Duration) []string {
+	if cmd != "SET" || ttl <= 0 {
+		return args
+	}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "PX", "EX", "PXAT", "EXAT":
+			return args
+		}
+	}
+	out := make([]string, len(args), len(args)+2)
+	copy(out, args)
+	return append(out, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+}
+
+// normalizeRestore turns the relative TTL of RESTORE key ttl payload into a
+// deadline with ABSTTL.
+func normalizeRestore(args []string, now time.Time) []string {
+	if len(args) < 3 || args[1] == "0" {
+		return args
+	}
+	for _, opt := range args[3:] {
+		if strings.EqualFold(opt, "ABSTTL") {
+			return args
+		}
+	}
+	ms, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return args
+	}
+	out := append([]string(nil), args...)
+	out[1] = strconv.FormatInt(now.UnixMilli()+ms, 10)
+	return append(out, "ABSTTL")
+}
@@ -8,7 +8,7 @@ import (
 
 type SAddHandler struct{}
 
-func (h *SAddHandler) Execute(s *store.Store, args []string) Response {
+func (h *SAddHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'sadd' command")}
 	}
@@ -23,7 +23,7 @@ func (h *SAddHandler) Execute(s *store.Store, args []string) Response {
 
 type SMembersHandler struct{}
 
-func (h *SMembersHandler) Execute(s *store.Store, args []string) Response {
+func (h *SMembersHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'smembers' command")}
 	}
@@ -37,7 +37,7 @@ func (h *SMembersHandler) Execute(s *store.Store, args []string) Response {
 
 type SRemHandler struct{}
 
-func (h *SRemHandler) Execute(s *store.Store, args []string) Response {
+func (h *SRemHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'srem' command")}
 	}
@@ -52,7 +52,7 @@ func (h *SRemHandler) Execute(s *store.Store, args []string) Response {
 
 type SISMemberHandler struct{}
 
-func (h *SISMemberHandler) Execute(s *store.Store, args []string) Response {
+func (h *SISMemberHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR : wrong number of arguments for 'sismember' command")}
 	}
@@ -65,6 +65,72 @@ func (h *SISMemberHandler) Execute(s *store.Store, args []string) Response {
 	return Response{Type: TypeInteger, Value: boolToInt(ok)}
 }
 
+// SMOVE handler: moves member from the set at source to the one at
+// destination, replying 1 if it was in source.
+// Usage: SMOVE source destination member
+type SMoveHandler struct{}
+
+func (h *SMoveHandler) Execute(s store.KV, args []string) Response {
+	if len(args) != 3 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'smove' command")}
+	}
+	src, dst, member := args[0], args[1], args[2]
+	found, err := s.SetIsMember(src, member)
+	if err != nil {
+		return Response{Type: TypeError, Error: err}
+	}
+	// Checked before anything changes, so a WRONGTYPE destination leaves
+	// the source alone.
+	if _, err := s.SetIsMember(dst, member); err != nil {
+		return Response{Type: TypeError, Error: err}
+	}
+	if !found {
+		return Response{Type: TypeInteger, Value: 0}
+	}
+	if src != dst {
+		s.SetRemove(src, member)
+		s.SetAdd(dst, member)
+	}
+	return Response{Type: TypeInteger, Value: 1}
+}
+
+// SINTERSTORE handler: stores the intersection of the sets at the keys in
+// destination, replacing it, and replies with its size. A missing key is an
+// empty set.
+// Usage: SINTERSTORE destination key [key ...]
+type SInterStoreHandler struct{}
+
+func (h *SInterStoreHandler) Execute(s store.KV, args []string) Response {
+	if len(args) < 2 {
+		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'sinterstore' command")}
+	}
+	var inter map[string]bool
+	for _, key := range args[1:] {
+		members, err := s.SetMembers(key)
+		if err != nil {
+			return Response{Type: TypeError, Error: err}
+		}
+		next := make(map[string]bool, len(members))
+		for _, m := range members {
+			if inter == nil || inter[m] {
+				next[m] = true
+			}
+		}
+		inter = next
+	}
+	members := make([]string, 0, len(inter))
+	for m := range inter {
+		members = append(members, m)
+	}
+	s.Delete(args[0])
+	if len(members) > 0 {
+		if _, err := s.SetAdd(args[0], members...); err != nil {
+			return Response{Type: TypeError, Error: err}
+		}
+	}
+	return Response{Type: TypeInteger, Value: len(members)}
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -4,13 +4,14 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"redis-from-scratch/internal/store"
 )
 
 type PingHandler struct{}
 
-func (h *PingHandler) Execute(s *store.Store, args []string) Response {
+func (h *PingHandler) Execute(s store.KV, args []string) Response {
 	if len(args) == 0 {
 		return Response{Type: TypeSimpleString, Value: "PONG"}
 	}
@@ -19,7 +20,7 @@ func (h *PingHandler) Execute(s *store.Store, args []string) Response {
 
 type EchoHandler struct{}
 
-func (h *EchoHandler) Execute(s *store.Store, args []string) Response {
+func (h *EchoHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'echo' command")}
 	}
@@ -28,13 +29,16 @@ func (h *EchoHandler) Execute(s *store.Store, args []string) Response {
 
 type SetHandler struct{}
 
-func (h *SetHandler) Execute(s *store.Store, args []string) Response {
+func (h *SetHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 2 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'set' command")}
 	}
 
 	key, value := args[0], args[1]
-	var expireMs int64
+	var (
+		expireMs int64
+		deadline int64 // absolute, in Unix milliseconds
+	)
 
 	for i := 2; i < len(args); i += 2 {
 		if i+1 >= len(args) {
@@ -42,19 +46,32 @@ func (h *SetHandler) Execute(s *store.Store, args []string) Response {
 		}
 
 		option := strings.ToUpper(args[i])
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
 		switch option {
-		case "PX":
-			var err error
-			expireMs, err = strconv.ParseInt(args[i+1], 10, 64)
-			if err != nil {
-				return Response{Type: TypeError, Error: fmt.Errorf("ERR invalid expire time")}
+		case "PX", "EX", "PXAT", "EXAT":
+			if err != nil || n <= 0 {
+				return Response{Type: TypeError, Error: fmt.Errorf("ERR invalid expire time in 'set' command")}
 			}
+		}
+		switch option {
+		case "PX":
+			expireMs = n
 		case "EX":
-			seconds, err := strconv.ParseInt(args[i+1], 10, 64)
-			if err != nil {
-				return Response{Type: TypeError, Error: fmt.Errorf("ERR invalid expire time")}
-			}
-			expireMs = seconds * 1000
+			expireMs = n * 1000
+		case "PXAT":
+			deadline = n
+		case "EXAT":
+			deadline = n * 1000
+		}
+	}
+
+	if deadline > 0 {
+		// A deadline that has already passed leaves nothing behind, as the
+		// key would be expired on the next access anyway.
+		expireMs = deadline - time.Now().UnixMilli()
+		if expireMs <= 0 {
+			s.Delete(key)
+			return Response{Type: TypeSimpleString, Value: "OK"}
 		}
 	}
 
@@ -64,7 +81,7 @@ func (h *SetHandler) Execute(s *store.Store, args []string) Response {
 
 type GetHandler struct{}
 
-func (h *GetHandler) Execute(s *store.Store, args []string) Response {
+func (h *GetHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 1 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'get' command")}
 	}
@@ -76,5 +93,40 @@ func (h *GetHandler) Execute(s *store.Store, args []string) Response {
 	return Response{Type: TypeBulkString, Value: value}
 }
 
+// GetString and SetString are GET key and SET key value without options,
+// for the server's fast path: they make the checks and take the key lock
+// Execute would, without the handler table or a Response.
+func GetString(s store.KV, key string) (string, bool) {
+	if l, ok := s.(store.KeyLocker); ok {
+		l.LockKey(key, false)
+		defer l.UnlockKey(key, false)
+	}
+	return s.Get(key)
+}
+
+// SetString sets the key and value in args, then calls then, if not nil,
+// with the key still locked, as ExecuteThen does; see GetString.
+func SetString(s store.KV, args []string, then func()) error {
+	if l, ok := s.(store.Limiter); ok {
+		if err := l.CheckLimits(args[0], args[1:]...); err != nil {
+			return err
+		}
+	}
+	if ev, ok := s.(store.Evictor); ok {
+		if err := ev.EvictIfNeeded(); err != nil {
+			return err
+		}
+	}
+	if l, ok := s.(store.KeyLocker); ok {
+		l.LockKey(args[0], true)
+		defer l.UnlockKey(args[0], true)
+	}
+	s.Set(args[0], args[1], 0)
+	if then != nil {
+		then()
+	}
+	return nil
+}
+
 // TODO: Add handlers for hash/list/set/zset commands in separate files.
 // For example, create `hash.go` with HSET/HGET/HDEL and corresponding store methods.
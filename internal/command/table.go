@@ -0,0 +1,189 @@
+package command
+
+import (
+	"sort"
+	"strings"
+)
+
+// Spec describes a command as COMMAND reports it, after Redis' command
+// table.
+type Spec struct {
+	// Name is lower-case, as Redis reports it.
+	Name string
+	// Arity is the number of arguments, the name included, or minus the
+	// least number for a variable number.
+	Arity int
+	Flags []string
+	// FirstKey and LastKey are the positions of the first and last keys
+	// among the arguments, the name at 0, every Step; LastKey is negative
+	// to count from the end. They are 0 for commands without keys.
+	FirstKey, LastKey, Step int
+	// Group and Summary are COMMAND DOCS'.
+	Group, Summary string
+}
+
+// Keys returns the keys among args, which leave out the command's name, by
+// their positions.
+func (sp Spec) Keys(args []string) []string {
+	return keyRange{sp.FirstKey, sp.LastKey, sp.Step}.of(args)
+}
+
+// keyRange is a command's first and last key positions and step.
+type keyRange [3]int
+
+// of returns the keys at r's positions among args, which leave out the
+// command's name. Consecutive keys are returned as a slice of args, which
+// callers must not modify, so that finding them does not allocate.
+func (r keyRange) of(args []string) []string {
+	first, last, step := r[0], r[1], r[2]
+	if first == 0 || len(args) == 0 {
+		return nil
+	}
+	if last < 0 {
+		last += len(args) + 1
+	}
+	if step == 1 {
+		if end := min(last, len(args)); first <= end {
+			return args[first-1 : end : end]
+		}
+		return nil
+	}
+	var keys []string
+	for pos := first; pos <= min(last, len(args)); pos += step {
+		keys = append(keys, args[pos-1])
+	}
+	return keys
+}
+
+var (
+	noKeys    = keyRange{}
+	firstKey  = keyRange{1, 1, 1}
+	firstTwo  = keyRange{1, 2, 1}
+	everyKey  = keyRange{1, -1, 1}
+	secondKey = keyRange{2, 2, 1}
+)
+
+// spec is a row of the command table: the arity, space-separated flags,
+// key positions, group and summary of a command.
+type spec struct {
+	arity   int
+	flags   string
+	keys    keyRange
+	group   string
+	summary string
+}
+
+// specs is the command table: every command the server runs, whether a
+// keyspace handler here, a server command or one of a connection's.
+var specs = map[string]spec{
+	"PING": {-1, "fast", noKeys, "connection", "Returns the server's liveliness response."},
+	"ECHO": {2, "fast", noKeys, "connection", "Returns the given string."},
+
+	"SET": {-3, "write denyoom", firstKey, "string", "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist."},
+	"GET": {2, "readonly fast", firstKey, "string", "Returns the string value of a key."},
+
+	"HSET":    {-4, "write denyoom fast", firstKey, "hash", "Creates or modifies the value of a field in a hash."},
+	"HGET":    {3, "readonly fast", firstKey, "hash", "Returns the value of a field in a hash."},
+	"HDEL":    {-3, "write fast", firstKey, "hash", "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain."},
+	"HGETALL": {2, "readonly", firstKey, "hash", "Returns all fields and values in a hash."},
+	"HSCAN":   {-3, "readonly", firstKey, "hash", "Iterates over fields and values of a hash."},
+
+	"LPUSH":  {-3, "write denyoom fast", firstKey, "list", "Prepends one or more elements to a list. Creates the key if it doesn't exist."},
+	"RPUSH":  {-3, "write denyoom fast", firstKey, "list", "Appends one or more elements to a list. Creates the key if it doesn't exist."},
+	"LPOP":   {-2, "write fast", firstKey, "list", "Returns the first elements in a list after removing it. Deletes the list if the last element was popped."},
+	"RPOP":   {-2, "write fast", firstKey, "list", "Returns and removes the last elements of the list. Deletes the list if the last element was popped."},
+	"LRANGE": {4, "readonly", firstKey, "list", "Returns a range of elements from a list."},
+	"LMOVE":  {5, "write denyoom", firstTwo, "list", "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved."},
+
+	"SADD":        {-3, "write denyoom fast", firstKey, "set", "Adds one or more members to a set. Creates the key if it doesn't exist."},
+	"SREM":        {-3, "write fast", firstKey, "set", "Removes one or more members from a set. Deletes the set if the last member was removed."},
+	"SMEMBERS":    {2, "readonly", firstKey, "set", "Returns all members of a set."},
+	"SISMEMBER":   {3, "readonly fast", firstKey, "set", "Determines whether a member belongs to a set."},
+	"SMOVE":       {4, "write denyoom fast", firstTwo, "set", "Moves a member from one set to another."},
+	"SINTERSTORE": {-3, "write denyoom", everyKey, "set", "Stores the intersect of multiple sets in a key."},
+
+	"ZADD":   {-4, "write denyoom fast", firstKey, "sorted-set", "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist."},
+	"ZRANGE": {4, "readonly", firstKey, "sorted-set", "Returns members in a sorted set within a range of indexes."},
+
+	"DEL":            {-2, "write", everyKey, "generic", "Deletes one or more keys."},
+	"EXISTS":         {-2, "readonly fast", everyKey, "generic", "Determines whether one or more keys exist."},
+	"KEYS":           {2, "readonly", noKeys, "generic", "Returns all key names that match a pattern."},
+	"SCAN":           {-2, "readonly", noKeys, "generic", "Iterates over the key names in the database."},
+	"OBJECT":         {-2, "readonly", secondKey, "generic", "A container for object introspection commands."},
+	"RENAME":         {3, "write", firstTwo, "generic", "Renames a key and overwrites the destination."},
+	"RENAMENX":       {3, "write fast", firstTwo, "generic", "Renames a key only when the target key name doesn't exist."},
+	"PEXPIREAT":      {3, "write fast", firstKey, "generic", "Sets the expiration time of a key to a Unix milliseconds timestamp."},
+	"DUMP":           {2, "readonly", firstKey, "generic", "Returns a serialized representation of the value stored at a key."},
+	"RESTORE":        {-4, "write denyoom", firstKey, "generic", "Creates a key from the serialized representation of a value."},
+	"RESTORE-ASKING": {-4, "write denyoom asking", firstKey, "generic", "An internal command for migrating keys in a cluster."},
+	"MIGRATE":        {-6, "write movablekeys", keyRange{3, 3, 1}, "generic", "Atomically transfers a key from one Redis instance to another."},
+	"WAIT":           {3, "noscript", noKeys, "generic", "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed."},
+
+	"FLUSHDB":      {-1, "write", noKeys, "server", "Removes all keys from the current database."},
+	"FLUSHALL":     {-1, "write", noKeys, "server", "Removes all keys from all databases."},
+	"INFO":         {-1, "loading stale", noKeys, "server", "Returns information and statistics about the server."},
+	"SAVE":         {1, "admin noscript", noKeys, "server", "Synchronously saves the database(s) to disk."},
+	"BGSAVE":       {-1, "admin noscript", noKeys, "server", "Asynchronously saves the database(s) to disk."},
+	"LASTSAVE":     {1, "loading stale fast", noKeys, "server", "Returns the Unix timestamp of the last successful save to disk."},
+	"BACKUP":       {2, "admin noscript", noKeys, "server", "Writes a snapshot of the dataset to a file."},
+	"BGREWRITEAOF": {1, "admin noscript", noKeys, "server", "Asynchronously rewrites the append-only file to disk."},
+	"CONFIG":       {-2, "", noKeys, "server", "A container for server configuration commands."},
+	"LATENCY":      {-2, "", noKeys, "server", "A container for latency diagnostics commands."},
+	"SLOWLOG":      {-2, "", noKeys, "server", "A container for slow log commands."},
+	"MEMORY":       {-2, "", noKeys, "server", "A container for memory diagnostics commands."},
+	"COMMAND":      {-1, "loading stale", noKeys, "server", "Returns detailed information about all commands."},
+	"ACL":          {-2, "", noKeys, "server", "A container for Access List Control commands."},
+
+	"REPLICAOF": {3, "admin noscript stale", noKeys, "server", "Configures a server as replica of another, or promotes it to a master."},
+	"SLAVEOF":   {3, "admin noscript stale", noKeys, "server", "Sets a Redis server as a replica of another, or promotes it to being a master."},
+	"ROLE":      {1, "noscript loading stale fast", noKeys, "server", "Returns the replication role."},
+	"FAILOVER":  {-1, "admin noscript stale", noKeys, "server", "Starts a coordinated failover from a server to one of its replicas."},
+	"SYNC":      {1, "admin noscript no_multi", noKeys, "server", "An internal command used in replication."},
+	"PSYNC":     {-3, "admin noscript no_multi", noKeys, "server", "An internal command used in replication."},
+	"REPLCONF":  {-1, "admin noscript loading stale", noKeys, "server", "An internal command for configuring the replication stream."},
+
+	"CLUSTER": {-2, "", noKeys, "cluster", "A container for Redis Cluster commands."},
+	"ASKING":  {1, "fast", noKeys, "cluster", "Signals that a cluster client is following an -ASK redirect."},
+
+	"PUBLISH":      {3, "pubsub loading stale fast", noKeys, "pubsub", "Posts a message to a channel."},
+	"SUBSCRIBE":    {-2, "pubsub noscript loading stale", noKeys, "pubsub", "Listens for messages published to channels."},
+	"UNSUBSCRIBE":  {-1, "pubsub noscript loading stale", noKeys, "pubsub", "Stops listening to messages posted to channels."},
+	"PSUBSCRIBE":   {-2, "pubsub noscript loading stale", noKeys, "pubsub", "Listens for messages published to channels that match one or more patterns."},
+	"PUNSUBSCRIBE": {-1, "pubsub noscript loading stale", noKeys, "pubsub", "Stops listening to messages published to channels that match one or more patterns."},
+
+	"AUTH":   {-2, "noscript loading stale fast no_auth", noKeys, "connection", "Authenticates the connection."},
+	"HELLO":  {-1, "noscript loading stale fast no_auth", noKeys, "connection", "Handshakes with the Redis server."},
+	"CLIENT": {-2, "", noKeys, "connection", "A container for client connection commands."},
+	"SELECT": {2, "loading stale fast", noKeys, "connection", "Changes the selected database."},
+	"QUIT":   {-1, "noscript loading stale fast no_auth allow_busy", noKeys, "connection", "Closes the connection."},
+	"RESET":  {1, "noscript loading stale fast no_auth allow_busy", noKeys, "connection", "Resets the connection."},
+}
+
+// Lookup returns the spec of the upper-cased command, if the server has it.
+func Lookup(cmd string) (Spec, bool) {
+	sp, ok := specs[cmd]
+	if !ok {
+		return Spec{}, false
+	}
+	return Spec{
+		Name:     strings.ToLower(cmd),
+		Arity:    sp.arity,
+		Flags:    strings.Fields(sp.flags),
+		FirstKey: sp.keys[0],
+		LastKey:  sp.keys[1],
+		Step:     sp.keys[2],
+		Group:    sp.group,
+		Summary:  sp.summary,
+	}, true
+}
+
+// Specs returns the spec of every command, sorted by name.
+func Specs() []Spec {
+	all := make([]Spec, 0, len(specs))
+	for cmd := range specs {
+		sp, _ := Lookup(cmd)
+		all = append(all, sp)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
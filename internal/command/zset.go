@@ -11,7 +11,7 @@ import (
 // Usage: ZADD key score member [score member ...]
 type ZAddHandler struct{}
 
-func (h *ZAddHandler) Execute(s *store.Store, args []string) Response {
+func (h *ZAddHandler) Execute(s store.KV, args []string) Response {
 	if len(args) < 3 || ((len(args)-1)%2) != 0 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'zadd' command")}
 	}
@@ -40,7 +40,7 @@ func (h *ZAddHandler) Execute(s *store.Store, args []string) Response {
 // ZRANGE handler: ZRANGE key start stop
 type ZRangeHandler struct{}
 
-func (h *ZRangeHandler) Execute(s *store.Store, args []string) Response {
+func (h *ZRangeHandler) Execute(s store.KV, args []string) Response {
 	if len(args) != 3 {
 		return Response{Type: TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'zrange' command")}
 	}
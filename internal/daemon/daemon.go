@@ -0,0 +1,143 @@
+// Package daemon integrates the server with service managers: a pidfile,
+// and systemd's readiness notification (sd_notify) and watchdog for units
+// of Type=notify, in place of Redis' daemonize, which a Go process cannot
+// do by forking.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WritePidFile writes the process' id to path, replacing any file there,
+// as Redis does on start.
+func WritePidFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
+
+// RemovePidFile removes the pidfile at path, if it is this process'.
+func RemovePidFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// Supervisor notifies systemd of the server's state. The zero value, and
+// that of NewSupervisor for mode "no", does nothing.
+type Supervisor struct {
+	socket   string        // NOTIFY_SOCKET
+	watchdog time.Duration // WATCHDOG_USEC, 0 without a watchdog
+	stop     chan struct{}
+}
+
+// NewSupervisor returns a Supervisor for the supervised mode: "no" for
+// none, "systemd" to notify systemd, failing without NOTIFY_SOCKET, which
+// systemd sets for units of Type=notify, or "auto" to notify it if it is
+// set.
+func NewSupervisor(mode string) (*Supervisor, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	switch strings.ToLower(mode) {
+	case "", "no":
+		return &Supervisor{}, nil
+	case "auto":
+		if socket == "" {
+			return &Supervisor{}, nil
+		}
+	case "systemd":
+		if socket == "" {
+			return nil, fmt.Errorf("supervised systemd: NOTIFY_SOCKET is not set; is the unit Type=notify?")
+		}
+	default:
+		return nil, fmt.Errorf("supervised must be no, systemd or auto, got %q", mode)
+	}
+	sv := &Supervisor{socket: socket}
+	// systemd sets WATCHDOG_PID too when the watchdog is for another
+	// process of the unit.
+	if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+			sv.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return sv, nil
+}
+
+// Enabled reports whether the Supervisor notifies systemd.
+func (sv *Supervisor) Enabled() bool {
+	return sv.socket != ""
+}
+
+// Ready tells systemd the server is accepting connections, with status as
+// the status `systemctl status` shows, and starts pinging the watchdog if
+// the unit has one.
+func (sv *Supervisor) Ready(status string) error {
+	if !sv.Enabled() {
+		return nil
+	}
+	if err := sv.notify("READY=1\nSTATUS=" + status); err != nil {
+		return err
+	}
+	if sv.watchdog > 0 && sv.stop == nil {
+		sv.stop = make(chan struct{})
+		go sv.pingWatchdog(sv.stop)
+	}
+	return nil
+}
+
+// Stopping tells systemd the server is shutting down, with status, and
+// stops pinging the watchdog, as draining may take longer than it allows.
+func (sv *Supervisor) Stopping(status string) error {
+	if !sv.Enabled() {
+		return nil
+	}
+	if sv.stop != nil {
+		close(sv.stop)
+		sv.stop = nil
+	}
+	return sv.notify("STOPPING=1\nSTATUS=" + status)
+}
+
+// pingWatchdog pings the watchdog at half its interval, as systemd
+// advises, until Stopping closes stop.
+func (sv *Supervisor) pingWatchdog(stop chan struct{}) {
+	ticker := time.NewTicker(sv.watchdog / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sv.notify("WATCHDOG=1")
+		}
+	}
+}
+
+// notify sends state to systemd's notification socket, a datagram socket
+// in the filesystem or, with a leading @, in the abstract namespace.
+func (sv *Supervisor) notify(state string) error {
+	addr := &net.UnixAddr{Name: sv.socket, Net: "unixgram"}
+	if strings.HasPrefix(addr.Name, "@") {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.pid")
+	if err := WritePidFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("expected the process id in the pidfile, got %q (%v)", data, err)
+	}
+	if err := RemovePidFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the pidfile to be removed, got %v", err)
+	}
+
+	// Another process' pidfile is left alone.
+	os.WriteFile(path, []byte("1\n"), 0o644)
+	if err := RemovePidFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected another process' pidfile to be kept, got %v", err)
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sv, err := NewSupervisor("auto"); err != nil || sv.Enabled() {
+		t.Fatalf("expected auto to do nothing outside systemd, got %v", err)
+	}
+	if _, err := NewSupervisor("systemd"); err == nil {
+		t.Fatal("expected systemd to fail without NOTIFY_SOCKET")
+	}
+	if _, err := NewSupervisor("upstart"); err == nil {
+		t.Fatal("expected an unknown mode to fail")
+	}
+
+	// Stand in for systemd.
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() string {
+		t.Helper()
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("expected a notification: %v", err)
+		}
+		return string(buf[:n])
+	}
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+	sv, err := NewSupervisor("systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.Ready("Ready to accept connections"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "READY=1\nSTATUS=Ready to accept connections" {
+		t.Fatalf("unexpected notification %q", got)
+	}
+	if got := read(); got != "WATCHDOG=1" {
+		t.Fatalf("expected the watchdog to be pinged, got %q", got)
+	}
+	if err := sv.Stopping("Shutting down"); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		// Pings may have been sent before Stopping.
+		if got := read(); got != "WATCHDOG=1" {
+			if got != "STOPPING=1\nSTATUS=Shutting down" {
+				t.Fatalf("unexpected notification %q", got)
+			}
+			break
+		}
+	}
+}
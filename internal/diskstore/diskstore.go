@@ -0,0 +1,821 @@
+// Package diskstore implements store.KV on top of an embedded bbolt database,
+// so the dataset lives on disk instead of being rebuilt from the AOF on
+// every start.
+package diskstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"redis-from-scratch/internal/glob"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/store"
+)
+
+var (
+	dataBucket    = []byte("data")
+	expiresBucket = []byte("expires")
+)
+
+var errWrongType = fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
+
+// record is one key as the backend works on it. Collections are stored as a
+// single record, so very large collections pay an encode/decode per write;
+// the backend targets many keys rather than huge individual values.
+type record struct {
+	Type   store.ValueType
+	Str    string
+	Hash   map[string]string
+	List   []string
+	Set    map[string]struct{}
+	ZSet   []store.ZMember
+	Expiry int64 // unix milliseconds, 0 = no expiry
+}
+
+// encode writes r in the store's dump format, which keeps values as the
+// bytes they are, where JSON would replace invalid UTF-8, and checksums
+// them.
+func (r *record) encode() ([]byte, error) {
+	var b bytes.Buffer
+	enc, err := store.NewEncoder(&b)
+	if err == nil {
+		err = enc.Encode(r.entry(""))
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	return b.Bytes(), err
+}
+
+// decode reads a record written by encode.
+func decode(raw []byte) (*record, error) {
+	dec, err := store.NewDecoder(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	e, err := dec.Decode()
+	if err != nil {
+		return nil, err
+	}
+	r := &record{Type: e.Type, Str: e.Str, Hash: e.Hash, List: e.List, Set: e.Set, ZSet: e.ZSet}
+	if e.Expiry != nil {
+		r.Expiry = e.Expiry.UnixMilli()
+	}
+	return r, nil
+}
+
+func (r *record) expired(now time.Time) bool {
+	return r.Expiry != 0 && now.UnixMilli() >= r.Expiry
+}
+
+// Store is a bbolt-backed implementation of store.KV.
+type Store struct {
+	db *bolt.DB
+	// KeyLocks makes the commands built from several calls atomic, as
+	// each call is a transaction of its own.
+	store.KeyLocks
+}
+
+var (
+	_ store.KV        = (*Store)(nil)
+	_ store.Durable   = (*Store)(nil)
+	_ store.Flusher   = (*Store)(nil)
+	_ store.Expirer   = (*Store)(nil)
+	_ store.KeyLocker = (*Store)(nil)
+)
+
+// Open opens (or creates) the database file at path.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(dataBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(expiresBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize disk store: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Durable reports that writes are persisted by the database itself.
+func (s *Store) Durable() bool {
+	return true
+}
+
+// Close flushes and closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// expiryKey orders the expires index by deadline, then key.
+func expiryKey(ms int64, key string) []byte {
+	b := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(b, uint64(ms))
+	copy(b[8:], key)
+	return b
+}
+
+// load returns the live record for key, treating expired records as absent.
+func load(tx *bolt.Tx, key string) (*record, bool) {
+	raw := tx.Bucket(dataBucket).Get([]byte(key))
+	if raw == nil {
+		return nil, false
+	}
+	r, err := decode(raw)
+	if err != nil || r.expired(time.Now()) {
+		return nil, false
+	}
+	return r, true
+}
+
+// save writes r under key, keeping the expires index in sync.
+func save(tx *bolt.Tx, key string, r *record) error {
+	if err := unindex(tx, key); err != nil {
+		return err
+	}
+	raw, err := r.encode()
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(dataBucket).Put([]byte(key), raw); err != nil {
+		return err
+	}
+	if r.Expiry != 0 {
+		return tx.Bucket(expiresBucket).Put(expiryKey(r.Expiry, key), nil)
+	}
+	return nil
+}
+
+// remove deletes key and its expiry index entry. It reports whether a live
+// key was removed.
+func remove(tx *bolt.Tx, key string) (bool, error) {
+	_, live := load(tx, key)
+	if err := unindex(tx, key); err != nil {
+		return false, err
+	}
+	if err := tx.Bucket(dataBucket).Delete([]byte(key)); err != nil {
+		return false, err
+	}
+	return live, nil
+}
+
+func unindex(tx *bolt.Tx, key string) error {
+	raw := tx.Bucket(dataBucket).Get([]byte(key))
+	if raw == nil {
+		return nil
+	}
+	old, err := decode(raw)
+	if err != nil || old.Expiry == 0 {
+		return nil
+	}
+	return tx.Bucket(expiresBucket).Delete(expiryKey(old.Expiry, key))
+}
+
+// loadTyped is load plus a type check; a missing key returns a fresh record
+// of type t with ok=false.
+func loadTyped(tx *bolt.Tx, key string, t store.ValueType) (*record, bool, error) {
+	r, ok := load(tx, key)
+	if !ok {
+		return &record{Type: t}, false, nil
+	}
+	if r.Type != t {
+		return nil, false, errWrongType
+	}
+	return r, true, nil
+}
+
+func (s *Store) Set(key, value string, expireMs int64) {
+	r := &record{Type: store.TypeString, Str: value}
+	if expireMs > 0 {
+		r.Expiry = time.Now().Add(time.Duration(expireMs) * time.Millisecond).UnixMilli()
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return save(tx, key, r)
+	})
+	if err != nil {
+		logging.Errorf("disk store: SET %s: %v", key, err)
+	}
+}
+
+func (s *Store) Get(key string) (string, bool) {
+	var (
+		val string
+		ok  bool
+	)
+	s.db.View(func(tx *bolt.Tx) error {
+		r, found := load(tx, key)
+		if found && r.Type == store.TypeString {
+			val, ok = r.Str, true
+		}
+		return nil
+	})
+	return val, ok
+}
+
+func (s *Store) Delete(keys ...string) int {
+	count := 0
+	s.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			removed, err := remove(tx, key)
+			if err != nil {
+				return err
+			}
+			if removed {
+				count++
+			}
+		}
+		return nil
+	})
+	return count
+}
+
+// ExpireAt sets the absolute deadline of key; a past deadline deletes it.
+func (s *Store) ExpireAt(key string, at time.Time) bool {
+	found := false
+	s.db.Update(func(tx *bolt.Tx) error {
+		r, ok := load(tx, key)
+		if !ok {
+			return nil
+		}
+		found = true
+		if !at.After(time.Now()) {
+			_, err := remove(tx, key)
+			return err
+		}
+		r.Expiry = at.UnixMilli()
+		return save(tx, key, r)
+	})
+	return found
+}
+
+func (s *Store) Exists(keys ...string) int {
+	count := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if _, ok := load(tx, key); ok {
+				count++
+			}
+		}
+		return nil
+	})
+	return count
+}
+
+// matchingKeys returns live keys matching pattern in lexicographic order.
+func (s *Store) matchingKeys(pattern string) []string {
+	keys := make([]string, 0)
+	now := time.Now()
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(dataBucket).ForEach(func(k, raw []byte) error {
+			if !glob.Match(pattern, string(k)) {
+				return nil
+			}
+			if r, err := decode(raw); err == nil && !r.expired(now) {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	return keys
+}
+
+func (s *Store) Keys(pattern string) []string {
+	return s.matchingKeys(pattern)
+}
+
+func (s *Store) Scan(cursor int64, pattern string, count int64) (int64, []string, error) {
+	if cursor < 0 {
+		return 0, nil, fmt.Errorf("ERR invalid cursor")
+	}
+	return paginate(s.matchingKeys(pattern), cursor, count)
+}
+
+// paginate applies the offset-cursor SCAN contract used by store.Store.
+func paginate(items []string, cursor, count int64) (int64, []string, error) {
+	if count <= 0 {
+		count = 10
+	}
+	if cursor >= int64(len(items)) {
+		return 0, []string{}, nil
+	}
+	end := cursor + count
+	if end > int64(len(items)) {
+		end = int64(len(items))
+	}
+	next := int64(0)
+	if end < int64(len(items)) {
+		next = end
+	}
+	return next, items[cursor:end], nil
+}
+
+// forEachBatch is the number of records decoded per read transaction in
+// ForEach.
+const forEachBatch = 256
+
+// ForEach walks the data bucket in key order. Records are read in batches and
+// fn runs outside any transaction, so it may write to the store; each entry
+// is consistent, but writes made during the walk may or may not be observed.
+func (s *Store) ForEach(fn func(e store.Entry) bool) {
+	var after []byte
+	for {
+		var (
+			batch []store.Entry
+			done  bool
+		)
+		now := time.Now()
+		s.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(dataBucket).Cursor()
+			k, raw := c.First()
+			if after != nil {
+				k, raw = c.Seek(after)
+				if k != nil && string(k) == string(after) {
+					k, raw = c.Next()
+				}
+			}
+			for ; k != nil && len(batch) < forEachBatch; k, raw = c.Next() {
+				after = append(after[:0], k...)
+				r, err := decode(raw)
+				if err != nil || r.expired(now) {
+					continue
+				}
+				batch = append(batch, r.entry(string(k)))
+			}
+			done = k == nil
+			return nil
+		})
+		for _, e := range batch {
+			if !fn(e) {
+				return
+			}
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// entry converts r into the engine-independent store.Entry form.
+func (r *record) entry(key string) store.Entry {
+	e := store.Entry{Key: key, Type: r.Type, Str: r.Str, Hash: r.Hash, List: r.List, Set: r.Set, ZSet: r.ZSet}
+	if r.Expiry != 0 {
+		exp := time.UnixMilli(r.Expiry)
+		e.Expiry = &exp
+	}
+	return e
+}
+
+func (s *Store) Size() int {
+	n := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(dataBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Flush drops and recreates both buckets.
+func (s *Store) Flush() {
+	s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{dataBucket, expiresBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CleanupExpired walks the expires index from the earliest deadline and
+// removes every key whose deadline has passed.
+func (s *Store) CleanupExpired() int {
+	count := 0
+	now := uint64(time.Now().UnixMilli())
+	s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(expiresBucket).Cursor()
+		var expired [][]byte
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= now; k, _ = c.Next() {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		for _, k := range expired {
+			if err := tx.Bucket(expiresBucket).Delete(k); err != nil {
+				return err
+			}
+			if err := tx.Bucket(dataBucket).Delete(k[8:]); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+func (s *Store) HashSet(key, field, value string) (int, error) {
+	added := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeHash)
+		if err != nil {
+			return err
+		}
+		if r.Hash == nil {
+			r.Hash = make(map[string]string)
+		}
+		if _, exists := r.Hash[field]; !exists {
+			added = 1
+		}
+		r.Hash[field] = value
+		return save(tx, key, r)
+	})
+	return added, err
+}
+
+func (s *Store) HashGet(key, field string) (string, bool, error) {
+	var (
+		val string
+		ok  bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeHash)
+		if err != nil {
+			return err
+		}
+		val, ok = r.Hash[field]
+		return nil
+	})
+	return val, ok, err
+}
+
+func (s *Store) HashDel(key string, fields ...string) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, exists, err := loadTyped(tx, key, store.TypeHash)
+		if err != nil || !exists {
+			return err
+		}
+		for _, f := range fields {
+			if _, ok := r.Hash[f]; ok {
+				delete(r.Hash, f)
+				count++
+			}
+		}
+		if len(r.Hash) == 0 {
+			_, err := remove(tx, key)
+			return err
+		}
+		return save(tx, key, r)
+	})
+	return count, err
+}
+
+func (s *Store) HashGetAll(key string) (map[string]string, error) {
+	out := map[string]string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeHash)
+		if err != nil {
+			return err
+		}
+		for k, v := range r.Hash {
+			out[k] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *Store) HashScan(key string, cursor int64, pattern string, count int64) (int64, []string, error) {
+	if cursor < 0 {
+		return 0, nil, fmt.Errorf("ERR invalid cursor")
+	}
+	m, err := s.HashGetAll(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	fields := matching(mapKeys(m), pattern)
+	next, page, _ := paginate(fields, cursor, count)
+	result := make([]string, 0, len(page)*2)
+	for _, f := range page {
+		result = append(result, f, m[f])
+	}
+	return next, result, nil
+}
+
+func (s *Store) ListLPush(key string, values ...string) (int, error) {
+	n := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeList)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			r.List = append([]string{v}, r.List...)
+		}
+		n = len(r.List)
+		return save(tx, key, r)
+	})
+	return n, err
+}
+
+func (s *Store) ListRPush(key string, values ...string) (int, error) {
+	n := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeList)
+		if err != nil {
+			return err
+		}
+		r.List = append(r.List, values...)
+		n = len(r.List)
+		return save(tx, key, r)
+	})
+	return n, err
+}
+
+func (s *Store) listPop(key string, left bool) (string, bool, error) {
+	var (
+		val string
+		ok  bool
+	)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, exists, err := loadTyped(tx, key, store.TypeList)
+		if err != nil || !exists || len(r.List) == 0 {
+			return err
+		}
+		if left {
+			val, r.List = r.List[0], r.List[1:]
+		} else {
+			val, r.List = r.List[len(r.List)-1], r.List[:len(r.List)-1]
+		}
+		ok = true
+		if len(r.List) == 0 {
+			_, err := remove(tx, key)
+			return err
+		}
+		return save(tx, key, r)
+	})
+	return val, ok, err
+}
+
+func (s *Store) ListLPop(key string) (string, bool, error) {
+	return s.listPop(key, true)
+}
+
+func (s *Store) ListRPop(key string) (string, bool, error) {
+	return s.listPop(key, false)
+}
+
+func (s *Store) ListRange(key string, start, stop int) ([]string, error) {
+	var out []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeList)
+		if err != nil {
+			return err
+		}
+		out = sliceRange(r.List, start, stop)
+		return nil
+	})
+	return out, err
+}
+
+// sliceRange applies Redis inclusive, negative-index range semantics.
+func sliceRange(items []string, start, stop int) []string {
+	ln := len(items)
+	if start < 0 {
+		start = ln + start
+	}
+	if stop < 0 {
+		stop = ln + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= ln {
+		stop = ln - 1
+	}
+	if ln == 0 || start > stop || start >= ln {
+		return []string{}
+	}
+	return append([]string{}, items[start:stop+1]...)
+}
+
+func (s *Store) SetAdd(key string, members ...string) (int, error) {
+	added := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeSet)
+		if err != nil {
+			return err
+		}
+		if r.Set == nil {
+			r.Set = make(map[string]struct{})
+		}
+		for _, m := range members {
+			if _, exists := r.Set[m]; !exists {
+				r.Set[m] = struct{}{}
+				added++
+			}
+		}
+		return save(tx, key, r)
+	})
+	return added, err
+}
+
+func (s *Store) SetMembers(key string) ([]string, error) {
+	var out []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeSet)
+		if err != nil {
+			return err
+		}
+		out = make([]string, 0, len(r.Set))
+		for m := range r.Set {
+			out = append(out, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *Store) SetRemove(key string, members ...string) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, exists, err := loadTyped(tx, key, store.TypeSet)
+		if err != nil || !exists {
+			return err
+		}
+		for _, m := range members {
+			if _, ok := r.Set[m]; ok {
+				delete(r.Set, m)
+				removed++
+			}
+		}
+		if len(r.Set) == 0 {
+			_, err := remove(tx, key)
+			return err
+		}
+		return save(tx, key, r)
+	})
+	return removed, err
+}
+
+func (s *Store) SetIsMember(key, member string) (bool, error) {
+	ok := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeSet)
+		if err != nil {
+			return err
+		}
+		_, ok = r.Set[member]
+		return nil
+	})
+	return ok, err
+}
+
+func (s *Store) SetScan(key string, cursor int64, pattern string, count int64) (int64, []string, error) {
+	if cursor < 0 {
+		return 0, nil, fmt.Errorf("ERR invalid cursor")
+	}
+	members, err := s.SetMembers(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	return paginate(matching(members, pattern), cursor, count)
+}
+
+// ZAdd keeps members ordered by (score, member), matching store.SortedSet.
+func (s *Store) ZAdd(key string, score float64, member string) (int, error) {
+	changed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeZSet)
+		if err != nil {
+			return err
+		}
+		for i, e := range r.ZSet {
+			if e.Member == member {
+				if e.Score == score {
+					return nil
+				}
+				r.ZSet = append(r.ZSet[:i], r.ZSet[i+1:]...)
+				break
+			}
+		}
+		i := sort.Search(len(r.ZSet), func(i int) bool {
+			if r.ZSet[i].Score == score {
+				return r.ZSet[i].Member >= member
+			}
+			return r.ZSet[i].Score >= score
+		})
+		r.ZSet = append(r.ZSet, store.ZMember{})
+		copy(r.ZSet[i+1:], r.ZSet[i:])
+		r.ZSet[i] = store.ZMember{Member: member, Score: score}
+		changed = 1
+		return save(tx, key, r)
+	})
+	return changed, err
+}
+
+func (s *Store) ZScore(key, member string) (float64, bool, error) {
+	var (
+		score float64
+		ok    bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeZSet)
+		if err != nil {
+			return err
+		}
+		for _, e := range r.ZSet {
+			if e.Member == member {
+				score, ok = e.Score, true
+				break
+			}
+		}
+		return nil
+	})
+	return score, ok, err
+}
+
+func (s *Store) ZRange(key string, start, stop int) ([]string, error) {
+	var out []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		r, _, err := loadTyped(tx, key, store.TypeZSet)
+		if err != nil {
+			return err
+		}
+		members := make([]string, len(r.ZSet))
+		for i, e := range r.ZSet {
+			members[i] = e.Member
+		}
+		out = sliceRange(members, start, stop)
+		return nil
+	})
+	return out, err
+}
+
+func (s *Store) ZRem(key string, members ...string) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r, exists, err := loadTyped(tx, key, store.TypeZSet)
+		if err != nil || !exists {
+			return err
+		}
+		drop := make(map[string]bool, len(members))
+		for _, m := range members {
+			drop[m] = true
+		}
+		kept := r.ZSet[:0]
+		for _, e := range r.ZSet {
+			if drop[e.Member] {
+				removed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		r.ZSet = kept
+		if len(r.ZSet) == 0 {
+			_, err := remove(tx, key)
+			return err
+		}
+		return save(tx, key, r)
+	})
+	return removed, err
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// matching filters items by glob pattern and sorts them for stable cursors.
+func matching(items []string, pattern string) []string {
+	out := make([]string, 0, len(items))
+	for _, it := range items {
+		if glob.Match(pattern, it) {
+			out = append(out, it)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,192 @@
+package diskstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/store"
+)
+
+func openTemp(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	return s, path
+}
+
+func TestDiskStorePersistsAcrossReopen(t *testing.T) {
+	s, path := openTemp(t)
+	s.Set("k", "v", 0)
+	s.HashSet("h", "f", "v")
+	s.ListRPush("l", "a", "b")
+	s.SetAdd("s", "m")
+	s.ZAdd("z", 2, "two")
+	s.ZAdd("z", 1, "one")
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+
+	if v, ok := s.Get("k"); !ok || v != "v" {
+		t.Fatalf("expected v, got '%s' ok=%v", v, ok)
+	}
+	if v, ok, _ := s.HashGet("h", "f"); !ok || v != "v" {
+		t.Fatalf("expected hash field, got '%s' ok=%v", v, ok)
+	}
+	if l, _ := s.ListRange("l", 0, -1); len(l) != 2 || l[0] != "a" || l[1] != "b" {
+		t.Fatalf("unexpected list: %v", l)
+	}
+	if ok, _ := s.SetIsMember("s", "m"); !ok {
+		t.Fatalf("expected set member")
+	}
+	if z, _ := s.ZRange("z", 0, -1); len(z) != 2 || z[0] != "one" {
+		t.Fatalf("unexpected zset order: %v", z)
+	}
+}
+
+func TestDiskStoreTypeErrorsAndDeletes(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+
+	s.Set("str", "v", 0)
+	if _, err := s.HashSet("str", "f", "v"); err == nil {
+		t.Fatalf("expected WRONGTYPE error")
+	}
+	if _, err := s.ListLPush("str", "x"); err == nil {
+		t.Fatalf("expected WRONGTYPE error")
+	}
+
+	s.ListRPush("l", "a")
+	if v, ok, _ := s.ListLPop("l"); !ok || v != "a" {
+		t.Fatalf("expected pop a, got '%s'", v)
+	}
+	if s.Exists("l") != 0 {
+		t.Fatalf("expected empty list to be removed")
+	}
+	if n := s.Delete("str", "missing"); n != 1 {
+		t.Fatalf("expected 1 deleted, got %d", n)
+	}
+}
+
+func TestDiskStoreExpiry(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+
+	s.Set("temp", "v", 50)
+	s.Set("keep", "v", 0)
+	time.Sleep(80 * time.Millisecond)
+
+	if _, ok := s.Get("temp"); ok {
+		t.Fatalf("expected temp to be expired")
+	}
+	if n := s.CleanupExpired(); n != 1 {
+		t.Fatalf("expected 1 cleaned up, got %d", n)
+	}
+	if keys := s.Keys("*"); len(keys) != 1 || keys[0] != "keep" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestDiskStoreFlush(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+	s.Set("k", "v", 100000)
+	s.HashSet("h", "f", "v")
+	s.Flush()
+	if s.Size() != 0 {
+		t.Fatalf("expected empty store after flush, got %d keys", s.Size())
+	}
+	s.Set("k", "v", 0)
+	if v, ok := s.Get("k"); !ok || v != "v" {
+		t.Fatalf("store unusable after flush")
+	}
+}
+
+func TestDiskStoreForEach(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+	for i := 0; i < 600; i++ {
+		s.Set(fmt.Sprintf("k%04d", i), "v", 0)
+	}
+	s.ZAdd("z", 1, "m")
+
+	seen := 0
+	var zset []store.ZMember
+	s.ForEach(func(e store.Entry) bool {
+		seen++
+		if e.Key == "z" {
+			zset = e.ZSet
+		}
+		return true
+	})
+	if seen != 601 {
+		t.Fatalf("expected 601 entries, got %d", seen)
+	}
+	if len(zset) != 1 || zset[0].Member != "m" {
+		t.Fatalf("unexpected zset entry: %v", zset)
+	}
+
+	seen = 0
+	s.ForEach(func(e store.Entry) bool {
+		seen++
+		return seen < 5
+	})
+	if seen != 5 {
+		t.Fatalf("expected early stop after 5 entries, got %d", seen)
+	}
+}
+
+func TestDiskStoreKeepsBinaryValues(t *testing.T) {
+	s, path := openTemp(t)
+	bin := "\xff\xfe\x00abc"
+	s.Set(bin, bin, 0)
+	s.HashSet("h", bin, bin)
+	s.ListRPush("l", bin)
+	s.ZAdd("z", 1, bin)
+	s.Close()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+	if v, ok := s.Get(bin); !ok || v != bin {
+		t.Fatalf("expected %q, got %q ok=%v", bin, v, ok)
+	}
+	if v, _, _ := s.HashGet("h", bin); v != bin {
+		t.Fatalf("expected hash field %q, got %q", bin, v)
+	}
+	if l, _ := s.ListRange("l", 0, -1); len(l) != 1 || l[0] != bin {
+		t.Fatalf("expected list [%q], got %q", bin, l)
+	}
+	if z, _ := s.ZRange("z", 0, -1); len(z) != 1 || z[0] != bin {
+		t.Fatalf("expected zset [%q], got %q", bin, z)
+	}
+}
+
+func TestDiskStoreKeysGlob(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+	s.Set("user/1", "v", 0)
+	s.Set("user/2", "v", 0)
+	s.Set("session", "v", 0)
+
+	if keys := s.Keys("user*"); len(keys) != 2 {
+		t.Fatalf("expected * to match '/', got %v", keys)
+	}
+	if keys := s.Keys("[u"); len(keys) != 0 {
+		t.Fatalf("expected no match for an unclosed class, got %v", keys)
+	}
+	if _, keys, _ := s.Scan(0, "*/2", 10); len(keys) != 1 || keys[0] != "user/2" {
+		t.Fatalf("expected user/2, got %v", keys)
+	}
+}
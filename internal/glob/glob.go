@@ -0,0 +1,95 @@
+// Package glob implements Redis' glob-style pattern matching
+// (stringmatchlen), as used by PSUBSCRIBE.
+package glob
+
+// Match reports whether s matches pattern. In the pattern, * matches any
+// sequence of characters, ? any one character, and \x the character x. A
+// class like [abc] matches one of its characters, [^abc] one that is not
+// among them, and [a-z] one in the range.
+//
+// Unlike path.Match, * also matches '/', and a malformed pattern is matched
+// as Redis would: a class missing its ] ends with the pattern.
+func Match(pattern, s string) bool {
+	p, i := 0, 0
+	// The last * seen and the position in s it was tried at: on a
+	// mismatch it takes one more character.
+	star, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, starI = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if ok, next := matchClass(pattern, p, s[i]); ok {
+					p = next
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == s[i] {
+						p += 2
+						i++
+						continue
+					}
+					break
+				}
+				// A trailing backslash matches itself.
+				fallthrough
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starI++
+		p, i = star+1, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class starting at pattern[p], '[',
+// returning whether it matched and the position after the class.
+func matchClass(pattern string, p int, c byte) (bool, int) {
+	p++
+	not := p < len(pattern) && pattern[p] == '^'
+	if not {
+		p++
+	}
+	match := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			match = match || pattern[p+1] == c
+			p += 2
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || lo <= c && c <= hi
+			p += 3
+		default:
+			match = match || pattern[p] == c
+			p++
+		}
+	}
+	if p < len(pattern) {
+		p++ // the ]
+	}
+	return match != not, p
+}
@@ -0,0 +1,113 @@
+package latency
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// subBits splits each power of two of microseconds into 1<<subBits
+// buckets, as HDR histograms do: a percentile read from a Histogram is
+// within 1/16th, about 6%, of the latency recorded. Latencies under 16µs
+// each have a bucket of their own.
+const (
+	subBits    = 4
+	subBuckets = 1 << subBits
+
+	// maxUsec is the largest latency told apart, about 19 hours; longer
+	// ones are counted as it.
+	maxUsec  = 1<<36 - 1
+	nBuckets = (36 - subBits + 1) * subBuckets
+)
+
+// Histogram counts latencies in buckets, for percentiles and LATENCY
+// HISTOGRAM. Recording takes no lock, so a Histogram can be shared by the
+// connections running a command. The zero value is ready to use.
+type Histogram struct {
+	counts [nBuckets]atomic.Int64
+	total  atomic.Int64
+}
+
+// bucket returns the index of the bucket counting usec.
+func bucket(usec uint64) int {
+	if usec < subBuckets {
+		return int(usec)
+	}
+	shift := bits.Len64(usec) - 1 - subBits
+	return (shift+1)*subBuckets + int(usec>>shift) - subBuckets
+}
+
+// bucketMin and bucketMax return the smallest and largest latencies, in
+// microseconds, counted in the bucket at i.
+func bucketMin(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := i/subBuckets - 1
+	return uint64(i%subBuckets+subBuckets) << shift
+}
+
+func bucketMax(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	return bucketMin(i) + 1<<(i/subBuckets-1) - 1
+}
+
+// Record counts a latency of d.
+func (h *Histogram) Record(d time.Duration) {
+	usec := uint64(max(d.Microseconds(), 0))
+	h.counts[bucket(min(usec, maxUsec))].Add(1)
+	h.total.Add(1)
+}
+
+// Count returns the number of latencies recorded.
+func (h *Histogram) Count() int64 {
+	return h.total.Load()
+}
+
+// Percentile returns the latency, in microseconds, that p percent of those
+// recorded are under or at, like 99.9 for the 99.9th percentile, or 0 if
+// none were recorded.
+func (h *Histogram) Percentile(p float64) uint64 {
+	total := h.total.Load()
+	if total == 0 {
+		return 0
+	}
+	target := max(int64(math.Ceil(p/100*float64(total))), 1)
+	var seen int64
+	for i := range h.counts {
+		if seen += h.counts[i].Load(); seen >= target {
+			return bucketMax(i)
+		}
+	}
+	return maxUsec
+}
+
+// PowersOfTwo returns, for each power of two of microseconds from 1 up to
+// the first that all latencies recorded are under or at, the number of
+// them that are, as LATENCY HISTOGRAM reports them: pairs of the power and
+// the count, in order. A bucket counts as under a power if it starts at or
+// under it.
+func (h *Histogram) PowersOfTwo() []int64 {
+	total := h.total.Load()
+	var out []int64
+	var seen int64
+	i := 0
+	for power := uint64(1); seen < total && i < nBuckets; power <<= 1 {
+		for ; i < nBuckets && bucketMin(i) <= power; i++ {
+			seen += h.counts[i].Load()
+		}
+		out = append(out, int64(power), seen)
+	}
+	return out
+}
+
+// Reset forgets the latencies recorded.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.total.Store(0)
+}
@@ -0,0 +1,193 @@
+// Package latency implements the latency monitor: the latency spikes of each
+// class of event, like commands or saves, that took at least a threshold,
+// for LATENCY LATEST, HISTORY and DOCTOR.
+package latency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyLen is the number of samples kept for each event, as in Redis.
+const historyLen = 160
+
+// Sample is one spike: when it ended and how long it took. Spikes of an
+// event ending within the same second are merged, keeping the longest.
+type Sample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// Latest is an event's latest spike and its longest since the event was
+// reset.
+type Latest struct {
+	Event string
+	Sample
+	Max time.Duration
+}
+
+type event struct {
+	samples []Sample // oldest first, at most historyLen
+	max     time.Duration
+}
+
+// Monitor records the spikes of each event. The zero value is not ready to
+// use; call New.
+type Monitor struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	events    map[string]*event
+}
+
+// New creates a monitor recording spikes of at least threshold, or none if
+// it is 0.
+func New(threshold time.Duration) *Monitor {
+	return &Monitor{threshold: threshold, events: make(map[string]*event)}
+}
+
+// Threshold returns the latency from which spikes are recorded.
+func (m *Monitor) Threshold() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.threshold
+}
+
+// SetThreshold changes the latency from which spikes are recorded; 0 stops
+// recording.
+func (m *Monitor) SetThreshold(threshold time.Duration) {
+	m.mu.Lock()
+	m.threshold = threshold
+	m.mu.Unlock()
+}
+
+// Add records that name took d, if that is a spike.
+func (m *Monitor) Add(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.threshold <= 0 || d < m.threshold {
+		return
+	}
+	now := time.Now()
+	e := m.events[name]
+	if e == nil {
+		e = &event{}
+		m.events[name] = e
+	}
+	e.max = max(e.max, d)
+	if n := len(e.samples); n > 0 && e.samples[n-1].Time.Unix() == now.Unix() {
+		last := &e.samples[n-1]
+		last.Latency = max(last.Latency, d)
+		return
+	}
+	if len(e.samples) == historyLen {
+		e.samples = append(e.samples[:0], e.samples[1:]...)
+	}
+	e.samples = append(e.samples, Sample{Time: now, Latency: d})
+}
+
+// Latest returns the latest spike of each event with any, sorted by event.
+func (m *Monitor) Latest() []Latest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	latest := make([]Latest, 0, len(m.events))
+	for name, e := range m.events {
+		latest = append(latest, Latest{Event: name, Sample: e.samples[len(e.samples)-1], Max: e.max})
+	}
+	sort.Slice(latest, func(i, j int) bool { return latest[i].Event < latest[j].Event })
+	return latest
+}
+
+// History returns the spikes of name, oldest first.
+func (m *Monitor) History(name string) []Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.events[name]; e != nil {
+		return append([]Sample(nil), e.samples...)
+	}
+	return nil
+}
+
+// Reset forgets the spikes of the named events, or of all without names,
+// and returns how many events had any.
+func (m *Monitor) Reset(names ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	if len(names) == 0 {
+		n = len(m.events)
+		m.events = make(map[string]*event)
+		return n
+	}
+	for _, name := range names {
+		if _, ok := m.events[name]; ok {
+			delete(m.events, name)
+			n++
+		}
+	}
+	return n
+}
+
+// Doctor describes the recorded spikes for a human, with advice for the
+// events it knows.
+func (m *Monitor) Doctor() string {
+	threshold := m.Threshold()
+	latest := m.Latest()
+	var b strings.Builder
+	switch {
+	case threshold <= 0:
+		b.WriteString("I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this server. Enable it with CONFIG SET latency-monitor-threshold <milliseconds>.\n")
+		return b.String()
+	case len(latest) == 0:
+		b.WriteString("Dave, no latency spike was observed during the lifetime of this server instance, not in the slightest bit. I honestly think you ought to sleep.\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Dave, I have observed latency spikes in this server of %d milliseconds or more:\n\n", threshold.Milliseconds())
+	for i, l := range latest {
+		history := m.History(l.Event)
+		var sum time.Duration
+		for _, s := range history {
+			sum += s.Latency
+		}
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %s). Worst all time event %dms.\n",
+			i+1, l.Event, len(history), (sum / time.Duration(len(history))).Milliseconds(),
+			meanDeviation(history, sum).Milliseconds(), period(history), l.Max.Milliseconds())
+	}
+	b.WriteString("\nI have a few advices for you:\n\n")
+	for _, l := range latest {
+		if advice, ok := advices[l.Event]; ok {
+			fmt.Fprintf(&b, "- %s\n", advice)
+		}
+	}
+	return b.String()
+}
+
+// advices are Doctor's, for the events the server records.
+var advices = map[string]string{
+	"command":      "Check your SLOWLOG and your latency history for the commands that are slow: KEYS, SMEMBERS or LRANGE over big keys, and the like, are O(N). Prefer SCAN and its variants.",
+	"save":         "Snapshots block writes to disk for their length: consider saving less often, or on a replica.",
+	"expire-cycle": "Many keys are expiring at the same time: spread their expiry times, for example with default_ttl_jitter.",
+}
+
+func meanDeviation(samples []Sample, sum time.Duration) time.Duration {
+	mean := sum / time.Duration(len(samples))
+	var dev time.Duration
+	for _, s := range samples {
+		d := s.Latency - mean
+		if d < 0 {
+			d = -d
+		}
+		dev += d
+	}
+	return dev / time.Duration(len(samples))
+}
+
+// period is the average time between spikes.
+func period(samples []Sample) time.Duration {
+	if len(samples) < 2 {
+		return 0
+	}
+	return samples[len(samples)-1].Time.Sub(samples[0].Time).Round(time.Second) / time.Duration(len(samples)-1)
+}
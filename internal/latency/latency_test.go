@@ -0,0 +1,96 @@
+package latency
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	m := New(0)
+	m.Add("command", time.Second)
+	if len(m.Latest()) != 0 {
+		t.Fatalf("expected nothing to be recorded while disabled")
+	}
+	if !strings.Contains(m.Doctor(), "Latency monitoring is disabled") {
+		t.Fatalf("unexpected doctor report: %q", m.Doctor())
+	}
+
+	m.SetThreshold(10 * time.Millisecond)
+	m.Add("command", 5*time.Millisecond)
+	if len(m.Latest()) != 0 {
+		t.Fatalf("expected latencies under the threshold to be ignored")
+	}
+	// Spikes within a second are merged.
+	m.Add("command", 20*time.Millisecond)
+	m.Add("command", 30*time.Millisecond)
+	m.Add("command", 15*time.Millisecond)
+	m.Add("save", 40*time.Millisecond)
+	latest := m.Latest()
+	if len(latest) != 2 || latest[0].Event != "command" || latest[0].Latency != 30*time.Millisecond || latest[0].Max != 30*time.Millisecond {
+		t.Fatalf("unexpected latest spikes: %+v", latest)
+	}
+	if h := m.History("command"); len(h) != 1 {
+		t.Fatalf("expected one merged sample, got %+v", h)
+	}
+	if report := m.Doctor(); !strings.Contains(report, "1. command: 1 latency spikes") || !strings.Contains(report, "2. save:") {
+		t.Fatalf("unexpected doctor report: %q", report)
+	}
+
+	if n := m.Reset("save", "missing"); n != 1 {
+		t.Fatalf("expected one event reset, got %d", n)
+	}
+	if n := m.Reset(); n != 1 || len(m.Latest()) != 0 {
+		t.Fatalf("expected every event to be reset, got %d", n)
+	}
+}
+
+func TestHistoryLength(t *testing.T) {
+	m := New(time.Millisecond)
+	e := &event{}
+	for i := 0; i < historyLen; i++ {
+		e.samples = append(e.samples, Sample{Time: time.Unix(int64(i), 0), Latency: time.Millisecond})
+	}
+	m.events["command"] = e
+	m.Add("command", 2*time.Millisecond)
+	h := m.History("command")
+	if len(h) != historyLen || h[0].Time.Unix() != 1 || h[len(h)-1].Latency != 2*time.Millisecond {
+		t.Fatalf("expected the oldest samples to be dropped, got %d starting at %v", len(h), h[0].Time)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if h.Percentile(50) != 0 || h.PowersOfTwo() != nil {
+		t.Fatalf("expected an empty histogram")
+	}
+	// 1µs to 1000µs, once each.
+	for usec := 1; usec <= 1000; usec++ {
+		h.Record(time.Duration(usec) * time.Microsecond)
+	}
+	for _, c := range []struct {
+		p    float64
+		want uint64
+	}{{50, 500}, {99, 990}, {99.9, 999}, {100, 1000}} {
+		got := h.Percentile(c.p)
+		if got < c.want || float64(got) > float64(c.want)*(1+1.0/subBuckets) {
+			t.Fatalf("expected p%g within 1/16th over %d, got %d", c.p, c.want, got)
+		}
+	}
+	powers := h.PowersOfTwo()
+	if n := len(powers); n != 2*11 || powers[n-2] != 1024 || powers[n-1] != 1000 {
+		t.Fatalf("expected powers of two up to 1024 counting all, got %v", powers)
+	}
+	if powers[0] != 1 || powers[1] != 1 || powers[2] != 2 || powers[3] != 2 {
+		t.Fatalf("expected exact counts under 16µs, got %v", powers[:4])
+	}
+
+	h.Record(100 * time.Hour)
+	if got := h.Percentile(100); got != maxUsec {
+		t.Fatalf("expected the longest latencies to be capped, got %d", got)
+	}
+	h.Reset()
+	if h.Count() != 0 || h.Percentile(100) != 0 {
+		t.Fatalf("expected Reset to forget the latencies")
+	}
+}
@@ -0,0 +1,174 @@
+// Package logging is the server's leveled logger: records at debug, info,
+// warn or error level, written as text or JSON lines to standard error or
+// to a file rotated by size or age. Until Setup is called, records go to
+// the standard log package's output, as text.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configure the logger.
+type Options struct {
+	// Level is one of Levels.
+	Level string
+	// Format is "text", key=value pairs, or "json".
+	Format string
+	// File is the file to log to, or standard error if empty.
+	File string
+	// MaxSize and MaxAge rotate the file: once it would grow over MaxSize
+	// bytes, or is older than MaxAge, it is renamed aside with the time
+	// appended and a new one started. MaxBackups bounds the files kept
+	// aside, the oldest being removed. 0 is no limit for each.
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+// levels maps the names Level accepts, Redis' loglevels among them, to
+// slog's levels: verbose and notice are both info.
+var levels = map[string]slog.Level{
+	"debug":   slog.LevelDebug,
+	"verbose": slog.LevelInfo,
+	"notice":  slog.LevelInfo,
+	"info":    slog.LevelInfo,
+	"warning": slog.LevelWarn,
+	"warn":    slog.LevelWarn,
+	"error":   slog.LevelError,
+}
+
+// Levels are the names of the levels, in the order errors list them.
+var Levels = []string{"debug", "verbose", "notice", "info", "warning", "warn", "error"}
+
+var (
+	level slog.LevelVar
+
+	// mu guards logger and the name of its level.
+	mu        sync.Mutex
+	logger    = slog.New(slog.NewTextHandler(stdWriter{}, &slog.HandlerOptions{Level: &level}))
+	levelName = "notice"
+	closer    io.Closer
+)
+
+// stdWriter writes to the standard log package's output, wherever it is
+// set at the time.
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	return log.Writer().Write(p)
+}
+
+// Setup replaces the logger with one configured by opts, closing the file
+// of the one before, if any.
+func Setup(opts Options) error {
+	handlerOpts := &slog.HandlerOptions{Level: &level}
+	var w io.Writer = os.Stderr
+	var f *rotatingFile
+	if opts.File != "" {
+		var err error
+		if f, err = openRotating(opts.File, opts.MaxSize, opts.MaxAge, opts.MaxBackups); err != nil {
+			return err
+		}
+		w = f
+	}
+	var h slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		h = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		if f != nil {
+			f.Close()
+		}
+		return fmt.Errorf("log format must be text or json, got %q", opts.Format)
+	}
+	if opts.Level != "" {
+		if err := SetLevel(opts.Level); err != nil {
+			if f != nil {
+				f.Close()
+			}
+			return err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	logger = slog.New(h)
+	if closer != nil {
+		closer.Close()
+		closer = nil
+	}
+	if f != nil {
+		closer = f
+	}
+	return nil
+}
+
+// SetLevel changes the level of the records logged.
+func SetLevel(name string) error {
+	l, ok := levels[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("argument must be one of %s", strings.Join(Levels, ", "))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	level.Set(l)
+	levelName = strings.ToLower(name)
+	return nil
+}
+
+// Level is the name of the level set.
+func Level() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return levelName
+}
+
+// Enabled reports whether records at l are logged, for callers to skip
+// building those that would not be.
+func Enabled(l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func current() *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	return logger
+}
+
+// Log logs a record with msg and key-value attributes at l.
+func Log(l slog.Level, msg string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	current().Log(context.Background(), l, msg, args...)
+}
+
+func logf(l slog.Level, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	current().Log(context.Background(), l, fmt.Sprintf(format, args...))
+}
+
+// Debugf, Infof, Warnf and Errorf log a message formatted as with
+// fmt.Sprintf at their level.
+
+func Debugf(format string, args ...any) { logf(slog.LevelDebug, format, args...) }
+func Infof(format string, args ...any)  { logf(slog.LevelInfo, format, args...) }
+func Warnf(format string, args ...any)  { logf(slog.LevelWarn, format, args...) }
+func Errorf(format string, args ...any) { logf(slog.LevelError, format, args...) }
+
+// Fatalf logs at error level, then exits.
+func Fatalf(format string, args ...any) {
+	logf(slog.LevelError, format, args...)
+	os.Exit(1)
+}
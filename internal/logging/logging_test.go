@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// reset restores the logger and level Setup replaced once the test ends.
+func reset(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		if closer != nil {
+			closer.Close()
+			closer = nil
+		}
+		logger = slog.New(slog.NewTextHandler(stdWriter{}, &slog.HandlerOptions{Level: &level}))
+		mu.Unlock()
+		SetLevel("notice")
+	})
+}
+
+func TestSetupJSON(t *testing.T) {
+	reset(t)
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := Setup(Options{Level: "warning", Format: "json", File: path}); err != nil {
+		t.Fatal(err)
+	}
+	Infof("left out")
+	Warnf("disk %s is slow", "sda")
+	Log(slog.LevelError, "command", "client", 7, "cmd", "get")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records at warning and over, got %q", data)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["level"] != "WARN" || rec["msg"] != "disk sda is slow" {
+		t.Fatalf("unexpected record %v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["client"] != 7.0 || rec["cmd"] != "get" {
+		t.Fatalf("expected the attributes in the record, got %v", rec)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	reset(t)
+	if err := Setup(Options{Format: "xml"}); err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+	if err := SetLevel("loud"); err == nil {
+		t.Fatal("expected an unknown level to fail")
+	}
+	if Level() != "notice" {
+		t.Fatalf("expected the level to be kept, got %q", Level())
+	}
+	if err := SetLevel("DEBUG"); err != nil || Level() != "debug" || !Enabled(slog.LevelDebug) {
+		t.Fatalf("expected level debug, got %q, %v", Level(), err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	reset(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+	if err := Setup(Options{File: path, MaxSize: 200, MaxBackups: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		Infof("record %d, long enough to need a few rotations", i)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 200 {
+		t.Fatalf("expected the log to be rotated under 200 bytes, got %d", info.Size())
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 files kept aside, got %v", backups)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "record 19,") {
+		t.Fatalf("expected the last record in the log, got %q", data)
+	}
+}
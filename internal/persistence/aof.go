@@ -3,25 +3,125 @@ package persistence
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/store"
+)
+
+// FsyncPolicy selects when the AOF is synced to disk, as Redis' appendfsync.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs every command before the client gets a reply.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncEverySec syncs once a second in the background, so a crash loses
+	// at most about a second of writes.
+	FsyncEverySec FsyncPolicy = "everysec"
+	// FsyncNo hands writes to the OS once a second and leaves syncing to it.
+	FsyncNo FsyncPolicy = "no"
 )
 
+// ParseFsyncPolicy validates a policy name from configuration.
+// An empty name selects everysec.
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	switch p := FsyncPolicy(strings.ToLower(name)); p {
+	case "":
+		return FsyncEverySec, nil
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown appendfsync policy '%s'", name)
+}
+
+// Options tunes an AOF.
+type Options struct {
+	// Fsync selects when the file is synced; empty means everysec.
+	Fsync FsyncPolicy
+	// SnapshotPreamble makes Rewrite write the dataset in the snapshot dump
+	// format, which loads much faster than replaying commands, followed by
+	// the commands logged since, like Redis' aof-use-rdb-preamble.
+	SnapshotPreamble bool
+	// LoadTruncated makes Load cut an incomplete final command off
+	// the file instead of failing, like Redis' aof-load-truncated.
+	LoadTruncated bool
+	// KeepHistory makes Rewrite move the segments it supersedes into a
+	// history directory, for archiving, instead of deleting them.
+	KeepHistory bool
+	// ReplayUntil, if set, makes Load stop at the first command logged
+	// after it, for point-in-time recovery. The rest of the AOF is moved to
+	// the history directory, so commands logged from then on follow the
+	// recovered state.
+	ReplayUntil time.Time
+	// Compression compresses the base segments written by Rewrite.
+	Compression Compression
+	// Encryption, if set, encrypts every segment written from now on.
+	// Existing plaintext segments stay readable.
+	Encryption *Encryption
+}
+
+// segmentWriter buffers appends to the open segment until Flush; it is a
+// *bufio.Writer, or a *sealWriter when the AOF is encrypted.
+type segmentWriter interface {
+	io.Writer
+	Flush() error
+	Reset(w io.Writer)
+}
+
 // AOF (Append-Only File) persistence implementation
 type AOF struct {
-	mu       sync.Mutex
-	file     *os.File
-	writer   *bufio.Writer
-	path     string
-	enabled  bool
-	syncFreq time.Duration
-	lastSync time.Time
+	mu            sync.Mutex
+	file          *os.File
+	out           *countingWriter // a.file, as the writer sees it
+	writer        segmentWriter
+	path          string // the incremental segment being appended to
+	dir           string
+	manifest      *Manifest
+	enabled       bool
+	policy        FsyncPolicy
+	loadTruncated bool
+	preamble      bool
+	compression   Compression
+	enc           *Encryption
+	keepHistory   bool
+	until         int64 // Options.ReplayUntil in Unix nanoseconds, or 0
+	rewriting     bool
+	syncFreq      time.Duration
+	lastSync      time.Time
+	stamped       int64 // time of the last timestamp annotation written
+
+	// pending holds commands a failed write left behind, to be written
+	// again before anything else; writeErr is why the last write or sync
+	// failed, or nil once one has succeeded since. See groupcommit.go.
+	pending  []byte
+	writeErr error
+	buf      []byte
+
+	// size is the current length of all segments; baseSize is what it was
+	// after the last rewrite, or when the AOF was opened.
+	size     int64
+	baseSize int64
+
+	// queue feeds the group-commit writer; see groupcommit.go. stop ends
+	// the background goroutines, and written and synced are closed when the
+	// writer and the sync goroutine have exited.
+	queue   chan aofWrite
+	stop    chan struct{}
+	written chan struct{}
+	synced  chan struct{}
 }
 
-// AOFEntry represents a single command entry in the AOF
+// AOFEntry represents a single command entry in the AOF. Timestamp is the
+// Unix time in nanoseconds at which the command was logged, to the second
+// for RESP records, or zero if unknown.
 type AOFEntry struct {
 	Timestamp int64    `json:"ts"`
 	Command   string   `json:"cmd"`
@@ -33,75 +133,207 @@ func New(dirPath string, enabled bool) (*AOF, error) {
 	if !enabled {
 		return &AOF{enabled: false}, nil
 	}
+	return NewWithOptions(dirPath, Options{LoadTruncated: true})
+}
+
+// NewWithOptions creates an enabled AOF in dirPath tuned by opts. The
+// segments and their manifest live in dirPath/appendonlydir; a single-file
+// AOF left by an older version at dirPath/commands.aof becomes the base
+// segment.
+func NewWithOptions(dirPath string, opts Options) (*AOF, error) {
+	if opts.Fsync == "" {
+		opts.Fsync = FsyncEverySec
+	}
 
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
+	dir := filepath.Join(dirPath, aofDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create persistence directory: %w", err)
 	}
-
-	filePath := filepath.Join(dirPath, "commands.aof")
-
-	// Open or create file in append mode
-	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	m, err := openManifest(dirPath, dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open AOF file: %w", err)
+		return nil, err
 	}
 
 	aof := &AOF{
-		file:     f,
-		writer:   bufio.NewWriter(f),
-		path:     filePath,
-		enabled:  true,
-		syncFreq: 1 * time.Second,
-		lastSync: time.Now(),
+		dir:           dir,
+		manifest:      m,
+		enabled:       true,
+		policy:        opts.Fsync,
+		loadTruncated: opts.LoadTruncated,
+		preamble:      opts.SnapshotPreamble,
+		compression:   opts.Compression,
+		enc:           opts.Encryption,
+		keepHistory:   opts.KeepHistory,
+		syncFreq:      1 * time.Second,
+		lastSync:      time.Now(),
+		queue:         make(chan aofWrite, queueSize),
+		stop:          make(chan struct{}),
+		written:       make(chan struct{}),
+		synced:        make(chan struct{}),
+	}
+	if !opts.ReplayUntil.IsZero() {
+		aof.until = opts.ReplayUntil.UnixNano()
+	}
+	// Keep appending to the last incremental segment, unless it is missing
+	// or encryption was turned on or off since it was written.
+	incrs := m.Incrs()
+	appendable := false
+	if len(incrs) > 0 {
+		encrypted, empty, err := fileEncrypted(filepath.Join(dir, incrs[len(incrs)-1].Name))
+		appendable = err == nil && (empty || encrypted == (aof.enc != nil))
 	}
+	if !appendable {
+		if err := aof.rotate(); err != nil {
+			return nil, err
+		}
+	} else if err := aof.open(incrs[len(incrs)-1]); err != nil {
+		return nil, err
+	}
+	if aof.size, err = aof.liveSize(); err != nil {
+		aof.file.Close()
+		return nil, err
+	}
+	aof.baseSize = aof.size
+	go aof.writeLoop()
+	go aof.syncLoop()
 
 	return aof, nil
 }
 
-// LogCommand appends a command to the AOF
+// openManifest reads the manifest in dir. Without one, a legacy single-file
+// AOF in dirPath is moved into dir as the base segment; otherwise the AOF
+// starts out empty.
+func openManifest(dirPath, dir string) (*Manifest, error) {
+	m, err := ReadManifest(filepath.Join(dir, manifestName))
+	if err == nil || !os.IsNotExist(err) {
+		return m, err
+	}
+
+	m = &Manifest{}
+	legacy := filepath.Join(dirPath, aofName)
+	if _, err := os.Stat(legacy); err != nil {
+		return m, nil
+	}
+	// Link rather than rename, so the legacy file stays in place until the
+	// manifest that replaces it has been written.
+	base := Segment{Name: segmentName(SegmentBase, 1), Seq: 1, Type: SegmentBase}
+	path := filepath.Join(dir, base.Name)
+	os.Remove(path)
+	if err := os.Link(legacy, path); err != nil {
+		return nil, fmt.Errorf("failed to move legacy AOF: %w", err)
+	}
+	m.Segments = append(m.Segments, base)
+	if err := m.write(dir); err != nil {
+		return nil, err
+	}
+	os.Remove(legacy)
+	logging.Infof("Moved legacy AOF %s into %s as its base segment", legacy, dir)
+	return m, nil
+}
+
+// LogCommand appends a command to the AOF. The write is handed to the
+// group-commit writer; with FsyncAlways LogCommand returns once the command
+// is on disk, otherwise as soon as it is queued. It must not be called after
+// Close.
 func (a *AOF) LogCommand(cmd string, args []string) error {
 	if !a.enabled {
 		return nil
 	}
 
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	entry := AOFEntry{
-		Timestamp: time.Now().UnixNano(),
-		Command:   cmd,
-		Args:      args,
+	req := aofWrite{buf: encodeCommand(cmd, args)}
+	if a.policy == FsyncAlways {
+		req.done = make(chan error, 1)
 	}
-
-	data, err := json.Marshal(entry)
-	if err != nil {
-		return fmt.Errorf("failed to marshal entry: %w", err)
+	a.queue <- req
+	if req.done != nil {
+		return <-req.done
 	}
+	return nil
+}
 
-	// Write JSON + newline
-	if _, err := a.writer.Write(append(data, '\n')); err != nil {
-		return fmt.Errorf("failed to write to AOF: %w", err)
+// QueueCommand is LogCommand without waiting for the disk, even with
+// FsyncAlways, for commands no client waits on, like the DELs of expired
+// and evicted keys: they are written, and synced, with the commands around
+// them. It must not be called after Close.
+func (a *AOF) QueueCommand(cmd string, args []string) {
+	if a.enabled {
+		a.queue <- aofWrite{buf: encodeCommand(cmd, args)}
 	}
+}
 
-	// Periodically sync to disk
-	if time.Since(a.lastSync) >= a.syncFreq {
-		if err := a.writer.Flush(); err != nil {
-			return fmt.Errorf("failed to flush AOF: %w", err)
+// syncLoop periodically flushes buffered commands to the OS and, under
+// everysec, syncs them to disk. The fsync runs without a.mu held, so
+// clients logging commands never wait for the disk.
+func (a *AOF) syncLoop() {
+	defer close(a.synced)
+	ticker := time.NewTicker(a.syncFreq)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.stop:
+			return
+		}
+
+		a.mu.Lock()
+		err := a.flushPending()
+		if err == nil {
+			err = a.writer.Flush()
 		}
-		if err := a.file.Sync(); err != nil {
-			return fmt.Errorf("failed to sync AOF: %w", err)
+		f := a.file
+		a.mu.Unlock()
+		if err != nil {
+			logging.Errorf("Failed to flush AOF: %v", err)
+			continue
 		}
+		if a.policy != FsyncEverySec {
+			continue
+		}
+		// A rewrite may have swapped and closed f meanwhile; the new file
+		// was synced when it was installed.
+		if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			logging.Errorf("Failed to sync AOF: %v", err)
+			a.mu.Lock()
+			a.writeErr = err
+			a.mu.Unlock()
+			continue
+		}
+		a.mu.Lock()
 		a.lastSync = time.Now()
+		if len(a.pending) == 0 {
+			a.writeErr = nil
+		}
+		a.mu.Unlock()
 	}
-
-	return nil
 }
 
-// ReadCommands reads all commands from the AOF file
+// ReadCommands reads all commands from the AOF file. It fails if the file
+// starts with a dataset preamble; use Load for such files.
 func (a *AOF) ReadCommands() ([]AOFEntry, error) {
+	base, entries, err := a.Load()
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		return nil, fmt.Errorf("AOF starts with a dataset preamble")
+	}
+	return entries, nil
+}
+
+// Load reads the AOF: the base segment, which holds either the dataset
+// preamble written by a rewrite with Options.SnapshotPreamble or commands,
+// then the commands of each incremental segment. base is nil when there is
+// no preamble. A base in the legacy JSON-lines format is read and then
+// rewritten in RESP format, so the migration happens once.
+//
+// A corrupt record anywhere is an error. An incomplete record at the end of
+// the last segment with data, the usual result of a crash mid-write, is cut
+// off when Options.LoadTruncated is set and reported as ErrTruncated
+// otherwise.
+func (a *AOF) Load() (base []store.Entry, entries []AOFEntry, err error) {
 	if !a.enabled {
-		return []AOFEntry{}, nil
+		return nil, []AOFEntry{}, nil
 	}
 
 	a.mu.Lock()
@@ -109,21 +341,155 @@ func (a *AOF) ReadCommands() ([]AOFEntry, error) {
 
 	// Flush before reading
 	if err := a.writer.Flush(); err != nil {
-		return nil, fmt.Errorf("failed to flush AOF: %w", err)
+		return nil, nil, fmt.Errorf("failed to flush AOF: %w", err)
 	}
 
-	f, err := os.Open(a.path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []AOFEntry{}, nil
+	segs := a.manifest.Segments
+	hasData := make([]bool, len(segs))
+	for i, seg := range segs {
+		path := filepath.Join(a.dir, seg.Name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("AOF segment %s listed in the manifest is unreadable: %w", seg.Name, err)
 		}
-		return nil, fmt.Errorf("failed to open AOF file: %w", err)
+		hasData[i] = info.Size() > 0
+		if info.Size() == int64(encHeaderSize) {
+			encrypted, _, _ := fileEncrypted(path)
+			hasData[i] = !encrypted
+		}
+	}
+
+	entries = []AOFEntry{}
+	for i, seg := range segs {
+		tail := !slices.Contains(hasData[i+1:], true)
+		segBase, segEntries, cut, err := a.readSegment(seg, tail)
+		if err != nil {
+			return nil, nil, fmt.Errorf("AOF segment %s: %w", seg.Name, err)
+		}
+		if segBase != nil {
+			base = segBase
+		}
+		entries = append(entries, segEntries...)
+		if cut >= 0 {
+			if err := a.setAside(i, cut); err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+	}
+	if a.size, err = a.liveSize(); err != nil {
+		return nil, nil, err
+	}
+	a.baseSize = a.size
+	return base, entries, nil
+}
+
+// readSegment reads the records of one segment. Only a base may hold a
+// preamble or legacy JSON, and only the tail segment, the last one with
+// data, may end with an incomplete record. With Options.ReplayUntil, cut is
+// the offset of the first command logged after it, or -1 if there is none.
+// Must be called with a.mu held.
+func (a *AOF) readSegment(seg Segment, tail bool) (base []store.Entry, entries []AOFEntry, cut int64, err error) {
+	path := filepath.Join(a.dir, seg.Name)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, -1, fmt.Errorf("failed to open AOF file: %w", err)
 	}
 	defer f.Close()
 
+	r, err := openSegment(f, a.enc)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	var start int64
+	if seg.Type == SegmentBase {
+		if first, err := r.Peek(1); err == nil && first[0] == '{' && !r.compressed {
+			entries, err := readJSONCommands(r)
+			if err == ErrTruncated && tail && a.loadTruncated {
+				logging.Warnf("dropping incomplete last line of legacy AOF after %d commands", len(entries))
+			} else if err != nil {
+				return nil, nil, -1, err
+			}
+			if err := migrate(path, entries, a.enc); err != nil {
+				return nil, nil, -1, err
+			}
+			return a.readSegment(seg, tail)
+		}
+		if base, err = readPreamble(r.Reader); err != nil {
+			return nil, nil, -1, err
+		}
+		start = r.offset()
+	}
+
+	// Record offsets are file offsets only in plaintext. An encrypted
+	// segment is truncated and cut at chunk boundaries instead, and a
+	// compressed base was written whole, so it can be neither.
+	plain := r.sealed == nil && !r.compressed
+	rr := newRecordReader(r.Reader)
+	rr.offset = start
+	entries = []AOFEntry{}
+	for {
+		pos := rr.offset
+		e, err := rr.next()
+		if err == io.EOF && r.sealed != nil && r.sealed.torn {
+			if tail && a.loadTruncated {
+				return base, entries, -1, truncateAt(path, r.sealed.validTo, len(entries))
+			}
+			err = ErrTruncated
+		}
+		if err == io.EOF && r.sealed != nil && !r.sealed.finished && !tail {
+			// Only the segment still being appended to is unfinished.
+			err = ErrTruncated
+		}
+		if err == io.EOF {
+			if seg.Type == SegmentBase && a.until != 0 && rr.ts > a.until {
+				return nil, nil, -1, a.errRewrittenAfter(rr.ts)
+			}
+			return base, entries, -1, nil
+		}
+		if err == ErrTruncated && tail && a.loadTruncated && plain {
+			return base, entries, -1, truncateAt(path, rr.offset, len(entries))
+		}
+		if err != nil {
+			return nil, nil, -1, fmt.Errorf("failed to read AOF after %d commands: %w", len(entries), err)
+		}
+		if a.until != 0 && e.Timestamp > a.until {
+			// A base written by a rewrite is stamped with the time of the
+			// rewrite as a whole, so it cannot be cut either.
+			if seg.Type == SegmentBase && (pos == start || r.compressed) {
+				return nil, nil, -1, a.errRewrittenAfter(e.Timestamp)
+			}
+			if r.sealed != nil {
+				// Timestamps change at the start of a batch, which is
+				// where chunks start.
+				if pos, ok := r.sealed.fileOffset(pos); ok {
+					return base, entries, pos, nil
+				}
+				return nil, nil, -1, fmt.Errorf("cannot cut encrypted AOF segment between chunks at offset %d", pos)
+			}
+			return base, entries, pos, nil
+		}
+		entries = append(entries, e)
+	}
+}
+
+// truncateAt cuts an incomplete final record off the segment at path.
+func truncateAt(path string, offset int64, commands int) error {
+	logging.Warnf("AOF ends with an incomplete command; truncating %s at offset %d after %d commands", filepath.Base(path), offset, commands)
+	if err := os.Truncate(path, offset); err != nil {
+		return fmt.Errorf("failed to truncate AOF: %w", err)
+	}
+	return nil
+}
+
+// readJSONCommands reads the legacy JSON-lines AOF format. A malformed line
+// is corruption unless it is the last one, which is reported as ErrTruncated
+// along with the commands before it.
+func readJSONCommands(r io.Reader) ([]AOFEntry, error) {
 	var entries []AOFEntry
-	scanner := bufio.NewScanner(f)
-	lineNum := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	lineNum, badLine := 0, 0
 
 	for scanner.Scan() {
 		lineNum++
@@ -131,11 +497,13 @@ func (a *AOF) ReadCommands() ([]AOFEntry, error) {
 		if len(line) == 0 {
 			continue
 		}
+		if badLine > 0 {
+			return nil, fmt.Errorf("corrupt AOF: malformed line %d", badLine)
+		}
 
 		var entry AOFEntry
 		if err := json.Unmarshal(line, &entry); err != nil {
-			// Log malformed line but continue
-			fmt.Printf("warning: skipping malformed AOF line %d: %v\n", lineNum, err)
+			badLine = lineNum
 			continue
 		}
 
@@ -145,65 +513,275 @@ func (a *AOF) ReadCommands() ([]AOFEntry, error) {
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error reading AOF file: %w", err)
 	}
+	if badLine > 0 {
+		return entries, ErrTruncated
+	}
 
 	return entries, nil
 }
 
-// Fsync forces a sync to disk
-func (a *AOF) Fsync() error {
-	if !a.enabled {
-		return nil
+// migrate replaces a legacy JSON segment with the same commands in RESP
+// format, keeping their timestamps to the second, and encrypted with enc
+// if it is set.
+func migrate(path string, entries []AOFEntry, enc *Encryption) error {
+	tmp := path + ".migrate"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to migrate AOF: %w", err)
+	}
+	out, err := wholeFileWriter(f, CompressionNone, enc)
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to migrate AOF: %w", err)
+	}
+	w := bufio.NewWriter(out)
+	var stamped int64
+	for _, e := range entries {
+		if ts := e.Timestamp / int64(time.Second); ts != stamped {
+			w.Write(encodeTimestamp(ts))
+			stamped = ts
+		}
+		w.Write(encodeCommand(e.Command, e.Args))
+	}
+	if err = w.Flush(); err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to migrate AOF: %w", err)
+	}
+	logging.Infof("Migrated %d AOF entries from JSON to RESP format", len(entries))
+	return nil
+}
+
+// open makes seg, an incremental segment, the one commands are appended to.
+// Must be called with a.mu held and the writer flushed.
+func (a *AOF) open(seg Segment) error {
+	path := filepath.Join(a.dir, seg.Name)
+	var sealed *sealWriter
+	if a.enc != nil {
+		var err error
+		if sealed, err = a.enc.appender(path); err != nil {
+			return fmt.Errorf("failed to open AOF file: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open AOF file: %w", err)
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file, a.path = f, path
+	a.out = &countingWriter{w: f, n: info.Size()}
+	a.stamped = 0
+	switch {
+	case sealed != nil:
+		sealed.Reset(a.out)
+		a.writer = sealed
+	case a.writer != nil:
+		a.writer.Reset(a.out)
+	default:
+		a.writer = bufio.NewWriter(a.out)
+	}
+	return nil
+}
+
+// rotate starts a new incremental segment and records it in the manifest,
+// so the commands logged from now on go to a file of their own. The previous
+// segment is synced first unless the policy leaves syncing to the OS. Must
+// be called with a.mu held.
+func (a *AOF) rotate() error {
+	if a.file != nil {
+		// Commands left behind by a failed write belong before the new
+		// segment, and a rewrite must not start without them on disk.
+		if err := a.flushPending(); err != nil {
+			return fmt.Errorf("failed to flush AOF: %w", err)
+		}
+		if err := a.writer.Flush(); err != nil {
+			return fmt.Errorf("failed to flush AOF: %w", err)
+		}
+		// An encrypted segment is finished with its final chunk, so that
+		// chunks cut off its end are noticed.
+		if sealed, ok := a.writer.(*sealWriter); ok {
+			start := a.out.n
+			if err := sealed.Close(); err != nil {
+				if a.out.n != start && a.file.Truncate(start) == nil {
+					a.out.n = start
+				}
+				return fmt.Errorf("failed to finish AOF segment: %w", err)
+			}
+		}
+		if a.policy != FsyncNo {
+			if err := a.file.Sync(); err != nil {
+				return fmt.Errorf("failed to sync AOF: %w", err)
+			}
+		}
+	}
+
+	seq := a.manifest.nextSeq(SegmentIncr)
+	seg := Segment{Name: segmentName(SegmentIncr, seq), Seq: seq, Type: SegmentIncr}
+	// Create the file before the manifest names it; a stale file of the same
+	// name, left by a crash before the manifest was written, is emptied.
+	// open gives an encrypted segment its header.
+	if err := os.WriteFile(filepath.Join(a.dir, seg.Name), nil, 0644); err != nil {
+		return fmt.Errorf("failed to create AOF segment: %w", err)
+	}
+	m := &Manifest{Segments: append(slices.Clone(a.manifest.Segments), seg)}
+	if err := m.write(a.dir); err != nil {
+		return err
+	}
+	a.manifest = m
+	return a.open(seg)
+}
+
+// liveSize returns the total length of the segments in the manifest. Must
+// be called with a.mu held and the writer flushed.
+func (a *AOF) liveSize() (int64, error) {
+	var size int64
+	for _, seg := range a.manifest.Segments {
+		info, err := os.Stat(filepath.Join(a.dir, seg.Name))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to stat AOF segment: %w", err)
+		}
+		if err == nil {
+			size += info.Size()
+		}
 	}
+	return size, nil
+}
 
+// retire removes segments that are no longer in the manifest, or moves them
+// into the history directory with Options.KeepHistory.
+func (a *AOF) retire(segs []Segment) {
+	if a.keepHistory {
+		a.archive(segs)
+		return
+	}
+	for _, seg := range segs {
+		if err := os.Remove(filepath.Join(a.dir, seg.Name)); err != nil && !os.IsNotExist(err) {
+			logging.Errorf("Failed to remove AOF segment %s: %v", seg.Name, err)
+		}
+	}
+}
+
+// archive moves segments into the history directory.
+func (a *AOF) archive(segs []Segment) {
+	if len(segs) == 0 {
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(a.dir, historyDir), 0755); err != nil {
+		logging.Errorf("Failed to create AOF history directory: %v", err)
+		return
+	}
+	for _, seg := range segs {
+		err := os.Rename(filepath.Join(a.dir, seg.Name), filepath.Join(a.dir, historyDir, seg.Name))
+		if err != nil && !os.IsNotExist(err) {
+			logging.Errorf("Failed to archive AOF segment %s: %v", seg.Name, err)
+		}
+	}
+}
+
+// Size returns the current length of all AOF segments and their length
+// after the last rewrite (or when the AOF was opened), for deciding when to rewrite again.
+func (a *AOF) Size() (current, base int64) {
+	if !a.enabled {
+		return 0, 0
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	return a.size, a.baseSize
+}
 
-	if err := a.writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush AOF: %w", err)
+// WriteErr returns why the last write to the AOF or sync of it failed, or
+// nil if one has succeeded since. Commands that could not be written are
+// kept and written again, in order, once the disk accepts them.
+func (a *AOF) WriteErr() error {
+	if !a.enabled {
+		return nil
 	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writeErr
+}
 
-	if err := a.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync AOF: %w", err)
+// Fsync forces a sync to disk of every command logged so far.
+func (a *AOF) Fsync() error {
+	if !a.enabled {
+		return nil
 	}
-
-	a.lastSync = time.Now()
-	return nil
+	return a.barrier(true)
 }
 
-// Close closes the AOF file
+// Close closes the AOF file once every queued command has been written and
+// synced to disk, whatever the fsync policy, as Redis does on shutdown.
 func (a *AOF) Close() error {
 	if !a.enabled || a.file == nil {
 		return nil
 	}
 
+	close(a.stop)
+	<-a.written
+	<-a.synced
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if err := a.flushPending(); err != nil {
+		return fmt.Errorf("failed to flush AOF on close: %w", err)
+	}
 	if err := a.writer.Flush(); err != nil {
 		return fmt.Errorf("failed to flush AOF on close: %w", err)
 	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync AOF on close: %w", err)
+	}
 
 	return a.file.Close()
 }
 
-// Truncate clears the AOF file (useful for snapshots)
+// Truncate discards every command logged so far and starts the AOF afresh
+// with an empty segment (useful for snapshots).
 func (a *AOF) Truncate() error {
 	if !a.enabled {
 		return nil
 	}
+	if err := a.barrier(false); err != nil {
+		return err
+	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if err := a.file.Truncate(0); err != nil {
+	if a.rewriting {
+		return ErrRewriteInProgress
+	}
+	old := a.manifest.Segments
+	if err := a.rotate(); err != nil {
 		return fmt.Errorf("failed to truncate AOF: %w", err)
 	}
-
-	if _, err := a.file.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to seek in AOF: %w", err)
+	m := &Manifest{Segments: a.manifest.Segments[len(old):]}
+	if err := m.write(a.dir); err != nil {
+		return fmt.Errorf("failed to truncate AOF: %w", err)
 	}
-
-	a.writer.Reset(a.file)
+	a.manifest = m
+	a.retire(old)
 	a.lastSync = time.Now()
+	a.size, a.baseSize = 0, 0
 	return nil
 }
@@ -0,0 +1,152 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAOFRoundTripRESP(t *testing.T) {
+	dir := t.TempDir()
+	aof, err := New(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.LogCommand("SET", []string{"k", "hello world"})
+	aof.LogCommand("RPUSH", []string{"l", "a", ""})
+	aof.Close()
+
+	data, _ := os.ReadFile(aof.path)
+	if !strings.HasPrefix(string(data), "#TS:") || !strings.Contains(string(data), "\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$11\r\nhello world\r\n") {
+		t.Fatalf("expected a timestamp annotation and RESP framing, got %q", data)
+	}
+
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	entries, err := aof.ReadCommands()
+	if err != nil {
+		t.Fatalf("ReadCommands failed: %v", err)
+	}
+	if len(entries) != 2 || entries[1].Command != "RPUSH" || len(entries[1].Args) != 3 || entries[1].Args[2] != "" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if age := time.Since(time.Unix(0, entries[0].Timestamp)); age < 0 || age > time.Minute {
+		t.Fatalf("expected entries stamped with the time they were logged, got %d", entries[0].Timestamp)
+	}
+}
+
+func TestAOFMigratesLegacyJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "commands.aof")
+	legacy := `{"ts":1,"cmd":"SET","args":["k","v"]}` + "\n" + `{"ts":2,"cmd":"DEL","args":["k"]}` + "\n"
+	os.WriteFile(path, []byte(legacy), 0644)
+
+	aof, _ := New(dir, true)
+	entries, err := aof.ReadCommands()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 legacy entries, got %d (%v)", len(entries), err)
+	}
+	aof.LogCommand("SET", []string{"k2", "v2"})
+	aof.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected legacy file moved into the AOF directory, got %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, aofDirName, segmentName(SegmentBase, 1)))
+	if !strings.HasPrefix(string(data), "*3\r\n$3\r\nSET") {
+		t.Fatalf("expected base segment rewritten in RESP format, got %q", data)
+	}
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	entries, err = aof.ReadCommands()
+	if err != nil || len(entries) != 3 || entries[2].Args[0] != "k2" {
+		t.Fatalf("unexpected entries after migration: %+v (%v)", entries, err)
+	}
+}
+
+func TestAOFTruncatesIncompleteTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "commands.aof")
+	complete := encodeCommand("DEL", []string{"k"})
+	partial := encodeCommand("SET", []string{"k", "v"})
+	os.WriteFile(path, append(append([]byte{}, complete...), partial[:len(partial)-6]...), 0644)
+
+	aof, _ := NewWithOptions(dir, Options{LoadTruncated: false})
+	if _, err := aof.ReadCommands(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated without aof_load_truncated, got %v", err)
+	}
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, Options{LoadTruncated: true})
+	entries, err := aof.ReadCommands()
+	if err != nil || len(entries) != 1 || entries[0].Command != "DEL" {
+		t.Fatalf("expected the complete command only, got %+v (%v)", entries, err)
+	}
+	aof.LogCommand("SET", []string{"k2", "v"})
+	aof.Close()
+
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	entries, err = aof.ReadCommands()
+	if err != nil || len(entries) != 2 || entries[1].Args[0] != "k2" {
+		t.Fatalf("expected file truncated before new writes, got %+v (%v)", entries, err)
+	}
+}
+
+func TestAOFDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "commands.aof")
+	first := encodeCommand("SET", []string{"a", "1"})
+	second := encodeCommand("SET", []string{"b", "2"})
+	data := append(append([]byte{}, first...), second...)
+	data = append(data, encodeCommand("SET", []string{"c", "3"})...)
+	data[len(first)+len(second)-14] = 'X' // inside the value of the second command
+	os.WriteFile(path, data, 0644)
+
+	aof, _ := New(dir, true)
+	defer aof.Close()
+	_, err := aof.ReadCommands()
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) || corrupt.Offset != int64(len(first)) {
+		t.Fatalf("expected corruption at offset %d, got %v", len(first), err)
+	}
+}
+
+func TestAOFReadsRecordsWithoutChecksums(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "commands.aof"), []byte("*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"), 0644)
+
+	aof, _ := New(dir, true)
+	defer aof.Close()
+	entries, err := aof.ReadCommands()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 commands, got %+v (%v)", entries, err)
+	}
+}
+
+func TestAOFFsyncPolicies(t *testing.T) {
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Fatalf("expected error for unknown policy")
+	}
+	if p, _ := ParseFsyncPolicy(""); p != FsyncEverySec {
+		t.Fatalf("expected everysec by default, got %s", p)
+	}
+
+	aof, _ := NewWithOptions(t.TempDir(), Options{Fsync: FsyncAlways})
+	aof.LogCommand("SET", []string{"k", "v"})
+	if data, _ := os.ReadFile(aof.path); len(data) == 0 {
+		t.Fatalf("expected command on disk before LogCommand returns with always")
+	}
+	aof.Close()
+
+	aof, _ = NewWithOptions(t.TempDir(), Options{Fsync: FsyncEverySec})
+	defer aof.Close()
+	aof.LogCommand("SET", []string{"k", "v"})
+	time.Sleep(100 * time.Millisecond)
+	if data, _ := os.ReadFile(aof.path); len(data) == 0 {
+		t.Fatalf("expected background goroutine to write the command out")
+	}
+}
@@ -0,0 +1,205 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"redis-from-scratch/internal/store"
+)
+
+// AOFCheck describes the result of validating an AOF file.
+type AOFCheck struct {
+	Size     int64 // length of the file, after decompression
+	Commands int   // valid commands before the first problem
+	ValidTo  int64 // offset just past the last valid command
+	// Err is the first problem found: ErrTruncated, a *CorruptError, or nil
+	// when the whole file is valid.
+	Err error
+}
+
+// OK reports whether the whole file is valid.
+func (c AOFCheck) OK() bool {
+	return c.Err == nil
+}
+
+// CheckAOF validates every record of the AOF at path without loading it. The
+// returned error is for failures to read the file and for a corrupt dataset
+// preamble, which truncation cannot repair; problems with the commands are
+// reported in AOFCheck.Err. An encrypted file needs enc; its problems are
+// reported at chunk boundaries, where it can be truncated.
+func CheckAOF(path string, enc *Encryption) (AOFCheck, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AOFCheck{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return AOFCheck{}, err
+	}
+
+	check := AOFCheck{Size: info.Size()}
+	r, err := openSegment(f, enc)
+	if err != nil {
+		return check, err
+	}
+	if first, err := r.Peek(1); err == nil && first[0] == '{' {
+		return check, fmt.Errorf("%s is in the legacy JSON format; start the server once to convert it", path)
+	}
+	if _, err := readPreamble(r.Reader); err != nil {
+		return check, err
+	}
+	rr := newRecordReader(r.Reader)
+	rr.offset = r.offset()
+	for {
+		_, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		var corrupt *CorruptError
+		if err == ErrTruncated || errors.As(err, &corrupt) {
+			check.Err = err
+			break
+		}
+		if err != nil {
+			return check, err
+		}
+		check.Commands++
+	}
+	check.ValidTo = rr.offset
+	switch {
+	case r.sealed != nil:
+		var corrupt *CorruptError
+		if errors.As(r.sealed.err, &corrupt) {
+			check.Err = corrupt
+		} else if check.Err != nil {
+			// The chunks are intact, so the damage was done before
+			// encryption and cannot be cut off at a chunk boundary.
+			return check, fmt.Errorf("encrypted AOF %s is damaged: %w", path, check.Err)
+		} else if r.sealed.torn {
+			check.Err = ErrTruncated
+		}
+		check.ValidTo = r.sealed.validTo
+	case r.compressed:
+		// Offsets are in the decompressed contents, so truncation cannot
+		// repair the file.
+		if check.Err != nil {
+			return check, fmt.Errorf("compressed AOF %s is damaged: %w", path, check.Err)
+		}
+		check.Size = check.ValidTo
+	}
+	return check, nil
+}
+
+// AOFFiles resolves path to the AOF files to check, in replay order. path
+// may be a single AOF file, a manifest, or a directory holding a manifest:
+// the appendonlydir or the persistence directory above it.
+func AOFFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := path
+	if info.IsDir() {
+		manifest = filepath.Join(path, manifestName)
+		if _, err := os.Stat(manifest); os.IsNotExist(err) {
+			manifest = filepath.Join(path, aofDirName, manifestName)
+		}
+	} else if !strings.HasSuffix(path, ".manifest") {
+		return []string{path}, nil
+	}
+
+	m, err := ReadManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(m.Segments))
+	for i, seg := range m.Segments {
+		files[i] = filepath.Join(filepath.Dir(manifest), seg.Name)
+	}
+	return files, nil
+}
+
+// ReadAOF reads the dataset preamble and the commands of the AOF at path,
+// which may be anything AOFFiles accepts, without changing any file: unlike
+// Load it neither cuts off a torn tail nor converts a legacy AOF. An
+// incomplete record at the end of the last file ends the read with
+// ErrTruncated, along with everything read before it.
+func ReadAOF(path string, enc *Encryption) (base []store.Entry, entries []AOFEntry, err error) {
+	files, err := AOFFiles(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries = []AOFEntry{}
+	for i, file := range files {
+		fileBase, fileEntries, err := readAOFFile(file, enc)
+		if fileBase != nil {
+			base = fileBase
+		}
+		entries = append(entries, fileEntries...)
+		if err == ErrTruncated && i == len(files)-1 {
+			return base, entries, err
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return base, entries, nil
+}
+
+func readAOFFile(path string, enc *Encryption) ([]store.Entry, []AOFEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	r, err := openSegment(f, enc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first, err := r.Peek(1); err == nil && first[0] == '{' {
+		return nil, nil, fmt.Errorf("legacy JSON format; start the server once to convert it")
+	}
+	base, err := readPreamble(r.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	rr := newRecordReader(r.Reader)
+	var entries []AOFEntry
+	for {
+		e, err := rr.next()
+		if err == io.EOF && r.sealed != nil && r.sealed.torn {
+			err = ErrTruncated
+		}
+		if err == io.EOF {
+			return base, entries, nil
+		}
+		if err != nil {
+			return base, entries, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// RepairAOF truncates the AOF at path to offset, normally AOFCheck.ValidTo,
+// discarding everything from the first bad record on.
+func RepairAOF(path string, offset int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to truncate AOF: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync AOF: %w", err)
+	}
+	return f.Close()
+}
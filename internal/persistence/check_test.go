@@ -0,0 +1,52 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckAndRepairAOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.aof")
+	good := append(encodeCommand("SET", []string{"a", "1"}), encodeCommand("SET", []string{"b", "2"})...)
+	os.WriteFile(path, good, 0644)
+
+	check, err := CheckAOF(path, nil)
+	if err != nil || !check.OK() || check.Commands != 2 || check.ValidTo != int64(len(good)) {
+		t.Fatalf("expected valid AOF, got %+v (%v)", check, err)
+	}
+
+	bad := append(append([]byte{}, good...), "*2\r\n$3\r\nDEL\r\n$1"...)
+	os.WriteFile(path, bad, 0644)
+	check, _ = CheckAOF(path, nil)
+	if check.OK() || check.Err != ErrTruncated || check.ValidTo != int64(len(good)) {
+		t.Fatalf("expected truncated tail after %d bytes, got %+v", len(good), check)
+	}
+
+	if err := RepairAOF(path, check.ValidTo); err != nil {
+		t.Fatalf("RepairAOF failed: %v", err)
+	}
+	if check, _ = CheckAOF(path, nil); !check.OK() || check.Size != int64(len(good)) {
+		t.Fatalf("expected repaired AOF to be valid, got %+v", check)
+	}
+}
+
+func TestReadAOFLeavesFilesAlone(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	aof.LogCommand("SET", []string{"a", "1"})
+	aof.LogCommand("SET", []string{"b", "2"})
+	path := aof.path
+	aof.Close()
+
+	data, _ := os.ReadFile(path)
+	torn := data[:len(data)-3]
+	os.WriteFile(path, torn, 0644)
+	base, entries, err := ReadAOF(dir, nil)
+	if err != ErrTruncated || base != nil || len(entries) != 1 {
+		t.Fatalf("expected 1 command before the torn tail, got %+v (%v)", entries, err)
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(torn) {
+		t.Fatalf("expected ReadAOF not to truncate the file")
+	}
+}
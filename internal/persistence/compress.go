@@ -0,0 +1,81 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compression selects how snapshots and rewritten AOF base segments are
+// compressed. Readers recognize compressed files by their header, so
+// changing the setting never makes existing files unreadable.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	// CompressionLZ4 is much faster than gzip, at a somewhat lower ratio.
+	CompressionLZ4 Compression = "lz4"
+)
+
+// ParseCompression validates a compression name from configuration. An
+// empty name selects none.
+func ParseCompression(name string) (Compression, error) {
+	switch c := Compression(strings.ToLower(name)); c {
+	case "":
+		return CompressionNone, nil
+	case CompressionNone, CompressionGzip, CompressionLZ4:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compression '%s'", name)
+}
+
+// writer wraps w so that what is written to it is compressed. Closing it
+// finishes the compressed stream but does not close w.
+func (c Compression) writer(w io.Writer) io.WriteCloser {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(w)
+	case CompressionLZ4:
+		return newLZ4Writer(w)
+	}
+	return nopWriteCloser{w}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// decompress returns a reader of the contents of r, decompressing them if r
+// starts with a gzip or LZ4 header, and whether it did.
+func decompress(r *bufio.Reader) (io.Reader, bool, error) {
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, false, fmt.Errorf("corrupt gzip header: %w", err)
+		}
+		return zr, true, nil
+	case len(magic) == 4 && binary.LittleEndian.Uint32(magic) == lz4Magic:
+		return newLZ4Reader(r), true, nil
+	}
+	return r, false, nil
+}
+
+// countingReader counts the bytes read through it, so that offsets can be
+// reported in the decompressed stream.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,138 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/store"
+)
+
+func TestXXH32(t *testing.T) {
+	if h := xxh32(nil, 0); h != 0x02CC5D05 {
+		t.Fatalf("xxh32 of nothing: got %08x", h)
+	}
+	// The descriptor of a frame with independent 64KB blocks, as written by
+	// the reference implementation: 60 40 82.
+	if hc := byte(xxh32([]byte{0x60, 0x40}, 0) >> 8); hc != 0x82 {
+		t.Fatalf("header checksum: got %02x", hc)
+	}
+}
+
+func TestLZ4RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 100_000)
+	rng.Read(random)
+	var text bytes.Buffer
+	for i := 0; text.Len() < 300_000; i++ {
+		text.Write(encodeCommand("SET", []string{fmt.Sprint("key:", i%1000), "value"}))
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":  nil,
+		"short":  []byte("hello"),
+		"runs":   bytes.Repeat([]byte{'a'}, 70_000),
+		"random": random,
+		"text":   text.Bytes(),
+	} {
+		var buf bytes.Buffer
+		w := newLZ4Writer(&buf)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if name == "text" && buf.Len() > len(data)/3 {
+			t.Fatalf("expected command streams to compress at least 3x, got %d -> %d bytes", len(data), buf.Len())
+		}
+		got, err := io.ReadAll(newLZ4Reader(bufio.NewReader(&buf)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: round trip failed (%v), %d -> %d bytes", name, err, len(data), len(got))
+		}
+	}
+}
+
+func TestLZ4RejectsCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	w := newLZ4Writer(&buf)
+	w.Write(bytes.Repeat([]byte("abcdefgh"), 1000))
+	w.Close()
+	data := buf.Bytes()
+	data[5] ^= 0xFF // frame descriptor
+	if _, err := io.ReadAll(newLZ4Reader(bufio.NewReader(bytes.NewReader(data)))); err == nil {
+		t.Fatalf("expected a damaged header to be rejected")
+	}
+	data[5] ^= 0xFF
+	if _, err := io.ReadAll(newLZ4Reader(bufio.NewReader(bytes.NewReader(data[:len(data)-6])))); err == nil {
+		t.Fatalf("expected a truncated frame to be rejected")
+	}
+}
+
+func TestCompressedSnapshot(t *testing.T) {
+	s := store.New()
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprint("key:", i), strings.Repeat("v", 100), 0)
+	}
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionLZ4} {
+		path := filepath.Join(t.TempDir(), "dump.snapshot")
+		if _, err := WriteSnapshot(path, s, c, nil); err != nil {
+			t.Fatalf("%s: WriteSnapshot failed: %v", c, err)
+		}
+		if info, _ := os.Stat(path); c != CompressionNone && info.Size() > 20_000 {
+			t.Fatalf("%s: expected a compressed snapshot, got %d bytes", c, info.Size())
+		}
+		got := store.New()
+		if err := LoadSnapshot(path, got, nil); err != nil || got.Size() != 1000 {
+			t.Fatalf("%s: expected 1000 keys, got %d (%v)", c, got.Size(), err)
+		}
+	}
+	if _, err := ParseCompression("zstd"); err == nil {
+		t.Fatalf("expected error for unknown compression")
+	}
+}
+
+func TestCompressedRewrite(t *testing.T) {
+	for _, preamble := range []bool{false, true} {
+		dir := t.TempDir()
+		opts := Options{SnapshotPreamble: preamble, Compression: CompressionLZ4}
+		aof, _ := NewWithOptions(dir, opts)
+		s := store.New()
+		var writes sync.RWMutex
+		for i := 0; i < 500; i++ {
+			apply(s, aof, &writes, "SET", fmt.Sprint("key:", i), "value")
+		}
+		if _, err := aof.Rewrite(s, &writes); err != nil {
+			t.Fatalf("Rewrite failed: %v", err)
+		}
+		apply(s, aof, &writes, "SET", "after", "v")
+		aof.Close()
+
+		files, _ := AOFFiles(dir)
+		check, err := CheckAOF(files[0], nil)
+		if err != nil || !check.OK() {
+			t.Fatalf("expected a valid compressed base, got %+v (%v)", check, err)
+		}
+
+		aof, _ = NewWithOptions(dir, opts)
+		base, entries, err := aof.Load()
+		aof.Close()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		got := store.New()
+		got.Restore(base)
+		for _, e := range entries {
+			command.Execute(got, e.Command, e.Args)
+		}
+		if got.Size() != 501 {
+			t.Fatalf("preamble=%v: expected 501 keys after replay, got %d", preamble, got.Size())
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package persistence
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encrypted file format: a header of the magic, a kind byte and a random
+// 16-byte file ID, then chunks of
+// [4-byte big-endian ciphertext length][12-byte random nonce][ciphertext],
+// each sealed with AES-GCM. A chunk's additional data is the header, its
+// index in the file and whether it is the final chunk, so a chunk that is
+// altered, moved, repeated or taken from another file fails authentication.
+// A whole file ends with a final chunk, and one that is missing it has been
+// truncated. Appended AOF segments seal one chunk per flush, so a chunk
+// always ends on a record boundary and a torn final chunk can be cut off
+// like a torn record; they get their final chunk when the AOF moves on to
+// the next segment, so only the segment still being appended to may end
+// without one.
+const (
+	encMagic      = "RFSGCM02"
+	encHeaderSize = len(encMagic) + 1 + 16
+	encChunkSize  = 64 << 10 // plaintext per chunk when writing whole files
+	encMaxChunk   = 1 << 30
+)
+
+// Kinds of encrypted file, the byte after the magic.
+const (
+	encWhole    = 'W' // written from start to end
+	encAppended = 'A' // an AOF segment appended to a flush at a time
+)
+
+// EncryptionKeyEnv names the environment variable the server reads the
+// encryption key from when the config does not set one.
+const EncryptionKeyEnv = "REDIS_ENCRYPTION_KEY"
+
+// ErrNoKey is returned when reading an encrypted file without a key.
+var ErrNoKey = errors.New("file is encrypted but no encryption key is configured")
+
+// Encryption encrypts persistence files with AES-GCM. A nil *Encryption
+// leaves them in plaintext.
+type Encryption struct {
+	aead cipher.AEAD
+}
+
+// NewEncryption creates an Encryption from an AES key of 16, 24 or 32 bytes.
+func NewEncryption(key []byte) (*Encryption, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryption{aead: aead}, nil
+}
+
+// ParseKey decodes an encryption key given in hex or base64.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid encryption key: expected hex or base64")
+}
+
+// EncryptionFromEnv returns the Encryption for the key in EncryptionKeyEnv,
+// or nil if the variable is not set.
+func EncryptionFromEnv() (*Encryption, error) {
+	s := os.Getenv(EncryptionKeyEnv)
+	if s == "" {
+		return nil, nil
+	}
+	key, err := ParseKey(s)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryption(key)
+}
+
+// header returns a new header for a file of the given kind.
+func (e *Encryption) header(kind byte) ([]byte, error) {
+	h := make([]byte, encHeaderSize)
+	copy(h, encMagic)
+	h[len(encMagic)] = kind
+	if _, err := rand.Read(h[len(encMagic)+1:]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// chunkAAD appends to dst the additional data of chunk index of the file
+// with the given header.
+func chunkAAD(dst, header []byte, index uint64, final bool) []byte {
+	dst = append(dst, header...)
+	dst = binary.BigEndian.AppendUint64(dst, index)
+	if final {
+		return append(dst, 1)
+	}
+	return append(dst, 0)
+}
+
+// seal appends the chunk holding p to dst, with ad as its additional data.
+func (e *Encryption) seal(dst, p, ad []byte) ([]byte, error) {
+	at := len(dst)
+	dst = append(dst, make([]byte, 4+e.aead.NonceSize())...)
+	nonce := dst[at+4:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = e.aead.Seal(dst, nonce, p, ad)
+	binary.BigEndian.PutUint32(dst[at:], uint32(len(dst)-at-4-len(nonce)))
+	return dst, nil
+}
+
+// sealWriter encrypts what is written to it. Whole files are sealed every
+// encChunkSize bytes; appended segments only on Flush, so that chunks end
+// where the AOF's batches do.
+type sealWriter struct {
+	enc    *Encryption
+	w      io.Writer
+	header []byte
+	index  uint64 // of the next chunk
+	buf    []byte
+	out    []byte
+	ad     []byte
+	chunk  int // seal once this much is buffered; 0 seals on Flush only
+}
+
+// writer returns a sealWriter for a whole new file, starting with its
+// header. Close seals what is left as the final chunk but does not close w.
+func (e *Encryption) writer(w io.Writer) (*sealWriter, error) {
+	header, err := e.header(encWhole)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &sealWriter{enc: e, w: w, header: header, chunk: encChunkSize}, nil
+}
+
+// appender returns a sealWriter to append to the encrypted segment at path,
+// once Reset to it, carrying on from its last chunk. A missing or empty
+// segment is given a header first, and a finished one loses its final
+// chunk, as the segment goes on after all.
+func (e *Encryption) appender(path string) (*sealWriter, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return e.newSegment(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, encHeaderSize)
+	if n, err := io.ReadFull(r, header); n == 0 {
+		return e.newSegment(path)
+	} else if err != nil || string(header[:len(encMagic)]) != encMagic || header[len(encMagic)] != encAppended {
+		return nil, fmt.Errorf("%s is not an encrypted AOF segment", filepath.Base(path))
+	}
+
+	// Count the complete chunks. A torn one after them is cut off when the
+	// AOF is loaded, and the next chunk takes its place.
+	s := &sealWriter{enc: e, header: header}
+	nonceSize := e.aead.NonceSize()
+	off, finalAt := int64(encHeaderSize), int64(-1)
+	for {
+		head, err := r.Peek(4 + nonceSize)
+		if err != nil {
+			break
+		}
+		n := int(binary.BigEndian.Uint32(head))
+		finalAt = -1
+		if n == e.aead.Overhead() {
+			// An empty chunk, as only the final chunk is.
+			chunk, err := r.Peek(4 + nonceSize + n)
+			if err != nil {
+				break
+			}
+			s.ad = chunkAAD(s.ad[:0], header, s.index, true)
+			if _, err := e.aead.Open(nil, chunk[4:4+nonceSize], chunk[4+nonceSize:], s.ad); err == nil {
+				finalAt = off
+			}
+		}
+		if d, _ := r.Discard(4 + nonceSize + n); d < 4+nonceSize+n {
+			break
+		}
+		off += int64(4 + nonceSize + n)
+		s.index++
+	}
+	if finalAt >= 0 {
+		if err := os.Truncate(path, finalAt); err != nil {
+			return nil, err
+		}
+		s.index--
+	}
+	return s, nil
+}
+
+// newSegment writes the header of a new appended segment to path.
+func (e *Encryption) newSegment(path string) (*sealWriter, error) {
+	header, err := e.header(encAppended)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, header, 0644); err != nil {
+		return nil, err
+	}
+	return &sealWriter{enc: e, header: header}, nil
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for s.chunk > 0 && len(s.buf) >= s.chunk {
+		if err := s.sealChunk(s.buf[:s.chunk], false); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[:copy(s.buf, s.buf[s.chunk:])]
+	}
+	return len(p), nil
+}
+
+// Flush seals everything buffered into one chunk and writes it out.
+func (s *sealWriter) Flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	if err := s.sealChunk(s.buf, false); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Close seals everything buffered into the final chunk, empty if nothing
+// is, and writes it out. Nothing may be written after it.
+func (s *sealWriter) Close() error {
+	if err := s.sealChunk(s.buf, true); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Reset discards anything buffered and appends to w from now on, carrying
+// on the same file.
+func (s *sealWriter) Reset(w io.Writer) {
+	s.w = w
+	s.buf = s.buf[:0]
+}
+
+// sealChunk writes p as the next chunk. The index only moves on once the
+// chunk is written, as a failed write is cut off the file again.
+func (s *sealWriter) sealChunk(p []byte, final bool) error {
+	var err error
+	s.ad = chunkAAD(s.ad[:0], s.header, s.index, final)
+	if s.out, err = s.enc.seal(s.out[:0], p, s.ad); err != nil {
+		return err
+	}
+	if _, err = s.w.Write(s.out); err != nil {
+		return err
+	}
+	s.index++
+	return nil
+}
+
+// openReader decrypts a file written by sealWriter. A chunk cut short by
+// the end of an appended segment ends the plaintext as if the segment ended
+// before it, with torn set; validTo is the file offset just past the last
+// complete chunk. A whole file that ends before its final chunk is corrupt.
+type openReader struct {
+	enc      *Encryption
+	r        *bufio.Reader
+	header   []byte
+	index    uint64
+	chunk    []byte
+	ad       []byte
+	out      []byte
+	pos      int
+	validTo  int64
+	torn     bool
+	finished bool  // the final chunk has been read
+	err      error // first failure, a *CorruptError for a bad chunk
+
+	// starts maps the plaintext offset of each chunk to its file offset.
+	starts map[int64]int64
+	plain  int64
+}
+
+func (e *Encryption) reader(r *bufio.Reader) (*openReader, error) {
+	o := &openReader{enc: e, r: r, header: make([]byte, encHeaderSize), starts: map[int64]int64{}}
+	if _, err := io.ReadFull(r, o.header); err != nil {
+		return nil, o.corrupt("truncated header")
+	}
+	if kind := o.header[len(encMagic)]; kind != encWhole && kind != encAppended {
+		return nil, o.corrupt("unknown file kind %q", kind)
+	}
+	o.validTo = int64(encHeaderSize)
+	return o, nil
+}
+
+// whole reports whether the file was written from start to end.
+func (o *openReader) whole() bool {
+	return o.header[len(encMagic)] == encWhole
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for o.pos == len(o.out) {
+		if o.err != nil {
+			return 0, o.err
+		}
+		if err := o.next(); err != nil {
+			o.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, o.out[o.pos:])
+	o.pos += n
+	return n, nil
+}
+
+// next opens the next chunk.
+func (o *openReader) next() error {
+	nonceSize := o.enc.aead.NonceSize()
+	head, err := o.r.Peek(4 + nonceSize)
+	if len(head) == 0 && err == io.EOF {
+		return o.end(false)
+	}
+	if o.finished {
+		return o.corrupt("data after the final chunk")
+	}
+	if err != nil {
+		return o.end(true)
+	}
+	n := int(binary.BigEndian.Uint32(head))
+	if n < o.enc.aead.Overhead() || n > encMaxChunk {
+		return o.corrupt("invalid chunk length %d", n)
+	}
+	o.chunk = append(o.chunk[:0], make([]byte, 4+nonceSize+n)...)
+	if _, err := io.ReadFull(o.r, o.chunk); err != nil {
+		return o.end(true)
+	}
+	nonce, sealed := o.chunk[4:4+nonceSize], o.chunk[4+nonceSize:]
+	o.ad = chunkAAD(o.ad[:0], o.header, o.index, false)
+	o.out, err = o.enc.aead.Open(o.out[:0], nonce, sealed, o.ad)
+	if err != nil {
+		o.ad = chunkAAD(o.ad[:0], o.header, o.index, true)
+		if o.out, err = o.enc.aead.Open(o.out[:0], nonce, sealed, o.ad); err != nil {
+			return o.corrupt("chunk %d fails authentication; wrong key, or a damaged or rearranged file", o.index)
+		}
+		o.finished = true
+	}
+	o.starts[o.plain] = o.validTo
+	o.plain += int64(len(o.out))
+	o.validTo += int64(len(o.chunk))
+	o.index++
+	o.pos = 0
+	return nil
+}
+
+// end ends the plaintext at the end of the file, which cut a chunk short
+// if torn.
+func (o *openReader) end(torn bool) error {
+	if o.whole() && !o.finished {
+		return o.corrupt("file ends before its final chunk; truncated")
+	}
+	o.torn = torn
+	return io.EOF
+}
+
+func (o *openReader) corrupt(format string, args ...any) error {
+	return &CorruptError{Offset: o.validTo, Reason: fmt.Sprintf(format, args...)}
+}
+
+// fileOffset maps a plaintext offset to the file offset of the chunk
+// starting there, if one does.
+func (o *openReader) fileOffset(plain int64) (int64, bool) {
+	off, ok := o.starts[plain]
+	return off, ok
+}
+
+// isEncrypted reports whether r starts with the encrypted file magic.
+func isEncrypted(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(encMagic))
+	return string(magic) == encMagic
+}
+
+// fileEncrypted reports whether the file at path is encrypted, and whether
+// it has no content at all.
+func fileEncrypted(path string) (encrypted, empty bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(encMagic))
+	n, _ := io.ReadFull(f, magic)
+	return string(magic[:n]) == encMagic, n == 0, nil
+}
+
+// wholeFileWriter returns a writer that compresses what is written to it
+// with c and then encrypts it with enc, for files written from start to
+// end. Close finishes both layers but does not close w.
+func wholeFileWriter(w io.Writer, c Compression, enc *Encryption) (io.WriteCloser, error) {
+	var sealed io.WriteCloser = nopWriteCloser{w}
+	if enc != nil {
+		sw, err := enc.writer(w)
+		if err != nil {
+			return nil, err
+		}
+		sealed = sw
+	}
+	return &layeredWriter{WriteCloser: c.writer(sealed), under: sealed}, nil
+}
+
+type layeredWriter struct {
+	io.WriteCloser
+	under io.Closer
+}
+
+func (l *layeredWriter) Close() error {
+	if err := l.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return l.under.Close()
+}
@@ -0,0 +1,311 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"redis-from-scratch/internal/store"
+)
+
+func testEncryption(t *testing.T, b byte) *Encryption {
+	t.Helper()
+	enc, err := NewEncryption(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func TestParseKey(t *testing.T) {
+	hexKey := strings.Repeat("ab", 32)
+	if key, err := ParseKey(hexKey); err != nil || len(key) != 32 {
+		t.Fatalf("expected a 32-byte hex key, got %d bytes (%v)", len(key), err)
+	}
+	if key, err := ParseKey("AAAAAAAAAAAAAAAAAAAAAA=="); err != nil || len(key) != 16 {
+		t.Fatalf("expected a 16-byte base64 key, got %d bytes (%v)", len(key), err)
+	}
+	if _, err := ParseKey("not a key!"); err == nil {
+		t.Fatalf("expected error for an undecodable key")
+	}
+	if _, err := NewEncryption(make([]byte, 10)); err == nil {
+		t.Fatalf("expected error for a 10-byte key")
+	}
+}
+
+func TestEncryptedAOF(t *testing.T) {
+	dir := t.TempDir()
+	enc := testEncryption(t, 1)
+	aof, _ := NewWithOptions(dir, Options{Encryption: enc})
+	aof.LogCommand("SET", []string{"secret", "value"})
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc})
+	aof.LogCommand("SET", []string{"b", "2"})
+	aof.Close()
+
+	files, _ := AOFFiles(dir)
+	for _, path := range files {
+		data, _ := os.ReadFile(path)
+		if !bytes.HasPrefix(data, []byte(encMagic)) || bytes.Contains(data, []byte("secret")) {
+			t.Fatalf("expected %s to be encrypted", path)
+		}
+	}
+
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc})
+	entries, err := aof.ReadCommands()
+	aof.Close()
+	if err != nil || len(entries) != 2 || entries[0].Args[0] != "secret" {
+		t.Fatalf("expected 2 commands, got %+v (%v)", entries, err)
+	}
+
+	aof, _ = NewWithOptions(dir, Options{})
+	if _, err := aof.ReadCommands(); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected ErrNoKey without a key, got %v", err)
+	}
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, Options{Encryption: testEncryption(t, 2)})
+	var corrupt *CorruptError
+	if _, err := aof.ReadCommands(); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a wrong key to be detected, got %v", err)
+	}
+	aof.Close()
+}
+
+func TestEncryptedAOFTruncatesTornChunk(t *testing.T) {
+	dir := t.TempDir()
+	enc := testEncryption(t, 1)
+	aof, _ := NewWithOptions(dir, Options{Encryption: enc})
+	aof.LogCommand("SET", []string{"a", "1"})
+	aof.Fsync()
+	aof.LogCommand("SET", []string{"b", "2"})
+	path := aof.path
+	aof.Close()
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-5], 0644)
+	check, err := CheckAOF(path, enc)
+	if err != nil || !errors.Is(check.Err, ErrTruncated) || check.Commands != 1 {
+		t.Fatalf("expected a torn chunk after 1 command, got %+v (%v)", check, err)
+	}
+
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc, LoadTruncated: true})
+	entries, err := aof.ReadCommands()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected 1 command after truncation, got %+v (%v)", entries, err)
+	}
+	aof.LogCommand("SET", []string{"c", "3"})
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc})
+	defer aof.Close()
+	if entries, err := aof.ReadCommands(); err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 commands after appending, got %+v (%v)", entries, err)
+	}
+}
+
+func TestEnablingEncryptionStartsNewSegment(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	aof.LogCommand("SET", []string{"a", "1"})
+	aof.Close()
+
+	enc := testEncryption(t, 1)
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc})
+	aof.LogCommand("SET", []string{"b", "2"})
+	aof.Close()
+
+	files, _ := AOFFiles(dir)
+	if len(files) != 2 {
+		t.Fatalf("expected a new segment for encrypted writes, got %v", files)
+	}
+	if encrypted, _, _ := fileEncrypted(files[0]); encrypted {
+		t.Fatalf("expected the old segment to stay plaintext")
+	}
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc})
+	defer aof.Close()
+	if entries, err := aof.ReadCommands(); err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 commands across both segments, got %+v (%v)", entries, err)
+	}
+}
+
+func TestEncryptedRewriteAndSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	enc := testEncryption(t, 1)
+	opts := Options{Encryption: enc, Compression: CompressionLZ4, SnapshotPreamble: true}
+	aof, _ := NewWithOptions(dir, opts)
+	s := store.New()
+	var writes sync.RWMutex
+	for i := 0; i < 200; i++ {
+		apply(s, aof, &writes, "SET", fmt.Sprint("key:", i), "value")
+	}
+	if _, err := aof.Rewrite(s, &writes); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, opts)
+	base, _, err := aof.Load()
+	aof.Close()
+	if err != nil || len(base) != 200 {
+		t.Fatalf("expected 200 keys in the encrypted base, got %d (%v)", len(base), err)
+	}
+
+	path := filepath.Join(dir, "dump.snapshot")
+	if _, err := WriteSnapshot(path, s, CompressionGzip, enc); err != nil {
+		t.Fatalf("WriteSnapshot failed: %v", err)
+	}
+	got := store.New()
+	if err := LoadSnapshot(path, got, enc); err != nil || got.Size() != 200 {
+		t.Fatalf("expected 200 keys, got %d (%v)", got.Size(), err)
+	}
+	if err := LoadSnapshot(path, store.New(), nil); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected ErrNoKey without a key, got %v", err)
+	}
+}
+
+// chunks splits the encrypted file data into its header and chunks.
+func chunks(t *testing.T, data []byte) (header []byte, chunks [][]byte) {
+	t.Helper()
+	header, data = data[:encHeaderSize], data[encHeaderSize:]
+	for len(data) > 0 {
+		n := 4 + 12 + int(binary.BigEndian.Uint32(data))
+		chunks, data = append(chunks, data[:n]), data[n:]
+	}
+	return header, chunks
+}
+
+func TestEncryptedSnapshotDetectsRearrangedChunks(t *testing.T) {
+	dir := t.TempDir()
+	enc := testEncryption(t, 1)
+	s := store.New()
+	for i := 0; i < 5000; i++ {
+		s.Set(fmt.Sprint("key:", i), strings.Repeat("v", 50), 0)
+	}
+	path := filepath.Join(dir, "dump.snapshot")
+	if _, err := WriteSnapshot(path, s, CompressionNone, enc); err != nil {
+		t.Fatalf("WriteSnapshot failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	header, parts := chunks(t, data)
+	if len(parts) < 4 {
+		t.Fatalf("expected several chunks, got %d", len(parts))
+	}
+	other := filepath.Join(dir, "other.snapshot")
+	WriteSnapshot(other, s, CompressionNone, enc)
+	otherData, _ := os.ReadFile(other)
+	_, otherParts := chunks(t, otherData)
+
+	cases := map[string][][]byte{
+		"reordered": {parts[1], parts[0]},
+		"repeated":  {parts[0], parts[0]},
+		"dropped":   {parts[0], parts[2]},
+		"spliced":   {parts[0], otherParts[1]},
+	}
+	for name, swapped := range cases {
+		damaged := slices.Concat([][]byte{header}, swapped, parts[2:])
+		os.WriteFile(path, bytes.Join(damaged, nil), 0644)
+		var corrupt *CorruptError
+		if err := LoadSnapshot(path, store.New(), enc); !errors.As(err, &corrupt) {
+			t.Fatalf("%s: expected a corrupt snapshot, got %v", name, err)
+		}
+	}
+
+	// Cut off at a chunk boundary, before the final chunk.
+	os.WriteFile(path, data[:len(data)-len(parts[len(parts)-1])], 0644)
+	var corrupt *CorruptError
+	if err := LoadSnapshot(path, store.New(), enc); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a truncated snapshot to be corrupt, got %v", err)
+	}
+}
+
+func TestEncryptedAOFDetectsRearrangedChunks(t *testing.T) {
+	dir := t.TempDir()
+	enc := testEncryption(t, 1)
+	aof, _ := NewWithOptions(dir, Options{Encryption: enc})
+	for i := 0; i < 3; i++ {
+		aof.LogCommand("SET", []string{fmt.Sprint("k", i), "v"})
+		aof.Fsync()
+	}
+	path := aof.path
+	aof.Close()
+
+	data, _ := os.ReadFile(path)
+	header, parts := chunks(t, data)
+	if len(parts) != 3 {
+		t.Fatalf("expected a chunk per flush, got %d", len(parts))
+	}
+	os.WriteFile(path, bytes.Join([][]byte{header, parts[1], parts[0], parts[2]}, nil), 0644)
+	check, err := CheckAOF(path, enc)
+	var corrupt *CorruptError
+	if err != nil || !errors.As(check.Err, &corrupt) {
+		t.Fatalf("expected reordered chunks to be corrupt, got %+v (%v)", check, err)
+	}
+}
+
+func TestEncryptedAOFDetectsTruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+	enc := testEncryption(t, 1)
+	aof, _ := NewWithOptions(dir, Options{Encryption: enc})
+	aof.LogCommand("SET", []string{"a", "1"})
+	aof.Fsync()
+	aof.LogCommand("SET", []string{"b", "2"})
+	aof.Fsync()
+	first := aof.path
+	aof.mu.Lock()
+	aof.rotate()
+	aof.mu.Unlock()
+	aof.LogCommand("SET", []string{"c", "3"})
+	aof.Close()
+
+	// The rotated segment was finished, so cutting off its last chunks,
+	// final included, is noticed though the rest authenticates.
+	data, _ := os.ReadFile(first)
+	header, parts := chunks(t, data)
+	if len(parts) != 3 {
+		t.Fatalf("expected 2 chunks and a final one, got %d", len(parts))
+	}
+	os.WriteFile(first, bytes.Join([][]byte{header, parts[0]}, nil), 0644)
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc, LoadTruncated: true})
+	defer aof.Close()
+	if _, err := aof.ReadCommands(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected a truncated segment to be reported, got %v", err)
+	}
+}
+
+func TestEncryptedAOFResumesFinishedSegment(t *testing.T) {
+	dir := t.TempDir()
+	enc := testEncryption(t, 1)
+	aof, _ := NewWithOptions(dir, Options{Encryption: enc})
+	aof.LogCommand("SET", []string{"a", "1"})
+	aof.Fsync()
+	path := aof.path
+	// As if the server stopped between finishing the segment and naming
+	// the next one in the manifest.
+	aof.mu.Lock()
+	aof.writer.Flush()
+	aof.writer.(*sealWriter).Close()
+	aof.mu.Unlock()
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc})
+	if aof.path != path {
+		t.Fatalf("expected to append to %s, got %s", path, aof.path)
+	}
+	aof.LogCommand("SET", []string{"b", "2"})
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, Options{Encryption: enc})
+	defer aof.Close()
+	if entries, err := aof.ReadCommands(); err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 commands, got %+v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,189 @@
+package persistence
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+const (
+	// queueSize bounds the commands waiting for the writer; LogCommand
+	// blocks once it is full.
+	queueSize = 4096
+	// maxBatch bounds how many commands the writer commits at once.
+	maxBatch = 1024
+)
+
+// aofWrite is a request to the group-commit writer. buf is nil for a
+// barrier, which only waits for the writes queued before it. done, if set,
+// receives the outcome once the batch holding the request is committed.
+type aofWrite struct {
+	buf  []byte
+	sync bool // fsync the batch even if the policy would not
+	done chan error
+}
+
+// writeLoop is the group-commit writer. It takes every command queued since
+// its last pass, writes them with a single flush and, when the policy or a
+// caller asks for it, a single fsync, then acknowledges all their waiters.
+// Under FsyncAlways concurrent clients thus share one fsync instead of
+// queueing for one each.
+func (a *AOF) writeLoop() {
+	defer close(a.written)
+	batch := make([]aofWrite, 0, maxBatch)
+	for {
+		stopping := false
+		select {
+		case req := <-a.queue:
+			batch = append(batch, req)
+		case <-a.stop:
+			stopping = true
+		}
+	gather:
+		for len(batch) < maxBatch || stopping {
+			select {
+			case req := <-a.queue:
+				batch = append(batch, req)
+			default:
+				break gather
+			}
+		}
+
+		if len(batch) > 0 {
+			err := a.commit(batch)
+			if err != nil {
+				logging.Errorf("Failed to write AOF: %v", err)
+			}
+			for _, req := range batch {
+				if req.done != nil {
+					req.done <- err
+				}
+			}
+			clear(batch)
+			batch = batch[:0]
+		}
+		if stopping {
+			return
+		}
+	}
+}
+
+// commit writes one batch to the file. If that fails, the batch is kept
+// in a.pending and written again before the next one.
+func (a *AOF) commit(batch []aofWrite) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sync := a.policy == FsyncAlways
+	now := time.Now().Unix()
+	buf := a.buf[:0]
+	for _, req := range batch {
+		sync = sync || req.sync
+		if req.buf == nil {
+			continue
+		}
+		if now != a.stamped {
+			buf = append(buf, encodeTimestamp(now)...)
+			a.stamped = now
+		}
+		buf = append(buf, req.buf...)
+	}
+	a.buf = buf[:0]
+
+	err := a.flushPending()
+	if err == nil {
+		err = a.write(buf)
+	}
+	if err != nil {
+		a.pending = append(a.pending, buf...)
+		a.writeErr = err
+		return fmt.Errorf("failed to write to AOF: %w", err)
+	}
+	a.size += int64(len(buf))
+	if sync {
+		if err := a.file.Sync(); err != nil {
+			a.writeErr = err
+			return fmt.Errorf("failed to sync AOF: %w", err)
+		}
+		a.lastSync = time.Now()
+	}
+	a.writeErr = nil
+	return nil
+}
+
+// flushPending writes the commands left behind by a failed write. Must be
+// called with a.mu held.
+func (a *AOF) flushPending() error {
+	if len(a.pending) == 0 {
+		return nil
+	}
+	if err := a.write(a.pending); err != nil {
+		a.writeErr = err
+		return err
+	}
+	a.size += int64(len(a.pending))
+	a.pending = nil
+	return nil
+}
+
+// write appends p to the open segment and flushes it, as one chunk when
+// the segment is encrypted. If that fails, whatever part of p reached the
+// file is cut off again, so that p can later be written again in full.
+// Must be called with a.mu held.
+func (a *AOF) write(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	start := a.out.n
+	_, err := a.writer.Write(p)
+	if err == nil {
+		err = a.writer.Flush()
+	}
+	if err == nil {
+		return nil
+	}
+	// A bufio.Writer keeps failing once a write has; start it afresh.
+	a.writer.Reset(a.out)
+	if a.out.n != start {
+		if terr := a.file.Truncate(start); terr != nil {
+			logging.Errorf("Failed to cut a partial write off the AOF: %v", terr)
+		}
+		a.out.n = start
+	}
+	return err
+}
+
+// countingWriter tracks the length of the segment being appended to.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Backlog returns the bytes of commands a failed write left behind, which
+// grow for as long as the disk refuses them, and the number of commands
+// queued for the writer, which fill up when it falls behind.
+func (a *AOF) Backlog() (pending int64, queued int) {
+	if !a.enabled {
+		return 0, 0
+	}
+	a.mu.Lock()
+	pending = int64(len(a.pending))
+	a.mu.Unlock()
+	return pending, len(a.queue)
+}
+
+// barrier waits until every command queued before it has been written and,
+// if sync is set, synced to disk.
+func (a *AOF) barrier(sync bool) error {
+	req := aofWrite{sync: sync, done: make(chan error, 1)}
+	a.queue <- req
+	return <-req.done
+}
@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestGroupCommitConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := NewWithOptions(dir, Options{Fsync: FsyncAlways})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 50; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := aof.LogCommand("SET", []string{fmt.Sprint(w, ":", i), "v"}); err != nil {
+					t.Errorf("LogCommand failed: %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	aof.Close()
+
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	entries, err := aof.ReadCommands()
+	if err != nil || len(entries) != 1000 {
+		t.Fatalf("expected 1000 commands, got %d (%v)", len(entries), err)
+	}
+}
+
+func TestCloseWritesQueuedCommands(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := NewWithOptions(dir, Options{Fsync: FsyncNo})
+	for i := 0; i < 500; i++ {
+		aof.LogCommand("SET", []string{"k", fmt.Sprint(i)})
+	}
+	aof.Close()
+
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	if entries, _ := aof.ReadCommands(); len(entries) != 500 {
+		t.Fatalf("expected every queued command written on close, got %d", len(entries))
+	}
+}
+
+// failingWriter writes half of what it is given, then fails, like a disk
+// filling up mid-write.
+type failingWriter struct{ w io.Writer }
+
+func (f failingWriter) Write(p []byte) (int, error) {
+	n, _ := f.w.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFailedWritesAreRetried(t *testing.T) {
+	for _, enc := range []*Encryption{nil, testEncryption(t, 1)} {
+		dir := t.TempDir()
+		opts := Options{Fsync: FsyncAlways, Encryption: enc}
+		aof, _ := NewWithOptions(dir, opts)
+		aof.LogCommand("SET", []string{"a", "1"})
+
+		aof.mu.Lock()
+		aof.out.w = failingWriter{aof.file}
+		aof.mu.Unlock()
+		if err := aof.LogCommand("SET", []string{"b", "2"}); err == nil {
+			t.Fatalf("expected the write to fail")
+		}
+		if aof.WriteErr() == nil {
+			t.Fatalf("expected WriteErr to report the failure")
+		}
+		if pending, _ := aof.Backlog(); pending == 0 {
+			t.Fatalf("expected the failed write to be pending")
+		}
+
+		aof.mu.Lock()
+		aof.out.w = aof.file
+		aof.mu.Unlock()
+		if err := aof.LogCommand("SET", []string{"c", "3"}); err != nil {
+			t.Fatalf("expected the write to succeed once the disk recovers: %v", err)
+		}
+		if err := aof.WriteErr(); err != nil {
+			t.Fatalf("expected WriteErr to clear, got %v", err)
+		}
+		if pending, _ := aof.Backlog(); pending != 0 {
+			t.Fatalf("expected nothing pending, got %d bytes", pending)
+		}
+		aof.Close()
+
+		aof, _ = NewWithOptions(dir, opts)
+		entries, err := aof.ReadCommands()
+		aof.Close()
+		if err != nil || len(entries) != 3 || entries[1].Args[0] != "b" {
+			t.Fatalf("expected all 3 commands in order, got %+v (%v)", entries, err)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// LZ4 frame format, implemented here like the RDB reader's LZF so that
+// compression needs no dependency. Frames are written with independent 64KB
+// blocks and no optional checksums: the dump format and AOF records carry
+// their own. The reader also accepts linked blocks, content sizes,
+// dictionary IDs and checksums (which it skips), and concatenated frames.
+const (
+	lz4Magic        = 0x184D2204
+	lz4BlockMax     = 64 << 10
+	lz4HashLog      = 14
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5  // a block always ends with at least this many literals
+	lz4MFLimit      = 12 // no match may start closer than this to the end
+	lz4Uncompressed = 1 << 31
+
+	lz4FlagVersion       = 0x40
+	lz4FlagIndependent   = 0x20
+	lz4FlagBlockChecksum = 0x10
+	lz4FlagContentSize   = 0x08
+	lz4FlagContentSum    = 0x04
+	lz4FlagDictID        = 0x01
+)
+
+var errCorruptLZ4 = errors.New("corrupt LZ4 data")
+
+// lz4Writer compresses everything written to it into one LZ4 frame. Close
+// writes the end mark; it does not close the underlying writer.
+type lz4Writer struct {
+	w       io.Writer
+	buf     []byte
+	out     []byte
+	table   [1 << lz4HashLog]int32
+	started bool
+	err     error
+}
+
+func newLZ4Writer(w io.Writer) *lz4Writer {
+	return &lz4Writer{w: w, buf: make([]byte, 0, lz4BlockMax)}
+}
+
+func (z *lz4Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && z.err == nil {
+		k := min(len(p), lz4BlockMax-len(z.buf))
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+		if len(z.buf) == lz4BlockMax {
+			z.flushBlock()
+		}
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	return n, nil
+}
+
+func (z *lz4Writer) Close() error {
+	if len(z.buf) > 0 || !z.started {
+		z.flushBlock()
+	}
+	if z.err == nil {
+		_, z.err = z.w.Write([]byte{0, 0, 0, 0})
+	}
+	return z.err
+}
+
+func (z *lz4Writer) flushBlock() {
+	if z.err != nil {
+		return
+	}
+	z.out = z.out[:0]
+	if !z.started {
+		flg, bd := byte(lz4FlagVersion|lz4FlagIndependent), byte(4<<4) // 64KB blocks
+		z.out = binary.LittleEndian.AppendUint32(z.out, lz4Magic)
+		z.out = append(z.out, flg, bd, byte(xxh32([]byte{flg, bd}, 0)>>8))
+		z.started = true
+	}
+	if len(z.buf) > 0 {
+		sizeAt := len(z.out)
+		z.out = append(z.out, 0, 0, 0, 0)
+		z.out = lz4CompressBlock(z.out, z.buf, &z.table)
+		size := uint32(len(z.out) - sizeAt - 4)
+		if int(size) >= len(z.buf) {
+			z.out = append(z.out[:sizeAt+4], z.buf...)
+			size = uint32(len(z.buf)) | lz4Uncompressed
+		}
+		binary.LittleEndian.PutUint32(z.out[sizeAt:], size)
+	}
+	_, z.err = z.w.Write(z.out)
+	z.buf = z.buf[:0]
+}
+
+// lz4CompressBlock appends the LZ4 block encoding of src to dst, finding
+// matches greedily through a hash table of 4-byte sequences.
+func lz4CompressBlock(dst, src []byte, table *[1 << lz4HashLog]int32) []byte {
+	clear(table[:])
+	anchor := 0
+	for i := 0; i < len(src)-lz4MFLimit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := seq * 2654435761 >> (32 - lz4HashLog)
+		ref := int(table[h]) - 1 // entries are stored +1 so zero means empty
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > 0xFFFF || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+		n := lz4MinMatch
+		for i+n < len(src)-lz4LastLiterals && src[ref+n] == src[i+n] {
+			n++
+		}
+		for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+			i, ref, n = i-1, ref-1, n+1
+		}
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+	}
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4AppendSequence appends literals followed by a match of n bytes at
+// offset back, or just the literals for the last sequence (n == 0).
+func lz4AppendSequence(dst, literals []byte, offset, n int) []byte {
+	token := byte(min(len(literals), 15)) << 4
+	if n > 0 {
+		token |= byte(min(n-lz4MinMatch, 15))
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if n == 0 {
+		return dst
+	}
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+	if n-lz4MinMatch >= 15 {
+		dst = lz4AppendLength(dst, n-lz4MinMatch-15)
+	}
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4DecompressBlock appends the decoded block src to dst. Matches may refer
+// back into what dst already holds, the previous block for linked blocks.
+func lz4DecompressBlock(dst, src []byte) ([]byte, error) {
+	length := func(i int, n int) (int, int, error) {
+		for {
+			if i >= len(src) {
+				return 0, 0, errCorruptLZ4
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+	var err error
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		lit := int(token >> 4)
+		if lit == 15 {
+			if i, lit, err = length(i, lit); err != nil {
+				return nil, err
+			}
+		}
+		if i+lit > len(src) {
+			return nil, errCorruptLZ4
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			break // the last sequence has no match
+		}
+
+		if i+2 > len(src) {
+			return nil, errCorruptLZ4
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		n := int(token & 15)
+		if n == 15 {
+			if i, n, err = length(i, n); err != nil {
+				return nil, err
+			}
+		}
+		n += lz4MinMatch
+		ref := len(dst) - offset
+		if offset == 0 || ref < 0 {
+			return nil, errCorruptLZ4
+		}
+		// Byte by byte when the match overlaps the bytes being written.
+		if offset >= n {
+			dst = append(dst, dst[ref:ref+n]...)
+			continue
+		}
+		for j := 0; j < n; j++ {
+			dst = append(dst, dst[ref+j])
+		}
+	}
+	return dst, nil
+}
+
+// lz4Reader decompresses a stream of LZ4 frames.
+type lz4Reader struct {
+	r       *bufio.Reader
+	flags   byte
+	inFrame bool
+	block   []byte
+	out     []byte // decoded, with up to 64KB of history for linked blocks
+	pos     int    // next byte of out to return
+}
+
+func newLZ4Reader(r *bufio.Reader) *lz4Reader {
+	return &lz4Reader{r: r}
+}
+
+func (z *lz4Reader) Read(p []byte) (int, error) {
+	for z.pos == len(z.out) {
+		if err := z.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, z.out[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+// next decodes the next block, reading frame headers and end marks on the
+// way. It returns io.EOF after the last frame.
+func (z *lz4Reader) next() error {
+	if !z.inFrame {
+		if err := z.header(); err != nil {
+			return err
+		}
+	}
+	var size uint32
+	if err := binary.Read(z.r, binary.LittleEndian, &size); err != nil {
+		return unexpected(err)
+	}
+	if size == 0 {
+		if z.flags&lz4FlagContentSum != 0 {
+			if _, err := z.r.Discard(4); err != nil {
+				return unexpected(err)
+			}
+		}
+		z.inFrame = false
+		return nil
+	}
+	n := int(size &^ lz4Uncompressed)
+	if n > 4<<20 {
+		return errCorruptLZ4
+	}
+	z.block = append(z.block[:0], make([]byte, n)...)
+	if _, err := io.ReadFull(z.r, z.block); err != nil {
+		return unexpected(err)
+	}
+	if z.flags&lz4FlagBlockChecksum != 0 {
+		if _, err := z.r.Discard(4); err != nil {
+			return unexpected(err)
+		}
+	}
+
+	// Keep the last 64KB as the dictionary of a linked block.
+	keep := 0
+	if z.flags&lz4FlagIndependent == 0 {
+		keep = min(len(z.out), 64<<10)
+	}
+	z.out = append(z.out[:0], z.out[len(z.out)-keep:]...)
+	z.pos = keep
+	if size&lz4Uncompressed != 0 {
+		z.out = append(z.out, z.block...)
+		return nil
+	}
+	var err error
+	z.out, err = lz4DecompressBlock(z.out, z.block)
+	return err
+}
+
+// header reads a frame header.
+func (z *lz4Reader) header() error {
+	var magic uint32
+	if err := binary.Read(z.r, binary.LittleEndian, &magic); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errCorruptLZ4
+		}
+		return err // io.EOF after the last frame
+	}
+	if magic != lz4Magic {
+		return errCorruptLZ4
+	}
+	desc := make([]byte, 2)
+	if _, err := io.ReadFull(z.r, desc); err != nil {
+		return unexpected(err)
+	}
+	z.flags = desc[0]
+	if z.flags&0xC0 != lz4FlagVersion {
+		return errCorruptLZ4
+	}
+	if z.flags&lz4FlagContentSize != 0 {
+		desc = binary.LittleEndian.AppendUint64(desc, 0)
+		if _, err := io.ReadFull(z.r, desc[2:]); err != nil {
+			return unexpected(err)
+		}
+	}
+	if z.flags&lz4FlagDictID != 0 {
+		return errors.New("LZ4 frames with a dictionary are not supported")
+	}
+	hc, err := z.r.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+	if hc != byte(xxh32(desc, 0)>>8) {
+		return errCorruptLZ4
+	}
+	z.inFrame = true
+	z.out = z.out[:0]
+	z.pos = 0
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// xxh32 is the 32-bit xxHash of p, used by LZ4 for its header checksum.
+func xxh32(p []byte, seed uint32) uint32 {
+	const (
+		prime1 uint32 = 2654435761
+		prime2 uint32 = 2246822519
+		prime3 uint32 = 3266489917
+		prime4 uint32 = 668265263
+		prime5 uint32 = 374761393
+	)
+	round := func(acc, in uint32) uint32 {
+		return bits.RotateLeft32(acc+in*prime2, 13) * prime1
+	}
+	n := len(p)
+	var h uint32
+	if n >= 16 {
+		v1, v2, v3, v4 := seed+prime1+prime2, seed+prime2, seed, seed-prime1
+		for ; len(p) >= 16; p = p[16:] {
+			v1 = round(v1, binary.LittleEndian.Uint32(p))
+			v2 = round(v2, binary.LittleEndian.Uint32(p[4:]))
+			v3 = round(v3, binary.LittleEndian.Uint32(p[8:]))
+			v4 = round(v4, binary.LittleEndian.Uint32(p[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + prime5
+	}
+	h += uint32(n)
+	for ; len(p) >= 4; p = p[4:] {
+		h = bits.RotateLeft32(h+binary.LittleEndian.Uint32(p)*prime3, 17) * prime4
+	}
+	for _, b := range p {
+		h = bits.RotateLeft32(h+uint32(b)*prime5, 11) * prime1
+	}
+	h ^= h >> 15
+	h *= prime2
+	h ^= h >> 13
+	h *= prime3
+	h ^= h >> 16
+	return h
+}
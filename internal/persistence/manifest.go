@@ -0,0 +1,194 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Multi-part AOF layout, as in Redis 7: the AOF lives in its own directory
+// as a base segment holding the dataset as of the last rewrite, incremental
+// segments holding the commands logged since, and a manifest listing them in
+// replay order. A rewrite writes a new base and starts a new incremental
+// segment instead of rewriting one large file in place.
+const (
+	aofDirName   = "appendonlydir"
+	aofName      = "commands.aof"
+	manifestName = aofName + ".manifest"
+	historyDir   = "history"
+)
+
+// SegmentType tells what a segment of a multi-part AOF holds.
+type SegmentType byte
+
+const (
+	// SegmentBase holds the dataset as of a rewrite, as commands or as a
+	// snapshot preamble.
+	SegmentBase SegmentType = 'b'
+	// SegmentIncr holds commands logged after the base was written.
+	SegmentIncr SegmentType = 'i'
+)
+
+// Segment is one file of a multi-part AOF.
+type Segment struct {
+	Name string
+	Seq  int
+	Type SegmentType
+}
+
+// Manifest lists the segments of a multi-part AOF: at most one base,
+// followed by the incremental segments in the order they are replayed.
+type Manifest struct {
+	Segments []Segment
+}
+
+// ManifestPath returns where the manifest of the AOF kept in dirPath, the
+// server's persistence_path, is stored.
+func ManifestPath(dirPath string) string {
+	return filepath.Join(dirPath, aofDirName, manifestName)
+}
+
+// ReadManifest parses the manifest at path. Each line describes a segment as
+// "file <name> seq <n> type <b|i>"; blank lines and lines starting with '#'
+// are ignored.
+func ReadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		seg, err := parseSegment(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %w", lineNum, err)
+		}
+		if seg.Type == SegmentBase && len(m.Segments) > 0 {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: base segment must come first", lineNum)
+		}
+		m.Segments = append(m.Segments, seg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read AOF manifest: %w", err)
+	}
+	return m, nil
+}
+
+func parseSegment(line string) (Segment, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return Segment{}, fmt.Errorf("odd number of fields")
+	}
+	var seg Segment
+	for i := 0; i < len(fields); i += 2 {
+		switch value := fields[i+1]; fields[i] {
+		case "file":
+			if value != filepath.Base(value) {
+				return Segment{}, fmt.Errorf("segment name %q is not a plain file name", value)
+			}
+			seg.Name = value
+		case "seq":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Segment{}, fmt.Errorf("invalid sequence number %q", value)
+			}
+			seg.Seq = n
+		case "type":
+			if value != string(SegmentBase) && value != string(SegmentIncr) {
+				return Segment{}, fmt.Errorf("unknown segment type %q", value)
+			}
+			seg.Type = SegmentType(value[0])
+		}
+	}
+	if seg.Name == "" || seg.Seq == 0 || seg.Type == 0 {
+		return Segment{}, fmt.Errorf("missing file, seq or type")
+	}
+	return seg, nil
+}
+
+// Base returns the base segment, if there is one.
+func (m *Manifest) Base() (Segment, bool) {
+	if len(m.Segments) > 0 && m.Segments[0].Type == SegmentBase {
+		return m.Segments[0], true
+	}
+	return Segment{}, false
+}
+
+// Incrs returns the incremental segments in replay order.
+func (m *Manifest) Incrs() []Segment {
+	if _, ok := m.Base(); ok {
+		return m.Segments[1:]
+	}
+	return m.Segments
+}
+
+// nextSeq returns the sequence number for a new segment of type t.
+func (m *Manifest) nextSeq(t SegmentType) int {
+	seq := 0
+	for _, seg := range m.Segments {
+		if seg.Type == t {
+			seq = max(seq, seg.Seq)
+		}
+	}
+	return seq + 1
+}
+
+// segmentName names segment seq of type t.
+func segmentName(t SegmentType, seq int) string {
+	kind := "incr"
+	if t == SegmentBase {
+		kind = "base"
+	}
+	return fmt.Sprintf("%s.%d.%s.aof", aofName, seq, kind)
+}
+
+// write replaces the manifest in dir. It is written to a temporary file and
+// renamed into place, so a crash leaves either the old or the new manifest.
+func (m *Manifest) write(dir string) error {
+	tmp, err := os.CreateTemp(dir, "temp-manifest-*")
+	if err != nil {
+		return fmt.Errorf("failed to write AOF manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	for _, seg := range m.Segments {
+		fmt.Fprintf(w, "file %s seq %d type %c\n", seg.Name, seg.Seq, seg.Type)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, manifestName))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write AOF manifest: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renames and new files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"redis-from-scratch/internal/store"
+)
+
+func TestReadManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), manifestName)
+	os.WriteFile(path, []byte("# comment\nfile commands.aof.2.base.aof seq 2 type b\n\nseq 3 type i file commands.aof.3.incr.aof\n"), 0644)
+	m, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+	if base, ok := m.Base(); !ok || base.Seq != 2 || len(m.Incrs()) != 1 || m.Incrs()[0].Name != "commands.aof.3.incr.aof" {
+		t.Fatalf("unexpected manifest: %+v", m.Segments)
+	}
+
+	for _, bad := range []string{
+		"file x seq 1 type q\n",
+		"file ../x seq 1 type i\n",
+		"file x seq 1\n",
+		"file x seq 1 type i\nfile y seq 1 type b\n",
+	} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := ReadManifest(path); err == nil {
+			t.Fatalf("expected error for manifest %q", bad)
+		}
+	}
+}
+
+func TestAOFSegmentsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	aof.LogCommand("SET", []string{"a", "1"})
+	aof.Close()
+
+	aof, _ = New(dir, true)
+	aof.LogCommand("SET", []string{"b", "2"})
+	aof.Close()
+
+	m, _ := ReadManifest(ManifestPath(dir))
+	if len(m.Segments) != 1 {
+		t.Fatalf("expected restarts to keep appending to one segment, got %+v", m.Segments)
+	}
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	if entries, err := aof.ReadCommands(); err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 commands, got %+v (%v)", entries, err)
+	}
+}
+
+func TestAOFTornRecordBeforeLastSegment(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	aof.LogCommand("SET", []string{"a", "1"})
+	aof.Fsync()
+	first := aof.path
+	aof.mu.Lock()
+	aof.rotate()
+	aof.mu.Unlock()
+	aof.LogCommand("SET", []string{"b", "2"})
+	aof.Close()
+
+	data, _ := os.ReadFile(first)
+	os.WriteFile(first, data[:len(data)-4], 0644)
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	if _, err := aof.ReadCommands(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected a torn record followed by more commands to fail, got %v", err)
+	}
+}
+
+func TestRewriteKeepsHistory(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := NewWithOptions(dir, Options{KeepHistory: true})
+	defer aof.Close()
+	s := store.New()
+	var writes sync.RWMutex
+	apply(s, aof, &writes, "SET", "k", "v")
+
+	for i := 0; i < 2; i++ {
+		if _, err := aof.Rewrite(s, &writes); err != nil {
+			t.Fatalf("Rewrite failed: %v", err)
+		}
+	}
+	history, _ := os.ReadDir(filepath.Join(dir, aofDirName, historyDir))
+	var names []string
+	for _, e := range history {
+		names = append(names, e.Name())
+	}
+	// incr 1, then base 1 and incr 2
+	if len(names) != 3 {
+		t.Fatalf("expected 3 retired segments in history, got %v", names)
+	}
+	if files, err := AOFFiles(dir); err != nil || len(files) != 2 {
+		t.Fatalf("expected base and one incr segment, got %v (%v)", files, err)
+	}
+}
@@ -0,0 +1,27 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+
+	"redis-from-scratch/internal/store"
+)
+
+// readPreamble decodes the dataset a rewrite may have written at the start
+// of the AOF in the snapshot dump format. It returns nil entries if there is
+// none.
+func readPreamble(r *bufio.Reader) ([]store.Entry, error) {
+	if magic, _ := r.Peek(len(store.DumpMagic)); string(magic) != store.DumpMagic {
+		return nil, nil
+	}
+	// The decoder reuses r rather than buffering on its own, so it consumes
+	// exactly the preamble and leaves the commands in r.
+	base, err := readDump(r)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt AOF preamble: %w", err)
+	}
+	if base == nil {
+		base = []store.Entry{}
+	}
+	return base, nil
+}
@@ -0,0 +1,866 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/store"
+)
+
+// Reader for Redis RDB files (versions 1 through 12), so an existing Redis
+// dataset can be imported. Only this server's own dump format is ever
+// written. Strings, lists, sets, sorted sets and hashes are supported in all
+// of their plain and compact encodings; streams, modules and functions are
+// not. Only database 0 is imported.
+
+const rdbMagic = "REDIS"
+
+// RDB opcodes.
+const (
+	rdbOpSlotInfo     = 0xF4
+	rdbOpFunction2    = 0xF5
+	rdbOpFunctionPre  = 0xF6
+	rdbOpModuleAux    = 0xF7
+	rdbOpIdle         = 0xF8
+	rdbOpFreq         = 0xF9
+	rdbOpAux          = 0xFA
+	rdbOpResizeDB     = 0xFB
+	rdbOpExpireTimeMs = 0xFC
+	rdbOpExpireTime   = 0xFD
+	rdbOpSelectDB     = 0xFE
+	rdbOpEOF          = 0xFF
+)
+
+// RDB value types.
+const (
+	rdbTypeString          = 0
+	rdbTypeList            = 1
+	rdbTypeSet             = 2
+	rdbTypeZSet            = 3
+	rdbTypeHash            = 4
+	rdbTypeZSet2           = 5
+	rdbTypeHashZipmap      = 9
+	rdbTypeListZiplist     = 10
+	rdbTypeSetIntset       = 11
+	rdbTypeZSetZiplist     = 12
+	rdbTypeHashZiplist     = 13
+	rdbTypeListQuicklist   = 14
+	rdbTypeHashListpack    = 16
+	rdbTypeZSetListpack    = 17
+	rdbTypeListQuicklist2  = 18
+	rdbTypeSetListpack     = 20
+	rdbMaxSupportedVersion = 12
+)
+
+// Special string encodings selected by the top bits of a length byte.
+const (
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+// quicklist2 node containers.
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+type rdbReader struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+}
+
+// ReadRDB decodes a Redis RDB stream into entries. When the file carries a
+// non-zero CRC64 trailer it is verified.
+func ReadRDB(r io.Reader) ([]store.Entry, error) {
+	rd := &rdbReader{r: bufio.NewReader(r)}
+	header := make([]byte, 9)
+	if err := rd.full(header); err != nil {
+		return nil, err
+	}
+	if string(header[:5]) != rdbMagic {
+		return nil, fmt.Errorf("not an RDB file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > rdbMaxSupportedVersion {
+		return nil, fmt.Errorf("unsupported RDB version %q", header[5:])
+	}
+	rd.version = version
+
+	var (
+		entries []store.Entry
+		db      uint64
+		skipped int
+		expiry  *time.Time
+	)
+	for {
+		op, err := rd.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case rdbOpEOF:
+			if err := rd.checksum(); err != nil {
+				return nil, err
+			}
+			if skipped > 0 {
+				logging.Warnf("skipped %d keys outside database 0 in RDB import", skipped)
+			}
+			return entries, nil
+		case rdbOpSelectDB:
+			if db, _, err = rd.length(); err != nil {
+				return nil, err
+			}
+			continue
+		case rdbOpResizeDB:
+			if _, _, err := rd.length(); err != nil {
+				return nil, err
+			}
+			if _, _, err := rd.length(); err != nil {
+				return nil, err
+			}
+			continue
+		case rdbOpAux:
+			if _, err := rd.string(); err != nil {
+				return nil, err
+			}
+			if _, err := rd.string(); err != nil {
+				return nil, err
+			}
+			continue
+		case rdbOpSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, _, err := rd.length(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case rdbOpIdle:
+			if _, _, err := rd.length(); err != nil {
+				return nil, err
+			}
+			continue
+		case rdbOpFreq:
+			if _, err := rd.byte(); err != nil {
+				return nil, err
+			}
+			continue
+		case rdbOpExpireTime:
+			var b [4]byte
+			if err := rd.full(b[:]); err != nil {
+				return nil, err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(b[:])), 0)
+			expiry = &t
+			continue
+		case rdbOpExpireTimeMs:
+			var b [8]byte
+			if err := rd.full(b[:]); err != nil {
+				return nil, err
+			}
+			t := time.UnixMilli(int64(binary.LittleEndian.Uint64(b[:])))
+			expiry = &t
+			continue
+		case rdbOpModuleAux, rdbOpFunction2, rdbOpFunctionPre:
+			return nil, fmt.Errorf("RDB contains modules or functions, which are not supported")
+		}
+
+		key, err := rd.string()
+		if err != nil {
+			return nil, err
+		}
+		e, err := rd.value(op)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key, err)
+		}
+		e.Key = key
+		e.Expiry = expiry
+		expiry = nil
+		if db != 0 {
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+}
+
+// full reads exactly len(p) bytes and adds them to the running checksum.
+func (rd *rdbReader) full(p []byte) error {
+	if _, err := io.ReadFull(rd.r, p); err != nil {
+		return fmt.Errorf("truncated RDB file: %w", err)
+	}
+	rd.crc = crc64Jones(rd.crc, p)
+	return nil
+}
+
+func (rd *rdbReader) byte() (byte, error) {
+	var b [1]byte
+	err := rd.full(b[:])
+	return b[0], err
+}
+
+// checksum verifies the CRC64 trailer present since RDB version 5. A zero
+// checksum means the writer had checksums disabled.
+func (rd *rdbReader) checksum() error {
+	if rd.version < 5 {
+		return nil
+	}
+	want := rd.crc
+	var b [8]byte
+	if _, err := io.ReadFull(rd.r, b[:]); err != nil {
+		return fmt.Errorf("truncated RDB checksum: %w", err)
+	}
+	got := binary.LittleEndian.Uint64(b[:])
+	if got != 0 && got != want {
+		return fmt.Errorf("RDB checksum mismatch")
+	}
+	return nil
+}
+
+// length reads a length-encoded integer. When encoded is true the value is
+// one of the rdbEnc* special string encodings instead of a length.
+func (rd *rdbReader) length() (n uint64, encoded bool, err error) {
+	b, err := rd.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := rd.byte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			var buf [4]byte
+			err := rd.full(buf[:])
+			return uint64(binary.BigEndian.Uint32(buf[:])), false, err
+		case 0x81:
+			var buf [8]byte
+			err := rd.full(buf[:])
+			return binary.BigEndian.Uint64(buf[:]), false, err
+		}
+		return 0, false, fmt.Errorf("invalid length encoding 0x%02x", b)
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+// count reads a collection length, rejecting absurd values.
+func (rd *rdbReader) count() (int, error) {
+	n, encoded, err := rd.length()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || n > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid collection length")
+	}
+	return int(n), nil
+}
+
+// string reads a string in any of its encodings.
+func (rd *rdbReader) string() (string, error) {
+	n, encoded, err := rd.length()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if n > math.MaxInt32 {
+			return "", fmt.Errorf("invalid string length %d", n)
+		}
+		buf := make([]byte, n)
+		if err := rd.full(buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+	switch n {
+	case rdbEncInt8:
+		b, err := rd.byte()
+		return strconv.Itoa(int(int8(b))), err
+	case rdbEncInt16:
+		var b [2]byte
+		err := rd.full(b[:])
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b[:])))), err
+	case rdbEncInt32:
+		var b [4]byte
+		err := rd.full(b[:])
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b[:])))), err
+	case rdbEncLZF:
+		clen, _, err := rd.length()
+		if err != nil {
+			return "", err
+		}
+		ulen, _, err := rd.length()
+		if err != nil {
+			return "", err
+		}
+		if clen > math.MaxInt32 || ulen > math.MaxInt32 {
+			return "", fmt.Errorf("invalid LZF lengths")
+		}
+		in := make([]byte, clen)
+		if err := rd.full(in); err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(in, int(ulen))
+		return string(out), err
+	}
+	return "", fmt.Errorf("unknown string encoding %d", n)
+}
+
+// value reads a value of RDB type t.
+func (rd *rdbReader) value(t byte) (store.Entry, error) {
+	switch t {
+	case rdbTypeString:
+		s, err := rd.string()
+		return store.Entry{Type: store.TypeString, Str: s}, err
+
+	case rdbTypeList, rdbTypeSet:
+		n, err := rd.count()
+		if err != nil {
+			return store.Entry{}, err
+		}
+		items := make([]string, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			s, err := rd.string()
+			if err != nil {
+				return store.Entry{}, err
+			}
+			items = append(items, s)
+		}
+		if t == rdbTypeList {
+			return listEntry(items), nil
+		}
+		return setEntry(items), nil
+
+	case rdbTypeZSet, rdbTypeZSet2:
+		n, err := rd.count()
+		if err != nil {
+			return store.Entry{}, err
+		}
+		var members []store.ZMember
+		for i := 0; i < n; i++ {
+			m, err := rd.string()
+			if err != nil {
+				return store.Entry{}, err
+			}
+			var score float64
+			if t == rdbTypeZSet2 {
+				var b [8]byte
+				if err := rd.full(b[:]); err != nil {
+					return store.Entry{}, err
+				}
+				score = math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+			} else if score, err = rd.legacyScore(); err != nil {
+				return store.Entry{}, err
+			}
+			members = append(members, store.ZMember{Member: m, Score: score})
+		}
+		return store.Entry{Type: store.TypeZSet, ZSet: members}, nil
+
+	case rdbTypeHash:
+		n, err := rd.count()
+		if err != nil {
+			return store.Entry{}, err
+		}
+		items := make([]string, 0, min(2*n, 1024))
+		for i := 0; i < 2*n; i++ {
+			s, err := rd.string()
+			if err != nil {
+				return store.Entry{}, err
+			}
+			items = append(items, s)
+		}
+		return hashEntry(items)
+
+	case rdbTypeHashZipmap:
+		blob, err := rd.string()
+		if err != nil {
+			return store.Entry{}, err
+		}
+		items, err := parseZipmap([]byte(blob))
+		if err != nil {
+			return store.Entry{}, err
+		}
+		return hashEntry(items)
+
+	case rdbTypeSetIntset:
+		blob, err := rd.string()
+		if err != nil {
+			return store.Entry{}, err
+		}
+		items, err := parseIntset([]byte(blob))
+		if err != nil {
+			return store.Entry{}, err
+		}
+		return setEntry(items), nil
+
+	case rdbTypeListZiplist, rdbTypeZSetZiplist, rdbTypeHashZiplist,
+		rdbTypeHashListpack, rdbTypeZSetListpack, rdbTypeSetListpack:
+		blob, err := rd.string()
+		if err != nil {
+			return store.Entry{}, err
+		}
+		var items []string
+		switch t {
+		case rdbTypeListZiplist, rdbTypeZSetZiplist, rdbTypeHashZiplist:
+			items, err = parseZiplist([]byte(blob))
+		default:
+			items, err = parseListpack([]byte(blob))
+		}
+		if err != nil {
+			return store.Entry{}, err
+		}
+		switch t {
+		case rdbTypeListZiplist:
+			return listEntry(items), nil
+		case rdbTypeSetListpack:
+			return setEntry(items), nil
+		case rdbTypeHashZiplist, rdbTypeHashListpack:
+			return hashEntry(items)
+		}
+		return zsetEntry(items)
+
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		n, err := rd.count()
+		if err != nil {
+			return store.Entry{}, err
+		}
+		var items []string
+		for i := 0; i < n; i++ {
+			container := uint64(quicklistNodePacked)
+			if t == rdbTypeListQuicklist2 {
+				if container, _, err = rd.length(); err != nil {
+					return store.Entry{}, err
+				}
+			}
+			blob, err := rd.string()
+			if err != nil {
+				return store.Entry{}, err
+			}
+			if container == quicklistNodePlain {
+				items = append(items, blob)
+				continue
+			}
+			var node []string
+			if t == rdbTypeListQuicklist {
+				node, err = parseZiplist([]byte(blob))
+			} else {
+				node, err = parseListpack([]byte(blob))
+			}
+			if err != nil {
+				return store.Entry{}, err
+			}
+			items = append(items, node...)
+		}
+		return listEntry(items), nil
+	}
+	return store.Entry{}, fmt.Errorf("unsupported RDB value type %d", t)
+}
+
+// legacyScore reads a sorted set score stored as a length-prefixed string.
+func (rd *rdbReader) legacyScore() (float64, error) {
+	n, err := rd.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, n)
+	if err := rd.full(buf); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func listEntry(items []string) store.Entry {
+	return store.Entry{Type: store.TypeList, List: items}
+}
+
+func setEntry(items []string) store.Entry {
+	set := make(map[string]struct{}, len(items))
+	for _, m := range items {
+		set[m] = struct{}{}
+	}
+	return store.Entry{Type: store.TypeSet, Set: set}
+}
+
+// hashEntry builds a hash from alternating fields and values.
+func hashEntry(items []string) (store.Entry, error) {
+	if len(items)%2 != 0 {
+		return store.Entry{}, fmt.Errorf("odd number of hash elements")
+	}
+	h := make(map[string]string, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		h[items[i]] = items[i+1]
+	}
+	return store.Entry{Type: store.TypeHash, Hash: h}, nil
+}
+
+// zsetEntry builds a sorted set from alternating members and scores.
+func zsetEntry(items []string) (store.Entry, error) {
+	if len(items)%2 != 0 {
+		return store.Entry{}, fmt.Errorf("odd number of sorted set elements")
+	}
+	members := make([]store.ZMember, 0, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		score, err := strconv.ParseFloat(items[i+1], 64)
+		if err != nil {
+			return store.Entry{}, fmt.Errorf("invalid sorted set score %q", items[i+1])
+		}
+		members = append(members, store.ZMember{Member: items[i], Score: score})
+	}
+	return store.Entry{Type: store.TypeZSet, ZSet: members}, nil
+}
+
+var errCorruptBlob = fmt.Errorf("corrupt encoded value")
+
+// parseZiplist decodes a ziplist: zlbytes(4) zltail(4) zllen(2), entries,
+// then 0xFF. Each entry is prevlen, encoding and data.
+func parseZiplist(b []byte) ([]string, error) {
+	if len(b) < 11 {
+		return nil, errCorruptBlob
+	}
+	var out []string
+	p := 10
+	for {
+		if p >= len(b) {
+			return nil, errCorruptBlob
+		}
+		if b[p] == 0xFF {
+			return out, nil
+		}
+		// prevlen
+		if b[p] == 0xFE {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(b) {
+			return nil, errCorruptBlob
+		}
+		enc := b[p]
+		var (
+			s    string
+			size int
+		)
+		switch {
+		case enc>>6 == 0:
+			size, p = int(enc&0x3F), p+1
+		case enc>>6 == 1:
+			if p+2 > len(b) {
+				return nil, errCorruptBlob
+			}
+			size, p = int(enc&0x3F)<<8|int(b[p+1]), p+2
+		case enc == 0x80:
+			if p+5 > len(b) {
+				return nil, errCorruptBlob
+			}
+			size, p = int(binary.BigEndian.Uint32(b[p+1:])), p+5
+		default:
+			v, n, err := ziplistInt(b[p:])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, strconv.FormatInt(v, 10))
+			p += n
+			continue
+		}
+		if size < 0 || p+size > len(b) {
+			return nil, errCorruptBlob
+		}
+		s, p = string(b[p:p+size]), p+size
+		out = append(out, s)
+	}
+}
+
+// ziplistInt decodes an integer ziplist entry starting at its encoding byte
+// and returns the value and the number of bytes consumed.
+func ziplistInt(b []byte) (int64, int, error) {
+	enc := b[0]
+	if enc >= 0xF1 && enc <= 0xFD {
+		return int64(enc&0x0F) - 1, 1, nil
+	}
+	var n int
+	switch enc {
+	case 0xC0:
+		n = 2
+	case 0xD0:
+		n = 4
+	case 0xE0:
+		n = 8
+	case 0xF0:
+		n = 3
+	case 0xFE:
+		n = 1
+	}
+	if n == 0 || len(b) < 1+n {
+		return 0, 0, errCorruptBlob
+	}
+	d := b[1 : 1+n]
+	switch enc {
+	case 0xC0:
+		return int64(int16(binary.LittleEndian.Uint16(d))), 3, nil
+	case 0xD0:
+		return int64(int32(binary.LittleEndian.Uint32(d))), 5, nil
+	case 0xE0:
+		return int64(binary.LittleEndian.Uint64(d)), 9, nil
+	case 0xF0:
+		v := int32(uint32(d[0])<<8|uint32(d[1])<<16|uint32(d[2])<<24) >> 8
+		return int64(v), 4, nil
+	default: // 0xFE
+		return int64(int8(d[0])), 2, nil
+	}
+}
+
+// parseListpack decodes a listpack: total bytes(4) and element count(2),
+// entries, then 0xFF. Each entry is encoding, data and a backlen.
+func parseListpack(b []byte) ([]string, error) {
+	if len(b) < 7 {
+		return nil, errCorruptBlob
+	}
+	var out []string
+	p := 6
+	for {
+		if p >= len(b) {
+			return nil, errCorruptBlob
+		}
+		enc := b[p]
+		if enc == 0xFF {
+			return out, nil
+		}
+		start := p
+		var (
+			size  int // string length, or -1 for an integer
+			ival  int64
+			isInt = true
+		)
+		switch {
+		case enc&0x80 == 0:
+			ival, p = int64(enc&0x7F), p+1
+		case enc&0xC0 == 0x80:
+			size, p, isInt = int(enc&0x3F), p+1, false
+		case enc&0xE0 == 0xC0:
+			if p+2 > len(b) {
+				return nil, errCorruptBlob
+			}
+			v := int64(enc&0x1F)<<8 | int64(b[p+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			ival, p = v, p+2
+		case enc&0xF0 == 0xE0:
+			if p+2 > len(b) {
+				return nil, errCorruptBlob
+			}
+			size, p, isInt = int(enc&0x0F)<<8|int(b[p+1]), p+2, false
+		case enc == 0xF0:
+			if p+5 > len(b) {
+				return nil, errCorruptBlob
+			}
+			size, p, isInt = int(binary.LittleEndian.Uint32(b[p+1:])), p+5, false
+		case enc >= 0xF1 && enc <= 0xF4:
+			n := int(enc-0xF1) + 2 // int16, int24, int32
+			if enc == 0xF4 {
+				n = 8
+			}
+			if p+1+n > len(b) {
+				return nil, errCorruptBlob
+			}
+			var u uint64
+			for i := n - 1; i >= 0; i-- {
+				u = u<<8 | uint64(b[p+1+i])
+			}
+			// Sign-extend from n bytes.
+			shift := uint(64 - 8*n)
+			ival, p = int64(u<<shift)>>shift, p+1+n
+		default:
+			return nil, errCorruptBlob
+		}
+		if isInt {
+			out = append(out, strconv.FormatInt(ival, 10))
+		} else {
+			if size < 0 || p+size > len(b) {
+				return nil, errCorruptBlob
+			}
+			out = append(out, string(b[p:p+size]))
+			p += size
+		}
+		p += listpackBacklenSize(p - start)
+	}
+}
+
+// listpackBacklenSize returns how many bytes encode an entry length of n.
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseIntset decodes an intset: encoding(4) length(4) then the integers,
+// all little-endian.
+func parseIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errCorruptBlob
+	}
+	width := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (width != 2 && width != 4 && width != 8) || n < 0 || len(b) < 8+n*width {
+		return nil, errCorruptBlob
+	}
+	out := make([]string, n)
+	for i := 0; i < n; i++ {
+		d := b[8+i*width:]
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(d)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(d)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(d))
+		}
+		out[i] = strconv.FormatInt(v, 10)
+	}
+	return out, nil
+}
+
+// parseZipmap decodes the pre-2.6 zipmap hash encoding.
+func parseZipmap(b []byte) ([]string, error) {
+	if len(b) < 2 {
+		return nil, errCorruptBlob
+	}
+	var out []string
+	p := 1
+	readLen := func() (int, bool) {
+		if p >= len(b) {
+			return 0, false
+		}
+		switch n := b[p]; {
+		case n < 254:
+			p++
+			return int(n), true
+		case n == 254 && p+5 <= len(b):
+			v := int(binary.LittleEndian.Uint32(b[p+1:]))
+			p += 5
+			return v, true
+		}
+		return 0, false
+	}
+	for {
+		if p >= len(b) {
+			return nil, errCorruptBlob
+		}
+		if b[p] == 0xFF {
+			return out, nil
+		}
+		klen, ok := readLen()
+		if !ok || p+klen > len(b) {
+			return nil, errCorruptBlob
+		}
+		key := string(b[p : p+klen])
+		p += klen
+		vlen, ok := readLen()
+		if !ok || p+1+vlen > len(b) {
+			return nil, errCorruptBlob
+		}
+		free := int(b[p])
+		p++
+		out = append(out, key, string(b[p:p+vlen]))
+		p += vlen + free
+	}
+}
+
+// lzfDecompress expands LZF-compressed data into exactly n bytes.
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			run := ctrl + 1
+			if i+run > len(in) {
+				return nil, errCorruptBlob
+			}
+			out = append(out, in[i:i+run]...)
+			i += run
+			continue
+		}
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errCorruptBlob
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorruptBlob
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorruptBlob
+		}
+		// Byte by byte: the reference may overlap the bytes being written.
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, errCorruptBlob
+	}
+	return out, nil
+}
+
+// crc64JonesTable is the reflected table for the Jones polynomial used by
+// Redis (0xad93d23594c935a9), with no initial or final inversion.
+var crc64JonesTable = func() [256]uint64 {
+	const poly = 0x95AC9329AC4BC9B5 // reflected
+	var t [256]uint64
+	for i := range t {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+func crc64Jones(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64JonesTable[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
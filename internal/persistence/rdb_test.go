@@ -0,0 +1,226 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/store"
+)
+
+// rdbBuilder assembles RDB files for tests using the same encodings real
+// Redis writes.
+type rdbBuilder struct{ bytes.Buffer }
+
+func (b *rdbBuilder) str(s string) {
+	b.WriteByte(byte(len(s))) // 6-bit length; tests keep strings short
+	b.WriteString(s)
+}
+
+func (b *rdbBuilder) blob(p []byte) {
+	b.WriteByte(0x40 | byte(len(p)>>8)) // 14-bit length
+	b.WriteByte(byte(len(p)))
+	b.Write(p)
+}
+
+func (b *rdbBuilder) finish() []byte {
+	b.WriteByte(rdbOpEOF)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], crc64Jones(0, b.Bytes()))
+	b.Write(sum[:])
+	return b.Bytes()
+}
+
+// listpack encodes short strings and 7-bit integers.
+func listpack(items ...interface{}) []byte {
+	var body []byte
+	for _, it := range items {
+		var entry []byte
+		switch v := it.(type) {
+		case string:
+			entry = append([]byte{0x80 | byte(len(v))}, v...)
+		case int:
+			entry = []byte{byte(v)}
+		}
+		body = append(body, entry...)
+		body = append(body, byte(len(entry)))
+	}
+	out := make([]byte, 6, 7+len(body))
+	binary.LittleEndian.PutUint32(out, uint32(7+len(body)))
+	binary.LittleEndian.PutUint16(out[4:], uint16(len(items)))
+	out = append(out, body...)
+	return append(out, 0xFF)
+}
+
+// ziplist encodes short strings and int16 values.
+func ziplist(items ...interface{}) []byte {
+	out := make([]byte, 10)
+	binary.LittleEndian.PutUint16(out[8:], uint16(len(items)))
+	prev := 0
+	for _, it := range items {
+		entry := []byte{byte(prev)}
+		switch v := it.(type) {
+		case string:
+			entry = append(entry, byte(len(v)))
+			entry = append(entry, v...)
+		case int:
+			entry = append(entry, 0xC0, byte(v), byte(v>>8))
+		}
+		out = append(out, entry...)
+		prev = len(entry)
+	}
+	out = append(out, 0xFF)
+	binary.LittleEndian.PutUint32(out, uint32(len(out)))
+	return out
+}
+
+func sampleRDB() []byte {
+	var b rdbBuilder
+	b.WriteString("REDIS0011")
+	b.WriteByte(rdbOpAux)
+	b.str("redis-ver")
+	b.str("7.2.0")
+	b.WriteByte(rdbOpSelectDB)
+	b.WriteByte(0)
+	b.WriteByte(rdbOpResizeDB)
+	b.WriteByte(8)
+	b.WriteByte(1)
+
+	b.WriteByte(rdbTypeString)
+	b.str("s")
+	b.str("hello")
+
+	b.WriteByte(rdbTypeString)
+	b.str("n")
+	b.Write([]byte{0xC0, 0xD6}) // int8 -42
+
+	b.WriteByte(rdbTypeString)
+	b.str("lzf")
+	b.Write([]byte{0xC3, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00}) // "a" x 10
+
+	b.WriteByte(rdbOpExpireTimeMs)
+	var ms [8]byte
+	binary.LittleEndian.PutUint64(ms[:], uint64(time.Now().Add(time.Hour).UnixMilli()))
+	b.Write(ms[:])
+	b.WriteByte(rdbTypeString)
+	b.str("ttl")
+	b.str("v")
+
+	b.WriteByte(rdbTypeListQuicklist2)
+	b.str("l")
+	b.WriteByte(1)
+	b.WriteByte(quicklistNodePacked)
+	b.blob(listpack("a", 5, "bb"))
+
+	b.WriteByte(rdbTypeHashListpack)
+	b.str("h")
+	b.blob(listpack("f", "v"))
+
+	intset := make([]byte, 12)
+	binary.LittleEndian.PutUint32(intset, 2)
+	binary.LittleEndian.PutUint32(intset[4:], 2)
+	binary.LittleEndian.PutUint16(intset[8:], uint16(0xFFFE)) // -2
+	binary.LittleEndian.PutUint16(intset[10:], 1)
+	b.WriteByte(rdbTypeSetIntset)
+	b.str("si")
+	b.blob(intset)
+
+	b.WriteByte(rdbTypeZSetListpack)
+	b.str("z")
+	b.blob(listpack("m", "1.5", "n", 2))
+
+	b.WriteByte(rdbTypeListZiplist)
+	b.str("zl")
+	b.blob(ziplist("x", 300))
+
+	b.WriteByte(rdbOpSelectDB)
+	b.WriteByte(1)
+	b.WriteByte(rdbTypeString)
+	b.str("other-db")
+	b.str("v")
+	return b.finish()
+}
+
+func TestCRC64Jones(t *testing.T) {
+	if got := crc64Jones(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("unexpected crc64: %x", got)
+	}
+}
+
+func TestReadRDB(t *testing.T) {
+	entries, err := ReadRDB(bytes.NewReader(sampleRDB()))
+	if err != nil {
+		t.Fatalf("ReadRDB failed: %v", err)
+	}
+	byKey := make(map[string]store.Entry)
+	for _, e := range entries {
+		byKey[e.Key] = e
+	}
+	if len(byKey) != 9 {
+		t.Fatalf("expected 9 keys from db 0, got %d", len(byKey))
+	}
+	if byKey["s"].Str != "hello" || byKey["n"].Str != "-42" {
+		t.Fatalf("unexpected strings: %+v %+v", byKey["s"], byKey["n"])
+	}
+	if byKey["lzf"].Str != strings.Repeat("a", 10) {
+		t.Fatalf("unexpected LZF string: %q", byKey["lzf"].Str)
+	}
+	if byKey["ttl"].Expiry == nil || byKey["s"].Expiry != nil {
+		t.Fatalf("expected expiry only on ttl key")
+	}
+	if got := strings.Join(byKey["l"].List, ","); got != "a,5,bb" {
+		t.Fatalf("unexpected quicklist: %s", got)
+	}
+	if byKey["h"].Hash["f"] != "v" {
+		t.Fatalf("unexpected hash: %v", byKey["h"].Hash)
+	}
+	var members []string
+	for m := range byKey["si"].Set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	if strings.Join(members, ",") != "-2,1" {
+		t.Fatalf("unexpected intset: %v", members)
+	}
+	z := byKey["z"].ZSet
+	if len(z) != 2 || z[0].Member != "m" || z[0].Score != 1.5 || z[1].Score != 2 {
+		t.Fatalf("unexpected zset: %v", z)
+	}
+	if got := strings.Join(byKey["zl"].List, ","); got != "x,300" {
+		t.Fatalf("unexpected ziplist: %s", got)
+	}
+}
+
+func TestReadRDBRejectsCorruption(t *testing.T) {
+	data := sampleRDB()
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-12] ^= 0xFF
+	if _, err := ReadRDB(bytes.NewReader(corrupt)); err == nil {
+		t.Fatalf("expected checksum error")
+	}
+	if _, err := ReadRDB(bytes.NewReader(data[:len(data)/2])); err == nil {
+		t.Fatalf("expected truncation error")
+	}
+}
+
+func TestLoadSnapshotDetectsRDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(path, sampleRDB(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := store.New()
+	if err := LoadSnapshot(path, s, nil); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if v, _ := s.Get("s"); v != "hello" {
+		t.Fatalf("expected imported key, got %q", v)
+	}
+	if s.Size() != 9 {
+		t.Fatalf("expected 9 keys, got %d", s.Size())
+	}
+}
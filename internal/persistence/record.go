@@ -0,0 +1,247 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AOF record format: a command framed as a RESP array of bulk strings,
+// followed by a checksum line '#' + the CRC-32 (IEEE) of the array in eight
+// lowercase hex digits + CRLF. Records written before checksums were added
+// have no checksum line and are accepted as they are.
+//
+// Between records, a "#TS:<unix seconds>" line stamps the records after it
+// with the time they were logged, as Redis' aof-timestamp-enabled does.
+// Other '#' lines between records are ignored.
+const (
+	checksumPrefix = '#'
+	checksumLen    = 1 + 8 + 2
+	timestampTag   = "#TS:"
+
+	// Sanity bounds, so a corrupt header cannot force a huge allocation.
+	maxRecordArgs = 1024 * 1024
+	maxBulkLen    = 512 * 1024 * 1024
+)
+
+// ErrTruncated is returned when the AOF ends partway through a record, as
+// happens when the server crashes mid-write.
+var ErrTruncated = errors.New("AOF ends with an incomplete command")
+
+// CorruptError reports a record that is malformed or fails its checksum.
+type CorruptError struct {
+	Offset int64 // where the bad record starts
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupt AOF record at offset %d: %s", e.Offset, e.Reason)
+}
+
+// encodeTimestamp returns the annotation stamping the records after it
+// with t.
+func encodeTimestamp(t int64) []byte {
+	return fmt.Appendf(nil, "%s%d\r\n", timestampTag, t)
+}
+
+// encodeCommand frames a command as an AOF record.
+func encodeCommand(cmd string, args []string) []byte {
+	buf := make([]byte, 0, 16+checksumLen+len(cmd)+16*len(args))
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)+1), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range append([]string{cmd}, args...) {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return fmt.Appendf(buf, "%c%08x\r\n", checksumPrefix, crc32.ChecksumIEEE(buf))
+}
+
+// recordReader reads AOF records and tracks the offset of the end of the
+// last complete one.
+type recordReader struct {
+	r      *bufio.Reader
+	offset int64 // end of the last complete record
+	n      int64 // bytes consumed in the current record
+	crc    uint32
+	ts     int64 // from the last timestamp annotation, in nanoseconds
+}
+
+func newRecordReader(r *bufio.Reader) *recordReader {
+	return &recordReader{r: r}
+}
+
+// next returns the next record. It returns io.EOF at a clean end of input,
+// ErrTruncated if the input ends inside a record and a *CorruptError for
+// anything else that is not a valid record.
+func (rr *recordReader) next() (AOFEntry, error) {
+	rr.n, rr.crc = 0, 0
+	for {
+		b, err := rr.r.Peek(1)
+		if err == io.EOF {
+			return AOFEntry{}, io.EOF
+		}
+		if err != nil || b[0] != checksumPrefix {
+			break
+		}
+		if err := rr.annotation(); err != nil {
+			return AOFEntry{}, err
+		}
+	}
+
+	count, err := rr.header('*')
+	if err != nil {
+		return AOFEntry{}, err
+	}
+	if count < 1 || count > maxRecordArgs {
+		return AOFEntry{}, rr.corrupt("invalid argument count %d", count)
+	}
+	args := make([]string, count)
+	for i := range args {
+		size, err := rr.header('$')
+		if err != nil {
+			return AOFEntry{}, err
+		}
+		if size < 0 || size > maxBulkLen {
+			return AOFEntry{}, rr.corrupt("invalid argument length %d", size)
+		}
+		p := make([]byte, size+2)
+		if err := rr.read(p); err != nil {
+			return AOFEntry{}, err
+		}
+		if p[size] != '\r' || p[size+1] != '\n' {
+			return AOFEntry{}, rr.corrupt("argument %d is not terminated by CRLF", i)
+		}
+		args[i] = string(p[:size])
+	}
+
+	// The checksum line is optional for records written before it existed,
+	// so such a record may be followed directly by an annotation.
+	if b, _ := rr.r.Peek(2); len(b) > 0 && b[0] == checksumPrefix && (len(b) == 1 || b[1] != timestampTag[1]) {
+		want := rr.crc
+		line := make([]byte, checksumLen)
+		if _, err := io.ReadFull(rr.r, line); err != nil {
+			return AOFEntry{}, ErrTruncated
+		}
+		rr.n += checksumLen
+		sum, err := strconv.ParseUint(string(line[1:9]), 16, 32)
+		if err != nil || line[9] != '\r' || line[10] != '\n' {
+			return AOFEntry{}, rr.corrupt("malformed checksum")
+		}
+		if uint32(sum) != want {
+			return AOFEntry{}, rr.corrupt("checksum mismatch")
+		}
+	}
+
+	rr.offset += rr.n
+	return AOFEntry{Timestamp: rr.ts, Command: strings.ToUpper(args[0]), Args: args[1:]}, nil
+}
+
+// annotation reads a '#' line between records.
+func (rr *recordReader) annotation() error {
+	line, err := rr.r.ReadSlice('\n')
+	if err == io.EOF {
+		return ErrTruncated
+	}
+	if err != nil {
+		return rr.corrupt("%v", err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return rr.corrupt("annotation is not terminated by CRLF")
+	}
+	if ts, ok := strings.CutPrefix(string(line[:len(line)-2]), timestampTag); ok {
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return rr.corrupt("invalid timestamp %q", ts)
+		}
+		rr.ts = sec * int64(time.Second)
+	}
+	rr.offset += int64(len(line))
+	return nil
+}
+
+// read fills p, adding it to the record checksum.
+func (rr *recordReader) read(p []byte) error {
+	if _, err := io.ReadFull(rr.r, p); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	rr.n += int64(len(p))
+	rr.crc = crc32.Update(rr.crc, crc32.IEEETable, p)
+	return nil
+}
+
+// header reads a "<prefix><int>\r\n" line.
+func (rr *recordReader) header(prefix byte) (int, error) {
+	line, err := rr.r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF {
+			return 0, ErrTruncated
+		}
+		return 0, rr.corrupt("%v", err)
+	}
+	rr.n += int64(len(line))
+	rr.crc = crc32.Update(rr.crc, crc32.IEEETable, line)
+	if len(line) < 4 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, rr.corrupt("expected '%c' header", prefix)
+	}
+	n, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil {
+		return 0, rr.corrupt("invalid length %q", line[1:len(line)-2])
+	}
+	return n, nil
+}
+
+func (rr *recordReader) corrupt(format string, args ...any) error {
+	return &CorruptError{Offset: rr.offset, Reason: fmt.Sprintf(format, args...)}
+}
+
+// segmentReader reads an AOF file through its decryption and decompression
+// layers, reporting offsets in the plaintext.
+type segmentReader struct {
+	*bufio.Reader
+	compressed bool
+	sealed     *openReader // nil unless the file is encrypted
+	count      *countingReader
+}
+
+func openSegment(f io.Reader, enc *Encryption) (*segmentReader, error) {
+	sr := &segmentReader{}
+	var src io.Reader
+	if r := bufio.NewReader(f); isEncrypted(r) {
+		if enc == nil {
+			return nil, ErrNoKey
+		}
+		o, err := enc.reader(r)
+		if err != nil {
+			return nil, err
+		}
+		sr.sealed = o
+		src = o
+	} else {
+		src = r
+	}
+	src, compressed, err := decompress(bufio.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	sr.compressed = compressed
+	sr.count = &countingReader{r: src}
+	sr.Reader = bufio.NewReader(sr.count)
+	return sr, nil
+}
+
+// offset returns how far the plaintext has been consumed.
+func (sr *segmentReader) offset() int64 {
+	return sr.count.n - int64(sr.Buffered())
+}
@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// setAside ends the AOF at offset cut of segment i, where the first command
+// logged after Options.ReplayUntil starts. Nothing is deleted: the rest of
+// segment i is copied to a file of its own in the history directory, and
+// the later segments are moved there, so a recovery to the wrong time can
+// be undone by hand. Must be called with a.mu held.
+func (a *AOF) setAside(i int, cut int64) error {
+	segs := a.manifest.Segments
+	seg := segs[i]
+	path := filepath.Join(a.dir, seg.Name)
+	until := time.Unix(0, a.until)
+
+	// The chunks of an encrypted segment are bound to its header and their
+	// place in it, so its tail cannot be read on its own; the whole segment
+	// is copied instead, to be put back in place of the cut one.
+	from := cut
+	if encrypted, _, _ := fileEncrypted(path); encrypted {
+		from = 0
+	}
+	tailName := fmt.Sprintf("%s.after-%d", seg.Name, until.Unix())
+	moved, err := copyTail(path, filepath.Join(a.dir, historyDir, tailName), from)
+	if err != nil {
+		return fmt.Errorf("failed to set aside AOF after %s: %w", until.Format(time.RFC3339), err)
+	}
+
+	m := &Manifest{Segments: slices.Clone(segs[:i+1])}
+	if err := m.write(a.dir); err != nil {
+		return err
+	}
+	a.manifest = m
+	a.archive(segs[i+1:])
+	if err := os.Truncate(path, cut); err != nil {
+		return fmt.Errorf("failed to truncate AOF: %w", err)
+	}
+
+	// Commands logged from now on go to the end of segment i, or to a new
+	// incremental segment if the cut was in the base.
+	if seg.Type == SegmentBase {
+		err = a.rotate()
+	} else if a.path != path {
+		err = a.open(seg)
+	}
+	if err != nil {
+		return err
+	}
+	logging.Infof("Recovered AOF to %s: moved %d bytes of %s and %d later segments to %s",
+		until.Format(time.RFC3339), moved, seg.Name, len(segs)-i-1, filepath.Join(a.dir, historyDir))
+	return nil
+}
+
+// copyTail copies everything from offset on in the file at src to dst, and
+// returns how many bytes that was.
+func copyTail(src, dst string, offset int64) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// errRewrittenAfter reports that the base segment, written by a rewrite at
+// ts, holds no state from before Options.ReplayUntil.
+func (a *AOF) errRewrittenAfter(ts int64) error {
+	return fmt.Errorf("cannot replay until %s: the AOF was rewritten at %s, after it; restore an older base from the history directory",
+		time.Unix(0, a.until).Format(time.RFC3339), time.Unix(0, ts).Format(time.RFC3339))
+}
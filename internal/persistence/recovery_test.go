@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/store"
+)
+
+// writeStamped writes a segment holding one SET per timestamp.
+func writeStamped(t *testing.T, path string, key string, stamps ...int64) {
+	t.Helper()
+	var data []byte
+	for i, ts := range stamps {
+		data = append(data, encodeTimestamp(ts)...)
+		data = append(data, encodeCommand("SET", []string{key, string(rune('a' + i))})...)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayUntil(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	aof.mu.Lock()
+	aof.rotate()
+	aof.mu.Unlock()
+	aof.Close()
+	m, _ := ReadManifest(ManifestPath(dir))
+	writeStamped(t, filepath.Join(dir, aofDirName, m.Segments[0].Name), "k", 100, 200, 300)
+	writeStamped(t, filepath.Join(dir, aofDirName, m.Segments[1].Name), "k", 400)
+
+	aof, _ = NewWithOptions(dir, Options{ReplayUntil: time.Unix(250, 0)})
+	entries, err := aof.ReadCommands()
+	if err != nil || len(entries) != 2 || entries[1].Args[1] != "b" {
+		t.Fatalf("expected the 2 commands before the cutoff, got %+v (%v)", entries, err)
+	}
+	aof.LogCommand("SET", []string{"k", "new"})
+	aof.Close()
+
+	aof, _ = New(dir, true)
+	defer aof.Close()
+	entries, err = aof.ReadCommands()
+	if err != nil || len(entries) != 3 || entries[2].Args[1] != "new" {
+		t.Fatalf("expected new commands to follow the recovered state, got %+v (%v)", entries, err)
+	}
+	history, _ := os.ReadDir(filepath.Join(dir, aofDirName, historyDir))
+	if len(history) != 2 || !strings.HasSuffix(history[0].Name(), ".after-250") {
+		t.Fatalf("expected the cut tail and the later segment in history, got %v", history)
+	}
+}
+
+func TestReplayUntilBeforeRewrite(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	s := store.New()
+	var writes sync.RWMutex
+	apply(s, aof, &writes, "SET", "k", "v")
+	aof.Rewrite(s, &writes)
+	aof.Close()
+
+	aof, _ = NewWithOptions(dir, Options{ReplayUntil: time.Now().Add(-time.Hour)})
+	defer aof.Close()
+	if _, err := aof.ReadCommands(); err == nil || !strings.Contains(err.Error(), "rewritten") {
+		t.Fatalf("expected a cutoff before the rewrite to fail, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/store"
+)
+
+// rewriteItemsPerCmd caps how many elements a rewritten collection command
+// carries, as Redis does with AOF_REWRITE_ITEMS_PER_CMD.
+const rewriteItemsPerCmd = 64
+
+// ErrRewriteInProgress is returned when a rewrite is requested while one is
+// already running.
+var ErrRewriteInProgress = fmt.Errorf("ERR Background append only file rewriting already in progress")
+
+// Rewrite replaces the AOF with the shortest command stream that rebuilds
+// the current dataset of kv, so it no longer grows with every update to the
+// same key. With Options.SnapshotPreamble the dataset is written in the
+// snapshot dump format instead. The new base segment is compressed and
+// encrypted as Options.Compression and Options.Encryption select. It returns the number of keys written.
+//
+// writes must be held by anyone applying a command to kv and logging it, so
+// that locking it separates commands already in the dataset from commands
+// still to come. Rewrite holds it while it starts a new incremental segment
+// for the commands to come, and until kv's iteration has started. The
+// dataset goes to a new base segment; once it is complete, the manifest is
+// switched to the new base and the segments logged since, and the segments
+// they replace are retired. Until then the old manifest stays valid, so a
+// failed rewrite loses nothing.
+func (a *AOF) Rewrite(kv store.KV, writes sync.Locker) (int, error) {
+	if !a.enabled {
+		return 0, fmt.Errorf("ERR AOF is not enabled")
+	}
+
+	writes.Lock()
+	release := sync.OnceFunc(writes.Unlock)
+	defer release()
+
+	// Commands applied before writes was locked may still be queued; they
+	// must reach the old segment, or the rewrite would both include them in
+	// the dataset and replay them from the new one.
+	if err := a.barrier(false); err != nil {
+		return 0, err
+	}
+	a.mu.Lock()
+	if a.rewriting {
+		a.mu.Unlock()
+		return 0, ErrRewriteInProgress
+	}
+	if err := a.rotate(); err != nil {
+		a.mu.Unlock()
+		return 0, err
+	}
+	incrs := a.manifest.Incrs()
+	first := incrs[len(incrs)-1].Seq
+	started := time.Now().Unix()
+	a.rewriting = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.rewriting = false
+		a.mu.Unlock()
+	}()
+
+	tmp, err := os.CreateTemp(a.dir, "temp-rewrite-*.aof")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create AOF rewrite file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	cw, err := wholeFileWriter(tmp, a.compression, a.enc)
+	if err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write AOF rewrite: %w", err)
+	}
+	w := bufio.NewWriter(cw)
+	write := func(e store.Entry) error { return rewriteEntry(w, e) }
+	// The base is stamped with the time of the rewrite, for ReplayUntil: at
+	// the start of the commands, or after the preamble.
+	var enc *store.Encoder
+	if !a.preamble {
+		_, err = w.Write(encodeTimestamp(started))
+	} else {
+		if enc, err = store.NewEncoder(w); err != nil {
+			tmp.Close()
+			return 0, fmt.Errorf("failed to write AOF rewrite: %w", err)
+		}
+		write = enc.Encode
+	}
+	n := 0
+	kv.ForEach(func(e store.Entry) bool {
+		// The iteration's view is fixed now, so writers may proceed.
+		release()
+		if err = write(e); err != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	release()
+	if err == nil && enc != nil {
+		err = enc.Close()
+	}
+	if err == nil && enc != nil {
+		_, err = w.Write(encodeTimestamp(started))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = cw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write AOF rewrite: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seq := a.manifest.nextSeq(SegmentBase)
+	base := Segment{Name: segmentName(SegmentBase, seq), Seq: seq, Type: SegmentBase}
+	if err := os.Rename(tmp.Name(), filepath.Join(a.dir, base.Name)); err != nil {
+		return 0, fmt.Errorf("failed to install AOF rewrite: %w", err)
+	}
+	m := &Manifest{Segments: []Segment{base}}
+	var old []Segment
+	for _, seg := range a.manifest.Segments {
+		if seg.Type == SegmentIncr && seg.Seq >= first {
+			m.Segments = append(m.Segments, seg)
+		} else {
+			old = append(old, seg)
+		}
+	}
+	if err := m.write(a.dir); err != nil {
+		os.Remove(filepath.Join(a.dir, base.Name))
+		return 0, fmt.Errorf("failed to install AOF rewrite: %w", err)
+	}
+	a.manifest = m
+	a.retire(old)
+
+	if err := a.writer.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to flush AOF: %w", err)
+	}
+	if a.size, err = a.liveSize(); err != nil {
+		return 0, err
+	}
+	a.baseSize = a.size
+	logging.Infof("AOF rewritten into %s; retired %d old segments", base.Name, len(old))
+	return n, nil
+}
+
+// rewriteEntry writes the commands that recreate e, with its expiry as an
+// absolute deadline.
+func rewriteEntry(w *bufio.Writer, e store.Entry) error {
+	var err error
+	switch e.Type {
+	case store.TypeString:
+		args := []string{e.Key, e.Str}
+		if e.Expiry != nil {
+			args = append(args, "PXAT", strconv.FormatInt(e.Expiry.UnixMilli(), 10))
+		}
+		_, err = w.Write(encodeCommand("SET", args))
+		return err
+	case store.TypeHash:
+		args := make([]string, 0, 2*len(e.Hash))
+		for f, v := range e.Hash {
+			args = append(args, f, v)
+		}
+		err = writeBatched(w, "HSET", e.Key, args, 2)
+	case store.TypeList:
+		err = writeBatched(w, "RPUSH", e.Key, e.List, 1)
+	case store.TypeSet:
+		args := make([]string, 0, len(e.Set))
+		for m := range e.Set {
+			args = append(args, m)
+		}
+		err = writeBatched(w, "SADD", e.Key, args, 1)
+	case store.TypeZSet:
+		args := make([]string, 0, 2*len(e.ZSet))
+		for _, z := range e.ZSet {
+			args = append(args, strconv.FormatFloat(z.Score, 'g', -1, 64), z.Member)
+		}
+		err = writeBatched(w, "ZADD", e.Key, args, 2)
+	default:
+		return fmt.Errorf("cannot rewrite value type %d", e.Type)
+	}
+	if err == nil && e.Expiry != nil {
+		_, err = w.Write(encodeCommand("PEXPIREAT", []string{e.Key, strconv.FormatInt(e.Expiry.UnixMilli(), 10)}))
+	}
+	return err
+}
+
+// writeBatched writes cmd key followed by args, split into commands of at
+// most rewriteItemsPerCmd elements of width arguments each.
+func writeBatched(w *bufio.Writer, cmd, key string, args []string, width int) error {
+	step := rewriteItemsPerCmd * width
+	for i := 0; i < len(args); i += step {
+		batch := append([]string{key}, args[i:min(i+step, len(args))]...)
+		if _, err := w.Write(encodeCommand(cmd, batch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
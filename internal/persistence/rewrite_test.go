@@ -0,0 +1,176 @@
+package persistence
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/store"
+)
+
+// apply runs a command against s and logs it, as the server does.
+func apply(s *store.Store, aof *AOF, writes *sync.RWMutex, args ...string) {
+	writes.RLock()
+	defer writes.RUnlock()
+	command.Execute(s, args[0], args[1:])
+	aof.LogCommand(args[0], args[1:])
+}
+
+func replay(t *testing.T, dir string) *store.Store {
+	aof, err := New(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aof.Close()
+	entries, err := aof.ReadCommands()
+	if err != nil {
+		t.Fatalf("ReadCommands failed: %v", err)
+	}
+	s := store.New()
+	for _, e := range entries {
+		command.Execute(s, e.Command, e.Args)
+	}
+	return s
+}
+
+func TestRewriteCompactsAOF(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	s := store.New()
+	var writes sync.RWMutex
+	for i := 0; i < 500; i++ {
+		apply(s, aof, &writes, "SET", "counter", fmt.Sprint(i))
+	}
+	for i := 0; i < 200; i++ {
+		apply(s, aof, &writes, "RPUSH", "l", fmt.Sprint(i))
+		apply(s, aof, &writes, "HSET", "h", fmt.Sprint("f", i), "v")
+		apply(s, aof, &writes, "ZADD", "z", fmt.Sprint(float64(i)/3), fmt.Sprint("m", i))
+	}
+	apply(s, aof, &writes, "SADD", "s", "a", "b")
+	apply(s, aof, &writes, "PEXPIREAT", "s", fmt.Sprint(time.Now().Add(time.Hour).UnixMilli()))
+	aof.Fsync()
+	before, _ := aof.Size()
+
+	n, err := aof.Rewrite(s, &writes)
+	if err != nil || n != 5 {
+		t.Fatalf("expected 5 keys rewritten, got %d (%v)", n, err)
+	}
+	after, _ := aof.Size()
+	aof.Close()
+	if after >= before/2 {
+		t.Fatalf("expected rewrite to shrink the AOF, %d -> %d bytes", before, after)
+	}
+	m, _ := ReadManifest(ManifestPath(dir))
+	if base, ok := m.Base(); !ok || base.Seq != 1 || len(m.Incrs()) != 1 || m.Incrs()[0].Seq != 2 {
+		t.Fatalf("expected a new base and the segment started by the rewrite, got %+v", m.Segments)
+	}
+	if _, err := os.Stat(filepath.Join(dir, aofDirName, segmentName(SegmentIncr, 1))); !os.IsNotExist(err) {
+		t.Fatalf("expected the replaced segment deleted, got %v", err)
+	}
+
+	got := replay(t, dir)
+	if v, _ := got.Get("counter"); v != "499" {
+		t.Fatalf("expected counter 499, got %q", v)
+	}
+	if l, _ := got.ListRange("l", 0, -1); len(l) != 200 || l[0] != "0" || l[199] != "199" {
+		t.Fatalf("unexpected list after replay: %d items", len(l))
+	}
+	if h, _ := got.HashGetAll("h"); len(h) != 200 {
+		t.Fatalf("expected 200 hash fields, got %d", len(h))
+	}
+	if sc, ok, _ := got.ZScore("z", "m100"); !ok || sc != float64(100)/3 {
+		t.Fatalf("expected exact zset score, got %v", sc)
+	}
+	if _, ttl, _ := got.TypeOf("s"); ttl <= 59*time.Minute {
+		t.Fatalf("expected set TTL kept across rewrite, got %v", ttl)
+	}
+}
+
+func TestRewriteKeepsConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := New(dir, true)
+	s := store.New()
+	var writes sync.RWMutex
+	for i := 0; i < 2000; i++ {
+		apply(s, aof, &writes, "SET", fmt.Sprint("k", i), "old")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			apply(s, aof, &writes, "SET", fmt.Sprint("k", i), "new")
+			apply(s, aof, &writes, "RPUSH", "log", fmt.Sprint(i))
+		}
+	}()
+	if _, err := aof.Rewrite(s, &writes); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	wg.Wait()
+	aof.Close()
+
+	got := replay(t, dir)
+	for i := 0; i < 2000; i++ {
+		if v, _ := got.Get(fmt.Sprint("k", i)); v != "new" {
+			t.Fatalf("k%d: expected new, got %q", i, v)
+		}
+	}
+	if l, _ := got.ListRange("log", 0, -1); len(l) != 2000 {
+		t.Fatalf("expected each concurrent RPUSH applied once, got %d items", len(l))
+	}
+}
+
+func TestRewriteWithSnapshotPreamble(t *testing.T) {
+	dir := t.TempDir()
+	aof, _ := NewWithOptions(dir, Options{SnapshotPreamble: true, LoadTruncated: true})
+	s := store.New()
+	var writes sync.RWMutex
+	for i := 0; i < 100; i++ {
+		apply(s, aof, &writes, "SET", "counter", fmt.Sprint(i))
+		apply(s, aof, &writes, "RPUSH", "l", fmt.Sprint(i))
+	}
+	if _, err := aof.Rewrite(s, &writes); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	apply(s, aof, &writes, "SET", "after", "v")
+	aof.Close()
+
+	if check, err := CheckAOF(filepath.Join(dir, aofDirName, segmentName(SegmentBase, 1)), nil); err != nil || !check.OK() || check.Commands != 0 {
+		t.Fatalf("expected a valid base holding only the preamble, got %+v (%v)", check, err)
+	}
+	path := aof.path
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append(data, "*2\r\n$3\r\nDEL"...), 0644) // torn write
+	if check, err := CheckAOF(path, nil); err != nil || check.Commands != 1 || check.Err != ErrTruncated {
+		t.Fatalf("expected 1 command and a torn tail, got %+v (%v)", check, err)
+	}
+
+	aof, _ = NewWithOptions(dir, Options{SnapshotPreamble: true, LoadTruncated: true})
+	defer aof.Close()
+	base, entries, err := aof.Load()
+	if err != nil || len(base) != 2 || len(entries) != 1 {
+		t.Fatalf("expected 2 preamble keys and 1 command, got %d and %d (%v)", len(base), len(entries), err)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasSuffix(string(data), "\r\n") {
+		t.Fatalf("expected the torn tail to be truncated")
+	}
+
+	got := store.New()
+	got.Restore(base)
+	command.Execute(got, entries[0].Command, entries[0].Args)
+	if v, _ := got.Get("counter"); v != "99" {
+		t.Fatalf("expected counter 99, got %q", v)
+	}
+	if v, _ := got.Get("after"); v != "v" {
+		t.Fatalf("expected command after the preamble applied, got %q", v)
+	}
+	if _, err := aof.ReadCommands(); err == nil {
+		t.Fatalf("expected ReadCommands to refuse a file with a preamble")
+	}
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"net"
+	"time"
+)
+
+// Client is a minimal RESP client for tools that talk to a server: it sends
+// one command at a time and waits for its reply. It is not safe for
+// concurrent use.
+type Client struct {
+	conn net.Conn
+	w    *Writer
+	p    *Parser
+}
+
+// Dial connects to the server at addr.
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client talking over conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, w: NewWriter(conn), p: NewParser(conn)}
+}
+
+// Do sends a command and returns its reply. An error reply is returned as
+// the error, along with the reply.
+func (c *Client) Do(args ...string) (Reply, error) {
+	if err := c.w.WriteArray(args); err != nil {
+		return Reply{}, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return Reply{}, err
+	}
+	r, err := c.p.ReadReply()
+	if err != nil {
+		return Reply{}, err
+	}
+	return r, r.Err()
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
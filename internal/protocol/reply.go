@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Reply is a reply as a client reads it: RESP2's, or RESP3's maps, pushes
+// and nulls.
+type Reply struct {
+	Kind  byte    // '+', '-', ':', '$', '*', '%', '>' or '_'
+	Str   string  // the simple string, error message or bulk string
+	Int   int64   // the integer
+	Array []Reply // the elements of an array or push, or a map's keys and values in turn
+	Null  bool    // a null bulk string or array, or RESP3's null
+}
+
+// Err returns the error an error reply carries, or nil for other replies.
+func (r Reply) Err() error {
+	if r.Kind != '-' {
+		return nil
+	}
+	return errors.New(r.Str)
+}
+
+// Strings returns the elements of an array of bulk strings.
+func (r Reply) Strings() []string {
+	out := make([]string, len(r.Array))
+	for i, e := range r.Array {
+		out[i] = e.Str
+	}
+	return out
+}
+
+// ReadReply reads one reply sent by a RESP server.
+func (p *Parser) ReadReply() (Reply, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Reply{}, err
+	}
+	r := Reply{Kind: line[0]}
+	switch r.Kind {
+	case '+', '-':
+		r.Str = line[1:]
+	case ':':
+		if r.Int, err = strconv.ParseInt(line[1:], 10, 64); err != nil {
+			return Reply{}, fmt.Errorf("invalid integer reply: %w", err)
+		}
+	case '$':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || n < -1 {
+			return Reply{}, fmt.Errorf("invalid bulk string length: %s", line[1:])
+		}
+		if n == -1 {
+			r.Null = true
+			break
+		}
+		if n > p.maxLength {
+			return Reply{}, fmt.Errorf("bulk string exceeds max length: %d > %d", n, p.maxLength)
+		}
+		str, err := p.readBulk(n)
+		if err == errNoCRLF {
+			return Reply{}, err
+		}
+		if err != nil {
+			return Reply{}, fmt.Errorf("failed to read bulk string: %w", err)
+		}
+		r.Str = str
+	case '_':
+		r.Null = true
+	case '*', '%', '>':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return Reply{}, fmt.Errorf("invalid array length: %s", line[1:])
+		}
+		if n == -1 {
+			r.Null = true
+			break
+		}
+		if r.Kind == '%' {
+			n *= 2
+		}
+		r.Array = make([]Reply, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			e, err := p.ReadReply()
+			if err != nil {
+				return Reply{}, err
+			}
+			r.Array = append(r.Array, e)
+		}
+	default:
+		return Reply{}, fmt.Errorf("unexpected reply type %q", r.Kind)
+	}
+	return r, nil
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	input := "+OK\r\n-ERR bad\r\n:42\r\n$5\r\nhello\r\n$-1\r\n*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$0\r\n\r\n*-1\r\n"
+	p := NewParser(strings.NewReader(input))
+
+	want := []func(Reply) bool{
+		func(r Reply) bool { return r.Kind == '+' && r.Str == "OK" && r.Err() == nil },
+		func(r Reply) bool { return r.Kind == '-' && r.Err() != nil && r.Err().Error() == "ERR bad" },
+		func(r Reply) bool { return r.Kind == ':' && r.Int == 42 },
+		func(r Reply) bool { return r.Kind == '$' && r.Str == "hello" },
+		func(r Reply) bool { return r.Kind == '$' && r.Null },
+		func(r Reply) bool {
+			return r.Kind == '*' && len(r.Array) == 2 && r.Array[0].Str == "0" &&
+				strings.Join(r.Array[1].Strings(), ",") == "a,"
+		},
+		func(r Reply) bool { return r.Kind == '*' && r.Null },
+	}
+	for i, ok := range want {
+		r, err := p.ReadReply()
+		if err != nil || !ok(r) {
+			t.Fatalf("reply %d: unexpected %+v (%v)", i, r, err)
+		}
+	}
+
+	for _, bad := range []string{"?x\r\n", ":x\r\n", "$3\r\nab\r\n", "*x\r\n"} {
+		if _, err := NewParser(strings.NewReader(bad)).ReadReply(); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		p := NewParser(server)
+		w := NewWriter(server)
+		for {
+			args, err := p.Parse()
+			if err != nil {
+				return
+			}
+			if args[0] == "PING" {
+				w.WriteSimpleString("PONG")
+			} else {
+				w.WriteError("ERR unknown command")
+			}
+			w.Flush()
+		}
+	}()
+
+	c := NewClient(client)
+	if r, err := c.Do("PING"); err != nil || r.Str != "PONG" {
+		t.Fatalf("expected PONG, got %+v (%v)", r, err)
+	}
+	if _, err := c.Do("NOPE"); err == nil || err.Error() != "ERR unknown command" {
+		t.Fatalf("expected the error reply as error, got %v", err)
+	}
+}
+
+func TestWriteValue(t *testing.T) {
+	var b strings.Builder
+	v := []any{"slave", 6379, int64(-1), nil, []string{"a"}, []any{}}
+	if err := NewWriter(&b).WriteValue(v); err != nil {
+		t.Fatalf("WriteValue: %v", err)
+	}
+	want := "*6\r\n$5\r\nslave\r\n:6379\r\n:-1\r\n$-1\r\n*1\r\n$1\r\na\r\n*0\r\n"
+	if b.String() != want {
+		t.Fatalf("WriteValue wrote %q, want %q", b.String(), want)
+	}
+	if err := NewWriter(&b).WriteValue(1.5); err == nil {
+		t.Fatalf("expected an error for an unsupported type")
+	}
+}
+
+func TestWriteRESP3(t *testing.T) {
+	v := Map{"proto", 3, "modules", []any{}, "missing", nil}
+	var b strings.Builder
+	w := NewWriter(&b)
+	if err := w.WriteValue(v); err != nil {
+		t.Fatalf("WriteValue: %v", err)
+	}
+	if want := "*6\r\n$5\r\nproto\r\n:3\r\n$7\r\nmodules\r\n*0\r\n$7\r\nmissing\r\n$-1\r\n"; b.String() != want {
+		t.Fatalf("expected a flat array in RESP2, got %q", b.String())
+	}
+
+	b.Reset()
+	w.SetProtocol(3)
+	if err := w.WriteValue(v); err != nil {
+		t.Fatalf("WriteValue: %v", err)
+	}
+	want := "%3\r\n$5\r\nproto\r\n:3\r\n$7\r\nmodules\r\n*0\r\n$7\r\nmissing\r\n_\r\n"
+	if b.String() != want {
+		t.Fatalf("WriteValue wrote %q, want %q", b.String(), want)
+	}
+	r, err := NewParser(strings.NewReader(want)).ReadReply()
+	if err != nil || r.Kind != '%' || len(r.Array) != 6 || r.Array[1].Int != 3 || !r.Array[5].Null {
+		t.Fatalf("unexpected %+v (%v)", r, err)
+	}
+
+	b.Reset()
+	w.WritePush([]any{"message", "news", "hi"})
+	if want := ">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n"; b.String() != want {
+		t.Fatalf("WritePush wrote %q, want %q", b.String(), want)
+	}
+	r, err = NewParser(strings.NewReader(b.String())).ReadReply()
+	if err != nil || r.Kind != '>' || strings.Join(r.Strings(), " ") != "message news hi" {
+		t.Fatalf("unexpected %+v (%v)", r, err)
+	}
+}
@@ -2,19 +2,51 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"strconv"
 	"strings"
+	"sync"
 )
 
 // TODO: Parser is basic and works for common RESP patterns. Consider hardening it to
 // handle edge cases and invalid input more robustly (large bulk lengths, partial reads,
 // malformed bytes). Add tests for malformed RESP inputs.
 
+// ProtocolError is a request that does not follow RESP. After a
+// recoverable one, an inline command, the parser skipped to the end of its
+// line and can parse the next request; after any other it is somewhere in
+// the middle of a malformed request, so the connection cannot be trusted
+// to be in step again and, as Redis does, should be closed once told.
+type ProtocolError struct {
+	Msg         string
+	Recoverable bool
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
+}
+
+// protocolErrorf returns an unrecoverable ProtocolError.
+func protocolErrorf(format string, a ...any) error {
+	return &ProtocolError{Msg: fmt.Sprintf(format, a...)}
+}
+
+var (
+	// errEmptyLine is returned by readLineBytes for an empty line.
+	errEmptyLine = errors.New("empty line")
+	// errLineTooLong is returned by readLineBytes for a line over the
+	// request size, once it skipped to its end.
+	errLineTooLong = errors.New("line exceeds max request size")
+)
+
 type Parser struct {
 	reader    *bufio.Reader
 	maxLength int64
+	// maxRequest bounds the bulk strings of a request together, and any
+	// line, or nothing if 0.
+	maxRequest int64
 }
 
 func NewParser(r io.Reader) *Parser {
@@ -24,64 +56,98 @@ func NewParser(r io.Reader) *Parser {
 	}
 }
 
+// Wait blocks until the next request starts arriving, without consuming
+// it, so that callers can time idleness apart from reading a request.
+func (p *Parser) Wait() error {
+	_, err := p.reader.Peek(1)
+	return err
+}
+
+// Buffered returns the number of bytes of requests read but not parsed
+// yet, as when a client pipelines commands.
+func (p *Parser) Buffered() int {
+	return p.reader.Buffered()
+}
+
 func (p *Parser) Parse() ([]string, error) {
-	line, err := p.readLine()
-	if err != nil {
-		return nil, err
-	}
+	return p.ParseInto(nil)
+}
 
-	if len(line) == 0 {
-		return nil, fmt.Errorf("empty command")
+// ParseInto is Parse decoding into args, reusing its space: the returned
+// slice may share args' array, so callers that reuse it across requests
+// must not keep a request's slice past the next call. Empty lines, which
+// some clients send to keep a connection alive, parse to no arguments.
+// Requests that do not follow RESP return a *ProtocolError.
+func (p *Parser) ParseInto(args []string) ([]string, error) {
+	multibulk := false
+	if b, err := p.reader.Peek(1); err == nil {
+		multibulk = b[0] == '*'
+	}
+	line, err := p.readLineBytes()
+	switch {
+	case err == errEmptyLine:
+		return args[:0], nil
+	case err == errLineTooLong && multibulk:
+		return nil, protocolErrorf("%v: %d bytes", err, p.maxRequest)
+	case err == errLineTooLong:
+		return nil, &ProtocolError{Msg: fmt.Sprintf("too big inline request: %d bytes", p.maxRequest), Recoverable: true}
+	case err != nil:
+		return nil, err
 	}
 
 	switch line[0] {
 	case '*':
-		return p.parseArray(line)
+		return p.parseArray(line, args[:0])
 	default:
-		return p.parseInline(line)
+		return p.parseInline(string(line))
 	}
 }
 
-func (p *Parser) parseArray(line string) ([]string, error) {
+func (p *Parser) parseArray(line []byte, args []string) ([]string, error) {
 	if len(line) < 2 {
-		return nil, fmt.Errorf("malformed array header")
+		return nil, protocolErrorf("malformed array header")
 	}
 
-	count, err := strconv.Atoi(line[1:])
-	if err != nil {
-		return nil, fmt.Errorf("invalid array length: %w", err)
+	count, ok := parseLength(line[1:])
+	if !ok {
+		return nil, protocolErrorf("invalid array length: %q", line[1:])
 	}
 
 	if count < 0 {
-		return nil, fmt.Errorf("negative array length: %d", count)
+		return nil, protocolErrorf("negative array length: %d", count)
 	}
 
 	if count > 1000000 {
-		return nil, fmt.Errorf("array length too large: %d", count)
+		return nil, protocolErrorf("array length too large: %d", count)
 	}
 
-	args := make([]string, 0, count)
-	for i := 0; i < count; i++ {
-		bulkLine, err := p.readLine()
+	if cap(args) < int(count) {
+		args = make([]string, 0, count)
+	}
+	var size int64
+	for i := 0; i < int(count); i++ {
+		bulkLine, err := p.readLineBytes()
+		if err == errEmptyLine {
+			return nil, protocolErrorf("empty bulk string header at index %d", i)
+		}
+		if err == errLineTooLong {
+			return nil, protocolErrorf("bulk string header at index %d %v: %d bytes", i, err, p.maxRequest)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("error reading bulk string %d: %w", i, err)
 		}
 
-		if len(bulkLine) == 0 {
-			return nil, fmt.Errorf("empty bulk string header at index %d", i)
-		}
-
 		if bulkLine[0] != '$' {
-			return nil, fmt.Errorf("expected bulk string at index %d, got %c", i, bulkLine[0])
+			return nil, protocolErrorf("expected bulk string at index %d, got %c", i, bulkLine[0])
 		}
 
-		length, err := strconv.ParseInt(bulkLine[1:], 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid bulk string length at index %d: %w", i, err)
+		length, ok := parseLength(bulkLine[1:])
+		if !ok {
+			return nil, protocolErrorf("invalid bulk string length at index %d: %q", i, bulkLine[1:])
 		}
 
 		if length < -1 {
-			return nil, fmt.Errorf("invalid bulk string length at index %d: %d", i, length)
+			return nil, protocolErrorf("invalid bulk string length at index %d: %d", i, length)
 		}
 
 		if length == -1 {
@@ -91,46 +157,252 @@ func (p *Parser) parseArray(line string) ([]string, error) {
 		}
 
 		if length > p.maxLength {
-			return nil, fmt.Errorf("bulk string exceeds max length at index %d: %d > %d", i, length, p.maxLength)
+			return nil, protocolErrorf("bulk string exceeds max length at index %d: %d > %d", i, length, p.maxLength)
+		}
+		if size += length; p.maxRequest > 0 && size > p.maxRequest {
+			return nil, protocolErrorf("request exceeds max size at index %d: %d > %d", i, size, p.maxRequest)
 		}
 
-		buf := make([]byte, length+2)
-		n, err := io.ReadFull(p.reader, buf)
+		arg, err := p.readBulk(length)
+		if err == errNoCRLF {
+			return nil, protocolErrorf("bulk string at index %d missing CRLF terminator", i)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read bulk string data at index %d: %w (read %d/%d bytes)", i, err, n, length+2)
+			return nil, fmt.Errorf("failed to read bulk string data at index %d: %w", i, err)
+		}
+
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+// parseLength parses a decimal length of a RESP header without allocating.
+func parseLength(b []byte) (int64, bool) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
 		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// errNoCRLF is returned by readBulk for a bulk string not followed by CRLF.
+var errNoCRLF = errors.New("bulk string missing CRLF terminator")
 
-		if buf[length] != '\r' || buf[length+1] != '\n' {
-			return nil, fmt.Errorf("bulk string at index %d missing CRLF terminator", i)
+// maxPooledBulk is the largest bulk string read through bulkPool's buffers;
+// larger ones, rare, get a buffer of their own rather than growing the
+// pooled ones for good.
+const maxPooledBulk = 64 << 10
+
+// bulkPool holds the buffers bulk strings too long for the reader's buffer
+// are read into before they are copied to a string.
+var bulkPool = sync.Pool{New: func() any {
+	b := make([]byte, 0, 4096)
+	return &b
+}}
+
+// readBulk reads a bulk string of length bytes and its CRLF. Only the
+// string is allocated: it is copied straight from the reader's buffer when
+// it is all there, or else read into a pooled buffer.
+func (p *Parser) readBulk(length int64) (string, error) {
+	n := int(length) + 2
+	if n <= p.reader.Size() {
+		b, err := p.reader.Peek(n)
+		if err != nil {
+			return "", err
 		}
+		if b[length] != '\r' || b[length+1] != '\n' {
+			return "", errNoCRLF
+		}
+		s := string(b[:length])
+		p.reader.Discard(n)
+		return s, nil
+	}
 
-		args = append(args, string(buf[:length]))
+	var buf []byte
+	if n <= maxPooledBulk {
+		bp := bulkPool.Get().(*[]byte)
+		defer bulkPool.Put(bp)
+		if cap(*bp) < n {
+			*bp = make([]byte, n)
+		}
+		buf = (*bp)[:n]
+	} else {
+		buf = make([]byte, n)
 	}
+	if _, err := io.ReadFull(p.reader, buf); err != nil {
+		return "", err
+	}
+	if buf[length] != '\r' || buf[length+1] != '\n' {
+		return "", errNoCRLF
+	}
+	return string(buf[:length]), nil
+}
 
+func (p *Parser) parseInline(line string) ([]string, error) {
+	args, ok := splitArgs(line)
+	if !ok {
+		return nil, &ProtocolError{Msg: "unbalanced quotes in request", Recoverable: true}
+	}
 	return args, nil
 }
 
-func (p *Parser) parseInline(line string) ([]string, error) {
-	parts := strings.Fields(line)
-	if len(parts) == 0 {
-		return nil, fmt.Errorf("empty inline command")
+// splitArgs splits an inline command into arguments the way Redis'
+// sdssplitargs does: on whitespace, except within double quotes, which take
+// \n, \r, \t, \b, \a, \xHH and backslashed characters, or single quotes,
+// which only take \'. A closing quote must end its argument. It reports
+// false for quotes left open or followed by something else.
+func splitArgs(line string) ([]string, bool) {
+	if strings.IndexAny(line, "\"'") < 0 {
+		// Unquoted, as most inline commands are.
+		return strings.Fields(line), true
+	}
+	var args []string
+	var arg strings.Builder
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, true
+		}
+		arg.Reset()
+		for ; i < len(line) && !isSpace(line[i]); i++ {
+			quote := line[i]
+			if quote != '"' && quote != '\'' {
+				arg.WriteByte(quote)
+				continue
+			}
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, false
+				}
+				c := line[i]
+				if c == quote {
+					break
+				}
+				if c != '\\' || i+1 == len(line) {
+					arg.WriteByte(c)
+					continue
+				}
+				next := line[i+1]
+				switch {
+				case quote == '\'':
+					if next != '\'' {
+						arg.WriteByte(c)
+						continue
+					}
+					arg.WriteByte(next)
+				case next == 'x' && i+3 < len(line) && isHex(line[i+2]) && isHex(line[i+3]):
+					arg.WriteByte(unhex(line[i+2])<<4 | unhex(line[i+3]))
+					i += 2
+				default:
+					arg.WriteByte(unescape(next))
+				}
+				i++
+			}
+			if i+1 < len(line) && !isSpace(line[i+1]) {
+				return nil, false
+			}
+		}
+		args = append(args, arg.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
+
+// unescape returns the character a backslash followed by c stands for
+// within double quotes.
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
 	}
-	return parts, nil
 }
 
 func (p *Parser) readLine() (string, error) {
-	line, err := p.reader.ReadString('\n')
+	line, err := p.readLineBytes()
+	return string(line), err
+}
+
+// readLineBytes reads a line without its CRLF. The line is only valid until
+// the next read. A line over the request size is skipped, up to its end,
+// for errLineTooLong.
+func (p *Parser) readLineBytes() ([]byte, error) {
+	line, err := p.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// Longer than the reader's buffer: gather it.
+		buf := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			if p.maxRequest > 0 && int64(len(buf)) > p.maxRequest {
+				break
+			}
+			line, err = p.reader.ReadSlice('\n')
+			buf = append(buf, line...)
+		}
+		line = buf
+	}
+	if p.maxRequest > 0 && int64(len(line)) > p.maxRequest {
+		for err == bufio.ErrBufferFull {
+			_, err = p.reader.ReadSlice('\n')
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, errLineTooLong
+	}
 	if err != nil {
 		if err == io.EOF && len(line) > 0 {
-			return "", fmt.Errorf("incomplete line: %w", err)
+			return nil, fmt.Errorf("incomplete line: %w", err)
 		}
-		return "", err
+		return nil, err
 	}
 
-	line = strings.TrimRight(line, "\r\n")
+	line = bytes.TrimRight(line, "\r\n")
 
 	if len(line) == 0 {
-		return "", fmt.Errorf("empty line")
+		return nil, errEmptyLine
 	}
 
 	return line, nil
@@ -141,3 +413,9 @@ func (p *Parser) readLine() (string, error) {
 func (p *Parser) SetMaxBulkLength(n int64) {
 	p.maxLength = n
 }
+
+// SetMaxRequestSize bounds the total length of a request's bulk strings,
+// and of any line, to n bytes; 0 removes the bound.
+func (p *Parser) SetMaxRequestSize(n int64) {
+	p.maxRequest = n
+}
@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"errors"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -147,6 +149,103 @@ func TestParseBulkStringExceedsMax(t *testing.T) {
 	}
 }
 
+func TestParseRequestExceedsMax(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$60\r\n" + strings.Repeat("x", 60) + "\r\n"
+	parser := NewParser(strings.NewReader(input))
+	parser.SetMaxBulkLength(100)
+	parser.SetMaxRequestSize(50)
+	_, err := parser.Parse()
+	if err == nil || !strings.Contains(err.Error(), "request exceeds max size") {
+		t.Fatalf("expected error for request exceeding max size, got %v", err)
+	}
+
+	parser = NewParser(strings.NewReader(input))
+	parser.SetMaxRequestSize(64)
+	if _, err := parser.Parse(); err != nil {
+		t.Fatalf("expected request within max size to parse, got %v", err)
+	}
+}
+
+func TestParseLineExceedsMax(t *testing.T) {
+	parser := NewParser(strings.NewReader("SET k " + strings.Repeat("x", 10000) + "\r\n"))
+	parser.SetMaxRequestSize(5000)
+	if _, err := parser.Parse(); err == nil {
+		t.Fatal("expected error for inline command exceeding max request size")
+	}
+}
+
+func TestParseProtocolErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		recoverable bool
+	}{
+		{"*x\r\n", false},
+		{"*1\r\n+OK\r\n", false},
+		{"*1\r\n$-5\r\n", false},
+		{"*1\r\n$3\r\nGETX\r\n", false},
+		{"*1\r\n$1\r\n" + strings.Repeat("x", 100) + "\r\nPING\r\n", false},
+		{"SET k " + strings.Repeat("x", 100) + "\r\nPING\r\n", true},
+	}
+	for _, tt := range tests {
+		parser := NewParser(strings.NewReader(tt.input))
+		parser.SetMaxRequestSize(50)
+		_, err := parser.Parse()
+		var perr *ProtocolError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%q: expected a protocol error, got %v", tt.input, err)
+		}
+		if perr.Recoverable != tt.recoverable {
+			t.Fatalf("%q: expected recoverable %v, got %v", tt.input, tt.recoverable, perr.Recoverable)
+		}
+		if !tt.recoverable {
+			continue
+		}
+		if args, err := parser.Parse(); err != nil || len(args) != 1 || args[0] != "PING" {
+			t.Fatalf("%q: expected the next command after the error, got %v, %v", tt.input, args, err)
+		}
+	}
+}
+
+func TestParseInlineQuotes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key 'it''s'`, nil},
+		{`SET key 'it\'s \n'`, []string{"SET", "key", `it's \n`}},
+		{`SET key "a\tb\r\n\"c\"\\ \x41\xZZ"`, []string{"SET", "key", "a\tb\r\n\"c\"\\ AxZZ"}},
+		{`SET "" x"y z"`, []string{"SET", "", "xy z"}},
+		{`SET key "open`, nil},
+		{`SET key "closed"after`, nil},
+	}
+	for _, tt := range tests {
+		args, err := NewParser(strings.NewReader(tt.input + "\r\n")).Parse()
+		if tt.want == nil {
+			var perr *ProtocolError
+			if !errors.As(err, &perr) || !perr.Recoverable {
+				t.Fatalf("%s: expected a recoverable protocol error, got %q, %v", tt.input, args, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(args, tt.want) {
+			t.Fatalf("%s: expected %q, got %q, %v", tt.input, tt.want, args, err)
+		}
+	}
+}
+
+func TestParseEmptyLine(t *testing.T) {
+	parser := NewParser(strings.NewReader("\r\n  \r\nPING\r\n"))
+	for i := 0; i < 2; i++ {
+		if args, err := parser.Parse(); err != nil || len(args) != 0 {
+			t.Fatalf("expected an empty line to parse to nothing, got %v, %v", args, err)
+		}
+	}
+	if args, err := parser.Parse(); err != nil || len(args) != 1 || args[0] != "PING" {
+		t.Fatalf("expected PING, got %v, %v", args, err)
+	}
+}
+
 func TestParseIncompleteBulkString(t *testing.T) {
 	input := "*1\r\n$10\r\nhello"
 	parser := NewParser(strings.NewReader(input))
@@ -202,3 +301,65 @@ func TestParseBinaryData(t *testing.T) {
 		t.Fatalf("binary data not parsed correctly")
 	}
 }
+
+// repeatReader serves the same requests for ever.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.data)
+	}
+	return n, nil
+}
+
+func benchmarkParse(b *testing.B, request string, reuse bool) {
+	p := NewParser(&repeatReader{data: []byte(request)})
+	var args []string
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if reuse {
+			args, err = p.ParseInto(args)
+		} else {
+			args, err = p.Parse()
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+const (
+	benchSet = "*3\r\n$3\r\nSET\r\n$10\r\nkey:000042\r\n$16\r\nvalue:0000000042\r\n"
+	benchGet = "*2\r\n$3\r\nGET\r\n$10\r\nkey:000042\r\n"
+)
+
+func BenchmarkParseSet(b *testing.B)      { benchmarkParse(b, benchSet, false) }
+func BenchmarkParseSetReuse(b *testing.B) { benchmarkParse(b, benchSet, true) }
+func BenchmarkParseGet(b *testing.B)      { benchmarkParse(b, benchGet, false) }
+func BenchmarkParseGetReuse(b *testing.B) { benchmarkParse(b, benchGet, true) }
+func BenchmarkParseLargeBulk(b *testing.B) {
+	benchmarkParse(b, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$20000\r\n"+strings.Repeat("v", 20000)+"\r\n", true)
+}
+
+func TestParseInto(t *testing.T) {
+	p := NewParser(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\na\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\n"))
+	first, err := p.ParseInto(nil)
+	if err != nil || len(first) != 2 || first[1] != "a" {
+		t.Fatalf("expected [GET a], got %q (%v)", first, err)
+	}
+	second, err := p.ParseInto(first)
+	if err != nil || len(second) != 2 || second[1] != "b" {
+		t.Fatalf("expected [GET b], got %q (%v)", second, err)
+	}
+	if &second[0] != &first[0] {
+		t.Fatal("expected the arguments to be decoded into the given slice")
+	}
+}
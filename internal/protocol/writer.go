@@ -1,48 +1,108 @@
 package protocol
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 type Writer struct {
 	w io.Writer
+	// buf holds what was written until Flush, so a reply goes out in
+	// one write rather than one per element; it is nil when writing to
+	// memory.
+	buf *bufio.Writer
+	// proto is the RESP version replies are encoded in: 2, or 3 once the
+	// client asks for it with HELLO.
+	proto int
+	// line is where headers and short lines are encoded, reused so that
+	// writing a reply does not allocate.
+	line []byte
 }
 
+// NewWriter returns a Writer to w. What is written is buffered until
+// Flush, unless w is a bytes.Buffer or strings.Builder.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w: w}
+	switch w.(type) {
+	case *bytes.Buffer, *strings.Builder:
+		return &Writer{w: w, proto: 2}
+	}
+	buf := bufio.NewWriter(w)
+	return &Writer{w: buf, buf: buf, proto: 2}
+}
+
+// Flush writes what is buffered to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.buf == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+// Buffered returns the number of bytes written but not flushed yet.
+func (w *Writer) Buffered() int {
+	if w.buf == nil {
+		return 0
+	}
+	return w.buf.Buffered()
 }
 
+// SetProtocol switches the replies written from now on to RESP version
+// proto, 2 or 3.
+func (w *Writer) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+// Protocol returns the RESP version replies are encoded in.
+func (w *Writer) Protocol() int {
+	return w.proto
+}
+
+// Map is a reply made of alternating keys and values. It is written as a
+// RESP3 map, or as a flat array to RESP2 clients.
+type Map []any
+
 // TODO: Writer covers the main RESP types. If you add complex types (e.g., nested arrays
 // or custom serialization for zset members), add helper methods and tests here.
 
 func (w *Writer) WriteSimpleString(s string) error {
-	_, err := fmt.Fprintf(w.w, "+%s\r\n", s)
-	return err
+	return w.writeLine('+', s)
 }
 
 func (w *Writer) WriteError(s string) error {
-	_, err := fmt.Fprintf(w.w, "-%s\r\n", s)
-	return err
+	return w.writeLine('-', s)
 }
 
 func (w *Writer) WriteInteger(n int) error {
-	_, err := fmt.Fprintf(w.w, ":%d\r\n", n)
-	return err
+	return w.writeHeader(':', int64(n))
 }
 
 func (w *Writer) WriteBulkString(s string) error {
-	_, err := fmt.Fprintf(w.w, "$%d\r\n%s\r\n", len(s), s)
+	if err := w.writeHeader('$', int64(len(s))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w.w, s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, "\r\n")
 	return err
 }
 
+// WriteNull writes a null bulk string, or RESP3's null.
 func (w *Writer) WriteNull() error {
-	_, err := fmt.Fprintf(w.w, "$-1\r\n")
+	if w.proto == 3 {
+		_, err := io.WriteString(w.w, "_\r\n")
+		return err
+	}
+	_, err := io.WriteString(w.w, "$-1\r\n")
 	return err
 }
 
 func (w *Writer) WriteArray(arr []string) error {
-	if _, err := fmt.Fprintf(w.w, "*%d\r\n", len(arr)); err != nil {
+	if err := w.writeHeader('*', int64(len(arr))); err != nil {
 		return err
 	}
 	for _, s := range arr {
@@ -53,6 +113,21 @@ func (w *Writer) WriteArray(arr []string) error {
 	return nil
 }
 
+// writeLine writes s as a line of kind, like a simple string or an error.
+func (w *Writer) writeLine(kind byte, s string) error {
+	w.line = append(append(append(w.line[:0], kind), s...), '\r', '\n')
+	_, err := w.w.Write(w.line)
+	return err
+}
+
+// writeHeader writes n as a line of kind, like an integer or the length
+// of a bulk string or aggregate.
+func (w *Writer) writeHeader(kind byte, n int64) error {
+	w.line = append(strconv.AppendInt(append(w.line[:0], kind), n, 10), '\r', '\n')
+	_, err := w.w.Write(w.line)
+	return err
+}
+
 // WriteZsetMember writes a zset member as a two-element array: [score, member]
 // Format: *2\r\n$N\r\nscore\r\n$M\r\nmember\r\n
 func (w *Writer) WriteZsetMember(score float64, member string) error {
@@ -90,3 +165,79 @@ func (w *Writer) WriteNestedArray(cursor string, keys []string) error {
 	}
 	return nil
 }
+
+// WriteValue writes v according to its Go type, for replies that mix types:
+// strings as bulk strings, integers as integers, slices as arrays, Map as
+// a map and nil as a null.
+func (w *Writer) WriteValue(v any) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteNull()
+	case string:
+		return w.WriteBulkString(v)
+	case int:
+		return w.WriteInteger(v)
+	case int64:
+		return w.writeHeader(':', v)
+	case []string:
+		return w.WriteArray(v)
+	case []any:
+		return w.writeAggregate('*', len(v), v)
+	case Map:
+		if w.proto == 3 {
+			return w.writeAggregate('%', len(v)/2, v)
+		}
+		return w.writeAggregate('*', len(v), v)
+	default:
+		return fmt.Errorf("cannot write %T as a RESP value", v)
+	}
+}
+
+// CheckValue returns an error if v, or any value it holds, is not one
+// WriteValue can write, which it would only find out halfway through the
+// reply.
+func CheckValue(v any) error {
+	switch v := v.(type) {
+	case nil, string, int, int64, []string:
+		return nil
+	case []any:
+		return checkValues(v)
+	case Map:
+		return checkValues(v)
+	default:
+		return fmt.Errorf("cannot write %T as a RESP value", v)
+	}
+}
+
+func checkValues(elems []any) error {
+	for _, e := range elems {
+		if err := CheckValue(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePush writes an out-of-band message, like one published to a
+// subscribed channel, as a RESP3 push, or as an array to RESP2 clients,
+// which tell them from replies by their content.
+func (w *Writer) WritePush(msg []any) error {
+	if w.proto == 3 {
+		return w.writeAggregate('>', len(msg), msg)
+	}
+	return w.writeAggregate('*', len(msg), msg)
+}
+
+// writeAggregate writes the header of an aggregate of kind with n entries,
+// followed by elems.
+func (w *Writer) writeAggregate(kind byte, n int, elems []any) error {
+	if err := w.writeHeader(kind, int64(n)); err != nil {
+		return err
+	}
+	for _, e := range elems {
+		if err := w.WriteValue(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
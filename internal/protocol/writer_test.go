@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// benchConn returns a loopback TCP connection whose peer discards what it
+// reads, so writes cost the system calls they do against a real client.
+func benchConn(b *testing.B) net.Conn {
+	b.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatalf("dial: %v", err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// benchReply is an LRANGE-like reply of 100 short elements.
+var benchReply = func() []string {
+	arr := make([]string, 100)
+	for i := range arr {
+		arr[i] = "element:" + strconv.Itoa(i)
+	}
+	return arr
+}()
+
+// BenchmarkWriteArrayPerFragment flushes every fragment, one system call
+// each, as the Writer did before it buffered.
+func BenchmarkWriteArrayPerFragment(b *testing.B) {
+	w := NewWriter(benchConn(b))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.buf.WriteString("*" + strconv.Itoa(len(benchReply)) + "\r\n")
+		w.Flush()
+		for _, s := range benchReply {
+			w.WriteBulkString(s)
+			if err := w.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkWriteArrayBuffered flushes once per reply.
+func BenchmarkWriteArrayBuffered(b *testing.B) {
+	w := NewWriter(benchConn(b))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.WriteArray(benchReply)
+		if err := w.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	w := NewWriter(server)
+	w.WriteSimpleString("OK")
+	w.WriteInteger(1)
+	if got := w.Buffered(); got != len("+OK\r\n:1\r\n") {
+		t.Fatalf("expected the replies to be buffered, got %d bytes", got)
+	}
+	go w.Flush()
+	buf := make([]byte, 64)
+	n, err := io.ReadAtLeast(client, buf, len("+OK\r\n:1\r\n"))
+	if err != nil || string(buf[:n]) != "+OK\r\n:1\r\n" {
+		t.Fatalf("expected both replies in one write, got %q (%v)", buf[:n], err)
+	}
+}
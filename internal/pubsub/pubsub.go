@@ -0,0 +1,123 @@
+// Package pubsub implements the Pub/Sub broker: the subscribers of each
+// channel and pattern, and the delivery of the messages published to them.
+package pubsub
+
+import (
+	"sync"
+
+	"redis-from-scratch/internal/glob"
+)
+
+// Subscriber receives the messages published to the channels it is
+// subscribed to, as the RESP array to push to the client:
+// ["message", channel, message], or ["pmessage", pattern, channel, message]
+// for a channel matching one of its patterns. Deliver is called with the
+// broker's lock held, so it must not block or call back into the broker.
+type Subscriber interface {
+	Deliver(msg []string)
+}
+
+// Broker holds the subscribers of each channel and glob-style pattern. The
+// zero value is not ready to use; call New.
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[Subscriber]struct{}
+	patterns map[string]map[Subscriber]struct{}
+}
+
+// New creates a broker without subscribers.
+func New() *Broker {
+	return &Broker{
+		channels: make(map[string]map[Subscriber]struct{}),
+		patterns: make(map[string]map[Subscriber]struct{}),
+	}
+}
+
+// Subscribe subscribes sub to channel, reporting false if it already was.
+func (b *Broker) Subscribe(sub Subscriber, channel string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return add(b.channels, channel, sub)
+}
+
+// Unsubscribe unsubscribes sub from channel, reporting false if it was not
+// subscribed. A channel is forgotten with its last subscriber.
+func (b *Broker) Unsubscribe(sub Subscriber, channel string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return remove(b.channels, channel, sub)
+}
+
+// Subscribed reports whether sub is subscribed to channel.
+func (b *Broker) Subscribed(sub Subscriber, channel string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.channels[channel][sub]
+	return ok
+}
+
+// PSubscribe subscribes sub to the channels matching pattern, with Redis'
+// glob rules (see glob.Match), reporting false if it already was.
+func (b *Broker) PSubscribe(sub Subscriber, pattern string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return add(b.patterns, pattern, sub)
+}
+
+// PUnsubscribe unsubscribes sub from pattern, reporting false if it was not
+// subscribed.
+func (b *Broker) PUnsubscribe(sub Subscriber, pattern string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return remove(b.patterns, pattern, sub)
+}
+
+func add(subs map[string]map[Subscriber]struct{}, name string, sub Subscriber) bool {
+	set := subs[name]
+	if set == nil {
+		set = make(map[Subscriber]struct{})
+		subs[name] = set
+	}
+	if _, ok := set[sub]; ok {
+		return false
+	}
+	set[sub] = struct{}{}
+	return true
+}
+
+func remove(subs map[string]map[Subscriber]struct{}, name string, sub Subscriber) bool {
+	set := subs[name]
+	if _, ok := set[sub]; !ok {
+		return false
+	}
+	delete(set, sub)
+	if len(set) == 0 {
+		delete(subs, name)
+	}
+	return true
+}
+
+// Publish delivers message to the subscribers of channel and of the
+// patterns it matches, and returns how many deliveries were made: a
+// subscriber matching several ways receives the message once for each.
+func (b *Broker) Publish(channel, message string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	subs := b.channels[channel]
+	msg := []string{"message", channel, message}
+	for sub := range subs {
+		sub.Deliver(msg)
+	}
+	n := len(subs)
+	for pattern, subs := range b.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		msg := []string{"pmessage", pattern, channel, message}
+		for sub := range subs {
+			sub.Deliver(msg)
+		}
+		n += len(subs)
+	}
+	return n
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+)
+
+type recorder struct {
+	msgs [][]string
+}
+
+func (r *recorder) Deliver(msg []string) {
+	r.msgs = append(r.msgs, msg)
+}
+
+func TestPublish(t *testing.T) {
+	b := New()
+	a, c := &recorder{}, &recorder{}
+	if !b.Subscribe(a, "news") || b.Subscribe(a, "news") {
+		t.Fatalf("expected only the first subscription to be new")
+	}
+	b.Subscribe(c, "news")
+	b.Subscribe(c, "sport")
+
+	if n := b.Publish("news", "hello"); n != 2 {
+		t.Fatalf("expected 2 receivers, got %d", n)
+	}
+	if n := b.Publish("weather", "rain"); n != 0 {
+		t.Fatalf("expected no receivers, got %d", n)
+	}
+	want := [][]string{{"message", "news", "hello"}}
+	if !reflect.DeepEqual(a.msgs, want) || !reflect.DeepEqual(c.msgs, want) {
+		t.Fatalf("expected %v, got %v and %v", want, a.msgs, c.msgs)
+	}
+
+	if !b.Unsubscribe(a, "news") || b.Unsubscribe(a, "news") {
+		t.Fatalf("expected only the first unsubscription to count")
+	}
+	if n := b.Publish("news", "again"); n != 1 {
+		t.Fatalf("expected 1 receiver, got %d", n)
+	}
+	b.Unsubscribe(c, "news")
+	if _, ok := b.channels["news"]; ok {
+		t.Fatalf("expected the channel without subscribers to be forgotten")
+	}
+}
+
+func TestPatternPublish(t *testing.T) {
+	b := New()
+	a, c := &recorder{}, &recorder{}
+	if !b.PSubscribe(a, "news.*") || b.PSubscribe(a, "news.*") {
+		t.Fatalf("expected only the first subscription to be new")
+	}
+	b.Subscribe(a, "news.tech")
+	b.PSubscribe(c, "*")
+
+	if n := b.Publish("news.tech", "go"); n != 3 {
+		t.Fatalf("expected 3 deliveries, got %d", n)
+	}
+	want := [][]string{{"message", "news.tech", "go"}, {"pmessage", "news.*", "news.tech", "go"}}
+	if !reflect.DeepEqual(a.msgs, want) {
+		t.Fatalf("expected %v, got %v", want, a.msgs)
+	}
+	if n := b.Publish("weather", "rain"); n != 1 {
+		t.Fatalf("expected 1 delivery, got %d", n)
+	}
+
+	if !b.PUnsubscribe(a, "news.*") || b.PUnsubscribe(a, "news.*") {
+		t.Fatalf("expected only the first unsubscription to count")
+	}
+	if _, ok := b.patterns["news.*"]; ok {
+		t.Fatalf("expected the pattern without subscribers to be forgotten")
+	}
+}
@@ -0,0 +1,511 @@
+package sentinel
+
+import (
+	"bufio"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/protocol"
+)
+
+const (
+	// maxDesync spreads the sentinels' failover attempts, so that they do
+	// not all ask for votes in the same epoch, as in Redis.
+	maxDesync = time.Second
+	// electionTimeout bounds how long a sentinel waits to be elected.
+	electionTimeout = 10 * time.Second
+)
+
+// period is how often the sentinel checks the instances: every second, or
+// more often if they are considered down sooner.
+func (s *Sentinel) period() time.Duration {
+	return min(time.Second, max(s.cfg.DownAfter/3, 10*time.Millisecond))
+}
+
+func desync() time.Duration {
+	return time.Duration(rand.Int63n(int64(maxDesync)))
+}
+
+func (s *Sentinel) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.period())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.tick()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// tick checks the instances, exchanges hellos and, when the master is down,
+// asks the other sentinels and fails it over once they agree.
+func (s *Sentinel) tick() {
+	s.refresh()
+	s.sendHellos()
+
+	s.mu.Lock()
+	down := s.masterDown()
+	s.mu.Unlock()
+	if down {
+		s.askSentinels()
+	}
+
+	s.mu.Lock()
+	s.maybeStartFailover()
+	elected := s.failoverState == "wait-start" && s.electedLeader()
+	if s.failoverState == "wait-start" && !elected && time.Since(s.failoverStart) > min(electionTimeout, s.cfg.FailoverTimeout) {
+		logging.Warnf("-failover-abort-not-elected master %s %s", s.cfg.Name, s.master.addr)
+		s.failoverState = ""
+	}
+	epoch := s.failoverEpoch
+	s.mu.Unlock()
+	if elected {
+		s.failover(epoch)
+	}
+	s.reconfigureReplicas()
+}
+
+// refresh pings the master and replicas and reads their INFO replication,
+// learning the replicas from the master's.
+func (s *Sentinel) refresh() {
+	s.mu.Lock()
+	instances := []*instance{s.master}
+	for _, r := range s.replicas {
+		instances = append(instances, r)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.check(inst)
+		}()
+	}
+	wg.Wait()
+}
+
+// check pings an instance and records what its INFO replication reports.
+func (s *Sentinel) check(inst *instance) {
+	if _, err := s.query(inst.addr, "PING"); err != nil {
+		return
+	}
+	r, err := s.query(inst.addr, "INFO", "replication")
+	if err != nil {
+		return
+	}
+	info := parseInfo(r.Str)
+	offset, _ := strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst.lastOK = time.Now()
+	inst.role = info["role"]
+	inst.masterAddr = ""
+	if info["master_host"] != "" {
+		inst.masterAddr = net.JoinHostPort(info["master_host"], info["master_port"])
+	}
+	inst.linkUp = info["master_link_status"] == "up"
+	inst.offset = offset
+	if inst != s.master || inst.role != "master" {
+		return
+	}
+	for i := 0; ; i++ {
+		line, ok := info["slave"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		fields := parseFields(line)
+		addr := net.JoinHostPort(fields["ip"], fields["port"])
+		if _, known := s.replicas[addr]; !known && !sameAddr(addr, s.master.addr) {
+			logging.Infof("+slave slave %s %s @ %s %s", addr, addr, s.cfg.Name, s.master.addr)
+			s.replicas[addr] = &instance{addr: addr, role: "slave"}
+		}
+	}
+}
+
+// sendHellos tells the other sentinels who this one is and which master it
+// follows, in which configuration epoch.
+func (s *Sentinel) sendHellos() {
+	s.mu.Lock()
+	peers := make(map[string]bool, len(s.peers))
+	for addr := range s.peers {
+		peers[addr] = true
+	}
+	for _, p := range s.sentinels {
+		peers[p.addr] = true
+	}
+	host, port, _ := net.SplitHostPort(s.master.addr)
+	hello := []string{"SENTINEL", "HELLO", strconv.Itoa(s.listenPort()), s.runID,
+		strconv.FormatInt(s.currentEpoch, 10), s.cfg.Name, host, port, strconv.FormatInt(s.configEpoch, 10)}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for addr := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := s.query(addr, hello...)
+			if err != nil || len(r.Array) == 0 {
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			known := r.Strings()
+			if known[0] == s.runID {
+				delete(s.peers, addr)
+				return
+			}
+			s.addSentinel(addr, known[0])
+			for _, a := range known[1:] {
+				s.peers[a] = true
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// askSentinels asks the other sentinels whether they consider the master
+// down too and, while collecting votes, to vote for this one.
+func (s *Sentinel) askSentinels() {
+	s.mu.Lock()
+	host, port, _ := net.SplitHostPort(s.master.addr)
+	epoch, runID := s.currentEpoch, "*"
+	if s.failoverState == "wait-start" {
+		epoch, runID = s.failoverEpoch, s.runID
+	}
+	peers := make([]*peer, 0, len(s.sentinels))
+	for _, p := range s.sentinels {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := s.query(p.addr, "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", host, port, strconv.FormatInt(epoch, 10), runID)
+			if err != nil || len(r.Array) != 3 {
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			p.masterDown = r.Array[0].Int == 1
+			p.replyTime = time.Now()
+			if r.Array[1].Str != "*" {
+				p.leader, p.leaderEpoch = r.Array[1].Str, r.Array[2].Int
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// addSentinel records the sentinel with runID, reachable at addr. It is
+// called with mu held.
+func (s *Sentinel) addSentinel(addr, runID string) {
+	if p, ok := s.sentinels[runID]; ok {
+		p.lastHello = time.Now()
+		return
+	}
+	logging.Infof("+sentinel sentinel %s %s @ %s %s", runID, addr, s.cfg.Name, s.master.addr)
+	s.sentinels[runID] = &peer{addr: addr, runID: runID, lastHello: time.Now()}
+}
+
+// updateEpoch moves to a newer epoch another sentinel started. It is called
+// with mu held.
+func (s *Sentinel) updateEpoch(epoch int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		logging.Infof("+new-epoch %d", epoch)
+	}
+}
+
+// vote gives this sentinel's vote in epoch to runID, unless it already
+// voted in that epoch, and returns the sentinel it voted for. As in Raft,
+// the first to ask in an epoch gets the vote. It is called with mu held.
+func (s *Sentinel) vote(runID string, epoch int64) (string, int64) {
+	s.updateEpoch(epoch)
+	if s.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		s.leader, s.leaderEpoch = runID, epoch
+		logging.Infof("+vote-for-leader %s %d", runID, epoch)
+		// Leave the other sentinel time to fail over before trying.
+		if runID != s.runID {
+			s.failoverStart = time.Now().Add(desync())
+		}
+	}
+	return s.leader, s.leaderEpoch
+}
+
+// adoptConfig switches to the master another sentinel chose, if it did so
+// in a later epoch than the one the current master was chosen in. It is
+// called with mu held.
+func (s *Sentinel) adoptConfig(addr string, configEpoch int64) {
+	if configEpoch <= s.configEpoch {
+		return
+	}
+	s.configEpoch = configEpoch
+	if !sameAddr(addr, s.master.addr) {
+		s.switchMaster(addr)
+	}
+	s.failoverState, s.forceFailover = "", false
+}
+
+// switchMaster makes addr the master. The old one is kept as a replica to
+// reconfigure when it comes back. It is called with mu held.
+func (s *Sentinel) switchMaster(addr string) {
+	old := s.master
+	logging.Infof("+switch-master %s %s %s", s.cfg.Name, old.addr, addr)
+	s.master = &instance{addr: addr, role: "master", lastOK: time.Now()}
+	for a := range s.replicas {
+		if sameAddr(a, addr) {
+			delete(s.replicas, a)
+		}
+	}
+	s.replicas[old.addr] = &instance{addr: old.addr, role: old.role, lastOK: old.lastOK}
+}
+
+// down reports whether inst has not replied for DownAfter: subjectively
+// down, in Redis' terms. It is called with mu held.
+func (s *Sentinel) down(inst *instance) bool {
+	return time.Since(inst.lastOK) > s.cfg.DownAfter
+}
+
+func (s *Sentinel) masterDown() bool {
+	return s.down(s.master)
+}
+
+// objectivelyDown reports whether a quorum of sentinels, this one included,
+// recently said the master is down. It is called with mu held.
+func (s *Sentinel) objectivelyDown() bool {
+	if !s.masterDown() {
+		return false
+	}
+	n := 1
+	for _, p := range s.sentinels {
+		if p.masterDown && time.Since(p.replyTime) < 5*s.period()+time.Second {
+			n++
+		}
+	}
+	return n >= s.cfg.Quorum
+}
+
+// maybeStartFailover starts a new epoch and asks for votes in it when the
+// master is objectively down, or SENTINEL FAILOVER asked for a failover,
+// unless one was attempted within twice the failover timeout. A forced
+// failover needs no votes. It is called with mu held.
+func (s *Sentinel) maybeStartFailover() {
+	if s.failoverState != "" {
+		return
+	}
+	if !s.forceFailover {
+		if !s.objectivelyDown() {
+			s.startAfter = time.Time{}
+			return
+		}
+		if !s.failoverStart.IsZero() && time.Since(s.failoverStart) < 2*s.cfg.FailoverTimeout {
+			return
+		}
+		// Wait a random delay first, so the sentinels that noticed at the
+		// same time do not split the votes.
+		if s.startAfter.IsZero() {
+			s.startAfter = time.Now().Add(desync())
+		}
+		if time.Now().Before(s.startAfter) {
+			return
+		}
+	}
+	s.startAfter = time.Time{}
+	s.currentEpoch++
+	s.failoverEpoch = s.currentEpoch
+	s.failoverState = "wait-start"
+	s.failoverStart = time.Now()
+	logging.Infof("+try-failover master %s %s epoch %d", s.cfg.Name, s.master.addr, s.failoverEpoch)
+	s.vote(s.runID, s.failoverEpoch)
+}
+
+// electedLeader reports whether this sentinel has the votes of a majority
+// of the sentinels, and at least a quorum, in the failover epoch. It is
+// called with mu held.
+func (s *Sentinel) electedLeader() bool {
+	if s.forceFailover {
+		return true
+	}
+	votes := 0
+	if s.leader == s.runID && s.leaderEpoch == s.failoverEpoch {
+		votes++
+	}
+	for _, p := range s.sentinels {
+		if p.leader == s.runID && p.leaderEpoch == s.failoverEpoch {
+			votes++
+		}
+	}
+	return votes >= (len(s.sentinels)+1)/2+1 && votes >= s.cfg.Quorum
+}
+
+// selectReplica picks the replica to promote: of those replying, the one
+// that applied the most of the master's stream. It is called with mu held.
+func (s *Sentinel) selectReplica() *instance {
+	var best *instance
+	for _, addr := range sortedKeys(s.replicas) {
+		r := s.replicas[addr]
+		if s.down(r) || r.role != "slave" {
+			continue
+		}
+		if best == nil || r.offset > best.offset {
+			best = r
+		}
+	}
+	return best
+}
+
+// failover promotes a replica as the leader of epoch and makes it the
+// master; the other replicas are reconfigured by reconfigureReplicas.
+func (s *Sentinel) failover(epoch int64) {
+	s.mu.Lock()
+	logging.Infof("+elected-leader master %s %s epoch %d", s.cfg.Name, s.master.addr, epoch)
+	r := s.selectReplica()
+	if r == nil {
+		logging.Warnf("-failover-abort-no-good-slave master %s %s", s.cfg.Name, s.master.addr)
+		s.failoverState, s.forceFailover = "", false
+		s.mu.Unlock()
+		return
+	}
+	s.failoverState = "wait-promotion"
+	addr := r.addr
+	s.mu.Unlock()
+
+	logging.Infof("+selected-slave slave %s @ %s", addr, s.cfg.Name)
+	err := s.promote(addr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failoverState, s.forceFailover = "", false
+	if err != nil {
+		logging.Warnf("-failover-abort-slave-timeout slave %s @ %s: %v", addr, s.cfg.Name, err)
+		return
+	}
+	if s.configEpoch >= epoch {
+		// Another sentinel's configuration won meanwhile.
+		return
+	}
+	s.configEpoch = epoch
+	s.switchMaster(addr)
+	logging.Infof("+failover-end master %s %s", s.cfg.Name, addr)
+}
+
+// promote turns the replica at addr into a master and waits for it to
+// report so.
+func (s *Sentinel) promote(addr string) error {
+	if _, err := s.query(addr, "REPLICAOF", "NO", "ONE"); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(s.cfg.FailoverTimeout)
+	for time.Now().Before(deadline) {
+		if r, err := s.query(addr, "INFO", "replication"); err == nil && parseInfo(r.Str)["role"] == "master" {
+			logging.Infof("+promoted-slave slave %s @ %s", addr, s.cfg.Name)
+			return nil
+		}
+		select {
+		case <-time.After(s.period()):
+		case <-s.quit:
+			return errors.New("sentinel stopping")
+		}
+	}
+	return errors.New("timed out")
+}
+
+// reconfigureReplicas points replicas that follow another master, or came
+// back as masters after being failed over, at the current master. It
+// waits while the master is down or being failed over, when this
+// sentinel's view may be behind the others'.
+func (s *Sentinel) reconfigureReplicas() {
+	s.mu.Lock()
+	if s.masterDown() || s.failoverState != "" {
+		s.mu.Unlock()
+		return
+	}
+	master := s.master.addr
+	var stale []string
+	for addr, r := range s.replicas {
+		if s.down(r) || r.role == "" {
+			continue
+		}
+		if r.role == "master" || !sameAddr(r.masterAddr, master) {
+			stale = append(stale, addr)
+		}
+	}
+	s.mu.Unlock()
+
+	host, port, _ := net.SplitHostPort(master)
+	for _, addr := range stale {
+		if _, err := s.query(addr, "REPLICAOF", host, port); err != nil {
+			continue
+		}
+		logging.Infof("+slave-reconf-sent slave %s @ %s %s", addr, s.cfg.Name, master)
+		s.mu.Lock()
+		if r, ok := s.replicas[addr]; ok {
+			r.role, r.masterAddr = "slave", master
+		}
+		s.mu.Unlock()
+	}
+}
+
+// query sends one command to addr and returns its reply, giving up after
+// DownAfter.
+func (s *Sentinel) query(addr string, args ...string) (protocol.Reply, error) {
+	timeout := s.cfg.DownAfter
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return protocol.Reply{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	return protocol.NewClient(conn).Do(args...)
+}
+
+// listenPort is the port other sentinels reach this one on.
+func (s *Sentinel) listenPort() int {
+	if addr, ok := s.listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return s.cfg.Port
+}
+
+// parseInfo reads the fields of an INFO reply.
+func parseInfo(text string) map[string]string {
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// parseFields reads the comma separated key=value pairs of an INFO field
+// such as slave0:ip=...,port=....
+func parseFields(s string) map[string]string {
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
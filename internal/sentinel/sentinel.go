@@ -0,0 +1,467 @@
+// Package sentinel watches a master and its replicas and fails over to a
+// replica when the master goes down, as Redis Sentinel does. Sentinels
+// ping the master, agree it is down when a quorum of them stops getting
+// replies, elect one of them in a new epoch to promote the best replica,
+// and point the other replicas, and the old master when it comes back, at
+// the new master. Clients ask any sentinel where the master is with
+// SENTINEL GET-MASTER-ADDR-BY-NAME.
+//
+// Redis' sentinels discover each other and spread the new configuration
+// through Pub/Sub hello messages on the master; here they send the same
+// hello to each other directly with SENTINEL HELLO, so each sentinel is
+// given at least one other sentinel's address.
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/protocol"
+)
+
+// Config configures a sentinel.
+type Config struct {
+	// Port is the port the sentinel listens on.
+	Port int
+	// Name is what clients ask for the master by; MasterAddr is its
+	// address at startup.
+	Name       string
+	MasterAddr string
+	// Quorum is how many sentinels must agree the master is down before
+	// it is failed over.
+	Quorum int
+	// Sentinels are addresses of other sentinels watching the same master.
+	// Others are learned from their hellos.
+	Sentinels []string
+	// DownAfter is how long the master may go without replying before
+	// this sentinel considers it down.
+	DownAfter time.Duration
+	// FailoverTimeout bounds each step of a failover, and a sentinel waits
+	// twice as long before trying to fail the same master over again.
+	FailoverTimeout time.Duration
+}
+
+// DefaultConfig returns Redis Sentinel's defaults.
+func DefaultConfig() Config {
+	return Config{
+		Port:            26379,
+		Name:            "mymaster",
+		Quorum:          2,
+		DownAfter:       30 * time.Second,
+		FailoverTimeout: 3 * time.Minute,
+	}
+}
+
+// instance is the master or a replica, as the sentinel last saw it.
+type instance struct {
+	addr string
+	// lastOK is when it last replied to PING.
+	lastOK time.Time
+	// What INFO replication last reported: its role, the master it
+	// replicates, whether the link to it is up and the offset applied.
+	role       string
+	masterAddr string
+	linkUp     bool
+	offset     int64
+}
+
+// peer is another sentinel watching the same master.
+type peer struct {
+	addr      string
+	runID     string
+	lastHello time.Time
+	// Its last answer to IS-MASTER-DOWN-BY-ADDR: whether it considers the
+	// master down and the leader it voted for in leaderEpoch.
+	masterDown  bool
+	leader      string
+	leaderEpoch int64
+	replyTime   time.Time
+}
+
+// Sentinel monitors one master.
+type Sentinel struct {
+	cfg      Config
+	runID    string
+	listener net.Listener
+	quit     chan struct{}
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// master and replicas are keyed by address. Replicas are learned
+	// from the master's INFO; a failed master is kept among them, so it
+	// is turned into a replica when it comes back.
+	master   *instance
+	replicas map[string]*instance
+	// peers are the addresses hellos are sent to, and sentinels the
+	// sentinels that answered or sent one, by run ID.
+	peers     map[string]bool
+	sentinels map[string]*peer
+	// currentEpoch is the latest epoch seen, and configEpoch the one in
+	// which the current master was chosen. leader is the sentinel this
+	// one voted for in leaderEpoch.
+	currentEpoch int64
+	configEpoch  int64
+	leader       string
+	leaderEpoch  int64
+	// failoverState is empty, wait-start while votes are collected in
+	// failoverEpoch, then wait-promotion. failoverStart is when the last
+	// failover was attempted or a vote given for another's, and startAfter
+	// when this sentinel may try once the master is objectively down.
+	failoverState string
+	failoverEpoch int64
+	failoverStart time.Time
+	startAfter    time.Time
+	forceFailover bool
+}
+
+// New returns a sentinel monitoring cfg.MasterAddr.
+func New(cfg Config) *Sentinel {
+	s := &Sentinel{
+		cfg:       cfg,
+		runID:     newRunID(),
+		quit:      make(chan struct{}),
+		master:    &instance{addr: cfg.MasterAddr, role: "master", lastOK: time.Now()},
+		replicas:  make(map[string]*instance),
+		peers:     make(map[string]bool),
+		sentinels: make(map[string]*peer),
+	}
+	for _, addr := range cfg.Sentinels {
+		s.peers[addr] = true
+	}
+	return s
+}
+
+// newRunID returns a random 40 character run ID.
+func newRunID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start listens for clients and other sentinels and starts monitoring.
+func (s *Sentinel) Start() error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.Port))
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+	logging.Infof("Sentinel ID is %s", s.runID)
+	logging.Infof("+monitor master %s %s quorum %d", s.cfg.Name, s.cfg.MasterAddr, s.cfg.Quorum)
+
+	s.wg.Add(2)
+	go s.accept()
+	go s.run()
+	return nil
+}
+
+// Stop stops monitoring and closes the listener.
+func (s *Sentinel) Stop() {
+	close(s.quit)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
+}
+
+// Addr is the address the sentinel listens on.
+func (s *Sentinel) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Sentinel) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+				logging.Errorf("accept error: %v", err)
+				continue
+			}
+		}
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// serve answers a connection's commands until it is closed.
+func (s *Sentinel) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.quit:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	p := protocol.NewParser(conn)
+	w := protocol.NewWriter(conn)
+	for {
+		args, err := p.Parse()
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.execute(conn, args).WriteTo(w)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sentinel) execute(conn net.Conn, args []string) command.Response {
+	switch cmd := strings.ToUpper(args[0]); cmd {
+	case "PING":
+		return command.Response{Type: command.TypeSimpleString, Value: "PONG"}
+	case "INFO":
+		return command.Response{Type: command.TypeBulkString, Value: s.info()}
+	case "SENTINEL":
+		if len(args) < 2 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'sentinel' command")}
+		}
+		return s.cmdSentinel(conn, strings.ToUpper(args[1]), args[2:])
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown command '%s'", args[0])}
+	}
+}
+
+// cmdSentinel runs a SENTINEL subcommand.
+func (s *Sentinel) cmdSentinel(conn net.Conn, sub string, args []string) command.Response {
+	wrongArgs := command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'sentinel %s' command", strings.ToLower(sub))}
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME", "MASTER", "REPLICAS", "SLAVES", "SENTINELS", "FAILOVER":
+		if len(args) != 1 {
+			return wrongArgs
+		}
+		if args[0] != s.cfg.Name {
+			return command.Response{Type: command.TypeValue, Value: nil}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME":
+		host, port, _ := net.SplitHostPort(s.master.addr)
+		return command.Response{Type: command.TypeValue, Value: []string{host, port}}
+	case "MASTERS":
+		return command.Response{Type: command.TypeValue, Value: []any{s.masterFields()}}
+	case "MASTER":
+		return command.Response{Type: command.TypeValue, Value: s.masterFields()}
+	case "REPLICAS", "SLAVES":
+		out := []any{}
+		for _, addr := range sortedKeys(s.replicas) {
+			out = append(out, s.replicaFields(s.replicas[addr]))
+		}
+		return command.Response{Type: command.TypeValue, Value: out}
+	case "SENTINELS":
+		out := []any{}
+		for _, id := range sortedKeys(s.sentinels) {
+			p := s.sentinels[id]
+			host, port, _ := net.SplitHostPort(p.addr)
+			out = append(out, []string{
+				"name", p.addr, "ip", host, "port", port, "runid", p.runID, "flags", "sentinel",
+				"last-hello-message", msSince(p.lastHello),
+			})
+		}
+		return command.Response{Type: command.TypeValue, Value: out}
+	case "IS-MASTER-DOWN-BY-ADDR":
+		if len(args) != 4 {
+			return wrongArgs
+		}
+		epoch, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+		}
+		down := 0
+		if sameAddr(net.JoinHostPort(args[0], args[1]), s.master.addr) && s.masterDown() {
+			down = 1
+		}
+		leader, leaderEpoch := "*", int64(0)
+		if args[3] != "*" {
+			leader, leaderEpoch = s.vote(args[3], epoch)
+		}
+		return command.Response{Type: command.TypeValue, Value: []any{down, leader, leaderEpoch}}
+	case "HELLO":
+		if len(args) != 7 {
+			return wrongArgs
+		}
+		if err := s.hello(conn, args); err != nil {
+			return command.Response{Type: command.TypeError, Error: err}
+		}
+		// Reply with our run ID and the sentinels we know, so sentinels
+		// given one another's address learn about all of them.
+		reply := []string{s.runID}
+		for _, id := range sortedKeys(s.sentinels) {
+			reply = append(reply, s.sentinels[id].addr)
+		}
+		return command.Response{Type: command.TypeValue, Value: reply}
+	case "FAILOVER":
+		if s.failoverState != "" {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("INPROG Failover already in progress")}
+		}
+		if s.selectReplica() == nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("NOGOODSLAVE No suitable replica to promote")}
+		}
+		s.forceFailover = true
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown sentinel subcommand '%s'", sub)}
+	}
+}
+
+// hello records a hello from another sentinel: SENTINEL HELLO <port>
+// <run id> <current epoch> <master name> <master host> <master port>
+// <master config epoch>, Redis' hello message without the IP, which is
+// taken from the connection. A newer configuration of the master is
+// adopted. It is called with mu held.
+func (s *Sentinel) hello(conn net.Conn, args []string) error {
+	epoch, err1 := strconv.ParseInt(args[2], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(args[6], 10, 64)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	if args[1] == s.runID {
+		return nil
+	}
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	s.addSentinel(net.JoinHostPort(host, args[0]), args[1])
+	s.updateEpoch(epoch)
+	if args[3] == s.cfg.Name {
+		s.adoptConfig(net.JoinHostPort(args[4], args[5]), configEpoch)
+	}
+	return nil
+}
+
+// masterFields describes the master for SENTINEL MASTER. It is called with
+// mu held.
+func (s *Sentinel) masterFields() []string {
+	host, port, _ := net.SplitHostPort(s.master.addr)
+	flags := "master"
+	if s.masterDown() {
+		flags += ",s_down"
+		if s.objectivelyDown() {
+			flags += ",o_down"
+		}
+	}
+	if s.failoverState != "" {
+		flags += ",failover_in_progress"
+	}
+	return []string{
+		"name", s.cfg.Name,
+		"ip", host,
+		"port", port,
+		"flags", flags,
+		"last-ok-ping-reply", msSince(s.master.lastOK),
+		"down-after-milliseconds", strconv.FormatInt(s.cfg.DownAfter.Milliseconds(), 10),
+		"num-slaves", strconv.Itoa(len(s.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(s.sentinels)),
+		"quorum", strconv.Itoa(s.cfg.Quorum),
+		"failover-timeout", strconv.FormatInt(s.cfg.FailoverTimeout.Milliseconds(), 10),
+		"config-epoch", strconv.FormatInt(s.configEpoch, 10),
+	}
+}
+
+// replicaFields describes a replica for SENTINEL REPLICAS. It is called with
+// mu held.
+func (s *Sentinel) replicaFields(r *instance) []string {
+	host, port, _ := net.SplitHostPort(r.addr)
+	flags := "slave"
+	if s.down(r) {
+		flags += ",s_down"
+	}
+	masterHost, masterPort, _ := net.SplitHostPort(r.masterAddr)
+	link := "err"
+	if r.linkUp {
+		link = "ok"
+	}
+	return []string{
+		"name", r.addr,
+		"ip", host,
+		"port", port,
+		"flags", flags,
+		"last-ok-ping-reply", msSince(r.lastOK),
+		"role-reported", r.role,
+		"master-host", masterHost,
+		"master-port", masterPort,
+		"master-link-status", link,
+		"slave-repl-offset", strconv.FormatInt(r.offset, 10),
+	}
+}
+
+// info is the sentinel's INFO reply.
+func (s *Sentinel) info() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := "ok"
+	if s.objectivelyDown() {
+		status = "odown"
+	} else if s.masterDown() {
+		status = "sdown"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Sentinel\r\n")
+	fmt.Fprintf(&b, "sentinel_masters:1\r\n")
+	fmt.Fprintf(&b, "sentinel_run_id:%s\r\n", s.runID)
+	fmt.Fprintf(&b, "sentinel_current_epoch:%d\r\n", s.currentEpoch)
+	fmt.Fprintf(&b, "master0:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+		s.cfg.Name, status, s.master.addr, len(s.replicas), len(s.sentinels)+1)
+	return b.String()
+}
+
+func msSince(t time.Time) string {
+	return strconv.FormatInt(time.Since(t).Milliseconds(), 10)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameAddr reports whether a and b are the same host and port, resolving
+// names, since a master may be configured as localhost and reported by
+// its replicas as 127.0.0.1.
+func sameAddr(a, b string) bool {
+	if a == b {
+		return true
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	ipsA, errA := net.LookupHost(hostA)
+	ipsB, errB := net.LookupHost(hostB)
+	if errA != nil || errB != nil {
+		return false
+	}
+	for _, ipA := range ipsA {
+		for _, ipB := range ipsB {
+			if net.ParseIP(ipA).Equal(net.ParseIP(ipB)) {
+				return true
+			}
+		}
+	}
+	return false
+}
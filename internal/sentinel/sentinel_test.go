@@ -0,0 +1,179 @@
+package sentinel
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/protocol"
+	"redis-from-scratch/internal/server"
+	"redis-from-scratch/pkg/config"
+)
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func startServer(t *testing.T, port int) *server.Server {
+	srv := server.New(&config.Config{
+		Port:            port,
+		MaxConnections:  1000,
+		CleanupInterval: time.Second,
+		PersistencePath: t.TempDir(),
+		ReplicaReadOnly: true,
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func sentinelAddr(s *Sentinel) string {
+	return "127.0.0.1:" + strconv.Itoa(s.Addr().(*net.TCPAddr).Port)
+}
+
+func do(t *testing.T, addr string, args ...string) protocol.Reply {
+	t.Helper()
+	c, err := protocol.Dial(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r, _ := c.Do(args...)
+	return r
+}
+
+func TestFailover(t *testing.T) {
+	ports := []int{freePort(t), freePort(t), freePort(t)}
+	addrs := make([]string, len(ports))
+	servers := make([]*server.Server, len(ports))
+	for i, port := range ports {
+		addrs[i] = "127.0.0.1:" + strconv.Itoa(port)
+		servers[i] = startServer(t, port)
+		defer func() {
+			if servers[i] != nil {
+				servers[i].Stop()
+			}
+		}()
+	}
+	for _, addr := range addrs[1:] {
+		do(t, addr, "REPLICAOF", "127.0.0.1", strconv.Itoa(ports[0]))
+	}
+	do(t, addrs[0], "SET", "k", "v")
+
+	var sentinels []*Sentinel
+	for i := 0; i < 3; i++ {
+		cfg := Config{Name: "mymaster", MasterAddr: addrs[0], Quorum: 2, DownAfter: 300 * time.Millisecond, FailoverTimeout: time.Second}
+		if i > 0 {
+			cfg.Sentinels = []string{sentinelAddr(sentinels[0])}
+		}
+		s := New(cfg)
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Stop()
+		sentinels = append(sentinels, s)
+	}
+	for _, s := range sentinels {
+		waitFor(t, "the sentinels to discover the replicas and each other", func() bool {
+			info := do(t, sentinelAddr(s), "INFO").Str
+			return strings.Contains(info, "slaves=2,sentinels=3")
+		})
+	}
+
+	servers[0].Stop()
+	servers[0] = nil
+	var promoted string
+	for _, s := range sentinels {
+		waitFor(t, "the sentinels to fail over", func() bool {
+			r := do(t, sentinelAddr(s), "SENTINEL", "GET-MASTER-ADDR-BY-NAME", "mymaster")
+			if len(r.Array) != 2 {
+				return false
+			}
+			addr := net.JoinHostPort(r.Array[0].Str, r.Array[1].Str)
+			if addr == addrs[0] || promoted != "" && addr != promoted {
+				return false
+			}
+			promoted = addr
+			return true
+		})
+	}
+	if r := do(t, promoted, "ROLE"); len(r.Array) == 0 || r.Array[0].Str != "master" {
+		t.Fatalf("expected %s to be promoted, got %+v", promoted, r)
+	}
+	if r := do(t, promoted, "GET", "k"); r.Str != "v" {
+		t.Fatalf("expected the new master to have the data, got %+v", r)
+	}
+	_, promotedPort, _ := net.SplitHostPort(promoted)
+	for _, addr := range addrs[1:] {
+		if addr == promoted {
+			continue
+		}
+		waitFor(t, "the other replica to follow the new master", func() bool {
+			return strings.Contains(do(t, addr, "INFO", "replication").Str, "master_port:"+promotedPort)
+		})
+	}
+
+	// The old master comes back and is turned into a replica.
+	servers[0] = startServer(t, ports[0])
+	waitFor(t, "the old master to follow the new one", func() bool {
+		info := do(t, addrs[0], "INFO", "replication").Str
+		return strings.Contains(info, "role:slave") && strings.Contains(info, "master_port:"+promotedPort)
+	})
+}
+
+func TestForcedFailover(t *testing.T) {
+	mport, rport := freePort(t), freePort(t)
+	master := startServer(t, mport)
+	defer master.Stop()
+	replica := startServer(t, rport)
+	defer replica.Stop()
+	maddr, raddr := "127.0.0.1:"+strconv.Itoa(mport), "127.0.0.1:"+strconv.Itoa(rport)
+	do(t, raddr, "REPLICAOF", "127.0.0.1", strconv.Itoa(mport))
+
+	s := New(Config{Name: "mymaster", MasterAddr: maddr, Quorum: 1, DownAfter: 300 * time.Millisecond, FailoverTimeout: time.Second})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	saddr := sentinelAddr(s)
+	if r := do(t, saddr, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", "other"); !r.Null {
+		t.Fatalf("expected an unknown master to be null, got %+v", r)
+	}
+	waitFor(t, "the sentinel to discover the replica", func() bool {
+		return len(do(t, saddr, "SENTINEL", "REPLICAS", "mymaster").Array) == 1
+	})
+	waitFor(t, "the replica to be usable", func() bool {
+		return do(t, saddr, "SENTINEL", "FAILOVER", "mymaster").Str == "OK"
+	})
+	waitFor(t, "the forced failover", func() bool {
+		r := do(t, saddr, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", "mymaster")
+		return len(r.Array) == 2 && r.Array[1].Str == strconv.Itoa(rport)
+	})
+	// The old master, still up, is made a replica of the new one.
+	waitFor(t, "the old master to follow the new one", func() bool {
+		return strings.Contains(do(t, maddr, "INFO", "replication").Str, "role:slave")
+	})
+	if r := do(t, saddr, "SENTINEL", "MASTER", "mymaster"); !strings.Contains(strings.Join(r.Strings(), " "), "config-epoch 1") {
+		t.Fatalf("expected the failover to bump the config epoch, got %v", r.Strings())
+	}
+}
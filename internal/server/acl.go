@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"redis-from-scratch/internal/command"
+)
+
+// loadACL gives the default user requirepass and then loads aclfile, if
+// set, whose users replace it.
+func (s *Server) loadACL() error {
+	if s.cfg.RequirePass != "" {
+		if err := s.acl.SetUser("default", passRules(s.cfg.RequirePass)...); err != nil {
+			return err
+		}
+	}
+	if s.cfg.ACLFile != "" {
+		if err := s.acl.Load(s.cfg.ACLFile); err != nil {
+			return fmt.Errorf("failed to load ACL file: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkACL returns the NOPERM error if the connection's user may not run cmd
+// on its keys, or acl.ErrRemoved if the user was deleted. The commands run
+// before authenticating are not checked.
+func (s *Server) checkACL(sess *session, cmd string, args []string) error {
+	if authExempt[cmd] {
+		return nil
+	}
+	return s.acl.Check(sess.user, cmd, command.Keys(cmd, args))
+}
+
+// aclArity gives the number of arguments of each ACL subcommand, itself
+// included, or minus the least number.
+var aclArity = map[string]int{"SETUSER": -2, "GETUSER": 2, "DELUSER": -2, "LIST": 1, "WHOAMI": 1, "LOAD": 1}
+
+// ACL SETUSER username [rule ...] | GETUSER username | DELUSER username
+// [username ...] | LIST | WHOAMI | LOAD
+func (s *Server) cmdACL(sess *session, args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'acl' command")}
+	}
+	sub := strings.ToUpper(args[0])
+	arity := aclArity[sub]
+	switch {
+	case arity == 0:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try ACL HELP.", args[0])}
+	case arity > 0 && len(args) != arity, arity < 0 && len(args) < -arity:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'acl|%s' command", strings.ToLower(sub))}
+	}
+
+	switch sub {
+	case "SETUSER":
+		if err := s.acl.SetUser(args[1], args[2:]...); err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+		}
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	case "GETUSER":
+		info, ok := s.acl.GetUser(args[1])
+		if !ok {
+			return command.Response{Type: command.TypeNull}
+		}
+		return command.Response{Type: command.TypeValue, Value: info}
+	case "DELUSER":
+		n, err := s.acl.DelUser(args[1:]...)
+		if err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+		}
+		return command.Response{Type: command.TypeInteger, Value: n}
+	case "LIST":
+		return command.Response{Type: command.TypeArray, Value: s.acl.List()}
+	case "WHOAMI":
+		return command.Response{Type: command.TypeBulkString, Value: sess.user.Name()}
+	default: // LOAD
+		if s.cfg.ACLFile == "" {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")}
+		}
+		if err := s.acl.Load(s.cfg.ACLFile); err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+		}
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	admin := dialTest(t, port)
+	defer admin.conn.Close()
+	admin.send("ACL", "WHOAMI")
+	admin.expect("default")
+	admin.send("ACL", "SETUSER", "app", "on", ">pw", "~cache:*", "-@all", "+@read", "+set", "+acl")
+	admin.expect("OK")
+	admin.send("ACL", "SETUSER", "app", "+nosuchcommand")
+	admin.expect("-ERR Error in ACL SETUSER modifier '+nosuchcommand': Unknown command or category name in ACL")
+	admin.send("ACL", "LIST")
+	admin.expect("user app on #30c952fab122c3f9759f02a6d95c3758b246b4fee239957b2d4fee46e26170c4 ~cache:* -@all +acl +dump +exists +get +hget +hgetall +hscan +keys +lrange +object +scan +set +sismember +smembers +zrange",
+		"user default on nopass ~* +@all")
+	admin.send("ACL", "GETUSER", "nobody")
+	admin.expect("<nil>")
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("AUTH", "app", "wrong")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.")
+	c.send("AUTH", "app", "pw")
+	c.expect("OK")
+	c.send("ACL", "WHOAMI")
+	c.expect("app")
+	c.send("SET", "cache:1", "v")
+	c.expect("OK")
+	c.send("GET", "cache:1")
+	c.expect("v")
+	c.send("GET", "other")
+	c.expect("-NOPERM No permissions to access a key")
+	c.send("DEL", "cache:1")
+	c.expect("-NOPERM User app has no permissions to run the 'del' command")
+
+	// Rules apply to the connections already authenticated.
+	admin.send("ACL", "SETUSER", "app", "+del")
+	admin.expect("OK")
+	c.send("DEL", "cache:1")
+	c.expect("1")
+
+	// Deleting a user closes its connections.
+	admin.send("ACL", "DELUSER", "app", "nobody")
+	admin.expect("1")
+	admin.send("ACL", "DELUSER", "default")
+	admin.expect("-ERR The 'default' user cannot be removed")
+	c.send("PING")
+	if _, err := c.parser.ReadReply(); err == nil {
+		t.Fatalf("expected the deleted user's connection to be closed")
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	os.WriteFile(path, []byte("user default on >secret ~* +@all\nuser reader on nopass ~* -@all +get\n"), 0600)
+	cfg := testConfig()
+	cfg.ACLFile = path
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	if resp := sendCommand(t, port, []string{"GET", "k"}); !strings.HasPrefix(resp, "-NOAUTH") {
+		t.Fatalf("expected the default user to need its password, got %q", resp)
+	}
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("AUTH", "reader", "anything")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("<nil>")
+	c.send("SET", "k", "v")
+	c.expect("-NOPERM User reader has no permissions to run the 'set' command")
+
+	admin := dialTest(t, port)
+	defer admin.conn.Close()
+	admin.send("AUTH", "secret")
+	admin.expect("OK")
+	os.WriteFile(path, []byte("user default on >secret ~* +@all\nuser reader on nopass ~* -@all +get +set\n"), 0600)
+	admin.send("ACL", "LOAD")
+	admin.expect("OK")
+	c.send("SET", "k", "v")
+	c.expect("OK")
+}
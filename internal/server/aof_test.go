@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/persistence"
+)
+
+func TestAOFLogsOnlyChanges(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+
+	srv, port := startTestServerWithConfig(t, cfg)
+	time.Sleep(100 * time.Millisecond)
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	sendCommand(t, port, []string{"SADD", "s", "m"})
+	for _, args := range [][]string{
+		{"LPUSH", "k", "x"}, // WRONGTYPE
+		{"DEL", "missing"},
+		{"LPOP", "missing"},
+		{"SADD", "s", "m"},
+		{"SREM", "s", "other"},
+		{"HDEL", "missing", "f"},
+		{"SET", "k"}, // wrong number of arguments
+	} {
+		sendCommand(t, port, args)
+	}
+	srv.Stop()
+
+	data := readAOF(t, cfg.PersistencePath)
+	if n := strings.Count(data, "*"); n != 2 {
+		t.Fatalf("expected only the 2 effective commands in the AOF, got %d: %q", n, data)
+	}
+}
+
+// readAOF returns the contents of every AOF segment in dir, in replay order.
+func readAOF(t *testing.T, dir string) string {
+	t.Helper()
+	files, err := persistence.AOFFiles(dir)
+	if err != nil {
+		t.Fatalf("failed to list AOF files: %v", err)
+	}
+	var sb strings.Builder
+	for _, path := range files {
+		data, _ := os.ReadFile(path)
+		sb.Write(data)
+	}
+	return sb.String()
+}
+
+func TestAOFKeepsTheOrderWritesWereApplied(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+	srv := New(cfg)
+	// Several threads, even on one CPU, for writers to be preempted between
+	// applying a write and logging it.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				srv.applyWrite(nil, "RPUSH", []string{"l", fmt.Sprintf("%d:%d", g, i)})
+			}
+		}()
+	}
+	wg.Wait()
+	want, _ := srv.store.ListRange("l", 0, -1)
+	srv.Stop()
+
+	srv = New(cfg)
+	defer srv.Stop()
+	if got, _ := srv.store.ListRange("l", 0, -1); !slices.Equal(got, want) {
+		t.Fatalf("expected the AOF to replay the list as applied, got %d elements differing from %d", len(got), len(want))
+	}
+}
+
+func TestAOFOrdersFlushWithConcurrentWrites(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+	srv := New(cfg)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				srv.applyWrite(nil, "SET", []string{fmt.Sprintf("k%d:%d", g, i), "v"})
+			}
+		}()
+	}
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				srv.applyWrite(nil, "FLUSHDB", nil)
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+	<-flushed
+	want := srv.store.Keys("*")
+	srv.Stop()
+
+	srv = New(cfg)
+	defer srv.Stop()
+	if got := srv.store.Keys("*"); !slices.Equal(got, want) {
+		t.Fatalf("expected the AOF to replay the %d keys left after the flushes, got %d", len(want), len(got))
+	}
+}
+
+func TestUnreadableAOFManifestRefusesToStart(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+	dir := filepath.Join(cfg.PersistencePath, "appendonlydir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "commands.aof.manifest"), []byte("garbage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := New(cfg)
+	defer srv.Stop()
+	if err := srv.Start(); err == nil || !strings.Contains(err.Error(), "refusing to start") {
+		t.Fatalf("expected the server to refuse to start, got %v", err)
+	}
+}
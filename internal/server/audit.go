@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/acl"
+	"redis-from-scratch/internal/command"
+)
+
+// auditRecord is a line of the audit_log file, written for each write or
+// admin command a client ran: who ran it, from where, when, on which keys
+// and whether it failed. Unlike the AOF, the audit log records commands
+// refused and those that changed nothing too, and who sent them, and it is
+// never rewritten or read back. Arguments other than keys and subcommands
+// are left out, as they may hold passwords and values.
+type auditRecord struct {
+	Time       string   `json:"time"`
+	Client     int64    `json:"client"`
+	Addr       string   `json:"addr"`
+	User       string   `json:"user"`
+	Cmd        string   `json:"cmd"`
+	Subcommand string   `json:"subcommand,omitempty"`
+	Keys       []string `json:"keys,omitempty"`
+	Outcome    string   `json:"outcome"`
+	Err        string   `json:"err,omitempty"`
+}
+
+// subcommands are the audited commands whose first argument is a
+// subcommand, recorded as such.
+var subcommands = map[string]bool{"CONFIG": true, "ACL": true, "LATENCY": true, "SLOWLOG": true}
+
+// audited reports whether the upper-cased command goes to the audit log:
+// those that may write to the dataset and those in @admin.
+func audited(cmd string) bool {
+	return command.IsWrite(cmd) || acl.InCategory(cmd, "write") || acl.InCategory(cmd, "admin")
+}
+
+// audit records a command sess ran, with its arguments after the name, in
+// the audit log if there is one and the command is audited.
+func (s *Server) audit(sess *session, cmd string, args []string, err error) {
+	if s.auditLog == nil || !audited(cmd) {
+		return
+	}
+	rec := &auditRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Client:  sess.id,
+		Addr:    sess.conn.RemoteAddr().String(),
+		User:    sess.user.Name(),
+		Cmd:     strings.ToLower(cmd),
+		Keys:    command.Keys(cmd, args),
+		Outcome: "ok",
+	}
+	if subcommands[cmd] && len(args) > 0 {
+		rec.Subcommand = strings.ToLower(args[0])
+	}
+	if err != nil {
+		rec.Outcome, rec.Err = "error", err.Error()
+	}
+	s.auditLog.write(rec)
+}
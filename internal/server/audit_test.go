@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	cfg := testConfig()
+	cfg.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+	srv, port := startTestServerWithConfig(t, cfg)
+
+	c := dialTest(t, port)
+	c.send("SET", "k", "secret")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("secret")
+	c.send("LPUSH", "k", "v")
+	c.read()
+	c.send("CONFIG", "SET", "maxmemory-clients", "0")
+	c.expect("OK")
+	c.send("PING")
+	c.expect("PONG")
+	srv.Stop()
+
+	data, err := os.ReadFile(cfg.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("expected values to be left out of the audit log, got %q", data)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected SET, LPUSH and CONFIG in the audit log, got %q", data)
+	}
+	var recs []auditRecord
+	for _, line := range lines {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("expected a JSON line, got %q: %v", line, err)
+		}
+		if rec.Time == "" || rec.Addr != c.conn.LocalAddr().String() || rec.User != "default" {
+			t.Fatalf("expected the time, client and user, got %+v", rec)
+		}
+		recs = append(recs, rec)
+	}
+	if recs[0].Cmd != "set" || len(recs[0].Keys) != 1 || recs[0].Keys[0] != "k" || recs[0].Outcome != "ok" {
+		t.Fatalf("unexpected SET record %+v", recs[0])
+	}
+	if recs[1].Cmd != "lpush" || recs[1].Outcome != "error" || !strings.HasPrefix(recs[1].Err, "WRONGTYPE") {
+		t.Fatalf("expected LPUSH to be recorded as failed, got %+v", recs[1])
+	}
+	if recs[2].Cmd != "config" || recs[2].Subcommand != "set" || recs[2].Keys != nil {
+		t.Fatalf("unexpected CONFIG record %+v", recs[2])
+	}
+}
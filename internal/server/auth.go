@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// authExempt are the commands a connection may run before authenticating.
+var authExempt = map[string]bool{
+	"AUTH":  true,
+	"HELLO": true,
+	"QUIT":  true,
+	"RESET": true,
+}
+
+// errNoAuth is the error other commands get before authenticating.
+var errNoAuth = fmt.Errorf("NOAUTH Authentication required.")
+
+// passwords returns requirepass, which clients authenticate with, and
+// masterauth, which this server authenticates to others with.
+func (s *Server) passwords() (requirePass, masterAuth string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	return s.requirePass, s.masterAuth
+}
+
+// AUTH [username] password authenticates the connection as an ACL user,
+// "default" when the username is left out.
+func (s *Server) cmdAuth(sess *session, args []string) command.Response {
+	if len(args) == 0 || len(args) > 2 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'auth' command")}
+	}
+	if len(args) == 1 && s.acl.NoPass() {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")}
+	}
+	name, pass := "default", args[len(args)-1]
+	if len(args) == 2 {
+		name = args[0]
+	}
+	u, err := s.acl.Authenticate(name, pass)
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: err}
+	}
+	sess.mu.Lock()
+	sess.user, sess.authenticated = u, true
+	sess.mu.Unlock()
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// authLink authenticates a link to another server with masterauth, if set.
+func (s *Server) authLink(c *protocol.Client) error {
+	if _, pass := s.passwords(); pass != "" {
+		if _, err := c.Do("AUTH", pass); err != nil {
+			return fmt.Errorf("AUTH failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) getRequirePass() string {
+	pass, _ := s.passwords()
+	return pass
+}
+
+// setRequirePass makes value the default user's only password, or lets it
+// in without one if empty. Connections already authenticated stay so.
+func (s *Server) setRequirePass(value string) error {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	if err := s.acl.SetUser("default", passRules(value)...); err != nil {
+		return err
+	}
+	s.requirePass = value
+	return nil
+}
+
+// passRules returns the ACL rules giving the default user requirepass.
+func passRules(pass string) []string {
+	if pass == "" {
+		return []string{"nopass"}
+	}
+	return []string{"resetpass", ">" + pass}
+}
+
+func (s *Server) getMasterAuth() string {
+	_, pass := s.passwords()
+	return pass
+}
+
+func (s *Server) setMasterAuth(value string) error {
+	s.authMu.Lock()
+	s.masterAuth = value
+	s.authMu.Unlock()
+	return nil
+}
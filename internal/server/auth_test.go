@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequirePass = "secret"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("GET", "k")
+	c.expect("-NOAUTH Authentication required.")
+	c.send("AUTH", "wrong")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.")
+	c.send("AUTH", "someone", "secret")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.")
+	c.send("AUTH", "default", "secret")
+	c.expect("OK")
+	c.send("SET", "k", "v")
+	c.expect("OK")
+
+	// A new password only applies to new authentications.
+	c.send("CONFIG", "SET", "requirepass", "other")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("v")
+	if resp := sendCommand(t, port, []string{"GET", "k"}); !strings.HasPrefix(resp, "-NOAUTH") {
+		t.Fatalf("expected a new connection to need AUTH, got %q", resp)
+	}
+
+	c.send("CONFIG", "SET", "requirepass", "")
+	c.expect("OK")
+	if resp := sendCommand(t, port, []string{"AUTH", "secret"}); !strings.HasPrefix(resp, "-ERR AUTH <password> called without any password configured") {
+		t.Fatalf("expected AUTH without a password to fail, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"GET", "k"}); resp != "$1\r\nv\r\n" {
+		t.Fatalf("expected no AUTH to be needed, got %q", resp)
+	}
+}
+
+func TestAuthReplication(t *testing.T) {
+	mcfg := replTestConfig(t)
+	mcfg.RequirePass = "secret"
+	master, mport := startTestServerWithConfig(t, mcfg)
+	defer master.Stop()
+	rcfg := replTestConfig(t)
+	rcfg.MasterAuth = "secret"
+	replica, rport := startTestServerWithConfig(t, rcfg)
+	defer replica.Stop()
+
+	c := dialTest(t, mport)
+	defer c.conn.Close()
+	c.send("AUTH", "secret")
+	c.expect("OK")
+	c.send("SET", "k", "v")
+	c.expect("OK")
+	if resp := sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("REPLICAOF failed: %s", resp)
+	}
+	waitFor(t, "the replica to authenticate and sync", func() bool {
+		return sendCommand(t, rport, []string{"GET", "k"}) == "$1\r\nv\r\n"
+	})
+}
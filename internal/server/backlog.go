@@ -0,0 +1,40 @@
+package server
+
+// backlog is a circular buffer holding the end of the replication stream,
+// so that a replica that lost its connection can continue from the offset
+// it reached instead of syncing the whole dataset again.
+type backlog struct {
+	buf  []byte
+	next int   // where the next byte goes
+	held int   // bytes held, up to len(buf)
+	end  int64 // replication offset of the last byte written
+}
+
+func newBacklog(size int64, offset int64) *backlog {
+	return &backlog{buf: make([]byte, size), end: offset}
+}
+
+func (b *backlog) write(p []byte) {
+	b.end += int64(len(p))
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+	n := copy(b.buf[b.next:], p)
+	copy(b.buf, p[n:])
+	b.next = (b.next + len(p)) % len(b.buf)
+	b.held = min(b.held+len(p), len(b.buf))
+}
+
+// since returns the stream written after offset, or false if that part of
+// the stream is no longer held.
+func (b *backlog) since(offset int64) ([]byte, bool) {
+	if offset > b.end || offset < b.end-int64(b.held) {
+		return nil, false
+	}
+	n := int(b.end - offset)
+	out := make([]byte, n)
+	start := (b.next - n + len(b.buf)) % len(b.buf)
+	k := copy(out, b.buf[start:min(start+n, len(b.buf))])
+	copy(out[k:], b.buf[:n-k])
+	return out, true
+}
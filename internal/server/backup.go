@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/persistence"
+)
+
+// backupPath resolves the target of BACKUP. Relative paths are taken from
+// persistence_path, and a directory, existing or given with a trailing
+// slash, gets a file named after the current time.
+func (s *Server) backupPath(target string, now time.Time) string {
+	dir := strings.HasSuffix(target, "/") || strings.HasSuffix(target, string(filepath.Separator))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(s.cfg.PersistencePath, target)
+	}
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		dir = true
+	}
+	if dir {
+		return filepath.Join(target, "backup-"+now.UTC().Format("20060102-150405")+".snapshot")
+	}
+	return target
+}
+
+// BACKUP path writes a snapshot of the dataset to path and replies with the
+// file written once it is durable. Like BGSAVE it works from the snapshot
+// iterator, so other clients keep reading and writing meanwhile; unlike it,
+// it leaves the configured snapshot, LASTSAVE and the save rules alone.
+func (s *Server) cmdBackup(args []string) command.Response {
+	if len(args) != 1 || args[0] == "" {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'backup' command")}
+	}
+	path := s.backupPath(args[0], time.Now())
+	start := time.Now()
+	n, err := persistence.WriteSnapshot(path, s.store, s.compression, s.encryption)
+	if err != nil {
+		logging.Errorf("Backup failed: %v", err)
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+	}
+	logging.Infof("Backed up %d keys to %s in %v", n, path, time.Since(start))
+	return command.Response{Type: command.TypeBulkString, Value: path}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/store"
+)
+
+func TestBackup(t *testing.T) {
+	cfg := testConfig()
+	cfg.PersistencePath = t.TempDir()
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	sendCommand(t, port, []string{"RPUSH", "l", "a", "b"})
+
+	file := filepath.Join(t.TempDir(), "nightly.snapshot")
+	if resp := sendCommand(t, port, []string{"BACKUP", file}); !strings.Contains(resp, file) {
+		t.Fatalf("BACKUP failed: %s", resp)
+	}
+	got := store.New()
+	if err := persistence.LoadSnapshot(file, got, nil); err != nil || got.Size() != 2 {
+		t.Fatalf("expected 2 keys in the backup, got %d (%v)", got.Size(), err)
+	}
+
+	// A directory gets a timestamped file; relative paths are under
+	// persistence_path.
+	resp := sendCommand(t, port, []string{"BACKUP", "backups/"})
+	entries, _ := os.ReadDir(filepath.Join(cfg.PersistencePath, "backups"))
+	if len(entries) != 1 || !strings.Contains(resp, entries[0].Name()) {
+		t.Fatalf("expected one backup in the directory, got %v (%s)", entries, resp)
+	}
+	if _, err := os.Stat(srv.snapshotPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected BACKUP to leave the configured snapshot alone")
+	}
+}
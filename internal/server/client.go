@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+)
+
+// clientArity gives the number of arguments of the CLIENT subcommands
+// without options.
+var clientArity = map[string]int{"ID": 0, "INFO": 0, "GETNAME": 0, "SETNAME": 1}
+
+// CLIENT subcommand [arg ...] inspects or changes the connection, or the
+// others.
+func (s *Server) cmdClient(sess *session, args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'client' command")}
+	}
+	sub, args := strings.ToUpper(args[0]), args[1:]
+	switch sub {
+	case "TRACKING":
+		return s.cmdClientTracking(sess, args)
+	case "GETREDIR":
+		return s.cmdClientGetRedir(sess, args)
+	case "LIST":
+		return s.cmdClientList(args)
+	case "KILL":
+		return s.cmdClientKill(sess, args)
+	}
+
+	n, ok := clientArity[sub]
+	if !ok {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try CLIENT HELP.", sub)}
+	}
+	if len(args) != n {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'client|%s' command", strings.ToLower(sub))}
+	}
+	switch sub {
+	case "ID":
+		return command.Response{Type: command.TypeInteger, Value: int(sess.id)}
+	case "INFO":
+		return command.Response{Type: command.TypeBulkString, Value: sess.info(time.Now()) + "\n"}
+	case "GETNAME":
+		if sess.name == "" {
+			return command.Response{Type: command.TypeNull}
+		}
+		return command.Response{Type: command.TypeBulkString, Value: sess.name}
+	default: // SETNAME
+		if !validClientName(args[0]) {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Client names cannot contain spaces, newlines or special characters.")}
+		}
+		sess.setName(args[0])
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	}
+}
+
+// setName names the connection; an empty name removes its name.
+func (sess *session) setName(name string) {
+	sess.mu.Lock()
+	sess.name = name
+	sess.mu.Unlock()
+}
+
+// info describes the connection as a line of CLIENT LIST, with the fields
+// of Redis' that apply.
+func (sess *session) info(now time.Time) string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	resp := 2
+	if sess.resp3.Load() {
+		resp = 3
+	}
+	mem, total := sess.mem.get()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d argv-mem=%d omem=%d tot-mem=%d cmd=%s user=%s resp=%d",
+		sess.id, sess.conn.RemoteAddr(), sess.conn.LocalAddr(), sess.name,
+		int64(now.Sub(sess.created).Seconds()), int64(now.Sub(sess.lastUsed).Seconds()),
+		sess.flags(), sess.db, len(sess.subscriptions), len(sess.patterns),
+		mem[memArgs], mem[memOutput]+mem[memPush], total,
+		strings.ToLower(sess.lastCmd), sess.user.Name(), resp)
+}
+
+// clientType is the connection's type for CLIENT LIST and KILL's TYPE
+// filter: "replica" once it ran SYNC or PSYNC, which it keeps serving,
+// "pubsub" while subscribed, or "normal". It needs sess.mu.
+func (sess *session) clientType() string {
+	switch {
+	case sess.lastCmd == "SYNC" || sess.lastCmd == "PSYNC":
+		return "replica"
+	case len(sess.subscriptions)+len(sess.patterns) > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// flags are CLIENT LIST's: S for a replica, P for a subscriber, N for
+// neither. It needs sess.mu.
+func (sess *session) flags() string {
+	switch sess.clientType() {
+	case "replica":
+		return "S"
+	case "pubsub":
+		return "P"
+	}
+	return "N"
+}
+
+// sessions returns the open connections, in the order they were accepted.
+func (s *Server) sessions() []*session {
+	s.clientsMu.Lock()
+	list := make([]*session, 0, len(s.clients))
+	for _, sess := range s.clients {
+		list = append(list, sess)
+	}
+	s.clientsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// CLIENT LIST [TYPE normal|replica|pubsub] [ID id [id ...]] describes the
+// open connections, one line each.
+func (s *Server) cmdClientList(args []string) command.Response {
+	var typ string
+	var ids map[int64]bool
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "TYPE" && i+1 < len(args):
+			typ = strings.ToLower(args[i+1])
+			if typ == "slave" {
+				typ = "replica"
+			}
+			if typ != "normal" && typ != "replica" && typ != "pubsub" && typ != "master" {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Unknown client type '%s'", args[i+1])}
+			}
+			i++
+		case opt == "ID" && i+1 < len(args):
+			ids = make(map[int64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || id <= 0 {
+					return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid client ID")}
+				}
+				ids[id] = true
+			}
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+	}
+	var b strings.Builder
+	now := time.Now()
+	for _, sess := range s.sessions() {
+		sess.mu.Lock()
+		ct := sess.clientType()
+		sess.mu.Unlock()
+		if typ != "" && ct != typ || ids != nil && !ids[sess.id] {
+			continue
+		}
+		b.WriteString(sess.info(now))
+		b.WriteByte('\n')
+	}
+	return command.Response{Type: command.TypeBulkString, Value: b.String()}
+}
+
+// CLIENT KILL addr:port | CLIENT KILL [ID id] [ADDR addr:port] [LADDR
+// addr:port] [TYPE type] [USER username] [SKIPME yes|no] closes the
+// connections matching: the one from addr, replying OK, or those matching
+// every filter, replying with their number. SKIPME, on by default, spares
+// the calling connection.
+func (s *Server) cmdClientKill(sess *session, args []string) command.Response {
+	if len(args) == 1 {
+		for _, c := range s.sessions() {
+			if c.conn.RemoteAddr().String() == args[0] {
+				c.conn.Close()
+				return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+			}
+		}
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR No such client")}
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+	}
+	var filters []func(c *session) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR client-id should be greater than 0")}
+			}
+			filters = append(filters, func(c *session) bool { return c.id == id })
+		case "ADDR":
+			filters = append(filters, func(c *session) bool { return c.conn.RemoteAddr().String() == value })
+		case "LADDR":
+			filters = append(filters, func(c *session) bool { return c.conn.LocalAddr().String() == value })
+		case "TYPE":
+			typ := strings.ToLower(value)
+			if typ == "slave" {
+				typ = "replica"
+			}
+			filters = append(filters, func(c *session) bool {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.clientType() == typ
+			})
+		case "USER":
+			filters = append(filters, func(c *session) bool {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.user.Name() == value
+			})
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+			}
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+	}
+	killed := 0
+next:
+	for _, c := range s.sessions() {
+		if skipMe && c == sess {
+			continue
+		}
+		for _, match := range filters {
+			if !match(c) {
+				continue next
+			}
+		}
+		c.conn.Close()
+		killed++
+	}
+	return command.Response{Type: command.TypeInteger, Value: killed}
+}
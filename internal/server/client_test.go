@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientCommands(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	a := dialTest(t, port)
+	defer a.conn.Close()
+	a.send("CLIENT", "ID")
+	a.expect("1")
+	a.send("CLIENT", "GETNAME")
+	a.expect("<nil>")
+	a.send("CLIENT", "SETNAME", "bad name")
+	a.expect("-ERR Client names cannot contain spaces, newlines or special characters.")
+	a.send("CLIENT", "SETNAME", "worker")
+	a.expect("OK")
+	a.send("CLIENT", "GETNAME")
+	a.expect("worker")
+
+	b := dialTest(t, port)
+	defer b.conn.Close()
+	b.send("SUBSCRIBE", "news")
+	b.expect("subscribe", "news", "1")
+
+	a.send("CLIENT", "INFO")
+	info := a.read()[0]
+	for _, field := range []string{"id=1 ", "addr=" + a.conn.LocalAddr().String(), " name=worker ", " flags=N ", " cmd=client ", " user=default "} {
+		if !strings.Contains(info, field) {
+			t.Fatalf("expected %q in CLIENT INFO, got %q", field, info)
+		}
+	}
+	a.send("CLIENT", "LIST")
+	lines := strings.Split(strings.TrimSuffix(a.read()[0], "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "id=1 ") || !strings.Contains(lines[1], " flags=P db=0 sub=1 psub=0 ") {
+		t.Fatalf("unexpected CLIENT LIST: %q", lines)
+	}
+	a.send("CLIENT", "LIST", "TYPE", "pubsub")
+	if got := a.read()[0]; !strings.HasPrefix(got, "id=2 ") || strings.Count(got, "\n") != 1 {
+		t.Fatalf("expected only the subscriber, got %q", got)
+	}
+
+	a.send("CLIENT", "KILL", "127.0.0.1:1")
+	a.expect("-ERR No such client")
+	a.send("CLIENT", "KILL", "TYPE", "normal")
+	a.expect("0")
+	a.send("CLIENT", "KILL", "ID", "2")
+	a.expect("1")
+	if _, err := b.parser.ReadReply(); err == nil {
+		t.Fatalf("expected the killed connection to be closed")
+	}
+	waitFor(t, "the killed connection to be forgotten", func() bool {
+		a.send("CLIENT", "LIST", "ID", "2")
+		return a.read()[0] == ""
+	})
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/pkg/config"
+)
+
+// Parts of a connection's memory, as CLIENT LIST reports them.
+const (
+	memArgs   = iota // the arguments of its latest command
+	memOutput        // the reply being written, not yet read by the client
+	memPush          // the messages pushed to it waiting to be written
+	memParts
+)
+
+// connOverhead is what any connection holds: its parser's and its
+// writer's buffers.
+const connOverhead = 2 * 4096
+
+// clientsMemory is the memory held by every connection together, which
+// maxmemory-clients bounds: once it goes over limit, the connections
+// holding the most are closed until it is back under, as Redis' client
+// eviction does. Replicas are left alone, as they are in Redis.
+type clientsMemory struct {
+	total   atomic.Int64
+	limit   atomic.Int64
+	evicted atomic.Int64
+	// evicting serializes evict, which other connections need not wait
+	// for.
+	evicting sync.Mutex
+}
+
+// clientMemory is the memory a connection holds, counted in its server's
+// clientsMemory while it is open.
+type clientMemory struct {
+	mu     sync.Mutex
+	all    *clientsMemory
+	parts  [memParts]int64
+	closed bool
+}
+
+// open counts a new connection in all.
+func (m *clientMemory) open(all *clientsMemory) {
+	m.all = all
+	all.total.Add(connOverhead)
+}
+
+// set records that the connection holds n bytes for part.
+func (m *clientMemory) set(part int, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.all.total.Add(n - m.parts[part])
+	m.parts[part] = n
+}
+
+// close stops counting the connection, which is gone.
+func (m *clientMemory) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	used := int64(connOverhead)
+	for _, n := range m.parts {
+		used += n
+	}
+	m.all.total.Add(-used)
+}
+
+// get returns what the connection holds for each part, and in all.
+func (m *clientMemory) get() (parts [memParts]int64, total int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total = connOverhead
+	for _, n := range m.parts {
+		total += n
+	}
+	return m.parts, total
+}
+
+// usedArgs records the memory of the arguments of the command just read.
+func (sess *session) usedArgs(args []string) {
+	var n int64
+	for _, arg := range args {
+		n += int64(len(arg))
+	}
+	sess.mem.set(memArgs, n)
+}
+
+// evictClients closes the connections holding the most memory while
+// connections together hold more than maxmemory-clients.
+func (s *Server) evictClients() {
+	limit := s.clientsMem.limit.Load()
+	if limit <= 0 || s.clientsMem.total.Load() <= limit {
+		return
+	}
+	if !s.clientsMem.evicting.TryLock() {
+		return
+	}
+	defer s.clientsMem.evicting.Unlock()
+
+	type candidate struct {
+		sess *session
+		used int64
+	}
+	var candidates []candidate
+	for _, sess := range s.sessions() {
+		if sess.outputClass() == "replica" {
+			continue
+		}
+		_, used := sess.mem.get()
+		candidates = append(candidates, candidate{sess, used})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].used > candidates[j].used })
+	for _, c := range candidates {
+		if s.clientsMem.total.Load() <= limit {
+			break
+		}
+		logging.Warnf("Evicting client id=%d holding %d bytes, over maxmemory-clients", c.sess.id, c.used)
+		c.sess.conn.Close()
+		// Its goroutine may take a while to notice: it holds nothing
+		// from now on.
+		c.sess.mem.close()
+		s.clientsMem.evicted.Add(1)
+	}
+}
+
+func (s *Server) getMaxMemoryClients() string {
+	return fmt.Sprint(s.clientsMem.limit.Load())
+}
+
+func (s *Server) setMaxMemoryClients(value string) error {
+	n, err := config.ParseSize(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("argument must be a memory value")
+	}
+	s.clientsMem.limit.Store(n)
+	s.evictClients()
+	return nil
+}
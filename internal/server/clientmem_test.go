@@ -0,0 +1,54 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestClientMemory(t *testing.T) {
+	srv, port := startTestServerWithConfig(t, testConfig())
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("SET", "k", strings.Repeat("v", 1000))
+	c.expect("OK")
+	c.send("CLIENT", "INFO")
+	info := c.read()[0]
+	if !strings.Contains(info, " argv-mem=10 ") || !strings.Contains(info, " omem=0 tot-mem=8202 ") {
+		t.Fatalf("expected the arguments of CLIENT INFO and the buffers to be counted, got %q", info)
+	}
+	if got := srv.clientsMem.total.Load(); got != 8202 {
+		t.Fatalf("expected the connection's memory in the total, got %d", got)
+	}
+	c.conn.Close()
+	waitFor(t, "the connection's memory to be given back", func() bool {
+		return srv.clientsMem.total.Load() == 0
+	})
+}
+
+func TestMaxMemoryClients(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxMemoryClients = 64 * 1024
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	small := dialTest(t, port)
+	small.send("PING")
+	small.expect("PONG")
+
+	hog := dialTest(t, port)
+	hog.send("SET", "k", strings.Repeat("v", 100*1024))
+	if _, err := io.ReadAll(hog.conn); err != nil {
+		t.Fatalf("expected the client over maxmemory-clients to be closed, got %v", err)
+	}
+
+	small.send("INFO", "stats")
+	if info := small.read()[0]; !strings.Contains(info, "evicted_clients:1\r\n") {
+		t.Fatalf("expected an evicted client, got %q", info)
+	}
+	small.send("CONFIG", "SET", "maxmemory-clients", "1mb")
+	small.expect("OK")
+	small.send("CONFIG", "GET", "maxmemory-clients")
+	small.expect("maxmemory-clients", "1048576")
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/cluster"
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/store"
+	"redis-from-scratch/pkg/config"
+)
+
+// openCluster loads the cluster state from <persistence_path>/<cluster
+// config file>, creating it for a new node.
+func openCluster(cfg *config.Config) (*cluster.Cluster, error) {
+	if err := os.MkdirAll(cfg.PersistencePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	path := filepath.Join(cfg.PersistencePath, cmp.Or(cfg.ClusterConfigFile, "nodes.conf"))
+	// With tls_cluster nodes reach each other, and clients are
+	// redirected, on the TLS port, as in Redis.
+	port := cfg.Port
+	if cfg.TLSCluster {
+		port = cfg.TLSPort
+	}
+	c, err := cluster.Open(path, cfg.ClusterAnnounceIP, port)
+	if err != nil {
+		return nil, err
+	}
+	c.SetNodeTimeout(cmp.Or(cfg.ClusterNodeTimeout, cluster.DefaultNodeTimeout))
+	return c, nil
+}
+
+// CLUSTER <subcommand> reports the cluster topology for cluster-aware
+// clients, with Redis' reply formats, assigns slots to this node and moves
+// them between nodes:
+//
+//	INFO | MYID | NODES | SLOTS | SHARDS
+//	KEYSLOT key | COUNTKEYSINSLOT slot | GETKEYSINSLOT slot count
+//	ADDSLOTS slot [slot ...] | ADDSLOTSRANGE start end [start end ...]
+//	DELSLOTS slot [slot ...] | DELSLOTSRANGE start end [start end ...]
+//	MEET host port
+//	SETSLOT slot IMPORTING node-id | MIGRATING node-id | NODE node-id | STABLE
+func (s *Server) cmdCluster(args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'cluster' command")}
+	}
+	if s.cluster == nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR This instance has cluster support disabled")}
+	}
+	sub, args := strings.ToUpper(args[0]), args[1:]
+	arity := map[string]int{"INFO": 0, "MYID": 0, "NODES": 0, "SLOTS": 0, "SHARDS": 0, "KEYSLOT": 1, "COUNTKEYSINSLOT": 1, "GETKEYSINSLOT": 2, "MEET": 2}
+	if n, ok := arity[sub]; ok && len(args) != n {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'cluster|%s' command", strings.ToLower(sub))}
+	}
+	switch sub {
+	case "INFO":
+		return command.Response{Type: command.TypeBulkString, Value: s.clusterInfo()}
+	case "MYID":
+		return command.Response{Type: command.TypeBulkString, Value: s.cluster.MyID()}
+	case "NODES":
+		var b strings.Builder
+		s.cluster.WriteNodes(&b)
+		return command.Response{Type: command.TypeBulkString, Value: b.String()}
+	case "SLOTS":
+		return command.Response{Type: command.TypeValue, Value: s.clusterSlots()}
+	case "SHARDS":
+		return command.Response{Type: command.TypeValue, Value: s.clusterShards()}
+	case "KEYSLOT":
+		return command.Response{Type: command.TypeInteger, Value: cluster.KeySlot(args[0])}
+	case "COUNTKEYSINSLOT":
+		slot, err := cluster.ParseSlot(args[0])
+		if err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid slot")}
+		}
+		return command.Response{Type: command.TypeInteger, Value: s.countKeysInSlot(slot)}
+	case "GETKEYSINSLOT":
+		slot, err := cluster.ParseSlot(args[0])
+		if err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid slot")}
+		}
+		count, err := strconv.Atoi(args[1])
+		if err != nil || count < 0 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid number of keys")}
+		}
+		return command.Response{Type: command.TypeArray, Value: s.keysInSlot(slot, count)}
+	case "MEET":
+		return s.clusterMeet(args[0], args[1])
+	case "SETSLOT":
+		return s.clusterSetSlot(args)
+	case "ADDSLOTS", "ADDSLOTSRANGE", "DELSLOTS", "DELSLOTSRANGE":
+		slots, err := parseSlots(sub, args)
+		if err == nil && strings.HasPrefix(sub, "ADD") {
+			err = s.cluster.AddSlots(slots)
+		} else if err == nil {
+			err = s.cluster.DelSlots(slots)
+		}
+		if err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+		}
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try CLUSTER HELP.", sub)}
+	}
+}
+
+// parseSlots reads the slots given to ADDSLOTS and DELSLOTS, or the start
+// and end pairs given to their RANGE forms.
+func parseSlots(sub string, args []string) ([]int, error) {
+	byRange := strings.HasSuffix(sub, "RANGE")
+	if len(args) == 0 || byRange && len(args)%2 != 0 {
+		return nil, fmt.Errorf("wrong number of arguments for 'cluster|%s' command", strings.ToLower(sub))
+	}
+	nums := make([]int, len(args))
+	for i, arg := range args {
+		slot, err := cluster.ParseSlot(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid or out of range slot")
+		}
+		nums[i] = slot
+	}
+	if !byRange {
+		return noRepeats(nums)
+	}
+	var slots []int
+	for i := 0; i < len(nums); i += 2 {
+		if nums[i] > nums[i+1] {
+			return nil, fmt.Errorf("start slot number %d is greater than end slot number %d", nums[i], nums[i+1])
+		}
+		for slot := nums[i]; slot <= nums[i+1]; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return noRepeats(slots)
+}
+
+// noRepeats returns slots, or an error if one is given twice.
+func noRepeats(slots []int) ([]int, error) {
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if seen[slot] {
+			return nil, fmt.Errorf("Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+	}
+	return slots, nil
+}
+
+// clusterMeet adds the node at host:port to the cluster: it is sent a meet,
+// which makes it add this node, and its pong introduces it here. Gossip then
+// introduces both to the rest of the cluster.
+func (s *Server) clusterMeet(host, port string) command.Response {
+	portNum, err := strconv.Atoi(port)
+	if err != nil || portNum <= 0 || portNum > 65535 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid node address specified: %s:%s", host, port)}
+	}
+	if _, err := s.clusterSend(net.JoinHostPort(host, port), "MEET", clusterMeetTimeout); err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// clusterMeetTimeout bounds the meet CLUSTER MEET sends.
+const clusterMeetTimeout = 5 * time.Second
+
+// clusterSetSlot handles CLUSTER SETSLOT, the steps of moving a slot between
+// nodes: the target is told it is IMPORTING the slot from the source and
+// the source that it is MIGRATING it to the target, the keys are moved with
+// MIGRATE, then both are told the target is its NODE.
+func (s *Server) clusterSetSlot(args []string) command.Response {
+	if len(args) < 2 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'cluster|setslot' command")}
+	}
+	slot, err := cluster.ParseSlot(args[0])
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid or out of range slot")}
+	}
+	action := strings.ToUpper(args[1])
+	if action == "STABLE" && len(args) != 2 || action != "STABLE" && len(args) != 3 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+	}
+	switch action {
+	case "IMPORTING":
+		err = s.cluster.SetSlotImporting(slot, args[2])
+	case "MIGRATING":
+		err = s.cluster.SetSlotMigrating(slot, args[2])
+	case "STABLE":
+		err = s.cluster.SetSlotStable(slot)
+	case "NODE":
+		owner, _ := s.cluster.Owner(slot)
+		if owner.Myself && args[2] != owner.ID && s.countKeysInSlot(slot) > 0 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)}
+		}
+		err = s.cluster.SetSlotNode(slot, args[2])
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")}
+	}
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// ASKING lets the next command of the connection use a slot this node is
+// importing, as clients do after an ASK redirection.
+func (s *Server) cmdAsking(args []string) (command.Response, bool) {
+	if len(args) != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'asking' command")}, false
+	}
+	if s.cluster == nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR This instance has cluster support disabled")}, false
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}, true
+}
+
+// route decides, in cluster mode, whether this node serves a client command,
+// returning the redirection Redis Cluster replies with if not:
+//
+//   - CROSSSLOT if its keys are in different slots;
+//   - CLUSTERDOWN if no node serves the slot;
+//   - MOVED to the node that serves the slot, unless this node is importing
+//     it and the client sent ASKING;
+//   - ASK to the target if this node is migrating the slot and the keys are
+//     gone from here, or will be created;
+//   - TRYAGAIN if only some of the keys of a multi-key command are here
+//     while the slot moves.
+func (s *Server) route(cmd string, args []string, asking bool) error {
+	if s.cluster == nil {
+		return nil
+	}
+	keys := command.Keys(cmd, args)
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := cluster.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.KeySlot(key) != slot {
+			return fmt.Errorf("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	owner, ok := s.cluster.Owner(slot)
+	if !ok {
+		return fmt.Errorf("CLUSTERDOWN Hash slot not served")
+	}
+	if !owner.Myself {
+		if _, importing := s.cluster.Importing(slot); !importing || !asking {
+			return fmt.Errorf("MOVED %d %s", slot, owner.Addr())
+		}
+		if len(keys) > 1 && s.store.Exists(keys...) < len(keys) {
+			return fmt.Errorf("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return nil
+	}
+	if target, migrating := s.cluster.Migrating(slot); migrating {
+		switch n := s.store.Exists(keys...); {
+		case n == 0:
+			return fmt.Errorf("ASK %d %s", slot, target.Addr())
+		case n < len(keys):
+			return fmt.Errorf("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+	}
+	return nil
+}
+
+// keysInSlot lists up to count keys in slot, through the engine's index if
+// it keeps one.
+func (s *Server) keysInSlot(slot, count int) []string {
+	if x, ok := s.store.(store.SlotIndexer); ok {
+		return x.KeysInSlot(slot, count)
+	}
+	keys := []string{}
+	s.store.ForEach(func(e store.Entry) bool {
+		if len(keys) == count {
+			return false
+		}
+		if cluster.KeySlot(e.Key) == slot {
+			keys = append(keys, e.Key)
+		}
+		return true
+	})
+	return keys
+}
+
+// countKeysInSlot counts the keys in slot, through the engine's index if it
+// keeps one.
+func (s *Server) countKeysInSlot(slot int) int {
+	if c, ok := s.store.(store.SlotIndexer); ok {
+		return c.CountKeysInSlot(slot)
+	}
+	n := 0
+	s.store.ForEach(func(e store.Entry) bool {
+		if cluster.KeySlot(e.Key) == slot {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// clusterInfo is the CLUSTER INFO text, with Redis' field names.
+func (s *Server) clusterInfo() string {
+	info := s.cluster.Info()
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", info.State)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", info.SlotsAssigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", info.SlotsAssigned-info.SlotsPFail-info.SlotsFail)
+	fmt.Fprintf(&b, "cluster_slots_pfail:%d\r\n", info.SlotsPFail)
+	fmt.Fprintf(&b, "cluster_slots_fail:%d\r\n", info.SlotsFail)
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", info.KnownNodes)
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", info.Size)
+	fmt.Fprintf(&b, "cluster_current_epoch:%d\r\n", info.CurrentEpoch)
+	fmt.Fprintf(&b, "cluster_my_epoch:%d\r\n", info.MyEpoch)
+	return b.String()
+}
+
+// endpoint is how CLUSTER SLOTS names a node: its host, or null when it is
+// not known, which tells clients to use the address they sent the command
+// to.
+func endpoint(n cluster.Node) any {
+	if n.Host == "" {
+		return nil
+	}
+	return n.Host
+}
+
+// clusterSlots is the CLUSTER SLOTS reply: for each range of slots served
+// by one node, ordered by slot, the first and last slot and the node's
+// endpoint, port, ID and (empty) metadata.
+func (s *Server) clusterSlots() []any {
+	type served struct {
+		r    cluster.Range
+		node cluster.Node
+	}
+	var all []served
+	for _, n := range s.cluster.Nodes() {
+		for _, r := range n.Slots {
+			all = append(all, served{r, n})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].r.Start < all[j].r.Start })
+	out := make([]any, 0, len(all))
+	for _, e := range all {
+		node := []any{endpoint(e.node), e.node.Port, e.node.ID, []any{}}
+		out = append(out, []any{e.r.Start, e.r.End, node})
+	}
+	return out
+}
+
+// clusterShards is the CLUSTER SHARDS reply: one shard per master, with its
+// slot ranges as start and end pairs and its nodes' details, as RESP2 maps
+// of alternating keys and values.
+func (s *Server) clusterShards() []any {
+	s.repl.mu.Lock()
+	offset := s.repl.offset
+	s.repl.mu.Unlock()
+
+	out := []any{}
+	for _, n := range s.cluster.Nodes() {
+		slots := []any{}
+		for _, r := range n.Slots {
+			slots = append(slots, r.Start, r.End)
+		}
+		var nodeOffset int64
+		if n.Myself {
+			nodeOffset = offset
+		}
+		node := []any{
+			"id", n.ID,
+			"port", n.Port,
+			"ip", n.Host,
+			"endpoint", n.Host,
+			"role", "master",
+			"replication-offset", nodeOffset,
+			"health", "online",
+		}
+		out = append(out, []any{"slots", slots, "nodes", []any{node}})
+	}
+	return out
+}
+
+// infoCluster is the cluster section of INFO.
+func (s *Server) infoCluster() string {
+	return "cluster_enabled:" + strconv.Itoa(boolInt(s.cluster != nil)) + "\r\n"
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/store"
+)
+
+func TestClusterCommands(t *testing.T) {
+	cfg := testConfig()
+	cfg.Port = 7000
+	cfg.PersistencePath = t.TempDir()
+	cfg.ClusterEnabled = true
+	cfg.ClusterAnnounceIP = "127.0.0.1"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	id := srv.cluster.MyID()
+	if resp := sendCommand(t, port, []string{"CLUSTER", "MYID"}); resp != "$40\r\n"+id+"\r\n" {
+		t.Fatalf("unexpected CLUSTER MYID reply: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CLUSTER", "INFO"}); !strings.Contains(resp, "cluster_state:fail\r\n") || !strings.Contains(resp, "cluster_slots_assigned:0\r\n") {
+		t.Fatalf("expected a node without slots to be failing, got %q", resp)
+	}
+
+	if resp := sendCommand(t, port, []string{"CLUSTER", "ADDSLOTSRANGE", "0", "8191", "8193", "16383"}); resp != "+OK\r\n" {
+		t.Fatalf("CLUSTER ADDSLOTSRANGE failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CLUSTER", "ADDSLOTS", "8192", "100"}); resp != "-ERR Slot 100 is already busy\r\n" {
+		t.Fatalf("expected a busy slot error, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CLUSTER", "ADDSLOTS", "8192", "8192"}); resp != "-ERR Slot 8192 specified multiple times\r\n" {
+		t.Fatalf("expected a repeated slot error, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CLUSTER", "ADDSLOTS", "16384"}); resp != "-ERR Invalid or out of range slot\r\n" {
+		t.Fatalf("expected an out of range error, got %q", resp)
+	}
+
+	wantSlots := "*2\r\n" +
+		"*3\r\n:0\r\n:8191\r\n*4\r\n$9\r\n127.0.0.1\r\n:7000\r\n$40\r\n" + id + "\r\n*0\r\n" +
+		"*3\r\n:8193\r\n:16383\r\n*4\r\n$9\r\n127.0.0.1\r\n:7000\r\n$40\r\n" + id + "\r\n*0\r\n"
+	if resp := sendCommand(t, port, []string{"CLUSTER", "SLOTS"}); resp != wantSlots {
+		t.Fatalf("unexpected CLUSTER SLOTS reply:\n got: %q\nwant: %q", resp, wantSlots)
+	}
+	wantNodes := id + " 127.0.0.1:7000@17000 myself,master - 0 0 0 connected 0-8191 8193-16383\n"
+	if resp := sendCommand(t, port, []string{"CLUSTER", "NODES"}); !strings.Contains(resp, wantNodes) {
+		t.Fatalf("unexpected CLUSTER NODES reply: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CLUSTER", "SHARDS"}); !strings.Contains(resp, "$5\r\nslots\r\n*4\r\n:0\r\n:8191\r\n:8193\r\n:16383\r\n$5\r\nnodes\r\n") {
+		t.Fatalf("unexpected CLUSTER SHARDS reply: %q", resp)
+	}
+
+	sendCommand(t, port, []string{"CLUSTER", "ADDSLOTS", "8192"})
+	if resp := sendCommand(t, port, []string{"CLUSTER", "INFO"}); !strings.Contains(resp, "cluster_state:ok\r\n") || !strings.Contains(resp, "cluster_size:1\r\n") {
+		t.Fatalf("expected the cluster to be ok once every slot is served, got %q", resp)
+	}
+
+	if resp := sendCommand(t, port, []string{"CLUSTER", "KEYSLOT", "{user1000}.following"}); resp != ":3443\r\n" {
+		t.Fatalf("unexpected CLUSTER KEYSLOT reply: %q", resp)
+	}
+	sendCommand(t, port, []string{"SET", "{user1000}.following", "a"})
+	sendCommand(t, port, []string{"SADD", "{user1000}.followers", "b"})
+	sendCommand(t, port, []string{"SET", "other", "c"})
+	if resp := sendCommand(t, port, []string{"CLUSTER", "COUNTKEYSINSLOT", "3443"}); resp != ":2\r\n" {
+		t.Fatalf("unexpected CLUSTER COUNTKEYSINSLOT reply: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CLUSTER", "COUNTKEYSINSLOT", "-1"}); resp != "-ERR Invalid slot\r\n" {
+		t.Fatalf("expected an invalid slot error, got %q", resp)
+	}
+
+	if resp := sendCommand(t, port, []string{"CLUSTER", "DELSLOTSRANGE", "0", "8191"}); resp != "+OK\r\n" {
+		t.Fatalf("CLUSTER DELSLOTSRANGE failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CLUSTER", "DELSLOTS", "0"}); resp != "-ERR Slot 0 is already unassigned\r\n" {
+		t.Fatalf("expected an unassigned slot error, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"INFO", "cluster"}); !strings.Contains(resp, "cluster_enabled:1") {
+		t.Fatalf("expected INFO to report cluster mode, got %q", resp)
+	}
+}
+
+func TestClusterDisabled(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	if resp := sendCommand(t, port, []string{"CLUSTER", "INFO"}); resp != "-ERR This instance has cluster support disabled\r\n" {
+		t.Fatalf("expected cluster support to be disabled, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"INFO", "cluster"}); !strings.Contains(resp, "cluster_enabled:0") {
+		t.Fatalf("expected INFO to report cluster mode off, got %q", resp)
+	}
+}
+
+// startClusterNode starts a cluster node that announces the port it
+// listens on, as other nodes reach it there.
+func startClusterNode(t *testing.T) (*Server, int) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	cfg := testConfig()
+	cfg.Port = listener.Addr().(*net.TCPAddr).Port
+	cfg.PersistencePath = t.TempDir()
+	cfg.ClusterEnabled = true
+	cfg.ClusterNodeTimeout = 300 * time.Millisecond
+	return serveTestListener(New(cfg), listener)
+}
+
+// isRedirect reports whether resp is a MOVED or ASK redirection of slot to
+// the node listening on port, at whichever address it is known by.
+func isRedirect(resp, kind, slot string, port int) bool {
+	return strings.HasPrefix(resp, "-"+kind+" "+slot+" ") && strings.HasSuffix(resp, ":"+strconv.Itoa(port)+"\r\n")
+}
+
+func TestClusterRedirection(t *testing.T) {
+	srv, port := startClusterNode(t)
+	defer srv.Stop()
+
+	if resp := sendCommand(t, port, []string{"GET", "foo"}); resp != "-CLUSTERDOWN Hash slot not served\r\n" {
+		t.Fatalf("expected an unserved slot to be refused, got %q", resp)
+	}
+	sendCommand(t, port, []string{"CLUSTER", "ADDSLOTSRANGE", "0", "16383"})
+	if resp := sendCommand(t, port, []string{"DEL", "foo", "bar"}); resp != "-CROSSSLOT Keys in request don't hash to the same slot\r\n" {
+		t.Fatalf("expected keys in different slots to be refused, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"DEL", "{foo}a", "{foo}b"}); resp != ":0\r\n" {
+		t.Fatalf("expected keys sharing a hash tag to be served, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"ASKING"}); resp != "+OK\r\n" {
+		t.Fatalf("ASKING failed: %q", resp)
+	}
+}
+
+func TestClusterSlotMigration(t *testing.T) {
+	a, aport := startClusterNode(t)
+	defer a.Stop()
+	b, bport := startClusterNode(t)
+	defer b.Stop()
+	aid, bid := a.cluster.MyID(), b.cluster.MyID()
+
+	sendCommand(t, aport, []string{"CLUSTER", "ADDSLOTSRANGE", "0", "16383"})
+	if resp := sendCommand(t, aport, []string{"CLUSTER", "MEET", "localhost", strconv.Itoa(bport)}); resp != "+OK\r\n" {
+		t.Fatalf("CLUSTER MEET failed: %q", resp)
+	}
+	if resp := sendCommand(t, bport, []string{"CLUSTER", "INFO"}); !strings.Contains(resp, "cluster_known_nodes:2\r\n") || !strings.Contains(resp, "cluster_state:ok\r\n") {
+		t.Fatalf("expected b to learn about a and its slots, got %q", resp)
+	}
+	slot := "12182" // foo
+	if resp := sendCommand(t, bport, []string{"GET", "foo"}); !isRedirect(resp, "MOVED", slot, aport) {
+		t.Fatalf("expected b to redirect to a, got %q", resp)
+	}
+
+	sendCommand(t, aport, []string{"SET", "foo", "bar", "EX", "100"})
+	sendCommand(t, aport, []string{"RPUSH", "{foo}list", "x", "y"})
+	if resp := sendCommand(t, bport, []string{"CLUSTER", "SETSLOT", slot, "IMPORTING", aid}); resp != "+OK\r\n" {
+		t.Fatalf("SETSLOT IMPORTING failed: %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"CLUSTER", "SETSLOT", slot, "MIGRATING", bid}); resp != "+OK\r\n" {
+		t.Fatalf("SETSLOT MIGRATING failed: %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"CLUSTER", "NODES"}); !strings.Contains(resp, "["+slot+"->-"+bid+"]") {
+		t.Fatalf("expected CLUSTER NODES to show the migration, got %q", resp)
+	}
+
+	// Keys still on a are served there; missing ones are on b, or will be.
+	if resp := sendCommand(t, aport, []string{"GET", "foo"}); resp != "$3\r\nbar\r\n" {
+		t.Fatalf("expected a to serve a key it still holds, got %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"GET", "{foo}new"}); !isRedirect(resp, "ASK", slot, bport) {
+		t.Fatalf("expected a missing key to be redirected with ASK, got %q", resp)
+	}
+	if resp := sendCommand(t, bport, []string{"GET", "{foo}new"}); !strings.HasPrefix(resp, "-MOVED") {
+		t.Fatalf("expected b to redirect without ASKING, got %q", resp)
+	}
+
+	if resp := sendCommand(t, aport, []string{"CLUSTER", "GETKEYSINSLOT", slot, "10"}); !strings.Contains(resp, "foo\r\n") || !strings.HasPrefix(resp, "*2\r\n") {
+		t.Fatalf("unexpected CLUSTER GETKEYSINSLOT reply: %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"MIGRATE", "localhost", strconv.Itoa(bport), "", "0", "1000", "KEYS", "foo", "{foo}list"}); resp != "+OK\r\n" {
+		t.Fatalf("MIGRATE failed: %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"MIGRATE", "localhost", strconv.Itoa(bport), "foo", "0", "1000"}); resp != "+NOKEY\r\n" {
+		t.Fatalf("expected migrating a missing key to reply NOKEY, got %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"GET", "foo"}); !isRedirect(resp, "ASK", slot, bport) {
+		t.Fatalf("expected a migrated key to be redirected with ASK, got %q", resp)
+	}
+
+	// Finish the migration on both nodes.
+	if resp := sendCommand(t, bport, []string{"CLUSTER", "SETSLOT", slot, "NODE", bid}); resp != "+OK\r\n" {
+		t.Fatalf("SETSLOT NODE on b failed: %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"CLUSTER", "SETSLOT", slot, "NODE", bid}); resp != "+OK\r\n" {
+		t.Fatalf("SETSLOT NODE on a failed: %q", resp)
+	}
+	if resp := sendCommand(t, aport, []string{"GET", "foo"}); !isRedirect(resp, "MOVED", slot, bport) {
+		t.Fatalf("expected a to redirect to the new owner, got %q", resp)
+	}
+	if resp := sendCommand(t, bport, []string{"GET", "foo"}); resp != "$3\r\nbar\r\n" {
+		t.Fatalf("expected b to serve the migrated key, got %q", resp)
+	}
+	if resp := sendCommand(t, bport, []string{"LRANGE", "{foo}list", "0", "-1"}); resp != "*2\r\n$1\r\nx\r\n$1\r\ny\r\n" {
+		t.Fatalf("expected the list to be migrated, got %q", resp)
+	}
+	if e, _ := b.store.(store.EntryStore).Lookup("foo"); e.Expiry == nil || time.Until(*e.Expiry) > 100*time.Second {
+		t.Fatalf("expected the TTL to be migrated, got %v", e.Expiry)
+	}
+	if be, ae := b.cluster.Info().MyEpoch, a.cluster.Info().MyEpoch; be <= ae {
+		t.Fatalf("expected b to claim the slot with a newer epoch than a's, got %d and %d", be, ae)
+	}
+}
+
+func TestClusterGossip(t *testing.T) {
+	a, aport := startClusterNode(t)
+	defer a.Stop()
+	b, bport := startClusterNode(t)
+	defer b.Stop()
+	c, cport := startClusterNode(t)
+	cStopped := false
+	defer func() {
+		if !cStopped {
+			c.Stop()
+		}
+	}()
+
+	for i, port := range []int{aport, bport, cport} {
+		start := strconv.Itoa(i * 5462)
+		end := strconv.Itoa(min((i+1)*5462-1, 16383))
+		sendCommand(t, port, []string{"CLUSTER", "ADDSLOTSRANGE", start, end})
+	}
+	// a meets the others; gossip introduces b and c to each other.
+	for _, port := range []int{bport, cport} {
+		if resp := sendCommand(t, aport, []string{"CLUSTER", "MEET", "localhost", strconv.Itoa(port)}); resp != "+OK\r\n" {
+			t.Fatalf("CLUSTER MEET failed: %q", resp)
+		}
+	}
+	for _, port := range []int{aport, bport, cport} {
+		waitFor(t, "the cluster to converge", func() bool {
+			resp := sendCommand(t, port, []string{"CLUSTER", "INFO"})
+			return strings.Contains(resp, "cluster_state:ok\r\n") && strings.Contains(resp, "cluster_known_nodes:3\r\n")
+		})
+	}
+	if resp := sendCommand(t, bport, []string{"GET", "foo"}); !isRedirect(resp, "MOVED", "12182", cport) {
+		t.Fatalf("expected b to redirect to c, got %q", resp)
+	}
+
+	cid := c.cluster.MyID()
+	c.Stop()
+	cStopped = true
+	for _, port := range []int{aport, bport} {
+		waitFor(t, "c to be flagged failing", func() bool {
+			resp := sendCommand(t, port, []string{"CLUSTER", "NODES"})
+			for _, line := range strings.Split(resp, "\n") {
+				if strings.HasPrefix(line, cid) {
+					return strings.Contains(line, "master,fail ") && strings.Contains(line, " disconnected")
+				}
+			}
+			return false
+		})
+	}
+	resp := sendCommand(t, aport, []string{"CLUSTER", "INFO"})
+	if !strings.Contains(resp, "cluster_state:fail\r\n") || !strings.Contains(resp, "cluster_slots_fail:5460\r\n") {
+		t.Fatalf("expected c's slots to be failing, got %q", resp)
+	}
+}
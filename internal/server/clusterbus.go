@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/protocol"
+)
+
+// CLUSTER BUS stands in for Redis' binary cluster bus: nodes exchange its
+// messages as commands over their client ports, or their TLS ports with
+// tls_cluster.
+//
+//	PING|MEET current-epoch sender [node ...]
+//	FAIL sender-id node-id
+//
+// A ping or meet carries the sender's CLUSTER NODES line and its gossip
+// about the nodes it knows (see cluster.Message), and is answered with this
+// node's own, the pong. A meet from an unknown node adds it. A node learns
+// its own address from the first connection that reaches it, unless it
+// announces one.
+func (s *Server) clusterBus(conn net.Conn, args []string) command.Response {
+	if s.cluster == nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR This instance has cluster support disabled")}
+	}
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'cluster|bus' command")}
+	}
+	local, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	if err := s.cluster.SetMyHost(local); err != nil {
+		logging.Warnf("%v", err)
+	}
+	switch kind := strings.ToUpper(args[0]); kind {
+	case "PING", "MEET":
+		remote, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if _, err := s.cluster.Receive(args[1:], remote, kind == "MEET"); err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+		}
+		return command.Response{Type: command.TypeArray, Value: s.cluster.Message()}
+	case "FAIL":
+		if len(args) != 3 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'cluster|bus' command")}
+		}
+		s.cluster.MarkFailed(args[1], args[2])
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown cluster bus message '%s'", kind)}
+	}
+}
+
+// clusterSend sends a ping or meet to the node at addr and processes its
+// pong, returning the node's ID. The node is known by the host it was
+// reached at unless it announces its own.
+func (s *Server) clusterSend(addr, kind string, timeout time.Duration) (string, error) {
+	r, err := s.busRequest(addr, timeout, append([]string{"CLUSTER", "BUS", kind}, s.cluster.Message()...)...)
+	if err != nil {
+		return "", err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	id, err := s.cluster.Receive(r.Strings(), host, true)
+	if err != nil {
+		return "", fmt.Errorf("bad pong from %s: %v", addr, err)
+	}
+	s.cluster.Ponged(id, time.Now())
+	return id, nil
+}
+
+// busRequest sends a cluster bus message to the node at addr, with each
+// step bounded by timeout, over TLS with tls_cluster and authenticated with
+// masterauth.
+func (s *Server) busRequest(addr string, timeout time.Duration, args ...string) (protocol.Reply, error) {
+	conn, err := s.dialLink(addr, timeout, s.cfg.TLSCluster)
+	if err != nil {
+		return protocol.Reply{}, fmt.Errorf("Can't reach node %s: %v", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	c := protocol.NewClient(conn)
+	if err := s.authLink(c); err != nil {
+		return protocol.Reply{}, fmt.Errorf("Node %s refused us: %v", addr, err)
+	}
+	r, err := c.Do(args...)
+	if err != nil {
+		return r, fmt.Errorf("Node %s replied: %v", addr, err)
+	}
+	return r, nil
+}
+
+// clusterPeriod is how often the other nodes are pinged: every second, or
+// more often with a short node timeout, so a failing node is noticed in
+// time.
+func (s *Server) clusterPeriod() time.Duration {
+	return min(time.Second, max(s.cluster.NodeTimeout()/3, 10*time.Millisecond))
+}
+
+func (s *Server) clusterLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.clusterPeriod())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.clusterTick()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// clusterTick pings every other node, flags the ones that stopped
+// answering and tells the others about those a majority agrees are down.
+func (s *Server) clusterTick() {
+	defer s.recoverPanic("cluster loop")
+	period := s.clusterPeriod()
+	nodes := s.cluster.Nodes()
+	var wg sync.WaitGroup
+	for _, n := range nodes[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.recoverPanic("cluster ping")
+			s.cluster.Pinged(n.ID, time.Now())
+			if _, err := s.clusterSend(n.Addr(), "PING", period); err != nil {
+				s.cluster.Unreachable(n.ID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	myID := s.cluster.MyID()
+	for _, id := range s.cluster.CheckFailures(time.Now()) {
+		for _, n := range nodes[1:] {
+			if n.ID == id {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer s.recoverPanic("cluster failure report")
+				s.busRequest(n.Addr(), period, "CLUSTER", "BUS", "FAIL", myID, id)
+			}()
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+)
+
+func (s *Server) getLogLevel() string {
+	return logging.Level()
+}
+
+func (s *Server) setLogLevel(value string) error {
+	return logging.SetLevel(value)
+}
+
+// logCommands reports whether every command is logged, at loglevel debug.
+func (s *Server) logCommands() bool {
+	return logging.Enabled(slog.LevelDebug)
+}
+
+// logCommand logs a command a connection ran, at loglevel debug, as a
+// record of the client's id and address, the command's sequence number
+// among the client's, its user, name and number of arguments, how long it
+// took and whether it failed. Following a client's ids and sequence numbers
+// traces its exact traffic. Its arguments are left out, as they may hold
+// passwords and values.
+func (s *Server) logCommand(sess *session, cmd string, nargs int, start time.Time, err error) {
+	if !s.logCommands() {
+		return
+	}
+	attrs := []any{
+		"client", sess.id, "seq", sess.commands, "addr", sess.conn.RemoteAddr().String(),
+		"user", sess.user.Name(), "cmd", strings.ToLower(cmd), "args", nargs,
+		"duration_us", time.Since(start).Microseconds(), "outcome", "ok",
+	}
+	if err != nil {
+		attrs[len(attrs)-1] = "error"
+		attrs = append(attrs, "err", err.Error())
+	}
+	logging.Log(slog.LevelDebug, "command", attrs...)
+}
+
+// responseErr is the error a command replied with, if any.
+func responseErr(response command.Response) error {
+	if response.Type == command.TypeError {
+		return response.Error
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logBuffer collects what the server logs.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog collects what the server logs until the test ends.
+func captureLog(t *testing.T) *logBuffer {
+	b := &logBuffer{}
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return b
+}
+
+func TestLogCommands(t *testing.T) {
+	logs := captureLog(t)
+	srv, port := startTestServerWithConfig(t, testConfig())
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("SET", "k", "secret")
+	c.expect("OK")
+	if strings.Contains(logs.String(), "command client=") {
+		t.Fatalf("expected commands not to be logged at loglevel notice, got %q", logs.String())
+	}
+	c.send("CONFIG", "SET", "loglevel", "debug")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("secret")
+	c.send("HGET", "k", "f")
+	c.read()
+	c.send("CONFIG", "SET", "loglevel", "notice")
+	c.expect("OK")
+
+	id := fmt.Sprintf("client=%d ", srv.sessions()[0].id)
+	for _, want := range []string{
+		id + "seq=3 addr=" + c.conn.LocalAddr().String() + " user=default cmd=get args=1 duration_us=",
+		id + "seq=4 ",
+		"cmd=hget args=2 ",
+		`outcome=error err="WRONGTYPE`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected %q in the log, got %q", want, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Fatalf("expected arguments to be left out of the log, got %q", logs.String())
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/latency"
+)
+
+// commandStat counts the calls of a command for INFO commandstats.
+type commandStat struct {
+	calls  atomic.Int64
+	failed atomic.Int64 // calls replied to with an error
+	usec   atomic.Int64 // time spent running them
+	hist   latency.Histogram
+}
+
+// commandStats holds the commandStat of each command in the command table,
+// by upper-cased name. It is made once, so that counting takes no lock;
+// unknown commands are not counted.
+type commandStats map[string]*commandStat
+
+func newCommandStats() commandStats {
+	specs := command.Specs()
+	st := make(commandStats, len(specs))
+	for _, sp := range specs {
+		st[strings.ToUpper(sp.Name)] = &commandStat{}
+	}
+	return st
+}
+
+// called counts a call of the upper-cased cmd that took d.
+func (st commandStats) called(cmd string, d time.Duration) {
+	if c := st[cmd]; c != nil {
+		c.calls.Add(1)
+		c.usec.Add(d.Microseconds())
+		c.hist.Record(d)
+	}
+}
+
+// failed counts a call of cmd as replied to with an error.
+func (st commandStats) failed(cmd string) {
+	if c := st[cmd]; c != nil {
+		c.failed.Add(1)
+	}
+}
+
+func (st commandStats) reset() {
+	for _, c := range st {
+		c.calls.Store(0)
+		c.failed.Store(0)
+		c.usec.Store(0)
+		c.hist.Reset()
+	}
+}
+
+// names returns the names of the commands called since the start or the
+// last CONFIG RESETSTAT, sorted.
+func (st commandStats) names() []string {
+	names := make([]string, 0, len(st))
+	for name, c := range st {
+		if c.calls.Load() > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// infoCommandStats reports the commands called, in Redis' format.
+func (s *Server) infoCommandStats() string {
+	var b strings.Builder
+	for _, name := range s.cmdStats.names() {
+		c := s.cmdStats[name]
+		calls, usec := c.calls.Load(), c.usec.Load()
+		fmt.Fprintf(&b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d\r\n",
+			strings.ToLower(name), calls, usec, float64(usec)/float64(calls), c.failed.Load())
+	}
+	return b.String()
+}
+
+// latencyPercentiles are the percentiles INFO latencystats reports.
+var latencyPercentiles = []float64{50, 99, 99.9}
+
+// infoLatencyStats reports percentiles of the latency of the commands
+// called, in Redis' format, from their histograms: each is within about 6%
+// of the latency measured.
+func (s *Server) infoLatencyStats() string {
+	var b strings.Builder
+	for _, name := range s.cmdStats.names() {
+		h := &s.cmdStats[name].hist
+		fmt.Fprintf(&b, "latency_percentiles_usec_%s:", strings.ToLower(name))
+		for i, p := range latencyPercentiles {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "p%g=%d.000", p, h.Percentile(p))
+		}
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// ran counts a command sess ran, from start, and its outcome in the
+// server's statistics, records it in the audit log and logs it; see
+// logCommand. args are its arguments after the name.
+func (s *Server) ran(sess *session, cmd string, args []string, start time.Time, err error) {
+	d := time.Since(start)
+	s.cmdStats.called(cmd, d)
+	s.slowlog.add(sess, cmd, args, d)
+	if err != nil {
+		s.cmdStats.failed(cmd)
+		s.stats.errors.Add(1)
+	}
+	s.audit(sess, cmd, args, err)
+	s.logCommand(sess, cmd, len(args), start, err)
+}
+
+// resetStats is CONFIG RESETSTAT: it zeroes the counters of INFO stats and
+// commandstats.
+func (s *Server) resetStats() {
+	s.stats.reset()
+	s.cmdStats.reset()
+	s.clientsMem.evicted.Store(0)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandStats(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("SET", "k", "v")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("v")
+	c.send("GET", "missing")
+	c.expect("<nil>")
+	c.send("LPUSH", "k", "v")
+	c.read()
+	c.send("SET", "short", "v", "PX", "1")
+	c.expect("OK")
+	time.Sleep(5 * time.Millisecond)
+	// Reads leave expired keys to writes and the cleanup loop.
+	c.send("LPUSH", "short", "v")
+	c.expect("1")
+
+	c.send("INFO")
+	if info := c.read()[0]; strings.Contains(info, "# Commandstats") {
+		t.Fatalf("expected commandstats left out of the default sections, got %q", info)
+	}
+	c.send("INFO", "commandstats")
+	info := c.read()[0]
+	for _, want := range []string{"cmdstat_set:calls=2,", "cmdstat_get:calls=2,", "cmdstat_lpush:calls=2,", ",failed_calls=1\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("expected %q in %q", want, info)
+		}
+	}
+	c.send("INFO", "stats")
+	info = c.read()[0]
+	for _, want := range []string{"keyspace_hits:1\r\n", "keyspace_misses:1\r\n", "total_error_replies:1\r\n", "expired_keys:1\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("expected %q in %q", want, info)
+		}
+	}
+
+	c.send("CONFIG", "RESETSTAT")
+	c.expect("OK")
+	c.send("INFO", "stats", "commandstats")
+	info = c.read()[0]
+	for _, want := range []string{"total_commands_processed:1\r\n", "keyspace_hits:0\r\n", "total_error_replies:0\r\n", "expired_keys:0\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("expected %q after CONFIG RESETSTAT in %q", want, info)
+		}
+	}
+	if strings.Contains(info, "cmdstat_get") {
+		t.Fatalf("expected commandstats to be reset, got %q", info)
+	}
+}
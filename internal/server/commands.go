@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"redis-from-scratch/internal/acl"
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// serverCommand is a command that needs server state, not just the keyspace.
+type serverCommand func(s *Server, args []string) command.Response
+
+// serverCommands take precedence over the keyspace handlers in the command
+// package. It is filled in init so handlers may refer back to execute.
+var serverCommands map[string]serverCommand
+
+func init() {
+	serverCommands = map[string]serverCommand{
+		"SAVE":     (*Server).cmdSave,
+		"BGSAVE":   (*Server).cmdBgSave,
+		"LASTSAVE": (*Server).cmdLastSave,
+		"BACKUP":   (*Server).cmdBackup,
+
+		"BGREWRITEAOF": (*Server).cmdBgRewriteAOF,
+
+		"REPLICAOF": (*Server).cmdReplicaOf,
+		"SLAVEOF":   (*Server).cmdReplicaOf,
+		"WAIT":      (*Server).cmdWait,
+		"ROLE":      (*Server).cmdRole,
+		"FAILOVER":  (*Server).cmdFailover,
+
+		"CLUSTER": (*Server).cmdCluster,
+		"MIGRATE": (*Server).cmdMigrate,
+
+		"PUBLISH": (*Server).cmdPublish,
+
+		"CONFIG": (*Server).cmdConfig,
+		"INFO":   (*Server).cmdInfo,
+
+		"LATENCY": (*Server).cmdLatency,
+		"SLOWLOG": (*Server).cmdSlowlog,
+		"MEMORY":  (*Server).cmdMemory,
+		"COMMAND": (*Server).cmdCommand,
+	}
+}
+
+// execute runs an upper-cased command against the server. A command that
+// panics, a bug, is replied to with an error rather than taking the server
+// down: its keys' locks are released as it unwinds.
+func (s *Server) execute(cmd string, args []string) command.Response {
+	return s.executeThen(cmd, args, nil)
+}
+
+// executeThen is execute, calling then with the response as
+// command.ExecuteThen does, before a keyspace command's keys are unlocked.
+func (s *Server) executeThen(cmd string, args []string, then func(command.Response)) (response command.Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.panicked("command "+strings.ToLower(cmd), r)
+			response = command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR internal error running '%s'", strings.ToLower(cmd))}
+		}
+	}()
+	if h, ok := serverCommands[cmd]; ok {
+		response = h(s, args)
+		if then != nil {
+			then(response)
+		}
+		return response
+	}
+	return command.ExecuteThen(s.store, cmd, args, then)
+}
+
+// COMMAND [COUNT | INFO [command ...] | DOCS [command ...] | GETKEYS command
+// [arg ...]] describes the commands from the command table, as clients
+// like cluster-aware ones and redis-cli's completion expect.
+func (s *Server) cmdCommand(args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeValue, Value: commandInfos(command.Specs())}
+	}
+	switch sub := strings.ToUpper(args[0]); sub {
+	case "COUNT":
+		if len(args) != 1 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'command|count' command")}
+		}
+		return command.Response{Type: command.TypeInteger, Value: len(command.Specs())}
+	case "INFO":
+		if len(args) == 1 {
+			return command.Response{Type: command.TypeValue, Value: commandInfos(command.Specs())}
+		}
+		out := make([]any, 0, len(args)-1)
+		for _, name := range args[1:] {
+			if sp, ok := command.Lookup(strings.ToUpper(name)); ok {
+				out = append(out, commandInfo(sp))
+			} else {
+				out = append(out, nil)
+			}
+		}
+		return command.Response{Type: command.TypeValue, Value: out}
+	case "DOCS":
+		specs := command.Specs()
+		if len(args) > 1 {
+			specs = specs[:0]
+			for _, name := range args[1:] {
+				if sp, ok := command.Lookup(strings.ToUpper(name)); ok {
+					specs = append(specs, sp)
+				}
+			}
+		}
+		docs := protocol.Map{}
+		for _, sp := range specs {
+			docs = append(docs, sp.Name, protocol.Map{"summary", sp.Summary, "group", sp.Group})
+		}
+		return command.Response{Type: command.TypeValue, Value: docs}
+	case "GETKEYS":
+		if len(args) < 2 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'command|getkeys' command")}
+		}
+		sp, ok := command.Lookup(strings.ToUpper(args[1]))
+		switch n := len(args) - 1; {
+		case !ok:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid command specified")}
+		case sp.Arity > 0 && n != sp.Arity, sp.Arity < 0 && n < -sp.Arity:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid number of arguments specified for command")}
+		}
+		keys := sp.Keys(args[2:])
+		if len(keys) == 0 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR The command has no key arguments")}
+		}
+		return command.Response{Type: command.TypeArray, Value: keys}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try COMMAND HELP.", args[0])}
+	}
+}
+
+func commandInfos(specs []command.Spec) []any {
+	out := make([]any, len(specs))
+	for i, sp := range specs {
+		out[i] = commandInfo(sp)
+	}
+	return out
+}
+
+// commandInfo is COMMAND INFO's reply for one command, in Redis 7's form:
+// name, arity, flags, key positions, ACL categories, and the tips, key
+// specs and subcommands, which the table has none of.
+func commandInfo(sp command.Spec) []any {
+	flags := make([]any, len(sp.Flags))
+	for i, f := range sp.Flags {
+		flags[i] = f
+	}
+	cats := []any{}
+	for _, c := range acl.Categories(strings.ToUpper(sp.Name)) {
+		cats = append(cats, "@"+c)
+	}
+	return []any{sp.Name, sp.Arity, flags, sp.FirstKey, sp.LastKey, sp.Step, cats, []any{}, []any{}, []any{}}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"redis-from-scratch/internal/command"
+)
+
+func TestCommand(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+
+	c.send("COMMAND", "COUNT")
+	c.expect(strconv.Itoa(len(command.Specs())))
+
+	c.send("COMMAND", "INFO", "get", "nosuch", "mset")
+	r, err := c.parser.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Array) != 3 || !r.Array[1].Null || !r.Array[2].Null {
+		t.Fatalf("expected get and two nulls, got %+v", r)
+	}
+	get := r.Array[0].Array
+	if len(get) != 10 || get[0].Str != "get" || get[1].Int != 2 || get[3].Int != 1 || get[4].Int != 1 || get[5].Int != 1 {
+		t.Fatalf("unexpected COMMAND INFO get: %+v", get)
+	}
+	if get[2].Array[0].Str != "readonly" || get[6].Array[0].Str != "@read" {
+		t.Fatalf("unexpected flags or categories: %+v", get)
+	}
+
+	c.send("COMMAND", "GETKEYS", "SINTERSTORE", "dst", "a", "b")
+	c.expect("dst", "a", "b")
+	c.send("COMMAND", "GETKEYS", "OBJECT", "ENCODING", "k")
+	c.expect("k")
+	c.send("COMMAND", "GETKEYS", "PING")
+	c.expect("-ERR The command has no key arguments")
+	c.send("COMMAND", "GETKEYS", "GET")
+	c.expect("-ERR Invalid number of arguments specified for command")
+	c.send("COMMAND", "GETKEYS", "NOSUCH", "k")
+	c.expect("-ERR Invalid command specified")
+
+	c.send("COMMAND", "DOCS", "set")
+	// RESP2 flattens DOCS' maps to arrays.
+	if r, _ := c.parser.ReadReply(); len(r.Array) != 2 || r.Array[1].Array[1].Str != "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist." {
+		t.Fatalf("unexpected COMMAND DOCS set: %+v", r)
+	}
+
+	c.send("COMMAND")
+	if r, _ := c.parser.ReadReply(); len(r.Array) != len(command.Specs()) {
+		t.Fatalf("expected every command, got %d", len(r.Array))
+	}
+}
+
+// TestCommandTable checks the table covers the server's commands and agrees
+// with what the server treats as writes.
+func TestCommandTable(t *testing.T) {
+	for name := range serverCommands {
+		if _, ok := command.Lookup(name); !ok {
+			t.Errorf("%s is missing from the command table", name)
+		}
+	}
+	for _, sp := range command.Specs() {
+		name := strings.ToUpper(sp.Name)
+		write := slices.Contains(sp.Flags, "write")
+		if command.IsWrite(name) && !write || command.IsReadOnly(name) && write {
+			t.Errorf("%s: flags %v disagree with IsWrite", name, sp.Flags)
+		}
+	}
+}
+
+func TestCommandPanics(t *testing.T) {
+	serverCommands["TESTPANIC"] = func(s *Server, args []string) command.Response { panic("boom") }
+	serverCommands["TESTBADREPLY"] = func(s *Server, args []string) command.Response {
+		return command.Response{Type: command.TypeInteger, Value: args}
+	}
+	serverCommands["TESTBADVALUE"] = func(s *Server, args []string) command.Response {
+		return command.Response{Type: command.TypeValue, Value: []any{"ok", 1.5}}
+	}
+	defer func() {
+		delete(serverCommands, "TESTPANIC")
+		delete(serverCommands, "TESTBADREPLY")
+		delete(serverCommands, "TESTBADVALUE")
+	}()
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	for _, cmd := range []string{"TESTPANIC", "TESTBADREPLY", "TESTBADVALUE"} {
+		c.send(cmd, "x")
+		if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-ERR internal error") {
+			t.Fatalf("%s: expected an error reply, got %q", cmd, got)
+		}
+	}
+	c.send("PING")
+	c.expect("PONG")
+	c.send("INFO", "stats")
+	if info := c.read()[0]; !strings.Contains(info, "panics_recovered:1\r\n") {
+		t.Fatalf("expected the panic to be counted, got %q", info)
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/pkg/config"
+)
+
+// configParam is a setting CONFIG GET and CONFIG SET can reach at runtime.
+type configParam struct {
+	get func(s *Server) string
+	set func(s *Server, value string) error
+}
+
+var configParams = map[string]configParam{
+	"save":              {get: (*Server).getSave, set: (*Server).setSave},
+	"replica-read-only": {get: (*Server).getReplicaReadOnly, set: (*Server).setReplicaReadOnly},
+	"read-only":         {get: (*Server).getReadOnly, set: (*Server).setReadOnly},
+
+	"min-replicas-to-write": {get: (*Server).getMinReplicas, set: (*Server).setMinReplicas},
+	"min-replicas-max-lag":  {get: (*Server).getMaxLag, set: (*Server).setMaxLag},
+
+	"requirepass": {get: (*Server).getRequirePass, set: (*Server).setRequirePass},
+	"masterauth":  {get: (*Server).getMasterAuth, set: (*Server).setMasterAuth},
+
+	"latency-monitor-threshold": {get: (*Server).getLatencyThreshold, set: (*Server).setLatencyThreshold},
+	"loglevel":                  {get: (*Server).getLogLevel, set: (*Server).setLogLevel},
+	"slowlog-log-slower-than":   {get: (*Server).getSlowlogSlowerThan, set: (*Server).setSlowlogSlowerThan},
+	"slowlog-max-len":           {get: (*Server).getSlowlogMaxLen, set: (*Server).setSlowlogMaxLen},
+
+	"client-output-buffer-limit": {get: (*Server).getOutputLimits, set: (*Server).setOutputLimits},
+	"maxmemory-clients":          {get: (*Server).getMaxMemoryClients, set: (*Server).setMaxMemoryClients},
+
+	"gogc":       {get: (*Server).getGOGC, set: (*Server).setGOGC},
+	"gomemlimit": {get: (*Server).getGOMemLimit, set: (*Server).setGOMemLimit},
+}
+
+// CONFIG GET pattern [pattern ...] | CONFIG SET parameter value | CONFIG
+// REWRITE | CONFIG RESETSTAT
+func (s *Server) cmdConfig(args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'config' command")}
+	}
+	switch sub := strings.ToUpper(args[0]); sub {
+	case "GET":
+		if len(args) < 2 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'config|get' command")}
+		}
+		var names []string
+		for name := range configParams {
+			for _, pattern := range args[1:] {
+				if command.NewPatternMatcher(strings.ToLower(pattern)).Match(name) {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+		sort.Strings(names)
+		out := make([]string, 0, 2*len(names))
+		for _, name := range names {
+			out = append(out, name, configParams[name].get(s))
+		}
+		return command.Response{Type: command.TypeArray, Value: out}
+	case "SET":
+		if len(args) != 3 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'config|set' command")}
+		}
+		p, ok := configParams[strings.ToLower(args[1])]
+		if !ok {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[1])}
+		}
+		if err := p.set(s, args[2]); err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", args[1], err)}
+		}
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	case "REWRITE":
+		if len(args) != 1 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'config|rewrite' command")}
+		}
+		return s.configRewrite()
+	case "RESETSTAT":
+		if len(args) != 1 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'config|resetstat' command")}
+		}
+		s.resetStats()
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0])}
+	}
+}
+
+// configRewrite writes the parameters CONFIG SET can change back to the
+// config file the server was started with.
+func (s *Server) configRewrite() command.Response {
+	if s.cfg.File == "" {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR The server is running without a config file")}
+	}
+	values := make(map[string]string, len(configParams))
+	for name, p := range configParams {
+		values[name] = p.get(s)
+	}
+	if err := config.Rewrite(s.cfg.File, values); err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Rewriting config file: %v", err)}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+func (s *Server) getSave() string {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return config.FormatSavePoints(s.savePoints)
+}
+
+func (s *Server) setSave(value string) error {
+	points, err := config.ParseSavePoints(value)
+	if err != nil {
+		return err
+	}
+	s.saveMu.Lock()
+	s.savePoints = points
+	s.saveMu.Unlock()
+	return nil
+}
+
+func (s *Server) getReplicaReadOnly() string {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.readOnly {
+		return "yes"
+	}
+	return "no"
+}
+
+func (s *Server) setReplicaReadOnly(value string) error {
+	var readOnly bool
+	switch strings.ToLower(value) {
+	case "yes":
+		readOnly = true
+	case "no":
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	s.repl.mu.Lock()
+	s.repl.readOnly = readOnly
+	s.repl.mu.Unlock()
+	return nil
+}
+
+func (s *Server) getReadOnly() string {
+	if s.readOnly.Load() {
+		return "yes"
+	}
+	return "no"
+}
+
+func (s *Server) setReadOnly(value string) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		s.readOnly.Store(true)
+	case "no":
+		s.readOnly.Store(false)
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func (s *Server) getOutputLimits() string {
+	return s.outputLimits.String()
+}
+
+func (s *Server) setOutputLimits(value string) error {
+	return s.outputLimits.set(value)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/pkg/config"
+)
+
+func TestConfigRewriteRedisConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	conf := `# Snapshotting
+save 3600 1
+save 300 100
+
+# Replication
+masterauth 'old'
+maxmemory 1mb
+max-value-size 512kb
+cluster-node-timeout 5000
+`
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("failed to load redis.conf: %v", err)
+	}
+	if cfg.Save != "3600 1 300 100" || cfg.MasterAuth != "old" || cfg.MaxMemory != 1<<20 || cfg.ClusterNodeTimeout != 5*time.Second {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("CONFIG", "SET", "save", "60 5")
+	c.expect("OK")
+	c.send("CONFIG", "SET", "masterauth", "new secret")
+	c.expect("OK")
+	c.send("CONFIG", "SET", "min-replicas-max-lag", "20")
+	c.expect("OK")
+	c.send("CONFIG", "REWRITE")
+	c.expect("OK")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Snapshotting
+save 60 5
+
+# Replication
+masterauth "new secret"
+maxmemory 1mb
+max-value-size 512kb
+cluster-node-timeout 5000
+# Generated by CONFIG REWRITE
+min-replicas-max-lag 20
+`
+	if string(data) != want {
+		t.Fatalf("expected rewritten file:\n%s\ngot:\n%s", want, data)
+	}
+	if cfg, err = config.LoadFromFile(path); err != nil || cfg.MasterAuth != "new secret" || cfg.MinReplicasMaxLag != 20 {
+		t.Fatalf("expected the rewritten file to load, got %+v (%v)", cfg, err)
+	}
+}
+
+func TestConfigRewriteJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"max_connections": 1000, "save": "3600 1"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("CONFIG", "SET", "save", "")
+	c.expect("OK")
+	c.send("CONFIG", "SET", "latency-monitor-threshold", "100")
+	c.expect("OK")
+	c.send("CONFIG", "REWRITE")
+	c.expect("OK")
+
+	if cfg, err = config.LoadFromFile(path); err != nil || cfg.Save != "" || cfg.LatencyThreshold != 100 || cfg.MaxConnections != 1000 {
+		t.Fatalf("unexpected rewritten config %+v (%v)", cfg, err)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "masterauth") {
+		t.Fatalf("expected defaults not to be added, got %s", data)
+	}
+}
+
+func TestConfigRewriteWithoutFile(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("CONFIG", "REWRITE")
+	c.expect("-ERR The server is running without a config file")
+}
+
+func TestLoadRedisConfErrors(t *testing.T) {
+	for _, conf := range []string{"nosuch 1", "port", "port 1 2", "maxmemory lots", "masterauth \"unbalanced"} {
+		path := filepath.Join(t.TempDir(), "redis.conf")
+		os.WriteFile(path, []byte(conf+"\n"), 0o600)
+		if _, err := config.LoadFromFile(path); err == nil {
+			t.Errorf("expected %q to be rejected", conf)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for conf, want := range map[string]string{
+		"port 70000":                       "port must be 0 to 65535, got 70000",
+		"port 0":                           "port must be 1 to 65535 unless tls_port is set, got 0",
+		"read-timeout -5":                  "read_timeout must be at least 0s, got -5ms",
+		"maxmemory 100":                    "max_memory (maxmemory) must be 0 for no limit or at least 1mb, got 100",
+		"maxmemory-policy allkeys-oldest":  `max_memory_policy (maxmemory-policy) must be noeviction, allkeys-random, allkeys-lru, allkeys-lfu, volatile-random, volatile-lru, volatile-lfu or volatile-ttl, got "allkeys-oldest"`,
+		"appendfsync sometimes":            `appendfsync must be always, everysec or no, got "sometimes"`,
+		"enable-persistence yes\ndir \"\"": "persistence_path (dir) must be set with enable_persistence or storage_backend bolt",
+		"tls-port 6380":                    "tls_port needs tls_cert_file and tls_key_file",
+		"save 60":                          `save must be pairs of seconds and changes, both at least 1, or empty to disable saves, got "60"`,
+		"persistence-compression zstd":     `persistence_compression must be none, gzip or lz4, got "zstd"`,
+		"replicaof localhost":              `replicaof must be "<host> <port>" with a port of 1 to 65535, got "localhost"`,
+		"maxmemory 1mb":                    "max_value_size must not exceed max_memory (1048576), as a value that large could never be stored, got 536870912",
+	} {
+		path := filepath.Join(t.TempDir(), "redis.conf")
+		os.WriteFile(path, []byte(conf+"\n"), 0o600)
+		if _, err := config.LoadFromFile(path); err == nil || err.Error() != want {
+			t.Errorf("expected %q to be rejected with %q, got %v", conf, want, err)
+		}
+	}
+
+	// Every problem is reported at once.
+	cfg := config.DefaultConfig()
+	cfg.MaxConnections, cfg.LogFormat = 0, "xml"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "max_connections must be at least 1, got 0") || !strings.Contains(err.Error(), `log_format must be text or json, got "xml"`) {
+		t.Fatalf("expected both errors, got %v", err)
+	}
+	if err := config.DefaultConfig().Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+}
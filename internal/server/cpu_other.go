@@ -0,0 +1,8 @@
+//go:build !unix
+
+package server
+
+// infoCPU reports nothing where the process' CPU time is not available.
+func infoCPU() string {
+	return ""
+}
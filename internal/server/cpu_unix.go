@@ -0,0 +1,18 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// infoCPU reports the CPU time the process used, in seconds.
+func infoCPU() string {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("used_cpu_sys:%.6f\r\nused_cpu_user:%.6f\r\n",
+		float64(ru.Stime.Nano())/1e9, float64(ru.Utime.Nano())/1e9)
+}
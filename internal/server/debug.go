@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// startDebug starts the admin HTTP listener on debug_addr, which serves the
+// runtime's profiles under /debug/pprof/ and its variables, with the
+// server's counters as "redis", under /debug/vars, as Go services usually
+// do: `go tool pprof http://<debug_addr>/debug/pprof/profile` captures 30
+// seconds of CPU profile from a running server. /healthz and /readyz are
+// liveness and readiness probes; see health.go. It serves neither the
+// dataset nor commands, but profiles expose a good deal of the process, so
+// it should only be reachable by operators: bind it to localhost.
+func (s *Server) startDebug() error {
+	ln, err := net.Listen("tcp", s.cfg.DebugAddr)
+	if err != nil {
+		return fmt.Errorf("debug_addr: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", s.serveVars)
+	mux.HandleFunc("/healthz", s.serveHealthz)
+	mux.HandleFunc("/readyz", s.serveReadyz)
+	s.debug = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	s.debugListener = ln
+	go func() {
+		if err := s.debug.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logging.Errorf("Debug listener error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// serveVars serves expvar's variables, like memstats and cmdline, and the
+// server's own as "redis". They are not published with expvar, which holds
+// one set for the process where tests run several servers.
+func (s *Server) serveVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: %s\n}\n", "redis", s.vars())
+}
+
+// vars are the server's counters for /debug/vars.
+func (s *Server) vars() *expvar.Map {
+	m := new(expvar.Map).Init()
+	m.Set("uptime_in_seconds", expvar.Func(func() any { return int64(time.Since(s.started).Seconds()) }))
+	m.Set("connected_clients", expvar.Func(func() any { return len(s.sessions()) }))
+	m.Set("total_connections_received", expvar.Func(func() any { return s.stats.connections.Load() }))
+	m.Set("total_commands_processed", expvar.Func(func() any { return s.stats.commands.Load() }))
+	m.Set("keyspace_hits", expvar.Func(func() any { return s.stats.hits.Load() }))
+	m.Set("keyspace_misses", expvar.Func(func() any { return s.stats.misses.Load() }))
+	m.Set("panics_recovered", expvar.Func(func() any { return s.stats.panics.Load() }))
+	m.Set("keys", expvar.Func(func() any { return s.store.Size() }))
+	return m
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDebugListener(t *testing.T) {
+	cfg := testConfig()
+	cfg.DebugAddr = "localhost:0"
+	srv := New(cfg)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	base := "http://" + srv.debugListener.Addr().String()
+
+	resp, err := http.Get(base + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatalf("failed to get a profile: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine profile:") {
+		t.Fatalf("expected a goroutine profile, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(base + "/debug/vars")
+	if err != nil {
+		t.Fatalf("failed to get the variables: %v", err)
+	}
+	defer resp.Body.Close()
+	var vars struct {
+		MemStats map[string]any `json:"memstats"`
+		Redis    map[string]any `json:"redis"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatalf("expected JSON variables: %v", err)
+	}
+	if vars.MemStats == nil || vars.Redis["connected_clients"] != float64(0) {
+		t.Fatalf("expected memstats and the server's counters, got %+v", vars)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// drainPoll is how often drainClients checks whether the connections ended.
+const drainPoll = 10 * time.Millisecond
+
+// isDraining reports whether Stop has begun.
+func (s *Server) isDraining() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainClients ends the client connections once their command in flight,
+// if any, replied: connections waiting for a command are woken up, and see
+// the server is draining. Connections still running a command after
+// timeout are closed. Replica connections are left to Stop, which sends
+// them the rest of the stream first.
+func (s *Server) drainClients(timeout time.Duration) {
+	for _, sess := range s.clientSessions() {
+		sess.conn.SetReadDeadline(time.Now())
+	}
+	if s.loop != nil {
+		s.loop.wakeAll()
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && len(s.clientSessions()) > 0 {
+		time.Sleep(drainPoll)
+	}
+	if left := s.clientSessions(); len(left) > 0 {
+		logging.Warnf("Closing %d connections still running a command after %v", len(left), timeout)
+		for _, sess := range left {
+			sess.conn.Close()
+		}
+	}
+}
+
+// clientSessions returns the open connections other than replicas'.
+func (s *Server) clientSessions() []*session {
+	var clients []*session
+	for _, sess := range s.sessions() {
+		sess.mu.Lock()
+		replica := sess.clientType() == "replica"
+		sess.mu.Unlock()
+		if !replica {
+			clients = append(clients, sess)
+		}
+	}
+	return clients
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopDrains(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+	cfg.AppendFsync = "no"
+	cfg.ShutdownTimeout = 5 * time.Second
+	srv, port := startTestServerWithConfig(t, cfg)
+
+	idle := dialTest(t, port)
+	defer idle.conn.Close()
+	busy := dialTest(t, port)
+	defer busy.conn.Close()
+	busy.send("SET", "k", "v")
+	busy.expect("OK")
+	idle.send("PING")
+	idle.expect("PONG")
+
+	// WAIT without replicas blocks for its timeout: a command in flight.
+	busy.send("WAIT", "1", "300")
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	srv.Stop()
+
+	busy.expect("0")
+	if _, err := busy.parser.ReadReply(); err != io.EOF {
+		t.Fatalf("expected the connection to close after its reply, got %v", err)
+	}
+	if _, err := idle.parser.ReadReply(); err != io.EOF {
+		t.Fatalf("expected the idle connection to close, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected Stop to end with the command in flight, took %v", elapsed)
+	}
+	if data := readAOF(t, cfg.PersistencePath); !strings.Contains(data, "SET") {
+		t.Fatalf("expected the AOF to be flushed on Stop, got %q", data)
+	}
+}
+
+func TestStopClosesAfterTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.ShutdownTimeout = 100 * time.Millisecond
+	srv, port := startTestServerWithConfig(t, cfg)
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("WAIT", "1", "0") // blocks until a replica acknowledges
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	srv.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected Stop to close the connection after the grace period, took %v", elapsed)
+	}
+}
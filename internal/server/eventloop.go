@@ -0,0 +1,212 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// errPollClosed is returned by a poll's wait once it was woken up to stop.
+var errPollClosed = errors.New("poll closed")
+
+// eventLoop serves connections in event_loop mode: one waiting for its next
+// command is parked, its goroutine gone, and the loop watches its socket
+// with epoll or kqueue, starting a goroutine to serve it once it is
+// readable. An idle connection thus costs its session and buffers but no
+// stack, which adds up at 100k connections. TLS connections, whose records
+// are decrypted in user space, are served by a goroutine each as without
+// it.
+type eventLoop struct {
+	srv  *Server
+	poll *poll
+
+	// parked holds the parked connections by descriptor; stopped is set
+	// once the loop stopped, after which none are parked.
+	mu      sync.Mutex
+	parked  map[int]*session
+	stopped bool
+}
+
+// newEventLoop opens the poll of event_loop mode and starts waiting on it.
+func newEventLoop(s *Server) (*eventLoop, error) {
+	p, err := openPoll()
+	if err != nil {
+		return nil, err
+	}
+	l := &eventLoop{srv: s, poll: p, parked: make(map[int]*session)}
+	s.wg.Add(1)
+	go l.run()
+	return l, nil
+}
+
+// run starts serving the parked connections that became readable, or hung
+// up, until stop.
+func (l *eventLoop) run() {
+	defer l.srv.wg.Done()
+	var ready []int
+	for {
+		var err error
+		ready, err = l.poll.wait(ready[:0])
+		if errors.Is(err, errPollClosed) {
+			l.mu.Lock()
+			l.poll.release()
+			l.mu.Unlock()
+			return
+		}
+		if err != nil {
+			logging.Errorf("Event loop error: %v", err)
+			continue
+		}
+		l.serveReady(ready)
+	}
+}
+
+// serveReady dispatches the parked connections whose descriptors are
+// ready. A panic is recovered from here, for run to carry on.
+func (l *eventLoop) serveReady(ready []int) {
+	defer l.srv.recoverPanic("event loop")
+	for _, fd := range ready {
+		if sess := l.unpark(fd, nil); sess != nil {
+			l.dispatch(sess)
+		}
+	}
+}
+
+// stop ends run. The connections must be gone: see wakeAll.
+func (l *eventLoop) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.stopped {
+		l.stopped = true
+		l.poll.wakeup()
+	}
+}
+
+// dispatch serves a connection unparked, which has something to read.
+func (l *eventLoop) dispatch(sess *session) {
+	go l.srv.serveSession(sess, true)
+}
+
+// wrap returns conn as the event loop serves it: closing it serves it one
+// last time if it is parked, so that it is cleaned up. Connections without
+// a descriptor to watch, like TLS ones, are returned as they are.
+func (l *eventLoop) wrap(conn net.Conn) net.Conn {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return conn
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return conn
+	}
+	fd := -1
+	raw.Control(func(d uintptr) { fd = int(d) })
+	if fd < 0 {
+		return conn
+	}
+	return &polledConn{Conn: conn, loop: l, fd: fd}
+}
+
+// park hands sess, which waits for its next command with nothing left to
+// read, to the loop, and reports whether it did: its goroutine must then
+// return without touching it. Connections it cannot watch, or closed, are
+// not parked. Parked connections are closed after idle_timeout, unless
+// subscribed.
+func (l *eventLoop) park(sess *session) bool {
+	pc, ok := sess.conn.(*polledConn)
+	if !ok {
+		return false
+	}
+	idle := l.srv.cfg.IdleTimeout
+	if sess.subscribed() {
+		idle = 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped || pc.closed.Load() {
+		return false
+	}
+	if err := l.poll.add(pc.fd); err != nil {
+		logging.Errorf("Event loop error: %v", err)
+		return false
+	}
+	l.parked[pc.fd] = sess
+	if idle > 0 {
+		if sess.idle == nil {
+			sess.idle = time.AfterFunc(idle, func() { sess.conn.Close() })
+		} else {
+			sess.idle.Reset(idle)
+		}
+	}
+	return true
+}
+
+// unpark takes the connection parked on fd back from the loop, if its
+// connection is conn or conn is nil.
+func (l *eventLoop) unpark(fd int, conn net.Conn) *session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	parked := l.parked[fd]
+	if parked == nil || conn != nil && parked.conn != conn {
+		return nil
+	}
+	delete(l.parked, fd)
+	l.poll.remove(fd)
+	if parked.idle != nil {
+		parked.idle.Stop()
+	}
+	return parked
+}
+
+// wakeAll serves every parked connection, as Stop does for them to see the
+// server is draining.
+func (l *eventLoop) wakeAll() {
+	l.mu.Lock()
+	var woken []*session
+	for fd, sess := range l.parked {
+		delete(l.parked, fd)
+		l.poll.remove(fd)
+		if sess.idle != nil {
+			sess.idle.Stop()
+		}
+		woken = append(woken, sess)
+	}
+	l.mu.Unlock()
+	for _, sess := range woken {
+		l.dispatch(sess)
+	}
+}
+
+// parkedCount is the number of connections parked.
+func (l *eventLoop) parkedCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.parked)
+}
+
+// polledConn is a connection served by the event loop.
+type polledConn struct {
+	net.Conn
+	loop   *eventLoop
+	fd     int
+	closed atomic.Bool
+}
+
+// Close closes the connection and, if it was parked, serves it for its
+// goroutine to see it closed and clean up, as when it is killed or reaps
+// itself after idle_timeout. The descriptor is unwatched before it is
+// closed and maybe reused.
+func (c *polledConn) Close() error {
+	c.closed.Store(true)
+	sess := c.loop.unpark(c.fd, c)
+	err := c.Conn.Close()
+	if sess != nil {
+		c.loop.dispatch(sess)
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEventLoopServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := testConfig()
+	cfg.EventLoop = true
+	srv := New(cfg)
+	if srv.loop == nil {
+		t.Skip("no event loop on this platform")
+	}
+	return srv
+}
+
+func TestEventLoopParks(t *testing.T) {
+	srv := newEventLoopServer(t)
+	srv, port := serveTestListener(srv, testListener(t))
+	defer srv.Stop()
+
+	clients := make([]*testConn, 10)
+	for i := range clients {
+		clients[i] = dialTest(t, port)
+		clients[i].send("PING")
+		clients[i].expect("PONG")
+	}
+	waitFor(t, "the connections to be parked", func() bool { return srv.loop.parkedCount() == len(clients) })
+
+	for i, c := range clients {
+		c.send("SET", "k", strings.Repeat("v", i))
+		c.expect("OK")
+		c.send("GET", "k")
+		c.expect(strings.Repeat("v", i))
+	}
+	if got := sendCommand(t, port, []string{"INFO", "server"}); !strings.Contains(got, "multiplexing_api:"+pollAPI) {
+		t.Fatalf("expected INFO to report %s, got %q", pollAPI, got)
+	}
+
+	// Killing a parked connection cleans it up.
+	clients[0].send("CLIENT", "ID")
+	id := clients[0].read()[0]
+	waitFor(t, "the connection to be parked again", func() bool { return srv.loop.parkedCount() == len(clients) })
+	clients[1].send("CLIENT", "KILL", "ID", id)
+	clients[1].expect("1")
+	if _, err := io.ReadAll(clients[0].conn); err != nil {
+		t.Fatalf("expected the killed connection to be closed, got %v", err)
+	}
+	waitFor(t, "the killed connection to be removed", func() bool { return len(srv.sessions()) == len(clients)-1 })
+}
+
+func TestEventLoopIdleTimeout(t *testing.T) {
+	srv := newEventLoopServer(t)
+	srv.cfg.IdleTimeout = 100 * time.Millisecond
+	srv, port := serveTestListener(srv, testListener(t))
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("PING")
+	c.expect("PONG")
+	if _, err := io.ReadAll(c.conn); err != nil {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+	waitFor(t, "the idle connection to be removed", func() bool { return len(srv.sessions()) == 0 })
+}
+
+func TestEventLoopStop(t *testing.T) {
+	srv := newEventLoopServer(t)
+	srv, port := serveTestListener(srv, testListener(t))
+
+	c := dialTest(t, port)
+	c.send("PING")
+	c.expect("PONG")
+	waitFor(t, "the connection to be parked", func() bool { return srv.loop.parkedCount() == 1 })
+	start := time.Now()
+	srv.Stop()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected parked connections not to hold Stop, took %v", d)
+	}
+	if _, err := io.ReadAll(c.conn); err != nil {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+)
+
+// failover is a FAILOVER in progress. It is reached through repl.failover,
+// under repl.mu.
+type failover struct {
+	// state is Redis' master_failover_state: waiting-for-sync while the
+	// target catches up, then failover-in-progress while it takes over.
+	state string
+	// target is the address of the replica to hand over to, or empty for
+	// the first one to catch up.
+	target  string
+	force   bool
+	timeout time.Duration
+
+	abort     chan struct{}
+	abortOnce sync.Once
+	// done is closed when the failover ends, resuming writes.
+	done chan struct{}
+}
+
+var errFailoverAborted = errors.New("failover manually aborted")
+
+// FAILOVER [TO host port [FORCE]] [TIMEOUT ms] [ABORT] hands the master role
+// over to a replica, as Redis does: writes are paused until the replica
+// (the given one, or the first to get there) acknowledges every write, then
+// this server becomes its replica and asks it, with PSYNC FAILOVER, to
+// become a master. Clients whose writes were paused then get READONLY, and
+// the other replicas follow the new master through this server. With a
+// timeout the failover is abandoned if the replica has not caught up by
+// then, unless FORCE hands over regardless. ABORT cancels it.
+func (s *Server) cmdFailover(args []string) command.Response {
+	var target string
+	var force, abort bool
+	var timeout int64
+	for i := 0; i < len(args); i++ {
+		switch opt := args[i]; {
+		case strings.EqualFold(opt, "TIMEOUT") && i+1 < len(args) && timeout == 0:
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+			}
+			if ms <= 0 {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR FAILOVER timeout must be greater than 0")}
+			}
+			timeout = ms
+			i++
+		case strings.EqualFold(opt, "TO") && i+2 < len(args) && target == "":
+			if _, err := strconv.Atoi(args[i+2]); err != nil {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+			}
+			target = net.JoinHostPort(args[i+1], args[i+2])
+			i += 2
+		case strings.EqualFold(opt, "FORCE") && !force:
+			force = true
+		case strings.EqualFold(opt, "ABORT") && !abort:
+			abort = true
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+	}
+
+	if abort {
+		return s.abortFailover()
+	}
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.link != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR FAILOVER is not valid when server is a replica.")}
+	}
+	if len(s.repl.replicas) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR FAILOVER requires connected replicas.")}
+	}
+	if force && (timeout == 0 || target == "") {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR FAILOVER with force option requires both a timeout and target HOST and IP.")}
+	}
+	if s.repl.failover != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR FAILOVER already in progress.")}
+	}
+	if target != "" {
+		r := s.findReplica(target)
+		if r == nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR FAILOVER target HOST and PORT is not a replica.")}
+		}
+		if !r.online() {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR FAILOVER target replica is not online.")}
+		}
+	}
+
+	f := &failover{
+		state:   "waiting-for-sync",
+		target:  target,
+		force:   force,
+		timeout: time.Duration(timeout) * time.Millisecond,
+		abort:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.repl.failover = f
+	s.requestAcks()
+	logging.Infof("FAILOVER requested to %s.", cmp.Or(target, "any replica"))
+	s.wg.Add(1)
+	go s.runFailover(f)
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// abortFailover cancels the failover in progress and waits for it to end.
+func (s *Server) abortFailover() command.Response {
+	s.repl.mu.Lock()
+	f := s.repl.failover
+	s.repl.mu.Unlock()
+	if f == nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR No failover in progress.")}
+	}
+	f.abortOnce.Do(func() { close(f.abort) })
+	<-f.done
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// runFailover waits for the target to catch up, hands over to it and
+// resumes writes.
+func (s *Server) runFailover(f *failover) {
+	defer s.wg.Done()
+	var timeout <-chan time.Time
+	if f.timeout > 0 {
+		t := time.NewTimer(f.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	err := s.handOver(f, timeout)
+
+	s.repl.mu.Lock()
+	s.repl.failover = nil
+	s.repl.mu.Unlock()
+	close(f.done)
+	if err != nil {
+		logging.Warnf("FAILOVER to %s aborted: %v", cmp.Or(f.target, "any replica"), err)
+		return
+	}
+	logging.Infof("FAILOVER to %s completed.", f.target)
+}
+
+// handOver waits for the target to acknowledge every write and makes this
+// server its replica, asking it to take over. If that fails, this server
+// becomes a master again. A panic aborts it, resuming writes.
+func (s *Server) handOver(f *failover, timeout <-chan time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.panicked("failover", r)
+			err = errPanicked
+		}
+	}()
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+	forced := false
+	var l *masterLink
+	result := make(chan error, 1)
+	for l == nil {
+		// Writes are paused, but ones already under way finish first:
+		// the target must have acknowledged them too when this server
+		// stops being a master, so that is decided under writeMu.
+		s.writeMu.Lock()
+		s.repl.mu.Lock()
+		if target := s.caughtUpReplica(f, forced); target != "" {
+			f.target, f.state = target, "failover-in-progress"
+			l = newMasterLink(target)
+			l.failover = result
+			s.followMaster(l)
+		}
+		acked := s.repl.acked
+		s.repl.mu.Unlock()
+		s.writeMu.Unlock()
+		if l != nil {
+			break
+		}
+		select {
+		case <-acked:
+		case <-ticker.C:
+			s.repl.mu.Lock()
+			s.requestAcks()
+			s.repl.mu.Unlock()
+		case <-timeout:
+			if !f.force {
+				return errors.New("target did not catch up in time")
+			}
+			forced, timeout = true, nil
+		case <-f.abort:
+			return errFailoverAborted
+		case <-s.quit:
+			return errors.New("server stopping")
+		}
+	}
+
+	logging.Infof("FAILOVER target %s caught up, asking it to take over.", f.target)
+	select {
+	case err = <-result:
+	case <-l.done:
+		err = errors.New("replication stopped")
+	case <-timeout:
+		err = errors.New("target did not take over in time")
+	case <-f.abort:
+		err = errFailoverAborted
+	case <-s.quit:
+		return errors.New("server stopping")
+	}
+	if err != nil {
+		// Take the master role back.
+		if s.stopReplicaOf() {
+			s.shiftReplID()
+			logging.Infof("MASTER MODE enabled")
+		}
+	}
+	return err
+}
+
+// caughtUpReplica returns the address of the replica to hand over to once
+// it has acknowledged every write: the target, or any online replica if
+// none was given. A forced failover does not wait for the target. It is
+// called with repl.mu held.
+func (s *Server) caughtUpReplica(f *failover, forced bool) string {
+	if forced {
+		return f.target
+	}
+	for r := range s.repl.replicas {
+		if r.online() && (f.target == "" || r.addr == f.target) && r.ackOffset >= s.repl.offset {
+			return r.addr
+		}
+	}
+	return ""
+}
+
+// findReplica returns the replica at addr, as ROLE reports it, or nil. It is
+// called with repl.mu held.
+func (s *Server) findReplica(addr string) *replica {
+	for r := range s.repl.replicas {
+		if r.addr == addr {
+			return r
+		}
+	}
+	return nil
+}
+
+// acceptFailover makes this replica a master on the request of its master,
+// which sent PSYNC <id> <offset> FAILOVER with our replication ID.
+func (s *Server) acceptFailover(id string) bool {
+	s.repl.mu.Lock()
+	ours := s.repl.id
+	s.repl.mu.Unlock()
+	if id != ours {
+		return false
+	}
+	logging.Infof("Failover request received for replid %s.", id)
+	if s.stopReplicaOf() {
+		s.shiftReplID()
+		logging.Infof("MASTER MODE enabled")
+	}
+	return true
+}
+
+// failingOver reports whether a failover is in progress.
+func (s *Server) failingOver() bool {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	return s.repl.failover != nil
+}
+
+// waitForFailover blocks while a failover pauses writes.
+func (s *Server) waitForFailover() {
+	s.repl.mu.Lock()
+	f := s.repl.failover
+	s.repl.mu.Unlock()
+	if f == nil {
+		return
+	}
+	select {
+	case <-f.done:
+	case <-s.quit:
+	}
+}
+
+// failoverState is master_failover_state for INFO.
+func (s *Server) failoverState() string {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.failover == nil {
+		return "no-failover"
+	}
+	return s.repl.failover.state
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// fastPath reports whether a command is served by serveFast: GET key, or
+// SET key value without options, from a connection that does not track
+// keys, outside cluster mode, unless every command is logged and, for SET,
+// without a default TTL to add. They make most of a cache's traffic, and
+// profiles of such loads showed the generic path spending its time in the
+// command table lookups, the slices of keys it builds, and boxing values in
+// a Response.
+func (s *Server) fastPath(sess *session, cmd string, args []string) bool {
+	if s.cluster != nil || sess.tracker != nil || s.logCommands() || s.auditLog != nil {
+		return false
+	}
+	return cmd == "GET" && len(args) == 2 || cmd == "SET" && len(args) == 3 && s.cfg.DefaultTTL == 0
+}
+
+// serveFast runs a command fastPath accepted and writes its reply, flushed
+// if flush is set, with the same effects as the generic path.
+func (s *Server) serveFast(sess *session, cmd string, args []string, flush bool) error {
+	if cmd == "GET" {
+		value, ok := command.GetString(s.store, args[1])
+		if !ok {
+			s.stats.misses.Add(1)
+			return sess.writeOut(writeNull, flush)
+		}
+		s.stats.hits.Add(1)
+		return sess.writeOut(func(w *protocol.Writer) error { return w.WriteBulkString(value) }, flush)
+	}
+	if err := s.fastSet(sess, args[1:]); err != nil {
+		s.cmdStats.failed(cmd)
+		s.stats.errors.Add(1)
+		return sess.writeOut(func(w *protocol.Writer) error { return w.WriteError(err.Error()) }, flush)
+	}
+	return sess.writeOut(writeOK, flush)
+}
+
+// fastSet is executeWrite for SET key value.
+func (s *Server) fastSet(sess *session, args []string) error {
+	s.waitForFailover()
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	if err := s.writeAllowed(sess, "SET", args); err != nil {
+		return err
+	}
+	return command.SetString(s.store, args, func() { s.written(sess, "SET", args) })
+}
+
+func writeOK(w *protocol.Writer) error {
+	return w.WriteSimpleString("OK")
+}
+
+func writeNull(w *protocol.Writer) error {
+	return w.WriteNull()
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"redis-from-scratch/internal/protocol"
+)
+
+func TestFastPath(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("GET", "k")
+	c.expect("<nil>")
+	c.send("SET", "k", "v")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("v")
+	c.send("HELLO", "3")
+	c.read()
+	c.send("GET", "missing")
+	c.expect("<nil>")
+	if got := srv.stats.hits.Load(); got != 1 {
+		t.Fatalf("expected 1 hit, got %d", got)
+	}
+	if got := srv.stats.misses.Load(); got != 2 {
+		t.Fatalf("expected 2 misses, got %d", got)
+	}
+}
+
+func TestFastPathOOM(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxMemory = 1
+	cfg.MaxMemoryPolicy = "noeviction"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("SET", "k", "v")
+	c.expect("OK")
+	c.send("SET", "k2", "v")
+	if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-OOM") {
+		t.Fatalf("expected an OOM error, got %q", got)
+	}
+}
+
+// benchmarkPipeline sends b.N commands in pipelines of 100 and reads their
+// replies, each reply long, the way redis-benchmark -P 100 does.
+func benchmarkPipeline(b *testing.B, cmd []string, reply int) {
+	srv, port := serveTestListener(New(testConfig()), testListener(b))
+	defer srv.Stop()
+	srv.store.Set("key", "value", 0)
+
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	var req bytes.Buffer
+	w := protocol.NewWriter(&req)
+	for i := 0; i < 100; i++ {
+		w.WriteArray(cmd)
+	}
+	replies := make([]byte, 100*reply)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 100 {
+		if _, err := conn.Write(req.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, replies); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPipelineGet(b *testing.B) {
+	benchmarkPipeline(b, []string{"GET", "key"}, len("$5\r\nvalue\r\n"))
+}
+
+func BenchmarkPipelineSet(b *testing.B) {
+	benchmarkPipeline(b, []string{"SET", "key", "value"}, len("+OK\r\n"))
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"redis-from-scratch/pkg/config"
+)
+
+// The Go runtime's garbage collector decides how much memory the process
+// really holds beyond used_memory: with GOGC at 100 the heap may grow to
+// twice what is live before a collection, so a server at its maxmemory can
+// take about twice that from the OS. gogc and gomemlimit tune it as the
+// GOGC and GOMEMLIMIT environment variables do, for the whole process, and
+// INFO runtime reports what it does.
+
+// envGOGC is the GOGC the runtime started with: from the environment
+// variable, off being -1, or 100.
+func envGOGC() int64 {
+	v := os.Getenv("GOGC")
+	if strings.EqualFold(v, "off") {
+		return -1
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n
+	}
+	return 100
+}
+
+// setupRuntime applies the gogc and gomemlimit of cfg, if set.
+func (s *Server) setupRuntime(cfg *config.Config) {
+	if cfg.GOGC != 0 {
+		s.setGCPercent(int64(cfg.GOGC))
+	}
+	if cfg.GOMemLimit > 0 {
+		debug.SetMemoryLimit(cfg.GOMemLimit)
+	}
+}
+
+// setGCPercent sets GOGC, negative turning the collector off and 0 going
+// back to the environment's.
+func (s *Server) setGCPercent(n int64) {
+	if n < 0 {
+		n = -1
+	}
+	s.gogc.Store(n)
+	if n == 0 {
+		n = envGOGC()
+	}
+	debug.SetGCPercent(int(n))
+}
+
+// gcPercent is the GOGC the runtime runs with, -1 when off.
+func (s *Server) gcPercent() int64 {
+	if n := s.gogc.Load(); n != 0 {
+		return n
+	}
+	return envGOGC()
+}
+
+func formatGOGC(n int64) string {
+	if n < 0 {
+		return "off"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func (s *Server) getGOGC() string {
+	return formatGOGC(s.gogc.Load())
+}
+
+func (s *Server) setGOGC(value string) error {
+	if strings.EqualFold(value, "off") {
+		s.setGCPercent(-1)
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("argument must be an integer or 'off'")
+	}
+	s.setGCPercent(n)
+	return nil
+}
+
+// memoryLimit is GOMEMLIMIT, or 0 without one.
+func memoryLimit() int64 {
+	if n := debug.SetMemoryLimit(-1); n != math.MaxInt64 {
+		return n
+	}
+	return 0
+}
+
+func (s *Server) getGOMemLimit() string {
+	return strconv.FormatInt(memoryLimit(), 10)
+}
+
+func (s *Server) setGOMemLimit(value string) error {
+	n, err := config.ParseSize(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("argument must be a memory value")
+	}
+	if n == 0 {
+		n = math.MaxInt64
+	}
+	debug.SetMemoryLimit(n)
+	return nil
+}
+
+// infoRuntime reports the Go runtime's scheduler, heap and garbage
+// collector.
+func (s *Server) infoRuntime() string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var lastPause uint64
+	if m.NumGC > 0 {
+		lastPause = m.PauseNs[(m.NumGC+255)%256]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "go_goroutines:%d\r\n", runtime.NumGoroutine())
+	fmt.Fprintf(&b, "go_gomaxprocs:%d\r\n", runtime.GOMAXPROCS(0))
+	fmt.Fprintf(&b, "go_heap_alloc:%d\r\n", m.HeapAlloc)
+	fmt.Fprintf(&b, "go_heap_inuse:%d\r\n", m.HeapInuse)
+	fmt.Fprintf(&b, "go_heap_idle:%d\r\n", m.HeapIdle)
+	fmt.Fprintf(&b, "go_heap_released:%d\r\n", m.HeapReleased)
+	fmt.Fprintf(&b, "go_heap_objects:%d\r\n", m.HeapObjects)
+	fmt.Fprintf(&b, "go_sys:%d\r\n", m.Sys)
+	fmt.Fprintf(&b, "go_sys_human:%s\r\n", humanBytes(int64(m.Sys)))
+	fmt.Fprintf(&b, "go_next_gc:%d\r\n", m.NextGC)
+	fmt.Fprintf(&b, "go_gc_cycles:%d\r\n", m.NumGC)
+	fmt.Fprintf(&b, "go_gc_forced_cycles:%d\r\n", m.NumForcedGC)
+	fmt.Fprintf(&b, "go_gc_pause_total_usec:%d\r\n", m.PauseTotalNs/1000)
+	fmt.Fprintf(&b, "go_gc_last_pause_usec:%d\r\n", lastPause/1000)
+	fmt.Fprintf(&b, "go_gc_cpu_fraction:%.6f\r\n", m.GCCPUFraction)
+	fmt.Fprintf(&b, "gogc:%s\r\n", formatGOGC(s.gcPercent()))
+	fmt.Fprintf(&b, "gomemlimit:%d\r\n", memoryLimit())
+	return b.String()
+}
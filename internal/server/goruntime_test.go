@@ -0,0 +1,53 @@
+package server
+
+import (
+	"math"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeInfo(t *testing.T) {
+	t.Setenv("GOGC", "")
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	defer debug.SetMemoryLimit(math.MaxInt64)
+
+	cfg := testConfig()
+	cfg.GOMemLimit = 1 << 40
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("INFO", "runtime")
+	info := c.read()[0]
+	for _, want := range []string{"# Runtime\r\n", "go_goroutines:", "go_gomaxprocs:", "go_heap_inuse:", "go_gc_pause_total_usec:", "gogc:100\r\n", "gomemlimit:1099511627776\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("expected %q in %q", want, info)
+		}
+	}
+
+	c.send("CONFIG", "SET", "gogc", "off")
+	c.expect("OK")
+	c.send("CONFIG", "GET", "gogc")
+	c.expect("gogc", "off")
+	c.send("CONFIG", "SET", "gogc", "x")
+	c.expect("-ERR CONFIG SET failed (possibly related to argument 'gogc') - argument must be an integer or 'off'")
+	c.send("CONFIG", "SET", "gogc", "200")
+	c.expect("OK")
+	c.send("CONFIG", "SET", "gomemlimit", "0")
+	c.expect("OK")
+	c.send("INFO", "runtime")
+	info = c.read()[0]
+	if !strings.Contains(info, "gogc:200\r\n") || !strings.Contains(info, "gomemlimit:0\r\n") {
+		t.Fatalf("expected the new GOGC and no memory limit in %q", info)
+	}
+	c.send("CONFIG", "SET", "gogc", "0")
+	c.expect("OK")
+	c.send("CONFIG", "GET", "gogc")
+	c.expect("gogc", "0")
+	c.send("INFO", "runtime")
+	if info := c.read()[0]; !strings.Contains(info, "gogc:100\r\n") {
+		t.Fatalf("expected GOGC back to the environment's in %q", info)
+	}
+}
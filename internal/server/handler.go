@@ -1,131 +1,448 @@
 package server
 
 import (
-	"io"
-	"log"
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"net"
 	"strings"
 	"time"
 
+	"redis-from-scratch/internal/acl"
 	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
 	"redis-from-scratch/internal/protocol"
-	"redis-from-scratch/pkg/config"
 )
 
-// HandleConnectionWithTimeouts processes client connections with read/write timeouts
+// handleConnection serves a client connection's commands until it closes,
+// stays idle for idle_timeout, or the server stops.
 func (s *Server) handleConnection(conn net.Conn) {
+	if s.loop != nil {
+		conn = s.loop.wrap(conn)
+	}
+	sess := s.newSession(conn)
+	s.addClient(sess)
+	s.stats.connections.Add(1)
+	s.serveSession(sess, false)
+}
+
+// serveSession serves the connection's commands until it closes or, in
+// event_loop mode, until it is parked waiting for the next one. woken is
+// set when the event loop unparked it, to read what woke it. A panic
+// serving it closes it alone.
+func (s *Server) serveSession(sess *session, woken bool) {
+	conn, parser := sess.conn, sess.parser
+	parked := false
 	defer func() {
+		if r := recover(); r != nil {
+			s.panicked(sess.describe(), r)
+			parked = false
+		}
+		if parked {
+			return
+		}
+		s.removeClient(sess)
+		s.unsubscribeAll(sess)
+		close(sess.closed)
 		conn.Close()
 		s.wg.Done()
 	}()
 
-	// Apply read/write timeouts from config
-	if err := applyTimeouts(conn, s.cfg); err != nil {
-		log.Printf("Warning: failed to apply timeouts: %v", err)
-	}
-
-	parser := protocol.NewParser(conn)
-	writer := protocol.NewWriter(conn)
-
 	for {
-		select {
-		case <-s.quit:
+		// Wait for the next command for up to idle_timeout, then give
+		// its rest read_timeout to arrive. The deadline is set before
+		// checking for Stop, which may have woken the connection up.
+		s.setIdleDeadline(sess)
+		if s.isDraining() {
+			return
+		}
+		if s.loop != nil && !woken && parser.Buffered() == 0 {
+			// Send the replies so far, then leave waiting to the
+			// event loop.
+			if err := sess.flush(); err != nil {
+				return
+			}
+			if parked = s.loop.park(sess); parked {
+				return
+			}
+		}
+		woken = false
+		if err := parser.Wait(); err != nil {
+			// Closed, idle for too long, or woken up by Stop.
 			return
-		default:
 		}
+		setDeadline(conn.SetReadDeadline, s.cfg.ReadTimeout)
 
 		// Parse incoming command
-		args, err := parser.Parse()
+		args, err := parser.ParseInto(sess.args)
 		if err != nil {
-			if err == io.EOF {
+			var perr *protocol.ProtocolError
+			if !errors.As(err, &perr) {
+				// Closed, or too slow to send the command.
+				return
+			}
+			if err := sess.write(func(w *protocol.Writer) error { return w.WriteError("ERR " + perr.Error()) }); err != nil {
+				return
+			}
+			if !perr.Recoverable {
+				// Out of step with the client: whatever follows could be
+				// read as commands it never meant.
+				logging.Infof("Closing client id=%d after a protocol error: %v", sess.id, err)
 				return
 			}
-			log.Printf("Parse error: %v", err)
-			writer.WriteError(err.Error())
 			continue
 		}
 
 		if len(args) == 0 {
 			continue
 		}
+		sess.args = args
+		sess.usedArgs(args)
+		s.evictClients()
 
 		cmd := strings.ToUpper(args[0])
+		if name, ok := s.commandNames[cmd]; ok {
+			cmd = name
+		}
+		if cmd == "" {
+			// Renamed or disabled by rename_command.
+			response := command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown command '%s'", args[0])}
+			if err := sess.write(response.WriteTo); err != nil {
+				return
+			}
+			continue
+		}
+		sess.started(cmd)
+		s.stats.commands.Add(1)
+		sess.asking, sess.askingNext = sess.askingNext, false
+		if cmd == "RESTORE-ASKING" {
+			// MIGRATE's RESTORE, for a slot the target may be importing.
+			cmd, sess.asking = "RESTORE", true
+		}
 
-		// Execute command
-		response := command.Execute(s.store, cmd, args[1:])
+		// A connection whose user was deleted is closed.
+		denied := s.checkACL(sess, cmd, args[1:])
+		if errors.Is(denied, acl.ErrRemoved) {
+			return
+		}
 
-		// Persist write commands if persistence enabled
-		if s.aof != nil && isPersistentCommand(cmd) {
-			if err := s.aof.LogCommand(cmd, args[1:]); err != nil {
-				log.Printf("Failed to log command to AOF: %v", err)
-				// Don't fail the request, but log the error
+		// Execute command, persisting write commands that changed the dataset
+		var response command.Response
+		start := time.Now()
+		switch {
+		case !sess.authenticated && !authExempt[cmd]:
+			response = command.Response{Type: command.TypeError, Error: errNoAuth}
+		case cmd == "AUTH":
+			response = s.cmdAuth(sess, args[1:])
+		case cmd == "HELLO":
+			response = s.cmdHello(sess, args[1:])
+		case cmd == "QUIT":
+			// Reply, then close.
+			s.ran(sess, cmd, args[1:], start, nil)
+			sess.write(command.Response{Type: command.TypeSimpleString, Value: "OK"}.WriteTo)
+			return
+		case cmd == "RESET":
+			response = s.cmdReset(sess, args[1:])
+		case s.restricted[cmd]:
+			response = command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR command '%s' is disabled on this server", strings.ToLower(cmd))}
+		case denied != nil:
+			response = command.Response{Type: command.TypeError, Error: denied}
+		case cmd == "ACL":
+			response = s.cmdACL(sess, args[1:])
+		case cmd == "CLIENT":
+			response = s.cmdClient(sess, args[1:])
+		case sess.subscribed() && sess.w.Protocol() == 2 && !subscriberCommands[cmd]:
+			// In RESP3 messages are pushes, told from replies, so any
+			// command may run.
+			response = notAllowedSubscribed(cmd)
+		case cmd == "SUBSCRIBE" || cmd == "UNSUBSCRIBE" || cmd == "PSUBSCRIBE" || cmd == "PUNSUBSCRIBE":
+			// They reply once per channel or pattern, and start or
+			// end the delivery of its messages in order with the
+			// replies.
+			err := sess.write(func(w *protocol.Writer) error {
+				if strings.HasSuffix(cmd, "UNSUBSCRIBE") {
+					return s.cmdUnsubscribe(sess, w, cmd, args[1:])
+				}
+				return s.cmdSubscribe(sess, w, cmd, args[1:])
+			})
+			s.ran(sess, cmd, args[1:], start, nil)
+			if err != nil {
+				logging.Infof("Write error: %v", err)
+				return
+			}
+			continue
+		case cmd == "PING" && sess.subscribed() && sess.w.Protocol() == 2:
+			response = subscriberPing(args[1:])
+		case cmd == "SYNC" || cmd == "PSYNC":
+			// The connection is a replica's from now on.
+			var psync []string
+			if cmd == "PSYNC" {
+				// A master handing over to this replica adds FAILOVER.
+				if len(args) != 3 && (len(args) != 4 || !strings.EqualFold(args[3], "FAILOVER")) {
+					response = command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'psync' command")}
+					break
+				}
+				psync = args[1:]
 			}
+			if err := sess.flush(); err != nil {
+				return
+			}
+			s.ran(sess, cmd, args[1:], start, nil)
+			s.serveReplica(conn, parser, sess.w, psync, sess.replConf)
+			return
+		case cmd == "CLUSTER" && len(args) > 1 && strings.EqualFold(args[1], "BUS"):
+			// Another node's message; its address is the connection's.
+			response = s.clusterBus(conn, args[2:])
+		case cmd == "REPLCONF":
+			response = replconf(args[1:], &sess.replConf)
+		case cmd == "ASKING":
+			response, sess.askingNext = s.cmdAsking(args[1:])
+		case cmd == "SELECT":
+			response = sess.cmdSelect(args[1:])
+		case command.IsKeyspace(cmd):
+			// Replied to by runKeyspace, flushed as below.
+			if err := s.runKeyspace(sess, cmd, args, parser.Buffered() == 0); err != nil {
+				if err != errPanicked {
+					logging.Infof("Write error: %v", err)
+				}
+				return
+			}
+			continue
+		case command.IsWrite(cmd):
+			response = s.executeWrite(sess, cmd, args[1:])
+		default:
+			response = s.executeRead(sess, cmd, args[1:])
+		}
+
+		if cmd != "WAIT" {
+			// WAIT blocks for replicas, not for the server.
+			s.latency.Add("command", time.Since(start))
 		}
+		s.ran(sess, cmd, args[1:], start, responseErr(response))
 
-		// Write response
-		if err := response.WriteTo(writer); err != nil {
-			log.Printf("Write error: %v", err)
+		// Write response, flushed with those of the commands pipelined
+		// after it; see input.
+		write := sess.write
+		if parser.Buffered() > 0 {
+			write = sess.writeBuffered
+		}
+		if err := write(response.WriteTo); err != nil {
+			logging.Infof("Write error: %v", err)
 			return
 		}
 	}
 }
 
-// applyTimeouts sets read/write deadlines on the connection
-func applyTimeouts(conn net.Conn, cfg *config.Config) error {
-	if cfg.ReadTimeout > 0 {
-		if err := conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout)); err != nil {
-			return err
-		}
+// runKeyspace runs a keyspace command and writes its reply, flushed if
+// flush is set, on a worker when workers is set. The connection waits for
+// it, so its replies stay in order.
+func (s *Server) runKeyspace(sess *session, cmd string, args []string, flush bool) error {
+	if s.workers == nil {
+		return s.execKeyspace(sess, cmd, args, flush)
 	}
+	if sess.done == nil {
+		sess.done = make(chan error, 1)
+	}
+	return s.workers.do(sess.done, func() (err error) {
+		// Recovered from here, the panic takes the worker, not the
+		// process, down; the connection is closed.
+		defer func() {
+			if r := recover(); r != nil {
+				s.panicked(sess.describe(), r)
+				err = errPanicked
+			}
+		}()
+		return s.execKeyspace(sess, cmd, args, flush)
+	})
+}
 
-	if cfg.WriteTimeout > 0 {
-		if err := conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout)); err != nil {
-			return err
-		}
+// execKeyspace is runKeyspace's work.
+func (s *Server) execKeyspace(sess *session, cmd string, args []string, flush bool) error {
+	start := time.Now()
+	if s.fastPath(sess, cmd, args) {
+		err := s.serveFast(sess, cmd, args, flush)
+		d := time.Since(start)
+		s.cmdStats.called(cmd, d)
+		s.slowlog.add(sess, cmd, args[1:], d)
+		s.latency.Add("command", d)
+		return err
+	}
+	var response command.Response
+	if command.IsWrite(cmd) {
+		response = s.executeWrite(sess, cmd, args[1:])
+	} else {
+		response = s.executeRead(sess, cmd, args[1:])
+	}
+	s.latency.Add("command", time.Since(start))
+	s.ran(sess, cmd, args[1:], start, responseErr(response))
+	return sess.writeOut(response.WriteTo, flush)
+}
+
+// executeRead runs any other command from a client's session, unless in
+// cluster mode its keys are served by another node.
+func (s *Server) executeRead(sess *session, cmd string, args []string) command.Response {
+	if err := s.route(cmd, args, sess.asking); err != nil {
+		return command.Response{Type: command.TypeError, Error: err}
+	}
+	response := s.execute(cmd, args)
+	if command.IsReadOnly(cmd) {
+		s.stats.lookedUp(command.Keys(cmd, args), response)
+	}
+	if sess.tracker != nil && response.Type != command.TypeError {
+		s.tracking.Track(sess.tracker, command.Keys(cmd, args))
+	}
+	return response
+}
+
+// executeWrite runs a write command from a client's session unless
+// persistence is failing, the server is read-only, or a read-only replica,
+// too few replicas are
+// connected or, in cluster mode, its keys are served by another node. A
+// failover pauses writes until it ends; the checks are made under writeMu
+// so a write it held back is refused if the server became a replica, and
+// MIGRATE cannot move its keys away in between.
+func (s *Server) executeWrite(sess *session, cmd string, args []string) command.Response {
+	s.waitForFailover()
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	if err := s.writeAllowed(sess, cmd, args); err != nil {
+		return command.Response{Type: command.TypeError, Error: err}
+	}
+	return s.applyWriteLocked(sess, cmd, command.DefaultExpiry(cmd, args, s.defaultTTL()))
+}
+
+// defaultTTL is the expiry a client's SET without one is given, for
+// deployments used purely as a cache: default_ttl, plus a random extra of up
+// to default_ttl_jitter so keys written together do not all expire at once.
+// Zero disables it.
+func (s *Server) defaultTTL() time.Duration {
+	ttl := s.cfg.DefaultTTL
+	if ttl > 0 && s.cfg.DefaultTTLJitter > 0 {
+		ttl += time.Duration(rand.Int64N(int64(s.cfg.DefaultTTLJitter)))
 	}
+	return ttl
+}
 
+// writeAllowed returns why a write command from sess is refused, if it is;
+// see executeWrite. It is called with writeMu's read lock held.
+func (s *Server) writeAllowed(sess *session, cmd string, args []string) error {
+	if err := s.route(cmd, args, sess.asking); err != nil {
+		return err
+	}
+	if err := s.writeDenied(); err != nil {
+		return err
+	}
+	if s.readOnly.Load() {
+		return fmt.Errorf("READONLY You can't write against a read only server.")
+	}
+	if s.readOnlyReplica() {
+		return fmt.Errorf("READONLY You can't write against a read only replica.")
+	}
+	if !s.enoughReplicas() {
+		return fmt.Errorf("NOREPLICAS Not enough good replicas to write.")
+	}
 	return nil
 }
 
-// isPersistentCommand determines if a command should be persisted to AOF
-func isPersistentCommand(cmd string) bool {
-	persistentCommands := map[string]bool{
-		"SET":     true,
-		"DEL":     true,
-		"HSET":    true,
-		"HDEL":    true,
-		"LPUSH":   true,
-		"RPUSH":   true,
-		"LPOP":    true,
-		"RPOP":    true,
-		"SADD":    true,
-		"SREM":    true,
-		"ZADD":    true,
-		"ZREM":    true,
-		"FLUSHDB": true,
+// applyWrite runs a write command, logging it to the AOF, propagating it to
+// replicas and invalidating the keys tracking clients read if it changed the
+// dataset. sess is the session it comes from, nil for the master's stream
+// or the server's own writes.
+func (s *Server) applyWrite(sess *session, cmd string, args []string) command.Response {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.applyWriteLocked(sess, cmd, args)
+}
+
+// applyWriteLocked is applyWrite for callers already holding writeMu's read
+// lock.
+func (s *Server) applyWriteLocked(sess *session, cmd string, args []string) command.Response {
+	return s.executeThen(cmd, args, func(response command.Response) {
+		if command.Changed(cmd, response) {
+			s.written(sess, cmd, args)
+		}
+	})
+}
+
+// written invalidates the keys tracking clients read, logs to the AOF and
+// propagates to replicas a write command that changed the dataset. It is
+// called with writeMu's read lock and the command's key locks held, so that
+// writes to a key reach the AOF and the replicas in the order they were
+// applied.
+func (s *Server) written(sess *session, cmd string, args []string) {
+	s.invalidate(sess, cmd, args)
+	s.dirty.Add(1)
+	logged := command.NormalizeExpiry(cmd, args, time.Now())
+	if s.aof != nil {
+		if err := s.aof.LogCommand(cmd, logged); err != nil {
+			logging.Errorf("Failed to log command to AOF: %v", err)
+			// Don't fail the request, but log the error
+		}
+	}
+	s.propagate(cmd, logged)
+}
+
+// removed tells the tracking clients that read it of a key the store
+// expired or evicted, and logs to the AOF and propagates to replicas its
+// DEL, as Redis does, so that replicas and a replayed AOF drop it too rather
+// than keep it for ever. It is called from the store's hooks, with its write
+// lock held, so the DEL is ordered with the writes to the key after it.
+func (s *Server) removed(key string) {
+	args := []string{key}
+	s.tracking.Invalidate(nil, args)
+	s.dirty.Add(1)
+	if s.aof != nil {
+		s.aof.QueueCommand("DEL", args)
+	}
+	s.propagate("DEL", args)
+}
+
+// setIdleDeadline makes the connection's next read time out after
+// idle_timeout, unless it is subscribed: subscribers wait for messages, not
+// commands, and are not reaped, as in Redis.
+func (s *Server) setIdleDeadline(sess *session) {
+	if sess.subscribed() {
+		sess.conn.SetReadDeadline(time.Time{})
+		return
+	}
+	setDeadline(sess.conn.SetReadDeadline, s.cfg.IdleTimeout)
+}
+
+// setDeadline sets a deadline timeout from now with set, or none if timeout
+// is 0.
+func setDeadline(set func(time.Time) error, timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
 	}
-	return persistentCommands[cmd]
+	set(deadline)
+}
+
+// writeDenied returns the MISCONF error write commands are rejected with
+// while persistence is failing and stop_writes_on_bgsave_error is set, as
+// Redis does: after a failed save when save rules are configured, and after
+// a failed AOF write until the AOF is written again.
+func (s *Server) writeDenied() error {
+	if !s.cfg.StopWritesOnError {
+		return nil
+	}
+	s.saveMu.Lock()
+	saveErr, rules := s.lastSaveErr, len(s.savePoints)
+	s.saveMu.Unlock()
+	if saveErr != nil && rules > 0 {
+		return fmt.Errorf("MISCONF Unable to save snapshots to disk: %v. Commands that may modify the data set are disabled until a save succeeds, because stop_writes_on_bgsave_error is set", saveErr)
+	}
+	if s.aof != nil {
+		if err := s.aof.WriteErr(); err != nil {
+			return fmt.Errorf("MISCONF Errors writing to the AOF file: %v", err)
+		}
+	}
+	return nil
 }
 
-// ReadOnlyCommand checks if a command only reads data
+// IsReadOnlyCommand reports whether cmd only reads data.
 func IsReadOnlyCommand(cmd string) bool {
-	readOnlyCommands := map[string]bool{
-		"GET":       true,
-		"HGET":      true,
-		"HGETALL":   true,
-		"LRANGE":    true,
-		"LPOP":      true,
-		"RPOP":      true,
-		"SMEMBERS":  true,
-		"SISMEMBER": true,
-		"KEYS":      true,
-		"SCAN":      true,
-		"HSCAN":     true,
-		"EXISTS":    true,
-		"PING":      true,
-		"ECHO":      true,
-	}
-	return readOnlyCommands[cmd]
+	return command.IsReadOnly(strings.ToUpper(cmd))
 }
 
 // OptimizedHandler with batching and connection pooling consideration
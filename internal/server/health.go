@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// serveHealthz is the liveness probe on the admin listener: it answers
+// once the store does, so a server stuck on its store's lock fails it by
+// timing out, and is restarted.
+func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	s.store.Size()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "ok\n")
+}
+
+// serveReadyz is the readiness probe on the admin listener: it fails with
+// 503 Service Unavailable and the reasons, one a line, while the server
+// should not be sent clients.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	s.store.Size()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if reasons := s.notReady(); len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s\n", strings.Join(reasons, "\n"))
+		return
+	}
+	fmt.Fprintf(w, "ok\n")
+}
+
+// notReady returns why the server is not ready for clients: it is shutting
+// down, it refuses writes as persistence is failing, or it is a replica
+// whose link to its master is not up.
+func (s *Server) notReady() []string {
+	var reasons []string
+	if s.isDraining() {
+		reasons = append(reasons, "shutting down")
+	}
+	if err := s.writeDenied(); err != nil {
+		reasons = append(reasons, err.Error())
+	}
+	s.repl.mu.Lock()
+	link := s.repl.link
+	s.repl.mu.Unlock()
+	if link != nil {
+		if state := link.getState(); state != "connected" {
+			reasons = append(reasons, fmt.Sprintf("master link %s", state))
+		}
+	}
+	return reasons
+}
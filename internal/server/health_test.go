@@ -0,0 +1,58 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHealthProbes(t *testing.T) {
+	cfg := testConfig()
+	cfg.DebugAddr = "localhost:0"
+	srv := New(cfg)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	base := "http://" + srv.debugListener.Addr().String()
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Fatalf("expected /healthz to be ok, got %d %q", code, body)
+	}
+	if code, body := get("/readyz"); code != http.StatusOK || body != "ok\n" {
+		t.Fatalf("expected /readyz to be ok, got %d %q", code, body)
+	}
+
+	// A replica is not ready until its master link is up; nothing listens
+	// on the port the test listener had.
+	ln := testListener(t)
+	masterPort := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	c := dialTest(t, srv.Addr().(*net.TCPAddr).Port)
+	c.send("REPLICAOF", "127.0.0.1", masterPort)
+	c.expect("OK")
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "master link") {
+		t.Fatalf("expected /readyz to fail while the master link is down, got %d %q", code, body)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to stay ok, got %d", code)
+	}
+	c.send("REPLICAOF", "NO", "ONE")
+	c.expect("OK")
+	if code, body := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to be ok again, got %d %q", code, body)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+	"redis-from-scratch/internal/version"
+)
+
+// serverVersion is the Redis version the server reports to HELLO, which
+// clients check for the features they may use.
+const serverVersion = version.Redis
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]] switches
+// the connection to RESP protover, 2 or 3, authenticating it and naming it
+// first, and replies with the server's details as a map: a flat array in
+// RESP2.
+func (s *Server) cmdHello(sess *session, args []string) command.Response {
+	proto := sess.w.Protocol()
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Protocol version is not an integer or out of range")}
+		}
+		if v != 2 && v != 3 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("NOPROTO unsupported protocol version")}
+		}
+		proto = v
+	}
+	var auth []string
+	name, setName := "", false
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "AUTH" && i+2 < len(args):
+			auth = args[i+1 : i+3]
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			name, setName = args[i+1], true
+			i++
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i])}
+		}
+	}
+	if setName && !validClientName(name) {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Client names cannot contain spaces, newlines or special characters.")}
+	}
+	if auth != nil {
+		if r := s.cmdAuth(sess, auth); r.Type == command.TypeError {
+			return r
+		}
+	}
+	if !sess.authenticated {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")}
+	}
+	if setName {
+		sess.setName(name)
+	}
+	// Under the write lock pushLoop encodes with; the messages already
+	// queued go out in the old protocol.
+	sess.write(func(w *protocol.Writer) error {
+		w.SetProtocol(proto)
+		return nil
+	})
+	sess.resp3.Store(proto == 3)
+
+	mode := "standalone"
+	if s.cluster != nil {
+		mode = "cluster"
+	}
+	role := "master"
+	s.repl.mu.Lock()
+	if s.repl.link != nil {
+		role = "replica"
+	}
+	s.repl.mu.Unlock()
+	return command.Response{Type: command.TypeValue, Value: protocol.Map{
+		"server", "redis",
+		"version", serverVersion,
+		"proto", proto,
+		"id", sess.id,
+		"mode", mode,
+		"role", role,
+		"modules", []any{},
+	}}
+}
+
+// validClientName reports whether name may name a connection: printable
+// ASCII without spaces, as in Redis.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
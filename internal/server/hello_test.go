@@ -0,0 +1,52 @@
+package server
+
+import "testing"
+
+func TestHello(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("HELLO")
+	c.expect("server", "redis", "version", serverVersion, "proto", "2", "id", "1", "mode", "standalone", "role", "master", "modules", "")
+	c.send("HELLO", "4")
+	c.expect("-NOPROTO unsupported protocol version")
+	c.send("HELLO", "3", "SETNAME")
+	c.expect("-ERR Syntax error in HELLO option 'SETNAME'")
+	c.send("HELLO", "3", "SETNAME", "worker-1")
+	r, err := c.parser.ReadReply()
+	if err != nil || r.Kind != '%' || len(r.Array) != 14 || r.Array[5].Int != 3 {
+		t.Fatalf("expected a RESP3 map, got %+v (%v)", r, err)
+	}
+	c.send("GET", "missing")
+	if r, err := c.parser.ReadReply(); err != nil || r.Kind != '_' {
+		t.Fatalf("expected a RESP3 null, got %+v (%v)", r, err)
+	}
+	c.send("HELLO", "2")
+	c.read()
+	c.send("GET", "missing")
+	c.expect("<nil>")
+}
+
+func TestHelloAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequirePass = "secret"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("HELLO", "3")
+	if got := c.read(); len(got) != 1 || got[0][:7] != "-NOAUTH" {
+		t.Fatalf("expected HELLO to need authentication, got %q", got)
+	}
+	c.send("HELLO", "3", "AUTH", "default", "wrong")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.")
+	c.send("HELLO", "3", "AUTH", "default", "secret")
+	if r, err := c.parser.ReadReply(); err != nil || r.Kind != '%' {
+		t.Fatalf("expected a RESP3 map, got %+v (%v)", r, err)
+	}
+	c.send("SET", "k", "1")
+	c.expect("OK")
+}
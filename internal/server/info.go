@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/store"
+	"redis-from-scratch/internal/version"
+)
+
+// serverStats are the counters INFO's stats section reports.
+type serverStats struct {
+	connections atomic.Int64 // connections accepted
+	commands    atomic.Int64 // commands run, whatever their outcome
+	hits        atomic.Int64 // keys read commands found
+	misses      atomic.Int64 // keys read commands did not
+	errors      atomic.Int64 // commands replied to with an error
+	expired     atomic.Int64 // keys removed as their TTL passed
+	evicted     atomic.Int64 // keys removed by the eviction policy
+	panics      atomic.Int64 // panics recovered from, kept by RESETSTAT; see panic.go
+}
+
+func (st *serverStats) reset() {
+	for _, c := range []*atomic.Int64{&st.connections, &st.commands, &st.hits, &st.misses, &st.errors, &st.expired, &st.evicted} {
+		c.Store(0)
+	}
+}
+
+// lookedUp counts the keys a read-only command read as hits, or as misses
+// when it replied with null or an empty array. Errors count as neither.
+func (st *serverStats) lookedUp(keys []string, response command.Response) {
+	if len(keys) == 0 {
+		return
+	}
+	switch response.Type {
+	case command.TypeError:
+	case command.TypeNull:
+		st.misses.Add(int64(len(keys)))
+	case command.TypeArray:
+		if v, _ := response.Value.([]string); len(v) == 0 {
+			st.misses.Add(int64(len(keys)))
+			return
+		}
+		st.hits.Add(int64(len(keys)))
+	default:
+		st.hits.Add(int64(len(keys)))
+	}
+}
+
+// INFO adds the sections only the server can fill in to the keyspace one,
+// in Redis' order.
+func (s *Server) cmdInfo(args []string) command.Response {
+	return command.Info(s.store, args,
+		command.InfoSection{Name: "server", Render: s.infoServer},
+		command.InfoSection{Name: "clients", Render: s.infoClients},
+		command.InfoSection{Name: "memory", Render: s.infoMemory},
+		command.InfoSection{Name: "persistence", Render: s.infoPersistence},
+		command.InfoSection{Name: "stats", Render: s.infoStats},
+		command.InfoSection{Name: "replication", Render: s.infoReplication},
+		command.InfoSection{Name: "cpu", Title: "CPU", Render: infoCPU},
+		command.InfoSection{Name: "runtime", Render: s.infoRuntime},
+		command.InfoSection{Name: "commandstats", Render: s.infoCommandStats, Extra: true},
+		command.InfoSection{Name: "latencystats", Render: s.infoLatencyStats},
+		command.InfoSection{Name: "cluster", Render: s.infoCluster},
+	)
+}
+
+// infoServer describes the process.
+func (s *Server) infoServer() string {
+	mode := "standalone"
+	if s.cluster != nil {
+		mode = "cluster"
+	}
+	uptime := time.Since(s.started)
+	var b strings.Builder
+	build := version.Get()
+	fmt.Fprintf(&b, "redis_version:%s\r\n", serverVersion)
+	fmt.Fprintf(&b, "redis_git_sha1:%s\r\n", build.ShortCommit())
+	fmt.Fprintf(&b, "redis_git_dirty:%d\r\n", boolInt(build.Dirty))
+	fmt.Fprintf(&b, "build_version:%s\r\n", build.Version)
+	fmt.Fprintf(&b, "build_commit:%s\r\n", build.Commit)
+	fmt.Fprintf(&b, "build_date:%s\r\n", build.Date)
+	fmt.Fprintf(&b, "redis_mode:%s\r\n", mode)
+	fmt.Fprintf(&b, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "arch_bits:%d\r\n", strconv.IntSize)
+	fmt.Fprintf(&b, "go_version:%s\r\n", runtime.Version())
+	if s.loop != nil {
+		fmt.Fprintf(&b, "multiplexing_api:%s\r\n", pollAPI)
+	}
+	fmt.Fprintf(&b, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(&b, "tcp_port:%d\r\n", s.cfg.Port)
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	fmt.Fprintf(&b, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	return b.String()
+}
+
+// infoClients counts the open connections, and those subscribed or
+// tracking.
+func (s *Server) infoClients() string {
+	sessions := s.sessions()
+	pubsub := 0
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		if len(sess.subscriptions)+len(sess.patterns) > 0 {
+			pubsub++
+		}
+		sess.mu.Unlock()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", len(sessions))
+	fmt.Fprintf(&b, "maxclients:%d\r\n", s.cfg.MaxConnections)
+	fmt.Fprintf(&b, "pubsub_clients:%d\r\n", pubsub)
+	fmt.Fprintf(&b, "tracking_clients:%d\r\n", s.tracking.Clients())
+	return b.String()
+}
+
+// infoMemory reports the memory the dataset holds when the engine accounts
+// for it, or else the Go heap.
+func (s *Server) infoMemory() string {
+	var used int64
+	if r, ok := s.store.(store.MemoryReporter); ok {
+		used = r.UsedMemory()
+	} else {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		used = int64(m.HeapAlloc)
+	}
+	policy, _ := store.ParseEvictionPolicy(s.cfg.MaxMemoryPolicy)
+	var replicas, normal int64
+	for _, sess := range s.sessions() {
+		_, used := sess.mem.get()
+		if sess.outputClass() == "replica" {
+			replicas += used
+		} else {
+			normal += used
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "used_memory:%d\r\n", used)
+	fmt.Fprintf(&b, "used_memory_human:%s\r\n", humanBytes(used))
+	fmt.Fprintf(&b, "maxmemory:%d\r\n", s.cfg.MaxMemory)
+	fmt.Fprintf(&b, "maxmemory_human:%s\r\n", humanBytes(s.cfg.MaxMemory))
+	fmt.Fprintf(&b, "maxmemory_policy:%s\r\n", policy)
+	fmt.Fprintf(&b, "mem_clients_slaves:%d\r\n", replicas)
+	fmt.Fprintf(&b, "mem_clients_normal:%d\r\n", normal)
+	fmt.Fprintf(&b, "maxmemory_clients:%d\r\n", s.clientsMem.limit.Load())
+	return b.String()
+}
+
+// infoStats reports the server's counters, since the start or the last
+// CONFIG RESETSTAT.
+func (s *Server) infoStats() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "total_connections_received:%d\r\n", s.stats.connections.Load())
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", s.stats.commands.Load())
+	fmt.Fprintf(&b, "expired_keys:%d\r\n", s.stats.expired.Load())
+	fmt.Fprintf(&b, "evicted_keys:%d\r\n", s.stats.evicted.Load())
+	fmt.Fprintf(&b, "evicted_clients:%d\r\n", s.clientsMem.evicted.Load())
+	fmt.Fprintf(&b, "keyspace_hits:%d\r\n", s.stats.hits.Load())
+	fmt.Fprintf(&b, "keyspace_misses:%d\r\n", s.stats.misses.Load())
+	fmt.Fprintf(&b, "total_error_replies:%d\r\n", s.stats.errors.Load())
+	fmt.Fprintf(&b, "panics_recovered:%d\r\n", s.stats.panics.Load())
+	return b.String()
+}
+
+// humanBytes formats n as Redis' *_human fields do, like 1.50M.
+func humanBytes(n int64) string {
+	const units = "KMGTP"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f, i := float64(n)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%c", f, units[i])
+}
+
+// infoPersistence reports saves and the AOF with Redis' field names.
+func (s *Server) infoPersistence() string {
+	s.saveMu.Lock()
+	saving, lastSave, saveErr, rewriting := s.saving, s.lastSave, s.lastSaveErr, s.aofRewriting
+	s.saveMu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "rdb_changes_since_last_save:%d\r\n", s.dirty.Load())
+	fmt.Fprintf(&b, "rdb_bgsave_in_progress:%d\r\n", boolInt(saving))
+	fmt.Fprintf(&b, "rdb_last_save_time:%d\r\n", lastSave.Unix())
+	fmt.Fprintf(&b, "rdb_last_bgsave_status:%s\r\n", status(saveErr))
+	fmt.Fprintf(&b, "aof_enabled:%d\r\n", boolInt(s.aof != nil))
+	if s.aof != nil {
+		current, base := s.aof.Size()
+		fmt.Fprintf(&b, "aof_rewrite_in_progress:%d\r\n", boolInt(rewriting))
+		fmt.Fprintf(&b, "aof_last_write_status:%s\r\n", status(s.aof.WriteErr()))
+		fmt.Fprintf(&b, "aof_current_size:%d\r\n", current)
+		fmt.Fprintf(&b, "aof_base_size:%d\r\n", base)
+		pending, _ := s.aof.Backlog()
+		fmt.Fprintf(&b, "aof_buffer_length:%d\r\n", pending)
+	}
+	writesDenied := s.writeDenied() != nil
+	fmt.Fprintf(&b, "stop_writes_on_bgsave_error:%d\r\n", boolInt(s.cfg.StopWritesOnError))
+	fmt.Fprintf(&b, "writes_denied:%d\r\n", boolInt(writesDenied))
+	return b.String()
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func status(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// jsonLog is a file of JSON lines the server appends records to, like the
+// audit log and the slow log's file. It is only ever appended to.
+type jsonLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openJSONLog(path string) (*jsonLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLog{f: f}, nil
+}
+
+// write appends rec to the log as a line, in a single write so that the
+// records of connections running commands at once are not interleaved.
+func (l *jsonLog) write(rec any) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		logging.Errorf("Failed to write to %s: %v", l.f.Name(), err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		logging.Errorf("Failed to write to %s: %v", l.f.Name(), err)
+	}
+}
+
+func (l *jsonLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
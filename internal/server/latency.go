@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// LATENCY LATEST | HISTORY event | RESET [event ...] | DOCTOR reports the
+// latency spikes of commands ("command"), saves ("save") and expire cycles
+// ("expire-cycle") of latency-monitor-threshold milliseconds or more.
+// LATENCY HISTOGRAM [command ...] reports the latency of every call of each
+// command, or of those named, called since the start or the last CONFIG
+// RESETSTAT.
+func (s *Server) cmdLatency(args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'latency' command")}
+	}
+	sub := strings.ToUpper(args[0])
+	wrongArgs := command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'latency|%s' command", strings.ToLower(sub))}
+	switch sub {
+	case "LATEST":
+		if len(args) != 1 {
+			return wrongArgs
+		}
+		out := []any{}
+		for _, l := range s.latency.Latest() {
+			out = append(out, []any{l.Event, l.Time.Unix(), int(l.Latency.Milliseconds()), int(l.Max.Milliseconds())})
+		}
+		return command.Response{Type: command.TypeValue, Value: out}
+	case "HISTORY":
+		if len(args) != 2 {
+			return wrongArgs
+		}
+		out := []any{}
+		for _, sample := range s.latency.History(args[1]) {
+			out = append(out, []any{sample.Time.Unix(), int(sample.Latency.Milliseconds())})
+		}
+		return command.Response{Type: command.TypeValue, Value: out}
+	case "RESET":
+		return command.Response{Type: command.TypeInteger, Value: s.latency.Reset(args[1:]...)}
+	case "HISTOGRAM":
+		return command.Response{Type: command.TypeValue, Value: s.latencyHistogram(args[1:])}
+	case "DOCTOR":
+		if len(args) != 1 {
+			return wrongArgs
+		}
+		return command.Response{Type: command.TypeBulkString, Value: s.latency.Doctor()}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try LATENCY HELP.", args[0])}
+	}
+}
+
+func (s *Server) getLatencyThreshold() string {
+	return strconv.FormatInt(s.latency.Threshold().Milliseconds(), 10)
+}
+
+func (s *Server) setLatencyThreshold(value string) error {
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return fmt.Errorf("argument must be a non-negative integer")
+	}
+	s.latency.SetThreshold(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+// latencyHistogram is LATENCY HISTOGRAM's reply, as in Redis: a map of
+// each command called, among names if any, to its calls and the number of
+// them that took up to each power of two of microseconds.
+func (s *Server) latencyHistogram(names []string) protocol.Map {
+	if len(names) == 0 {
+		names = s.cmdStats.names()
+	}
+	out := protocol.Map{}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		c := s.cmdStats[name]
+		if c == nil || seen[name] || c.calls.Load() == 0 {
+			continue
+		}
+		seen[name] = true
+		buckets := protocol.Map{}
+		for _, n := range c.hist.PowersOfTwo() {
+			buckets = append(buckets, n)
+		}
+		out = append(out, strings.ToLower(name), protocol.Map{"calls", c.hist.Count(), "histogram_usec", buckets})
+	}
+	return out
+}
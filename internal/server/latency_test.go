@@ -0,0 +1,55 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("CONFIG", "GET", "latency-monitor-threshold")
+	c.expect("latency-monitor-threshold", "0")
+	c.send("LATENCY", "LATEST")
+	c.expect()
+	c.send("CONFIG", "SET", "latency-monitor-threshold", "10")
+	c.expect("OK")
+
+	// Spikes are hard to cause on demand; record one as a slow command would.
+	srv.latency.Add("command", 25*time.Millisecond)
+	c.send("LATENCY", "LATEST")
+	r, err := c.parser.ReadReply()
+	if err != nil || len(r.Array) != 1 {
+		t.Fatalf("expected one event, got %+v (%v)", r, err)
+	}
+	if e := r.Array[0].Array; len(e) != 4 || e[0].Str != "command" || e[2].Int != 25 || e[3].Int != 25 {
+		t.Fatalf("unexpected LATENCY LATEST entry: %+v", e)
+	}
+	c.send("LATENCY", "HISTORY", "command")
+	if r, err := c.parser.ReadReply(); err != nil || len(r.Array) != 1 || r.Array[0].Array[1].Int != 25 {
+		t.Fatalf("unexpected LATENCY HISTORY: %+v (%v)", r, err)
+	}
+	c.send("LATENCY", "DOCTOR")
+	if report := c.read()[0]; !strings.Contains(report, "command: 1 latency spikes") {
+		t.Fatalf("unexpected LATENCY DOCTOR: %q", report)
+	}
+	c.send("LATENCY", "RESET")
+	c.expect("1")
+
+	c.send("LATENCY", "HISTOGRAM", "config", "ping")
+	r, err = c.parser.ReadReply()
+	if err != nil || len(r.Array) != 2 || r.Array[0].Str != "config" {
+		t.Fatalf("expected CONFIG's histogram alone, got %+v (%v)", r, err)
+	}
+	if h := r.Array[1].Array; len(h) != 4 || h[0].Str != "calls" || h[1].Int != 2 || h[2].Str != "histogram_usec" || len(h[3].Array) == 0 {
+		t.Fatalf("unexpected histogram: %+v", h)
+	}
+	c.send("INFO", "latencystats")
+	if info := c.read()[0]; !strings.Contains(info, "latency_percentiles_usec_config:p50=") || !strings.Contains(info, ",p99.9=") {
+		t.Fatalf("unexpected INFO latencystats: %q", info)
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+	"redis-from-scratch/internal/store"
+)
+
+// bigkeysTypes are the types MEMORY BIGKEYS reports on, in its order.
+var bigkeysTypes = []store.ValueType{store.TypeString, store.TypeList, store.TypeSet, store.TypeZSet, store.TypeHash}
+
+// MEMORY BIGKEYS [COUNT count] | DOCTOR
+func (s *Server) cmdMemory(args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'memory' command")}
+	}
+	switch sub := strings.ToUpper(args[0]); sub {
+	case "BIGKEYS":
+		count := 1
+		switch {
+		case len(args) == 1:
+		case len(args) == 3 && strings.EqualFold(args[1], "COUNT"):
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 1 {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR count should be greater than 0")}
+			}
+			count = n
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+		return command.Response{Type: command.TypeValue, Value: s.bigkeys(count)}
+	case "DOCTOR":
+		if len(args) != 1 {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'memory|doctor' command")}
+		}
+		return command.Response{Type: command.TypeBulkString, Value: s.memoryDoctor()}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try MEMORY HELP.", args[0])}
+	}
+}
+
+// bigkeysStat is what MEMORY BIGKEYS gathers about the keys of one type.
+type bigkeysStat struct {
+	keys, elements, bytes int64
+	// byElements and byBytes are the largest keys, largest first.
+	byElements, byBytes []bigkey
+}
+
+type bigkey struct {
+	key  string
+	size int64
+}
+
+// top inserts k into the largest count keys, if it is one of them. Of keys
+// of the same size, the first found stays ahead.
+func top(keys []bigkey, k bigkey, count int) []bigkey {
+	i := len(keys)
+	for i > 0 && keys[i-1].size < k.size {
+		i--
+	}
+	if i == count {
+		return keys
+	}
+	if len(keys) < count {
+		keys = append(keys, bigkey{})
+	}
+	copy(keys[i+1:], keys[i:])
+	keys[i] = k
+	return keys
+}
+
+// bigkeys is MEMORY BIGKEYS' reply, Redis' redis-cli --bigkeys and
+// --memkeys done server-side: for each type, the number of keys, their
+// elements and estimated bytes in all, as store.Entry's Len and Size count
+// them, and the count largest keys by each. It walks the whole keyspace
+// through a snapshot, so writers carry on meanwhile, paying only for
+// copies of the keys they change before the walk gets to them; the
+// calling connection waits for it.
+func (s *Server) bigkeys(count int) protocol.Map {
+	stats := make(map[store.ValueType]*bigkeysStat, len(bigkeysTypes))
+	for _, t := range bigkeysTypes {
+		stats[t] = &bigkeysStat{}
+	}
+	s.store.ForEach(func(e store.Entry) bool {
+		st := stats[e.Type]
+		if st == nil {
+			return true
+		}
+		n, size := int64(e.Len()), e.Size()
+		st.keys++
+		st.elements += n
+		st.bytes += size
+		st.byElements = top(st.byElements, bigkey{e.Key, n}, count)
+		st.byBytes = top(st.byBytes, bigkey{e.Key, size}, count)
+		return true
+	})
+
+	list := func(keys []bigkey) protocol.Map {
+		out := protocol.Map{}
+		for _, k := range keys {
+			out = append(out, k.key, k.size)
+		}
+		return out
+	}
+	out := protocol.Map{}
+	for _, t := range bigkeysTypes {
+		st := stats[t]
+		out = append(out, t.String(), protocol.Map{
+			"keys", st.keys,
+			"elements", st.elements,
+			"bytes", st.bytes,
+			"biggest_by_elements", list(st.byElements),
+			"biggest_by_bytes", list(st.byBytes),
+		})
+	}
+	return out
+}
+
+// What MEMORY DOCTOR takes for a problem.
+const (
+	doctorHugeKey       = 10 << 20    // a key's estimated bytes
+	doctorShortTTL      = time.Minute // a TTL short enough for a key to churn
+	doctorManyShortTTL  = 100000      // keys with a short TTL
+	doctorClientOutput  = 200000      // a normal client's pending output, as in Redis
+	doctorReplicaOutput = 10 << 20    // a replica's pending output, as in Redis
+	doctorAOFQueued     = 1000        // commands waiting for the AOF writer
+)
+
+// memoryDoctor is MEMORY DOCTOR's report, as LATENCY DOCTOR's is for
+// latency: the likely memory problems of the server, each with advice.
+// It walks the keyspace as MEMORY BIGKEYS does, for huge keys and keys
+// about to expire, and looks at the clients' pending output and the AOF's
+// backlog as they are now.
+func (s *Server) memoryDoctor() string {
+	var issues []string
+
+	var huge []bigkey
+	var hugeCount, shortTTL int64
+	deadline := time.Now().Add(doctorShortTTL)
+	s.store.ForEach(func(e store.Entry) bool {
+		if size := e.Size(); size >= doctorHugeKey {
+			hugeCount++
+			huge = top(huge, bigkey{e.Key, size}, 3)
+		}
+		if e.Expiry != nil && e.Expiry.Before(deadline) {
+			shortTTL++
+		}
+		return true
+	})
+	if hugeCount > 0 {
+		var names []string
+		for _, k := range huge {
+			names = append(names, fmt.Sprintf("'%s' (%s)", k.key, humanBytes(k.size)))
+		}
+		issues = append(issues, fmt.Sprintf("Huge keys: %d keys hold %s or more each, the largest %s. Reading, deleting or expiring one whole is O(N) and blocks the server meanwhile, and it cannot be spread across a cluster: split them into smaller keys, and list them with MEMORY BIGKEYS.",
+			hugeCount, humanBytes(doctorHugeKey), strings.Join(names, ", ")))
+	}
+	if shortTTL >= doctorManyShortTTL {
+		issues = append(issues, fmt.Sprintf("Many short-lived keys: %d keys expire within %s. Until the cleanup loop or an access reclaims them, expired keys keep holding memory, and recreating them keeps the allocator and the expire cycle busy: consider longer TTLs, keeping related values in fewer keys, or spreading expiry times with default_ttl_jitter.",
+			shortTTL, doctorShortTTL))
+	}
+
+	var bigClients, bigReplicas int
+	var biggestClient, biggestReplica int64
+	for _, sess := range s.sessions() {
+		parts, _ := sess.mem.get()
+		output := parts[memOutput] + parts[memPush]
+		if sess.outputClass() == "replica" {
+			if output >= doctorReplicaOutput {
+				bigReplicas++
+			}
+			biggestReplica = max(biggestReplica, output)
+		} else {
+			if output >= doctorClientOutput {
+				bigClients++
+			}
+			biggestClient = max(biggestClient, output)
+		}
+	}
+	if bigClients > 0 {
+		issues = append(issues, fmt.Sprintf("Big client output buffers: %d clients have %s or more of replies or messages waiting to be read, the most %s. They are slow to read what they asked for, like big LRANGE or KEYS replies, or Pub/Sub subscribers falling behind: check CLIENT LIST's omem, and bound them with client-output-buffer-limit and maxmemory-clients.",
+			bigClients, humanBytes(doctorClientOutput), humanBytes(biggestClient)))
+	}
+	if bigReplicas > 0 {
+		issues = append(issues, fmt.Sprintf("Big replica output buffers: %d replicas have %s or more of the command stream waiting to be sent, the most %s. The link to them is too slow for the write traffic: check the network, and raise the replica class of client-output-buffer-limit if they keep being disconnected.",
+			bigReplicas, humanBytes(doctorReplicaOutput), humanBytes(biggestReplica)))
+	}
+
+	if s.aof != nil {
+		pending, queued := s.aof.Backlog()
+		if pending > 0 {
+			issues = append(issues, fmt.Sprintf("AOF buffer growth: %s of commands could not be written to the AOF and are held in memory until the disk accepts them (%v). Free up or fix the disk.",
+				humanBytes(pending), s.aof.WriteErr()))
+		}
+		if queued >= doctorAOFQueued {
+			issues = append(issues, fmt.Sprintf("AOF buffer growth: %d commands are waiting for the AOF writer, which is falling behind the writes. The disk is too slow for appendfsync always, or saturated: consider appendfsync everysec, or a faster disk.", queued))
+		}
+	}
+
+	if len(issues) == 0 {
+		return "Dave, I can't find any memory issue in this server. I can only account for what it holds right now.\n"
+	}
+	var b strings.Builder
+	b.WriteString("Dave, I have found a few memory issues in this server:\n\n")
+	for _, issue := range issues {
+		fmt.Fprintf(&b, " * %s\n\n", issue)
+	}
+	b.WriteString("I'm here to keep you safe, Dave. I want to help you.\n")
+	return b.String()
+}
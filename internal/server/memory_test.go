@@ -0,0 +1,105 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"redis-from-scratch/internal/protocol"
+)
+
+func TestMemoryBigkeys(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("SET", "short", "v")
+	c.expect("OK")
+	c.send("SET", "long", strings.Repeat("v", 1000))
+	c.expect("OK")
+	c.send("RPUSH", "few", "a", "b", "c")
+	c.read()
+	c.send("RPUSH", "many", "a", "b", "c", "d", "e")
+	c.read()
+	c.send("RPUSH", "wide", strings.Repeat("x", 500))
+	c.read()
+
+	c.send("MEMORY", "BIGKEYS", "COUNT", "2")
+	r, err := c.parser.ReadReply()
+	if err != nil || len(r.Array) != 10 {
+		t.Fatalf("expected a map of 5 types, got %+v (%v)", r, err)
+	}
+	field := func(m protocol.Reply, name string) protocol.Reply {
+		t.Helper()
+		for i := 0; i+1 < len(m.Array); i += 2 {
+			if m.Array[i].Str == name {
+				return m.Array[i+1]
+			}
+		}
+		t.Fatalf("expected %s in %+v", name, m)
+		return protocol.Reply{}
+	}
+	keys := func(m protocol.Reply) []string {
+		var out []string
+		for i := 0; i < len(m.Array); i += 2 {
+			out = append(out, m.Array[i].Str)
+		}
+		return out
+	}
+
+	str := field(r, "string")
+	if field(str, "keys").Int != 2 || field(str, "elements").Int != 1001 {
+		t.Fatalf("unexpected string stats %+v", str)
+	}
+	if got := keys(field(str, "biggest_by_bytes")); len(got) != 2 || got[0] != "long" || got[1] != "short" {
+		t.Fatalf("expected long then short, got %q", got)
+	}
+	list := field(r, "list")
+	if field(list, "keys").Int != 3 || field(list, "elements").Int != 9 {
+		t.Fatalf("unexpected list stats %+v", list)
+	}
+	byElements := field(list, "biggest_by_elements")
+	if got := keys(byElements); len(got) != 2 || got[0] != "many" || got[1] != "few" || byElements.Array[1].Int != 5 {
+		t.Fatalf("expected many then few by elements, got %+v", byElements)
+	}
+	if got := keys(field(list, "biggest_by_bytes")); got[0] != "wide" {
+		t.Fatalf("expected wide to be the largest list in bytes, got %q", got)
+	}
+	if hash := field(r, "hash"); field(hash, "keys").Int != 0 || len(field(hash, "biggest_by_bytes").Array) != 0 {
+		t.Fatalf("expected no hashes, got %+v", hash)
+	}
+
+	c.send("MEMORY", "BIGKEYS", "COUNT", "0")
+	c.expect("-ERR count should be greater than 0")
+	c.send("MEMORY", "NOPE")
+	c.expect("-ERR unknown subcommand 'NOPE'. Try MEMORY HELP.")
+}
+
+func TestMemoryDoctor(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("MEMORY", "DOCTOR")
+	if got := c.read()[0]; !strings.Contains(got, "can't find any memory issue") {
+		t.Fatalf("expected no issue, got %q", got)
+	}
+
+	srv.store.Set("huge", strings.Repeat("x", doctorHugeKey), 0)
+	for i := 0; i < doctorManyShortTTL; i++ {
+		srv.store.Set("session:"+strconv.Itoa(i), "v", 30000)
+	}
+	c.send("MEMORY", "DOCTOR")
+	got := c.read()[0]
+	if !strings.Contains(got, "Huge keys: 1 keys") || !strings.Contains(got, "'huge' (10.00M)") {
+		t.Fatalf("expected the huge key reported, got %q", got)
+	}
+	if !strings.Contains(got, "Many short-lived keys: 100000 keys expire within 1m0s") {
+		t.Fatalf("expected the short-lived keys reported, got %q", got)
+	}
+	if strings.Contains(got, "output buffers") || strings.Contains(got, "AOF") {
+		t.Fatalf("expected no other issue, got %q", got)
+	}
+}
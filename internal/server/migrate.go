@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+	"redis-from-scratch/internal/store"
+)
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key ...]
+// moves keys to another server, as Redis does to reshard a cluster: each
+// key is sent with DUMP's payload and its remaining TTL as RESTORE-ASKING,
+// which a target importing the slot accepts, and deleted here once
+// the target replied, unless COPY is given. With REPLACE existing keys on
+// the target are overwritten; otherwise the target refuses them. Writes are
+// paused while it runs, so no write to a key is lost between sending it and
+// deleting it. The reply is NOKEY if none of the keys exist.
+func (s *Server) cmdMigrate(args []string) command.Response {
+	if len(args) < 5 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'migrate' command")}
+	}
+	addr := net.JoinHostPort(args[0], args[1])
+	keys := []string{args[2]}
+	db, err := strconv.Atoi(args[3])
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+	}
+	ms, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	var copyKeys, replace bool
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "KEYS":
+			if args[2] != "" {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")}
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+	}
+	if db != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR DB index is out of range")}
+	}
+	if !copyKeys && s.readOnlyReplica() {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("READONLY You can't write against a read only replica.")}
+	}
+	es, ok := s.store.(store.EntryStore)
+	if !ok {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR MIGRATE is not supported by this storage backend")}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	var entries []store.Entry
+	for _, key := range keys {
+		if e, ok := es.Lookup(key); ok {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return command.Response{Type: command.TypeSimpleString, Value: "NOKEY"}
+	}
+
+	moved, err := s.migrateEntries(addr, timeout, entries, replace)
+	if !copyKeys && len(moved) > 0 {
+		s.applyWriteLocked(nil, "DEL", moved)
+	}
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: err}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// migrateEntries restores entries on the server at addr, each I/O bounded
+// by timeout, and returns the keys the target accepted, stopping at the
+// first error. The target is reached over TLS with tls_cluster and
+// authenticated with masterauth, like the other nodes.
+func (s *Server) migrateEntries(addr string, timeout time.Duration, entries []store.Entry, replace bool) ([]string, error) {
+	conn, err := s.dialLink(addr, timeout, s.cfg.TLSCluster)
+	if err != nil {
+		return nil, fmt.Errorf("IOERR error or timeout connecting to the client: %v", err)
+	}
+	defer conn.Close()
+	c := protocol.NewClient(conn)
+	do := func(args ...string) error {
+		conn.SetDeadline(time.Now().Add(timeout))
+		r, err := c.Do(args...)
+		if r.Kind == '-' {
+			return fmt.Errorf("ERR Target instance replied with error: %s", r.Str)
+		}
+		if err != nil {
+			return fmt.Errorf("IOERR error or timeout reading to target instance")
+		}
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := s.authLink(c); err != nil {
+		return nil, fmt.Errorf("ERR Target instance replied with error: %v", err)
+	}
+
+	var moved []string
+	for _, e := range entries {
+		ttl := int64(0)
+		if e.Expiry != nil {
+			ttl = max(time.Until(*e.Expiry).Milliseconds(), 1)
+		}
+		payload, err := store.DumpValue(e)
+		if err != nil {
+			return moved, fmt.Errorf("ERR %v", err)
+		}
+		args := []string{"RESTORE-ASKING", e.Key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			args = append(args, "REPLACE")
+		}
+		if err := do(args...); err != nil {
+			return moved, err
+		}
+		moved = append(moved, e.Key)
+	}
+	return moved, nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/protocol"
+	"redis-from-scratch/pkg/config"
+)
+
+func TestDumpRestore(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c, err := protocol.Dial(net.JoinHostPort("localhost", strconv.Itoa(port)), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Do("HSET", "h", "f", "v")
+	r, err := c.Do("DUMP", "h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := r.Str
+	if r, _ := c.Do("DUMP", "missing"); !r.Null {
+		t.Fatalf("expected DUMP of a missing key to be null, got %+v", r)
+	}
+
+	if _, err := c.Do("RESTORE", "h", "0", payload); err == nil || err.Error() != "BUSYKEY Target key name already exists." {
+		t.Fatalf("expected RESTORE onto an existing key to fail, got %v", err)
+	}
+	if _, err := c.Do("RESTORE", "h2", "5000", payload); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Do("HGET", "h2", "f"); r.Str != "v" {
+		t.Fatalf("expected the restored hash, got %+v", r)
+	}
+	if _, err := c.Do("RESTORE", "h", "0", payload[:len(payload)-1]+"x", "REPLACE"); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a corrupt payload to be refused, got %v", err)
+	}
+	// A deadline in the past deletes the key it replaces.
+	if _, err := c.Do("RESTORE", "h", "1", payload, "REPLACE", "ABSTTL"); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Do("EXISTS", "h"); r.Int != 0 {
+		t.Fatalf("expected an already expired RESTORE to leave no key, got %+v", r)
+	}
+}
+
+func TestMigrateOptions(t *testing.T) {
+	src, sport := startTestServer(t)
+	defer src.Stop()
+	dst, dport := startTestServer(t)
+	defer dst.Stop()
+	migrate := func(args ...string) string {
+		return sendCommand(t, sport, append([]string{"MIGRATE", "localhost", strconv.Itoa(dport)}, args...))
+	}
+
+	sendCommand(t, sport, []string{"SET", "k", "new"})
+	sendCommand(t, dport, []string{"SET", "k", "old"})
+	if resp := migrate("k", "0", "1000"); !strings.HasPrefix(resp, "-ERR Target instance replied with error: BUSYKEY") {
+		t.Fatalf("expected an existing target key to be refused, got %q", resp)
+	}
+	if resp := sendCommand(t, sport, []string{"GET", "k"}); resp != "$3\r\nnew\r\n" {
+		t.Fatalf("expected a failed MIGRATE to keep the key, got %q", resp)
+	}
+	if resp := migrate("k", "0", "1000", "COPY", "REPLACE"); resp != "+OK\r\n" {
+		t.Fatalf("MIGRATE COPY REPLACE failed: %q", resp)
+	}
+	if resp := sendCommand(t, dport, []string{"GET", "k"}); resp != "$3\r\nnew\r\n" {
+		t.Fatalf("expected REPLACE to overwrite the target key, got %q", resp)
+	}
+	if resp := sendCommand(t, sport, []string{"GET", "k"}); resp != "$3\r\nnew\r\n" {
+		t.Fatalf("expected COPY to keep the key, got %q", resp)
+	}
+	if resp := migrate("k", "1", "1000"); resp != "-ERR DB index is out of range\r\n" {
+		t.Fatalf("expected a non-zero database to be refused, got %q", resp)
+	}
+	if resp := migrate("k", "0", "1000", "KEYS", "k"); !strings.Contains(resp, "must be set to the empty string") {
+		t.Fatalf("expected KEYS with a key argument to be refused, got %q", resp)
+	}
+}
+
+func TestRestoreLimits(t *testing.T) {
+	src, sport := startTestServer(t)
+	defer src.Stop()
+	sendCommand(t, sport, []string{"HSET", "h", "f1", "value"})
+	sendCommand(t, sport, []string{"HSET", "h", "f2", "v"})
+	sendCommand(t, sport, []string{"HSET", "h", "f3", "v"})
+	c, err := protocol.Dial(net.JoinHostPort("localhost", strconv.Itoa(sport)), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r, err := c.Do("DUMP", "h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := r.Str
+
+	for _, tc := range []struct {
+		limit func(cfg *config.Config)
+		want  string
+	}{
+		{func(cfg *config.Config) { cfg.MaxValueSize = 4 }, "ERR value size 5 exceeds max-value-size (4 bytes)"},
+		{func(cfg *config.Config) { cfg.MaxCollectionLen = 2 }, "ERR collection would hold 3 entries, exceeding max-collection-entries (2)"},
+		{func(cfg *config.Config) { cfg.MaxMemory, cfg.MaxMemoryPolicy = 1, "noeviction" }, "OOM"},
+	} {
+		cfg := testConfig()
+		tc.limit(cfg)
+		dst, dport := startTestServerWithConfig(t, cfg)
+		sendCommand(t, dport, []string{"SET", "k", "v"})
+		d, err := protocol.Dial(net.JoinHostPort("localhost", strconv.Itoa(dport)), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Do("RESTORE", "h", "0", payload); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("expected RESTORE to be refused with %q, got %v", tc.want, err)
+		}
+		if r, _ := d.Do("EXISTS", "h"); r.Int != 0 {
+			t.Errorf("expected a refused RESTORE to leave no key, got %+v", r)
+		}
+		d.Close()
+		dst.Stop()
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/pkg/config"
+)
+
+// errOutputLimit is returned by a write that took a connection over its
+// output buffer limit.
+var errOutputLimit = errors.New("output buffer limit reached")
+
+// outputClasses are the classes of clients client-output-buffer-limit
+// sets limits for, in the order CONFIG GET reports them.
+var outputClasses = []string{"normal", "replica", "pubsub"}
+
+// outputLimit is the client-output-buffer-limit of a class of clients: a
+// client whose pending output goes over hard, or stays over soft for more
+// than softTime, is disconnected. A limit of 0 is none.
+type outputLimit struct {
+	hard, soft int64
+	softTime   time.Duration
+}
+
+// exceeded reports whether a client with pending bytes of output waiting
+// went over l. since is when it went over the soft limit, kept up to date.
+func (l outputLimit) exceeded(pending int64, since *time.Time) bool {
+	if l.hard > 0 && pending > l.hard {
+		return true
+	}
+	if l.soft == 0 || pending <= l.soft {
+		*since = time.Time{}
+		return false
+	}
+	now := time.Now()
+	if since.IsZero() {
+		*since = now
+	}
+	return now.Sub(*since) > l.softTime
+}
+
+// outputLimits are the limits of each class of clients.
+type outputLimits struct {
+	mu      sync.Mutex
+	classes map[string]outputLimit
+}
+
+// get returns the limit of a class of clients.
+func (l *outputLimits) get(class string) outputLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.classes[class]
+}
+
+// set changes the limits of the classes in value, given as "class hard
+// soft seconds" groups with sizes as in redis.conf; the others keep
+// theirs. "slave" stands for replica.
+func (l *outputLimits) set(value string) error {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return fmt.Errorf("wrong number of arguments")
+	}
+	classes := make(map[string]outputLimit)
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = "replica"
+		}
+		if class != "normal" && class != "replica" && class != "pubsub" {
+			return fmt.Errorf("invalid client class '%s'", fields[i])
+		}
+		hard, err := config.ParseSize(fields[i+1])
+		if err != nil || hard < 0 {
+			return fmt.Errorf("invalid hard limit '%s'", fields[i+1])
+		}
+		soft, err := config.ParseSize(fields[i+2])
+		if err != nil || soft < 0 {
+			return fmt.Errorf("invalid soft limit '%s'", fields[i+2])
+		}
+		secs, err := strconv.Atoi(fields[i+3])
+		if err != nil || secs < 0 {
+			return fmt.Errorf("invalid soft limit seconds '%s'", fields[i+3])
+		}
+		classes[class] = outputLimit{hard: hard, soft: soft, softTime: time.Duration(secs) * time.Second}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.classes == nil {
+		l.classes = make(map[string]outputLimit)
+	}
+	for class, limit := range classes {
+		l.classes[class] = limit
+	}
+	return nil
+}
+
+// String renders the limits the way CONFIG GET reports them.
+func (l *outputLimits) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	parts := make([]string, 0, len(outputClasses))
+	for _, class := range outputClasses {
+		limit := l.classes[class]
+		parts = append(parts, fmt.Sprintf("%s %d %d %d", class, limit.hard, limit.soft, int64(limit.softTime.Seconds())))
+	}
+	return strings.Join(parts, " ")
+}
+
+// reply is the output of the reply being written to a connection, checked
+// against the limit of the connection's class as it is written. deadline
+// is the write deadline, if any.
+type reply struct {
+	limit     outputLimit
+	bytes     int64
+	softSince time.Time
+	deadline  time.Time
+}
+
+// outputWriter writes a connection's replies. A reply is written to the
+// socket as it is encoded, so what a client has yet to read of it is what
+// Redis would hold in the client's output buffer: a reply over the hard
+// limit closes the connection, and one over the soft limit must be read
+// within the soft limit's time.
+type outputWriter struct {
+	sess *session
+}
+
+func (o outputWriter) Write(p []byte) (int, error) {
+	sess := o.sess
+	r := &sess.reply
+	r.bytes += int64(len(p))
+	sess.mem.set(memOutput, r.bytes)
+	if r.limit.exceeded(r.bytes, &r.softSince) {
+		sess.closeForOutput(r.bytes)
+		return 0, errOutputLimit
+	}
+	if !r.softSince.IsZero() {
+		if d := r.softSince.Add(r.limit.softTime); r.deadline.IsZero() || d.Before(r.deadline) {
+			sess.conn.SetWriteDeadline(d)
+		}
+	}
+	n, err := sess.conn.Write(p)
+	var netErr net.Error
+	if !r.softSince.IsZero() && errors.As(err, &netErr) && netErr.Timeout() {
+		sess.closeForOutput(r.bytes - int64(n))
+	}
+	return n, err
+}
+
+// closeForOutput disconnects a client over its output buffer limit.
+func (sess *session) closeForOutput(pending int64) {
+	logging.Warnf("Client id=%d closed for overcoming of output buffer limits (%d bytes pending)", sess.id, pending)
+	sess.conn.Close()
+}
+
+// outputClass is the connection's class for its output buffer limit.
+func (sess *session) outputClass() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.clientType()
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputLimitsConfig(t *testing.T) {
+	srv, port := startTestServerWithConfig(t, testConfig())
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("CONFIG", "SET", "client-output-buffer-limit", "normal 1mb 512kb 10 slave 0 0 0")
+	c.expect("OK")
+	c.send("CONFIG", "GET", "client-output-buffer-limit")
+	c.expect("client-output-buffer-limit", "normal 1048576 524288 10 replica 0 0 0 pubsub 0 0 0")
+	c.send("CONFIG", "SET", "client-output-buffer-limit", "pubsub 32mb 8mb")
+	if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-ERR") {
+		t.Fatalf("expected an error for a missing argument, got %q", got)
+	}
+	c.send("CONFIG", "SET", "client-output-buffer-limit", "master 0 0 0")
+	if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-ERR") {
+		t.Fatalf("expected an error for an unknown class, got %q", got)
+	}
+}
+
+func TestOutputLimitExceeded(t *testing.T) {
+	var since time.Time
+	l := outputLimit{hard: 100, soft: 50, softTime: 20 * time.Millisecond}
+	if l.exceeded(40, &since) || !since.IsZero() {
+		t.Fatal("under the soft limit should pass")
+	}
+	if l.exceeded(60, &since) || since.IsZero() {
+		t.Fatal("going over the soft limit should start its clock")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !l.exceeded(60, &since) {
+		t.Fatal("staying over the soft limit should exceed it")
+	}
+	since = time.Time{}
+	if !l.exceeded(101, &since) {
+		t.Fatal("going over the hard limit should exceed it")
+	}
+	if (outputLimit{}).exceeded(1<<40, &since) {
+		t.Fatal("no limit should never be exceeded")
+	}
+}
+
+func TestOutputHardLimit(t *testing.T) {
+	srv, port := startTestServerWithConfig(t, testConfig())
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	c.send("RPUSH", "list", strings.Repeat("a", 1000), strings.Repeat("b", 1000))
+	c.expect("2")
+	c.send("CONFIG", "SET", "client-output-buffer-limit", "normal 1500 0 0")
+	c.expect("OK")
+	c.send("LRANGE", "list", "0", "0")
+	c.expect(strings.Repeat("a", 1000))
+	c.send("LRANGE", "list", "0", "-1")
+	if _, err := io.ReadAll(c.conn); err != nil {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+	if got := sendCommand(t, port, []string{"EXISTS", "list"}); got != ":1\r\n" {
+		t.Fatalf("expected other clients to be served, got %q", got)
+	}
+}
+
+func TestOutputSoftLimit(t *testing.T) {
+	srv, port := startTestServerWithConfig(t, testConfig())
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	value := strings.Repeat("v", 1<<20)
+	for i := 0; i < 16; i++ {
+		c.send("RPUSH", "list", value)
+		c.read()
+	}
+	c.send("CONFIG", "SET", "client-output-buffer-limit", "normal 0 1mb 0")
+	c.expect("OK")
+	// The reply does not fit in the socket buffers of a client that does
+	// not read it.
+	slow := dialTest(t, port)
+	slow.send("LRANGE", "list", "0", "-1")
+	waitFor(t, "the slow client to be disconnected", func() bool {
+		return strings.Count(sendCommand(t, port, []string{"CLIENT", "LIST"}), "id=") == 2
+	})
+}
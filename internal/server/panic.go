@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// errPanicked is the error of work that panicked and was recovered from,
+// already logged.
+var errPanicked = errors.New("recovered from a panic")
+
+// panicked logs a panic recovered from in what, with the stack of the
+// goroutine that panicked, and counts it as panics_recovered in INFO stats.
+// A panic is a bug: rather than taking every client down with the process,
+// the server ends the connection or the background work it happened in,
+// and background loops carry on with their next round. A panic while a lock
+// is held can still leave the server stuck.
+func (s *Server) panicked(what string, r any) {
+	s.stats.panics.Add(1)
+	logging.Errorf("Panic in %s, recovered: %v\n%s", what, r, debug.Stack())
+}
+
+// recoverPanic, deferred, recovers from a panic in the background work
+// what, if any.
+func (s *Server) recoverPanic(what string) {
+	if r := recover(); r != nil {
+		s.panicked(what, r)
+	}
+}
+
+// describe names the connection in logs.
+func (sess *session) describe() string {
+	return fmt.Sprintf("connection id=%d addr=%s", sess.id, sess.conn.RemoteAddr())
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+// panicListener accepts connections that panic when written a reply
+// holding "PANICNOW", standing in for a bug on the connection's goroutine.
+type panicListener struct{ net.Listener }
+
+func (l panicListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	return panicConn{conn}, err
+}
+
+type panicConn struct{ net.Conn }
+
+func (c panicConn) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("PANICNOW")) {
+		panic("boom")
+	}
+	return c.Conn.Write(p)
+}
+
+func TestConnectionPanic(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		cfg := testConfig()
+		cfg.Workers = workers
+		srv := New(cfg)
+		ln := testListener(t)
+		serveTestListener(srv, panicListener{ln})
+		port := ln.Addr().(*net.TCPAddr).Port
+
+		c := dialTest(t, port)
+		c.send("ECHO", "PANICNOW")
+		if _, err := c.parser.ReadReply(); err == nil {
+			t.Fatalf("workers %d: expected the connection to be closed", workers)
+		}
+		c = dialTest(t, port)
+		c.send("SET", "k", "PANICNOW")
+		c.expect("OK")
+		c.send("GET", "k")
+		if _, err := c.parser.ReadReply(); err == nil {
+			t.Fatalf("workers %d: expected the connection to be closed", workers)
+		}
+
+		c = dialTest(t, port)
+		c.send("INFO", "stats")
+		if info := c.read()[0]; !strings.Contains(info, "panics_recovered:2\r\n") {
+			t.Fatalf("workers %d: expected 2 panics recovered, got %q", workers, info)
+		}
+		waitFor(t, "the connections that panicked to be removed", func() bool { return len(srv.sessions()) == 1 })
+		srv.Stop()
+	}
+}
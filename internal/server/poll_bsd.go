@@ -0,0 +1,83 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package server
+
+import "syscall"
+
+// pollAPI is the readiness API the event loop uses, as INFO reports it.
+const pollAPI = "kqueue"
+
+// poll is a kqueue, and a pipe that wakes its waiter up to stop.
+type poll struct {
+	kq     int
+	pipe   [2]int
+	events []syscall.Kevent_t
+}
+
+func openPoll() (*poll, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(kq)
+	p := &poll{kq: kq, events: make([]syscall.Kevent_t, 128)}
+	if err := syscall.Pipe(p.pipe[:]); err != nil {
+		syscall.Close(kq)
+		return nil, err
+	}
+	syscall.CloseOnExec(p.pipe[0])
+	syscall.CloseOnExec(p.pipe[1])
+	if err := p.add(p.pipe[0]); err != nil {
+		p.release()
+		return nil, err
+	}
+	return p, nil
+}
+
+// add watches fd until it is readable or hung up.
+func (p *poll) add(fd int) error {
+	return p.change(fd, syscall.EV_ADD)
+}
+
+// remove stops watching fd.
+func (p *poll) remove(fd int) error {
+	return p.change(fd, syscall.EV_DELETE)
+}
+
+func (p *poll) change(fd, flags int) error {
+	var ev syscall.Kevent_t
+	syscall.SetKevent(&ev, fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(p.kq, []syscall.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+// wait blocks until some of the watched descriptors are ready and appends
+// them to ready, or returns errPollClosed once wakeup was called.
+func (p *poll) wait(ready []int) ([]int, error) {
+	n, err := syscall.Kevent(p.kq, nil, p.events, nil)
+	if err == syscall.EINTR {
+		return ready, nil
+	}
+	if err != nil {
+		return ready, err
+	}
+	for _, ev := range p.events[:n] {
+		if int(ev.Ident) == p.pipe[0] {
+			return ready, errPollClosed
+		}
+		ready = append(ready, int(ev.Ident))
+	}
+	return ready, nil
+}
+
+// wakeup makes wait return errPollClosed.
+func (p *poll) wakeup() {
+	syscall.Write(p.pipe[1], []byte{0})
+}
+
+// release closes the descriptors, once wait returned for good.
+func (p *poll) release() {
+	syscall.Close(p.pipe[0])
+	syscall.Close(p.pipe[1])
+	syscall.Close(p.kq)
+}
@@ -0,0 +1,74 @@
+package server
+
+import "syscall"
+
+// pollAPI is the readiness API the event loop uses, as INFO reports it.
+const pollAPI = "epoll"
+
+// poll is an epoll instance, and a pipe that wakes its waiter up to stop.
+type poll struct {
+	epfd   int
+	pipe   [2]int
+	events []syscall.EpollEvent
+}
+
+func openPoll() (*poll, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &poll{epfd: epfd, events: make([]syscall.EpollEvent, 128)}
+	if err := syscall.Pipe2(p.pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	if err := p.add(p.pipe[0]); err != nil {
+		p.release()
+		return nil, err
+	}
+	return p, nil
+}
+
+// add watches fd until it is readable or hung up.
+func (p *poll) add(fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+// remove stops watching fd.
+func (p *poll) remove(fd int) error {
+	// Kernels before 2.6.9 want an event even to remove.
+	var ev syscall.EpollEvent
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, &ev)
+}
+
+// wait blocks until some of the watched descriptors are ready and appends
+// them to ready, or returns errPollClosed once wakeup was called.
+func (p *poll) wait(ready []int) ([]int, error) {
+	n, err := syscall.EpollWait(p.epfd, p.events, -1)
+	if err == syscall.EINTR {
+		return ready, nil
+	}
+	if err != nil {
+		return ready, err
+	}
+	for _, ev := range p.events[:n] {
+		if int(ev.Fd) == p.pipe[0] {
+			return ready, errPollClosed
+		}
+		ready = append(ready, int(ev.Fd))
+	}
+	return ready, nil
+}
+
+// wakeup makes wait return errPollClosed.
+func (p *poll) wakeup() {
+	syscall.Write(p.pipe[1], []byte{0})
+}
+
+// release closes the descriptors, once wait returned for good.
+func (p *poll) release() {
+	syscall.Close(p.pipe[0])
+	syscall.Close(p.pipe[1])
+	syscall.Close(p.epfd)
+}
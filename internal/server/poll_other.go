@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package server
+
+import "errors"
+
+// pollAPI is the readiness API the event loop uses, as INFO reports it.
+const pollAPI = ""
+
+// poll is not available here: event_loop falls back to a goroutine per
+// connection.
+type poll struct{}
+
+func openPoll() (*poll, error) {
+	return nil, errors.New("no epoll or kqueue on this platform")
+}
+
+func (p *poll) add(fd int) error                { return nil }
+func (p *poll) remove(fd int) error             { return nil }
+func (p *poll) wait(ready []int) ([]int, error) { return ready, errPollClosed }
+func (p *poll) wakeup()                         {}
+func (p *poll) release()                        {}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// subscriberCommands are the commands a connection subscribed to a channel
+// may run.
+var subscriberCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+	"RESET":        true,
+}
+
+// subscriptionCount returns the number of channels and patterns the
+// connection is subscribed to.
+func (sess *session) subscriptionCount() int {
+	return len(sess.subscriptions) + len(sess.patterns)
+}
+
+// subscribed reports whether the connection is in subscriber mode.
+func (sess *session) subscribed() bool {
+	return sess.subscriptionCount() > 0
+}
+
+// Deliver queues a message published to one of the connection's channels.
+func (sess *session) Deliver(msg []string) {
+	push := make([]any, len(msg))
+	for i, e := range msg {
+		push[i] = e
+	}
+	sess.push(push)
+}
+
+// SUBSCRIBE channel [channel ...] subscribes the connection to the
+// channels, confirming each with ["subscribe", channel, count], count being
+// the number of channels and patterns it is subscribed to. PSUBSCRIBE
+// pattern [pattern ...] does the same for the channels matching the
+// patterns, with "psubscribe". It is called by sess.write, so the messages
+// published to a channel follow its confirmation.
+func (s *Server) cmdSubscribe(sess *session, w *protocol.Writer, cmd string, args []string) error {
+	if len(args) == 0 {
+		return w.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+	}
+	s.startPushLoop(sess)
+	subs, subscribe := &sess.subscriptions, s.pubsub.Subscribe
+	if cmd == "PSUBSCRIBE" {
+		subs, subscribe = &sess.patterns, s.pubsub.PSubscribe
+	}
+	for _, name := range args {
+		if !(*subs)[name] {
+			subscribe(sess, name)
+			sess.mu.Lock()
+			if *subs == nil {
+				*subs = make(map[string]bool)
+			}
+			(*subs)[name] = true
+			sess.mu.Unlock()
+		}
+		if err := w.WritePush([]any{strings.ToLower(cmd), name, sess.subscriptionCount()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UNSUBSCRIBE [channel ...] unsubscribes the connection from the channels,
+// or from all of them, confirming each like SUBSCRIBE; PUNSUBSCRIBE
+// [pattern ...] does the same for patterns. Without subscriptions of the
+// kind they reply ["unsubscribe", nil, count].
+func (s *Server) cmdUnsubscribe(sess *session, w *protocol.Writer, cmd string, args []string) error {
+	subs, unsubscribe := sess.subscriptions, s.pubsub.Unsubscribe
+	if cmd == "PUNSUBSCRIBE" {
+		subs, unsubscribe = sess.patterns, s.pubsub.PUnsubscribe
+	}
+	kind := strings.ToLower(cmd)
+	if len(args) == 0 {
+		if len(subs) == 0 {
+			return w.WritePush([]any{kind, nil, sess.subscriptionCount()})
+		}
+		for name := range subs {
+			args = append(args, name)
+		}
+		sort.Strings(args)
+	}
+	for _, name := range args {
+		if subs[name] {
+			unsubscribe(sess, name)
+			sess.mu.Lock()
+			delete(subs, name)
+			sess.mu.Unlock()
+		}
+		if err := w.WritePush([]any{kind, name, sess.subscriptionCount()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribeAll removes a closing connection's subscriptions.
+func (s *Server) unsubscribeAll(sess *session) {
+	for ch := range sess.subscriptions {
+		s.pubsub.Unsubscribe(sess, ch)
+	}
+	for pattern := range sess.patterns {
+		s.pubsub.PUnsubscribe(sess, pattern)
+	}
+	sess.mu.Lock()
+	sess.subscriptions, sess.patterns = nil, nil
+	sess.mu.Unlock()
+}
+
+// PUBLISH channel message sends message to the channel's subscribers and
+// replies with how many received it.
+func (s *Server) cmdPublish(args []string) command.Response {
+	if len(args) != 2 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'publish' command")}
+	}
+	return command.Response{Type: command.TypeInteger, Value: s.pubsub.Publish(args[0], args[1])}
+}
+
+// subscriberPing is PING's reply in subscriber mode: ["pong", message].
+func subscriberPing(args []string) command.Response {
+	if len(args) > 1 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'ping' command")}
+	}
+	msg := ""
+	if len(args) == 1 {
+		msg = args[0]
+	}
+	return command.Response{Type: command.TypeArray, Value: []string{"pong", msg}}
+}
+
+// notAllowedSubscribed is the error other commands get in subscriber mode.
+func notAllowedSubscribed(cmd string) command.Response {
+	return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(cmd))}
+}
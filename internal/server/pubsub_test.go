@@ -0,0 +1,190 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/protocol"
+)
+
+// testConn is a raw client connection, for replies pushed without a
+// command.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	parser *protocol.Parser
+}
+
+func dialTest(t *testing.T, port int) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testConn{t: t, conn: conn, parser: protocol.NewParser(conn)}
+}
+
+func (c *testConn) send(args ...string) {
+	c.t.Helper()
+	w := protocol.NewWriter(c.conn)
+	w.WriteArray(args)
+	if err := w.Flush(); err != nil {
+		c.t.Fatalf("failed to send %v: %v", args, err)
+	}
+}
+
+// read reads a reply, flattening an array or push to its elements, with
+// integers in decimal and nulls as "<nil>".
+func (c *testConn) read() []string {
+	c.t.Helper()
+	r, err := c.parser.ReadReply()
+	if err != nil {
+		c.t.Fatalf("failed to read a reply: %v", err)
+	}
+	elems := []protocol.Reply{r}
+	if r.Kind == '*' || r.Kind == '>' {
+		elems = r.Array
+	}
+	var out []string
+	for _, e := range elems {
+		switch {
+		case e.Null:
+			out = append(out, "<nil>")
+		case e.Kind == ':':
+			out = append(out, strconv.FormatInt(e.Int, 10))
+		case e.Kind == '-':
+			out = append(out, "-"+e.Str)
+		default:
+			out = append(out, e.Str)
+		}
+	}
+	return out
+}
+
+func (c *testConn) expect(want ...string) {
+	c.t.Helper()
+	if got := c.read(); !reflect.DeepEqual(got, want) {
+		c.t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	sub := dialTest(t, port)
+	defer sub.conn.Close()
+	sub.send("SUBSCRIBE", "news", "sport")
+	sub.expect("subscribe", "news", "1")
+	sub.expect("subscribe", "sport", "2")
+
+	if resp := sendCommand(t, port, []string{"PUBLISH", "news", "hello"}); resp != ":1\r\n" {
+		t.Fatalf("expected 1 receiver, got %q", resp)
+	}
+	sub.expect("message", "news", "hello")
+	if resp := sendCommand(t, port, []string{"PUBLISH", "weather", "rain"}); resp != ":0\r\n" {
+		t.Fatalf("expected no receivers, got %q", resp)
+	}
+
+	// Subscriber mode only allows the Pub/Sub commands and PING.
+	sub.send("GET", "k")
+	if got := sub.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-ERR Can't execute 'get'") {
+		t.Fatalf("expected GET to be refused, got %q", got)
+	}
+	sub.send("PING")
+	sub.expect("pong", "")
+
+	sub.send("UNSUBSCRIBE", "news")
+	sub.expect("unsubscribe", "news", "1")
+	sendCommand(t, port, []string{"PUBLISH", "news", "missed"})
+	sendCommand(t, port, []string{"PUBLISH", "sport", "goal"})
+	sub.expect("message", "sport", "goal")
+
+	sub.send("UNSUBSCRIBE")
+	sub.expect("unsubscribe", "sport", "0")
+	sub.send("UNSUBSCRIBE")
+	sub.expect("unsubscribe", "<nil>", "0")
+	sub.send("PING")
+	sub.expect("PONG")
+}
+
+func TestPatternSubscriptions(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	sub := dialTest(t, port)
+	defer sub.conn.Close()
+	sub.send("PSUBSCRIBE", "news.*", "h[ae]llo")
+	sub.expect("psubscribe", "news.*", "1")
+	sub.expect("psubscribe", "h[ae]llo", "2")
+	sub.send("SUBSCRIBE", "news.tech")
+	sub.expect("subscribe", "news.tech", "3")
+
+	if resp := sendCommand(t, port, []string{"PUBLISH", "news.tech", "go"}); resp != ":2\r\n" {
+		t.Fatalf("expected 2 deliveries, got %q", resp)
+	}
+	sub.expect("message", "news.tech", "go")
+	sub.expect("pmessage", "news.*", "news.tech", "go")
+	sendCommand(t, port, []string{"PUBLISH", "hillo", "no"})
+	sendCommand(t, port, []string{"PUBLISH", "hallo", "yes"})
+	sub.expect("pmessage", "h[ae]llo", "hallo", "yes")
+
+	sub.send("PUNSUBSCRIBE", "news.*")
+	sub.expect("punsubscribe", "news.*", "2")
+	sub.send("PUNSUBSCRIBE")
+	sub.expect("punsubscribe", "h[ae]llo", "1")
+	sub.send("PUNSUBSCRIBE")
+	sub.expect("punsubscribe", "<nil>", "1")
+	sub.send("UNSUBSCRIBE")
+	sub.expect("unsubscribe", "news.tech", "0")
+}
+
+func TestPubSubDisconnect(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	sub := dialTest(t, port)
+	sub.send("SUBSCRIBE", "news")
+	sub.expect("subscribe", "news", "1")
+	sub.send("PSUBSCRIBE", "n*")
+	sub.expect("psubscribe", "n*", "2")
+	sub.conn.Close()
+	waitFor(t, "the closed connection to be unsubscribed", func() bool {
+		return sendCommand(t, port, []string{"PUBLISH", "news", "hello"}) == ":0\r\n"
+	})
+}
+
+func TestPubSubRESP3(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	sub := dialTest(t, port)
+	defer sub.conn.Close()
+	sub.send("HELLO", "3")
+	sub.read()
+	sub.send("SUBSCRIBE", "news")
+	pushed := func(want ...string) {
+		t.Helper()
+		r, err := sub.parser.ReadReply()
+		if err != nil || r.Kind != '>' || strings.Join(r.Strings(), " ") != strings.Join(want, " ") {
+			t.Fatalf("expected the push %q, got %+v (%v)", want, r, err)
+		}
+	}
+	// The count is an integer, which Strings leaves empty.
+	pushed("subscribe", "news", "")
+
+	// Pushes are told from replies, so any command may run.
+	sub.send("SET", "k", "v")
+	sub.expect("OK")
+	if resp := sendCommand(t, port, []string{"PUBLISH", "news", "hello"}); resp != ":1\r\n" {
+		t.Fatalf("expected 1 receiver, got %q", resp)
+	}
+	pushed("message", "news", "hello")
+	sub.send("PING")
+	sub.expect("PONG")
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"redis-from-scratch/internal/command"
+)
+
+// renameCommands builds the names clients call commands by from
+// rename_command, which maps commands to new names: a renamed command
+// answers to its new name only, and one renamed to "" to none. The result
+// maps each changed name, upper-cased, to the command it runs, or to "" if
+// none; it is nil when nothing is renamed.
+func renameCommands(renames map[string]string) (map[string]string, error) {
+	if len(renames) == 0 {
+		return nil, nil
+	}
+	names := make(map[string]string, 2*len(renames))
+	for from := range renames {
+		from = strings.ToUpper(from)
+		if _, ok := command.Lookup(from); !ok {
+			return nil, fmt.Errorf("rename_command: no such command '%s'", from)
+		}
+		names[from] = ""
+	}
+	taken := make(map[string]bool)
+	for from, to := range renames {
+		from, to = strings.ToUpper(from), strings.ToUpper(to)
+		if to == "" {
+			continue
+		}
+		if _, renamed := names[to]; !renamed {
+			if _, ok := command.Lookup(to); ok {
+				return nil, fmt.Errorf("rename_command: '%s' is the name of another command", to)
+			}
+		}
+		if taken[to] {
+			return nil, fmt.Errorf("rename_command: '%s' is the new name of two commands", to)
+		}
+		taken[to] = true
+		names[to] = from
+	}
+	// A command renamed to another's name, as in a swap, keeps it.
+	for name := range names {
+		if !taken[name] {
+			names[name] = ""
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"redis-from-scratch/pkg/config"
+)
+
+func TestRenameCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	conf := "rename-command FLUSHALL \"\"\nrename-command CONFIG secret-config\nrename-command GET SET\nrename-command SET GET\n"
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("failed to load rename-command: %v", err)
+	}
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+
+	c.send("FLUSHALL")
+	c.expect("-ERR unknown command 'FLUSHALL'")
+	c.send("config", "GET", "save")
+	c.expect("-ERR unknown command 'config'")
+	c.send("SECRET-CONFIG", "GET", "save")
+	c.expect("save", "")
+
+	// GET and SET swapped names.
+	c.send("GET", "k", "v")
+	c.expect("OK")
+	c.send("SET", "k")
+	c.expect("v")
+}
+
+func TestRenameCommandErrors(t *testing.T) {
+	for _, renames := range []map[string]string{
+		{"NOSUCH": "x"},
+		{"FLUSHALL": "GET"},
+		{"FLUSHALL": "x", "FLUSHDB": "x"},
+	} {
+		if _, err := renameCommands(renames); err == nil {
+			t.Errorf("expected %v to be rejected", renames)
+		}
+	}
+}
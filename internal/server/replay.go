@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/store"
+)
+
+// replayProgressEvery is how often, in commands, replay reports progress.
+const replayProgressEvery = 100000
+
+// replayErrorsLogged caps how many failing commands replay logs in full.
+const replayErrorsLogged = 10
+
+// replayStats summarizes an AOF replay.
+type replayStats struct {
+	Applied int // commands that ran successfully
+	Expired int // commands whose key had already expired, so it was dropped
+	Failed  int // commands that returned an error
+}
+
+func (st replayStats) log(elapsed time.Duration) {
+	logging.Infof("Replayed AOF in %v: %d applied, %d expired, %d failed",
+		elapsed, st.Applied, st.Expired, st.Failed)
+}
+
+// loadAOF restores the dataset from the AOF: the preamble written by a
+// rewrite, if any, then the commands logged after it.
+func (s *Server) loadAOF(base []store.Entry, entries []persistence.AOFEntry) {
+	start := time.Now()
+	if base != nil {
+		l, ok := s.store.(store.Loader)
+		if !ok {
+			s.loadErr = fmt.Errorf("the storage backend cannot load the AOF preamble")
+			logging.Errorf("%v", s.loadErr)
+			return
+		}
+		l.Restore(base)
+		logging.Infof("Loaded %d keys from the AOF preamble in %v", len(base), time.Since(start))
+	}
+	if len(entries) > 0 {
+		replayCommands(s.store, entries).log(time.Since(start))
+	}
+}
+
+// replayCommands applies AOF entries to kv. Commands that fail, such as a
+// WRONGTYPE from a hand-edited file, are counted and logged but do not stop
+// the replay.
+func replayCommands(kv store.KV, entries []persistence.AOFEntry) replayStats {
+	var st replayStats
+	now := time.Now()
+	for i, e := range entries {
+		if i > 0 && i%replayProgressEvery == 0 {
+			logging.Infof("Replaying AOF: %d/%d commands", i, len(entries))
+		}
+		resp := command.Execute(kv, e.Command, e.Args)
+		switch {
+		case resp.Type == command.TypeError:
+			st.Failed++
+			if st.Failed <= replayErrorsLogged {
+				logging.Warnf("AOF command %d (%s) failed: %v", i+1, e.Command, resp.Error)
+			}
+		case expiredBefore(e, now):
+			st.Expired++
+		default:
+			st.Applied++
+		}
+	}
+	return st
+}
+
+// expiredBefore reports whether e sets an absolute deadline that has already
+// passed, in which case replaying it drops the key.
+func expiredBefore(e persistence.AOFEntry, now time.Time) bool {
+	var deadline string
+	switch e.Command {
+	case "SET":
+		for i := 2; i+1 < len(e.Args); i += 2 {
+			if strings.EqualFold(e.Args[i], "PXAT") {
+				deadline = e.Args[i+1]
+			}
+		}
+	case "PEXPIREAT":
+		if len(e.Args) == 2 {
+			deadline = e.Args[1]
+		}
+	}
+	ms, err := strconv.ParseInt(deadline, 10, 64)
+	return err == nil && ms <= now.UnixMilli()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/store"
+)
+
+func TestReplayCommandsStats(t *testing.T) {
+	past := fmt.Sprint(time.Now().Add(-time.Minute).UnixMilli())
+	future := fmt.Sprint(time.Now().Add(time.Minute).UnixMilli())
+	entries := []persistence.AOFEntry{
+		{Command: "SET", Args: []string{"k", "v"}},
+		{Command: "SET", Args: []string{"gone", "v", "PXAT", past}},
+		{Command: "SET", Args: []string{"kept", "v", "PXAT", future}},
+		{Command: "LPUSH", Args: []string{"k", "x"}}, // WRONGTYPE
+		{Command: "NOSUCHCMD"},
+	}
+	s := store.New()
+	st := replayCommands(s, entries)
+	if st.Applied != 2 || st.Expired != 1 || st.Failed != 2 {
+		t.Fatalf("unexpected replay stats: %+v", st)
+	}
+	if s.Exists("gone") != 0 || s.Exists("kept") != 1 {
+		t.Fatalf("expected only the unexpired key to be loaded")
+	}
+}
+
+func TestCorruptAOFAbortsStartup(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+	os.WriteFile(filepath.Join(cfg.PersistencePath, "commands.aof"), []byte("*1\r\n$4\r\nPING\r\nGARBAGE\r\n*1\r\n$4\r\nPING\r\n"), 0644)
+
+	cfg.AOFAbortOnError = true
+	srv := New(cfg)
+	if err := srv.Start(); err == nil {
+		srv.Stop()
+		t.Fatalf("expected startup to fail on a corrupt AOF")
+	}
+	srv.Stop()
+
+	cfg.AOFAbortOnError = false
+	srv = New(cfg)
+	if srv.loadErr != nil {
+		t.Fatalf("expected startup to continue without aof_abort_on_error, got %v", srv.loadErr)
+	}
+	srv.Stop()
+}
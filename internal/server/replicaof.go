@@ -0,0 +1,505 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/protocol"
+	"redis-from-scratch/internal/store"
+)
+
+const (
+	// replTimeout bounds each step of the handshake with the master,
+	// including the transfer of the snapshot, and how long a master waits
+	// to hear from a replica.
+	replTimeout = 60 * time.Second
+	// replAckPeriod is how often a replica acknowledges its offset.
+	replAckPeriod = time.Second
+	// replRetryDelay is how long a replica waits before reconnecting.
+	replRetryDelay = time.Second
+)
+
+// masterLink is a replica's connection to its master. It is retried until
+// the replica is told to follow another master or none.
+type masterLink struct {
+	addr string
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	conn    net.Conn
+	stopped bool
+	// state is the link's state as ROLE reports it: connect, connecting,
+	// sync or connected.
+	state string
+	// lastIO is when the master last sent something, in Unix nanoseconds.
+	lastIO atomic.Int64
+	// failover is set when this server hands over to the master it
+	// connects to; see failover.go. The first PSYNC asks it to take over,
+	// and its outcome is sent here.
+	failover chan error
+}
+
+func newMasterLink(addr string) *masterLink {
+	return &masterLink{addr: addr, stop: make(chan struct{}), done: make(chan struct{}), state: "connect"}
+}
+
+// failoverDone reports the outcome of the handover the link was started for,
+// once.
+func (l *masterLink) failoverDone(err error) {
+	if l.failover != nil {
+		l.failover <- err
+		l.failover = nil
+	}
+}
+
+func (l *masterLink) setState(state string) {
+	l.mu.Lock()
+	l.state = state
+	l.mu.Unlock()
+}
+
+func (l *masterLink) getState() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// setConn records the current connection, so stopping can close it. It
+// reports false, closing conn, if the link was stopped meanwhile.
+func (l *masterLink) setConn(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		conn.Close()
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+func (l *masterLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	close(l.stop)
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+// readOnlyReplica reports whether clients' writes must be refused because
+// this server replicates a master and replica-read-only is set.
+func (s *Server) readOnlyReplica() bool {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	return s.repl.link != nil && s.repl.readOnly
+}
+
+// REPLICAOF host port makes the server a replica of another: it replaces its
+// dataset with the master's and then applies the master's write commands as
+// they happen. REPLICAOF NO ONE stops replicating and keeps the data.
+func (s *Server) cmdReplicaOf(args []string) command.Response {
+	if len(args) != 2 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'replicaof' command")}
+	}
+	if strings.EqualFold(args[0], "no") && strings.EqualFold(args[1], "one") {
+		if s.failingOver() {
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR REPLICAOF not allowed while failing over.")}
+		}
+		if s.stopReplicaOf() {
+			s.shiftReplID()
+			logging.Infof("MASTER MODE enabled")
+		}
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	}
+	if port, err := strconv.Atoi(args[1]); err != nil || port <= 0 || port > 65535 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Invalid master port")}
+	}
+	if s.failingOver() {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR REPLICAOF not allowed while failing over.")}
+	}
+	if !s.replicaOf(args[0], args[1]) {
+		return command.Response{Type: command.TypeSimpleString, Value: "OK Already connected to specified master"}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// replicaOf starts following the master at host:port, dropping the link to
+// the previous one. It reports false if that already is the master.
+func (s *Server) replicaOf(host, port string) bool {
+	addr := net.JoinHostPort(host, port)
+	s.repl.mu.Lock()
+	if s.repl.link != nil && s.repl.link.addr == addr {
+		s.repl.mu.Unlock()
+		return false
+	}
+	s.repl.mu.Unlock()
+	s.stopReplicaOf()
+
+	s.repl.mu.Lock()
+	s.followMaster(newMasterLink(addr))
+	s.repl.mu.Unlock()
+	return true
+}
+
+// followMaster makes l the link to our master and starts it. It is called
+// with repl.mu held, when there is no link.
+func (s *Server) followMaster(l *masterLink) {
+	s.repl.link = l
+	logging.Infof("Connecting to MASTER %s", l.addr)
+	s.wg.Add(1)
+	go s.runMasterLink(l)
+}
+
+// stopReplicaOf closes the link to the master, if there is one, and waits
+// for it to stop applying commands. It reports whether there was one.
+func (s *Server) stopReplicaOf() bool {
+	s.repl.mu.Lock()
+	l := s.repl.link
+	s.repl.mu.Unlock()
+	if l == nil {
+		return false
+	}
+	l.close()
+	<-l.done
+	// Only now may writes be propagated as this server's own.
+	s.repl.mu.Lock()
+	if s.repl.link == l {
+		s.repl.link = nil
+	}
+	s.repl.mu.Unlock()
+	return true
+}
+
+// runMasterLink syncs with the master and applies its command stream,
+// reconnecting whenever the connection is lost.
+func (s *Server) runMasterLink(l *masterLink) {
+	defer s.wg.Done()
+	defer close(l.done)
+	for {
+		err := s.syncWithMaster(l)
+		select {
+		case <-l.stop:
+			return
+		case <-s.quit:
+			return
+		default:
+		}
+		logging.Warnf("Connection with master %s lost: %v", l.addr, err)
+		select {
+		case <-time.After(replRetryDelay):
+		case <-l.stop:
+			return
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// syncWithMaster connects to the master, loads the snapshot it sends and
+// applies the commands that follow until the connection fails.
+func (s *Server) syncWithMaster(l *masterLink) (err error) {
+	defer l.setState("connect")
+	defer func() {
+		if err != nil {
+			l.failoverDone(err)
+		}
+	}()
+	// The link is retried after a panic, as after other errors.
+	defer func() {
+		if r := recover(); r != nil {
+			s.panicked("link to master "+l.addr, r)
+			err = errPanicked
+		}
+	}()
+	l.setState("connecting")
+	conn, err := s.dialLink(l.addr, replTimeout, s.cfg.TLSReplication)
+	if err != nil {
+		return err
+	}
+	if !l.setConn(conn) {
+		return nil
+	}
+	defer conn.Close()
+	// Stopping the server closes the connection to unblock reads.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.quit:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	br := bufio.NewReader(conn)
+	p := protocol.NewParser(br)
+	w := protocol.NewWriter(conn)
+	do := func(args ...string) (protocol.Reply, error) {
+		conn.SetDeadline(time.Now().Add(replTimeout))
+		w.WriteArray(args)
+		if err := w.Flush(); err != nil {
+			return protocol.Reply{}, err
+		}
+		r, err := p.ReadReply()
+		if err == nil {
+			err = r.Err()
+		}
+		return r, err
+	}
+	if _, pass := s.passwords(); pass != "" {
+		if _, err := do("AUTH", pass); err != nil {
+			return fmt.Errorf("AUTH failed: %w", err)
+		}
+	}
+	if _, err := do("PING"); err != nil {
+		return fmt.Errorf("PING failed: %w", err)
+	}
+	if _, err := do("REPLCONF", "listening-port", strconv.Itoa(s.listenPort())); err != nil {
+		return fmt.Errorf("REPLCONF failed: %w", err)
+	}
+	if _, err := do("REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return fmt.Errorf("REPLCONF failed: %w", err)
+	}
+	// Ask to continue from where our data is; a master that does not
+	// hold that history sends everything.
+	s.repl.mu.Lock()
+	id, offset := s.repl.id, s.repl.offset
+	s.repl.mu.Unlock()
+	l.setState("sync")
+	psync := []string{"PSYNC", id, strconv.FormatInt(offset+1, 10)}
+	if l.failover != nil {
+		psync = append(psync, "FAILOVER")
+	}
+	r, err := do(psync...)
+	if err != nil {
+		return fmt.Errorf("PSYNC failed: %w", err)
+	}
+	switch f := strings.Fields(r.Str); {
+	case len(f) >= 1 && f[0] == "CONTINUE":
+		logging.Infof("Successful partial resynchronization with master %s", l.addr)
+		if len(f) == 2 && f[1] != id {
+			// The master was promoted: its history continues ours. Our
+			// replicas reconnect to learn the new ID.
+			s.repl.mu.Lock()
+			s.repl.id2, s.repl.id2Offset = id, offset+1
+			s.repl.id = f[1]
+			s.repl.dropReplicas()
+			s.repl.mu.Unlock()
+		}
+	case len(f) == 3 && f[0] == "FULLRESYNC":
+		id = f[1]
+		if offset, err = strconv.ParseInt(f[2], 10, 64); err != nil {
+			return fmt.Errorf("unexpected reply to PSYNC: %s", r.Str)
+		}
+		logging.Infof("Full resync from master %s: %s:%d", l.addr, id, offset)
+		start := time.Now()
+		if err := s.loadFromMaster(br); err != nil {
+			return err
+		}
+		// Replicas of this server must sync again from the new dataset.
+		s.repl.mu.Lock()
+		s.repl.id, s.repl.offset = id, offset
+		s.repl.id2, s.repl.id2Offset = "", 0
+		s.repl.backlog = newBacklog(s.backlogSize(), offset)
+		s.repl.dropReplicas()
+		s.repl.mu.Unlock()
+		logging.Infof("Loaded %d keys from master %s in %v", s.store.Size(), l.addr, time.Since(start))
+		// The AOF still holds the old dataset: rewrite it from the new one.
+		if s.aof != nil {
+			s.startAOFRewrite()
+		}
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC: %s", r.Str)
+	}
+
+	conn.SetDeadline(time.Time{})
+	l.setState("connected")
+	l.failoverDone(nil)
+	l.lastIO.Store(time.Now().UnixNano())
+	getAck := make(chan struct{}, 1)
+	go s.sendAcks(conn, w, getAck, done)
+	var buf bytes.Buffer
+	for {
+		args, err := p.Parse()
+		if err != nil {
+			return err
+		}
+		l.lastIO.Store(time.Now().UnixNano())
+		if len(args) == 0 {
+			continue
+		}
+		// The stream is passed on to our own replicas and kept in the
+		// backlog as received, so they can later continue it from another
+		// server. A command is applied and passed on under writeMu, so a
+		// replica syncing from us meanwhile gets it either in its snapshot
+		// or in its stream, never both.
+		buf.Reset()
+		protocol.NewWriter(&buf).WriteArray(args)
+		s.writeMu.RLock()
+		// PING and SELECT keep the link alive and pick database 0.
+		switch cmd := strings.ToUpper(args[0]); {
+		case command.IsWrite(cmd):
+			s.applyWriteLocked(nil, cmd, args[1:])
+		case cmd == "REPLCONF" && len(args) >= 2 && strings.EqualFold(args[1], "GETACK"):
+			select {
+			case getAck <- struct{}{}:
+			default:
+			}
+		}
+		s.repl.mu.Lock()
+		s.repl.feed(buf.Bytes())
+		s.repl.mu.Unlock()
+		s.writeMu.RUnlock()
+	}
+}
+
+// sendAcks acknowledges the offset this replica reached to the master at
+// once, which tells a master that sent a diskless sync that it is loaded,
+// then every replAckPeriod and whenever the master asks, until done is
+// closed.
+func (s *Server) sendAcks(conn net.Conn, w *protocol.Writer, getAck <-chan struct{}, done <-chan struct{}) {
+	defer s.recoverPanic("replication acks")
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+	for {
+		s.repl.mu.Lock()
+		offset := s.repl.offset
+		s.repl.mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(replTimeout))
+		w.WriteArray([]string{"REPLCONF", "ACK", strconv.FormatInt(offset, 10)})
+		if err := w.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-getAck:
+		case <-done:
+			return
+		}
+	}
+}
+
+// loadFromMaster reads the snapshot the master sends after FULLRESYNC into
+// a temporary file and replaces the dataset with it.
+func (s *Server) loadFromMaster(br *bufio.Reader) error {
+	var line string
+	for {
+		var err error
+		if line, err = br.ReadString('\n'); err != nil {
+			return err
+		}
+		// The master may send newlines while it prepares the snapshot.
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			break
+		}
+	}
+	if line[0] == '-' {
+		return errors.New(line[1:])
+	}
+	// A diskless sync sends "$EOF:<mark>" and ends the snapshot with the
+	// mark instead of announcing its length.
+	mark, diskless := strings.CutPrefix(line, "$EOF:")
+	size, err := strconv.ParseInt(strings.TrimPrefix(line, "$"), 10, 64)
+	if diskless && len(mark) != eofMarkLen || !diskless && (line[0] != '$' || err != nil || size < 0) {
+		return fmt.Errorf("unexpected snapshot header from master: %q", line)
+	}
+
+	if s.cfg.PersistencePath != "" {
+		if err := os.MkdirAll(s.cfg.PersistencePath, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.CreateTemp(s.cfg.PersistencePath, "temp-sync-*.snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if diskless {
+		err = copyUntilMark(f, br, []byte(mark))
+	} else {
+		_, err = io.CopyN(f, br, size)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to receive snapshot: %w", err)
+	}
+
+	loader, ok := s.store.(store.Loader)
+	if !ok {
+		return fmt.Errorf("storage backend cannot load a snapshot")
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := persistence.LoadSnapshot(f.Name(), loader, s.encryption); err != nil {
+		return err
+	}
+	// The whole dataset was replaced, as by FLUSHALL.
+	s.tracking.Flush()
+	return nil
+}
+
+// eofMarkLen is the length of the mark ending a diskless transfer.
+const eofMarkLen = 40
+
+// copyUntilMark copies r to w until the mark that ends a diskless transfer,
+// which is not copied. The master sends nothing after the mark until it
+// has been acknowledged, so reads cannot run past it.
+func copyUntilMark(w io.Writer, r io.Reader, mark []byte) error {
+	buf := make([]byte, 0, 64<<10)
+	for {
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if bytes.HasSuffix(buf, mark) {
+			_, err := w.Write(buf[:len(buf)-len(mark)])
+			return err
+		}
+		// Keep what could be the start of the mark for the next read.
+		if keep := len(mark) - 1; len(buf) > keep {
+			if _, err := w.Write(buf[:len(buf)-keep]); err != nil {
+				return err
+			}
+			buf = buf[:copy(buf, buf[len(buf)-keep:])]
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// listenPort is the port this server accepts connections on: its TLS port
+// if replication uses TLS, so that its own replicas can connect.
+func (s *Server) listenPort() int {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	if s.cfg.TLSReplication && s.tlsListener != nil {
+		if addr, ok := s.tlsListener.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
+	if s.listener != nil {
+		if addr, ok := s.listener.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
+	return s.cfg.Port
+}
@@ -0,0 +1,498 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/protocol"
+	"redis-from-scratch/internal/store"
+	"redis-from-scratch/pkg/config"
+)
+
+// defaultBacklogSize is Redis' default repl-backlog-size.
+const defaultBacklogSize = 1 << 20
+
+// replication is the master side of replication: the replication ID and
+// offset, which count the bytes of command stream produced, the backlog of
+// recent stream and the replicas the stream is sent to.
+type replication struct {
+	mu       sync.Mutex
+	id       string
+	offset   int64
+	backlog  *backlog
+	replicas map[*replica]struct{}
+	buf      bytes.Buffer
+	syncs    int
+
+	// id2 is the ID of the history this server continued from when it
+	// was promoted, valid up to offset id2Offset, so replicas of the old
+	// master can continue from the new one.
+	id2       string
+	id2Offset int64
+
+	// link is the connection to our own master when this server is a
+	// replica; see replicaof.go. readOnly refuses clients' writes then.
+	link     *masterLink
+	readOnly bool
+
+	// failover is the FAILOVER in progress, if any; see failover.go.
+	failover *failover
+
+	// acked is closed and replaced whenever a replica acknowledges an
+	// offset, waking WAIT. minReplicas and maxLag are
+	// min-replicas-to-write and min-replicas-max-lag; see wait.go.
+	acked       chan struct{}
+	minReplicas int
+	maxLag      int
+}
+
+func newReplication(cfg *config.Config) replication {
+	return replication{
+		id:          newReplID(),
+		replicas:    make(map[*replica]struct{}),
+		readOnly:    cfg.ReplicaReadOnly,
+		acked:       make(chan struct{}),
+		minReplicas: cfg.MinReplicas,
+		maxLag:      cfg.MinReplicasMaxLag,
+	}
+}
+
+// newReplID returns a random 40 character replication ID.
+func newReplID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// replica is a connected replica. The command stream is queued in out and
+// written to the connection by the goroutine serving it. A replica whose
+// queue goes over the replica output buffer limit is disconnected and has
+// to sync again; softSince is when it went over the soft limit.
+type replica struct {
+	conn   net.Conn
+	addr   string
+	limits *outputLimits
+
+	mu        sync.Mutex
+	out       []byte
+	softSince time.Time
+	wake      chan struct{}
+	dropped   chan struct{}
+	once      sync.Once
+
+	// Guarded by replication.mu: how far the replica is in its sync,
+	// with Redis' names (wait_bgsave, send_bulk, then online once it
+	// receives the stream), and the offset it last acknowledged.
+	state     string
+	ackOffset int64
+	ackTime   time.Time
+}
+
+func (r *replica) online() bool {
+	return r.state == "online"
+}
+
+// feed queues part of the command stream, dropping the replica if it is
+// too far behind.
+func (r *replica) feed(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limits.get("replica").exceeded(int64(len(r.out)+len(p)), &r.softSince) {
+		logging.Warnf("Replica %s is %d bytes behind, disconnecting it", r.addr, len(r.out))
+		r.drop()
+		return
+	}
+	r.out = append(r.out, p...)
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *replica) drop() {
+	r.once.Do(func() { close(r.dropped) })
+}
+
+// propagate sends a write command that changed the dataset to the replicas.
+// args is the form logged to the AOF, with absolute expirations. A replica
+// passes on its master's stream instead, in syncWithMaster.
+func (s *Server) propagate(cmd string, args []string) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.backlog == nil || s.repl.link != nil {
+		return
+	}
+	s.repl.buf.Reset()
+	protocol.NewWriter(&s.repl.buf).WriteArray(append([]string{cmd}, args...))
+	s.repl.feed(s.repl.buf.Bytes())
+}
+
+// feed adds p to the replication stream. It is called with mu held.
+func (r *replication) feed(p []byte) {
+	r.offset += int64(len(p))
+	r.backlog.write(p)
+	for rep := range r.replicas {
+		rep.feed(p)
+	}
+}
+
+// dropReplicas disconnects every replica, so they sync again. It is called
+// with mu held.
+func (r *replication) dropReplicas() {
+	for rep := range r.replicas {
+		rep.drop()
+	}
+}
+
+// backlogSize is repl_backlog_size, or Redis' default if it is not set.
+func (s *Server) backlogSize() int64 {
+	if s.cfg.ReplBacklogSize > 0 {
+		return s.cfg.ReplBacklogSize
+	}
+	return defaultBacklogSize
+}
+
+// shiftReplID starts a new history on promotion to master, remembering the
+// old one so its replicas can still continue from this server. Those
+// already connected reconnect to learn the new ID.
+func (s *Server) shiftReplID() {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	s.repl.id2, s.repl.id2Offset = s.repl.id, s.repl.offset+1
+	s.repl.id = newReplID()
+	s.repl.dropReplicas()
+}
+
+// replicaConf is what a replica declares with REPLCONF before syncing.
+type replicaConf struct {
+	listeningPort string
+	// eof is set by "capa eof": the replica can read a snapshot of unknown
+	// length, ended by a mark, as a diskless sync sends it.
+	eof bool
+}
+
+// replconf answers the REPLCONF options a replica sends before syncing.
+func replconf(args []string, conf *replicaConf) command.Response {
+	if len(args)%2 != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch option := args[i]; {
+		case strings.EqualFold(option, "listening-port"):
+			conf.listeningPort = args[i+1]
+		case strings.EqualFold(option, "capa"):
+			if strings.EqualFold(args[i+1], "eof") {
+				conf.eof = true
+			}
+		case strings.EqualFold(option, "ip-address"):
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Unrecognized REPLCONF option: %s", option)}
+		}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// serveReplica takes over a connection that sent SYNC or PSYNC. A replica
+// that asks to continue a history this server holds in its backlog gets
+// the stream it missed; any other gets a snapshot of the dataset first.
+// Either way every write command applied afterwards follows, until either
+// side closes the connection. args are PSYNC's replication ID and offset,
+// and FAILOVER when our master hands over to us; see failover.go.
+func (s *Server) serveReplica(conn net.Conn, p *protocol.Parser, w *protocol.Writer, args []string, conf replicaConf) {
+	conn.SetDeadline(time.Time{})
+	r := &replica{
+		conn:    conn,
+		addr:    replicaAddr(conn, conf.listeningPort),
+		limits:  &s.outputLimits,
+		wake:    make(chan struct{}, 1),
+		dropped: make(chan struct{}),
+	}
+	defer s.removeReplica(r)
+
+	if len(args) == 3 && !s.acceptFailover(args[0]) {
+		w.WriteError("ERR PSYNC FAILOVER replid must match my replid.")
+		w.Flush()
+		return
+	}
+	// A replica can serve replicas of its own, but only with a dataset
+	// its master is keeping up to date.
+	s.repl.mu.Lock()
+	link := s.repl.link
+	s.repl.mu.Unlock()
+	if link != nil && link.getState() != "connected" {
+		w.WriteError("NOMASTERLINK Can't SYNC while not connected with my master")
+		w.Flush()
+		return
+	}
+
+	if args != nil {
+		if id, ok := s.partialSync(r, args[0], args[1]); ok {
+			logging.Infof("Partial resynchronization with replica %s accepted from offset %s", r.addr, args[1])
+			w.WriteSimpleString("CONTINUE " + id)
+			if err := w.Flush(); err != nil {
+				return
+			}
+			s.streamToReplica(r, p)
+			return
+		}
+	}
+	logging.Infof("Replica %s asks for synchronization", r.addr)
+
+	start := time.Now()
+	var err error
+	if s.cfg.ReplDisklessSync && conf.eof {
+		err = s.syncDiskless(r, p, w, args != nil)
+	} else {
+		err = s.syncFromDisk(r, w, args != nil)
+	}
+	if err != nil {
+		logging.Errorf("Full sync of replica %s failed: %v", r.addr, err)
+		return
+	}
+	logging.Infof("Synchronization with replica %s succeeded in %v", r.addr, time.Since(start))
+	s.streamToReplica(r, p)
+}
+
+// syncFromDisk writes a snapshot to a temporary file and sends it with its
+// length, as a bulk string without the trailing CRLF.
+func (s *Server) syncFromDisk(r *replica, w *protocol.Writer, psync bool) error {
+	path, id, offset, err := s.syncSnapshot(r)
+	defer os.Remove(path)
+	if err != nil {
+		w.WriteError("ERR " + err.Error())
+		w.Flush()
+		return err
+	}
+	if psync {
+		w.WriteSimpleString(fmt.Sprintf("FULLRESYNC %s %d", id, offset))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	s.repl.mu.Lock()
+	r.state = "send_bulk"
+	s.repl.mu.Unlock()
+	return sendFile(r.conn, path)
+}
+
+// syncDiskless streams the snapshot straight to the replica's connection as
+// it is serialized. Its length is not known up front, so, as in Redis, it
+// is sent after "$EOF:<mark>" and followed by the mark, and the stream only
+// starts once the replica acknowledged loading it.
+func (s *Server) syncDiskless(r *replica, p *protocol.Parser, w *protocol.Writer, psync bool) error {
+	s.writeMu.Lock()
+	release := sync.OnceFunc(s.writeMu.Unlock)
+	defer release()
+
+	id, offset := s.addSyncingReplica(r)
+	s.repl.mu.Lock()
+	r.state = "send_bulk"
+	s.repl.mu.Unlock()
+	if psync {
+		w.WriteSimpleString(fmt.Sprintf("FULLRESYNC %s %d", id, offset))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	mark := newReplID()
+	if _, err := fmt.Fprintf(r.conn, "$EOF:%s\r\n", mark); err != nil {
+		return err
+	}
+	if _, err := persistence.WriteSnapshotTo(r.conn, startedView{s.store, release}, s.compression, s.encryption); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(r.conn, mark); err != nil {
+		return err
+	}
+
+	r.conn.SetReadDeadline(time.Now().Add(replTimeout))
+	args, err := p.Parse()
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 || !strings.EqualFold(args[0], "REPLCONF") || !strings.EqualFold(args[1], "ACK") {
+		return fmt.Errorf("expected REPLCONF ACK after the snapshot, got %q", args)
+	}
+	if ack, err := strconv.ParseInt(args[2], 10, 64); err == nil {
+		s.ack(r, ack)
+	}
+	return nil
+}
+
+// partialSync registers r to continue the history id from offset, the first
+// byte it is missing, with the part of the stream it missed queued. It
+// returns the current replication ID, or false if the history is not ours
+// or the backlog no longer reaches back to offset.
+func (s *Server) partialSync(r *replica, id, offset string) (string, bool) {
+	want, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.backlog == nil {
+		return "", false
+	}
+	if id != s.repl.id && (id != s.repl.id2 || want > s.repl.id2Offset) {
+		return "", false
+	}
+	missed, ok := s.repl.backlog.since(want - 1)
+	if !ok {
+		return "", false
+	}
+	s.repl.replicas[r] = struct{}{}
+	r.feed(missed)
+	return s.repl.id, true
+}
+
+// streamToReplica sends the command stream queued for r as it comes, and
+// reads the offsets the replica acknowledges, until the server stops. A
+// replica that sends nothing for replTimeout is dropped.
+func (s *Server) streamToReplica(r *replica, p *protocol.Parser) {
+	s.repl.mu.Lock()
+	r.state, r.ackTime = "online", time.Now()
+	s.repl.mu.Unlock()
+	go func() {
+		defer r.drop()
+		defer s.recoverPanic("replica " + r.conn.RemoteAddr().String())
+		for {
+			r.conn.SetReadDeadline(time.Now().Add(replTimeout))
+			args, err := p.Parse()
+			if err != nil {
+				return
+			}
+			if len(args) == 3 && strings.EqualFold(args[0], "REPLCONF") && strings.EqualFold(args[1], "ACK") {
+				if offset, err := strconv.ParseInt(args[2], 10, 64); err == nil {
+					s.ack(r, offset)
+				}
+			}
+		}
+	}()
+	for {
+		stopping := false
+		select {
+		case <-r.wake:
+		case <-r.dropped:
+			return
+		case <-s.quit:
+			// Send what is queued first, so the replica has every
+			// write the server ran.
+			stopping = true
+		}
+		r.mu.Lock()
+		out := r.out
+		r.out = nil
+		r.mu.Unlock()
+		if s.cfg.WriteTimeout > 0 {
+			r.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+		}
+		if _, err := r.conn.Write(out); err != nil {
+			logging.Warnf("Lost connection to replica %s: %v", r.addr, err)
+			return
+		}
+		if stopping {
+			return
+		}
+	}
+}
+
+// syncSnapshot registers r for the command stream and writes the snapshot
+// it starts from to a temporary file. Writes are held off until the
+// snapshot iterator has started, so the stream continues exactly where the
+// snapshot ends.
+func (s *Server) syncSnapshot(r *replica) (path, id string, offset int64, err error) {
+	s.writeMu.Lock()
+	release := sync.OnceFunc(s.writeMu.Unlock)
+	defer release()
+
+	id, offset = s.addSyncingReplica(r)
+	s.repl.mu.Lock()
+	path = filepath.Join(s.cfg.PersistencePath, fmt.Sprintf("temp-sync-%d.snapshot", s.repl.syncs))
+	s.repl.mu.Unlock()
+
+	_, err = persistence.WriteSnapshot(path, startedView{s.store, release}, s.compression, s.encryption)
+	return path, id, offset, err
+}
+
+// addSyncingReplica registers r for the command stream from the current
+// offset, which it returns with the replication ID. It is called with
+// writeMu held, before the snapshot r starts from is taken.
+func (s *Server) addSyncingReplica(r *replica) (string, int64) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.backlog == nil {
+		s.repl.backlog = newBacklog(s.backlogSize(), s.repl.offset)
+	}
+	s.repl.replicas[r] = struct{}{}
+	r.state = "wait_bgsave"
+	s.repl.syncs++
+	return s.repl.id, s.repl.offset
+}
+
+// removeReplica stops streaming to r.
+func (s *Server) removeReplica(r *replica) {
+	s.repl.mu.Lock()
+	delete(s.repl.replicas, r)
+	s.repl.mu.Unlock()
+	r.drop()
+}
+
+// startedView calls started once ForEach has taken its snapshot, or found
+// the dataset empty.
+type startedView struct {
+	store.KV
+	started func()
+}
+
+func (v startedView) ForEach(fn func(store.Entry) bool) {
+	v.KV.ForEach(func(e store.Entry) bool {
+		v.started()
+		return fn(e)
+	})
+	v.started()
+}
+
+// sendFile sends the snapshot at path framed as a bulk string without the
+// trailing CRLF, as Redis sends its RDB file.
+func sendFile(conn net.Conn, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "$%d\r\n", info.Size()); err != nil {
+		return err
+	}
+	_, err = io.Copy(conn, f)
+	return err
+}
+
+// replicaAddr is the address a replica can be reached at: its IP and the
+// port it listens on, if it said.
+func replicaAddr(conn net.Conn, listeningPort string) string {
+	host, port, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	if listeningPort != "" {
+		port = listeningPort
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,545 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"redis-from-scratch/internal/store"
+	"redis-from-scratch/pkg/config"
+)
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReplicaOf(t *testing.T) {
+	master, mport := startTestServerWithConfig(t, replTestConfig(t))
+	defer master.Stop()
+	replica, rport := startTestServerWithConfig(t, replTestConfig(t))
+	defer replica.Stop()
+
+	sendCommand(t, mport, []string{"SET", "before", "1"})
+	sendCommand(t, mport, []string{"RPUSH", "list", "a", "b"})
+	sendCommand(t, rport, []string{"SET", "stale", "x"})
+
+	if resp := sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("REPLICAOF failed: %s", resp)
+	}
+	waitFor(t, "the full sync", func() bool {
+		return strings.Contains(sendCommand(t, rport, []string{"GET", "before"}), "$1\r\n1\r\n")
+	})
+	if resp := sendCommand(t, rport, []string{"GET", "stale"}); !strings.Contains(resp, "$-1") {
+		t.Fatalf("expected the sync to replace the replica's dataset, got %s", resp)
+	}
+	if resp := sendCommand(t, rport, []string{"LRANGE", "list", "0", "-1"}); !strings.Contains(resp, "a") || !strings.Contains(resp, "b") {
+		t.Fatalf("expected the list to be synced, got %s", resp)
+	}
+
+	sendCommand(t, mport, []string{"DEL", "before"})
+	sendCommand(t, mport, []string{"SET", "after", "2", "EX", "100"})
+	waitFor(t, "the command stream", func() bool {
+		return strings.Contains(sendCommand(t, rport, []string{"GET", "after"}), "2")
+	})
+	if resp := sendCommand(t, rport, []string{"GET", "before"}); !strings.Contains(resp, "$-1") {
+		t.Fatalf("expected DEL to reach the replica, got %s", resp)
+	}
+	if _, ttl, _ := replica.store.(*store.Store).GetWithTTL("after"); ttl <= 0 {
+		t.Fatalf("expected the expiry to reach the replica, got TTL %v", ttl)
+	}
+	if resp := sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)}); !strings.Contains(resp, "Already connected") {
+		t.Fatalf("expected REPLICAOF of the same master to be a no-op, got %s", resp)
+	}
+
+	if resp := sendCommand(t, rport, []string{"REPLICAOF", "NO", "ONE"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("REPLICAOF NO ONE failed: %s", resp)
+	}
+	waitFor(t, "the master to drop the replica", func() bool {
+		master.repl.mu.Lock()
+		defer master.repl.mu.Unlock()
+		return len(master.repl.replicas) == 0
+	})
+	sendCommand(t, mport, []string{"SET", "later", "3"})
+	time.Sleep(100 * time.Millisecond)
+	if resp := sendCommand(t, rport, []string{"GET", "later"}); !strings.Contains(resp, "$-1") {
+		t.Fatalf("expected a detached replica to stop applying writes, got %s", resp)
+	}
+	if resp := sendCommand(t, rport, []string{"GET", "after"}); !strings.Contains(resp, "2") {
+		t.Fatalf("expected the former replica to keep its data, got %s", resp)
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	b := newBacklog(8, 100)
+	b.write([]byte("abc"))
+	if got, ok := b.since(100); !ok || string(got) != "abc" {
+		t.Fatalf("since(100) = %q, %v", got, ok)
+	}
+	b.write([]byte("defghij"))
+	if got, ok := b.since(102); !ok || string(got) != "cdefghij" {
+		t.Fatalf("since(102) = %q, %v after wrapping", got, ok)
+	}
+	if got, ok := b.since(110); !ok || len(got) != 0 {
+		t.Fatalf("since(end) = %q, %v", got, ok)
+	}
+	if _, ok := b.since(101); ok {
+		t.Fatalf("expected an offset older than the backlog to be refused")
+	}
+	if _, ok := b.since(111); ok {
+		t.Fatalf("expected an offset past the end to be refused")
+	}
+	b.write([]byte("0123456789"))
+	if got, ok := b.since(112); !ok || string(got) != "23456789" {
+		t.Fatalf("since(112) = %q, %v after a write larger than the backlog", got, ok)
+	}
+}
+
+func TestPartialResync(t *testing.T) {
+	master, mport := startTestServerWithConfig(t, replTestConfig(t))
+	defer master.Stop()
+	r1, port1 := startTestServerWithConfig(t, replTestConfig(t))
+	defer r1.Stop()
+	r2, port2 := startTestServerWithConfig(t, replTestConfig(t))
+	defer r2.Stop()
+
+	for _, port := range []int{port1, port2} {
+		sendCommand(t, port, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	}
+	sendCommand(t, mport, []string{"SET", "k", "1"})
+	for _, port := range []int{port1, port2} {
+		waitFor(t, "the replicas to sync", func() bool {
+			return strings.Contains(sendCommand(t, port, []string{"GET", "k"}), "$1\r\n1\r\n")
+		})
+	}
+
+	// Cut the replicas off: they reconnect and continue from their offset.
+	master.repl.mu.Lock()
+	for r := range master.repl.replicas {
+		r.drop()
+	}
+	master.repl.mu.Unlock()
+	sendCommand(t, mport, []string{"SET", "k", "2"})
+	for _, port := range []int{port1, port2} {
+		waitFor(t, "the replicas to catch up", func() bool {
+			return strings.Contains(sendCommand(t, port, []string{"GET", "k"}), "2")
+		})
+	}
+	master.repl.mu.Lock()
+	syncs := master.repl.syncs
+	master.repl.mu.Unlock()
+	if syncs != 2 {
+		t.Fatalf("expected the reconnecting replicas to resync partially, got %d full syncs", syncs)
+	}
+
+	// Promote r1: r2 continues the same history from it.
+	sendCommand(t, port1, []string{"REPLICAOF", "NO", "ONE"})
+	sendCommand(t, port2, []string{"REPLICAOF", "localhost", strconv.Itoa(port1)})
+	sendCommand(t, port1, []string{"SET", "k", "3"})
+	waitFor(t, "the promoted master's stream", func() bool {
+		return strings.Contains(sendCommand(t, port2, []string{"GET", "k"}), "3")
+	})
+	r1.repl.mu.Lock()
+	syncs = r1.repl.syncs
+	r1.repl.mu.Unlock()
+	if syncs != 0 {
+		t.Fatalf("expected the promoted replica to continue its sibling's history, got %d full syncs", syncs)
+	}
+}
+
+func TestChainedReplication(t *testing.T) {
+	master, mport := startTestServerWithConfig(t, replTestConfig(t))
+	defer master.Stop()
+	r1, port1 := startTestServerWithConfig(t, replTestConfig(t))
+	defer r1.Stop()
+	r2, port2 := startTestServerWithConfig(t, replTestConfig(t))
+	defer r2.Stop()
+
+	sendCommand(t, port1, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	waitFor(t, "the first replica to sync", func() bool {
+		r1.repl.mu.Lock()
+		defer r1.repl.mu.Unlock()
+		return r1.repl.link.getState() == "connected"
+	})
+	// r2 syncs from r1 while r1 applies the master's stream: each RPUSH
+	// must reach r2 exactly once.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			sendCommand(t, mport, []string{"RPUSH", "list", "x"})
+		}
+	}()
+	sendCommand(t, port2, []string{"REPLICAOF", "localhost", strconv.Itoa(port1)})
+	<-done
+	waitFor(t, "the chained replica to catch up", func() bool {
+		return strings.HasPrefix(sendCommand(t, port2, []string{"LRANGE", "list", "0", "-1"}), "*200\r\n")
+	})
+
+	master.repl.mu.Lock()
+	direct := len(master.repl.replicas)
+	master.repl.mu.Unlock()
+	if direct != 1 {
+		t.Fatalf("expected only the first replica to sync from the master, got %d", direct)
+	}
+	if info := sendCommand(t, port1, []string{"INFO", "replication"}); !strings.Contains(info, "role:slave") || !strings.Contains(info, "connected_slaves:1") {
+		t.Fatalf("expected the first replica to serve the second, got %q", info)
+	}
+
+	// Without its master, a replica refuses to sync others.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	sendCommand(t, port1, []string{"REPLICAOF", "localhost", strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)})
+	waitFor(t, "the first replica to lose its master", func() bool {
+		r1.repl.mu.Lock()
+		defer r1.repl.mu.Unlock()
+		return r1.repl.link.getState() != "connected"
+	})
+	if resp := sendCommand(t, port1, []string{"PSYNC", "?", "-1"}); !strings.Contains(resp, "-NOMASTERLINK") {
+		t.Fatalf("expected a replica without its master to refuse to sync, got %s", resp)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	cfg := replTestConfig(t)
+	cfg.ReplicaReadOnly = true
+	master, mport := startTestServerWithConfig(t, cfg)
+	defer master.Stop()
+	replica, rport := startTestServerWithConfig(t, replTestConfig(t))
+	defer replica.Stop()
+
+	if resp := sendCommand(t, mport, []string{"FAILOVER"}); !strings.Contains(resp, "requires connected replicas") {
+		t.Fatalf("expected FAILOVER without replicas to fail, got %s", resp)
+	}
+	sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	sendCommand(t, mport, []string{"SET", "k", "1"})
+	waitFor(t, "the replica to acknowledge the write", func() bool {
+		return strings.Contains(sendCommand(t, mport, []string{"WAIT", "1", "100"}), ":1")
+	})
+	if resp := sendCommand(t, rport, []string{"FAILOVER"}); !strings.Contains(resp, "not valid when server is a replica") {
+		t.Fatalf("expected FAILOVER on a replica to fail, got %s", resp)
+	}
+	if resp := sendCommand(t, mport, []string{"FAILOVER", "TO", "127.0.0.1", "1"}); !strings.Contains(resp, "is not a replica") {
+		t.Fatalf("expected FAILOVER to an unknown replica to fail, got %s", resp)
+	}
+
+	if resp := sendCommand(t, mport, []string{"FAILOVER", "TO", "127.0.0.1", strconv.Itoa(rport)}); resp != "+OK\r\n" {
+		t.Fatalf("FAILOVER failed: %s", resp)
+	}
+	waitFor(t, "the roles to swap", func() bool {
+		return strings.Contains(sendCommand(t, rport, []string{"ROLE"}), "master") &&
+			strings.Contains(sendCommand(t, mport, []string{"ROLE"}), "connected")
+	})
+	if resp := sendCommand(t, mport, []string{"SET", "k", "2"}); !strings.Contains(resp, "-READONLY") {
+		t.Fatalf("expected the former master to refuse writes, got %s", resp)
+	}
+	sendCommand(t, rport, []string{"SET", "k", "3"})
+	waitFor(t, "the new master's stream", func() bool {
+		return strings.Contains(sendCommand(t, mport, []string{"GET", "k"}), "$1\r\n3\r\n")
+	})
+	replica.repl.mu.Lock()
+	syncs := replica.repl.syncs
+	replica.repl.mu.Unlock()
+	if syncs != 0 {
+		t.Fatalf("expected the former master to continue its history, got %d full syncs", syncs)
+	}
+	if info := sendCommand(t, mport, []string{"INFO", "replication"}); !strings.Contains(info, "master_failover_state:no-failover") {
+		t.Fatalf("expected the failover to be over, got %q", info)
+	}
+}
+
+func TestFailoverAbortAndTimeout(t *testing.T) {
+	master, mport := startTestServerWithConfig(t, replTestConfig(t))
+	defer master.Stop()
+
+	// A replica that never acknowledges anything keeps the failover
+	// waiting.
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(mport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "*1\r\n$4\r\nSYNC\r\n")
+	waitFor(t, "the replica to be online", func() bool {
+		return strings.Contains(sendCommand(t, mport, []string{"INFO", "replication"}), "state=online")
+	})
+	sendCommand(t, mport, []string{"SET", "k", "1"})
+
+	if resp := sendCommand(t, mport, []string{"FAILOVER"}); resp != "+OK\r\n" {
+		t.Fatalf("FAILOVER failed: %s", resp)
+	}
+	if info := sendCommand(t, mport, []string{"INFO", "replication"}); !strings.Contains(info, "master_failover_state:waiting-for-sync") {
+		t.Fatalf("expected the failover to wait for the replica, got %q", info)
+	}
+	if resp := sendCommand(t, mport, []string{"FAILOVER"}); !strings.Contains(resp, "already in progress") {
+		t.Fatalf("expected a second FAILOVER to fail, got %s", resp)
+	}
+	written := make(chan string, 1)
+	go func() { written <- sendCommand(t, mport, []string{"SET", "k", "2"}) }()
+	select {
+	case resp := <-written:
+		t.Fatalf("expected writes to be paused, got %s", resp)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if resp := sendCommand(t, mport, []string{"GET", "k"}); !strings.Contains(resp, "$1\r\n1\r\n") {
+		t.Fatalf("expected reads to go on, got %s", resp)
+	}
+	if resp := sendCommand(t, mport, []string{"FAILOVER", "ABORT"}); resp != "+OK\r\n" {
+		t.Fatalf("FAILOVER ABORT failed: %s", resp)
+	}
+	if resp := <-written; resp != "+OK\r\n" {
+		t.Fatalf("expected the paused write to go on after ABORT, got %s", resp)
+	}
+	if resp := sendCommand(t, mport, []string{"FAILOVER", "ABORT"}); !strings.Contains(resp, "No failover in progress") {
+		t.Fatalf("expected ABORT without a failover to fail, got %s", resp)
+	}
+
+	if resp := sendCommand(t, mport, []string{"FAILOVER", "TIMEOUT", "100"}); resp != "+OK\r\n" {
+		t.Fatalf("FAILOVER TIMEOUT failed: %s", resp)
+	}
+	waitFor(t, "the failover to time out", func() bool {
+		return strings.Contains(sendCommand(t, mport, []string{"INFO", "replication"}), "master_failover_state:no-failover")
+	})
+	if resp := sendCommand(t, mport, []string{"ROLE"}); !strings.Contains(resp, "master") {
+		t.Fatalf("expected the server to stay a master, got %q", resp)
+	}
+}
+
+func replTestConfig(t *testing.T) *config.Config {
+	cfg := testConfig()
+	cfg.PersistencePath = t.TempDir()
+	return cfg
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	master, mport := startTestServerWithConfig(t, replTestConfig(t))
+	defer master.Stop()
+	cfg := replTestConfig(t)
+	cfg.ReplicaReadOnly = true
+	replica, rport := startTestServerWithConfig(t, cfg)
+	defer replica.Stop()
+
+	if resp := sendCommand(t, rport, []string{"SET", "k", "local"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("expected a master to accept writes, got %s", resp)
+	}
+	sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	sendCommand(t, mport, []string{"LPUSH", "l", "x"})
+	waitFor(t, "the replica to sync", func() bool {
+		return strings.Contains(sendCommand(t, rport, []string{"LRANGE", "l", "0", "-1"}), "x")
+	})
+
+	for _, args := range [][]string{{"SET", "k", "v"}, {"LPOP", "l"}, {"FLUSHALL"}} {
+		if resp := sendCommand(t, rport, args); !strings.Contains(resp, "-READONLY") {
+			t.Fatalf("expected %s to be refused by a read-only replica, got %s", args[0], resp)
+		}
+	}
+	sendCommand(t, mport, []string{"LPOP", "l"})
+	waitFor(t, "the master's LPOP", func() bool {
+		return strings.Contains(sendCommand(t, rport, []string{"LRANGE", "l", "0", "-1"}), "*0")
+	})
+
+	sendCommand(t, rport, []string{"CONFIG", "SET", "replica-read-only", "no"})
+	if resp := sendCommand(t, rport, []string{"SET", "k", "v"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("expected a writable replica to accept writes, got %s", resp)
+	}
+	sendCommand(t, rport, []string{"CONFIG", "SET", "replica-read-only", "yes"})
+	sendCommand(t, rport, []string{"REPLICAOF", "NO", "ONE"})
+	if resp := sendCommand(t, rport, []string{"SET", "k", "v"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("expected a promoted replica to accept writes, got %s", resp)
+	}
+}
+
+func TestWaitAndMinReplicas(t *testing.T) {
+	master, mport := startTestServerWithConfig(t, replTestConfig(t))
+	defer master.Stop()
+	replica, rport := startTestServerWithConfig(t, replTestConfig(t))
+	defer replica.Stop()
+
+	if resp := sendCommand(t, mport, []string{"WAIT", "0", "0"}); !strings.Contains(resp, ":0") {
+		t.Fatalf("expected WAIT 0 to return at once, got %s", resp)
+	}
+	sendCommand(t, mport, []string{"CONFIG", "SET", "min-replicas-to-write", "1"})
+	if resp := sendCommand(t, mport, []string{"SET", "k", "v"}); !strings.Contains(resp, "-NOREPLICAS") {
+		t.Fatalf("expected writes to be refused without replicas, got %s", resp)
+	}
+
+	sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	waitFor(t, "the replica to come online", func() bool {
+		return strings.Contains(sendCommand(t, mport, []string{"SET", "k", "v"}), "+OK")
+	})
+	if resp := sendCommand(t, mport, []string{"WAIT", "1", "2000"}); !strings.Contains(resp, ":1") {
+		t.Fatalf("expected the replica to acknowledge the write, got %s", resp)
+	}
+	if resp := sendCommand(t, rport, []string{"GET", "k"}); !strings.Contains(resp, "v") {
+		t.Fatalf("expected the acknowledged write on the replica, got %s", resp)
+	}
+	start := time.Now()
+	if resp := sendCommand(t, mport, []string{"WAIT", "2", "300"}); !strings.Contains(resp, ":1") {
+		t.Fatalf("expected WAIT to time out with 1 replica, got %s", resp)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("expected WAIT to block until its timeout, returned after %v", elapsed)
+	}
+	if resp := sendCommand(t, rport, []string{"WAIT", "1", "10"}); !strings.Contains(resp, "-ERR") {
+		t.Fatalf("expected WAIT to be refused by a replica, got %s", resp)
+	}
+
+	sendCommand(t, mport, []string{"CONFIG", "SET", "min-replicas-to-write", "2"})
+	if resp := sendCommand(t, mport, []string{"SET", "k", "v"}); !strings.Contains(resp, "-NOREPLICAS") {
+		t.Fatalf("expected writes to be refused with too few replicas, got %s", resp)
+	}
+}
+
+func TestRoleAndInfoReplication(t *testing.T) {
+	master, mport := startTestServerWithConfig(t, replTestConfig(t))
+	defer master.Stop()
+	replica, rport := startTestServerWithConfig(t, replTestConfig(t))
+	defer replica.Stop()
+
+	if resp := sendCommand(t, mport, []string{"ROLE"}); resp != "*3\r\n$6\r\nmaster\r\n:0\r\n*0\r\n" {
+		t.Fatalf("unexpected ROLE of a lone master: %q", resp)
+	}
+	sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	sendCommand(t, mport, []string{"SET", "k", "v"})
+	waitFor(t, "the replica to acknowledge the write", func() bool {
+		return strings.Contains(sendCommand(t, mport, []string{"WAIT", "1", "100"}), ":1")
+	})
+
+	resp := sendCommand(t, mport, []string{"ROLE"})
+	if !strings.Contains(resp, "master") || !strings.Contains(resp, "127.0.0.1") || !strings.Contains(resp, strconv.Itoa(rport)) {
+		t.Fatalf("expected ROLE to list the replica, got %q", resp)
+	}
+	info := sendCommand(t, mport, []string{"INFO", "replication"})
+	for _, want := range []string{"role:master", "connected_slaves:1", "slave0:ip=127.0.0.1,port=" + strconv.Itoa(rport) + ",state=online,offset=", "repl_backlog_active:1"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("expected INFO replication to contain %q, got %q", want, info)
+		}
+	}
+
+	resp = sendCommand(t, rport, []string{"ROLE"})
+	if !strings.HasPrefix(resp, "*5\r\n$5\r\nslave\r\n$9\r\nlocalhost\r\n:"+strconv.Itoa(mport)+"\r\n$9\r\nconnected\r\n:") {
+		t.Fatalf("unexpected ROLE of a replica: %q", resp)
+	}
+	info = sendCommand(t, rport, []string{"INFO", "replication"})
+	for _, want := range []string{"role:slave", "master_port:" + strconv.Itoa(mport), "master_link_status:up", "connected_slaves:0"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("expected INFO replication to contain %q, got %q", want, info)
+		}
+	}
+}
+
+func TestDisklessSync(t *testing.T) {
+	// The master cannot write files: only a diskless sync can work.
+	cfg := replTestConfig(t)
+	cfg.ReplDisklessSync = true
+	cfg.PersistencePath = filepath.Join(t.TempDir(), "file")
+	master, mport := startTestServerWithConfig(t, cfg)
+	defer master.Stop()
+	if err := os.WriteFile(cfg.PersistencePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	replica, rport := startTestServerWithConfig(t, replTestConfig(t))
+	defer replica.Stop()
+
+	hset := []string{"HSET", "h"}
+	for i := 0; i < 2000; i++ {
+		hset = append(hset, "f"+strconv.Itoa(i), strings.Repeat("v", 100))
+	}
+	sendCommand(t, mport, hset)
+	sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	sendCommand(t, mport, []string{"SET", "after", "1"})
+	waitFor(t, "the diskless sync and the stream after it", func() bool {
+		return strings.Contains(sendCommand(t, rport, []string{"GET", "after"}), "$1\r\n1\r\n")
+	})
+	if resp := sendCommand(t, rport, []string{"HGET", "h", "f1999"}); !strings.Contains(resp, strings.Repeat("v", 100)) {
+		t.Fatalf("expected the snapshot to be loaded, got %q", resp)
+	}
+}
+
+func TestCopyUntilMark(t *testing.T) {
+	mark := strings.Repeat("m", eofMarkLen)
+	payload := strings.Repeat("payload-", 10000) + "mmm"
+	var out bytes.Buffer
+	if err := copyUntilMark(&out, iotest.HalfReader(strings.NewReader(payload+mark)), []byte(mark)); err != nil {
+		t.Fatalf("copyUntilMark: %v", err)
+	}
+	if out.String() != payload {
+		t.Fatalf("copied %d bytes, want the %d before the mark", out.Len(), len(payload))
+	}
+	if err := copyUntilMark(&out, strings.NewReader(payload), []byte(mark)); err != io.EOF {
+		t.Fatalf("expected a transfer without its mark to fail with EOF, got %v", err)
+	}
+}
+
+func TestEvictionsAndExpiriesReachReplicasAndAOF(t *testing.T) {
+	cfg := replTestConfig(t)
+	cfg.EnablePersistence = true
+	cfg.MaxMemory = 8 << 10
+	cfg.MaxMemoryPolicy = "allkeys-random"
+	master, mport := startTestServerWithConfig(t, cfg)
+	replica, rport := startTestServerWithConfig(t, replTestConfig(t))
+	defer replica.Stop()
+
+	sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mport)})
+	sendCommand(t, mport, []string{"SET", "short", "v", "PX", "50"})
+	waitFor(t, "the replica to sync", func() bool {
+		return replica.store.Exists("short") == 1
+	})
+	time.Sleep(60 * time.Millisecond)
+	master.repl.mu.Lock()
+	offset := master.repl.offset
+	master.repl.mu.Unlock()
+	if n := master.store.CleanupExpired(); n != 1 {
+		t.Fatalf("expected the master to expire short, got %d keys", n)
+	}
+	master.repl.mu.Lock()
+	stream, _ := master.repl.backlog.since(offset)
+	master.repl.mu.Unlock()
+	if want := "*2\r\n$3\r\nDEL\r\n$5\r\nshort\r\n"; string(stream) != want {
+		t.Fatalf("expected the expiry propagated as %q, got %q", want, stream)
+	}
+	c := dialTest(t, mport)
+	defer c.conn.Close()
+	for i := 0; i < 200; i++ {
+		c.send("SET", "k"+strconv.Itoa(i), strings.Repeat("v", 100))
+		c.expect("OK")
+	}
+	evicted := master.stats.evicted.Load()
+	if evicted == 0 {
+		t.Fatal("expected the master to evict keys")
+	}
+	waitFor(t, "the replica to catch up", func() bool {
+		return replica.store.Exists("k199") == 1
+	})
+	// The replica has no maxmemory: only the master's DELs remove keys.
+	if got, want := replica.store.Keys("*"), master.store.Keys("*"); !slices.Equal(got, want) {
+		t.Fatalf("expected the replica to drop the keys the master evicted, got %d keys, want %d", len(got), len(want))
+	}
+
+	master.Stop()
+	data := readAOF(t, cfg.PersistencePath)
+	if !strings.Contains(data, "$3\r\nDEL\r\n$5\r\nshort\r\n") {
+		t.Fatalf("expected the expired key's DEL in the AOF")
+	}
+	if n := strings.Count(data, "$3\r\nDEL\r\n"); int64(n) != evicted+1 {
+		t.Fatalf("expected a DEL for each of %d evicted keys and the expired one, got %d", evicted, n)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// RESET returns the connection to the state of a new one, as pooling clients
+// do before reusing it: it unsubscribes from every channel and pattern,
+// turns tracking off, selects database 0, drops its name, switches back to
+// RESP2 and authenticates as the default user again, which takes AUTH if
+// that user has a password. There are no transactions to discard, as MULTI
+// is not implemented.
+func (s *Server) cmdReset(sess *session, args []string) command.Response {
+	if len(args) != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'reset' command")}
+	}
+	s.unsubscribeAll(sess)
+	if sess.tracker != nil {
+		s.tracking.Disable(sess.tracker)
+		sess.tracker = nil
+	}
+	user, authenticated := s.acl.Default()
+	sess.mu.Lock()
+	sess.name, sess.db, sess.user = "", 0, user
+	sess.mu.Unlock()
+	sess.authenticated = authenticated
+	sess.asking, sess.askingNext = false, false
+	sess.write(func(w *protocol.Writer) error {
+		w.SetProtocol(2)
+		return nil
+	})
+	sess.resp3.Store(false)
+	return command.Response{Type: command.TypeSimpleString, Value: "RESET"}
+}
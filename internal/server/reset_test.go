@@ -0,0 +1,54 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequirePass = "secret"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+
+	c.send("HELLO", "3", "AUTH", "default", "secret", "SETNAME", "pooled")
+	c.read()
+	c.send("CLIENT", "TRACKING", "ON")
+	c.expect("OK")
+	c.send("SUBSCRIBE", "ch")
+	c.expect("subscribe", "ch", "1")
+
+	c.send("RESET")
+	c.expect("RESET")
+	c.send("PING")
+	c.expect("-NOAUTH Authentication required.")
+	c.send("AUTH", "secret")
+	c.expect("OK")
+	c.send("CLIENT", "INFO")
+	info := c.read()[0]
+	for _, want := range []string{"name= ", "sub=0", "resp=2", "db=0"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("expected %q after RESET, got %s", want, info)
+		}
+	}
+	c.send("CLIENT", "GETREDIR")
+	c.expect("-1")
+	c.send("PUBLISH", "ch", "m")
+	c.expect("0")
+}
+
+func TestQuit(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+
+	c.send("QUIT")
+	c.expect("OK")
+	if _, err := c.parser.ReadReply(); err != io.EOF {
+		t.Fatalf("expected the connection to close, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"redis-from-scratch/internal/acl"
+	"redis-from-scratch/internal/command"
+)
+
+// restrictCommands returns the commands allow_commands and deny_commands
+// keep clients from running: with an allowlist every command it does not
+// match, and every command the denylist matches. Entries are command
+// names, glob patterns like FLUSH*, or ACL categories like @dangerous. AUTH
+// and HELLO are never restricted, so clients can always authenticate. An
+// entry matching no command is an error, as it is most likely a typo. The
+// result is nil without lists.
+func restrictCommands(allow, deny []string) (map[string]bool, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	allowed, err := matchCommands("allow_commands", allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := matchCommands("deny_commands", deny)
+	if err != nil {
+		return nil, err
+	}
+	restricted := make(map[string]bool)
+	for _, sp := range command.Specs() {
+		cmd := strings.ToUpper(sp.Name)
+		if authExempt[cmd] {
+			continue
+		}
+		if len(allow) > 0 && !allowed[cmd] || denied[cmd] {
+			restricted[cmd] = true
+		}
+	}
+	return restricted, nil
+}
+
+// matchCommands returns the commands in the table matching entries.
+func matchCommands(option string, entries []string) (map[string]bool, error) {
+	matched := make(map[string]bool)
+	for _, entry := range entries {
+		var match func(cmd string) bool
+		if category, ok := strings.CutPrefix(entry, "@"); ok {
+			category = strings.ToLower(category)
+			if !acl.IsCategory(category) {
+				return nil, fmt.Errorf("%s: unknown category '%s'", option, entry)
+			}
+			match = func(cmd string) bool { return acl.InCategory(cmd, category) }
+		} else {
+			m := command.NewPatternMatcher(strings.ToUpper(entry))
+			match = m.Match
+		}
+		found := false
+		for _, sp := range command.Specs() {
+			if cmd := strings.ToUpper(sp.Name); match(cmd) {
+				matched[cmd] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: '%s' matches no command", option, entry)
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import "testing"
+
+func TestDenyCommands(t *testing.T) {
+	cfg := testConfig()
+	cfg.DenyCommands = []string{"keys", "FLUSH*"}
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+
+	c.send("KEYS", "*")
+	c.expect("-ERR command 'keys' is disabled on this server")
+	c.send("FLUSHALL")
+	c.expect("-ERR command 'flushall' is disabled on this server")
+	c.send("SET", "k", "v")
+	c.expect("OK")
+}
+
+func TestAllowCommands(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowCommands = []string{"@read", "PING"}
+	cfg.RequirePass = "secret"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	c := dialTest(t, port)
+	defer c.conn.Close()
+
+	c.send("AUTH", "secret")
+	c.expect("OK")
+	c.send("PING")
+	c.expect("PONG")
+	c.send("GET", "k")
+	c.expect("<nil>")
+	c.send("SET", "k", "v")
+	c.expect("-ERR command 'set' is disabled on this server")
+}
+
+func TestRestrictCommandsErrors(t *testing.T) {
+	if _, err := restrictCommands([]string{"@nosuch"}, nil); err == nil {
+		t.Error("expected an unknown category to be rejected")
+	}
+	if _, err := restrictCommands(nil, []string{"KEYZ"}); err == nil {
+		t.Error("expected an entry matching no command to be rejected")
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+)
+
+// rewriteAOF compacts the AOF to the commands needed to rebuild the current
+// dataset.
+func (s *Server) rewriteAOF() error {
+	start := time.Now()
+	n, err := s.aof.Rewrite(s.store, &s.writeMu)
+	if err != nil {
+		logging.Errorf("AOF rewrite failed: %v", err)
+		return err
+	}
+	logging.Infof("Rewrote AOF with %d keys in %v", n, time.Since(start))
+	return nil
+}
+
+// BGREWRITEAOF compacts the AOF in the background. Commands issued while it
+// runs are still logged and carried over into the new file.
+func (s *Server) cmdBgRewriteAOF(args []string) command.Response {
+	if len(args) != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'bgrewriteaof' command")}
+	}
+	if s.aof == nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR AOF is not enabled")}
+	}
+	if !s.startAOFRewrite() {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Background append only file rewriting already in progress")}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "Background append only file rewriting started"}
+}
+
+// startAOFRewrite starts a background rewrite unless one is already running.
+func (s *Server) startAOFRewrite() bool {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	if s.aofRewriting {
+		return false
+	}
+	s.aofRewriting = true
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.saveMu.Lock()
+			s.aofRewriting = false
+			s.saveMu.Unlock()
+		}()
+		defer s.recoverPanic("AOF rewrite")
+		s.rewriteAOF()
+	}()
+	return true
+}
+
+// maybeRewriteAOF starts a rewrite once the AOF has grown by
+// auto_aof_rewrite_percentage since the last one and is at least
+// auto_aof_rewrite_min_size bytes, as Redis does.
+func (s *Server) maybeRewriteAOF() {
+	pct := s.cfg.AutoAOFRewritePct
+	if s.aof == nil || pct <= 0 {
+		return
+	}
+	size, base := s.aof.Size()
+	if size < s.cfg.AutoAOFRewriteMin {
+		return
+	}
+	if base > 0 && (size-base)*100/base < int64(pct) {
+		return
+	}
+	if s.startAOFRewrite() {
+		logging.Infof("Starting automatic AOF rewrite: %d bytes, %d after the last rewrite", size, base)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/store"
+)
+
+func TestBgRewriteAOF(t *testing.T) {
+	t.Run("commands", func(t *testing.T) { testBgRewriteAOF(t, false) })
+	t.Run("preamble", func(t *testing.T) { testBgRewriteAOF(t, true) })
+}
+
+func testBgRewriteAOF(t *testing.T, preamble bool) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.AOFPreamble = preamble
+	cfg.PersistencePath = t.TempDir()
+
+	srv, port := startTestServerWithConfig(t, cfg)
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		sendCommand(t, port, []string{"SET", "k", fmt.Sprint(i)})
+	}
+	srv.aof.Fsync()
+	before, _ := srv.aof.Size()
+
+	if resp := sendCommand(t, port, []string{"BGREWRITEAOF"}); !strings.Contains(resp, "rewriting started") {
+		t.Fatalf("BGREWRITEAOF failed: %s", resp)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		srv.saveMu.Lock()
+		done := !srv.aofRewriting
+		srv.saveMu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("BGREWRITEAOF did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sendCommand(t, port, []string{"RPUSH", "l", "a"})
+	srv.Stop()
+
+	if after, _ := srv.aof.Size(); after >= before {
+		t.Fatalf("expected AOF to shrink, %d -> %d bytes", before, after)
+	}
+	srv, port = startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if resp := sendCommand(t, port, []string{"GET", "k"}); !strings.Contains(resp, "99") {
+		t.Fatalf("expected k=99 after replay, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"LRANGE", "l", "0", "-1"}); !strings.Contains(resp, "a") {
+		t.Fatalf("expected write after rewrite to be kept, got %s", resp)
+	}
+}
+
+func TestAutoAOFRewrite(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+	cfg.CleanupInterval = 50 * time.Millisecond
+	cfg.AutoAOFRewritePct = 100
+	cfg.AutoAOFRewriteMin = 2048
+
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 200; i++ {
+		sendCommand(t, port, []string{"SET", "k", fmt.Sprint(i)})
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		size, base := srv.aof.Size()
+		if base > 0 && size < 2048 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected automatic rewrite, AOF is %d bytes (base %d)", size, base)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAOFLogsAbsoluteExpiry(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+
+	srv, port := startTestServerWithConfig(t, cfg)
+	time.Sleep(100 * time.Millisecond)
+	sendCommand(t, port, []string{"SET", "short", "v", "PX", "300"})
+	sendCommand(t, port, []string{"SET", "long", "v", "EX", "100"})
+	srv.Stop()
+
+	data := readAOF(t, cfg.PersistencePath)
+	if strings.Contains(data, "$2\r\nPX\r\n") || !strings.Contains(data, "PXAT") {
+		t.Fatalf("expected relative expirations logged as PXAT, got %q", data)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	srv, port = startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if resp := sendCommand(t, port, []string{"GET", "short"}); !strings.Contains(resp, "$-1") {
+		t.Fatalf("expected expired key to stay expired after replay, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"GET", "long"}); !strings.Contains(resp, "v") {
+		t.Fatalf("expected long-lived key after replay, got %s", resp)
+	}
+}
+
+func TestAOFLogsDefaultTTL(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePersistence = true
+	cfg.PersistencePath = t.TempDir()
+	cfg.DefaultTTL = time.Minute
+	cfg.DefaultTTLJitter = 10 * time.Second
+
+	srv, port := startTestServerWithConfig(t, cfg)
+	sendCommand(t, port, []string{"SET", "cached", "v"})
+	sendCommand(t, port, []string{"SET", "explicit", "v", "PX", "5000"})
+	_, cached, _ := srv.store.(*store.Store).GetWithTTL("cached")
+	if cached <= 59*time.Second || cached > 70*time.Second {
+		t.Fatalf("expected the default TTL with jitter, got %v", cached)
+	}
+	if _, ttl, _ := srv.store.(*store.Store).GetWithTTL("explicit"); ttl > 5*time.Second {
+		t.Fatalf("expected the explicit expiry to win over the default, got %v", ttl)
+	}
+	srv.Stop()
+
+	if data := readAOF(t, cfg.PersistencePath); strings.Count(data, "PXAT") != 2 {
+		t.Fatalf("expected both expirations logged as PXAT, got %q", data)
+	}
+	srv, _ = startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	_, replayed, _ := srv.store.(*store.Store).GetWithTTL("cached")
+	if replayed > cached || replayed < cached-time.Second {
+		t.Fatalf("expected the replayed key to keep its deadline, %v left, got %v", cached, replayed)
+	}
+}
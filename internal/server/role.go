@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/command"
+)
+
+// replicaInfo is what ROLE and INFO report about a connected replica.
+type replicaInfo struct {
+	host, port string
+	state      string
+	offset     int64
+	lag        int64 // seconds since its last acknowledgement
+}
+
+// replicaInfos lists the connected replicas by address.
+func (s *Server) replicaInfos() []replicaInfo {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	out := make([]replicaInfo, 0, len(s.repl.replicas))
+	for r := range s.repl.replicas {
+		host, port, _ := net.SplitHostPort(r.addr)
+		info := replicaInfo{host: host, port: port, state: r.state, offset: r.ackOffset}
+		if !r.ackTime.IsZero() {
+			info.lag = int64(time.Since(r.ackTime) / time.Second)
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].host+":"+out[i].port < out[j].host+":"+out[j].port
+	})
+	return out
+}
+
+// ROLE replies, as Redis does, with "master", the replication offset and
+// the host, port and acknowledged offset of each replica; or with "slave",
+// the master's host and port, the link state and the offset applied.
+func (s *Server) cmdRole(args []string) command.Response {
+	if len(args) != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'role' command")}
+	}
+	s.repl.mu.Lock()
+	link, offset := s.repl.link, s.repl.offset
+	s.repl.mu.Unlock()
+
+	if link != nil {
+		host, port, _ := net.SplitHostPort(link.addr)
+		portNum, _ := strconv.Atoi(port)
+		state := link.getState()
+		if state != "connected" {
+			offset = -1
+		}
+		return command.Response{Type: command.TypeValue, Value: []any{"slave", host, portNum, state, offset}}
+	}
+	replicas := []any{}
+	for _, r := range s.replicaInfos() {
+		if r.state == "online" {
+			replicas = append(replicas, []string{r.host, r.port, strconv.FormatInt(r.offset, 10)})
+		}
+	}
+	return command.Response{Type: command.TypeValue, Value: []any{"master", offset, replicas}}
+}
+
+// infoReplication reports the replication state with Redis' field names.
+func (s *Server) infoReplication() string {
+	s.repl.mu.Lock()
+	link, readOnly := s.repl.link, s.repl.readOnly
+	id, id2, offset, id2Offset := s.repl.id, s.repl.id2, s.repl.offset, s.repl.id2Offset
+	var backlogSize, backlogFirst, backlogHeld int64
+	if b := s.repl.backlog; b != nil {
+		backlogSize, backlogHeld = int64(len(b.buf)), int64(b.held)
+		backlogFirst = b.end - backlogHeld + 1
+	}
+	s.repl.mu.Unlock()
+
+	var b strings.Builder
+	if link != nil {
+		host, port, _ := net.SplitHostPort(link.addr)
+		state := link.getState()
+		fmt.Fprintf(&b, "role:slave\r\n")
+		fmt.Fprintf(&b, "master_host:%s\r\n", host)
+		fmt.Fprintf(&b, "master_port:%s\r\n", port)
+		if state == "connected" {
+			fmt.Fprintf(&b, "master_link_status:up\r\n")
+			lastIO := time.Since(time.Unix(0, link.lastIO.Load()))
+			fmt.Fprintf(&b, "master_last_io_seconds_ago:%d\r\n", int64(lastIO/time.Second))
+		} else {
+			fmt.Fprintf(&b, "master_link_status:down\r\n")
+			fmt.Fprintf(&b, "master_last_io_seconds_ago:-1\r\n")
+		}
+		fmt.Fprintf(&b, "master_sync_in_progress:%d\r\n", boolInt(state == "sync"))
+		fmt.Fprintf(&b, "slave_repl_offset:%d\r\n", offset)
+		fmt.Fprintf(&b, "slave_read_only:%d\r\n", boolInt(readOnly))
+	} else {
+		fmt.Fprintf(&b, "role:master\r\n")
+	}
+
+	replicas := s.replicaInfos()
+	fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(replicas))
+	for i, r := range replicas {
+		fmt.Fprintf(&b, "slave%d:ip=%s,port=%s,state=%s,offset=%d,lag=%d\r\n", i, r.host, r.port, r.state, r.offset, r.lag)
+	}
+	fmt.Fprintf(&b, "master_failover_state:%s\r\n", s.failoverState())
+	if id2 == "" {
+		id2, id2Offset = strings.Repeat("0", len(id)), -1
+	}
+	fmt.Fprintf(&b, "master_replid:%s\r\n", id)
+	fmt.Fprintf(&b, "master_replid2:%s\r\n", id2)
+	fmt.Fprintf(&b, "master_repl_offset:%d\r\n", offset)
+	fmt.Fprintf(&b, "second_repl_offset:%d\r\n", id2Offset)
+	fmt.Fprintf(&b, "repl_backlog_active:%d\r\n", boolInt(backlogSize > 0))
+	fmt.Fprintf(&b, "repl_backlog_size:%d\r\n", backlogSize)
+	fmt.Fprintf(&b, "repl_backlog_first_byte_offset:%d\r\n", backlogFirst)
+	fmt.Fprintf(&b, "repl_backlog_histlen:%d\r\n", backlogHeld)
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"time"
+
+	"redis-from-scratch/internal/logging"
+)
+
+// saveRetryDelay is how long automatic saves wait after a failed one, so a
+// full disk is not hammered every tick.
+const saveRetryDelay = 5 * time.Second
+
+// maybeSave starts a background save when a save rule matches, as Redis'
+// serverCron does. After a failed save it waits saveRetryDelay before
+// trying again.
+func (s *Server) maybeSave() {
+	s.saveMu.Lock()
+	points := s.savePoints
+	since := time.Since(s.lastSave)
+	backoff := s.lastSaveErr != nil && time.Since(s.lastSaveTry) < saveRetryDelay
+	s.saveMu.Unlock()
+	if backoff {
+		return
+	}
+	dirty := s.dirty.Load()
+	for _, p := range points {
+		if dirty >= p.Changes && since >= time.Duration(p.Seconds)*time.Second {
+			if s.startBgSave() {
+				logging.Infof("%d changes in %d seconds. Saving...", p.Changes, p.Seconds)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/pkg/config"
+)
+
+func TestParseSavePoints(t *testing.T) {
+	points, err := config.ParseSavePoints("900 1  300 100")
+	if err != nil || len(points) != 2 || points[1] != (config.SavePoint{Seconds: 300, Changes: 100}) {
+		t.Fatalf("unexpected save points %+v (%v)", points, err)
+	}
+	if got := config.FormatSavePoints(points); got != "900 1 300 100" {
+		t.Fatalf("expected rules to format back, got %q", got)
+	}
+	if points, err := config.ParseSavePoints(""); err != nil || len(points) != 0 {
+		t.Fatalf("expected empty rules to disable saves, got %+v (%v)", points, err)
+	}
+	for _, bad := range []string{"900", "900 x", "0 1", "900 -1"} {
+		if _, err := config.ParseSavePoints(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestSavePointTriggersBgSave(t *testing.T) {
+	cfg := testConfig()
+	cfg.PersistencePath = t.TempDir()
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	if resp := sendCommand(t, port, []string{"CONFIG", "SET", "save", "1 2"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("CONFIG SET save failed: %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CONFIG", "GET", "sav*"}); !strings.Contains(resp, "1 2") {
+		t.Fatalf("expected CONFIG GET to return the new rules, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"CONFIG", "SET", "save", "1"}); !strings.Contains(resp, "-ERR") {
+		t.Fatalf("expected invalid rules to be rejected, got %s", resp)
+	}
+
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(srv.snapshotPath()); err == nil {
+		t.Fatalf("expected no save after 1 change with a rule for 2")
+	}
+
+	sendCommand(t, port, []string{"SET", "k", "v2"})
+	deadline := time.Now().Add(3 * time.Second)
+	for srv.dirty.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the save rule to trigger a save")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := os.Stat(srv.snapshotPath()); err != nil {
+		t.Fatalf("expected a snapshot file: %v", err)
+	}
+}
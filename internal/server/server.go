@@ -1,116 +1,648 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/acl"
+	"redis-from-scratch/internal/cluster"
+	"redis-from-scratch/internal/diskstore"
+	"redis-from-scratch/internal/latency"
+	"redis-from-scratch/internal/logging"
 	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/pubsub"
 	"redis-from-scratch/internal/store"
+	"redis-from-scratch/internal/tracking"
 	"redis-from-scratch/pkg/config"
 )
 
 type Server struct {
 	cfg      *config.Config
-	store    *store.Store
+	store    store.KV
 	listener net.Listener
 	wg       sync.WaitGroup
 	quit     chan struct{}
 	aof      *persistence.AOF
+
+	// draining is closed when Stop begins, before quit: the server stops
+	// accepting connections and each connection ends after its command in
+	// flight; see drain.go.
+	draining chan struct{}
+
+	// compression applies to snapshots and rewritten AOF base segments.
+	compression persistence.Compression
+	// encryption, when set, encrypts the AOF and snapshots at rest.
+	encryption *persistence.Encryption
+
+	// writeMu is read-locked around applying and logging a persistent
+	// command, so an AOF rewrite can start between two commands.
+	writeMu sync.RWMutex
+
+	// Snapshot and AOF rewrite state; see snapshot.go and rewrite.go.
+	saveMu       sync.Mutex
+	saving       bool
+	lastSave     time.Time
+	lastSaveTry  time.Time
+	lastSaveErr  error
+	aofRewriting bool
+	savePoints   []config.SavePoint
+
+	// dirty counts the changes made since the last successful save.
+	dirty atomic.Int64
+
+	// Replication state; see replication.go and replicaof.go.
+	repl replication
+
+	// cluster is the cluster state when cluster_enabled is set; see
+	// cluster.go.
+	cluster *cluster.Cluster
+
+	// TLS settings, when a certificate is configured: tlsServer for the
+	// listener on tls_port, tlsClient for replication and cluster links;
+	// see tls.go.
+	tlsServer   *tls.Config
+	tlsClient   *tls.Config
+	tlsListener net.Listener
+	// listeners are all those Start opened, several for each port with
+	// acceptors, and those given to Serve; listener and tlsListener are
+	// the first of each. listenMu guards them once the server is started.
+	listenMu  sync.Mutex
+	listeners []net.Listener
+	// debug serves pprof and expvar on debugListener, with debug_addr;
+	// see debug.go.
+	debug         *http.Server
+	debugListener net.Listener
+	// auditLog records clients' write and admin commands, with audit_log;
+	// see audit.go.
+	auditLog *jsonLog
+	// slowlog records the commands that took slowlog-log-slower-than or
+	// more, for SLOWLOG and slowlog_file.
+	slowlog *slowLog
+
+	// clientIDs numbers client connections, and clients holds the open
+	// ones by id; see session.go.
+	clientIDs atomic.Int64
+	clientsMu sync.Mutex
+	clients   map[int64]*session
+
+	// pubsub holds the connections' Pub/Sub subscriptions; see pubsub.go.
+	pubsub *pubsub.Broker
+
+	// latency records the latency spikes of commands, saves and expire
+	// cycles for LATENCY; see latency.go.
+	latency *latency.Monitor
+
+	// tracking holds the keys read by connections with CLIENT TRACKING on;
+	// see tracking.go.
+	tracking *tracking.Table
+
+	// acl holds the users clients authenticate as. requirePass, the
+	// default user's password, and masterAuth start as requirepass and
+	// masterauth, and CONFIG SET changes them; see auth.go.
+	acl         *acl.ACL
+	authMu      sync.Mutex
+	requirePass string
+	masterAuth  string
+
+	// commandNames are the names rename_command changed; see
+	// renameCommands.
+	commandNames map[string]string
+	// restricted are the commands allow_commands and deny_commands keep
+	// from clients; see restrictCommands.
+	restricted map[string]bool
+
+	// loop parks idle connections in event_loop mode; see eventloop.go.
+	loop *eventLoop
+	// workers run the keyspace commands when workers is set; see
+	// workers.go.
+	workers *workerPool
+
+	// outputLimits are client-output-buffer-limit's; see outbuf.go.
+	outputLimits outputLimits
+	// readOnly starts as read_only, and CONFIG SET read-only changes it.
+	readOnly atomic.Bool
+	// clientsMem is the memory of the connections, for maxmemory-clients;
+	// see clientmem.go.
+	clientsMem clientsMemory
+	// gogc is the gogc set, 0 for the GOGC environment variable's, which
+	// the runtime does not report; see goruntime.go.
+	gogc atomic.Int64
+
+	// started is when the server was created, and stats and cmdStats
+	// count what INFO reports; see info.go and cmdstats.go.
+	started  time.Time
+	stats    serverStats
+	cmdStats commandStats
+
+	// loadErr is set when the persisted dataset could not be loaded and the
+	// server must not start with partial data.
+	loadErr error
 }
 
 func New(cfg *config.Config) *Server {
+	kv, err := openStore(cfg)
+	if err != nil {
+		return newServer(cfg, store.NewWithOptions(storeOptions(cfg)), err)
+	}
+	return newServer(cfg, kv, nil)
+}
+
+// openStore creates the storage engine selected by cfg.StorageBackend. A
+// disk backend that cannot be opened is an error, for the server must not
+// start on an empty memory store in its place.
+func openStore(cfg *config.Config) (store.KV, error) {
+	switch cfg.StorageBackend {
+	case "", "memory":
+		return store.NewWithOptions(storeOptions(cfg)), nil
+	case "bolt":
+		if err := os.MkdirAll(cfg.PersistencePath, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+		ds, err := diskstore.Open(filepath.Join(cfg.PersistencePath, "store.db"))
+		if err != nil {
+			return nil, err
+		}
+		return ds, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s'", cfg.StorageBackend)
+	}
+}
+
+// NewWithStore creates a server backed by the given storage engine instead of
+// the default in-memory store.
+func NewWithStore(cfg *config.Config, kv store.KV) *Server {
+	return newServer(cfg, kv, nil)
+}
+
+// newServer creates a server backed by kv. storeErr is why the configured
+// storage engine could not be opened, kv then only standing in for it until
+// Start refuses to run.
+func newServer(cfg *config.Config, kv store.KV, storeErr error) *Server {
 	s := &Server{
-		cfg:   cfg,
-		store: store.New(),
-		quit:  make(chan struct{}),
+		cfg:      cfg,
+		store:    kv,
+		quit:     make(chan struct{}),
+		draining: make(chan struct{}),
+		lastSave: time.Now(),
+		repl:     newReplication(cfg),
+		started:  time.Now(),
+		pubsub:   pubsub.New(),
+		acl:      acl.New(),
+		tracking: tracking.New(),
+		latency:  latency.New(time.Duration(cfg.LatencyThreshold) * time.Millisecond),
+		clients:  make(map[int64]*session),
+		cmdStats: newCommandStats(),
+		slowlog:  newSlowLog(cfg.SlowlogSlowerThan, cfg.SlowlogMaxLen),
+
+		requirePass: cfg.RequirePass,
+		masterAuth:  cfg.MasterAuth,
+	}
+	if storeErr != nil {
+		s.loadErr = storeErr
+		logging.Errorf("%v", storeErr)
+		return s
+	}
+	var err error
+	if s.compression, err = persistence.ParseCompression(cfg.Compression); err != nil {
+		s.loadErr = fmt.Errorf("persistence_compression: %w", err)
+		logging.Errorf("%v", s.loadErr)
+		return s
+	}
+	enc, err := encryption(cfg)
+	if err != nil {
+		s.loadErr = err
+		logging.Errorf("%v", err)
+		return s
+	}
+	s.encryption = enc
+	if s.tlsServer, s.tlsClient, err = tlsConfigs(cfg); err != nil {
+		s.loadErr = err
+		logging.Errorf("%v", err)
+		return s
+	}
+	if err := s.loadACL(); err != nil {
+		s.loadErr = err
+		logging.Errorf("%v", err)
+		return s
+	}
+	if s.commandNames, err = renameCommands(cfg.RenameCommand); err != nil {
+		s.loadErr = err
+		logging.Errorf("%v", err)
+		return s
+	}
+	if s.restricted, err = restrictCommands(cfg.AllowCommands, cfg.DenyCommands); err != nil {
+		s.loadErr = err
+		logging.Errorf("%v", err)
+		return s
+	}
+	if err := s.outputLimits.set(cfg.ClientOutputBufferLimit); err != nil {
+		s.loadErr = fmt.Errorf("client_output_buffer_limit: %v", err)
+		logging.Errorf("%v", s.loadErr)
+		return s
+	}
+	if n, ok := s.store.(store.Notifier); ok {
+		n.OnExpire(func(key string) {
+			s.stats.expired.Add(1)
+			s.removed(key)
+		})
+		n.OnEvict(func(key string) {
+			s.stats.evicted.Add(1)
+			s.removed(key)
+		})
+	}
+	s.clientsMem.limit.Store(cfg.MaxMemoryClients)
+	s.setupRuntime(cfg)
+	s.readOnly.Store(cfg.ReadOnly)
+	if cfg.LogLevel != "" {
+		if err := logging.SetLevel(cfg.LogLevel); err != nil {
+			logging.Warnf("loglevel: %v, keeping %s", err, logging.Level())
+		}
+	}
+	if cfg.AuditLog != "" {
+		if s.auditLog, err = openJSONLog(cfg.AuditLog); err != nil {
+			s.loadErr = fmt.Errorf("audit_log: %w", err)
+			logging.Errorf("%v", s.loadErr)
+			return s
+		}
+	}
+	if cfg.SlowlogFile != "" {
+		if s.slowlog.file, err = openJSONLog(cfg.SlowlogFile); err != nil {
+			s.loadErr = fmt.Errorf("slowlog_file: %w", err)
+			logging.Errorf("%v", s.loadErr)
+			return s
+		}
+	}
+	if s.savePoints, err = config.ParseSavePoints(cfg.Save); err != nil {
+		s.loadErr = err
+		logging.Errorf("%v", err)
+		return s
+	}
+	masterHost, masterPort, ok := strings.Cut(cfg.ReplicaOf, " ")
+	if cfg.ReplicaOf != "" && !ok {
+		s.loadErr = fmt.Errorf("replicaof must be \"<host> <port>\", got %q", cfg.ReplicaOf)
+		logging.Errorf("%v", s.loadErr)
+		return s
+	}
+	if cfg.ClusterEnabled {
+		if s.cluster, err = openCluster(cfg); err != nil {
+			s.loadErr = err
+			logging.Errorf("%v", err)
+			return s
+		}
 	}
 
 	// Initialize AOF if enabled
-	if cfg.EnablePersistence {
-		aof, err := persistence.New(cfg.PersistencePath, true)
+	durable := false
+	if d, ok := kv.(store.Durable); ok {
+		durable = d.Durable()
+	}
+	if cfg.EnablePersistence && durable {
+		logging.Infof("Storage backend is durable, AOF disabled")
+	}
+	var (
+		base    []store.Entry
+		entries []persistence.AOFEntry
+	)
+	if cfg.EnablePersistence && !durable {
+		aof, err := persistence.NewWithOptions(cfg.PersistencePath, aofOptions(cfg, s.compression, s.encryption))
+		if err != nil {
+			// Running without it would skip its data and persist nothing.
+			s.loadErr = fmt.Errorf("failed to open AOF: %w", err)
+			logging.Errorf("%v", s.loadErr)
+			return s
+		}
+		s.aof = aof
+		base, entries, err = aof.Load()
+		if err != nil && cfg.AOFAbortOnError {
+			s.loadErr = fmt.Errorf("failed to read AOF: %w", err)
+			logging.Errorf("%v; fix the file with check-aof or disable aof_abort_on_error", s.loadErr)
+			return s
+		}
 		if err != nil {
-			log.Printf("Warning: failed to initialize AOF: %v", err)
+			logging.Warnf("failed to read AOF: %v, starting without it", err)
+		}
+	}
+
+	// The AOF, when it has data, is the more complete record, so the
+	// snapshot is only loaded when there is nothing to replay.
+	if !durable {
+		if base != nil || len(entries) > 0 {
+			s.loadAOF(base, entries)
 		} else {
-			s.aof = aof
-			// Replay commands from AOF
-			entries, err := aof.ReadCommands()
-			if err != nil {
-				log.Printf("Warning: failed to read AOF: %v", err)
-			} else {
-				replayCommands(s.store, entries)
-			}
+			s.loadSnapshot()
+		}
+		if s.loadErr != nil {
+			return s
 		}
 	}
 
+	if cfg.EventLoop {
+		if s.loop, err = newEventLoop(s); err != nil {
+			logging.Warnf("event_loop: %v, serving each connection with a goroutine", err)
+		}
+	}
+	if cfg.Workers > 0 {
+		s.workers = newWorkerPool(cfg.Workers)
+	}
+	s.wg.Add(1)
 	go s.cleanupLoop()
+	if s.cluster != nil {
+		s.wg.Add(1)
+		go s.clusterLoop()
+	}
+	if cfg.ReplicaOf != "" {
+		s.replicaOf(masterHost, masterPort)
+	}
 	return s
 }
 
+// storeOptions maps the server config onto the store's tuning options.
+func storeOptions(cfg *config.Config) store.Options {
+	policy, err := store.ParseEvictionPolicy(cfg.MaxMemoryPolicy)
+	if err != nil {
+		logging.Warnf("%v, using noeviction", err)
+		policy = store.PolicyNoEviction
+	}
+	return store.Options{
+		EncodeIntegers:   cfg.EncodeIntegers,
+		InternStrings:    cfg.InternStrings,
+		MaxMemory:        cfg.MaxMemory,
+		EvictionPolicy:   policy,
+		EvictionSamples:  cfg.MaxMemorySamples,
+		LFULogFactor:     cfg.LFULogFactor,
+		LFUDecayTime:     cfg.LFUDecayTime,
+		MaxKeyLen:        cfg.MaxKeyLength,
+		MaxValueSize:     cfg.MaxValueSize,
+		MaxCollectionLen: cfg.MaxCollectionLen,
+		IndexSlots:       cfg.ClusterEnabled,
+	}
+}
+
+// aofOptions maps the server config onto the AOF's options.
+func aofOptions(cfg *config.Config, c persistence.Compression, enc *persistence.Encryption) persistence.Options {
+	policy, err := persistence.ParseFsyncPolicy(cfg.AppendFsync)
+	if err != nil {
+		logging.Warnf("%v, using everysec", err)
+		policy = persistence.FsyncEverySec
+	}
+	return persistence.Options{
+		Fsync:            policy,
+		LoadTruncated:    cfg.AOFLoadTruncated,
+		SnapshotPreamble: cfg.AOFPreamble,
+		KeepHistory:      cfg.AOFKeepHistory,
+		ReplayUntil:      cfg.ReplayUntil,
+		Compression:      c,
+		Encryption:       enc,
+	}
+}
+
+// encryption builds the at-rest encryption from encryption_key, or from the
+// environment if the config has none. Without a key files are plaintext. A
+// bad key is an error rather than a warning: falling back to plaintext would
+// write the dataset unencrypted.
+func encryption(cfg *config.Config) (*persistence.Encryption, error) {
+	if cfg.EncryptionKey == "" {
+		return persistence.EncryptionFromEnv()
+	}
+	key, err := persistence.ParseKey(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return persistence.NewEncryption(key)
+}
+
+// Stop shuts the server down gracefully: it stops accepting connections,
+// lets the commands in flight finish for up to shutdown_timeout, then stops
+// the background work, sends replicas what they have not been sent yet, and
+// flushes and fsyncs the AOF.
 func (s *Server) Stop() {
+	close(s.draining)
+	s.listenMu.Lock()
+	closeListeners(s.listeners)
+	s.listenMu.Unlock()
+	if s.debug != nil {
+		s.debug.Close()
+	}
+	s.drainClients(s.cfg.ShutdownTimeout)
 	close(s.quit)
-	if s.listener != nil {
-		s.listener.Close()
+	if s.loop != nil {
+		s.loop.stop()
+	}
+	s.stopReplicaOf()
+	s.wg.Wait()
+	if s.workers != nil {
+		s.workers.stop()
 	}
 	if s.aof != nil {
-		s.aof.Close()
+		if err := s.aof.Close(); err != nil {
+			logging.Warnf("%v", err)
+		}
 	}
-	s.wg.Wait()
-	log.Println("Server stopped")
-}
-
-func replayCommands(s *store.Store, entries []persistence.AOFEntry) {
-	for _, e := range entries {
-		// Use command.Execute to replay
-		command.Execute(s, e.Command, e.Args)
+	if c, ok := s.store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			logging.Warnf("failed to close storage backend: %v", err)
+		}
 	}
+	if s.auditLog != nil {
+		if err := s.auditLog.close(); err != nil {
+			logging.Warnf("failed to close audit log: %v", err)
+		}
+	}
+	if s.slowlog.file != nil {
+		if err := s.slowlog.file.close(); err != nil {
+			logging.Warnf("failed to close slow log: %v", err)
+		}
+	}
+	logging.Infof("Server stopped")
 }
 
+// cleanupLoop runs cleanup every cleanup_interval until Stop, which waits
+// for the round in progress before closing the AOF it may write DELs to.
 func (s *Server) cleanupLoop() {
+	defer s.wg.Done()
 	ticker := time.NewTicker(s.cfg.CleanupInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			count := s.store.CleanupExpired()
-			if count > 0 {
-				log.Printf("Cleaned up %d expired keys", count)
-			}
+			s.cleanup()
 		case <-s.quit:
 			return
 		}
 	}
 }
 
-// Start begins listening on the configured port and accepts connections.
+// cleanup is a round of cleanupLoop: it removes expired keys, and starts
+// saves and AOF rewrites and evicts clients as due.
+func (s *Server) cleanup() {
+	defer s.recoverPanic("cleanup loop")
+	start := time.Now()
+	count := s.store.CleanupExpired()
+	s.latency.Add("expire-cycle", time.Since(start))
+	if count > 0 {
+		logging.Debugf("Cleaned up %d expired keys", count)
+	}
+	s.maybeSave()
+	s.maybeRewriteAOF()
+	s.evictClients()
+}
+
+// Start begins listening on the configured port, and on tls_port if set,
+// and accepts connections; with debug_addr, it serves profiles there too. With tls_port, port 0 leaves the plaintext
+// listener out, as in Redis, so that clients can only connect over TLS.
 func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%d", s.cfg.Port)
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
-	s.listener = ln
-
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				select {
-				case <-s.quit:
-					return
-				default:
-					log.Printf("accept error: %v", err)
-					continue
-				}
-			}
-			s.wg.Add(1)
-			go s.handleConnection(conn)
+	if s.loadErr != nil {
+		return fmt.Errorf("refusing to start: %w", s.loadErr)
+	}
+	var lns, tlns []net.Listener
+	if s.cfg.TLSPort > 0 {
+		var err error
+		if tlns, err = s.listen(s.cfg.TLSPort); err != nil {
+			return err
+		}
+		for i, ln := range tlns {
+			tlns[i] = tls.NewListener(ln, s.tlsServer)
+		}
+		s.listenMu.Lock()
+		s.tlsListener = tlns[0]
+		s.listenMu.Unlock()
+	}
+	if s.cfg.Port != 0 || s.cfg.TLSPort == 0 {
+		var err error
+		if lns, err = s.listen(s.cfg.Port); err != nil {
+			closeListeners(tlns)
+			return err
+		}
+		s.listenMu.Lock()
+		s.listener = lns[0]
+		s.listenMu.Unlock()
+	}
+	if s.cfg.DebugAddr != "" {
+		if err := s.startDebug(); err != nil {
+			closeListeners(lns)
+			closeListeners(tlns)
+			return err
 		}
-	}()
+	}
+	s.listenMu.Lock()
+	s.listeners = append(s.listeners, lns...)
+	s.listeners = append(s.listeners, tlns...)
+	s.listenMu.Unlock()
+	for _, ln := range lns {
+		go s.serve(ln)
+	}
+	for _, ln := range tlns {
+		go s.serve(ln)
+	}
+	return nil
+}
+
+// ErrServerClosed is returned by Serve once the server is stopped.
+var ErrServerClosed = errors.New("server closed")
 
+// Serve accepts connections from l, a listener the caller opened, like one
+// on an ephemeral port, a Unix socket or a socket systemd passed, and
+// serves them until the server is stopped, which closes l, or l fails. It
+// can be used instead of Start, or besides it. Connections are served as
+// they come: wrap l with tls.NewListener for TLS.
+func (s *Server) Serve(l net.Listener) error {
+	if s.loadErr != nil {
+		return fmt.Errorf("refusing to start: %w", s.loadErr)
+	}
+	s.listenMu.Lock()
+	select {
+	case <-s.draining:
+		s.listenMu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	default:
+	}
+	s.listeners = append(s.listeners, l)
+	if s.listener == nil {
+		s.listener = l
+	}
+	s.listenMu.Unlock()
+	return s.serve(l)
+}
+
+// Addr is the address of the server's first listener: the one Start
+// opened on port, or on tls_port with port 0, or else the first one given
+// to Serve. It is nil until there is one.
+func (s *Server) Addr() net.Addr {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	switch {
+	case s.listener != nil:
+		return s.listener.Addr()
+	case s.tlsListener != nil:
+		return s.tlsListener.Addr()
+	}
 	return nil
 }
+
+// listen opens the listeners on port: acceptors of them sharing it with
+// SO_REUSEPORT, for the kernel to spread new connections over their accept
+// loops, so that a single one does not bound how fast the server takes
+// them; or one.
+func (s *Server) listen(port int) ([]net.Listener, error) {
+	if s.cfg.Acceptors <= 1 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	var lns []net.Listener
+	for i := 0; i < s.cfg.Acceptors; i++ {
+		ln, err := lc.Listen(context.Background(), "tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			closeListeners(lns)
+			return nil, fmt.Errorf("acceptors: %w", err)
+		}
+		// The others share the port the first picked for port 0.
+		port = ln.Addr().(*net.TCPAddr).Port
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func closeListeners(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+// serve accepts connections from ln until the server stops, or ln is
+// closed.
+func (s *Server) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.draining:
+				return ErrServerClosed
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			logging.Errorf("accept error: %v", err)
+			continue
+		}
+		s.wg.Add(1)
+		go s.handleConnection(conn)
+	}
+}
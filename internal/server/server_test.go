@@ -4,45 +4,54 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"redis-from-scratch/internal/protocol"
 	"redis-from-scratch/pkg/config"
 )
 
 // Helper to start server on ephemeral port
 func startTestServer(t *testing.T) (*Server, int) {
-	cfg := &config.Config{
+	return startTestServerWithConfig(t, testConfig())
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
 		Port:            0, // Use ephemeral port
 		MaxConnections:  1000,
 		CleanupInterval: 1 * time.Second,
 		ReadTimeout:     30 * time.Second,
 		WriteTimeout:    30 * time.Second,
 	}
+}
 
-	srv := New(cfg)
-
+func startTestServerWithConfig(t *testing.T, cfg *config.Config) (*Server, int) {
 	// Start server and get assigned port
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
 		t.Fatalf("failed to listen: %v", err)
 	}
-	srv.listener = listener
-	port := listener.Addr().(*net.TCPAddr).Port
+	return serveTestListener(New(cfg), listener)
+}
 
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				return
-			}
-			srv.wg.Add(1)
-			go srv.handleConnection(conn)
-		}
-	}()
+// testListener listens on an ephemeral port.
+func testListener(tb testing.TB) net.Listener {
+	tb.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		tb.Fatalf("failed to listen: %v", err)
+	}
+	return l
+}
 
-	return srv, port
+// serveTestListener serves srv's connections from listener.
+func serveTestListener(srv *Server, listener net.Listener) (*Server, int) {
+	go srv.Serve(listener)
+	return srv, listener.Addr().(*net.TCPAddr).Port
 }
 
 // Helper to send command and get response
@@ -192,6 +201,93 @@ func TestServerSetOps(t *testing.T) {
 	}
 }
 
+func TestServerMultiKeyOps(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	sendCommand(t, port, []string{"SET", "old", "v", "PX", "300"})
+	if resp := sendCommand(t, port, []string{"RENAME", "old", "new"}); resp != "+OK\r\n" {
+		t.Fatalf("RENAME failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"GET", "new"}); resp != "$1\r\nv\r\n" {
+		t.Fatalf("expected the value under the new name, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"RENAME", "old", "new"}); !strings.HasPrefix(resp, "-ERR no such key") {
+		t.Fatalf("expected RENAME of a missing key to fail, got %q", resp)
+	}
+	sendCommand(t, port, []string{"SET", "other", "w"})
+	if resp := sendCommand(t, port, []string{"RENAMENX", "other", "new"}); resp != ":0\r\n" {
+		t.Fatalf("expected RENAMENX onto an existing key to do nothing, got %q", resp)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if resp := sendCommand(t, port, []string{"EXISTS", "new"}); resp != ":0\r\n" {
+		t.Fatalf("expected RENAME to keep the TTL, got %q", resp)
+	}
+
+	sendCommand(t, port, []string{"SADD", "s1", "a", "b", "c"})
+	sendCommand(t, port, []string{"SADD", "s2", "b", "c", "d"})
+	if resp := sendCommand(t, port, []string{"SMOVE", "s1", "s3", "a"}); resp != ":1\r\n" {
+		t.Fatalf("SMOVE failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SISMEMBER", "s3", "a"}); resp != ":1\r\n" {
+		t.Fatalf("expected the member in the destination, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SMOVE", "s1", "other", "b"}); !strings.Contains(resp, "WRONGTYPE") {
+		t.Fatalf("expected WRONGTYPE, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SISMEMBER", "s1", "b"}); resp != ":1\r\n" {
+		t.Fatalf("expected a failed SMOVE to keep the member, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SINTERSTORE", "inter", "s1", "s2"}); resp != ":2\r\n" {
+		t.Fatalf("SINTERSTORE failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SINTERSTORE", "inter", "s1", "missing"}); resp != ":0\r\n" {
+		t.Fatalf("expected an empty intersection, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"EXISTS", "inter"}); resp != ":0\r\n" {
+		t.Fatalf("expected an empty intersection to delete the destination, got %q", resp)
+	}
+
+	sendCommand(t, port, []string{"RPUSH", "l1", "x", "y"})
+	if resp := sendCommand(t, port, []string{"LMOVE", "l1", "l2", "RIGHT", "LEFT"}); resp != "$1\r\ny\r\n" {
+		t.Fatalf("LMOVE failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"LMOVE", "l1", "l2", "LEFT", "LEFT"}); resp != "$1\r\nx\r\n" {
+		t.Fatalf("LMOVE failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"LRANGE", "l2", "0", "-1"}); resp != "*2\r\n$1\r\nx\r\n$1\r\ny\r\n" {
+		t.Fatalf("expected x y, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"LMOVE", "l1", "l2", "LEFT", "LEFT"}); resp != "$-1\r\n" {
+		t.Fatalf("expected null from an empty source, got %q", resp)
+	}
+}
+
+func TestMultiKeyWritesDenyOOM(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxMemory = 1
+	cfg.MaxMemoryPolicy = "noeviction"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	srv.store.SetAdd("s1", "a")
+	srv.store.SetAdd("s2", "a")
+	srv.store.ListRPush("l1", "x")
+
+	for _, cmd := range [][]string{
+		{"LMOVE", "l1", "l2", "LEFT", "LEFT"},
+		{"SMOVE", "s1", "s3", "a"},
+		{"SINTERSTORE", "inter", "s1", "s2"},
+	} {
+		if resp := sendCommand(t, port, cmd); !strings.HasPrefix(resp, "-OOM") {
+			t.Errorf("expected %s to be refused over maxmemory, got %q", cmd[0], resp)
+		}
+	}
+	if resp := sendCommand(t, port, []string{"EXISTS", "l2", "s3", "inter"}); resp != ":0\r\n" {
+		t.Fatalf("expected the refused writes to create nothing, got %q", resp)
+	}
+}
+
 func TestServerTypeErrors(t *testing.T) {
 	srv, port := startTestServer(t)
 	defer srv.Stop()
@@ -315,3 +411,207 @@ func TestServerMultipleConnections(t *testing.T) {
 
 	time.Sleep(500 * time.Millisecond)
 }
+
+func TestServerMaxRequestSize(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxRequestSize = 100
+	cfg.ProtoMaxBulkLen = 80
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	if got := sendCommand(t, port, []string{"SET", "k", strings.Repeat("x", 90)}); !strings.Contains(got, "exceeds max length") {
+		t.Fatalf("expected an argument over proto_max_bulk_len to be refused, got %q", got)
+	}
+	if got := sendCommand(t, port, []string{"SET", "k", strings.Repeat("x", 60), "GET", strings.Repeat("y", 50)}); !strings.Contains(got, "exceeds max size") {
+		t.Fatalf("expected a request over max_request_size to be refused, got %q", got)
+	}
+	if got := sendCommand(t, port, []string{"SET", "k", strings.Repeat("x", 80)}); got != "+OK\r\n" {
+		t.Fatalf("expected a request within the limits to run, got %q", got)
+	}
+}
+
+func TestServerProtocolError(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxRequestSize = 100
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	// An inline command over the limit is skipped, and the next one runs.
+	c := dialTest(t, port)
+	fmt.Fprintf(c.conn, "SET k %s\r\n\r\nPING\r\n", strings.Repeat("x", 200))
+	if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-ERR Protocol error: too big inline request") {
+		t.Fatalf("expected a protocol error, got %q", got)
+	}
+	c.expect("PONG")
+	fmt.Fprintf(c.conn, "SET k \"hello\\tworld\r\nSET k \"hello\\tworld\"\r\nGET k\r\n")
+	if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-ERR Protocol error: unbalanced quotes") {
+		t.Fatalf("expected a protocol error, got %q", got)
+	}
+	c.expect("OK")
+	c.expect("hello\tworld")
+
+	// A malformed multibulk request is refused, and the connection closed.
+	c = dialTest(t, port)
+	fmt.Fprintf(c.conn, "*1\r\n$3\r\nGETX\r\nPING\r\n")
+	if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-ERR Protocol error:") {
+		t.Fatalf("expected a protocol error, got %q", got)
+	}
+	if rest, err := io.ReadAll(c.conn); err != nil || len(rest) != 0 {
+		t.Fatalf("expected the connection to be closed, got %q, %v", rest, err)
+	}
+}
+
+func TestServerInfoKeyspace(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	sendCommand(t, port, []string{"SET", "k", "v", "EX", "100"})
+	sendCommand(t, port, []string{"HSET", "h", "f", "v"})
+
+	resp := sendCommand(t, port, []string{"INFO", "keyspace"})
+	if !strings.Contains(resp, "# Keyspace") {
+		t.Fatalf("expected keyspace section, got: %s", resp)
+	}
+	if !strings.Contains(resp, "db0:keys=2,expires=1,avg_ttl=0,strings=1,hashes=1") {
+		t.Fatalf("unexpected keyspace line: %s", resp)
+	}
+}
+
+func TestServerInfoSections(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	sendCommand(t, port, []string{"GET", "k"})
+	sendCommand(t, port, []string{"GET", "missing"})
+	sendCommand(t, port, []string{"LRANGE", "missing", "0", "-1"})
+
+	resp := sendCommand(t, port, []string{"INFO"})
+	for _, want := range []string{
+		"# Server", "redis_version:" + serverVersion, "redis_git_sha1:", "build_version:", "redis_mode:standalone", "uptime_in_seconds:",
+		"# Clients", "connected_clients:",
+		"# Memory", "used_memory:", "maxmemory_policy:noeviction",
+		"# Persistence", "# Stats", "# Replication", "# CPU", "used_cpu_user:", "# Keyspace",
+		"total_connections_received:5", "total_commands_processed:5",
+		"keyspace_hits:1", "keyspace_misses:2",
+	} {
+		if !strings.Contains(resp, want) {
+			t.Fatalf("expected %q in INFO, got: %s", want, resp)
+		}
+	}
+	if strings.Index(resp, "# Server") > strings.Index(resp, "# Stats") {
+		t.Fatalf("expected sections in Redis' order, got: %s", resp)
+	}
+
+	resp = sendCommand(t, port, []string{"INFO", "stats", "cpu"})
+	if !strings.Contains(resp, "# Stats") || !strings.Contains(resp, "# CPU") || strings.Contains(resp, "# Server") {
+		t.Fatalf("expected only the stats and cpu sections, got: %s", resp)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	cfg := testConfig()
+	cfg.ReadOnly = true
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	for _, cmd := range [][]string{{"SET", "k", "v"}, {"DEL", "k"}, {"FLUSHALL"}} {
+		c.send(cmd...)
+		if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-READONLY") {
+			t.Fatalf("%s: expected a read-only server to refuse it, got %q", cmd[0], got)
+		}
+	}
+	c.send("GET", "k")
+	c.expect("<nil>")
+	c.send("CONFIG", "GET", "read-only")
+	c.expect("read-only", "yes")
+
+	c.send("CONFIG", "SET", "read-only", "no")
+	c.expect("OK")
+	c.send("SET", "k", "v")
+	c.expect("OK")
+	c.send("CONFIG", "SET", "read-only", "yes")
+	c.expect("OK")
+	c.send("SET", "k", "w")
+	if got := c.read(); len(got) != 1 || !strings.HasPrefix(got[0], "-READONLY") {
+		t.Fatalf("expected writes to be refused again, got %q", got)
+	}
+	c.send("GET", "k")
+	c.expect("v")
+}
+
+func TestServe(t *testing.T) {
+	srv := New(testConfig())
+	if srv.Addr() != nil {
+		t.Fatal("expected no address before serving")
+	}
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "redis.sock"))
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	conn, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "PING\r\n")
+	if reply, err := protocol.NewParser(conn).ReadReply(); err != nil || reply.Str != "PONG" {
+		t.Fatalf("expected PONG, got %+v, %v", reply, err)
+	}
+	if addr := srv.Addr(); addr == nil || addr.String() != ln.Addr().String() {
+		t.Fatalf("expected the address of the listener served, got %v", addr)
+	}
+
+	srv.Stop()
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("expected Serve to return ErrServerClosed, got %v", err)
+	}
+	if err := srv.Serve(testListener(t)); err != ErrServerClosed {
+		t.Fatalf("expected Serve after Stop to return ErrServerClosed, got %v", err)
+	}
+}
+
+func TestAcceptors(t *testing.T) {
+	cfg := testConfig()
+	cfg.Acceptors = 4
+	srv := New(cfg)
+	if err := srv.Start(); err != nil {
+		t.Skipf("acceptors: %v", err)
+	}
+	defer srv.Stop()
+	if len(srv.listeners) != 4 {
+		t.Fatalf("expected 4 listeners, got %d", len(srv.listeners))
+	}
+	port := srv.listener.Addr().(*net.TCPAddr).Port
+	for _, ln := range srv.listeners {
+		if p := ln.Addr().(*net.TCPAddr).Port; p != port {
+			t.Fatalf("expected every listener on port %d, got %d", port, p)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if got := sendCommand(t, port, []string{"PING"}); got != "+PONG\r\n" {
+			t.Fatalf("expected PONG, got %q", got)
+		}
+	}
+}
+
+func TestBoltBackendOpenFailureRefusesToStart(t *testing.T) {
+	cfg := testConfig()
+	cfg.StorageBackend = "bolt"
+	// A file where the data directory should be.
+	cfg.PersistencePath = filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(cfg.PersistencePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := New(cfg)
+	defer srv.Stop()
+	if err := srv.Start(); err == nil || !strings.Contains(err.Error(), "refusing to start") {
+		t.Fatalf("expected the server to refuse to start, got %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redis-from-scratch/internal/acl"
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// session is the state of a client connection: created when the connection
+// is accepted and passed to the commands it runs, which keep what they need
+// between commands here.
+type session struct {
+	// id is unique among the server's connections, in the order they
+	// were accepted.
+	id   int64
+	conn net.Conn
+	// mu guards what CLIENT LIST shows of the connection to others: its
+	// name, db, user, subscriptions, patterns and command stats. The
+	// connection's goroutine, their only writer, reads them without it.
+	mu sync.Mutex
+	// name is the connection's name, set by the client.
+	name string
+	// db is the selected database; only database 0 exists.
+	db int
+	// authenticated is set once the client has authenticated as user, or
+	// from the start while the default user needs no password.
+	authenticated bool
+	user          *acl.User
+	// subscriptions and patterns are the Pub/Sub channels and patterns
+	// the connection is subscribed to; see pubsub.go.
+	subscriptions map[string]bool
+	patterns      map[string]bool
+	// tracker is set while CLIENT TRACKING is on; see tracking.go.
+	tracker *invalidator
+	// resp3 is set once HELLO switched the connection to RESP3, for
+	// other connections to read.
+	resp3 atomic.Bool
+
+	// w writes to the connection, and wmu serializes its use between the
+	// connection's goroutine and pushLoop; reply is the one being written,
+	// checked against limits. pushes holds the out-of-band messages for
+	// the connection, like those published to its channels, until either
+	// writes them, pushBytes roughly their size and pushSoft when they
+	// went over the soft limit; they are guarded by pmu, unless the
+	// connection was dropped for falling behind. wake signals pushLoop,
+	// and closed stops it.
+	wmu          sync.Mutex
+	w            *protocol.Writer
+	writeTimeout time.Duration
+	limits       *outputLimits
+	reply        reply
+	pmu          sync.Mutex
+	pushes       [][]any
+	pushBytes    int
+	pushSoft     time.Time
+	dropped      bool
+	wake         chan struct{}
+	closed       chan struct{}
+	pushing      sync.Once
+
+	// parser reads the connection's commands, each decoded into args,
+	// those of the one before: commands do not keep them. idle closes the
+	// connection while it is parked in the event loop past idle_timeout;
+	// see eventloop.go.
+	parser *protocol.Parser
+	args   []string
+	idle   *time.Timer
+	// done receives the outcome of the connection's command run by a
+	// worker; see workers.go.
+	done chan error
+
+	// mem is the memory the connection holds, for maxmemory-clients; see
+	// clientmem.go.
+	mem clientMemory
+
+	// replConf is set by a replica through REPLCONF.
+	replConf replicaConf
+	// askingNext is set by ASKING, and asking while the command after it
+	// runs, which may use a slot this node is importing.
+	askingNext bool
+	asking     bool
+
+	// created is when the connection was accepted, lastCmd and lastUsed
+	// the name and time of its latest command, and commands the number it
+	// ran.
+	created  time.Time
+	lastCmd  string
+	lastUsed time.Time
+	commands int64
+}
+
+// newSession creates the session of a connection the server accepted.
+func (s *Server) newSession(conn net.Conn) *session {
+	now := time.Now()
+	user, authenticated := s.acl.Default()
+	sess := &session{
+		id:            s.clientIDs.Add(1),
+		conn:          conn,
+		writeTimeout:  s.cfg.WriteTimeout,
+		limits:        &s.outputLimits,
+		wake:          make(chan struct{}, 1),
+		authenticated: authenticated,
+		user:          user,
+		closed:        make(chan struct{}),
+		created:       now,
+		lastUsed:      now,
+	}
+	sess.mem.open(&s.clientsMem)
+	sess.w = protocol.NewWriter(outputWriter{sess})
+	sess.parser = protocol.NewParser(input{sess})
+	if s.cfg.ProtoMaxBulkLen > 0 {
+		sess.parser.SetMaxBulkLength(s.cfg.ProtoMaxBulkLen)
+	}
+	sess.parser.SetMaxRequestSize(s.cfg.MaxRequestSize)
+	return sess
+}
+
+// addClient registers the session of a new connection.
+func (s *Server) addClient(sess *session) {
+	s.clientsMu.Lock()
+	s.clients[sess.id] = sess
+	s.clientsMu.Unlock()
+}
+
+// removeClient forgets the session of a closing connection.
+func (s *Server) removeClient(sess *session) {
+	s.clientsMu.Lock()
+	delete(s.clients, sess.id)
+	s.clientsMu.Unlock()
+	sess.mem.close()
+	if sess.tracker != nil {
+		s.tracking.Disable(sess.tracker)
+	}
+}
+
+// client returns the session of the open connection with id.
+func (s *Server) client(id int64) *session {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.clients[id]
+}
+
+// write writes to the connection with fn, after the out-of-band messages
+// queued so far, and flushes it. Replies and messages thus reach the client
+// in order: what a command does before replying, like subscribing, is done
+// in fn.
+func (sess *session) write(fn func(w *protocol.Writer) error) error {
+	return sess.writeOut(fn, true)
+}
+
+// writeBuffered is write without flushing, for replies to pipelined
+// commands: the client's next command is already read, and its reply goes
+// out with this one.
+func (sess *session) writeBuffered(fn func(w *protocol.Writer) error) error {
+	return sess.writeOut(fn, false)
+}
+
+func (sess *session) writeOut(fn func(w *protocol.Writer) error, flush bool) error {
+	sess.wmu.Lock()
+	defer sess.wmu.Unlock()
+	sess.reply = reply{limit: sess.limits.get(sess.outputClass())}
+	defer func() {
+		sess.reply = reply{}
+		sess.mem.set(memOutput, 0)
+	}()
+	if sess.writeTimeout > 0 {
+		sess.reply.deadline = time.Now().Add(sess.writeTimeout)
+	}
+	sess.conn.SetWriteDeadline(sess.reply.deadline)
+	sess.pmu.Lock()
+	pushes := sess.pushes
+	sess.pushes, sess.pushBytes, sess.pushSoft = nil, 0, time.Time{}
+	sess.mem.set(memPush, 0)
+	sess.pmu.Unlock()
+	for _, msg := range pushes {
+		if err := sess.w.WritePush(msg); err != nil {
+			return err
+		}
+	}
+	if err := fn(sess.w); err != nil {
+		return err
+	}
+	if !flush {
+		return nil
+	}
+	return sess.w.Flush()
+}
+
+// flush writes the replies writeBuffered left in the buffer.
+func (sess *session) flush() error {
+	sess.wmu.Lock()
+	defer sess.wmu.Unlock()
+	if sess.w.Buffered() == 0 {
+		return nil
+	}
+	setDeadline(sess.conn.SetWriteDeadline, sess.writeTimeout)
+	return sess.w.Flush()
+}
+
+// input reads the connection's requests. It flushes the replies buffered so
+// far before waiting for more, since the client may wait for them before
+// sending the rest of a request it started.
+type input struct {
+	sess *session
+}
+
+func (in input) Read(p []byte) (int, error) {
+	if err := in.sess.flush(); err != nil {
+		return 0, err
+	}
+	return in.sess.conn.Read(p)
+}
+
+// push queues msg for the connection, as a RESP3 push or, to RESP2
+// clients, an array, to be written by pushLoop or before the next reply. A
+// client falling behind is disconnected by the pubsub output buffer limit,
+// which applies to the invalidations queued for tracking clients too. It
+// must not block: it is called by other connections, with locks held.
+func (sess *session) push(msg []any) {
+	size := 0
+	for _, e := range msg {
+		if str, ok := e.(string); ok {
+			size += len(str)
+		}
+	}
+	sess.pmu.Lock()
+	defer sess.pmu.Unlock()
+	if sess.dropped {
+		return
+	}
+	if sess.limits.get("pubsub").exceeded(int64(sess.pushBytes+size), &sess.pushSoft) {
+		sess.closeForOutput(int64(sess.pushBytes))
+		sess.dropped, sess.pushes, sess.pushBytes = true, nil, 0
+		sess.mem.set(memPush, 0)
+		return
+	}
+	sess.pushes = append(sess.pushes, msg)
+	sess.pushBytes += size
+	sess.mem.set(memPush, int64(sess.pushBytes))
+	select {
+	case sess.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startPushLoop starts the connection's pushLoop, once, before anything is
+// pushed to it.
+func (s *Server) startPushLoop(sess *session) {
+	sess.pushing.Do(func() {
+		s.wg.Add(1)
+		go s.pushLoop(sess)
+	})
+}
+
+// pushLoop writes the messages pushed to a connection while it waits for
+// commands, until the connection closes.
+func (s *Server) pushLoop(sess *session) {
+	defer s.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			s.panicked(sess.describe(), r)
+			sess.conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-sess.wake:
+			s.evictClients()
+			if err := sess.write(func(*protocol.Writer) error { return nil }); err != nil {
+				sess.conn.Close()
+				return
+			}
+		case <-sess.closed:
+			return
+		}
+	}
+}
+
+// started records that the connection runs cmd.
+func (sess *session) started(cmd string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.lastCmd = cmd
+	sess.lastUsed = time.Now()
+	sess.commands++
+}
+
+// SELECT index picks the connection's database. Only database 0 exists, as
+// in Redis Cluster.
+func (sess *session) cmdSelect(args []string) command.Response {
+	if len(args) != 1 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'select' command")}
+	}
+	db, err := strconv.Atoi(args[0])
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+	}
+	if db != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR DB index is out of range")}
+	}
+	sess.mu.Lock()
+	sess.db = db
+	sess.mu.Unlock()
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
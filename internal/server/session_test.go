@@ -0,0 +1,76 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	if resp := sendCommand(t, port, []string{"SELECT", "0"}); resp != "+OK\r\n" {
+		t.Fatalf("SELECT 0 failed: %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SELECT", "1"}); resp != "-ERR DB index is out of range\r\n" {
+		t.Fatalf("expected only database 0 to exist, got %q", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SELECT", "x"}); resp != "-ERR value is not an integer or out of range\r\n" {
+		t.Fatalf("expected a non-integer index to be refused, got %q", resp)
+	}
+}
+
+func TestSessionIDs(t *testing.T) {
+	srv := New(testConfig())
+	defer srv.Stop()
+
+	a, b := srv.newSession(nil), srv.newSession(nil)
+	if a.id != 1 || b.id != 2 {
+		t.Fatalf("expected sessions to be numbered in order, got %d and %d", a.id, b.id)
+	}
+	a.started("GET")
+	if a.lastCmd != "GET" || a.commands != 1 || a.lastUsed.Before(a.created) {
+		t.Fatalf("unexpected session stats: %+v", a)
+	}
+}
+
+func TestSlidingDeadlines(t *testing.T) {
+	cfg := testConfig()
+	cfg.ReadTimeout = 200 * time.Millisecond
+	cfg.IdleTimeout = 300 * time.Millisecond
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	// A connection in use outlives both timeouts.
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	for i := 0; i < 5; i++ {
+		c.send("PING")
+		c.expect("PONG")
+		time.Sleep(150 * time.Millisecond)
+	}
+
+	// Idle for longer than idle_timeout, it is closed.
+	time.Sleep(300 * time.Millisecond)
+	if _, err := c.parser.ReadReply(); err != io.EOF {
+		t.Fatalf("expected an idle connection to be closed, got %v", err)
+	}
+
+	// So is one too slow to send the rest of a command.
+	slow := dialTest(t, port)
+	defer slow.conn.Close()
+	slow.conn.Write([]byte("*2\r\n$4\r\nECHO\r\n"))
+	if _, err := slow.parser.ReadReply(); err != io.EOF {
+		t.Fatalf("expected a half-sent command to time out, got %v", err)
+	}
+
+	// Subscribers wait for messages, and are not reaped.
+	sub := dialTest(t, port)
+	defer sub.conn.Close()
+	sub.send("SUBSCRIBE", "ch")
+	sub.expect("subscribe", "ch", "1")
+	time.Sleep(500 * time.Millisecond)
+	sendCommand(t, port, []string{"PUBLISH", "ch", "m"})
+	sub.expect("message", "ch", "m")
+}
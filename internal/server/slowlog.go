@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redis-from-scratch/internal/command"
+)
+
+// As in Redis, slow log entries keep at most slowlogMaxArgs arguments,
+// the last standing for the rest, of at most slowlogMaxArgLen bytes each.
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowLog records the commands that took slowlog-log-slower-than
+// microseconds or more, for SLOWLOG: the latest slowlog-max-len in memory
+// and, with slowlog_file, every one in that file as a JSON line, so that
+// those the ring dropped can still be looked into.
+type slowLog struct {
+	threshold atomic.Int64 // microseconds; negative disables it
+	file      *jsonLog
+
+	mu      sync.Mutex
+	maxLen  int
+	entries []slowEntry // newest first
+	nextID  int64
+}
+
+// slowEntry is a command the slow log recorded, as SLOWLOG GET replies
+// with it and, in JSON, as slowlog_file holds it.
+type slowEntry struct {
+	ID       int64    `json:"id"`
+	Time     int64    `json:"time"`
+	Duration int64    `json:"duration_us"`
+	Args     []string `json:"args"`
+	Addr     string   `json:"addr"`
+	Name     string   `json:"name"`
+}
+
+func newSlowLog(threshold, maxLen int) *slowLog {
+	l := &slowLog{maxLen: maxLen}
+	l.threshold.Store(int64(threshold))
+	return l
+}
+
+// add records the command sess ran, with its arguments after the name, if
+// it took d or more than the threshold.
+func (l *slowLog) add(sess *session, cmd string, args []string, d time.Duration) {
+	threshold := l.threshold.Load()
+	if threshold < 0 || d.Microseconds() < threshold {
+		return
+	}
+	l.mu.Lock()
+	if l.maxLen == 0 && l.file == nil {
+		l.mu.Unlock()
+		return
+	}
+	e := slowEntry{
+		ID:       l.nextID,
+		Time:     time.Now().Unix(),
+		Duration: d.Microseconds(),
+		Args:     slowlogArgs(cmd, args),
+		Addr:     sess.conn.RemoteAddr().String(),
+		Name:     sess.name,
+	}
+	l.nextID++
+	if l.maxLen > 0 {
+		l.entries = append(l.entries, slowEntry{})
+		copy(l.entries[1:], l.entries)
+		l.entries[0] = e
+		l.trim()
+	}
+	l.mu.Unlock()
+	if l.file != nil {
+		l.file.write(&e)
+	}
+}
+
+// trim drops the oldest entries over maxLen. It is called with mu held.
+func (l *slowLog) trim() {
+	if len(l.entries) > l.maxLen {
+		clear(l.entries[l.maxLen:])
+		l.entries = l.entries[:l.maxLen]
+	}
+}
+
+// slowlogArgs returns the command's name and arguments as the slow log
+// keeps them: secrets redacted, and cut down to slowlogMaxArgs of
+// slowlogMaxArgLen bytes.
+func slowlogArgs(cmd string, args []string) []string {
+	n := min(len(args)+1, slowlogMaxArgs)
+	out := make([]string, 0, n)
+	out = append(out, strings.ToLower(cmd))
+	for i, arg := range args {
+		if len(out) == slowlogMaxArgs-1 && len(args)-i > 1 {
+			out = append(out, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		if redacted(cmd, args, i) {
+			arg = "(redacted)"
+		} else if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// redacted reports whether the argument at i of the upper-cased command
+// may hold a password, and is left out of the slow log.
+func redacted(cmd string, args []string, i int) bool {
+	switch cmd {
+	case "AUTH", "HELLO":
+		return true
+	case "ACL":
+		return strings.EqualFold(args[0], "SETUSER") && i >= 2
+	case "CONFIG":
+		return i == 2 && strings.EqualFold(args[0], "SET") &&
+			(strings.EqualFold(args[1], "requirepass") || strings.EqualFold(args[1], "masterauth"))
+	case "MIGRATE":
+		// The password after AUTH, or the user and password after AUTH2.
+		for j := 5; j < i; j++ {
+			switch strings.ToUpper(args[j]) {
+			case "AUTH":
+				return i == j+1
+			case "AUTH2":
+				return i <= j+2
+			case "KEYS":
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// SLOWLOG GET [count] | LEN | RESET reports the commands that took
+// slowlog-log-slower-than microseconds or more: the latest count, 10 by
+// default or all for -1, newest first, as id, Unix time, duration in
+// microseconds, arguments, client address and name.
+func (s *Server) cmdSlowlog(args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'slowlog' command")}
+	}
+	sub := strings.ToUpper(args[0])
+	wrongArgs := command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'slowlog|%s' command", strings.ToLower(sub))}
+	l := s.slowlog
+	switch sub {
+	case "GET":
+		if len(args) > 2 {
+			return wrongArgs
+		}
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < -1 {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR count should be greater than or equal to -1")}
+			}
+			count = n
+		}
+		l.mu.Lock()
+		if count == -1 || count > len(l.entries) {
+			count = len(l.entries)
+		}
+		out := make([]any, 0, count)
+		for _, e := range l.entries[:count] {
+			out = append(out, []any{e.ID, e.Time, e.Duration, e.Args, e.Addr, e.Name})
+		}
+		l.mu.Unlock()
+		return command.Response{Type: command.TypeValue, Value: out}
+	case "LEN":
+		if len(args) != 1 {
+			return wrongArgs
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return command.Response{Type: command.TypeInteger, Value: len(l.entries)}
+	case "RESET":
+		if len(args) != 1 {
+			return wrongArgs
+		}
+		l.mu.Lock()
+		l.entries = nil
+		l.mu.Unlock()
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	default:
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", args[0])}
+	}
+}
+
+func (s *Server) getSlowlogSlowerThan() string {
+	return strconv.FormatInt(s.slowlog.threshold.Load(), 10)
+}
+
+func (s *Server) setSlowlogSlowerThan(value string) error {
+	us, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("argument must be an integer")
+	}
+	s.slowlog.threshold.Store(us)
+	return nil
+}
+
+func (s *Server) getSlowlogMaxLen() string {
+	s.slowlog.mu.Lock()
+	defer s.slowlog.mu.Unlock()
+	return strconv.Itoa(s.slowlog.maxLen)
+}
+
+func (s *Server) setSlowlogMaxLen(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("argument must be a non-negative integer")
+	}
+	s.slowlog.mu.Lock()
+	defer s.slowlog.mu.Unlock()
+	s.slowlog.maxLen = n
+	s.slowlog.trim()
+	return nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSlowlog(t *testing.T) {
+	cfg := testConfig()
+	cfg.SlowlogMaxLen = 3
+	cfg.SlowlogFile = filepath.Join(t.TempDir(), "slowlog.log")
+	srv, port := startTestServerWithConfig(t, cfg)
+
+	// With a threshold of 0 every command is slow.
+	c := dialTest(t, port)
+	c.send("CLIENT", "SETNAME", "tester")
+	c.expect("OK")
+	c.send("AUTH", "secret")
+	c.read()
+	c.send("SET", "k", strings.Repeat("v", 200))
+	c.expect("OK")
+	push := []string{"RPUSH", "l"}
+	for i := 0; i < 40; i++ {
+		push = append(push, strconv.Itoa(i))
+	}
+	c.send(push...)
+	c.read()
+
+	c.send("SLOWLOG", "GET", "-1")
+	r, err := c.parser.ReadReply()
+	if err != nil || len(r.Array) != 3 {
+		t.Fatalf("expected the latest 3 entries, got %+v (%v)", r, err)
+	}
+	args := func(i int) []string {
+		var out []string
+		for _, a := range r.Array[i].Array[3].Array {
+			out = append(out, a.Str)
+		}
+		return out
+	}
+	rpush := args(0)
+	if len(rpush) != slowlogMaxArgs || rpush[0] != "rpush" || rpush[31] != "... (11 more arguments)" {
+		t.Fatalf("expected RPUSH's arguments cut down, got %q", rpush)
+	}
+	if e := r.Array[0].Array; e[0].Int != 3 || e[4].Str != c.conn.LocalAddr().String() || e[5].Str != "tester" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if set := args(1); set[2] != strings.Repeat("v", 128)+"... (72 more bytes)" {
+		t.Fatalf("expected SET's value cut down, got %q", set)
+	}
+	if auth := args(2); len(auth) != 2 || auth[1] != "(redacted)" {
+		t.Fatalf("expected AUTH's password redacted, got %q", auth)
+	}
+	c.send("SLOWLOG", "LEN")
+	c.expect("3")
+	c.send("SLOWLOG", "RESET")
+	c.expect("OK")
+	c.send("SLOWLOG", "LEN")
+	c.expect("1")
+
+	c.send("CONFIG", "SET", "slowlog-log-slower-than", "-1")
+	c.expect("OK")
+	c.send("SLOWLOG", "RESET")
+	c.expect("OK")
+	c.send("SLOWLOG", "LEN")
+	c.expect("0")
+	srv.Stop()
+
+	// The file keeps the entries the ring dropped.
+	data, err := os.ReadFile(cfg.SlowlogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("expected the password left out of the slow log file, got %q", data)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("expected 8 entries in the slow log file, got %q", data)
+	}
+	var e slowEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", lines[0], err)
+	}
+	if e.ID != 0 || e.Name != "tester" || len(e.Args) != 3 || e.Args[0] != "client" {
+		t.Fatalf("unexpected first entry %+v", e)
+	}
+}
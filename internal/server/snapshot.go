@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/logging"
+	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/store"
+)
+
+// snapshotPath returns where SAVE and BGSAVE write the dataset.
+func (s *Server) snapshotPath() string {
+	name := s.cfg.SnapshotFile
+	if name == "" {
+		name = "dump.snapshot"
+	}
+	return filepath.Join(s.cfg.PersistencePath, name)
+}
+
+// loadSnapshot restores the dataset from the snapshot file, if there is one.
+// A snapshot that cannot be loaded sets s.loadErr: starting empty would let
+// the next save replace the only copy of the data.
+func (s *Server) loadSnapshot() {
+	l, ok := s.store.(store.Loader)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	if err := persistence.LoadSnapshot(s.snapshotPath(), l, s.encryption); err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		s.loadErr = err
+		if errors.Is(err, persistence.ErrNoKey) {
+			logging.Errorf("%v; set encryption_key or %s", err, persistence.EncryptionKeyEnv)
+		} else {
+			logging.Errorf("%v; fix or move the snapshot aside to start", err)
+		}
+		return
+	}
+	logging.Infof("Loaded %d keys from snapshot in %v", s.store.Size(), time.Since(start))
+}
+
+// save writes a snapshot and records the outcome for LASTSAVE and INFO.
+// Changes made while it runs stay counted towards the save rules.
+func (s *Server) save() error {
+	s.saveMu.Lock()
+	s.lastSaveTry = time.Now()
+	s.saveMu.Unlock()
+	dirty := s.dirty.Load()
+	start := time.Now()
+	n, err := persistence.WriteSnapshot(s.snapshotPath(), s.store, s.compression, s.encryption)
+	s.latency.Add("save", time.Since(start))
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.lastSaveErr = err
+	if err != nil {
+		logging.Errorf("Snapshot failed: %v", err)
+		return err
+	}
+	s.dirty.Add(-dirty)
+	s.lastSave = time.Now()
+	logging.Infof("Saved %d keys to %s", n, s.snapshotPath())
+	return nil
+}
+
+// beginSave marks a save as running. It reports false if one already is.
+func (s *Server) beginSave() bool {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	if s.saving {
+		return false
+	}
+	s.saving = true
+	return true
+}
+
+func (s *Server) endSave() {
+	s.saveMu.Lock()
+	s.saving = false
+	s.saveMu.Unlock()
+}
+
+// SAVE writes a snapshot before replying.
+func (s *Server) cmdSave(args []string) command.Response {
+	if len(args) != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'save' command")}
+	}
+	if !s.beginSave() {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Background save already in progress")}
+	}
+	defer s.endSave()
+	if err := s.save(); err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR %v", err)}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// BGSAVE writes a snapshot in the background. The snapshot iterator keeps
+// the saved view consistent while clients continue writing.
+func (s *Server) cmdBgSave(args []string) command.Response {
+	if len(args) > 1 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'bgsave' command")}
+	}
+	if !s.startBgSave() {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR Background save already in progress")}
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "Background saving started"}
+}
+
+// startBgSave starts a background save unless a save is already running.
+func (s *Server) startBgSave() bool {
+	if !s.beginSave() {
+		return false
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.endSave()
+		defer s.recoverPanic("background save")
+		s.save()
+	}()
+	return true
+}
+
+// LASTSAVE returns the Unix time of the last successful save.
+func (s *Server) cmdLastSave(args []string) command.Response {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return command.Response{Type: command.TypeInteger, Value: int(s.lastSave.Unix())}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/internal/persistence"
+	"redis-from-scratch/internal/store"
+)
+
+func TestSaveAndLoadSnapshot(t *testing.T) {
+	cfg := testConfig()
+	cfg.PersistencePath = t.TempDir()
+
+	srv, port := startTestServerWithConfig(t, cfg)
+	time.Sleep(100 * time.Millisecond)
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	sendCommand(t, port, []string{"RPUSH", "l", "a", "b"})
+	sendCommand(t, port, []string{"SET", "ttl", "v", "EX", "100"})
+
+	before := sendCommand(t, port, []string{"LASTSAVE"})
+	time.Sleep(1100 * time.Millisecond)
+	if resp := sendCommand(t, port, []string{"SAVE"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("SAVE failed: %s", resp)
+	}
+	if after := sendCommand(t, port, []string{"LASTSAVE"}); after == before {
+		t.Fatalf("expected LASTSAVE to advance, still %s", after)
+	}
+	srv.Stop()
+
+	srv, port = startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if resp := sendCommand(t, port, []string{"GET", "k"}); !strings.Contains(resp, "v") {
+		t.Fatalf("expected key restored from snapshot, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"LRANGE", "l", "0", "-1"}); !strings.Contains(resp, "a") || !strings.Contains(resp, "b") {
+		t.Fatalf("expected list restored from snapshot, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"INFO", "keyspace"}); !strings.Contains(resp, "keys=3,expires=1") {
+		t.Fatalf("expected TTL restored from snapshot, got %s", resp)
+	}
+}
+
+func TestBgSave(t *testing.T) {
+	cfg := testConfig()
+	cfg.PersistencePath = t.TempDir()
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	if resp := sendCommand(t, port, []string{"BGSAVE"}); !strings.Contains(resp, "Background saving started") {
+		t.Fatalf("BGSAVE failed: %s", resp)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		srv.saveMu.Lock()
+		done := !srv.saving
+		srv.saveMu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("BGSAVE did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if srv.lastSaveErr != nil {
+		t.Fatalf("BGSAVE failed: %v", srv.lastSaveErr)
+	}
+}
+
+func TestStopWritesOnSaveError(t *testing.T) {
+	cfg := testConfig()
+	cfg.PersistencePath = filepath.Join(t.TempDir(), "data")
+	cfg.StopWritesOnError = true
+	cfg.Save = "3600 1"
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+	// A file where the persistence directory should be makes saves fail.
+	os.WriteFile(cfg.PersistencePath, nil, 0644)
+	time.Sleep(100 * time.Millisecond)
+
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	if resp := sendCommand(t, port, []string{"SAVE"}); !strings.Contains(resp, "-ERR") {
+		t.Fatalf("expected SAVE to fail, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SET", "k", "v2"}); !strings.HasPrefix(resp, "-MISCONF") {
+		t.Fatalf("expected writes to be rejected, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"GET", "k"}); !strings.Contains(resp, "$1\r\nv\r\n") {
+		t.Fatalf("expected reads to keep working, got %s", resp)
+	}
+	resp := sendCommand(t, port, []string{"INFO", "persistence"})
+	if !strings.Contains(resp, "rdb_last_bgsave_status:err") || !strings.Contains(resp, "writes_denied:1") {
+		t.Fatalf("expected INFO to report the failure, got %s", resp)
+	}
+
+	os.Remove(cfg.PersistencePath)
+	os.Mkdir(cfg.PersistencePath, 0755)
+	if resp := sendCommand(t, port, []string{"SAVE"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("SAVE failed: %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"SET", "k", "v2"}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("expected writes to resume after a successful save, got %s", resp)
+	}
+	if resp := sendCommand(t, port, []string{"INFO", "persistence"}); !strings.Contains(resp, "writes_denied:0") {
+		t.Fatalf("expected INFO to report writes allowed, got %s", resp)
+	}
+}
+
+func TestUnloadableSnapshotRefusesToStart(t *testing.T) {
+	written, err := persistence.NewEncryption(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := store.New()
+	kv.Set("k", "v", 0)
+
+	for name, write := range map[string]func(path string) error{
+		"corrupt": func(path string) error {
+			return os.WriteFile(path, []byte("not a snapshot"), 0644)
+		},
+		"wrong key": func(path string) error {
+			_, err := persistence.WriteSnapshot(path, kv, persistence.CompressionNone, written)
+			return err
+		},
+	} {
+		cfg := testConfig()
+		cfg.PersistencePath = t.TempDir()
+		cfg.EncryptionKey = strings.Repeat("02", 32)
+		if err := write(filepath.Join(cfg.PersistencePath, "dump.snapshot")); err != nil {
+			t.Fatal(err)
+		}
+		srv := New(cfg)
+		err := srv.Start()
+		srv.Stop()
+		if err == nil || !strings.Contains(err.Error(), "refusing to start") {
+			t.Fatalf("%s: expected the server to refuse to start, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"redis-from-scratch/pkg/config"
+)
+
+// tlsConfigs builds the TLS configuration of the listener on tls_port and
+// the one replication and cluster links dial with, from tls_cert_file,
+// tls_key_file and tls_ca_cert_file. Both are nil when TLS is not
+// configured. Links present the same certificate, and verify the other
+// server's against the CA file, or the system's roots without one. With
+// tls_auth_clients yes, clients must present a certificate the CA file
+// verifies; with optional, one they present is verified.
+func tlsConfigs(cfg *config.Config) (server, client *tls.Config, err error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.TLSPort != 0 || cfg.TLSReplication || cfg.TLSCluster {
+			return nil, nil, errors.New("tls_cert_file and tls_key_file are required for TLS")
+		}
+		return nil, nil, nil
+	}
+	if cfg.TLSCluster && cfg.TLSPort == 0 {
+		return nil, nil, errors.New("tls_cluster requires tls_port")
+	}
+	auth, err := clientAuth(cfg.TLSAuthClients)
+	if err != nil {
+		return nil, nil, err
+	}
+	if auth != tls.NoClientCert && cfg.TLSCACertFile == "" {
+		return nil, nil, errors.New("tls_auth_clients requires tls_ca_cert_file")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	var roots *x509.CertPool
+	if cfg.TLSCACertFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCACertFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS CA certificates: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", cfg.TLSCACertFile)
+		}
+	}
+	server = &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: auth, ClientCAs: roots, MinVersion: tls.VersionTLS12}
+	client = &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots, MinVersion: tls.VersionTLS12}
+	return server, client, nil
+}
+
+// clientAuth parses tls_auth_clients: no, the default, yes or optional.
+func clientAuth(mode string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(mode) {
+	case "", "no":
+		return tls.NoClientCert, nil
+	case "yes":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("tls_auth_clients must be yes, no or optional, got %q", mode)
+}
+
+// dialLink connects to another server for replication, the cluster bus or
+// MIGRATE, over TLS if useTLS. The handshake is bounded by timeout too.
+func (s *Server) dialLink(addr string, timeout time.Duration, useTLS bool) (net.Conn, error) {
+	if !useTLS {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, s.tlsClient)
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-from-scratch/pkg/config"
+)
+
+// testCerts writes a CA and a certificate it signed for localhost to dir,
+// as ca.crt, server.crt and server.key.
+func testCerts(t *testing.T, dir string) {
+	t.Helper()
+	writePEM := func(name, kind string, der []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM("server.crt", "CERTIFICATE", der)
+	writePEM("server.key", "EC PRIVATE KEY", keyDER)
+	writePEM("ca.crt", "CERTIFICATE", caDER)
+}
+
+// tlsTestConfig is a test config with the certificates in dir, which are
+// created by the first call.
+func tlsTestConfig(t *testing.T, dir string) *config.Config {
+	cfg := testConfig()
+	cfg.PersistencePath = t.TempDir()
+	if _, err := os.Stat(filepath.Join(dir, "ca.crt")); err != nil {
+		testCerts(t, dir)
+	}
+	cfg.TLSCertFile = filepath.Join(dir, "server.crt")
+	cfg.TLSKeyFile = filepath.Join(dir, "server.key")
+	cfg.TLSCACertFile = filepath.Join(dir, "ca.crt")
+	return cfg
+}
+
+// startTLSServer starts a test server that also accepts TLS connections,
+// returning its plaintext and TLS ports. With cluster_enabled it announces
+// the ports it listens on.
+func startTLSServer(t *testing.T, cfg *config.Config) (*Server, int, int) {
+	tln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	cfg.TLSPort = tln.Addr().(*net.TCPAddr).Port
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	cfg.Port = ln.Addr().(*net.TCPAddr).Port
+	srv, port := serveTestListener(New(cfg), ln)
+	srv.listenMu.Lock()
+	srv.tlsListener = tls.NewListener(tln, srv.tlsServer)
+	srv.listeners = append(srv.listeners, srv.tlsListener)
+	srv.listenMu.Unlock()
+	go srv.serve(srv.tlsListener)
+	return srv, port, cfg.TLSPort
+}
+
+func TestTLSReplication(t *testing.T) {
+	dir := t.TempDir()
+	master, mport, mtls := startTLSServer(t, tlsTestConfig(t, dir))
+	defer master.Stop()
+	cfg := tlsTestConfig(t, dir)
+	cfg.TLSReplication = true
+	replica, rport, _ := startTLSServer(t, cfg)
+	defer replica.Stop()
+
+	sendCommand(t, mport, []string{"SET", "k", "v"})
+	if resp := sendCommand(t, rport, []string{"REPLICAOF", "localhost", strconv.Itoa(mtls)}); !strings.Contains(resp, "+OK") {
+		t.Fatalf("REPLICAOF failed: %s", resp)
+	}
+	waitFor(t, "the sync over TLS", func() bool {
+		return sendCommand(t, rport, []string{"GET", "k"}) == "$1\r\nv\r\n"
+	})
+	sendCommand(t, mport, []string{"SET", "k", "w"})
+	waitFor(t, "the command stream over TLS", func() bool {
+		return sendCommand(t, rport, []string{"GET", "k"}) == "$1\r\nw\r\n"
+	})
+
+	// A replica that does not trust the master's CA cannot connect.
+	other := tlsTestConfig(t, t.TempDir())
+	other.TLSReplication = true
+	untrusting, uport, _ := startTLSServer(t, other)
+	defer untrusting.Stop()
+	sendCommand(t, uport, []string{"REPLICAOF", "localhost", strconv.Itoa(mtls)})
+	time.Sleep(200 * time.Millisecond)
+	if resp := sendCommand(t, uport, []string{"INFO", "replication"}); !strings.Contains(resp, "master_link_status:down") {
+		t.Fatalf("expected the untrusted master to be refused, got %q", resp)
+	}
+}
+
+func TestTLSCluster(t *testing.T) {
+	dir := t.TempDir()
+	nodeConfig := func() *config.Config {
+		cfg := tlsTestConfig(t, dir)
+		cfg.ClusterEnabled = true
+		cfg.ClusterNodeTimeout = 300 * time.Millisecond
+		cfg.TLSCluster = true
+		return cfg
+	}
+	a, aport, atls := startTLSServer(t, nodeConfig())
+	defer a.Stop()
+	b, bport, btls := startTLSServer(t, nodeConfig())
+	defer b.Stop()
+
+	sendCommand(t, aport, []string{"CLUSTER", "ADDSLOTSRANGE", "0", "16383"})
+	if resp := sendCommand(t, bport, []string{"CLUSTER", "MEET", "localhost", strconv.Itoa(aport)}); !strings.HasPrefix(resp, "-ERR Can't reach node") {
+		t.Fatalf("expected a plaintext meet to fail, got %q", resp)
+	}
+	if resp := sendCommand(t, bport, []string{"CLUSTER", "MEET", "localhost", strconv.Itoa(atls)}); resp != "+OK\r\n" {
+		t.Fatalf("CLUSTER MEET failed: %q", resp)
+	}
+	waitFor(t, "gossip over TLS", func() bool {
+		return strings.Contains(sendCommand(t, aport, []string{"CLUSTER", "INFO"}), "cluster_known_nodes:2\r\n") &&
+			strings.Contains(sendCommand(t, bport, []string{"CLUSTER", "INFO"}), "cluster_state:ok\r\n")
+	})
+	if resp := sendCommand(t, bport, []string{"GET", "foo"}); !isRedirect(resp, "MOVED", "12182", atls) {
+		t.Fatalf("expected a redirection to a's TLS port, got %q", resp)
+	}
+	if resp := sendCommand(t, bport, []string{"CLUSTER", "NODES"}); !strings.Contains(resp, ":"+strconv.Itoa(btls)+"@") {
+		t.Fatalf("expected b to announce its TLS port, got %q", resp)
+	}
+}
+
+// tlsPing sends PING over TLS to port, trusting the CA in dir and
+// presenting the certificate in certDir, if any, and returns the reply.
+func tlsPing(port int, dir, certDir string) (string, error) {
+	pem, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return "", err
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	cfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if certDir != "" {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(certDir, "server.crt"), filepath.Join(certDir, "server.key"))
+		if err != nil {
+			return "", err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	conn, err := tls.Dial("tcp", "localhost:"+strconv.Itoa(port), cfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 7)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
+
+func TestTLSAuthClients(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	tlsTestConfig(t, other)
+	for _, tc := range []struct {
+		mode                     string
+		none, trusted, untrusted bool
+	}{
+		{"no", true, true, true},
+		{"yes", false, true, false},
+		{"optional", true, true, false},
+	} {
+		cfg := tlsTestConfig(t, dir)
+		cfg.TLSAuthClients = tc.mode
+		srv, _, tport := startTLSServer(t, cfg)
+		for _, c := range []struct {
+			certDir string
+			ok      bool
+		}{{"", tc.none}, {dir, tc.trusted}, {other, tc.untrusted}} {
+			reply, err := tlsPing(tport, dir, c.certDir)
+			if ok := err == nil && reply == "+PONG\r\n"; ok != c.ok {
+				t.Errorf("tls_auth_clients %s with certificate %q: got %q, %v", tc.mode, c.certDir, reply, err)
+			}
+		}
+		srv.Stop()
+	}
+
+	cfg := tlsTestConfig(t, dir)
+	cfg.TLSAuthClients = "yes"
+	cfg.TLSCACertFile = ""
+	if srv := New(cfg); srv.loadErr == nil {
+		t.Fatal("expected tls_auth_clients to require tls_ca_cert_file")
+	}
+	cfg.TLSAuthClients = "maybe"
+	if srv := New(cfg); srv.loadErr == nil {
+		t.Fatal("expected an invalid tls_auth_clients to be refused")
+	}
+}
+
+func TestTLSOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := tlsTestConfig(t, dir)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.TLSPort = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	cfg.Port = 0
+	srv := New(cfg)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if srv.listener != nil {
+		t.Fatal("expected no plaintext listener with port 0")
+	}
+	if reply, err := tlsPing(cfg.TLSPort, dir, ""); err != nil || reply != "+PONG\r\n" {
+		t.Fatalf("expected PONG over TLS, got %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/tracking"
+)
+
+// invalidateChannel is the Pub/Sub channel RESP2 connections receive the
+// invalidations redirected to them on.
+const invalidateChannel = "__redis__:invalidate"
+
+// invalidator is a connection with CLIENT TRACKING on, telling it or the
+// connection it redirects to when the keys it read change.
+type invalidator struct {
+	s        *Server
+	sess     *session
+	redirect *session
+}
+
+// Invalidate pushes ["invalidate", keys] to a RESP3 connection, or
+// ["message", "__redis__:invalidate", keys] to a RESP2 one subscribed to
+// that channel; RESP2 connections otherwise have no way of receiving it.
+// keys is null after a flush. A connection whose redirection closed is
+// told ["tracking-redir-broken", id] instead.
+func (i *invalidator) Invalidate(keys []string) {
+	var v any
+	if keys != nil {
+		v = keys
+	}
+	target := i.sess
+	if i.redirect != nil {
+		select {
+		case <-i.redirect.closed:
+			if i.sess.resp3.Load() {
+				i.sess.push([]any{"tracking-redir-broken", i.redirect.id})
+			}
+			return
+		default:
+		}
+		target = i.redirect
+	}
+	switch {
+	case i.s.pubsub.Subscribed(target, invalidateChannel):
+		target.push([]any{"message", invalidateChannel, v})
+	case target.resp3.Load():
+		target.push([]any{"invalidate", v})
+	}
+}
+
+// invalidate tells the tracking connections that the keys of a write from
+// sess, which changed the dataset, did.
+func (s *Server) invalidate(sess *session, cmd string, args []string) {
+	if cmd == "FLUSHDB" || cmd == "FLUSHALL" {
+		s.tracking.Flush()
+		return
+	}
+	var origin tracking.Client
+	if sess != nil && sess.tracker != nil {
+		origin = sess.tracker
+	}
+	s.tracking.Invalidate(origin, command.Keys(cmd, args))
+}
+
+// CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix ...] [BCAST] [NOLOOP]
+// turns server-assisted client-side caching on or off for the connection:
+// while on, it is told when the keys it reads change, or with BCAST any
+// key starting with one of the prefixes. REDIRECT sends the invalidations
+// to another connection instead, as RESP2 clients need.
+func (s *Server) cmdClientTracking(sess *session, args []string) command.Response {
+	if len(args) == 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'client|tracking' command")}
+	}
+	on := strings.EqualFold(args[0], "ON")
+	if !on && !strings.EqualFold(args[0], "OFF") {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+	}
+	var opts tracking.Options
+	var redirect *session
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "BCAST":
+			opts.BCast = true
+		case opt == "NOLOOP":
+			opts.NoLoop = true
+		case opt == "PREFIX" && i+1 < len(args):
+			opts.Prefixes = append(opts.Prefixes, args[i+1])
+			i++
+		case opt == "REDIRECT" && i+1 < len(args):
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+			}
+			if redirect = s.client(id); redirect == nil {
+				return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR The client ID you want redirect to does not exist")}
+			}
+			i++
+		default:
+			return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR syntax error")}
+		}
+	}
+	if len(opts.Prefixes) > 0 && !opts.BCast {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR PREFIX option requires BCAST mode to be enabled")}
+	}
+
+	if !on {
+		if sess.tracker != nil {
+			s.tracking.Disable(sess.tracker)
+			sess.tracker = nil
+		}
+		return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+	}
+	if sess.tracker != nil {
+		s.tracking.Disable(sess.tracker)
+	}
+	sess.tracker = &invalidator{s: s, sess: sess, redirect: redirect}
+	s.tracking.Enable(sess.tracker, opts)
+	// Invalidations are written as they come, like published messages.
+	s.startPushLoop(sess)
+	if redirect != nil {
+		s.startPushLoop(redirect)
+	}
+	return command.Response{Type: command.TypeSimpleString, Value: "OK"}
+}
+
+// CLIENT GETREDIR replies with the id tracking redirects to, 0 without
+// redirection or -1 with tracking off.
+func (s *Server) cmdClientGetRedir(sess *session, args []string) command.Response {
+	if len(args) != 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'client|getredir' command")}
+	}
+	switch {
+	case sess.tracker == nil:
+		return command.Response{Type: command.TypeInteger, Value: -1}
+	case sess.tracker.redirect == nil:
+		return command.Response{Type: command.TypeInteger, Value: 0}
+	}
+	return command.Response{Type: command.TypeInteger, Value: int(sess.tracker.redirect.id)}
+}
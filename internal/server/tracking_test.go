@@ -0,0 +1,130 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// readPush reads a push or array and returns its kind and, flattened, the
+// keys it carries last; nil keys mean a flush.
+func (c *testConn) readPush() (kind string, keys []string) {
+	c.t.Helper()
+	r, err := c.parser.ReadReply()
+	if err != nil || len(r.Array) < 2 {
+		c.t.Fatalf("expected a message, got %+v (%v)", r, err)
+	}
+	last := r.Array[len(r.Array)-1]
+	if last.Null {
+		return r.Array[0].Str, nil
+	}
+	return r.Array[0].Str, last.Strings()
+}
+
+func TestClientTracking(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("HELLO", "3")
+	c.read()
+	c.send("CLIENT", "GETREDIR")
+	c.expect("-1")
+	c.send("CLIENT", "TRACKING", "ON", "PREFIX", "a")
+	c.expect("-ERR PREFIX option requires BCAST mode to be enabled")
+	c.send("CLIENT", "TRACKING", "ON")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("<nil>")
+
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	if kind, keys := c.readPush(); kind != "invalidate" || strings.Join(keys, ",") != "k" {
+		t.Fatalf("expected k to be invalidated, got %s %q", kind, keys)
+	}
+	// Once, until read again.
+	sendCommand(t, port, []string{"SET", "k", "w"})
+	c.send("SET", "k", "x")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("x")
+	// Without NOLOOP a connection is told of its own writes, before their
+	// replies.
+	c.send("DEL", "k")
+	if kind, keys := c.readPush(); kind != "invalidate" || strings.Join(keys, ",") != "k" {
+		t.Fatalf("expected k to be invalidated, got %s %q", kind, keys)
+	}
+	c.expect("1")
+
+	c.send("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "NOLOOP")
+	c.expect("OK")
+	c.send("SET", "user:1", "me")
+	c.expect("OK")
+	sendCommand(t, port, []string{"SET", "other", "v"})
+	sendCommand(t, port, []string{"SET", "user:2", "v"})
+	if kind, keys := c.readPush(); kind != "invalidate" || strings.Join(keys, ",") != "user:2" {
+		t.Fatalf("expected only user:2 to be invalidated, got %s %q", kind, keys)
+	}
+	sendCommand(t, port, []string{"FLUSHALL"})
+	if kind, keys := c.readPush(); kind != "invalidate" || keys != nil {
+		t.Fatalf("expected a flush to invalidate everything, got %s %q", kind, keys)
+	}
+
+	c.send("CLIENT", "TRACKING", "OFF")
+	c.expect("OK")
+	sendCommand(t, port, []string{"SET", "user:3", "v"})
+	c.send("PING")
+	c.expect("PONG")
+}
+
+func TestClientTrackingRedirect(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	// A RESP2 client receives invalidations on a second connection.
+	inv := dialTest(t, port)
+	defer inv.conn.Close()
+	inv.send("HELLO")
+	id := inv.read()[7]
+	inv.send("SUBSCRIBE", invalidateChannel)
+	inv.expect("subscribe", invalidateChannel, "1")
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("CLIENT", "TRACKING", "ON", "REDIRECT", "999")
+	c.expect("-ERR The client ID you want redirect to does not exist")
+	c.send("CLIENT", "TRACKING", "ON", "REDIRECT", id)
+	c.expect("OK")
+	c.send("CLIENT", "GETREDIR")
+	c.expect(id)
+	c.send("GET", "k")
+	c.expect("<nil>")
+	sendCommand(t, port, []string{"SET", "k", "v"})
+	if kind, keys := inv.readPush(); kind != "message" || strings.Join(keys, ",") != "k" {
+		t.Fatalf("expected k to be invalidated, got %s %q", kind, keys)
+	}
+}
+
+func TestClientTrackingExpiry(t *testing.T) {
+	srv, port := startTestServer(t)
+	defer srv.Stop()
+
+	c := dialTest(t, port)
+	defer c.conn.Close()
+	c.send("HELLO", "3")
+	c.read()
+	c.send("CLIENT", "TRACKING", "ON")
+	c.expect("OK")
+	c.send("SET", "k", "v", "PX", "50")
+	c.expect("OK")
+	c.send("GET", "k")
+	c.expect("v")
+
+	time.Sleep(60 * time.Millisecond)
+	if n := srv.store.CleanupExpired(); n != 1 {
+		t.Fatalf("expected k to expire, got %d keys", n)
+	}
+	if kind, keys := c.readPush(); kind != "invalidate" || strings.Join(keys, ",") != "k" {
+		t.Fatalf("expected the expired k to be invalidated, got %s %q", kind, keys)
+	}
+}
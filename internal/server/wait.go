@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"redis-from-scratch/internal/command"
+	"redis-from-scratch/internal/protocol"
+)
+
+// ack records that r has applied the stream up to offset.
+func (s *Server) ack(r *replica, offset int64) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	r.ackOffset, r.ackTime = offset, time.Now()
+	close(s.repl.acked)
+	s.repl.acked = make(chan struct{})
+}
+
+// ackedReplicas counts the replicas that acknowledged offset. It is called
+// with repl.mu held.
+func (s *Server) ackedReplicas(offset int64) int {
+	n := 0
+	for r := range s.repl.replicas {
+		if r.online() && r.ackOffset >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// requestAcks asks the replicas to acknowledge their offset now rather than
+// at their next periodic acknowledgement. It is called with repl.mu held.
+func (s *Server) requestAcks() {
+	if len(s.repl.replicas) == 0 {
+		return
+	}
+	s.repl.buf.Reset()
+	protocol.NewWriter(&s.repl.buf).WriteArray([]string{"REPLCONF", "GETACK", "*"})
+	s.repl.feed(s.repl.buf.Bytes())
+}
+
+// enoughReplicas reports whether a master has the min-replicas-to-write
+// replicas that acknowledged within min-replicas-max-lag, as writes require.
+func (s *Server) enoughReplicas() bool {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.minReplicas <= 0 || s.repl.link != nil {
+		return true
+	}
+	n := 0
+	for r := range s.repl.replicas {
+		// Lag is counted in whole seconds, as Redis does.
+		if r.online() && int(time.Since(r.ackTime)/time.Second) <= s.repl.maxLag {
+			n++
+		}
+	}
+	return n >= s.repl.minReplicas
+}
+
+// WAIT numreplicas timeout blocks until numreplicas replicas acknowledged
+// every write made before it, or timeout milliseconds passed (0 waits for
+// ever), and replies with the number of replicas that did.
+func (s *Server) cmdWait(args []string) command.Response {
+	if len(args) != 2 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR wrong number of arguments for 'wait' command")}
+	}
+	want, err := strconv.Atoi(args[0])
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR value is not an integer or out of range")}
+	}
+	ms, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR timeout is not an integer or out of range")}
+	}
+	if ms < 0 {
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR timeout is negative")}
+	}
+
+	s.repl.mu.Lock()
+	if s.repl.link != nil {
+		s.repl.mu.Unlock()
+		return command.Response{Type: command.TypeError, Error: fmt.Errorf("ERR WAIT cannot be used with replica instances")}
+	}
+	offset := s.repl.offset
+	n := s.ackedReplicas(offset)
+	if n < want {
+		s.requestAcks()
+	}
+	s.repl.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if ms > 0 {
+		t := time.NewTimer(time.Duration(ms) * time.Millisecond)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		s.repl.mu.Lock()
+		acked := s.repl.acked
+		n = s.ackedReplicas(offset)
+		s.repl.mu.Unlock()
+		if n >= want {
+			return command.Response{Type: command.TypeInteger, Value: n}
+		}
+		select {
+		case <-acked:
+		case <-timeout:
+			return command.Response{Type: command.TypeInteger, Value: n}
+		case <-s.quit:
+			return command.Response{Type: command.TypeInteger, Value: n}
+		}
+	}
+}
+
+func (s *Server) getMinReplicas() string {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	return strconv.Itoa(s.repl.minReplicas)
+}
+
+func (s *Server) setMinReplicas(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("argument must be a non-negative integer")
+	}
+	s.repl.mu.Lock()
+	s.repl.minReplicas = n
+	s.repl.mu.Unlock()
+	return nil
+}
+
+func (s *Server) getMaxLag() string {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	return strconv.Itoa(s.repl.maxLag)
+}
+
+func (s *Server) setMaxLag(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("argument must be a non-negative integer")
+	}
+	s.repl.mu.Lock()
+	s.repl.maxLag = n
+	s.repl.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,41 @@
+package server
+
+// workerPool runs commands on a fixed number of goroutines, so that however
+// many connections send commands at once, no more than that contend for
+// the store: the others wait for a worker to be free.
+type workerPool struct {
+	jobs chan job
+}
+
+// job is a command for a worker to run, and where its outcome goes.
+type job struct {
+	run  func() error
+	done chan<- error
+}
+
+// newWorkerPool starts n workers.
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{jobs: make(chan job)}
+	for i := 0; i < n; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for j := range p.jobs {
+		j.done <- j.run()
+	}
+}
+
+// do runs fn on a worker once one is free and returns its error. done,
+// buffered, is the caller's to reuse between calls.
+func (p *workerPool) do(done chan error, fn func() error) error {
+	p.jobs <- job{run: fn, done: done}
+	return <-done
+}
+
+// stop ends the workers, once no connection can call do.
+func (p *workerPool) stop() {
+	close(p.jobs)
+}
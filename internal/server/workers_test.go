@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolBounds(t *testing.T) {
+	p := newWorkerPool(2)
+	defer p.stop()
+
+	var running, most atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.do(make(chan error, 1), func() error {
+				n := running.Add(1)
+				for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+				}
+				time.Sleep(10 * time.Millisecond)
+				running.Add(-1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if got := most.Load(); got != 2 {
+		t.Fatalf("expected 2 commands at most at once, got %d", got)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	cfg := testConfig()
+	cfg.Workers = 1
+	srv, port := startTestServerWithConfig(t, cfg)
+	defer srv.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := dialTest(t, port)
+			key := "k" + strconv.Itoa(i)
+			// Pipelined, so the replies are read in order.
+			for _, args := range [][]string{{"SET", key, "v"}, {"RPUSH", "list", key}, {"GET", key}, {"PING"}} {
+				c.send(args...)
+			}
+			c.expect("OK")
+			c.read()
+			c.expect("v")
+			c.expect("PONG")
+		}(i)
+	}
+	wg.Wait()
+	c := dialTest(t, port)
+	c.send("LRANGE", "list", "0", "-1")
+	if got := c.read(); len(got) != 20 {
+		t.Fatalf("expected 20 elements, got %q", got)
+	}
+}
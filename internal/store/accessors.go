@@ -0,0 +1,131 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// NoExpiry is the TTL reported for keys without an expiry.
+const NoExpiry time.Duration = -1
+
+var errWrongType = fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
+
+// String returns the type name reported by the TYPE command.
+func (t ValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeHash:
+		return "hash"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	}
+	return "none"
+}
+
+// ttlOf returns the remaining lifetime of v, or NoExpiry.
+func ttlOf(v *Value, now time.Time) time.Duration {
+	if v.Expiry == nil {
+		return NoExpiry
+	}
+	return v.Expiry.Sub(now)
+}
+
+// live returns the unexpired value at key. Must be called with the lock held.
+func (s *Store) live(key string, now time.Time) (*Value, bool) {
+	v, ok := s.data[key]
+	if !ok || (v.Expiry != nil && now.After(*v.Expiry)) {
+		return nil, false
+	}
+	return v, true
+}
+
+// GetWithTTL returns the string at key together with its remaining TTL
+// (NoExpiry if it has none) under a single lock acquisition. It reports false
+// if the key does not exist or does not hold a string.
+func (s *Store) GetWithTTL(key string) (string, time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	v, ok := s.live(key, now)
+	if !ok || v.Type != TypeString {
+		return "", 0, false
+	}
+	s.touch(v)
+	return v.stringOf(), ttlOf(v, now), true
+}
+
+// TypeOf returns the type and remaining TTL of key.
+func (s *Store) TypeOf(key string) (ValueType, time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	v, ok := s.live(key, now)
+	if !ok {
+		return 0, 0, false
+	}
+	return v.Type, ttlOf(v, now), true
+}
+
+// Lookup returns a copy of the value at key whatever its type, with its
+// expiry, under a single lock acquisition.
+func (s *Store) Lookup(key string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.live(key, time.Now())
+	if !ok {
+		return Entry{}, false
+	}
+	s.touch(v)
+	return entryOf(key, v), true
+}
+
+// lookupTyped is Lookup plus a type check, returning the WRONGTYPE error when
+// the key holds another type.
+func (s *Store) lookupTyped(key string, t ValueType) (Entry, time.Duration, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	v, ok := s.live(key, now)
+	if !ok {
+		return Entry{}, 0, false, nil
+	}
+	if v.Type != t {
+		return Entry{}, 0, false, errWrongType
+	}
+	s.touch(v)
+	return entryOf(key, v), ttlOf(v, now), true, nil
+}
+
+// GetHash returns a copy of the hash at key and its remaining TTL.
+func (s *Store) GetHash(key string) (map[string]string, time.Duration, bool, error) {
+	e, ttl, ok, err := s.lookupTyped(key, TypeHash)
+	return e.Hash, ttl, ok, err
+}
+
+// GetList returns a copy of the list at key and its remaining TTL.
+func (s *Store) GetList(key string) ([]string, time.Duration, bool, error) {
+	e, ttl, ok, err := s.lookupTyped(key, TypeList)
+	return e.List, ttl, ok, err
+}
+
+// GetSet returns a copy of the set at key and its remaining TTL.
+func (s *Store) GetSet(key string) (map[string]struct{}, time.Duration, bool, error) {
+	e, ttl, ok, err := s.lookupTyped(key, TypeSet)
+	return e.Set, ttl, ok, err
+}
+
+// GetZSet returns the members of the sorted set at key, ordered by score,
+// and its remaining TTL.
+func (s *Store) GetZSet(key string) ([]ZMember, time.Duration, bool, error) {
+	e, ttl, ok, err := s.lookupTyped(key, TypeZSet)
+	return e.ZSet, ttl, ok, err
+}
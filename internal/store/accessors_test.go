@@ -0,0 +1,60 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetWithTTL(t *testing.T) {
+	store := New()
+	store.Set("plain", "v", 0)
+	store.Set("ttl", "v", 60000)
+	store.HashSet("h", "f", "v")
+
+	if v, ttl, ok := store.GetWithTTL("plain"); !ok || v != "v" || ttl != NoExpiry {
+		t.Fatalf("unexpected result for plain key: %q %v %v", v, ttl, ok)
+	}
+	if _, ttl, ok := store.GetWithTTL("ttl"); !ok || ttl <= 59*time.Second || ttl > 60*time.Second {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+	if _, _, ok := store.GetWithTTL("h"); ok {
+		t.Fatalf("GetWithTTL should not return non-string values")
+	}
+	if _, _, ok := store.GetWithTTL("missing"); ok {
+		t.Fatalf("expected missing key")
+	}
+}
+
+func TestTypedGetters(t *testing.T) {
+	store := New()
+	store.HashSet("h", "f", "v")
+	store.ZAdd("z", 2, "b")
+	store.ZAdd("z", 1, "a")
+
+	if typ, ttl, ok := store.TypeOf("z"); !ok || typ != TypeZSet || ttl != NoExpiry {
+		t.Fatalf("unexpected TypeOf result: %v %v %v", typ, ttl, ok)
+	}
+	if typ, _, _ := store.TypeOf("h"); typ.String() != "hash" {
+		t.Fatalf("expected hash, got %s", typ)
+	}
+
+	h, _, ok, err := store.GetHash("h")
+	if err != nil || !ok || h["f"] != "v" {
+		t.Fatalf("unexpected hash: %v %v %v", h, ok, err)
+	}
+	h["f"] = "changed"
+	if v, _, _ := store.HashGet("h", "f"); v != "v" {
+		t.Fatalf("GetHash must return a copy")
+	}
+
+	z, _, ok, err := store.GetZSet("z")
+	if err != nil || !ok || len(z) != 2 || z[0].Member != "a" {
+		t.Fatalf("unexpected zset: %v %v %v", z, ok, err)
+	}
+	if _, _, _, err := store.GetList("h"); err == nil {
+		t.Fatalf("expected WRONGTYPE error")
+	}
+	if _, _, ok, err := store.GetSet("missing"); ok || err != nil {
+		t.Fatalf("expected missing key without error")
+	}
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"hash/maphash"
+	"strconv"
+)
+
+// Encoding describes how a string value is represented in memory.
+type Encoding int
+
+const (
+	// EncodingRaw stores the value as a plain Go string.
+	EncodingRaw Encoding = iota
+	// EncodingInt stores a canonical integer string as an int64 and formats it on read.
+	EncodingInt
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case EncodingInt:
+		return "int"
+	default:
+		return "raw"
+	}
+}
+
+// sharedIntegerCount mirrors Redis' OBJ_SHARED_INTEGERS: integers in
+// [0, sharedIntegerCount) are formatted from a preallocated table so reading
+// small counters does not allocate.
+const sharedIntegerCount = 10000
+
+var sharedIntegers = func() [sharedIntegerCount]string {
+	var t [sharedIntegerCount]string
+	for i := range t {
+		t[i] = strconv.Itoa(i)
+	}
+	return t
+}()
+
+// formatInt returns the decimal form of n, using the shared table when possible.
+func formatInt(n int64) string {
+	if n >= 0 && n < sharedIntegerCount {
+		return sharedIntegers[n]
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// parseCanonicalInt reports whether s is an integer that round-trips exactly
+// through strconv.FormatInt. Values like "007", "+1" or " 1" are rejected so
+// that GET always returns the bytes the client stored.
+func parseCanonicalInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if formatInt(n) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// Default limits for the intern table.
+const (
+	defaultInternMaxLen     = 64
+	defaultInternMaxEntries = 100000
+)
+
+// interner deduplicates short, frequently repeated values so that many keys
+// holding the same payload share a single backing string. A value is only
+// interned the second time it is seen: the first sighting records its hash,
+// so values written once never take a place in the table. It is not safe
+// for concurrent use on its own and must be called with the store's write
+// lock held.
+type interner struct {
+	values     map[string]string
+	seen       map[uint64]struct{} // hashes of values seen once
+	seed       maphash.Seed
+	mem        int64 // accounted size of values and seen
+	maxLen     int
+	maxEntries int
+}
+
+func newInterner(maxLen, maxEntries int) *interner {
+	if maxLen <= 0 {
+		maxLen = defaultInternMaxLen
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultInternMaxEntries
+	}
+	return &interner{
+		values:     make(map[string]string),
+		seen:       make(map[uint64]struct{}),
+		seed:       maphash.MakeSeed(),
+		maxLen:     maxLen,
+		maxEntries: maxEntries,
+	}
+}
+
+// intern returns the canonical copy of s. Once the table is full new values
+// are returned unchanged rather than evicting existing entries; once the
+// record of values seen once is full it starts over.
+func (in *interner) intern(s string) string {
+	if in == nil || len(s) > in.maxLen {
+		return s
+	}
+	if canon, ok := in.values[s]; ok {
+		return canon
+	}
+	if len(in.values) >= in.maxEntries {
+		return s
+	}
+	h := maphash.String(in.seed, s)
+	if _, ok := in.seen[h]; !ok {
+		if len(in.seen) >= in.maxEntries {
+			clear(in.seen)
+			in.mem -= int64(in.maxEntries * elementOverhead)
+		}
+		in.seen[h] = struct{}{}
+		in.mem += elementOverhead
+		return s
+	}
+	delete(in.seen, h)
+	in.values[s] = s
+	in.mem += int64(fieldOverhead+len(s)) - elementOverhead
+	return s
+}
+
+// reset empties the table, releasing the values it holds.
+func (in *interner) reset() {
+	if in == nil {
+		return
+	}
+	clear(in.values)
+	clear(in.seen)
+	in.mem = 0
+}
+
+// intern interns value, accounting for the growth of the intern table in
+// s.used. Must be called with the write lock held.
+func (s *Store) intern(value string) string {
+	if s.strings == nil {
+		return value
+	}
+	before := s.strings.mem
+	value = s.strings.intern(value)
+	s.used += s.strings.mem - before
+	return value
+}
+
+// encodeString builds a string Value using the store's encoding options.
+func (s *Store) encodeString(value string) Value {
+	if s.opts.EncodeIntegers {
+		if n, ok := parseCanonicalInt(value); ok {
+			return Value{Type: TypeString, Encoding: EncodingInt, Int: n}
+		}
+	}
+	return Value{Type: TypeString, Str: s.intern(value)}
+}
+
+// stringOf returns the string form of a TypeString value regardless of encoding.
+func (v Value) stringOf() string {
+	if v.Encoding == EncodingInt {
+		return formatInt(v.Int)
+	}
+	return v.Str
+}
+
+// ObjectEncoding returns the Redis-style encoding name for the value at key,
+// as reported by OBJECT ENCODING.
+func (s *Store) ObjectEncoding(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.data[key]
+	if !ok || v.expired() {
+		return "", false
+	}
+	switch v.Type {
+	case TypeString:
+		if v.Encoding == EncodingInt {
+			return "int", true
+		}
+		return "raw", true
+	case TypeHash, TypeSet:
+		return "hashtable", true
+	case TypeList:
+		return "quicklist", true
+	case TypeZSet:
+		return "skiplist", true
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func TestIntegerEncodingRoundTrip(t *testing.T) {
+	store := NewWithOptions(Options{EncodeIntegers: true})
+	store.Set("counter", "12345", 0)
+	v, ok := store.Get("counter")
+	if !ok || v != "12345" {
+		t.Fatalf("expected 12345, got '%s' ok=%v", v, ok)
+	}
+	enc, _ := store.ObjectEncoding("counter")
+	if enc != "int" {
+		t.Fatalf("expected int encoding, got %s", enc)
+	}
+
+	store.Set("negative", "-42", 0)
+	if v, _ := store.Get("negative"); v != "-42" {
+		t.Fatalf("expected -42, got %s", v)
+	}
+}
+
+func TestIntegerEncodingKeepsNonCanonical(t *testing.T) {
+	store := NewWithOptions(Options{EncodeIntegers: true})
+	for _, in := range []string{"007", "+1", " 1", "1.5", "99999999999999999999"} {
+		store.Set("k", in, 0)
+		v, _ := store.Get("k")
+		if v != in {
+			t.Fatalf("expected %q, got %q", in, v)
+		}
+		if enc, _ := store.ObjectEncoding("k"); enc != "raw" {
+			t.Fatalf("expected raw encoding for %q, got %s", in, enc)
+		}
+	}
+}
+
+func TestInternStringsSharesBacking(t *testing.T) {
+	store := NewWithOptions(Options{InternStrings: true})
+	a := string([]byte("shared-value"))
+	b := string([]byte("shared-value"))
+	c := string([]byte("shared-value"))
+	store.Set("a", a, 0)
+	store.Set("b", b, 0)
+	store.Set("c", c, 0)
+
+	vb, _ := store.Get("b")
+	vc, _ := store.Get("c")
+	if unsafe.StringData(vb) != unsafe.StringData(vc) {
+		t.Fatalf("expected interned values to share storage")
+	}
+}
+
+func TestInternOnlyRepeatedValues(t *testing.T) {
+	store := NewWithOptions(Options{InternStrings: true})
+	for i := 0; i < 100; i++ {
+		store.Set(strconv.Itoa(i), "unique-"+strconv.Itoa(i), 0)
+	}
+	if n := len(store.strings.values); n != 0 {
+		t.Fatalf("expected values seen once to stay out of the table, got %d entries", n)
+	}
+	store.Set("x", "unique-1", 0)
+	if _, ok := store.strings.values["unique-1"]; !ok {
+		t.Fatalf("expected a repeated value to be interned")
+	}
+}
+
+func TestInternTableAccounted(t *testing.T) {
+	store := NewWithOptions(Options{InternStrings: true})
+	plain := New()
+	for _, s := range []*Store{store, plain} {
+		s.Set("a", "v", 0)
+		s.Set("b", "v", 0)
+		s.HashSet("h", "f", "v")
+	}
+	if store.UsedMemory() <= plain.UsedMemory() {
+		t.Fatalf("expected the intern table in used memory, got %d against %d without it", store.UsedMemory(), plain.UsedMemory())
+	}
+
+	store.Flush()
+	if len(store.strings.values) != 0 || len(store.strings.seen) != 0 || store.UsedMemory() != 0 {
+		t.Fatalf("expected a flush to release the intern table, got %d entries, %d seen and %d bytes used",
+			len(store.strings.values), len(store.strings.seen), store.UsedMemory())
+	}
+	store.Set("a", "v", 0)
+	store.Delete("a")
+	if used := store.UsedMemory(); used != elementOverhead {
+		t.Fatalf("expected only the value's sighting to stay accounted, got %d", used)
+	}
+}
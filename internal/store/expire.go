@@ -0,0 +1,26 @@
+package store
+
+import "time"
+
+// ExpireAt sets the absolute deadline of key, as PEXPIREAT does. A deadline
+// that has already passed deletes the key. It reports whether the key
+// existed.
+func (s *Store) ExpireAt(key string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	v, ok := s.live(key, now)
+	if !ok {
+		return false
+	}
+	if !at.After(now) {
+		s.remove(key)
+		return true
+	}
+	s.preserve(key)
+	s.count(v, -1)
+	v.Expiry = &at
+	s.count(v, 1)
+	return true
+}
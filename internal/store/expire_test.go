@@ -0,0 +1,30 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpireAt(t *testing.T) {
+	s := New()
+	s.HashSet("h", "f", "v")
+	if s.ExpireAt("missing", time.Now().Add(time.Hour)) {
+		t.Fatalf("expected false for a missing key")
+	}
+	if !s.ExpireAt("h", time.Now().Add(time.Hour)) {
+		t.Fatalf("expected true for an existing key")
+	}
+	if _, ttl, ok := s.TypeOf("h"); !ok || ttl <= 59*time.Minute {
+		t.Fatalf("expected an hour TTL, got %v", ttl)
+	}
+	if st := s.Stats(); st.Expires != 1 {
+		t.Fatalf("expected 1 key with a TTL, got %d", st.Expires)
+	}
+
+	if !s.ExpireAt("h", time.Now().Add(-time.Second)) {
+		t.Fatalf("expected true for an existing key")
+	}
+	if s.Exists("h") != 0 || s.Stats().Expires != 0 {
+		t.Fatalf("expected a past deadline to delete the key")
+	}
+}
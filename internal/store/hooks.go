@@ -0,0 +1,63 @@
+package store
+
+// Event identifies the kind of keyspace change reported to hooks.
+type Event int
+
+const (
+	// EventSet fires after a write creates or modifies a key.
+	EventSet Event = iota
+	// EventDelete fires after a key is removed by a command, including a
+	// collection becoming empty.
+	EventDelete
+	// EventExpire fires after a key is removed because its TTL passed.
+	EventExpire
+	// EventEvict fires after a key is removed by the eviction policy.
+	EventEvict
+
+	numEvents
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "del"
+	case EventExpire:
+		return "expired"
+	case EventEvict:
+		return "evicted"
+	}
+	return "unknown"
+}
+
+// Hooks run synchronously with the store's write lock held, so they observe
+// changes in commit order. They must be quick and must not call back into
+// the store; hand work off to a goroutine or channel instead. Flush does not
+// fire per-key events.
+
+// OnSet registers fn to be called after a key is created or modified.
+func (s *Store) OnSet(fn func(key string)) { s.on(EventSet, fn) }
+
+// OnDelete registers fn to be called after a key is deleted.
+func (s *Store) OnDelete(fn func(key string)) { s.on(EventDelete, fn) }
+
+// OnExpire registers fn to be called after a key expires.
+func (s *Store) OnExpire(fn func(key string)) { s.on(EventExpire, fn) }
+
+// OnEvict registers fn to be called after a key is evicted.
+func (s *Store) OnEvict(fn func(key string)) { s.on(EventEvict, fn) }
+
+func (s *Store) on(event Event, fn func(key string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks[event] = append(s.hooks[event], fn)
+}
+
+// emit runs the hooks registered for event. Must be called with the write
+// lock held.
+func (s *Store) emit(event Event, key string) {
+	for _, fn := range s.hooks[event] {
+		fn(key)
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordEvents(store *Store) *[]string {
+	var events []string
+	add := func(event Event) func(string) {
+		return func(key string) { events = append(events, event.String()+":"+key) }
+	}
+	store.OnSet(add(EventSet))
+	store.OnDelete(add(EventDelete))
+	store.OnExpire(add(EventExpire))
+	store.OnEvict(add(EventEvict))
+	return &events
+}
+
+func TestHooksFireOnWrites(t *testing.T) {
+	store := New()
+	events := recordEvents(store)
+
+	store.Set("k", "v", 0)
+	store.HashSet("h", "f", "v")
+	store.SetAdd("s", "m")
+	store.SetAdd("s", "m") // no change
+	store.ZAdd("z", 1, "m")
+	store.ZAdd("z", 1, "m") // no change
+	store.ListRPush("l", "a")
+	store.ListLPop("l")
+	store.Delete("k", "missing")
+
+	want := "set:k set:h set:s set:z set:l del:l del:k"
+	if got := strings.Join(*events, " "); got != want {
+		t.Fatalf("unexpected events:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestHooksFireOnExpireAndEvict(t *testing.T) {
+	store := NewWithOptions(Options{MaxMemory: 1, EvictionPolicy: PolicyAllKeysRandom})
+	events := recordEvents(store)
+
+	store.Set("ttl", "v", 1)
+	time.Sleep(5 * time.Millisecond)
+	store.CleanupExpired()
+
+	for i := 0; i < 3; i++ {
+		store.Set(fmt.Sprintf("k%d", i), "v", 0)
+	}
+	store.EvictIfNeeded()
+
+	got := strings.Join(*events, " ")
+	if !strings.Contains(got, "expired:ttl") {
+		t.Fatalf("expected expired event, got %s", got)
+	}
+	if strings.Count(got, "evicted:") != 3 {
+		t.Fatalf("expected 3 evicted events, got %s", got)
+	}
+}
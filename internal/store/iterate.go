@@ -0,0 +1,16 @@
+package store
+
+// ForEach calls fn with a copy of every live key in key order, stopping early
+// when fn returns false. It iterates a Snapshot, so fn sees the dataset as it
+// was when ForEach was called, runs without the store lock held and may
+// itself read or write the store.
+func (s *Store) ForEach(fn func(e Entry) bool) {
+	snap := s.BeginSnapshot()
+	defer snap.Close()
+	for {
+		e, ok := snap.Next()
+		if !ok || !fn(e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestForEachVisitsAllKeysInOrder(t *testing.T) {
+	store := New()
+	store.Set("b", "2", 0)
+	store.HashSet("a", "f", "v")
+	store.SetAdd("c", "m")
+
+	var keys []string
+	store.ForEach(func(e Entry) bool {
+		keys = append(keys, e.Key)
+		return true
+	})
+	if fmt.Sprint(keys) != "[a b c]" {
+		t.Fatalf("unexpected iteration order: %v", keys)
+	}
+}
+
+func TestForEachStopsEarlyAndAllowsWrites(t *testing.T) {
+	store := New()
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("k%d", i), "v", 0)
+	}
+	seen := 0
+	store.ForEach(func(e Entry) bool {
+		// Writing from the callback must not deadlock.
+		store.Set(e.Key, "updated", 0)
+		seen++
+		return seen < 3
+	})
+	if seen != 3 {
+		t.Fatalf("expected iteration to stop after 3 keys, got %d", seen)
+	}
+	if len(store.snapshots) != 0 {
+		t.Fatalf("ForEach must release its snapshot")
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"slices"
+	"sync"
+)
+
+// KeyLocker is implemented by engines that let a command lock the keys it
+// uses for its whole run, so that one made of several engine calls, like
+// SMOVE or RENAME, is atomic with respect to the other commands on those
+// keys. The command layer locks the keys of every keyspace command.
+type KeyLocker interface {
+	// LockKeys locks keys, exclusively if write is set and shared
+	// otherwise, and returns the function that unlocks them.
+	LockKeys(keys []string, write bool) (unlock func())
+	// LockKey and UnlockKey lock and unlock a single key as LockKeys
+	// would, without allocating, for the commands run most.
+	LockKey(key string, write bool)
+	UnlockKey(key string, write bool)
+	// LockAll locks every key exclusively, for commands like FLUSHDB that
+	// touch them all, and returns the function that unlocks them.
+	LockAll() (unlock func())
+}
+
+// keyLockStripes is the number of locks keys are spread over.
+const keyLockStripes = 256
+
+// KeyLocks is a KeyLocker for engines to embed: keys hash to one of a fixed
+// set of stripes, and a command locks its stripes in ascending order, once
+// each, so commands sharing keys cannot deadlock however they list them.
+// Keys sharing a stripe are merely serialized. The zero value is ready to
+// use.
+type KeyLocks struct {
+	stripes [keyLockStripes]sync.RWMutex
+}
+
+// LockKeys implements KeyLocker.
+func (l *KeyLocks) LockKeys(keys []string, write bool) func() {
+	order := stripesOf(keys)
+	for _, i := range order {
+		if write {
+			l.stripes[i].Lock()
+		} else {
+			l.stripes[i].RLock()
+		}
+	}
+	return func() {
+		for j := len(order) - 1; j >= 0; j-- {
+			if write {
+				l.stripes[order[j]].Unlock()
+			} else {
+				l.stripes[order[j]].RUnlock()
+			}
+		}
+	}
+}
+
+// LockKey implements KeyLocker.
+func (l *KeyLocks) LockKey(key string, write bool) {
+	if write {
+		l.stripes[stripe(key)].Lock()
+	} else {
+		l.stripes[stripe(key)].RLock()
+	}
+}
+
+// UnlockKey implements KeyLocker.
+func (l *KeyLocks) UnlockKey(key string, write bool) {
+	if write {
+		l.stripes[stripe(key)].Unlock()
+	} else {
+		l.stripes[stripe(key)].RUnlock()
+	}
+}
+
+// LockAll implements KeyLocker, taking the stripes in the order LockKeys
+// does.
+func (l *KeyLocks) LockAll() func() {
+	for i := range l.stripes {
+		l.stripes[i].Lock()
+	}
+	return func() {
+		for i := len(l.stripes) - 1; i >= 0; i-- {
+			l.stripes[i].Unlock()
+		}
+	}
+}
+
+// stripesOf returns the stripes of keys, sorted and without repeats: the
+// order they are locked in.
+func stripesOf(keys []string) []int {
+	order := make([]int, 0, len(keys))
+	for _, key := range keys {
+		order = append(order, stripe(key))
+	}
+	slices.Sort(order)
+	return slices.Compact(order)
+}
+
+// stripe hashes key to a stripe with FNV-1a.
+func stripe(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % keyLockStripes)
+}
+
+// LockKeys implements KeyLocker. It is independent of the lock guarding the
+// data, which each call still takes.
+func (s *Store) LockKeys(keys []string, write bool) func() {
+	return s.keys.LockKeys(keys, write)
+}
+
+// LockKey implements KeyLocker.
+func (s *Store) LockKey(key string, write bool) {
+	s.keys.LockKey(key, write)
+}
+
+// UnlockKey implements KeyLocker.
+func (s *Store) UnlockKey(key string, write bool) {
+	s.keys.UnlockKey(key, write)
+}
+
+// LockAll implements KeyLocker.
+func (s *Store) LockAll() func() {
+	return s.keys.LockAll()
+}
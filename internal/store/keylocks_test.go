@@ -0,0 +1,78 @@
+package store
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLockKeysOrdersStripes(t *testing.T) {
+	got := stripesOf([]string{"b", "a", "b", "a"})
+	want := stripesOf([]string{"a", "b"})
+	if len(got) != len(want) || len(got) > 2 {
+		t.Fatalf("expected each stripe once, got %v and %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] || i > 0 && got[i] <= got[i-1] {
+			t.Fatalf("expected the same ascending stripes, got %v and %v", got, want)
+		}
+	}
+}
+
+func TestLockKeysNoDeadlock(t *testing.T) {
+	var l KeyLocks
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		keys := []string{"a", "b", "c"}
+		if i%2 == 1 {
+			keys = []string{"c", "b", "a", "b"}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				unlock := l.LockKeys(keys, true)
+				counter++
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if counter != 8000 {
+		t.Fatalf("expected the writers to exclude each other, got %d increments", counter)
+	}
+
+	// Readers share their keys.
+	unlock := l.LockKeys([]string{"a"}, false)
+	l.LockKeys([]string{"a", "b"}, false)()
+	unlock()
+}
+
+func TestLockAllExcludesEveryKey(t *testing.T) {
+	var l KeyLocks
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				l.LockKey("a", true)
+				counter++
+				l.UnlockKey("a", true)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				unlock := l.LockAll()
+				counter++
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if counter != 4400 {
+		t.Fatalf("expected LockAll to exclude key writers, got %d increments", counter)
+	}
+}
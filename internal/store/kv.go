@@ -0,0 +1,142 @@
+package store
+
+import "time"
+
+// KV is the storage engine interface used by command handlers and the server.
+// *Store is the default in-memory implementation; embedders can supply
+// their own engine by implementing KV. Type errors must be reported with the
+// same WRONGTYPE error text used by Store.
+type KV interface {
+	// Strings and generic keyspace operations.
+	Set(key, value string, expireMs int64)
+	Get(key string) (string, bool)
+	Delete(keys ...string) int
+	Exists(keys ...string) int
+	Keys(pattern string) []string
+	Scan(cursor int64, pattern string, count int64) (int64, []string, error)
+	Size() int
+	CleanupExpired() int
+	// ForEach calls fn with a copy of every live key, stopping when fn
+	// returns false. fn must be allowed to call back into the engine.
+	ForEach(fn func(e Entry) bool)
+
+	// Hashes.
+	HashSet(key, field, value string) (int, error)
+	HashGet(key, field string) (string, bool, error)
+	HashDel(key string, fields ...string) (int, error)
+	HashGetAll(key string) (map[string]string, error)
+	HashScan(key string, cursor int64, pattern string, count int64) (int64, []string, error)
+
+	// Lists.
+	ListLPush(key string, values ...string) (int, error)
+	ListRPush(key string, values ...string) (int, error)
+	ListLPop(key string) (string, bool, error)
+	ListRPop(key string) (string, bool, error)
+	ListRange(key string, start, stop int) ([]string, error)
+
+	// Sets.
+	SetAdd(key string, members ...string) (int, error)
+	SetMembers(key string) ([]string, error)
+	SetRemove(key string, members ...string) (int, error)
+	SetIsMember(key, member string) (bool, error)
+	SetScan(key string, cursor int64, pattern string, count int64) (int64, []string, error)
+
+	// Sorted sets.
+	ZAdd(key string, score float64, member string) (int, error)
+	ZScore(key, member string) (float64, bool, error)
+	ZRange(key string, start, stop int) ([]string, error)
+	ZRem(key string, members ...string) (int, error)
+}
+
+// Evictor is implemented by engines that enforce a memory limit. Engines
+// that do not implement it are never asked to evict.
+type Evictor interface {
+	EvictIfNeeded() error
+}
+
+// ObjectInspector is implemented by engines that expose per-key internals
+// for the OBJECT command.
+type ObjectInspector interface {
+	ObjectEncoding(key string) (string, bool)
+	ObjectFreq(key string) (int, bool, error)
+	ObjectIdleTime(key string) (int64, bool)
+}
+
+// Flusher is implemented by engines that can drop the whole keyspace at
+// once for FLUSHDB.
+type Flusher interface {
+	Flush()
+}
+
+// Loader is implemented by engines that can replace their whole dataset, as
+// used to load snapshots at startup.
+type Loader interface {
+	Restore(entries []Entry)
+}
+
+// Limiter is implemented by engines that bound key and value sizes. The
+// command layer checks it before running any command that writes data, and
+// RESTORE checks the entry it decodes.
+type Limiter interface {
+	CheckLimits(key string, values ...string) error
+	CheckEntry(e Entry) error
+}
+
+// StatsReporter is implemented by engines that track keyspace statistics
+// incrementally.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// MemoryReporter is implemented by engines that account for the memory
+// their dataset holds, as reported by INFO.
+type MemoryReporter interface {
+	UsedMemory() int64
+	EvictedKeys() int64
+}
+
+// Notifier is implemented by engines that report keyspace changes to
+// registered hooks, as used by notifications, tracking and replication.
+type Notifier interface {
+	OnSet(fn func(key string))
+	OnDelete(fn func(key string))
+	OnExpire(fn func(key string))
+	OnEvict(fn func(key string))
+}
+
+// Expirer is implemented by engines that can set an absolute expiry on an
+// existing key of any type, as used by PEXPIREAT and AOF replay.
+type Expirer interface {
+	ExpireAt(key string, at time.Time) bool
+}
+
+// EntryStore is implemented by engines that read and write a whole value,
+// whatever its type, with its expiry at once, as used by DUMP, RESTORE and
+// MIGRATE.
+type EntryStore interface {
+	Lookup(key string) (Entry, bool)
+	Put(e Entry)
+}
+
+// Durable is implemented by engines that persist every write themselves. The
+// server does not use the AOF with such engines, since replaying it on top of
+// already-persisted data would apply commands twice.
+type Durable interface {
+	Durable() bool
+}
+
+var (
+	_ KV              = (*Store)(nil)
+	_ Evictor         = (*Store)(nil)
+	_ ObjectInspector = (*Store)(nil)
+	_ Flusher         = (*Store)(nil)
+	_ Notifier        = (*Store)(nil)
+	_ StatsReporter   = (*Store)(nil)
+	_ MemoryReporter  = (*Store)(nil)
+	_ Limiter         = (*Store)(nil)
+	_ Loader          = (*Store)(nil)
+	_ Expirer         = (*Store)(nil)
+	_ EntryStore      = (*Store)(nil)
+	_ SlotIndexer     = (*Store)(nil)
+	_ KeyLocker       = (*Store)(nil)
+)
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// LFU-based eviction policies.
+const (
+	PolicyAllKeysLFU  EvictionPolicy = "allkeys-lfu"
+	PolicyVolatileLFU EvictionPolicy = "volatile-lfu"
+)
+
+// ErrLFUNotSelected is returned by ObjectFreq when access frequency is not tracked.
+var ErrLFUNotSelected = fmt.Errorf("ERR An LFU maxmemory policy is not selected, access frequency not tracked")
+
+// The LFU counter follows Redis: an 8-bit logarithmic counter that new keys
+// start at lfuInitVal, plus the minute of its last decrement, packed into a
+// single uint32 so readers can update it atomically under the read lock.
+const (
+	lfuInitVal          = 5
+	defaultLFULogFactor = 10
+)
+
+// lfu reports whether the policy ranks keys by access frequency.
+func (p EvictionPolicy) lfu() bool {
+	return p == PolicyAllKeysLFU || p == PolicyVolatileLFU
+}
+
+func lfuMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xFFFFFF
+}
+
+func packLFU(minutes uint32, counter uint8) uint32 {
+	return minutes<<8 | uint32(counter)
+}
+
+// lfuDecayed returns the counter after applying decay for the minutes elapsed
+// since its last decrement. A decay time of zero disables decay.
+func (s *Store) lfuDecayed(packed uint32) uint8 {
+	counter := uint8(packed & 0xFF)
+	last := packed >> 8
+	now := lfuMinutes()
+	elapsed := (now - last) & 0xFFFFFF
+
+	decay := s.opts.LFUDecayTime
+	if decay <= 0 {
+		return counter
+	}
+	periods := elapsed / uint32(decay)
+	if periods >= uint32(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+// lfuIncr increments counter with probability 1/((counter-init)*factor+1),
+// so that hot keys saturate slowly.
+func (s *Store) lfuIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+	factor := s.opts.LFULogFactor
+	if factor <= 0 {
+		factor = defaultLFULogFactor
+	}
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1.0/(base*float64(factor)+1) {
+		counter++
+	}
+	return counter
+}
+
+// touch records an access to v for LRU and, under an LFU policy, LFU
+// tracking. It is safe to call with only the read lock held.
+func (s *Store) touch(v *Value) {
+	touchLRU(v)
+	if !s.opts.EvictionPolicy.lfu() {
+		return
+	}
+	old := atomic.LoadUint32(&v.lfu)
+	counter := s.lfuIncr(s.lfuDecayed(old))
+	atomic.CompareAndSwapUint32(&v.lfu, old, packLFU(lfuMinutes(), counter))
+}
+
+// ObjectFreq returns the logarithmic access frequency counter of key, as
+// reported by OBJECT FREQ.
+func (s *Store) ObjectFreq(key string) (int, bool, error) {
+	if !s.opts.EvictionPolicy.lfu() {
+		return 0, false, ErrLFUNotSelected
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.data[key]
+	if !ok || v.expired() {
+		return 0, false, nil
+	}
+	return int(s.lfuDecayed(atomic.LoadUint32(&v.lfu))), true, nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestObjectFreqRequiresLFUPolicy(t *testing.T) {
+	store := New()
+	store.Set("k", "v", 0)
+	if _, _, err := store.ObjectFreq("k"); err != ErrLFUNotSelected {
+		t.Fatalf("expected ErrLFUNotSelected, got %v", err)
+	}
+}
+
+func TestObjectFreqGrowsWithAccess(t *testing.T) {
+	store := NewWithOptions(Options{EvictionPolicy: PolicyAllKeysLFU, LFULogFactor: 1})
+	store.Set("hot", "v", 0)
+	start, ok, err := store.ObjectFreq("hot")
+	if err != nil || !ok {
+		t.Fatalf("unexpected result: ok=%v err=%v", ok, err)
+	}
+	if start != lfuInitVal {
+		t.Fatalf("expected initial counter %d, got %d", lfuInitVal, start)
+	}
+	for i := 0; i < 1000; i++ {
+		store.Get("hot")
+	}
+	after, _, _ := store.ObjectFreq("hot")
+	if after <= start {
+		t.Fatalf("expected counter to grow, got %d -> %d", start, after)
+	}
+}
+
+func TestLFUDecay(t *testing.T) {
+	store := NewWithOptions(Options{EvictionPolicy: PolicyAllKeysLFU, LFUDecayTime: 1})
+	packed := packLFU((lfuMinutes()-3)&0xFFFFFF, 10)
+	if got := store.lfuDecayed(packed); got != 7 {
+		t.Fatalf("expected counter decayed to 7, got %d", got)
+	}
+	if got := store.lfuDecayed(packLFU((lfuMinutes()-30)&0xFFFFFF, 10)); got != 0 {
+		t.Fatalf("expected counter to floor at 0, got %d", got)
+	}
+}
+
+func TestLFUEvictionKeepsHotKey(t *testing.T) {
+	store := NewWithOptions(Options{EvictionPolicy: PolicyAllKeysLFU, LFULogFactor: 1, EvictionSamples: 100})
+	store.Set("hot", "v", 0)
+	for i := 0; i < 1000; i++ {
+		store.Get("hot")
+	}
+	for i := 0; i < 20; i++ {
+		store.Set(fmt.Sprintf("cold:%d", i), "v", 0)
+	}
+	store.opts.MaxMemory = store.UsedMemory() / 2
+	if err := store.EvictIfNeeded(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.Get("hot"); !ok {
+		t.Fatalf("expected frequently accessed key to survive eviction")
+	}
+}
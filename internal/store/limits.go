@@ -0,0 +1,112 @@
+package store
+
+import "fmt"
+
+// CheckLimits reports whether a write of values under key is within the
+// configured key length and value size limits. It does not lock the store.
+func (s *Store) CheckLimits(key string, values ...string) error {
+	if max := s.opts.MaxKeyLen; max > 0 && len(key) > max {
+		return fmt.Errorf("ERR key length %d exceeds max-key-length (%d bytes)", len(key), max)
+	}
+	if max := s.opts.MaxValueSize; max > 0 {
+		for _, v := range values {
+			if int64(len(v)) > max {
+				return fmt.Errorf("ERR value size %d exceeds max-value-size (%d bytes)", len(v), max)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckEntry reports whether e, a whole value written at once as RESTORE
+// does, is within the key length and value size limits and, for a
+// collection, the max-collection-entries one. It does not lock the store.
+func (s *Store) CheckEntry(e Entry) error {
+	var values []string
+	n := 1
+	switch e.Type {
+	case TypeString:
+		values = append(values, e.Str)
+	case TypeHash:
+		for f, v := range e.Hash {
+			values = append(values, f, v)
+		}
+		n = len(e.Hash)
+	case TypeList:
+		values, n = e.List, len(e.List)
+	case TypeSet:
+		for m := range e.Set {
+			values = append(values, m)
+		}
+		n = len(e.Set)
+	case TypeZSet:
+		for _, m := range e.ZSet {
+			values = append(values, m.Member)
+		}
+		n = len(e.ZSet)
+	}
+	if err := s.CheckLimits(e.Key, values...); err != nil {
+		return err
+	}
+	if max := s.opts.MaxCollectionLen; max > 0 && e.Type != TypeString && n > max {
+		return fmt.Errorf("ERR collection would hold %d entries, exceeding max-collection-entries (%d)", n, max)
+	}
+	return nil
+}
+
+// checkCollection reports whether adding n entries to the collection v
+// (absent when exists is false) stays within MaxCollectionLen. Must be
+// called with the lock held.
+func (s *Store) checkCollection(v *Value, exists bool, n int) error {
+	max := s.opts.MaxCollectionLen
+	if max <= 0 || n == 0 {
+		return nil
+	}
+	cur := 0
+	if exists {
+		cur = valueLen(v)
+	}
+	if cur+n > max {
+		return fmt.Errorf("ERR collection would hold %d entries, exceeding max-collection-entries (%d)", cur+n, max)
+	}
+	return nil
+}
+
+func hasField(h map[string]string, field string) bool {
+	_, ok := h[field]
+	return ok
+}
+
+func hasMember(ss *SortedSet, member string) bool {
+	_, ok := ss.index[member]
+	return ok
+}
+
+// newMembers counts the distinct members not already in the set v.
+func newMembers(v *Value, exists bool, members []string) int {
+	seen := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		if exists {
+			if _, ok := v.Set[m]; ok {
+				continue
+			}
+		}
+		seen[m] = struct{}{}
+	}
+	return len(seen)
+}
+
+// valueLen returns the number of elements held by v; strings count as one.
+func valueLen(v *Value) int {
+	switch v.Type {
+	case TypeHash:
+		return len(v.Hash)
+	case TypeList:
+		return len(v.List)
+	case TypeSet:
+		return len(v.Set)
+	case TypeZSet:
+		return len(v.ZSet.entries)
+	}
+	return 1
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckLimits(t *testing.T) {
+	store := NewWithOptions(Options{MaxKeyLen: 4, MaxValueSize: 3})
+	if err := store.CheckLimits("key", "abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.CheckLimits("toolong", "a"); err == nil || !strings.Contains(err.Error(), "max-key-length") {
+		t.Fatalf("expected key length error, got %v", err)
+	}
+	if err := store.CheckLimits("k", "a", "abcd"); err == nil || !strings.Contains(err.Error(), "max-value-size") {
+		t.Fatalf("expected value size error, got %v", err)
+	}
+	if err := New().CheckLimits(strings.Repeat("k", 1000), strings.Repeat("v", 1000)); err != nil {
+		t.Fatalf("limits should be disabled by default: %v", err)
+	}
+}
+
+func TestMaxCollectionLen(t *testing.T) {
+	store := NewWithOptions(Options{MaxCollectionLen: 2})
+
+	if _, err := store.ListRPush("l", "a", "b", "c"); err == nil {
+		t.Fatalf("expected list push over the limit to fail")
+	}
+	if store.Exists("l") != 0 {
+		t.Fatalf("rejected push must not create the key")
+	}
+	store.ListRPush("l", "a", "b")
+	if _, err := store.ListLPush("l", "c"); err == nil {
+		t.Fatalf("expected push onto a full list to fail")
+	}
+
+	store.HashSet("h", "f1", "v")
+	store.HashSet("h", "f2", "v")
+	if _, err := store.HashSet("h", "f1", "updated"); err != nil {
+		t.Fatalf("updating an existing field must be allowed: %v", err)
+	}
+	if _, err := store.HashSet("h", "f3", "v"); err == nil {
+		t.Fatalf("expected new hash field over the limit to fail")
+	}
+
+	if _, err := store.SetAdd("s", "a", "a", "b"); err != nil {
+		t.Fatalf("duplicate members must count once: %v", err)
+	}
+	if _, err := store.SetAdd("s", "c"); err == nil {
+		t.Fatalf("expected new set member over the limit to fail")
+	}
+
+	store.ZAdd("z", 1, "a")
+	store.ZAdd("z", 2, "b")
+	if _, err := store.ZAdd("z", 3, "a"); err != nil {
+		t.Fatalf("rescoring an existing member must be allowed: %v", err)
+	}
+	if _, err := store.ZAdd("z", 1, "c"); err == nil {
+		t.Fatalf("expected new zset member over the limit to fail")
+	}
+}
+
+func TestCheckEntry(t *testing.T) {
+	store := NewWithOptions(Options{MaxKeyLen: 4, MaxValueSize: 3, MaxCollectionLen: 2})
+	for _, tc := range []struct {
+		e    Entry
+		want string
+	}{
+		{Entry{Key: "k", Type: TypeString, Str: "abc"}, ""},
+		{Entry{Key: "toolong", Type: TypeString, Str: "a"}, "max-key-length"},
+		{Entry{Key: "k", Type: TypeString, Str: "abcd"}, "max-value-size"},
+		{Entry{Key: "k", Type: TypeHash, Hash: map[string]string{"f": "abcd"}}, "max-value-size"},
+		{Entry{Key: "k", Type: TypeHash, Hash: map[string]string{"long": "v"}}, "max-value-size"},
+		{Entry{Key: "k", Type: TypeList, List: []string{"a", "b", "c"}}, "max-collection-entries"},
+		{Entry{Key: "k", Type: TypeSet, Set: map[string]struct{}{"abcd": {}}}, "max-value-size"},
+		{Entry{Key: "k", Type: TypeZSet, ZSet: []ZMember{{Member: "a"}, {Member: "b"}}}, ""},
+	} {
+		err := store.CheckEntry(tc.e)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("CheckEntry(%+v): expected %q, got %v", tc.e, tc.want, err)
+		}
+	}
+}
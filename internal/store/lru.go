@@ -0,0 +1,69 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lruClockResolution is how often the global LRU clock advances. Entries
+// record the clock value on access, so idle times are accurate to roughly
+// this resolution.
+const lruClockResolution = 100 * time.Millisecond
+
+var (
+	lruClock     atomic.Uint32 // seconds since the Unix epoch, truncated to 32 bits
+	lruClockOnce sync.Once
+)
+
+// startLRUClock starts the process-wide goroutine that refreshes lruClock.
+// Reading a cached value is much cheaper than calling time.Now on every key
+// access.
+func startLRUClock() {
+	lruClockOnce.Do(func() {
+		lruClock.Store(uint32(time.Now().Unix()))
+		go func() {
+			ticker := time.NewTicker(lruClockResolution)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				lruClock.Store(uint32(now.Unix()))
+			}
+		}()
+	})
+}
+
+// lruNow returns the current coarse LRU clock.
+func lruNow() uint32 {
+	return lruClock.Load()
+}
+
+// touchLRU stamps v with the current clock. It only writes when the clock has
+// moved, so hot keys read in a tight loop do not contend on the same cache line.
+func touchLRU(v *Value) {
+	now := lruNow()
+	if atomic.LoadUint32(&v.lru) != now {
+		atomic.StoreUint32(&v.lru, now)
+	}
+}
+
+// idleSeconds returns how long ago v was last accessed.
+func idleSeconds(v *Value) int64 {
+	idle := int64(lruNow()) - int64(atomic.LoadUint32(&v.lru))
+	if idle < 0 {
+		return 0
+	}
+	return idle
+}
+
+// ObjectIdleTime returns the number of seconds since key was last read or
+// written, as reported by OBJECT IDLETIME.
+func (s *Store) ObjectIdleTime(key string) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.data[key]
+	if !ok || v.expired() {
+		return 0, false
+	}
+	return idleSeconds(v), true
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestObjectIdleTimeResetsOnRead(t *testing.T) {
+	store := New()
+	store.Set("k", "v", 0)
+	store.data["k"].lru = lruNow() - 10
+
+	idle, ok := store.ObjectIdleTime("k")
+	if !ok || idle != 10 {
+		t.Fatalf("expected idle time 10, got %d ok=%v", idle, ok)
+	}
+
+	store.Get("k")
+	if idle, _ := store.ObjectIdleTime("k"); idle != 0 {
+		t.Fatalf("expected idle time reset by read, got %d", idle)
+	}
+
+	if _, ok := store.ObjectIdleTime("missing"); ok {
+		t.Fatalf("expected missing key to report not found")
+	}
+}
+
+func TestLRUEvictionPrefersIdleKeys(t *testing.T) {
+	store := NewWithOptions(Options{EvictionPolicy: PolicyAllKeysLRU, EvictionSamples: 100})
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("idle:%d", i)
+		store.Set(key, "v", 0)
+		store.data[key].lru = lruNow() - 1000
+	}
+	store.Set("recent", "v", 0)
+
+	store.opts.MaxMemory = store.UsedMemory() / 2
+	if err := store.EvictIfNeeded(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.Get("recent"); !ok {
+		t.Fatalf("expected recently used key to survive eviction")
+	}
+}
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// EvictionPolicy selects which keys are removed when the dataset exceeds maxmemory.
+type EvictionPolicy string
+
+const (
+	PolicyNoEviction     EvictionPolicy = "noeviction"
+	PolicyAllKeysRandom  EvictionPolicy = "allkeys-random"
+	PolicyAllKeysLRU     EvictionPolicy = "allkeys-lru"
+	PolicyVolatileLRU    EvictionPolicy = "volatile-lru"
+	PolicyVolatileRandom EvictionPolicy = "volatile-random"
+	PolicyVolatileTTL    EvictionPolicy = "volatile-ttl"
+)
+
+// ErrOOM is returned when a write is refused because memory cannot be reclaimed.
+var ErrOOM = fmt.Errorf("OOM command not allowed when used memory > 'maxmemory'")
+
+// ParseEvictionPolicy validates a policy name from configuration.
+// An empty name selects noeviction.
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(name); p {
+	case "":
+		return PolicyNoEviction, nil
+	case PolicyNoEviction, PolicyAllKeysRandom, PolicyAllKeysLRU, PolicyAllKeysLFU,
+		PolicyVolatileLRU, PolicyVolatileLFU, PolicyVolatileRandom, PolicyVolatileTTL:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown maxmemory policy '%s'", name)
+}
+
+// volatile reports whether the policy only considers keys with a TTL.
+func (p EvictionPolicy) volatile() bool {
+	return p == PolicyVolatileLRU || p == PolicyVolatileLFU ||
+		p == PolicyVolatileRandom || p == PolicyVolatileTTL
+}
+
+const defaultEvictionSamples = 5
+
+// Approximate per-item overheads used for memory accounting. These are not
+// exact heap sizes; they only need to grow proportionally with the dataset.
+const (
+	entryOverhead   = 96 // map slot + Value struct
+	elementOverhead = 16 // string header per list/set element
+	fieldOverhead   = 32 // map slot per hash field
+	zsetOverhead    = 48 // slice entry + index slot per sorted set member
+)
+
+// entrySize computes the accounted size of v stored under key from scratch.
+func entrySize(key string, v *Value) int64 {
+	n := int64(entryOverhead + len(key))
+	switch v.Type {
+	case TypeString:
+		if v.Encoding != EncodingInt {
+			n += int64(len(v.Str))
+		}
+	case TypeHash:
+		for f, val := range v.Hash {
+			n += int64(fieldOverhead + len(f) + len(val))
+		}
+	case TypeList:
+		for _, e := range v.List {
+			n += int64(elementOverhead + len(e))
+		}
+	case TypeSet:
+		for m := range v.Set {
+			n += int64(elementOverhead + len(m))
+		}
+	case TypeZSet:
+		for _, e := range v.ZSet.entries {
+			n += int64(zsetOverhead + len(e.member))
+		}
+	}
+	return n
+}
+
+// Size returns the accounted size of the entry, as entrySize does for the
+// value it was copied from, but for integer-encoded strings, counted by
+// their digits.
+func (e Entry) Size() int64 {
+	n := int64(entryOverhead + len(e.Key))
+	switch e.Type {
+	case TypeString:
+		n += int64(len(e.Str))
+	case TypeHash:
+		for f, val := range e.Hash {
+			n += int64(fieldOverhead + len(f) + len(val))
+		}
+	case TypeList:
+		for _, m := range e.List {
+			n += int64(elementOverhead + len(m))
+		}
+	case TypeSet:
+		for m := range e.Set {
+			n += int64(elementOverhead + len(m))
+		}
+	case TypeZSet:
+		for _, z := range e.ZSet {
+			n += int64(zsetOverhead + len(z.Member))
+		}
+	}
+	return n
+}
+
+// Len returns the entry's number of elements: a string's length in bytes,
+// or the fields, elements or members of a hash, list, set or sorted set.
+func (e Entry) Len() int {
+	switch e.Type {
+	case TypeHash:
+		return len(e.Hash)
+	case TypeList:
+		return len(e.List)
+	case TypeSet:
+		return len(e.Set)
+	case TypeZSet:
+		return len(e.ZSet)
+	}
+	return len(e.Str)
+}
+
+// insert stores v at key, replacing any previous entry. Must be called with
+// the write lock held.
+func (s *Store) insert(key string, v *Value) {
+	s.preserve(key)
+	if old, ok := s.data[key]; ok {
+		s.used -= old.mem
+		s.count(old, -1)
+	} else if s.slots != nil {
+		s.slots.add(key)
+	}
+	v.mem = entrySize(key, v)
+	v.lru = lruNow()
+	v.lfu = packLFU(lfuMinutes(), lfuInitVal)
+	s.used += v.mem
+	s.count(v, 1)
+	s.data[key] = v
+}
+
+// remove deletes key on behalf of a command and fires EventDelete. Must be
+// called with the write lock held.
+func (s *Store) remove(key string) bool {
+	if !s.unlink(key) {
+		return false
+	}
+	s.emit(EventDelete, key)
+	return true
+}
+
+// expire deletes key because its TTL has passed and fires EventExpire. Must
+// be called with the write lock held.
+func (s *Store) expire(key string) bool {
+	if !s.unlink(key) {
+		return false
+	}
+	s.emit(EventExpire, key)
+	return true
+}
+
+// unlink deletes key and releases its accounted memory without firing any
+// event. Must be called with the write lock held.
+func (s *Store) unlink(key string) bool {
+	v, ok := s.data[key]
+	if !ok {
+		return false
+	}
+	s.preserve(key)
+	s.used -= v.mem
+	s.count(v, -1)
+	delete(s.data, key)
+	if s.slots != nil {
+		s.slots.remove(key)
+	}
+	return true
+}
+
+// grow records an in-place change of delta bytes to the value at key and
+// fires EventSet. Must be called with the write lock held.
+func (s *Store) grow(key string, v *Value, delta int64) {
+	v.mem += delta
+	s.touch(v)
+	s.used += delta
+	s.emit(EventSet, key)
+}
+
+// UsedMemory returns the approximate number of bytes held by the dataset.
+func (s *Store) UsedMemory() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.used
+}
+
+// EvictedKeys returns the number of keys removed by the eviction policy.
+func (s *Store) EvictedKeys() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.evicted
+}
+
+// EvictIfNeeded frees keys according to the eviction policy until the
+// dataset fits within MaxMemory. It returns ErrOOM when the limit is
+// exceeded and nothing more can be evicted, which callers use to refuse
+// commands that would grow memory further.
+func (s *Store) EvictIfNeeded() error {
+	if s.opts.MaxMemory <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.used > s.opts.MaxMemory {
+		if s.opts.EvictionPolicy == PolicyNoEviction || s.opts.EvictionPolicy == "" {
+			return ErrOOM
+		}
+		victim, ok := s.evictionCandidate()
+		if !ok {
+			return ErrOOM
+		}
+		s.unlink(victim)
+		s.evicted++
+		s.emit(EventEvict, victim)
+	}
+	return nil
+}
+
+// evictionCandidate samples keys and returns the best one to evict under the
+// configured policy. Go randomizes map iteration order, so taking the first
+// N keys of a range loop is a cheap random sample.
+func (s *Store) evictionCandidate() (string, bool) {
+	policy := s.opts.EvictionPolicy
+	samples := s.opts.EvictionSamples
+	if samples <= 0 {
+		samples = defaultEvictionSamples
+	}
+
+	var (
+		best      string
+		bestScore int64
+		found     bool
+		seen      int
+	)
+	for k, v := range s.data {
+		if policy.volatile() && v.Expiry == nil {
+			continue
+		}
+
+		var score int64 // lower score is evicted first
+		switch policy {
+		case PolicyAllKeysLRU, PolicyVolatileLRU:
+			score = int64(atomic.LoadUint32(&v.lru))
+		case PolicyAllKeysLFU, PolicyVolatileLFU:
+			score = int64(s.lfuDecayed(atomic.LoadUint32(&v.lfu)))
+		case PolicyVolatileTTL:
+			score = v.Expiry.UnixNano()
+		}
+
+		if !found || score < bestScore {
+			best, bestScore, found = k, score, true
+		}
+		seen++
+		if seen >= samples {
+			break
+		}
+	}
+	return best, found
+}
+
+// listSize returns the accounted size of a batch of list elements.
+func listSize(values []string) int64 {
+	var n int64
+	for _, e := range values {
+		n += int64(elementOverhead + len(e))
+	}
+	return n
+}
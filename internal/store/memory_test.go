@@ -0,0 +1,109 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUsedMemoryTracksWrites(t *testing.T) {
+	store := New()
+	if store.UsedMemory() != 0 {
+		t.Fatalf("expected empty store to use 0 bytes, got %d", store.UsedMemory())
+	}
+
+	store.Set("k", "value", 0)
+	store.HashSet("h", "f", "v")
+	store.ListRPush("l", "a", "b")
+	store.SetAdd("s", "m")
+	store.ZAdd("z", 1, "m")
+	if store.UsedMemory() <= 0 {
+		t.Fatalf("expected positive memory usage")
+	}
+
+	store.ListLPop("l")
+	store.ListLPop("l")
+	store.Delete("k", "h", "s")
+	store.ZRem("z", "m")
+	if store.UsedMemory() != 0 {
+		t.Fatalf("expected memory to return to 0, got %d", store.UsedMemory())
+	}
+}
+
+func TestEntrySize(t *testing.T) {
+	store := New()
+	store.Set("k", "value", 0)
+	store.HashSet("h", "f", "v")
+	store.ListRPush("l", "a", "b", "c")
+	store.SetAdd("s", "m")
+	store.ZAdd("z", 1, "m")
+
+	var size int64
+	lens := map[string]int{}
+	store.ForEach(func(e Entry) bool {
+		size += e.Size()
+		lens[e.Key] = e.Len()
+		return true
+	})
+	if size != store.UsedMemory() {
+		t.Fatalf("expected the entries' sizes to add up to %d, got %d", store.UsedMemory(), size)
+	}
+	if lens["k"] != 5 || lens["h"] != 1 || lens["l"] != 3 || lens["s"] != 1 || lens["z"] != 1 {
+		t.Fatalf("unexpected lengths %v", lens)
+	}
+}
+
+func TestNoEvictionReturnsOOM(t *testing.T) {
+	store := NewWithOptions(Options{MaxMemory: 1, EvictionPolicy: PolicyNoEviction})
+	if err := store.EvictIfNeeded(); err != nil {
+		t.Fatalf("unexpected error on empty store: %v", err)
+	}
+	store.Set("k", "v", 0)
+	if err := store.EvictIfNeeded(); err != ErrOOM {
+		t.Fatalf("expected ErrOOM, got %v", err)
+	}
+	if store.Size() != 1 {
+		t.Fatalf("noeviction must not remove keys")
+	}
+}
+
+func TestAllKeysEvictionFitsLimit(t *testing.T) {
+	for _, policy := range []EvictionPolicy{PolicyAllKeysRandom, PolicyAllKeysLRU} {
+		store := NewWithOptions(Options{MaxMemory: 2000, EvictionPolicy: policy})
+		for i := 0; i < 100; i++ {
+			store.Set(fmt.Sprintf("key:%d", i), "value", 0)
+		}
+		if err := store.EvictIfNeeded(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		if store.UsedMemory() > 2000 {
+			t.Fatalf("%s: expected usage under limit, got %d", policy, store.UsedMemory())
+		}
+		if store.EvictedKeys() == 0 {
+			t.Fatalf("%s: expected evictions", policy)
+		}
+	}
+}
+
+func TestVolatileEvictionSparesPersistentKeys(t *testing.T) {
+	store := NewWithOptions(Options{MaxMemory: 1, EvictionPolicy: PolicyVolatileTTL})
+	store.Set("persistent", "v", 0)
+	store.Set("volatile", "v", 60000)
+	if err := store.EvictIfNeeded(); err != ErrOOM {
+		t.Fatalf("expected ErrOOM once only persistent keys remain, got %v", err)
+	}
+	if _, ok := store.Get("persistent"); !ok {
+		t.Fatalf("persistent key should not be evicted")
+	}
+	if _, ok := store.Get("volatile"); ok {
+		t.Fatalf("volatile key should have been evicted")
+	}
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	if p, err := ParseEvictionPolicy("allkeys-lru"); err != nil || p != PolicyAllKeysLRU {
+		t.Fatalf("expected allkeys-lru, got %v %v", p, err)
+	}
+	if _, err := ParseEvictionPolicy("bogus"); err == nil {
+		t.Fatalf("expected error for unknown policy")
+	}
+}
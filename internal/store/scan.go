@@ -7,13 +7,14 @@ package store
 
 import (
 	"fmt"
-	"path/filepath"
 	"sort"
 	"time"
+
+	"redis-from-scratch/internal/glob"
 )
 
 // KeysPattern returns keys matching the given pattern
-// Supports glob patterns: *, ?, [abc], [^abc]
+// Supports Redis glob patterns: *, ?, [abc], [^abc], [a-z] and \x
 func (s *Store) KeysPattern(pattern string) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -28,8 +29,7 @@ func (s *Store) KeysPattern(pattern string) []string {
 		}
 
 		// Match against pattern
-		ok, err := filepath.Match(pattern, k)
-		if err != nil || !ok {
+		if !glob.Match(pattern, k) {
 			continue
 		}
 
@@ -62,8 +62,7 @@ func (s *Store) Scan(cursor int64, pattern string, count int64) (int64, []string
 		}
 
 		// Check if matches pattern
-		ok, err := filepath.Match(pattern, k)
-		if err != nil || !ok {
+		if !glob.Match(pattern, k) {
 			continue
 		}
 
@@ -126,8 +125,7 @@ func (s *Store) HashScan(key string, cursor int64, pattern string, count int64)
 	// Get all matching fields
 	allFields := make([]string, 0)
 	for f := range v.Hash {
-		ok, err := filepath.Match(pattern, f)
-		if err != nil || !ok {
+		if !glob.Match(pattern, f) {
 			continue
 		}
 		allFields = append(allFields, f)
@@ -192,8 +190,7 @@ func (s *Store) SetScan(key string, cursor int64, pattern string, count int64) (
 	// Get all matching members
 	allMembers := make([]string, 0)
 	for m := range v.Set {
-		ok, err := filepath.Match(pattern, m)
-		if err != nil || !ok {
+		if !glob.Match(pattern, m) {
 			continue
 		}
 		allMembers = append(allMembers, m)
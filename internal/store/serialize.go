@@ -0,0 +1,446 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dump format: the magic header, then one record per key and an end marker
+// followed by a CRC-32 (IEEE) of every preceding byte. A record is an
+// optional opExpiry with the deadline in Unix milliseconds, the value type as
+// one byte, the key, and the type-specific payload. Strings are written as a
+// uvarint length followed by the bytes; counts are uvarints.
+const (
+	// DumpMagic starts every dump, so readers can tell it from other data.
+	DumpMagic = "RFSDUMP1"
+
+	opExpiry byte = 0xFC
+	opEOF    byte = 0xFF
+)
+
+// ErrBadDump is returned when a dump is truncated, corrupt or not a dump.
+var ErrBadDump = fmt.Errorf("invalid or corrupt dump")
+
+// Encoder writes entries in the dump format.
+type Encoder struct {
+	w   *bufio.Writer
+	out io.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewEncoder writes the dump header to w and returns an encoder for the
+// entries that follow. Close must be called to write the trailer.
+func NewEncoder(w io.Writer) (*Encoder, error) {
+	crc := crc32.NewIEEE()
+	enc := &Encoder{w: bufio.NewWriter(io.MultiWriter(w, crc)), out: w, crc: crc}
+	if _, err := enc.w.WriteString(DumpMagic); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+func (enc *Encoder) uvarint(n uint64) {
+	k := binary.PutUvarint(enc.buf[:], n)
+	enc.w.Write(enc.buf[:k])
+}
+
+func (enc *Encoder) string(s string) {
+	enc.uvarint(uint64(len(s)))
+	enc.w.WriteString(s)
+}
+
+// Encode writes one entry.
+func (enc *Encoder) Encode(e Entry) error {
+	if e.Expiry != nil {
+		enc.w.WriteByte(opExpiry)
+		binary.LittleEndian.PutUint64(enc.buf[:8], uint64(e.Expiry.UnixMilli()))
+		enc.w.Write(enc.buf[:8])
+	}
+	enc.w.WriteByte(byte(e.Type))
+	enc.string(e.Key)
+	switch e.Type {
+	case TypeString:
+		enc.string(e.Str)
+	case TypeHash:
+		enc.uvarint(uint64(len(e.Hash)))
+		for f, v := range e.Hash {
+			enc.string(f)
+			enc.string(v)
+		}
+	case TypeList:
+		enc.uvarint(uint64(len(e.List)))
+		for _, v := range e.List {
+			enc.string(v)
+		}
+	case TypeSet:
+		enc.uvarint(uint64(len(e.Set)))
+		for m := range e.Set {
+			enc.string(m)
+		}
+	case TypeZSet:
+		enc.uvarint(uint64(len(e.ZSet)))
+		for _, z := range e.ZSet {
+			enc.string(z.Member)
+			binary.LittleEndian.PutUint64(enc.buf[:8], math.Float64bits(z.Score))
+			enc.w.Write(enc.buf[:8])
+		}
+	default:
+		return fmt.Errorf("cannot encode value type %d", e.Type)
+	}
+	// bufio.Writer keeps the first error and returns it from every later call.
+	_, err := enc.w.Write(nil)
+	return err
+}
+
+// Close writes the end marker and checksum. It does not close the
+// underlying writer.
+func (enc *Encoder) Close() error {
+	if err := enc.w.WriteByte(opEOF); err != nil {
+		return err
+	}
+	if err := enc.w.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], enc.crc.Sum32())
+	_, err := enc.out.Write(sum[:])
+	return err
+}
+
+// Decoder reads entries written by an Encoder.
+type Decoder struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	buf [8]byte
+	eof bool
+}
+
+// NewDecoder reads and checks the dump header from r.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	dec := &Decoder{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	magic := make([]byte, len(DumpMagic))
+	if err := dec.full(magic); err != nil || string(magic) != DumpMagic {
+		return nil, ErrBadDump
+	}
+	return dec, nil
+}
+
+// full reads exactly len(p) bytes, adding them to the running checksum.
+func (dec *Decoder) full(p []byte) error {
+	if _, err := io.ReadFull(dec.r, p); err != nil {
+		return ErrBadDump
+	}
+	dec.crc.Write(p)
+	return nil
+}
+
+func (dec *Decoder) byte() (byte, error) {
+	if err := dec.full(dec.buf[:1]); err != nil {
+		return 0, err
+	}
+	return dec.buf[0], nil
+}
+
+func (dec *Decoder) uint64() (uint64, error) {
+	if err := dec.full(dec.buf[:8]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(dec.buf[:8]), nil
+}
+
+func (dec *Decoder) uvarint() (uint64, error) {
+	var (
+		n     uint64
+		shift uint
+	)
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := dec.byte()
+		if err != nil {
+			return 0, err
+		}
+		n |= uint64(b&0x7F) << shift
+		if b < 0x80 {
+			return n, nil
+		}
+		shift += 7
+	}
+	return 0, ErrBadDump
+}
+
+func (dec *Decoder) string() (string, error) {
+	n, err := dec.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > math.MaxInt32 {
+		return "", ErrBadDump
+	}
+	if n > 64*1024 {
+		// Grow with the data actually read rather than trusting the length.
+		var b strings.Builder
+		if _, err := io.CopyN(&b, io.TeeReader(dec.r, dec.crc), int64(n)); err != nil {
+			return "", ErrBadDump
+		}
+		return b.String(), nil
+	}
+	p := make([]byte, n)
+	if err := dec.full(p); err != nil {
+		return "", err
+	}
+	return string(p), nil
+}
+
+// count reads a collection length. The capacity hint is bounded so a
+// corrupt length cannot force a huge allocation up front.
+func (dec *Decoder) count() (int, int, error) {
+	n, err := dec.uvarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	if n > math.MaxInt32 {
+		return 0, 0, ErrBadDump
+	}
+	return int(n), min(int(n), 1024), nil
+}
+
+// Decode returns the next entry, or io.EOF after the end marker once the
+// checksum has been verified.
+func (dec *Decoder) Decode() (Entry, error) {
+	if dec.eof {
+		return Entry{}, io.EOF
+	}
+	var e Entry
+	op, err := dec.byte()
+	if err != nil {
+		return e, err
+	}
+	if op == opEOF {
+		want := dec.crc.Sum32()
+		if _, err := io.ReadFull(dec.r, dec.buf[:4]); err != nil {
+			return e, ErrBadDump
+		}
+		if binary.LittleEndian.Uint32(dec.buf[:4]) != want {
+			return e, ErrBadDump
+		}
+		dec.eof = true
+		return e, io.EOF
+	}
+	if op == opExpiry {
+		ms, err := dec.uint64()
+		if err != nil {
+			return e, err
+		}
+		exp := time.UnixMilli(int64(ms))
+		e.Expiry = &exp
+		if op, err = dec.byte(); err != nil {
+			return e, err
+		}
+	}
+	e.Type = ValueType(op)
+	if e.Key, err = dec.string(); err != nil {
+		return e, err
+	}
+
+	switch e.Type {
+	case TypeString:
+		e.Str, err = dec.string()
+	case TypeHash:
+		n, hint, cerr := dec.count()
+		if cerr != nil {
+			return e, cerr
+		}
+		e.Hash = make(map[string]string, hint)
+		for i := 0; i < n && err == nil; i++ {
+			var f, v string
+			if f, err = dec.string(); err == nil {
+				v, err = dec.string()
+				e.Hash[f] = v
+			}
+		}
+	case TypeList:
+		n, hint, cerr := dec.count()
+		if cerr != nil {
+			return e, cerr
+		}
+		e.List = make([]string, 0, hint)
+		for i := 0; i < n && err == nil; i++ {
+			var v string
+			v, err = dec.string()
+			e.List = append(e.List, v)
+		}
+	case TypeSet:
+		n, hint, cerr := dec.count()
+		if cerr != nil {
+			return e, cerr
+		}
+		e.Set = make(map[string]struct{}, hint)
+		for i := 0; i < n && err == nil; i++ {
+			var m string
+			m, err = dec.string()
+			e.Set[m] = struct{}{}
+		}
+	case TypeZSet:
+		n, hint, cerr := dec.count()
+		if cerr != nil {
+			return e, cerr
+		}
+		e.ZSet = make([]ZMember, 0, hint)
+		for i := 0; i < n && err == nil; i++ {
+			var (
+				m    string
+				bits uint64
+			)
+			if m, err = dec.string(); err == nil {
+				bits, err = dec.uint64()
+				e.ZSet = append(e.ZSet, ZMember{Member: m, Score: math.Float64frombits(bits)})
+			}
+		}
+	default:
+		return e, ErrBadDump
+	}
+	return e, err
+}
+
+// Serialize writes every live key with its TTL to w in the dump format. It
+// iterates a snapshot, so writers are not blocked while the dump is written.
+func (s *Store) Serialize(w io.Writer) error {
+	enc, err := NewEncoder(w)
+	if err != nil {
+		return err
+	}
+	s.ForEach(func(e Entry) bool {
+		err = enc.Encode(e)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Deserialize replaces the dataset with the dump read from r. Keys whose TTL
+// has already passed are skipped. The dataset is only replaced once the
+// whole dump has been read and its checksum verified.
+func (s *Store) Deserialize(r io.Reader) error {
+	dec, err := NewDecoder(r)
+	if err != nil {
+		return err
+	}
+	var entries []Entry
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	s.Restore(entries)
+	return nil
+}
+
+// Restore replaces the dataset with entries. Entries whose expiry has already
+// passed are skipped; when a key repeats, the last entry wins. Readers see
+// either the old dataset or the new one, never an empty or partial one.
+func (s *Store) Restore(entries []Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+	now := time.Now()
+	for _, e := range entries {
+		if e.Expiry != nil && now.After(*e.Expiry) {
+			continue
+		}
+		s.insert(e.Key, s.valueOf(e))
+	}
+}
+
+// Put stores e, replacing any value at its key, and fires EventSet.
+func (s *Store) Put(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert(e.Key, s.valueOf(e))
+	s.emit(EventSet, e.Key)
+}
+
+// DumpValue serializes the value of e, without its key and expiry, as a dump
+// holding that one entry. It is the payload of DUMP, and the dump checksum
+// lets RESTORE reject a corrupt one.
+func DumpValue(e Entry) ([]byte, error) {
+	e.Key, e.Expiry = "", nil
+	var b bytes.Buffer
+	enc, err := NewEncoder(&b)
+	if err == nil {
+		err = enc.Encode(e)
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	return b.Bytes(), err
+}
+
+// LoadValue reads a payload written by DumpValue, returning ErrBadDump if it
+// is not one.
+func LoadValue(payload []byte) (Entry, error) {
+	dec, err := NewDecoder(bytes.NewReader(payload))
+	if err != nil {
+		return Entry{}, ErrBadDump
+	}
+	e, err := dec.Decode()
+	if err != nil {
+		return Entry{}, ErrBadDump
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		return Entry{}, ErrBadDump
+	}
+	return e, nil
+}
+
+// valueOf builds a store value from a decoded entry, applying the store's
+// string encoding options.
+func (s *Store) valueOf(e Entry) *Value {
+	var v Value
+	switch e.Type {
+	case TypeString:
+		v = s.encodeString(e.Str)
+	case TypeHash:
+		v = Value{Type: TypeHash, Hash: make(map[string]string, len(e.Hash))}
+		for f, val := range e.Hash {
+			v.Hash[f] = s.intern(val)
+		}
+	case TypeList:
+		v = Value{Type: TypeList, List: e.List}
+	case TypeSet:
+		v = Value{Type: TypeSet, Set: e.Set}
+	case TypeZSet:
+		v = Value{Type: TypeZSet, ZSet: newSortedSet()}
+		for _, z := range e.ZSet {
+			v.ZSet.index[z.Member] = z.Score
+		}
+		for m, sc := range v.ZSet.index {
+			v.ZSet.entries = append(v.ZSet.entries, zEntry{member: m, score: sc})
+		}
+		sort.Slice(v.ZSet.entries, func(i, j int) bool {
+			a, b := v.ZSet.entries[i], v.ZSet.entries[j]
+			if a.score == b.score {
+				return a.member < b.member
+			}
+			return a.score < b.score
+		})
+	}
+	v.Expiry = e.Expiry
+	return &v
+}
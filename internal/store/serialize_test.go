@@ -0,0 +1,114 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	src := New()
+	src.Set("str", "hello", 0)
+	src.Set("ttl", "v", 60000)
+	src.Set("big", strings.Repeat("x", 100000), 0)
+	src.HashSet("h", "f", "v")
+	src.ListRPush("l", "a", "b", "c")
+	src.SetAdd("s", "m1", "m2")
+	src.ZAdd("z", 2, "two")
+	src.ZAdd("z", 1, "one")
+
+	var buf bytes.Buffer
+	if err := src.Serialize(&buf); err != nil {
+		t.Fatalf("serialize failed: %v", err)
+	}
+
+	dst := New()
+	dst.Set("stale", "v", 0)
+	if err := dst.Deserialize(&buf); err != nil {
+		t.Fatalf("deserialize failed: %v", err)
+	}
+	if dst.Size() != 7 {
+		t.Fatalf("expected 7 keys, got %d", dst.Size())
+	}
+	if dst.Exists("stale") != 0 {
+		t.Fatalf("deserialize should replace the dataset")
+	}
+	if v, _ := dst.Get("str"); v != "hello" {
+		t.Fatalf("expected hello, got %s", v)
+	}
+	if v, _ := dst.Get("big"); len(v) != 100000 {
+		t.Fatalf("expected large value to round trip, got %d bytes", len(v))
+	}
+	if exp := dst.data["ttl"].Expiry; exp == nil || time.Until(*exp) <= 0 {
+		t.Fatalf("expected TTL to be restored")
+	}
+	if v, _, _ := dst.HashGet("h", "f"); v != "v" {
+		t.Fatalf("expected hash field v, got %s", v)
+	}
+	if l, _ := dst.ListRange("l", 0, -1); strings.Join(l, ",") != "a,b,c" {
+		t.Fatalf("unexpected list: %v", l)
+	}
+	if ok, _ := dst.SetIsMember("s", "m2"); !ok {
+		t.Fatalf("expected set member m2")
+	}
+	if z, _ := dst.ZRange("z", 0, -1); strings.Join(z, ",") != "one,two" {
+		t.Fatalf("unexpected zset order: %v", z)
+	}
+	if dst.UsedMemory() != src.UsedMemory() {
+		t.Fatalf("expected memory accounting to match: %d vs %d", dst.UsedMemory(), src.UsedMemory())
+	}
+}
+
+func TestDeserializeRejectsCorruptDump(t *testing.T) {
+	src := New()
+	src.Set("k", "value", 0)
+	var buf bytes.Buffer
+	src.Serialize(&buf)
+	data := buf.Bytes()
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(DumpMagic)+3] ^= 0xFF
+	truncated := data[:len(data)-2]
+
+	for name, in := range map[string][]byte{"corrupt": corrupt, "truncated": truncated, "garbage": []byte("nope")} {
+		dst := New()
+		dst.Set("keep", "v", 0)
+		if err := dst.Deserialize(bytes.NewReader(in)); err != ErrBadDump {
+			t.Fatalf("%s: expected ErrBadDump, got %v", name, err)
+		}
+		if dst.Exists("keep") != 1 {
+			t.Fatalf("%s: failed load must leave the dataset intact", name)
+		}
+	}
+}
+
+func TestRestoreIsNeverSeenHalfDone(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	s := New()
+	var entries []Entry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, Entry{Key: fmt.Sprint("key:", i), Type: TypeString, Str: "v"})
+	}
+	s.Restore(entries)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			s.Restore(entries)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if n := s.Size(); n != len(entries) {
+			t.Fatalf("expected %d keys throughout, saw %d", len(entries), n)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import "redis-from-scratch/internal/cluster"
+
+// SlotIndexer is implemented by engines that can count and list the keys in
+// a cluster hash slot, for CLUSTER COUNTKEYSINSLOT and GETKEYSINSLOT.
+type SlotIndexer interface {
+	CountKeysInSlot(slot int) int
+	KeysInSlot(slot, count int) []string
+}
+
+// slotIndex holds the keys in each hash slot, as Redis keeps them in
+// cluster mode. Maps are created on first use.
+type slotIndex [cluster.Slots]map[string]struct{}
+
+func (x *slotIndex) add(key string) {
+	slot := cluster.KeySlot(key)
+	if x[slot] == nil {
+		x[slot] = make(map[string]struct{})
+	}
+	x[slot][key] = struct{}{}
+}
+
+func (x *slotIndex) remove(key string) {
+	delete(x[cluster.KeySlot(key)], key)
+}
+
+// CountKeysInSlot returns the number of keys in the hash slot. With
+// Options.IndexSlots the count is kept up to date as keys are added and
+// removed; otherwise the keyspace is scanned. Keys that have expired but not
+// yet been removed are counted.
+func (s *Store) CountKeysInSlot(slot int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.slots != nil {
+		return len(s.slots[slot])
+	}
+	n := 0
+	for key := range s.data {
+		if cluster.KeySlot(key) == slot {
+			n++
+		}
+	}
+	return n
+}
+
+// KeysInSlot returns up to count keys in the hash slot, in no particular
+// order. Like CountKeysInSlot it scans the keyspace unless slots are indexed.
+func (s *Store) KeysInSlot(slot, count int) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []string
+	if s.slots != nil {
+		for key := range s.slots[slot] {
+			if len(keys) == count {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+	for key := range s.data {
+		if len(keys) == count {
+			break
+		}
+		if cluster.KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"testing"
+
+	"redis-from-scratch/internal/cluster"
+)
+
+func TestCountKeysInSlot(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		s := NewWithOptions(Options{IndexSlots: indexed})
+		slot := cluster.KeySlot("{user}")
+		s.Set("{user}:a", "1", 0)
+		s.Set("{user}:a", "2", 0) // overwriting does not count twice
+		s.HashSet("{user}:b", "f", "v")
+		s.ListRPush("{user}:c", "x")
+		s.Set("other", "1", 0)
+		if got := s.CountKeysInSlot(slot); got != 3 {
+			t.Fatalf("indexed=%v: expected 3 keys in slot %d, got %d", indexed, slot, got)
+		}
+
+		s.Delete("{user}:b")
+		s.ListLPop("{user}:c") // empties and removes the list
+		if got := s.CountKeysInSlot(slot); got != 1 {
+			t.Fatalf("indexed=%v: expected 1 key after deletes, got %d", indexed, got)
+		}
+
+		s.Flush()
+		if got := s.CountKeysInSlot(slot); got != 0 {
+			t.Fatalf("indexed=%v: expected no keys after flush, got %d", indexed, got)
+		}
+		s.Restore([]Entry{{Key: "{user}:d", Type: TypeString, Str: "v"}})
+		if got := s.CountKeysInSlot(slot); got != 1 {
+			t.Fatalf("indexed=%v: expected the restored key to be counted, got %d", indexed, got)
+		}
+	}
+}
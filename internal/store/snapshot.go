@@ -0,0 +1,198 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// ZMember is a sorted set member with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// Entry is a self-contained copy of one key and its value. It shares no
+// memory with the store and is safe to retain or hand to other goroutines.
+type Entry struct {
+	Key    string
+	Type   ValueType
+	Str    string
+	Hash   map[string]string
+	List   []string
+	Set    map[string]struct{}
+	ZSet   []ZMember // ordered by score, then member
+	Expiry *time.Time
+}
+
+// entryOf deep-copies v into an Entry.
+func entryOf(key string, v *Value) Entry {
+	e := Entry{Key: key, Type: v.Type}
+	if v.Expiry != nil {
+		exp := *v.Expiry
+		e.Expiry = &exp
+	}
+	switch v.Type {
+	case TypeString:
+		e.Str = v.stringOf()
+	case TypeHash:
+		e.Hash = make(map[string]string, len(v.Hash))
+		for f, val := range v.Hash {
+			e.Hash[f] = val
+		}
+	case TypeList:
+		e.List = append([]string(nil), v.List...)
+	case TypeSet:
+		e.Set = make(map[string]struct{}, len(v.Set))
+		for m := range v.Set {
+			e.Set[m] = struct{}{}
+		}
+	case TypeZSet:
+		e.ZSet = make([]ZMember, len(v.ZSet.entries))
+		for i, z := range v.ZSet.entries {
+			e.ZSet[i] = ZMember{Member: z.member, Score: z.score}
+		}
+	}
+	return e
+}
+
+// Snapshot is a consistent point-in-time view of the dataset that can be
+// iterated while writers continue. Instead of holding the lock (or forking)
+// for the whole walk, the snapshot records the key set at creation, and any
+// writer touching a key that the snapshot has not yet preserved first saves
+// a copy of its pre-image. Iteration then returns the preserved copy if
+// there is one, or the live value, which is unchanged since creation.
+//
+// A Snapshot must be closed when no longer needed; until then every first
+// write to a key pays for a copy of its old value.
+type Snapshot struct {
+	s       *Store
+	created time.Time
+	keys    []string
+	pos     int
+
+	// saved holds pre-images preserved by writers, guarded by s.mu. A nil
+	// value means the key did not exist when the snapshot was taken.
+	saved map[string]*Value
+}
+
+// BeginSnapshot registers and returns a new snapshot of the current dataset.
+// Keys already expired at this point are excluded.
+func (s *Store) BeginSnapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	snap := &Snapshot{
+		s:       s,
+		created: now,
+		keys:    make([]string, 0, len(s.data)),
+		saved:   make(map[string]*Value),
+	}
+	for k, v := range s.data {
+		if v.Expiry != nil && now.After(*v.Expiry) {
+			continue
+		}
+		snap.keys = append(snap.keys, k)
+	}
+	sort.Strings(snap.keys)
+
+	if s.snapshots == nil {
+		s.snapshots = make(map[*Snapshot]struct{})
+	}
+	s.snapshots[snap] = struct{}{}
+	return snap
+}
+
+// preserve saves the current value at key into every active snapshot that has
+// not captured it yet. Writers must call it, with the write lock held, before
+// mutating or replacing the value at key.
+func (s *Store) preserve(key string) {
+	if len(s.snapshots) == 0 {
+		return
+	}
+	for snap := range s.snapshots {
+		if _, done := snap.saved[key]; done {
+			continue
+		}
+		if v, ok := s.data[key]; ok {
+			snap.saved[key] = cloneValue(v)
+		} else {
+			snap.saved[key] = nil
+		}
+	}
+}
+
+// cloneValue returns a deep copy of v.
+func cloneValue(v *Value) *Value {
+	c := *v
+	switch v.Type {
+	case TypeHash:
+		c.Hash = make(map[string]string, len(v.Hash))
+		for f, val := range v.Hash {
+			c.Hash[f] = val
+		}
+	case TypeList:
+		c.List = append([]string(nil), v.List...)
+	case TypeSet:
+		c.Set = make(map[string]struct{}, len(v.Set))
+		for m := range v.Set {
+			c.Set[m] = struct{}{}
+		}
+	case TypeZSet:
+		c.ZSet = &SortedSet{
+			entries: append([]zEntry(nil), v.ZSet.entries...),
+			index:   make(map[string]float64, len(v.ZSet.index)),
+		}
+		for m, sc := range v.ZSet.index {
+			c.ZSet.index[m] = sc
+		}
+	}
+	return &c
+}
+
+// Created returns the time the snapshot was taken.
+func (snap *Snapshot) Created() time.Time {
+	return snap.created
+}
+
+// Len returns the number of keys in the snapshot.
+func (snap *Snapshot) Len() int {
+	return len(snap.keys)
+}
+
+// Next returns the next entry in key order, or false when the snapshot is
+// exhausted. Each call holds the store's read lock only while copying a
+// single value.
+func (snap *Snapshot) Next() (Entry, bool) {
+	s := snap.s
+	for snap.pos < len(snap.keys) {
+		key := snap.keys[snap.pos]
+		snap.pos++
+
+		s.mu.RLock()
+		v, preserved := snap.saved[key]
+		if !preserved {
+			v = s.data[key]
+		}
+		var e Entry
+		if v != nil {
+			e = entryOf(key, v)
+		}
+		s.mu.RUnlock()
+
+		if v != nil {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Close releases the snapshot so writers stop preserving values for it.
+func (snap *Snapshot) Close() {
+	s := snap.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.snapshots, snap)
+	snap.saved = nil
+	snap.keys = nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import "testing"
+
+func drain(snap *Snapshot) map[string]Entry {
+	out := make(map[string]Entry)
+	for {
+		e, ok := snap.Next()
+		if !ok {
+			return out
+		}
+		out[e.Key] = e
+	}
+}
+
+func TestSnapshotIsolatedFromWrites(t *testing.T) {
+	store := New()
+	store.Set("str", "old", 0)
+	store.HashSet("h", "f", "old")
+	store.ListRPush("l", "a")
+	store.ZAdd("z", 1, "m")
+	store.Set("gone", "v", 0)
+
+	snap := store.BeginSnapshot()
+	defer snap.Close()
+
+	store.Set("str", "new", 0)
+	store.HashSet("h", "f", "new")
+	store.HashSet("h", "g", "added")
+	store.ListRPush("l", "b")
+	store.ZAdd("z", 5, "m")
+	store.Delete("gone")
+	store.Set("created", "v", 0)
+
+	got := drain(snap)
+	if len(got) != 5 {
+		t.Fatalf("expected 5 entries, got %d: %v", len(got), got)
+	}
+	if got["str"].Str != "old" {
+		t.Fatalf("expected old string, got %s", got["str"].Str)
+	}
+	if len(got["h"].Hash) != 1 || got["h"].Hash["f"] != "old" {
+		t.Fatalf("unexpected hash in snapshot: %v", got["h"].Hash)
+	}
+	if len(got["l"].List) != 1 {
+		t.Fatalf("unexpected list in snapshot: %v", got["l"].List)
+	}
+	if got["z"].ZSet[0].Score != 1 {
+		t.Fatalf("unexpected zset score in snapshot: %v", got["z"].ZSet)
+	}
+	if _, ok := got["gone"]; !ok {
+		t.Fatalf("deleted key should still be visible in snapshot")
+	}
+	if _, ok := got["created"]; ok {
+		t.Fatalf("key created after snapshot should not be visible")
+	}
+
+	// The live store reflects the writes.
+	if v, _ := store.Get("str"); v != "new" {
+		t.Fatalf("expected live value new, got %s", v)
+	}
+}
+
+func TestSnapshotCloseStopsPreserving(t *testing.T) {
+	store := New()
+	store.Set("k", "v", 0)
+	snap := store.BeginSnapshot()
+	snap.Close()
+
+	store.Set("k", "v2", 0)
+	if len(store.snapshots) != 0 {
+		t.Fatalf("expected no active snapshots after close")
+	}
+	if _, ok := snap.Next(); ok {
+		t.Fatalf("closed snapshot should yield nothing")
+	}
+}
+
+func TestFlushKeepsSnapshotView(t *testing.T) {
+	store := New()
+	store.Set("a", "1", 0)
+	store.ListRPush("l", "x", "y")
+
+	snap := store.BeginSnapshot()
+	defer snap.Close()
+	store.Flush()
+
+	got := drain(snap)
+	if len(got) != 2 || got["a"].Str != "1" || len(got["l"].List) != 2 {
+		t.Fatalf("snapshot lost data after flush: %v", got)
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+// Stats summarizes the keyspace. Counts are maintained incrementally as keys
+// are added and removed, so reading them does not scan the dataset. Keys
+// that have expired but not yet been removed are still counted.
+type Stats struct {
+	Keys    int
+	Expires int // keys with a TTL
+	Strings int
+	Hashes  int
+	Lists   int
+	Sets    int
+	ZSets   int
+}
+
+// keyCounts is the incrementally maintained state behind Stats.
+type keyCounts struct {
+	byType  [TypeZSet + 1]int
+	expires int
+}
+
+// count adds delta (+1 or -1) for v to the keyspace counts. Must be called
+// with the write lock held.
+func (s *Store) count(v *Value, delta int) {
+	s.counts.byType[v.Type] += delta
+	if v.Expiry != nil {
+		s.counts.expires += delta
+	}
+}
+
+// Stats returns the current keyspace statistics.
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := s.counts
+	return Stats{
+		Keys:    len(s.data),
+		Expires: c.expires,
+		Strings: c.byType[TypeString],
+		Hashes:  c.byType[TypeHash],
+		Lists:   c.byType[TypeList],
+		Sets:    c.byType[TypeSet],
+		ZSets:   c.byType[TypeZSet],
+	}
+}
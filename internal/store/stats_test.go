@@ -0,0 +1,31 @@
+package store
+
+import "testing"
+
+func TestStatsTrackTypesAndExpires(t *testing.T) {
+	store := New()
+	store.Set("a", "1", 0)
+	store.Set("b", "2", 60000)
+	store.HashSet("h", "f", "v")
+	store.ListRPush("l", "x")
+	store.SetAdd("s", "m")
+	store.ZAdd("z", 1, "m")
+
+	want := Stats{Keys: 6, Expires: 1, Strings: 2, Hashes: 1, Lists: 1, Sets: 1, ZSets: 1}
+	if got := store.Stats(); got != want {
+		t.Fatalf("unexpected stats:\n got: %+v\nwant: %+v", got, want)
+	}
+
+	store.Set("b", "3", 0) // overwrite drops the TTL
+	store.ListLPop("l")    // empties and removes the list
+	store.Delete("h")
+	want = Stats{Keys: 4, Expires: 0, Strings: 2, Sets: 1, ZSets: 1}
+	if got := store.Stats(); got != want {
+		t.Fatalf("unexpected stats after writes:\n got: %+v\nwant: %+v", got, want)
+	}
+
+	store.Flush()
+	if got := store.Stats(); got != (Stats{}) {
+		t.Fatalf("expected zero stats after flush, got %+v", got)
+	}
+}
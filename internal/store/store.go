@@ -16,6 +16,11 @@ type Value struct {
 	// Str holds plain string values (SET/GET).
 	Str string
 
+	// Encoding records how a TypeString value is held. With EncodingInt the
+	// value lives in Int and Str is empty.
+	Encoding Encoding
+	Int      int64
+
 	// Hash, List, Set and ZSet are placeholders for future data types.
 	// Only one of these should be used depending on Type.
 	Hash map[string]string
@@ -24,6 +29,13 @@ type Value struct {
 	ZSet *SortedSet
 
 	Expiry *time.Time
+
+	// Bookkeeping maintained by the store: accounted size in bytes, the LRU
+	// clock at last access and the packed LFU counter. lru and lfu are
+	// updated atomically by readers holding only the read lock.
+	mem int64
+	lru uint32
+	lfu uint32
 }
 
 // ValueType represents the stored value's data type.
@@ -46,27 +58,100 @@ const (
 //   ZSet *SortedSet
 // Also add store methods for each type (HashSet/HashGet, ListPush, ZAdd, etc.)
 
+// Options controls optional store behaviour. The zero value matches the
+// behaviour of New.
+type Options struct {
+	// EncodeIntegers stores canonical integer strings as int64.
+	EncodeIntegers bool
+	// InternStrings deduplicates short string and hash values.
+	InternStrings bool
+	// InternMaxLen is the longest value considered for interning.
+	InternMaxLen int
+	// InternMaxEntries bounds the size of the intern table.
+	InternMaxEntries int
+
+	// MaxMemory is the accounted dataset size in bytes above which
+	// EvictIfNeeded starts evicting keys. Zero disables the limit.
+	MaxMemory int64
+	// EvictionPolicy selects the keys to evict; empty means noeviction.
+	EvictionPolicy EvictionPolicy
+	// EvictionSamples is the number of keys sampled per eviction.
+	EvictionSamples int
+	// LFULogFactor controls how quickly the LFU counter saturates.
+	LFULogFactor int
+	// LFUDecayTime is the number of idle minutes that decrement the LFU
+	// counter by one. Zero disables decay.
+	LFUDecayTime int
+
+	// MaxKeyLen, MaxValueSize and MaxCollectionLen bound key length, the size
+	// of any single value or element, and the number of entries in one
+	// collection. Zero disables a limit.
+	MaxKeyLen        int
+	MaxValueSize     int64
+	MaxCollectionLen int
+
+	// IndexSlots keeps an index of the keys in each cluster hash slot, so
+	// CountKeysInSlot does not scan the keyspace.
+	IndexSlots bool
+}
+
 type Store struct {
-	mu   sync.RWMutex
-	data map[string]Value
+	mu      sync.RWMutex
+	data    map[string]*Value
+	opts    Options
+	strings *interner
+	used    int64
+	evicted int64
+	counts  keyCounts
+	slots   *slotIndex
+
+	// snapshots holds the active point-in-time views; see preserve.
+	snapshots map[*Snapshot]struct{}
+
+	// hooks are the callbacks registered per Event; see emit.
+	hooks [numEvents][]func(key string)
+
+	// keys are the locks commands take on the keys they use; see
+	// LockKeys.
+	keys KeyLocks
 }
 
 func New() *Store {
-	return &Store{
-		data: make(map[string]Value),
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions creates a store configured with opts.
+func NewWithOptions(opts Options) *Store {
+	s := &Store{
+		data: make(map[string]*Value),
+		opts: opts,
 	}
+	if opts.InternStrings {
+		s.strings = newInterner(opts.InternMaxLen, opts.InternMaxEntries)
+	}
+	if opts.IndexSlots {
+		s.slots = new(slotIndex)
+	}
+	startLRUClock()
+	return s
+}
+
+// expired reports whether the value has a TTL that has already passed.
+func (v *Value) expired() bool {
+	return v.Expiry != nil && time.Now().After(*v.Expiry)
 }
 
 func (s *Store) Set(key, value string, expireMs int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	v := Value{Type: TypeString, Str: value}
+	v := s.encodeString(value)
 	if expireMs > 0 {
 		exp := time.Now().Add(time.Duration(expireMs) * time.Millisecond)
 		v.Expiry = &exp
 	}
-	s.data[key] = v
+	s.insert(key, &v)
+	s.emit(EventSet, key)
 }
 
 func (s *Store) Get(key string) (string, bool) {
@@ -87,7 +172,8 @@ func (s *Store) Get(key string) (string, bool) {
 		return "", false
 	}
 
-	return v.Str, true
+	s.touch(v)
+	return v.stringOf(), true
 }
 
 func (s *Store) Delete(keys ...string) int {
@@ -96,8 +182,7 @@ func (s *Store) Delete(keys ...string) int {
 
 	count := 0
 	for _, key := range keys {
-		if _, exists := s.data[key]; exists {
-			delete(s.data, key)
+		if s.remove(key) {
 			count++
 		}
 	}
@@ -134,7 +219,7 @@ func (s *Store) CleanupExpired() int {
 
 	for k, v := range s.data {
 		if v.Expiry != nil && now.After(*v.Expiry) {
-			delete(s.data, k)
+			s.expire(k)
 			count++
 		}
 	}
@@ -147,22 +232,63 @@ func (s *Store) Size() int {
 	return len(s.data)
 }
 
+// Flush removes every key. The old keyspace is only unlinked, so the lock is
+// held briefly however large it was; the garbage collector reclaims it
+// concurrently.
+func (s *Store) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+}
+
+// flush is Flush with s.mu held.
+func (s *Store) flush() {
+	old := s.data
+	s.data = make(map[string]*Value)
+	s.used = 0
+	s.strings.reset()
+	s.counts = keyCounts{}
+	if s.slots != nil {
+		s.slots = new(slotIndex)
+	}
+	// The old values are no longer mutated, so active snapshots can keep
+	// them as their pre-images instead of copying.
+	for snap := range s.snapshots {
+		for k, v := range old {
+			if _, done := snap.saved[k]; !done {
+				snap.saved[k] = v
+			}
+		}
+	}
+}
+
 // HashSet sets the field in the hash stored at key. Returns 1 if field is new, 0 if updated.
 // Returns an error if the key exists and is not a hash.
 func (s *Store) HashSet(key, field, value string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if ok && v.Type != TypeHash {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
+	if !ok || !hasField(v.Hash, field) {
+		if err := s.checkCollection(v, ok, 1); err != nil {
+			return 0, err
+		}
+	}
 	if !ok {
-		v = Value{Type: TypeHash, Hash: make(map[string]string)}
+		v = &Value{Type: TypeHash, Hash: make(map[string]string)}
+		s.insert(key, v)
+	}
+	old, existed := v.Hash[field]
+	v.Hash[field] = s.intern(value)
+	if existed {
+		s.grow(key, v, int64(len(value)-len(old)))
+	} else {
+		s.grow(key, v, int64(fieldOverhead+len(field)+len(value)))
 	}
-	_, existed := v.Hash[field]
-	v.Hash[field] = value
-	s.data[key] = v
 	if existed {
 		return 0, nil
 	}
@@ -191,6 +317,7 @@ func (s *Store) HashGet(key, field string) (string, bool, error) {
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
 		return "", false, nil
 	}
+	s.touch(v)
 	return val, true, nil
 }
 
@@ -199,6 +326,7 @@ func (s *Store) HashGet(key, field string) (string, bool, error) {
 func (s *Store) HashDel(key string, fields ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if !ok {
@@ -208,17 +336,19 @@ func (s *Store) HashDel(key string, fields ...string) (int, error) {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
 	count := 0
+	var freed int64
 	for _, f := range fields {
-		if _, exists := v.Hash[f]; exists {
+		if val, exists := v.Hash[f]; exists {
 			delete(v.Hash, f)
+			freed += int64(fieldOverhead + len(f) + len(val))
 			count++
 		}
 	}
 	// If hash becomes empty, you could delete the key entirely
 	if len(v.Hash) == 0 {
-		delete(s.data, key)
-	} else {
-		s.data[key] = v
+		s.remove(key)
+	} else if count > 0 {
+		s.grow(key, v, -freed)
 	}
 	return count, nil
 }
@@ -235,6 +365,7 @@ func (s *Store) HashGetAll(key string) (map[string]string, error) {
 	if v.Type != TypeHash {
 		return nil, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
+	s.touch(v)
 	// Copy to avoid exposing internal map
 	out := make(map[string]string, len(v.Hash))
 	for k, val := range v.Hash {
@@ -248,26 +379,31 @@ func (s *Store) HashGetAll(key string) (map[string]string, error) {
 func (s *Store) ListLPush(key string, values ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if ok {
 		// If expired, treat as not exist
 		if v.Expiry != nil && time.Now().After(*v.Expiry) {
-			delete(s.data, key)
+			s.expire(key)
 			ok = false
 		}
 	}
 	if ok && v.Type != TypeList {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
+	if err := s.checkCollection(v, ok, len(values)); err != nil {
+		return 0, err
+	}
 	if !ok {
-		v = Value{Type: TypeList, List: make([]string, 0)}
+		v = &Value{Type: TypeList, List: make([]string, 0)}
+		s.insert(key, v)
 	}
 	// Prepend values in order: LPUSH a b c -> pushes a then b then c => list becomes c b a
 	for i := 0; i < len(values); i++ {
 		v.List = append([]string{values[i]}, v.List...)
 	}
-	s.data[key] = v
+	s.grow(key, v, listSize(values))
 	return len(v.List), nil
 }
 
@@ -275,22 +411,27 @@ func (s *Store) ListLPush(key string, values ...string) (int, error) {
 func (s *Store) ListRPush(key string, values ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if ok {
 		if v.Expiry != nil && time.Now().After(*v.Expiry) {
-			delete(s.data, key)
+			s.expire(key)
 			ok = false
 		}
 	}
 	if ok && v.Type != TypeList {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
+	if err := s.checkCollection(v, ok, len(values)); err != nil {
+		return 0, err
+	}
 	if !ok {
-		v = Value{Type: TypeList, List: make([]string, 0)}
+		v = &Value{Type: TypeList, List: make([]string, 0)}
+		s.insert(key, v)
 	}
 	v.List = append(v.List, values...)
-	s.data[key] = v
+	s.grow(key, v, listSize(values))
 	return len(v.List), nil
 }
 
@@ -299,6 +440,7 @@ func (s *Store) ListRPush(key string, values ...string) (int, error) {
 func (s *Store) ListLPop(key string) (string, bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if !ok {
@@ -308,7 +450,7 @@ func (s *Store) ListLPop(key string) (string, bool, error) {
 		return "", false, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
-		delete(s.data, key)
+		s.expire(key)
 		return "", false, nil
 	}
 	if len(v.List) == 0 {
@@ -317,9 +459,9 @@ func (s *Store) ListLPop(key string) (string, bool, error) {
 	val := v.List[0]
 	v.List = v.List[1:]
 	if len(v.List) == 0 {
-		delete(s.data, key)
+		s.remove(key)
 	} else {
-		s.data[key] = v
+		s.grow(key, v, -int64(elementOverhead+len(val)))
 	}
 	return val, true, nil
 }
@@ -328,6 +470,7 @@ func (s *Store) ListLPop(key string) (string, bool, error) {
 func (s *Store) ListRPop(key string) (string, bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if !ok {
@@ -337,7 +480,7 @@ func (s *Store) ListRPop(key string) (string, bool, error) {
 		return "", false, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
-		delete(s.data, key)
+		s.expire(key)
 		return "", false, nil
 	}
 	if len(v.List) == 0 {
@@ -346,9 +489,9 @@ func (s *Store) ListRPop(key string) (string, bool, error) {
 	last := v.List[len(v.List)-1]
 	v.List = v.List[:len(v.List)-1]
 	if len(v.List) == 0 {
-		delete(s.data, key)
+		s.remove(key)
 	} else {
-		s.data[key] = v
+		s.grow(key, v, -int64(elementOverhead+len(last)))
 	}
 	return last, true, nil
 }
@@ -369,6 +512,7 @@ func (s *Store) ListRange(key string, start, stop int) ([]string, error) {
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
 		return []string{}, nil
 	}
+	s.touch(v)
 	ln := len(v.List)
 	if ln == 0 {
 		return []string{}, nil
@@ -398,28 +542,39 @@ func (s *Store) ListRange(key string, start, stop int) ([]string, error) {
 func (s *Store) SetAdd(key string, members ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if ok {
 		if v.Expiry != nil && time.Now().After(*v.Expiry) {
-			delete(s.data, key)
+			s.expire(key)
 			ok = false
 		}
 	}
 	if ok && v.Type != TypeSet {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
+	if s.opts.MaxCollectionLen > 0 {
+		if err := s.checkCollection(v, ok, newMembers(v, ok, members)); err != nil {
+			return 0, err
+		}
+	}
 	if !ok {
-		v = Value{Type: TypeSet, Set: make(map[string]struct{})}
+		v = &Value{Type: TypeSet, Set: make(map[string]struct{})}
+		s.insert(key, v)
 	}
 	added := 0
+	var delta int64
 	for _, m := range members {
 		if _, exists := v.Set[m]; !exists {
 			v.Set[m] = struct{}{}
+			delta += int64(elementOverhead + len(m))
 			added++
 		}
 	}
-	s.data[key] = v
+	if added > 0 {
+		s.grow(key, v, delta)
+	}
 	return added, nil
 }
 
@@ -439,6 +594,7 @@ func (s *Store) SetMembers(key string) ([]string, error) {
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
 		return []string{}, nil
 	}
+	s.touch(v)
 	out := make([]string, 0, len(v.Set))
 	for m := range v.Set {
 		out = append(out, m)
@@ -451,6 +607,7 @@ func (s *Store) SetMembers(key string) ([]string, error) {
 func (s *Store) SetRemove(key string, members ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if !ok {
@@ -460,16 +617,18 @@ func (s *Store) SetRemove(key string, members ...string) (int, error) {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
 	removed := 0
+	var freed int64
 	for _, m := range members {
 		if _, exists := v.Set[m]; exists {
 			delete(v.Set, m)
+			freed += int64(elementOverhead + len(m))
 			removed++
 		}
 	}
 	if len(v.Set) == 0 {
-		delete(s.data, key)
-	} else {
-		s.data[key] = v
+		s.remove(key)
+	} else if removed > 0 {
+		s.grow(key, v, -freed)
 	}
 	return removed, nil
 }
@@ -490,6 +649,7 @@ func (s *Store) SetIsMember(key, member string) (bool, error) {
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
 		return false, nil
 	}
+	s.touch(v)
 	_, exists := v.Set[member]
 	return exists, nil
 }
@@ -575,19 +735,26 @@ func (ss *SortedSet) getRange(start, stop int) []string {
 func (s *Store) ZAdd(key string, score float64, member string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 
 	v, ok := s.data[key]
 	if ok {
 		if v.Expiry != nil && time.Now().After(*v.Expiry) {
-			delete(s.data, key)
+			s.expire(key)
 			ok = false
 		}
 	}
 	if ok && v.Type != TypeZSet {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
+	if !ok || !hasMember(v.ZSet, member) {
+		if err := s.checkCollection(v, ok, 1); err != nil {
+			return 0, err
+		}
+	}
 	if !ok {
-		v = Value{Type: TypeZSet, ZSet: newSortedSet()}
+		v = &Value{Type: TypeZSet, ZSet: newSortedSet()}
+		s.insert(key, v)
 	}
 	ss := v.ZSet
 	if old, exists := ss.index[member]; exists {
@@ -595,9 +762,12 @@ func (s *Store) ZAdd(key string, score float64, member string) (int, error) {
 			return 0, nil
 		}
 		ss.removeMember(member)
+		ss.insertEntry(zEntry{member: member, score: score})
+		s.grow(key, v, 0)
+		return 1, nil
 	}
 	ss.insertEntry(zEntry{member: member, score: score})
-	s.data[key] = v
+	s.grow(key, v, int64(zsetOverhead+len(member)))
 	return 1, nil
 }
 
@@ -615,6 +785,7 @@ func (s *Store) ZScore(key, member string) (float64, bool, error) {
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
 		return 0, false, nil
 	}
+	s.touch(v)
 	sc, exists := v.ZSet.index[member]
 	return sc, exists, nil
 }
@@ -633,6 +804,7 @@ func (s *Store) ZRange(key string, start, stop int) ([]string, error) {
 	if v.Expiry != nil && time.Now().After(*v.Expiry) {
 		return []string{}, nil
 	}
+	s.touch(v)
 	return v.ZSet.getRange(start, stop), nil
 }
 
@@ -640,6 +812,7 @@ func (s *Store) ZRange(key string, start, stop int) ([]string, error) {
 func (s *Store) ZRem(key string, members ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.preserve(key)
 	v, ok := s.data[key]
 	if !ok {
 		return 0, nil
@@ -648,15 +821,17 @@ func (s *Store) ZRem(key string, members ...string) (int, error) {
 		return 0, fmt.Errorf("WRONGTYPE operation against a key holding the wrong kind of value")
 	}
 	removed := 0
+	var freed int64
 	for _, m := range members {
 		if v.ZSet.removeMember(m) {
+			freed += int64(zsetOverhead + len(m))
 			removed++
 		}
 	}
 	if len(v.ZSet.entries) == 0 {
-		delete(s.data, key)
-	} else {
-		s.data[key] = v
+		s.remove(key)
+	} else if removed > 0 {
+		s.grow(key, v, -freed)
 	}
 	return removed, nil
 }
@@ -2,6 +2,7 @@
 package store
 
 import (
+	"fmt"
 	"testing"
 	"time"
 )
@@ -60,3 +61,36 @@ func TestExists(t *testing.T) {
 		t.Errorf("Expected 1 existing key, got %d", count)
 	}
 }
+
+func TestSetTTL(t *testing.T) {
+	store := New()
+	store.Set("explicit", "v", 5000)
+	if _, ttl, _ := store.GetWithTTL("explicit"); ttl <= 4*time.Second || ttl > 5*time.Second {
+		t.Fatalf("expected the TTL set, got %v", ttl)
+	}
+
+	if _, ttl, _ := New().GetWithTTL("missing"); ttl != 0 {
+		t.Fatalf("unexpected ttl for missing key: %v", ttl)
+	}
+	plain := New()
+	plain.Set("k", "v", 0)
+	if _, ttl, _ := plain.GetWithTTL("k"); ttl != NoExpiry {
+		t.Fatalf("expected no expiry without a default TTL, got %v", ttl)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	store := New()
+	for i := 0; i < 50; i++ {
+		store.Set(fmt.Sprintf("k%d", i), "v", 0)
+	}
+	store.Flush()
+	if store.Size() != 0 || store.UsedMemory() != 0 {
+		t.Fatalf("expected empty store after flush, size=%d used=%d", store.Size(), store.UsedMemory())
+	}
+
+	store.Set("k", "v", 0)
+	if v, ok := store.Get("k"); !ok || v != "v" {
+		t.Fatalf("store unusable after flush")
+	}
+}
@@ -0,0 +1,169 @@
+// Package tracking implements the invalidation table behind client-side
+// caching: the keys each tracking client read, or the key prefixes it
+// follows in broadcasting mode, and the clients to tell when keys change.
+package tracking
+
+import (
+	"strings"
+	"sync"
+)
+
+// Client is a connection with tracking on. Invalidate is told the keys it
+// read, or that match its prefixes, that changed, or nil when the whole
+// dataset was flushed. It is called with the table's lock held, so it must
+// not block or call back into the table.
+type Client interface {
+	Invalidate(keys []string)
+}
+
+// Options are CLIENT TRACKING's.
+type Options struct {
+	// BCast follows every key starting with one of Prefixes, or every key
+	// without any, instead of the keys the client reads.
+	BCast    bool
+	Prefixes []string
+	// NoLoop leaves out the keys the client changes itself.
+	NoLoop bool
+}
+
+// client is a tracking client's options and the keys it read since they
+// were last invalidated.
+type client struct {
+	opts Options
+	keys map[string]bool
+}
+
+// Table holds the tracking clients and the keys they read. The zero value is
+// not ready to use; call New.
+type Table struct {
+	mu      sync.Mutex
+	clients map[Client]*client
+	keys    map[string]map[Client]struct{}
+}
+
+// New creates a table without tracking clients.
+func New() *Table {
+	return &Table{
+		clients: make(map[Client]*client),
+		keys:    make(map[string]map[Client]struct{}),
+	}
+}
+
+// Enable turns tracking on for c with opts, forgetting the keys it read
+// before if it was on already.
+func (t *Table) Enable(c Client, opts Options) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forget(c)
+	t.clients[c] = &client{opts: opts, keys: make(map[string]bool)}
+}
+
+// Disable turns tracking off for c.
+func (t *Table) Disable(c Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forget(c)
+	delete(t.clients, c)
+}
+
+func (t *Table) forget(c Client) {
+	st := t.clients[c]
+	if st == nil {
+		return
+	}
+	for key := range st.keys {
+		delete(t.keys[key], c)
+		if len(t.keys[key]) == 0 {
+			delete(t.keys, key)
+		}
+	}
+}
+
+// Clients returns the number of clients with tracking on.
+func (t *Table) Clients() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.clients)
+}
+
+// Track records that c read keys, for it to be told when they change, once.
+// It does nothing if c is not tracking or is broadcasting.
+func (t *Table) Track(c Client, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.clients[c]
+	if st == nil || st.opts.BCast {
+		return
+	}
+	for _, key := range keys {
+		clients := t.keys[key]
+		if clients == nil {
+			clients = make(map[Client]struct{})
+			t.keys[key] = clients
+		}
+		clients[c] = struct{}{}
+		st.keys[key] = true
+	}
+}
+
+// Invalidate tells the clients that read keys, or broadcast a prefix of one
+// of them, that they changed, in one call per client. origin is the client
+// that changed them, if any, left out if it asked for NoLoop.
+func (t *Table) Invalidate(origin Client, keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.clients) == 0 {
+		return
+	}
+	pending := make(map[Client][]string)
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		for c := range t.keys[key] {
+			pending[c] = append(pending[c], key)
+			delete(t.clients[c].keys, key)
+		}
+		delete(t.keys, key)
+		for c, st := range t.clients {
+			if st.opts.BCast && hasPrefix(key, st.opts.Prefixes) {
+				pending[c] = append(pending[c], key)
+			}
+		}
+	}
+	for c, keys := range pending {
+		if c != origin || !t.clients[c].opts.NoLoop {
+			c.Invalidate(keys)
+		}
+	}
+}
+
+// Flush tells every tracking client the whole dataset changed.
+func (t *Table) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c, st := range t.clients {
+		st.keys = make(map[string]bool)
+		c.Invalidate(nil)
+	}
+	t.keys = make(map[string]map[Client]struct{})
+}
+
+// hasPrefix reports whether key starts with one of prefixes, or prefixes is
+// empty.
+func hasPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
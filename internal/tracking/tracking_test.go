@@ -0,0 +1,75 @@
+package tracking
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type recorder struct {
+	calls [][]string
+}
+
+func (r *recorder) Invalidate(keys []string) {
+	sort.Strings(keys)
+	r.calls = append(r.calls, keys)
+}
+
+func TestTrack(t *testing.T) {
+	tb := New()
+	a, b, off := &recorder{}, &recorder{}, &recorder{}
+	tb.Enable(a, Options{})
+	tb.Enable(b, Options{NoLoop: true})
+	tb.Track(a, []string{"x", "y"})
+	tb.Track(b, []string{"x"})
+	tb.Track(off, []string{"x"})
+
+	tb.Invalidate(b, []string{"x", "x", "z"})
+	if want := [][]string{{"x"}}; !reflect.DeepEqual(a.calls, want) {
+		t.Fatalf("expected %v, got %v", want, a.calls)
+	}
+	if len(b.calls) != 0 || len(off.calls) != 0 {
+		t.Fatalf("expected NOLOOP and untracked clients to be left out, got %v and %v", b.calls, off.calls)
+	}
+
+	// A key is invalidated once until it is read again.
+	tb.Invalidate(nil, []string{"x"})
+	if len(a.calls) != 1 {
+		t.Fatalf("expected x to be forgotten, got %v", a.calls)
+	}
+	tb.Invalidate(nil, []string{"y"})
+	if len(a.calls) != 2 {
+		t.Fatalf("expected y to be invalidated, got %v", a.calls)
+	}
+
+	tb.Track(a, []string{"w"})
+	tb.Disable(a)
+	tb.Invalidate(nil, []string{"w"})
+	if len(a.calls) != 2 || len(tb.keys) != 0 {
+		t.Fatalf("expected a disabled client to be forgotten, got %v and %v", a.calls, tb.keys)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	tb := New()
+	a, all := &recorder{}, &recorder{}
+	tb.Enable(a, Options{BCast: true, Prefixes: []string{"user:", "cart:"}})
+	tb.Enable(all, Options{BCast: true})
+	tb.Track(a, []string{"user:1"})
+	if len(tb.keys) != 0 {
+		t.Fatalf("expected broadcasting clients not to track reads")
+	}
+
+	tb.Invalidate(nil, []string{"user:1", "cart:2", "other"})
+	if want := [][]string{{"cart:2", "user:1"}}; !reflect.DeepEqual(a.calls, want) {
+		t.Fatalf("expected %v, got %v", want, a.calls)
+	}
+	if want := [][]string{{"cart:2", "other", "user:1"}}; !reflect.DeepEqual(all.calls, want) {
+		t.Fatalf("expected %v, got %v", want, all.calls)
+	}
+
+	tb.Flush()
+	if a.calls[1] != nil || all.calls[1] != nil {
+		t.Fatalf("expected a flush to invalidate everything, got %v and %v", a.calls, all.calls)
+	}
+}
@@ -0,0 +1,90 @@
+// Package version describes the running build, for --version and INFO
+// server. Release builds set Version, Commit and Date at link time:
+//
+//	go build -ldflags "-X redis-from-scratch/internal/version.Version=v1.4.0 \
+//	  -X redis-from-scratch/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X redis-from-scratch/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+//
+// Left unset, they fall back to what the Go toolchain records of the module
+// and of the git checkout it was built from.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Redis is the Redis version the server is compatible with and reports to
+// clients, which check it for the features they may use.
+const Redis = "7.2.0"
+
+// Set at link time with -X.
+var (
+	Version string // the release, like v1.4.0
+	Commit  string // the git commit, with a -dirty suffix for uncommitted changes
+	Date    string // when it was built, in RFC 3339
+)
+
+// Info is the build of the running binary.
+type Info struct {
+	Version string // "dev" if unknown
+	Commit  string // the full git commit, empty if unknown
+	Dirty   bool   // built with uncommitted changes
+	Date    string // the build's time or, unset, the commit's; empty if unknown
+	Go      string // the Go release it was built with
+}
+
+// Get returns the build of the running binary.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, Go: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		if info.Commit == "" {
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					info.Commit = s.Value
+				case "vcs.modified":
+					info.Dirty = s.Value == "true"
+				case "vcs.time":
+					if info.Date == "" {
+						info.Date = s.Value
+					}
+				}
+			}
+		}
+	}
+	if c, ok := strings.CutSuffix(info.Commit, "-dirty"); ok {
+		info.Commit, info.Dirty = c, true
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// ShortCommit is the commit's first 8 digits, or 8 zeros if unknown, as
+// Redis reports its redis_git_sha1.
+func (i Info) ShortCommit() string {
+	if i.Commit == "" {
+		return "00000000"
+	}
+	return i.Commit[:min(8, len(i.Commit))]
+}
+
+// String describes the build on one line, as Redis' --version does.
+func (i Info) String() string {
+	dirty := 0
+	if i.Dirty {
+		dirty = 1
+	}
+	s := fmt.Sprintf("redis-from-scratch %s (Redis %s) sha=%s:%d go=%s", i.Version, Redis, i.ShortCommit(), dirty, i.Go)
+	if i.Date != "" {
+		s += " built=" + i.Date
+	}
+	return s
+}
@@ -0,0 +1,24 @@
+package version
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+
+	Version, Commit, Date = "", "", ""
+	if info := Get(); info.Version == "" || info.Go == "" || len(info.ShortCommit()) != 8 {
+		t.Fatalf("expected a version and Go release without link-time values, got %+v", info)
+	}
+
+	Version, Commit, Date = "v1.4.0", "0123456789abcdef-dirty", "2026-10-15T09:00:00Z"
+	info := Get()
+	if info.Version != "v1.4.0" || info.Commit != "0123456789abcdef" || !info.Dirty || info.Date != Date {
+		t.Fatalf("expected the link-time values, got %+v", info)
+	}
+	if s := info.String(); !strings.HasPrefix(s, "redis-from-scratch v1.4.0 (Redis "+Redis+") sha=01234567:1 go=") || !strings.HasSuffix(s, " built="+Date) {
+		t.Fatalf("unexpected version line %q", s)
+	}
+}
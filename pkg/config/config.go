@@ -7,34 +7,147 @@ import (
 )
 
 type Config struct {
-	Port              int           `json:"port"`
-	MaxConnections    int           `json:"max_connections"`
-	CleanupInterval   time.Duration `json:"cleanup_interval"`
-	ReadTimeout       time.Duration `json:"read_timeout"`
-	WriteTimeout      time.Duration `json:"write_timeout"`
-	MaxRequestSize    int64         `json:"max_request_size"`
-	EnablePersistence bool          `json:"enable_persistence"`
-	PersistencePath   string        `json:"persistence_path"`
+	Port               int           `json:"port"`
+	MaxConnections     int           `json:"max_connections"`
+	CleanupInterval    time.Duration `json:"cleanup_interval"`
+	ReadTimeout        time.Duration `json:"read_timeout"`       // to read a command once it starts arriving
+	WriteTimeout       time.Duration `json:"write_timeout"`      // to write a reply
+	IdleTimeout        time.Duration `json:"idle_timeout"`       // between commands; 0 never closes idle connections
+	MaxRequestSize     int64         `json:"max_request_size"`   // of a command's arguments together; 0 for no limit
+	ProtoMaxBulkLen    int64         `json:"proto_max_bulk_len"` // of each argument
+	Acceptors          int           `json:"acceptors"`          // listeners sharing each port with SO_REUSEPORT; 0 or 1 for one
+	EventLoop          bool          `json:"event_loop"`         // park idle connections in epoll or kqueue
+	Workers            int           `json:"workers"`            // run keyspace commands on this many goroutines; 0 on each connection's
+	EnablePersistence  bool          `json:"enable_persistence"`
+	PersistencePath    string        `json:"persistence_path"`
+	SnapshotFile       string        `json:"snapshot_file"`
+	Save               string        `json:"save"`
+	StopWritesOnError  bool          `json:"stop_writes_on_bgsave_error"`
+	AppendFsync        string        `json:"appendfsync"`
+	AOFLoadTruncated   bool          `json:"aof_load_truncated"`
+	AOFAbortOnError    bool          `json:"aof_abort_on_error"`
+	AOFPreamble        bool          `json:"aof_use_snapshot_preamble"`
+	AOFKeepHistory     bool          `json:"aof_keep_history"`
+	ReplayUntil        time.Time     `json:"replay_until"`
+	Compression        string        `json:"persistence_compression"`
+	EncryptionKey      string        `json:"encryption_key"`
+	AutoAOFRewritePct  int           `json:"auto_aof_rewrite_percentage"`
+	AutoAOFRewriteMin  int64         `json:"auto_aof_rewrite_min_size"`
+	EncodeIntegers     bool          `json:"encode_integers"`
+	InternStrings      bool          `json:"intern_strings"`
+	MaxMemory          int64         `json:"max_memory"`
+	MaxMemoryPolicy    string        `json:"max_memory_policy"`
+	MaxMemorySamples   int           `json:"max_memory_samples"`
+	MaxMemoryClients   int64         `json:"maxmemory_clients"` // of every connection's buffers together; 0 for no limit
+	GOGC               int           `json:"gogc"`              // as the GOGC environment variable; 0 keeps it, negative turns the collector off
+	GOMemLimit         int64         `json:"gomemlimit"`        // as GOMEMLIMIT, in bytes; 0 keeps it
+	LFULogFactor       int           `json:"lfu_log_factor"`
+	LFUDecayTime       int           `json:"lfu_decay_time"`
+	StorageBackend     string        `json:"storage_backend"`
+	MaxKeyLength       int           `json:"max_key_length"`
+	MaxValueSize       int64         `json:"max_value_size"`
+	MaxCollectionLen   int           `json:"max_collection_entries"`
+	DefaultTTL         time.Duration `json:"default_ttl"`
+	DefaultTTLJitter   time.Duration `json:"default_ttl_jitter"`
+	ReplicaOf          string        `json:"replicaof"`
+	ReplBacklogSize    int64         `json:"repl_backlog_size"`
+	ReplDisklessSync   bool          `json:"repl_diskless_sync"`
+	ReplicaReadOnly    bool          `json:"replica_read_only"`
+	ReadOnly           bool          `json:"read_only"` // refuse every write command from clients
+	MinReplicas        int           `json:"min_replicas_to_write"`
+	MinReplicasMaxLag  int           `json:"min_replicas_max_lag"`
+	ClusterEnabled     bool          `json:"cluster_enabled"`
+	ClusterConfigFile  string        `json:"cluster_config_file"`
+	ClusterAnnounceIP  string        `json:"cluster_announce_ip"`
+	ClusterNodeTimeout time.Duration `json:"cluster_node_timeout"`
+	TLSPort            int           `json:"tls_port"`
+	TLSCertFile        string        `json:"tls_cert_file"`
+	TLSKeyFile         string        `json:"tls_key_file"`
+	TLSCACertFile      string        `json:"tls_ca_cert_file"`
+	TLSAuthClients     string        `json:"tls_auth_clients"` // no, yes or optional
+	TLSReplication     bool          `json:"tls_replication"`
+	TLSCluster         bool          `json:"tls_cluster"`
+	RequirePass        string        `json:"requirepass"`
+	MasterAuth         string        `json:"masterauth"`
+	ACLFile            string        `json:"aclfile"`
+	LatencyThreshold   int           `json:"latency_monitor_threshold"` // milliseconds; 0 disables
+	LogLevel           string        `json:"loglevel"`                  // debug, verbose, notice or warning (or info, warn, error); debug logs every command
+	LogFile            string        `json:"logfile"`                   // empty for standard error
+	LogFormat          string        `json:"log_format"`                // text or json
+	LogMaxSize         int64         `json:"log_max_size"`              // rotate logfile past this size; 0 never
+	LogMaxAge          time.Duration `json:"log_max_age"`               // rotate logfile past this age; 0 never
+	LogMaxBackups      int           `json:"log_max_backups"`           // rotated files kept; 0 keeps all
+	ShutdownTimeout    time.Duration `json:"shutdown_timeout"`          // grace period for commands in flight on Stop
+	PidFile            string        `json:"pidfile"`                   // file the process id is written to; empty for none
+	Supervised         string        `json:"supervised"`                // no, systemd or auto: notify systemd when ready and stopping
+	AuditLog           string        `json:"audit_log"`                 // file recording clients' write and admin commands; empty for none
+	SlowlogSlowerThan  int           `json:"slowlog_log_slower_than"`   // microseconds a command takes to be in SLOWLOG; negative for none
+	SlowlogMaxLen      int           `json:"slowlog_max_len"`           // entries SLOWLOG keeps in memory
+	SlowlogFile        string        `json:"slowlog_file"`              // file recording every SLOWLOG entry as a JSON line; empty for none
+	DebugAddr          string        `json:"debug_addr"`                // host:port of the pprof and expvar HTTP listener; empty for none
+	// RenameCommand maps commands to the names clients must call them by,
+	// or to "" to disable them.
+	RenameCommand map[string]string `json:"rename_command"`
+	// AllowCommands, if set, are the only commands clients may run, and
+	// DenyCommands commands they may not: names, patterns like FLUSH* or
+	// ACL categories like @dangerous.
+	AllowCommands []string `json:"allow_commands"`
+	DenyCommands  []string `json:"deny_commands"`
+	// ClientOutputBufferLimit is Redis' client-output-buffer-limit: for
+	// each class of client, normal, replica or pubsub, the hard limit on
+	// its pending output, the soft limit and the seconds it may stay over
+	// it, as in "pubsub 32mb 8mb 60".
+	ClientOutputBufferLimit string `json:"client_output_buffer_limit"`
+
+	// File is the file the configuration was loaded from, which CONFIG
+	// REWRITE writes back to.
+	File string `json:"-"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Port:              6379,
-		MaxConnections:    1000,
-		CleanupInterval:   time.Second,
-		ReadTimeout:       30 * time.Second,
-		WriteTimeout:      30 * time.Second,
-		MaxRequestSize:    512 * 1024 * 1024, // 512MB
-		EnablePersistence: false,
-		PersistencePath:   "./data",
+		Port:               6379,
+		MaxConnections:     1000,
+		CleanupInterval:    time.Second,
+		ReadTimeout:        30 * time.Second,
+		WriteTimeout:       30 * time.Second,
+		MaxRequestSize:     512 * 1024 * 1024, // 512MB
+		ProtoMaxBulkLen:    512 * 1024 * 1024, // 512MB
+		EnablePersistence:  false,
+		PersistencePath:    "./data",
+		SnapshotFile:       "dump.snapshot",
+		StopWritesOnError:  true,
+		AppendFsync:        "everysec",
+		AOFLoadTruncated:   true,
+		AOFAbortOnError:    true,
+		AOFPreamble:        true,
+		AutoAOFRewritePct:  100,
+		AutoAOFRewriteMin:  64 * 1024 * 1024, // 64MB
+		MaxMemoryPolicy:    "noeviction",
+		MaxMemorySamples:   5,
+		LFULogFactor:       10,
+		LFUDecayTime:       1,
+		StorageBackend:     "memory",
+		MaxValueSize:       512 * 1024 * 1024, // 512MB
+		ReplBacklogSize:    1024 * 1024,       // 1MB
+		ReplicaReadOnly:    true,
+		MinReplicasMaxLag:  10,
+		ClusterConfigFile:  "nodes.conf",
+		ClusterNodeTimeout: 15 * time.Second,
+		ShutdownTimeout:    10 * time.Second,
+		SlowlogSlowerThan:  10000,
+		SlowlogMaxLen:      128,
+		LogLevel:           "notice",
+
+		// 256mb 64mb 60 for replicas and 32mb 8mb 60 for Pub/Sub
+		// clients, in bytes as CONFIG GET reports them.
+		ClientOutputBufferLimit: "normal 0 0 0 replica 268435456 67108864 60 pubsub 33554432 8388608 60",
 	}
 }
 
-// TODO: The config file includes persistence and timeout fields but the server
-// does not currently use persistence or per-connection timeouts. Consider adding
-// persistence implementation and applying ReadTimeout/WriteTimeout on connections
-// (conn.SetReadDeadline / conn.SetWriteDeadline) in `handleConnection`.
-
+// LoadFromFile loads the configuration at path over the defaults, from JSON
+// or from a redis.conf-style file of "directive arg ..." lines; see
+// parseRedisConf. A configuration Validate rejects is an error.
 func LoadFromFile(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -42,9 +155,18 @@ func LoadFromFile(path string) (*Config, error) {
 	}
 
 	cfg := DefaultConfig()
-	if err := json.Unmarshal(data, cfg); err != nil {
+	if isJSON(data) {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = parseRedisConf(data, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	cfg.File = path
 
 	return cfg, nil
 }
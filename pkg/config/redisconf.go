@@ -0,0 +1,462 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisNames are the redis.conf directives of the fields whose name there
+// is Redis' rather than their JSON name with dashes.
+var redisNames = map[string]string{
+	"max_connections":    "maxclients",
+	"max_memory":         "maxmemory",
+	"max_memory_policy":  "maxmemory-policy",
+	"max_memory_samples": "maxmemory-samples",
+	"persistence_path":   "dir",
+	"snapshot_file":      "dbfilename",
+}
+
+// multiArg are the directives whose value is several arguments, written
+// unquoted: save's rules, replicaof's host and port, the command lists and
+// the output buffer limits.
+var multiArg = map[string]bool{
+	"save":                       true,
+	"replicaof":                  true,
+	"allow-commands":             true,
+	"deny-commands":              true,
+	"client-output-buffer-limit": true,
+}
+
+// cumulative are the directives several lines of which add up.
+var cumulative = map[string]bool{"save": true, "client-output-buffer-limit": true}
+
+// rewriteMarker heads the directives CONFIG REWRITE appends, as in Redis.
+const rewriteMarker = "# Generated by CONFIG REWRITE"
+
+// directive is a Config field as redis.conf names it.
+type directive struct {
+	index int    // of the field in Config
+	json  string // its JSON name
+}
+
+// directives maps the redis.conf directives to Config's fields.
+var directives = func() map[string]directive {
+	m := make(map[string]directive)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		conf, ok := redisNames[name]
+		if !ok {
+			conf = strings.ReplaceAll(name, "_", "-")
+		}
+		m[conf] = directive{index: i, json: name}
+	}
+	return m
+}()
+
+// isJSON reports whether a config file's content is JSON rather than
+// redis.conf.
+func isJSON(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// parseRedisConf applies the directives of a redis.conf file to cfg: one
+// per line, "name arg ...", with '#' comments and arguments quoted as
+// Redis does. Several save or client-output-buffer-limit lines add up, and
+// `save ""` clears the rules; each "rename-command name new-name" line adds
+// to RenameCommand.
+func parseRedisConf(data []byte, cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", i+1, err)
+		}
+		name := strings.ToLower(args[0])
+		if name == "rename-command" {
+			if len(args) != 3 {
+				return fmt.Errorf("line %d: bad directive or wrong number of arguments: %s", i+1, args[0])
+			}
+			if cfg.RenameCommand == nil {
+				cfg.RenameCommand = make(map[string]string)
+			}
+			cfg.RenameCommand[args[1]] = args[2]
+			continue
+		}
+		d, ok := directives[name]
+		if !ok || len(args) < 2 || len(args) > 2 && !multiArg[name] {
+			return fmt.Errorf("line %d: bad directive or wrong number of arguments: %s", i+1, args[0])
+		}
+		value := strings.Join(args[1:], " ")
+		if prev := v.Field(d.index).String(); cumulative[name] && value != "" && prev != "" {
+			value = prev + " " + value
+		}
+		if err := setField(v.Field(d.index), value); err != nil {
+			return fmt.Errorf("line %d: %s: %v", i+1, args[0], err)
+		}
+	}
+	return nil
+}
+
+// setField parses value into a Config field: space-separated words for
+// lists, yes or no for booleans,
+// sizes with Redis' units (1k, 5mb, 2gb) for 64-bit integers, Go durations
+// or bare milliseconds for durations, and RFC 3339 for times.
+func setField(f reflect.Value, value string) error {
+	switch f.Interface().(type) {
+	case string:
+		f.SetString(value)
+	case []string:
+		f.Set(reflect.ValueOf(strings.Fields(value)))
+	case bool:
+		switch strings.ToLower(value) {
+		case "yes":
+			f.SetBool(true)
+		case "no":
+			f.SetBool(false)
+		default:
+			return fmt.Errorf("argument must be 'yes' or 'no'")
+		}
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("argument must be an integer")
+		}
+		f.SetInt(int64(n))
+	case int64:
+		n, err := ParseSize(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case time.Duration:
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			f.SetInt(int64(time.Duration(ms) * time.Millisecond))
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("argument must be a duration")
+		}
+		f.SetInt(int64(d))
+	case time.Time:
+		var t time.Time
+		if value != "" {
+			var err error
+			if t, err = time.Parse(time.RFC3339, value); err != nil {
+				return fmt.Errorf("argument must be an RFC 3339 time")
+			}
+		}
+		f.Set(reflect.ValueOf(t))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
+
+// formatField renders a Config field as setField parses it.
+func formatField(f reflect.Value) string {
+	switch v := f.Interface().(type) {
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case []string:
+		return strings.Join(v, " ")
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(f.Interface())
+}
+
+// ParseSize parses a byte count with an optional unit, as in redis.conf:
+// k, m and g are powers of 1000, kb, mb and gb of 1024.
+func ParseSize(s string) (int64, error) {
+	lower := strings.ToLower(s)
+	mul := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mul    int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1e3}, {"m", 1e6}, {"g", 1e9}} {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mul = strings.TrimSuffix(lower, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// splitArgs splits a redis.conf line into its arguments, which may be
+// quoted: in double quotes with backslash escapes, including \xHH, or in
+// single quotes with only \' escaped. A closing quote must end the
+// argument.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg strings.Builder
+		var quote byte
+		if line[i] == '"' || line[i] == '\'' {
+			quote = line[i]
+			i++
+		}
+	arg:
+		for {
+			if i == len(line) {
+				if quote != 0 {
+					return nil, fmt.Errorf("unbalanced quotes")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case quote == 0 && isSpace(c):
+				break arg
+			case quote != 0 && c == quote:
+				i++
+				if i < len(line) && !isSpace(line[i]) {
+					return nil, fmt.Errorf("closing quote must be followed by a space")
+				}
+				break arg
+			case quote == '"' && c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+				b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+				arg.WriteByte(byte(b))
+				i += 4
+			case quote == '"' && c == '\\' && i+1 < len(line):
+				arg.WriteByte(unescape(line[i+1]))
+				i += 2
+			case quote == '\'' && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				arg.WriteByte('\'')
+				i += 2
+			default:
+				arg.WriteByte(c)
+				i++
+			}
+		}
+		args = append(args, arg.String())
+	}
+}
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\r' }
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// unescape is the byte a backslash and c stand for in double quotes.
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
+
+// quoteArg quotes a redis.conf argument when it is empty or holds spaces,
+// quotes or other characters that need escaping.
+func quoteArg(s string) string {
+	plain := s != ""
+	for i := 0; i < len(s) && plain; i++ {
+		c := s[i]
+		plain = c > ' ' && c <= '~' && c != '"' && c != '\'' && c != '\\'
+	}
+	if plain {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Rewrite writes values, keyed by redis.conf directive, to the config file
+// at path, in its format, for CONFIG REWRITE. In a redis.conf file each
+// directive's first line is replaced and any others removed, comments and
+// every other line kept; a JSON file has its fields set. A directive
+// missing from the file is added only if its value is not the default. The
+// file is replaced atomically.
+func Rewrite(path string, values map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	defaults := reflect.ValueOf(DefaultConfig()).Elem()
+	for name := range values {
+		if _, ok := directives[name]; !ok {
+			return fmt.Errorf("unknown directive '%s'", name)
+		}
+	}
+	if isJSON(data) {
+		data, err = rewriteJSON(data, values, defaults)
+	} else {
+		data = rewriteRedisConf(data, values, defaults)
+	}
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, data)
+}
+
+func rewriteRedisConf(data []byte, values map[string]string, defaults reflect.Value) []byte {
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	written := make(map[string]bool)
+	hasMarker := false
+	var out []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		hasMarker = hasMarker || trimmed == rewriteMarker
+		args, err := splitArgs(trimmed)
+		if err != nil || len(args) == 0 || strings.HasPrefix(trimmed, "#") {
+			out = append(out, line)
+			continue
+		}
+		name := strings.ToLower(args[0])
+		value, ok := values[name]
+		switch {
+		case !ok:
+			out = append(out, line)
+		case !written[name]:
+			out = append(out, confLine(name, value))
+			written[name] = true
+		}
+	}
+	var added []string
+	for name, value := range values {
+		if !written[name] && value != formatField(defaults.Field(directives[name].index)) {
+			added = append(added, confLine(name, value))
+		}
+	}
+	if len(added) > 0 {
+		sort.Strings(added)
+		if !hasMarker {
+			out = append(out, rewriteMarker)
+		}
+		out = append(out, added...)
+	}
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+// confLine is a redis.conf line setting name to value.
+func confLine(name, value string) string {
+	if multiArg[name] && value != "" {
+		return name + " " + value
+	}
+	return name + " " + quoteArg(value)
+}
+
+func rewriteJSON(data []byte, values map[string]string, defaults reflect.Value) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	scratch := reflect.ValueOf(DefaultConfig()).Elem()
+	for name, value := range values {
+		d := directives[name]
+		if _, ok := fields[d.json]; !ok && value == formatField(defaults.Field(d.index)) {
+			continue
+		}
+		f := scratch.Field(d.index)
+		if err := setField(f, value); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		raw, err := json.Marshal(f.Interface())
+		if err != nil {
+			return nil, err
+		}
+		fields[d.json] = raw
+	}
+	out, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// writeAtomic replaces the file at path with data, keeping its mode, so a
+// crash leaves either the old file or the new one.
+func writeAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
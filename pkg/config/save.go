@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SavePoint triggers a background save once Changes writes have been made
+// and Seconds seconds have passed since the last successful save.
+type SavePoint struct {
+	Seconds int
+	Changes int64
+}
+
+// ParseSavePoints parses save rules in Redis' "seconds changes [seconds
+// changes ...]" form. An empty string disables automatic saves.
+func ParseSavePoints(s string) ([]SavePoint, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save rules '%s': expected pairs of seconds and changes", s)
+	}
+	var points []SavePoint
+	for i := 0; i < len(fields); i += 2 {
+		secs, err := strconv.Atoi(fields[i])
+		if err != nil || secs < 1 {
+			return nil, fmt.Errorf("invalid save rules '%s': bad seconds '%s'", s, fields[i])
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 1 {
+			return nil, fmt.Errorf("invalid save rules '%s': bad changes '%s'", s, fields[i+1])
+		}
+		points = append(points, SavePoint{Seconds: secs, Changes: changes})
+	}
+	return points, nil
+}
+
+// FormatSavePoints renders save rules the way CONFIG GET save reports them.
+func FormatSavePoints(points []SavePoint) string {
+	parts := make([]string, 0, 2*len(points))
+	for _, p := range points {
+		parts = append(parts, strconv.Itoa(p.Seconds), strconv.FormatInt(p.Changes, 10))
+	}
+	return strings.Join(parts, " ")
+}
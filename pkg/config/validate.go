@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-from-scratch/internal/persistence"
+)
+
+// minMaxMemory is the smallest max_memory accepted: as Redis warns, less
+// than 1mb leaves no room for anything but a handful of small keys.
+const minMaxMemory = 1 << 20
+
+// Validate reports the settings of c that cannot work, all of them at once,
+// each naming the setting and the values it takes, so that the server
+// refuses to start with them rather than failing later, or quietly falling
+// back to a default. LoadFromFile calls it.
+func (c *Config) Validate() error {
+	v := validator{}
+	v.check(c.Port >= 0 && c.Port <= 65535, "port", "must be 0 to 65535, got %d", c.Port)
+	v.check(c.TLSPort >= 0 && c.TLSPort <= 65535, "tls_port", "must be 0 to 65535, got %d", c.TLSPort)
+	v.check(c.Port != 0 || c.TLSPort != 0, "port", "must be 1 to 65535 unless tls_port is set, got 0")
+	v.check(c.Port == 0 || c.Port != c.TLSPort, "tls_port", "must differ from port, both %d", c.Port)
+	if c.TLSPort != 0 {
+		v.check(c.TLSCertFile != "" && c.TLSKeyFile != "", "tls_port", "needs tls_cert_file and tls_key_file")
+	}
+	v.oneOf("tls_auth_clients", c.TLSAuthClients, "", "no", "yes", "optional")
+	atLeast(&v, "max_connections", c.MaxConnections, 1)
+	atLeast(&v, "acceptors", c.Acceptors, 0)
+	atLeast(&v, "workers", c.Workers, 0)
+
+	atLeast(&v, "cleanup_interval", c.CleanupInterval, time.Millisecond)
+	atLeast(&v, "read_timeout", c.ReadTimeout, 0)
+	atLeast(&v, "write_timeout", c.WriteTimeout, 0)
+	atLeast(&v, "idle_timeout", c.IdleTimeout, 0)
+	atLeast(&v, "shutdown_timeout", c.ShutdownTimeout, 0)
+	atLeast(&v, "max_request_size", c.MaxRequestSize, 0)
+	atLeast(&v, "proto_max_bulk_len", c.ProtoMaxBulkLen, 1)
+
+	if c.EnablePersistence || c.StorageBackend == "bolt" {
+		v.check(c.PersistencePath != "", "persistence_path (dir)", "must be set with enable_persistence or storage_backend bolt")
+	}
+	switch c.StorageBackend {
+	case "", "memory", "bolt":
+	default:
+		v.check(false, "storage_backend", "must be memory or bolt, got %q", c.StorageBackend)
+	}
+	v.oneOf("appendfsync", c.AppendFsync, "", "always", "everysec", "no")
+	if _, err := ParseSavePoints(c.Save); err != nil {
+		v.check(false, "save", "must be pairs of seconds and changes, both at least 1, or empty to disable saves, got %q", c.Save)
+	}
+	_, err := persistence.ParseCompression(c.Compression)
+	v.check(err == nil, "persistence_compression", "must be none, gzip or lz4, got %q", c.Compression)
+	atLeast(&v, "auto_aof_rewrite_percentage", c.AutoAOFRewritePct, 0)
+	atLeast(&v, "auto_aof_rewrite_min_size", c.AutoAOFRewriteMin, 0)
+
+	if c.MaxMemory != 0 {
+		v.check(c.MaxMemory >= minMaxMemory, "max_memory (maxmemory)", "must be 0 for no limit or at least 1mb, got %d", c.MaxMemory)
+	}
+	if c.MaxMemory >= minMaxMemory && c.MaxValueSize != 0 {
+		v.check(c.MaxValueSize <= c.MaxMemory, "max_value_size", "must not exceed max_memory (%d), as a value that large could never be stored, got %d", c.MaxMemory, c.MaxValueSize)
+	}
+	v.oneOf("max_memory_policy (maxmemory-policy)", c.MaxMemoryPolicy, "", "noeviction", "allkeys-random", "allkeys-lru",
+		"allkeys-lfu", "volatile-random", "volatile-lru", "volatile-lfu", "volatile-ttl")
+	atLeast(&v, "max_memory_samples (maxmemory-samples)", c.MaxMemorySamples, 1)
+	atLeast(&v, "maxmemory_clients", c.MaxMemoryClients, 0)
+	atLeast(&v, "lfu_log_factor", c.LFULogFactor, 0)
+	atLeast(&v, "lfu_decay_time", c.LFUDecayTime, 0)
+	atLeast(&v, "max_key_length", c.MaxKeyLength, 0)
+	atLeast(&v, "max_value_size", c.MaxValueSize, 0)
+	atLeast(&v, "max_collection_entries", c.MaxCollectionLen, 0)
+	atLeast(&v, "default_ttl", c.DefaultTTL, 0)
+	atLeast(&v, "default_ttl_jitter", c.DefaultTTLJitter, 0)
+	atLeast(&v, "gomemlimit", c.GOMemLimit, 0)
+
+	atLeast(&v, "repl_backlog_size", c.ReplBacklogSize, 0)
+	atLeast(&v, "min_replicas_to_write", c.MinReplicas, 0)
+	atLeast(&v, "min_replicas_max_lag", c.MinReplicasMaxLag, 0)
+	if c.ReplicaOf != "" {
+		host, port, ok := strings.Cut(c.ReplicaOf, " ")
+		n, err := strconv.Atoi(port)
+		v.check(ok && host != "" && err == nil && n > 0 && n <= 65535, "replicaof", "must be \"<host> <port>\" with a port of 1 to 65535, got %q", c.ReplicaOf)
+	}
+	if c.ClusterEnabled {
+		atLeast(&v, "cluster_node_timeout", c.ClusterNodeTimeout, time.Millisecond)
+		v.check(c.ClusterConfigFile != "", "cluster_config_file", "must be set with cluster_enabled")
+	}
+
+	v.oneOf("loglevel", c.LogLevel, "", "debug", "verbose", "notice", "info", "warning", "warn", "error")
+	v.oneOf("log_format", c.LogFormat, "", "text", "json")
+	atLeast(&v, "log_max_size", c.LogMaxSize, 0)
+	atLeast(&v, "log_max_age", c.LogMaxAge, 0)
+	atLeast(&v, "log_max_backups", c.LogMaxBackups, 0)
+	atLeast(&v, "latency_monitor_threshold", c.LatencyThreshold, 0)
+	atLeast(&v, "slowlog_max_len", c.SlowlogMaxLen, 0)
+	v.oneOf("supervised", c.Supervised, "", "no", "systemd", "auto")
+	return errors.Join(v.errs...)
+}
+
+// validator gathers Validate's errors.
+type validator struct {
+	errs []error
+}
+
+// check records that setting is wrong, as format says, unless ok.
+func (v *validator) check(ok bool, setting, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s %s", setting, fmt.Sprintf(format, args...)))
+	}
+}
+
+// atLeast checks that value is min or more.
+func atLeast[T int | int64 | time.Duration](v *validator, setting string, value, least T) {
+	v.check(value >= least, setting, "must be at least %v, got %v", least, value)
+}
+
+// oneOf checks that value is one of allowed, regardless of case. An empty
+// allowed value stands for the default, left out of the error.
+func (v *validator) oneOf(setting, value string, allowed ...string) {
+	var names []string
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return
+		}
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	v.check(false, setting, "must be %s or %s, got %q", strings.Join(names[:len(names)-1], ", "), names[len(names)-1], value)
+}